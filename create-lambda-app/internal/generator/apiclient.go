@@ -0,0 +1,370 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/leeguooooo/create-lambda-app/internal/templates"
+)
+
+// Client languages of "generate client"
+const (
+	ClientGo         = "go"
+	ClientTypeScript = "typescript"
+)
+
+// Where "generate client" writes each client, relative to the project
+const (
+	GoClientDir         = "pkg/client"
+	TypeScriptClientDir = "clients/typescript"
+)
+
+// ClientGenOptions configures "generate client"
+type ClientGenOptions struct {
+	ProjectPath string
+	SpecPath    string   // defaults to SpecFile in the project
+	Languages   []string // defaults to every client language
+}
+
+// ClientGenResult lists what GenerateClient changed, relative to the
+// project
+type ClientGenResult struct {
+	Config  *Config
+	Spec    *APISpec
+	Written []string // rewritten on every run
+	Created []string // package files created once, then left to the project
+}
+
+// GenerateClient generates typed clients of a project's API from its
+// OpenAPI spec: a Go package other services can import and a TypeScript
+// package for frontends. Both are rewritten on every run.
+func GenerateClient(opts ClientGenOptions) (*ClientGenResult, error) {
+	config, err := LoadProject(opts.ProjectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	specPath, specFile := opts.SpecPath, SpecFile
+	if specPath == "" {
+		if !config.HasFeature("api") {
+			return nil, fmt.Errorf("the project was created without the api feature; pass the spec with --spec")
+		}
+		specPath = filepath.Join(opts.ProjectPath, SpecFile)
+	} else {
+		specFile = filepath.Base(specPath)
+	}
+	spec, err := LoadAPISpec(specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	languages := opts.Languages
+	if len(languages) == 0 {
+		languages = []string{ClientGo, ClientTypeScript}
+	}
+	for _, language := range languages {
+		if language != ClientGo && language != ClientTypeScript {
+			return nil, fmt.Errorf("unknown client language %q (use %s or %s)", language, ClientGo, ClientTypeScript)
+		}
+	}
+
+	result := &ClientGenResult{Config: config, Spec: spec}
+	for _, language := range languages {
+		data := &apiGenData{
+			Config:   config,
+			Command:  "generate client",
+			Spec:     spec,
+			SpecFile: specFile,
+		}
+
+		if language == ClientGo {
+			err = generateGoClient(opts.ProjectPath, data, result)
+		} else {
+			err = generateTypeScriptClient(opts.ProjectPath, data, result)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func generateGoClient(projectPath string, data *apiGenData, result *ClientGenResult) error {
+	outputPath := filepath.Join(projectPath, GoClientDir)
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", GoClientDir, err)
+	}
+
+	data.Package = packageName(GoClientDir)
+	generated := []struct{ name, content string }{
+		{"types.gen.go", templates.APIGenTypes},
+		{"client.gen.go", templates.APIClientGo},
+		{"client.gen_test.go", templates.APIClientGoTest},
+	}
+	for _, file := range generated {
+		if err := writeGoFile(filepath.Join(outputPath, file.name), file.content, data); err != nil {
+			return err
+		}
+		result.Written = append(result.Written, filepath.Join(GoClientDir, file.name))
+	}
+
+	// Ginkgo runs the tests' specs from a suite the project owns
+	suitePath := filepath.Join(outputPath, "suite_test.go")
+	if _, err := os.Stat(suitePath); data.TestingFramework != "ginkgo" || err == nil {
+		return nil
+	}
+	suite := fmt.Sprintf(templates.GinkgoSuite, data.Package, strings.ToUpper(data.Package[:1])+data.Package[1:])
+	if err := writeGoFile(suitePath, suite, data); err != nil {
+		return err
+	}
+	result.Created = append(result.Created, filepath.Join(GoClientDir, "suite_test.go"))
+	return nil
+}
+
+// tsClientNames are the declarations src/index.ts makes besides the spec's
+// types, which a schema or operation input of the spec must not reuse
+var tsClientNames = []string{"ErrorType", "ProblemDocument", "APIError", "isAPIError", "RetryPolicy", "defaultRetryPolicy", "TokenSource", "ClientOptions", "RequestOptions", "PendingRequest"}
+
+func generateTypeScriptClient(projectPath string, data *apiGenData, result *ClientGenResult) error {
+	if err := checkTypeScriptNames(data); err != nil {
+		return err
+	}
+
+	outputPath := filepath.Join(projectPath, TypeScriptClientDir)
+	if err := os.MkdirAll(filepath.Join(outputPath, "src"), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", TypeScriptClientDir, err)
+	}
+
+	files := []struct {
+		name, content string
+		keep          bool // the project owns the file once it exists
+	}{
+		{"src/index.ts", templates.APIClientTypeScript, false},
+		{"package.json", templates.APIClientPackageJSON, true},
+		{"tsconfig.json", templates.APIClientTSConfig, true},
+	}
+	for _, file := range files {
+		path := filepath.Join(outputPath, file.name)
+		if _, err := os.Stat(path); file.keep && err == nil {
+			continue
+		}
+
+		content, err := renderTemplate(path, file.content, data)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", path, err)
+		}
+
+		name := filepath.Join(TypeScriptClientDir, file.name)
+		if file.keep {
+			result.Created = append(result.Created, name)
+		} else {
+			result.Written = append(result.Written, name)
+		}
+	}
+	return nil
+}
+
+// checkTypeScriptNames rejects spec types that would be declared twice in
+// src/index.ts, which TypeScript merges or rejects instead of shadowing
+func checkTypeScriptNames(data *apiGenData) error {
+	reserved := map[string]bool{data.ClientName(): true}
+	for _, name := range tsClientNames {
+		reserved[name] = true
+	}
+
+	var names []string
+	for _, t := range data.Spec.Types {
+		names = append(names, t.Name)
+	}
+	for _, operation := range data.Spec.Operations {
+		if operation.Input != "" {
+			names = append(names, operation.Input)
+		}
+	}
+	for _, name := range names {
+		if reserved[name] {
+			return fmt.Errorf("the spec declares %s, which the TypeScript client declares itself; rename it in the spec", name)
+		}
+	}
+	return nil
+}
+
+// ClientSignature returns the signature of the operation's Go client
+// method. Non-JSON responses are returned as bytes.
+func (o *APIOperation) ClientSignature() string {
+	params := "ctx context.Context"
+	if o.Input != "" {
+		params += ", input *" + o.Input
+	}
+	if o.Output == "" {
+		return fmt.Sprintf("%s(%s) error", o.Name, params)
+	}
+	result := o.Output
+	if o.RawOutput {
+		result = "[]byte"
+	}
+	return fmt.Sprintf("%s(%s) (%s, error)", o.Name, params, result)
+}
+
+// ClientResult is the type the Go client decodes the response into
+func (o *APIOperation) ClientResult() string {
+	if o.RawOutput {
+		return "[]byte"
+	}
+	return strings.TrimPrefix(o.Output, "*")
+}
+
+// ClientPointer reports whether the Go client returns a pointer to the
+// decoded response
+func (o *APIOperation) ClientPointer() bool {
+	return !o.RawOutput && strings.HasPrefix(o.Output, "*")
+}
+
+// ClientZero is what the Go client returns besides an error
+func (o *APIOperation) ClientZero() string {
+	if o.RawOutput || o.ClientPointer() {
+		return "nil"
+	}
+	return o.zero
+}
+
+// TSMethod returns the name of the operation's TypeScript client method
+func (o *APIOperation) TSMethod() string {
+	return strings.ToLower(o.Name[:1]) + o.Name[1:]
+}
+
+// TSOutput returns what the TypeScript client method resolves to
+func (o *APIOperation) TSOutput() string {
+	switch {
+	case o.Output == "":
+		return "void"
+	case o.RawOutput:
+		return "Blob"
+	default:
+		return tsType(o.Output)
+	}
+}
+
+// TSInputOptional reports whether every parameter and the body of the
+// operation are optional, so the TypeScript input can be omitted
+func (o *APIOperation) TSInputOptional() bool {
+	for _, param := range o.Params {
+		if param.Required {
+			return false
+		}
+	}
+	return o.Body == nil || !o.Body.Required
+}
+
+// TSProperty returns the parameter's property in the TypeScript input
+func (p *APIParam) TSProperty() string {
+	return tsProperty(p.Key, !p.Required)
+}
+
+// TSAccess returns the expression reading the parameter from the
+// TypeScript input
+func (p *APIParam) TSAccess() string {
+	if tsIdentifierPattern.MatchString(p.Key) {
+		return "input." + p.Key
+	}
+	return "input[" + tsString(p.Key) + "]"
+}
+
+// TSType returns the parameter's TypeScript type
+func (p *APIParam) TSType() string {
+	return tsType(p.Type)
+}
+
+// TSType returns the body's TypeScript type
+func (b *APIBody) TSType() string {
+	return tsType(b.Type)
+}
+
+// TSExtends returns the extends clause of the type's TypeScript interface
+func (t *APIType) TSExtends() string {
+	if len(t.Embeds) == 0 {
+		return ""
+	}
+	return " extends " + strings.Join(t.Embeds, ", ")
+}
+
+// TSType returns the TypeScript type of an enum or alias
+func (t *APIType) TSType() string {
+	if t.Kind != "enum" {
+		return tsType(t.Base)
+	}
+	values := make([]string, len(t.Values))
+	for i, value := range t.Values {
+		values[i] = value.Value
+		if s, err := strconv.Unquote(value.Value); err == nil {
+			values[i] = tsString(s)
+		}
+	}
+	return strings.Join(values, " | ")
+}
+
+// TSProperty returns the field's property in its TypeScript interface
+func (f *APIField) TSProperty() string {
+	return tsProperty(f.Key, f.Optional)
+}
+
+// TSType returns the field's TypeScript type
+func (f *APIField) TSType() string {
+	if f.Nullable {
+		return tsType(f.Type) + " | null"
+	}
+	return tsType(f.Type)
+}
+
+var tsIdentifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsProperty(key string, optional bool) string {
+	if !tsIdentifierPattern.MatchString(key) {
+		key = tsString(key)
+	}
+	if optional {
+		key += "?"
+	}
+	return key
+}
+
+func tsString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", `\n`).Replace(s) + "'"
+}
+
+// tsType maps a Go type of the generated code to TypeScript
+func tsType(goType string) string {
+	switch {
+	case strings.HasPrefix(goType, "*"):
+		return tsType(goType[1:])
+	case strings.HasPrefix(goType, "[]"):
+		element := tsType(goType[2:])
+		if strings.Contains(element, " ") {
+			element = "(" + element + ")"
+		}
+		return element + "[]"
+	case strings.HasPrefix(goType, "map[string]"):
+		return "Record<string, " + tsType(strings.TrimPrefix(goType, "map[string]")) + ">"
+	}
+
+	switch goType {
+	case "string", "time.Time":
+		return "string"
+	case "int", "int32", "int64", "float32", "float64":
+		return "number"
+	case "bool":
+		return "boolean"
+	case "interface{}", "json.RawMessage":
+		return "unknown"
+	default:
+		return goType
+	}
+}
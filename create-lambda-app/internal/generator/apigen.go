@@ -0,0 +1,479 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/leeguooooo/create-lambda-app/internal/templates"
+)
+
+// SpecFile is where a project keeps its OpenAPI spec; pkg/openapi embeds it
+const SpecFile = "docs/openapi.yaml"
+
+// APIGenOptions configures "generate api"
+type APIGenOptions struct {
+	ProjectPath string
+	SpecPath    string // defaults to SpecFile in the project
+	OutputDir   string // relative to the project; defaults per architecture
+}
+
+// APIGenResult lists what GenerateAPI changed, relative to the project
+type APIGenResult struct {
+	Config     *Config
+	Spec       *APISpec
+	OutputDir  string
+	Written    []string           // rewritten on every run
+	Stubs      []string           // created for operations without a handler
+	Removed    []RemovedOperation // handlers left over from earlier runs
+	RoutesFile string             // IaC file whose routes were updated
+	Notes      []string
+}
+
+// RemovedOperation is a handler whose operation is gone from the spec; it
+// refers to generated types that no longer exist, so it must be deleted
+type RemovedOperation struct {
+	Name string
+	File string
+}
+
+// apiGenData is what the generate api and generate client templates are
+// executed with
+type apiGenData struct {
+	*Config
+	Command   string // e.g. "generate api", for the generated file header
+	Spec      *APISpec
+	Package   string
+	SpecFile  string
+	Operation *APIOperation
+}
+
+// OperationsDir returns where generate api writes for an architecture
+func OperationsDir(architecture string) string {
+	switch architecture {
+	case "clean":
+		return "internal/interfaces/operations"
+	case "ddd":
+		return "interfaces/operations"
+	default:
+		return "operations"
+	}
+}
+
+// GenerateAPI generates the types, router and handler stubs of an OpenAPI
+// spec into an existing project and updates its IaC routes. Generated
+// files are rewritten; handlers for operations that already have one are
+// left alone.
+func GenerateAPI(opts APIGenOptions) (*APIGenResult, error) {
+	config, err := LoadProject(opts.ProjectPath)
+	if err != nil {
+		return nil, err
+	}
+	if !config.HasFeature("api") {
+		return nil, fmt.Errorf("the project was created without the api feature")
+	}
+
+	specPath := opts.SpecPath
+	if specPath == "" {
+		specPath = filepath.Join(opts.ProjectPath, SpecFile)
+	}
+	spec, err := LoadAPISpec(specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = OperationsDir(config.Architecture)
+	}
+	outputPath := filepath.Join(opts.ProjectPath, outputDir)
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", outputDir, err)
+	}
+
+	result := &APIGenResult{Config: config, Spec: spec, OutputDir: outputDir}
+	data := &apiGenData{
+		Config:   config,
+		Command:  "generate api",
+		Spec:     spec,
+		Package:  packageName(outputDir),
+		SpecFile: SpecFile,
+	}
+
+	// The embedded spec validates requests, so it must be the one the
+	// code is generated from
+	target := filepath.Join(opts.ProjectPath, SpecFile)
+	if current, err := os.ReadFile(target); err != nil || !bytes.Equal(current, spec.Source) {
+		if err := os.WriteFile(target, spec.Source, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", SpecFile, err)
+		}
+		result.Written = append(result.Written, SpecFile)
+	}
+
+	previous, err := declaredOperations(filepath.Join(outputPath, "router.gen.go"))
+	if err != nil {
+		return nil, err
+	}
+	implemented, err := handlerMethods(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	generated := []struct{ name, content string }{
+		{"types.gen.go", templates.APIGenTypes},
+		{"router.gen.go", templates.APIGenRouter},
+	}
+	for _, file := range generated {
+		if err := writeGoFile(filepath.Join(outputPath, file.name), file.content, data); err != nil {
+			return nil, err
+		}
+		result.Written = append(result.Written, filepath.Join(outputDir, file.name))
+	}
+
+	handlersPath := filepath.Join(outputPath, "handlers.go")
+	if _, err := os.Stat(handlersPath); os.IsNotExist(err) {
+		if err := writeGoFile(handlersPath, templates.APIGenHandlers, data); err != nil {
+			return nil, err
+		}
+		result.Stubs = append(result.Stubs, filepath.Join(outputDir, "handlers.go"))
+	}
+
+	for _, op := range spec.Operations {
+		if _, ok := implemented[op.Name]; ok {
+			continue
+		}
+		name := snakeCase(op.Name) + ".go"
+		path := filepath.Join(outputPath, name)
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s exists but does not define Handlers.%s", filepath.Join(outputDir, name), op.Name)
+		}
+
+		stub := *data
+		stub.Operation = op
+		if err := writeGoFile(path, templates.APIGenStub, &stub); err != nil {
+			return nil, err
+		}
+		result.Stubs = append(result.Stubs, filepath.Join(outputDir, name))
+	}
+
+	for _, name := range previous {
+		if file, ok := implemented[name]; ok && spec.Operation(name) == nil {
+			result.Removed = append(result.Removed, RemovedOperation{Name: name, File: filepath.Join(outputDir, file)})
+		}
+	}
+
+	if err := updateRoutes(opts.ProjectPath, config, spec, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// writeGoFile executes a template and writes the gofmt'ed result
+func writeGoFile(path, content string, data *apiGenData) error {
+	generated, err := renderTemplate(path, content, data)
+	if err != nil {
+		return err
+	}
+
+	source, err := format.Source(generated)
+	if err != nil {
+		return fmt.Errorf("generated %s is not valid Go: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, source, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", path, err)
+	}
+	return nil
+}
+
+func renderTemplate(path, content string, data *apiGenData) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(path)).Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template for %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute template for %s: %w", path, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// declaredOperations returns the methods of the Operations interface in a
+// previously generated router, so removed operations can be reported
+func declaredOperations(path string) ([]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var names []string
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.TypeSpec)
+		if !ok || spec.Name.Name != "Operations" {
+			return true
+		}
+		if iface, ok := spec.Type.(*ast.InterfaceType); ok {
+			for _, method := range iface.Methods.List {
+				for _, name := range method.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+		return false
+	})
+	return names, nil
+}
+
+// handlerMethods returns the methods defined on Handlers outside the
+// generated files, mapped to the name of the file defining them
+func handlerMethods(dir string) (map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	methods := make(map[string]string)
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".gen.go") || strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Recv != nil && len(fn.Recv.List) == 1 && receiverType(fn.Recv.List[0].Type) == "Handlers" {
+				methods[fn.Name.Name] = filepath.Base(path)
+			}
+		}
+	}
+	return methods, nil
+}
+
+func receiverType(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// Route markers delimit the IaC block generate api rewrites
+const (
+	routesBegin = "BEGIN API routes"
+	routesEnd   = "END API routes"
+)
+
+// updateRoutes rewrites the API routes of the project's IaC template.
+// Function URLs and load balancers send every request to the function, so
+// they have no routes.
+func updateRoutes(projectPath string, config *Config, spec *APISpec, result *APIGenResult) error {
+	if config.APIType != "rest" && config.APIType != "http" {
+		return nil
+	}
+
+	var file string
+	var render func(*Config, *APISpec) []string
+	switch config.DeploymentTool {
+	case "sam":
+		file, render = "template.yaml", samRoutes
+	case "serverless":
+		file, render = "serverless.yml", serverlessRoutes
+	case "cdk":
+		file, render = "cdk/lib/stack.ts", cdkRoutes
+	case "terraform":
+		if config.APIType != "http" {
+			result.Notes = append(result.Notes, "terraform/main.tf does not integrate the REST API with a function; add the routes of "+SpecFile+" there")
+			return nil
+		}
+		file, render = "terraform/main.tf", terraformRoutes
+	default:
+		return nil
+	}
+
+	path := filepath.Join(projectPath, file)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	updated, ok := replaceBlock(string(content), render(config, spec))
+	if !ok {
+		result.Notes = append(result.Notes, fmt.Sprintf("%s has no %q block, so its routes were not updated", file, routesBegin))
+		return nil
+	}
+	if updated != string(content) {
+		if err := os.WriteFile(path, []byte(updated), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	result.RoutesFile = file
+	return nil
+}
+
+// replaceBlock replaces the lines between the route markers, indenting
+// them like the begin marker
+func replaceBlock(content string, lines []string) (string, bool) {
+	all := strings.Split(content, "\n")
+	begin, end := -1, -1
+	for i, line := range all {
+		if begin < 0 && strings.Contains(line, routesBegin) {
+			begin = i
+		} else if begin >= 0 && strings.Contains(line, routesEnd) {
+			end = i
+			break
+		}
+	}
+	if begin < 0 || end < 0 {
+		return content, false
+	}
+
+	indent := all[begin][:len(all[begin])-len(strings.TrimLeft(all[begin], " \t"))]
+	block := make([]string, 0, len(lines))
+	for _, line := range lines {
+		block = append(block, indent+line)
+	}
+
+	updated := append(append(append([]string{}, all[:begin+1]...), block...), all[end:]...)
+	return strings.Join(updated, "\n"), true
+}
+
+// routedOperations skips OPTIONS, which the gateways answer for CORS
+func routedOperations(spec *APISpec) []*APIOperation {
+	var ops []*APIOperation
+	for _, op := range spec.Operations {
+		if op.Method != "OPTIONS" {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func samRoutes(config *Config, spec *APISpec) []string {
+	var lines []string
+	for _, op := range routedOperations(spec) {
+		if config.APIType == "http" {
+			lines = append(lines,
+				op.Name+":",
+				"  Type: HttpApi",
+				"  Properties:",
+				"    ApiId: !Ref HttpApi",
+			)
+		} else {
+			lines = append(lines,
+				op.Name+":",
+				"  Type: Api",
+				"  Properties:",
+				"    RestApiId: !Ref ApiGateway",
+			)
+		}
+		lines = append(lines, "    Path: "+op.Path, "    Method: "+op.Method)
+	}
+	return lines
+}
+
+func serverlessRoutes(config *Config, spec *APISpec) []string {
+	var lines []string
+	for _, op := range routedOperations(spec) {
+		if config.APIType == "http" {
+			lines = append(lines,
+				"- httpApi:",
+				"    path: "+op.Path,
+				"    method: "+op.Method,
+			)
+		} else {
+			lines = append(lines,
+				"- http:",
+				"    path: "+strings.TrimPrefix(op.Path, "/"),
+				"    method: "+op.Method,
+				"    cors: ${self:custom.cors.${self:provider.stage}}",
+				"    private: true",
+			)
+		}
+	}
+	return lines
+}
+
+func cdkRoutes(config *Config, spec *APISpec) []string {
+	ops := routedOperations(spec)
+
+	var lines []string
+	if config.APIType == "rest" {
+		for _, op := range ops {
+			lines = append(lines, fmt.Sprintf("api.root.resourceForPath('%s').addMethod('%s', userIntegration);", op.Path, op.Method))
+		}
+		return lines
+	}
+
+	for _, path := range spec.Paths() {
+		var methods []string
+		for _, op := range ops {
+			if op.Path == path {
+				methods = append(methods, "apigwv2.HttpMethod."+op.Method)
+			}
+		}
+		if len(methods) == 0 {
+			continue
+		}
+		lines = append(lines,
+			"api.addRoutes({",
+			fmt.Sprintf("  path: '%s',", path),
+			fmt.Sprintf("  methods: [%s],", strings.Join(methods, ", ")),
+			"  integration: userIntegration,",
+			"});",
+		)
+	}
+	return lines
+}
+
+func terraformRoutes(config *Config, spec *APISpec) []string {
+	var lines []string
+	for _, op := range routedOperations(spec) {
+		lines = append(lines, fmt.Sprintf("%q,", op.Method+" "+op.Path))
+	}
+	return lines
+}
+
+var packageNamePattern = regexp.MustCompile(`[^a-z0-9]`)
+
+// packageName derives the Go package name from the output directory
+func packageName(dir string) string {
+	name := packageNamePattern.ReplaceAllString(strings.ToLower(filepath.Base(dir)), "")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return "operations"
+	}
+	return name
+}
+
+// snakeCase converts an exported Go name to a file name, e.g.
+// GetUserByID to get_user_by_id
+func snakeCase(name string) string {
+	var words []string
+	start := 0
+	for i := 1; i < len(name); i++ {
+		lower := func(c byte) bool { return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' }
+		if !lower(name[i]) && (lower(name[i-1]) || (i+1 < len(name) && lower(name[i+1]))) {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	words = append(words, name[start:])
+	return strings.ToLower(strings.Join(words, "_"))
+}
@@ -0,0 +1,1160 @@
+package generator
+
+import (
+	"fmt"
+	"go/token"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APISpec is the part of an OpenAPI 3 document "generate api" turns into
+// Go: the operations under paths and the schemas they use
+type APISpec struct {
+	Operations []*APIOperation
+	Types      []*APIType
+
+	// Source is the document the spec was loaded from
+	Source []byte
+
+	types map[string]*APIType
+	doc   *specDocument
+}
+
+// APIOperation is one method on one path
+type APIOperation struct {
+	Name        string // Go method name, e.g. CreateUser
+	Input       string // Go type of the parameters and body, if any
+	OperationID string
+	Method      string // e.g. POST
+	Path        string // e.g. /users/{id}
+	Summary     string
+	Params      []*APIParam
+	Body        *APIBody // nil when the operation takes no body
+	Status      int      // the first 2xx response
+	// Output is the Go type of the success response, empty when it has
+	// no body
+	Output string
+	// RawOutput is set when the response is not JSON; the operation then
+	// builds the apievent.Response itself
+	RawOutput bool
+	// Accept is the media type of the success response, if it has a body
+	Accept string
+
+	zero string
+}
+
+// APIParam is a path, query or header parameter
+type APIParam struct {
+	Name     string // Go field name
+	Key      string // name in the spec
+	In       string // path, query or header
+	Required bool
+	Type     string // Go type
+	Default  string // Go literal, empty without a default
+	Doc      string
+}
+
+// APIBody is the request body of an operation
+type APIBody struct {
+	Type        string // Go type; string for non-JSON bodies
+	Required    bool
+	JSON        bool
+	ContentType string
+}
+
+// APIType is a Go type generated from a schema
+type APIType struct {
+	Name string
+	Doc  []string
+	// Kind is struct, enum or alias
+	Kind   string
+	Embeds []string
+	Fields []*APIField
+	// Base is the underlying type of enums and aliases
+	Base   string
+	Values []*APIEnumValue
+}
+
+// APIField is a field of a generated struct
+type APIField struct {
+	Name     string
+	Type     string
+	Tag      string
+	Doc      []string
+	Key      string // property name in the spec
+	Optional bool
+	Nullable bool
+}
+
+// APIEnumValue is a constant of a generated enum
+type APIEnumValue struct {
+	Name  string
+	Value string // Go literal
+}
+
+// specDocument is the subset of OpenAPI 3 the generator reads
+type specDocument struct {
+	OpenAPI    string                   `yaml:"openapi"`
+	Paths      map[string]*specPathItem `yaml:"paths"`
+	Components specComponents           `yaml:"components"`
+}
+
+type specComponents struct {
+	Schemas       map[string]*specSchema      `yaml:"schemas"`
+	Parameters    map[string]*specParameter   `yaml:"parameters"`
+	RequestBodies map[string]*specRequestBody `yaml:"requestBodies"`
+	Responses     map[string]*specResponse    `yaml:"responses"`
+}
+
+type specPathItem struct {
+	Parameters []*specParameter `yaml:"parameters"`
+	Get        *specOperation   `yaml:"get"`
+	Put        *specOperation   `yaml:"put"`
+	Post       *specOperation   `yaml:"post"`
+	Delete     *specOperation   `yaml:"delete"`
+	Options    *specOperation   `yaml:"options"`
+	Head       *specOperation   `yaml:"head"`
+	Patch      *specOperation   `yaml:"patch"`
+}
+
+type specOperation struct {
+	OperationID string                   `yaml:"operationId"`
+	Summary     string                   `yaml:"summary"`
+	Parameters  []*specParameter         `yaml:"parameters"`
+	RequestBody *specRequestBody         `yaml:"requestBody"`
+	Responses   map[string]*specResponse `yaml:"responses"`
+}
+
+type specParameter struct {
+	Ref         string      `yaml:"$ref"`
+	Name        string      `yaml:"name"`
+	In          string      `yaml:"in"`
+	Description string      `yaml:"description"`
+	Required    bool        `yaml:"required"`
+	Schema      *specSchema `yaml:"schema"`
+}
+
+type specRequestBody struct {
+	Ref      string                    `yaml:"$ref"`
+	Required bool                      `yaml:"required"`
+	Content  map[string]*specMediaType `yaml:"content"`
+}
+
+type specResponse struct {
+	Ref     string                    `yaml:"$ref"`
+	Content map[string]*specMediaType `yaml:"content"`
+}
+
+type specMediaType struct {
+	Schema *specSchema `yaml:"schema"`
+}
+
+type specSchema struct {
+	Ref                  string           `yaml:"$ref"`
+	Type                 schemaType       `yaml:"type"`
+	Format               string           `yaml:"format"`
+	Description          string           `yaml:"description"`
+	Nullable             bool             `yaml:"nullable"`
+	Enum                 []interface{}    `yaml:"enum"`
+	Default              interface{}      `yaml:"default"`
+	Properties           schemaProperties `yaml:"properties"`
+	Required             []string         `yaml:"required"`
+	Items                *specSchema      `yaml:"items"`
+	AdditionalProperties *specSchema      `yaml:"-"`
+	AllOf                []*specSchema    `yaml:"allOf"`
+	OneOf                []*specSchema    `yaml:"oneOf"`
+	AnyOf                []*specSchema    `yaml:"anyOf"`
+}
+
+// schemaType accepts both the OpenAPI 3.0 form (type: string) and the 3.1
+// one (type: [string, "null"])
+type schemaType struct {
+	Name     string
+	Nullable bool
+}
+
+func (t *schemaType) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.Name = node.Value
+		return nil
+	}
+
+	var names []string
+	if err := node.Decode(&names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "null" {
+			t.Nullable = true
+		} else if t.Name == "" {
+			t.Name = name
+		}
+	}
+	return nil
+}
+
+// schemaProperties keeps properties in the order of the document, so the
+// generated struct fields follow the spec
+type schemaProperties struct {
+	Names   []string
+	Schemas map[string]*specSchema
+}
+
+func (p *schemaProperties) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("properties must be a mapping")
+	}
+
+	p.Schemas = make(map[string]*specSchema)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		schema := &specSchema{}
+		if err := node.Content[i+1].Decode(schema); err != nil {
+			return fmt.Errorf("property %s: %w", name, err)
+		}
+		p.Names = append(p.Names, name)
+		p.Schemas[name] = schema
+	}
+	return nil
+}
+
+// additionalProperties may be a boolean; only schemas produce maps
+func (s *specSchema) UnmarshalYAML(node *yaml.Node) error {
+	type plain specSchema
+	var raw struct {
+		plain                `yaml:",inline"`
+		AdditionalProperties yaml.Node `yaml:"additionalProperties"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	*s = specSchema(raw.plain)
+
+	if raw.AdditionalProperties.Kind == yaml.MappingNode {
+		s.AdditionalProperties = &specSchema{}
+		return raw.AdditionalProperties.Decode(s.AdditionalProperties)
+	}
+	if raw.AdditionalProperties.Kind == yaml.ScalarNode && raw.AdditionalProperties.Value == "true" {
+		s.AdditionalProperties = &specSchema{}
+	}
+	return nil
+}
+
+var (
+	pathParamPattern = regexp.MustCompile(`\{([^{}/]+)\}`)
+	nonAlnumPattern  = regexp.MustCompile(`[^A-Za-z0-9]+`)
+	camelHumpPattern = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+	// specMethods are the operations of a path item in routing order
+	specMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions}
+)
+
+// LoadAPISpec reads an OpenAPI 3 document in YAML or JSON
+func LoadAPISpec(path string) (*APISpec, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API spec: %w", err)
+	}
+
+	doc := &specDocument{}
+	if err := yaml.Unmarshal(source, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse API spec: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("invalid API spec: only OpenAPI 3 documents are supported")
+	}
+
+	spec := &APISpec{Source: source, types: make(map[string]*APIType), doc: doc}
+	if err := spec.resolve(); err != nil {
+		return nil, fmt.Errorf("invalid API spec: %w", err)
+	}
+
+	return spec, nil
+}
+
+// Routes returns the operations grouped by path, in routing order
+func (s *APISpec) Routes() map[string][]*APIOperation {
+	routes := make(map[string][]*APIOperation)
+	for _, op := range s.Operations {
+		routes[op.Path] = append(routes[op.Path], op)
+	}
+	return routes
+}
+
+// Paths returns the paths of the spec in routing order
+func (s *APISpec) Paths() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, op := range s.Operations {
+		if !seen[op.Path] {
+			seen[op.Path] = true
+			paths = append(paths, op.Path)
+		}
+	}
+	return paths
+}
+
+// Operation returns the operation with the given Go name, or nil
+func (s *APISpec) Operation(name string) *APIOperation {
+	for _, op := range s.Operations {
+		if op.Name == name {
+			return op
+		}
+	}
+	return nil
+}
+
+// UsesPackage reports whether the generated types need the import
+func (s *APISpec) UsesPackage(pkg string) bool {
+	marker := pkg + "."
+	for _, t := range s.Types {
+		if strings.Contains(t.Base, marker) {
+			return true
+		}
+		for _, field := range t.Fields {
+			if strings.Contains(field.Type, marker) {
+				return true
+			}
+		}
+	}
+	for _, op := range s.Operations {
+		for _, param := range op.Params {
+			if strings.Contains(param.Type, marker) {
+				return true
+			}
+		}
+		if op.Body != nil && strings.Contains(op.Body.Type, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *APISpec) resolve() error {
+	if len(s.doc.Paths) == 0 {
+		return fmt.Errorf("the spec has no paths")
+	}
+
+	// Component schemas keep their names; inline ones are named after
+	// where they are used
+	for _, name := range sortedSchemaNames(s.doc.Components.Schemas) {
+		goName := typeName(name)
+		if goName == "" {
+			return fmt.Errorf("schema name %q has no letters", name)
+		}
+		s.types[name] = &APIType{Name: goName}
+	}
+	for _, name := range sortedSchemaNames(s.doc.Components.Schemas) {
+		if err := s.defineType(s.types[name], s.doc.Components.Schemas[name]); err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+
+	paths := make([]string, 0, len(s.doc.Paths))
+	for path := range s.doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return routeLess(paths[i], paths[j]) })
+
+	names := make(map[string]string)
+	for _, path := range paths {
+		item := s.doc.Paths[path]
+		if item == nil {
+			continue
+		}
+		for _, method := range specMethods {
+			operation := item.operation(method)
+			if operation == nil {
+				continue
+			}
+
+			op, err := s.resolveOperation(method, path, item, operation)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			if other, ok := names[op.Name]; ok {
+				return fmt.Errorf("%s %s and %s both become the Go method %s; set distinct operationIds", method, path, other, op.Name)
+			}
+			names[op.Name] = method + " " + path
+			s.Operations = append(s.Operations, op)
+		}
+	}
+	if len(s.Operations) == 0 {
+		return fmt.Errorf("the spec has no operations")
+	}
+
+	// Inline types are registered as they are found; list them by name
+	sort.Slice(s.Types, func(i, j int) bool { return s.Types[i].Name < s.Types[j].Name })
+
+	taken := make(map[string]bool)
+	for _, t := range s.Types {
+		taken[t.Name] = true
+	}
+	for _, op := range s.Operations {
+		if len(op.Params) > 0 || op.Body != nil {
+			op.Input = uniqueName(op.Name+"Input", taken)
+		}
+		op.zero = s.zeroValue(op.Output)
+	}
+
+	return nil
+}
+
+// zeroValue returns the Go zero value of a type the spec generated
+func (s *APISpec) zeroValue(goType string) string {
+	switch {
+	case goType == "":
+		return ""
+	case strings.HasPrefix(goType, "*"), strings.HasPrefix(goType, "[]"), strings.HasPrefix(goType, "map["),
+		goType == "interface{}", goType == "json.RawMessage":
+		return "nil"
+	case goType == "string":
+		return `""`
+	case goType == "bool":
+		return "false"
+	case isScalar(goType):
+		return "0"
+	}
+
+	for _, t := range s.Types {
+		if t.Name != goType {
+			continue
+		}
+		switch t.Kind {
+		case "enum":
+			return `""`
+		case "alias":
+			return s.zeroValue(t.Base)
+		}
+	}
+	return goType + "{}"
+}
+
+func (s *APISpec) resolveOperation(method, path string, item *specPathItem, operation *specOperation) (*APIOperation, error) {
+	op := &APIOperation{
+		Name:        operationName(method, path, operation.OperationID),
+		OperationID: operation.OperationID,
+		Method:      method,
+		Path:        path,
+		Summary:     strings.TrimSpace(operation.Summary),
+	}
+	if op.Name == "" || !token.IsIdentifier(op.Name) {
+		return nil, fmt.Errorf("operationId %q is not usable as a Go name", operation.OperationID)
+	}
+
+	// Operation parameters override path item ones with the same name
+	params := make(map[string]*specParameter)
+	var order []string
+	for _, param := range append(append([]*specParameter{}, item.Parameters...), operation.Parameters...) {
+		resolved, err := s.parameter(param)
+		if err != nil {
+			return nil, err
+		}
+		if resolved.In == "cookie" {
+			continue
+		}
+		key := resolved.In + ":" + resolved.Name
+		if _, ok := params[key]; !ok {
+			order = append(order, key)
+		}
+		params[key] = resolved
+	}
+
+	// A path template segment the spec forgot to declare is still a
+	// required string
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		key := "path:" + match[1]
+		if _, ok := params[key]; !ok {
+			order = append(order, key)
+			params[key] = &specParameter{Name: match[1], In: "path", Required: true}
+		}
+	}
+
+	fields := make(map[string]bool)
+	if operation.RequestBody != nil {
+		fields["Body"] = true
+	}
+	for _, key := range order {
+		param := params[key]
+		name := uniqueName(fieldName(param.Name), fields)
+		goType, def, err := s.paramType(op.Name+name, param.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", param.Name, err)
+		}
+		op.Params = append(op.Params, &APIParam{
+			Name:     name,
+			Key:      param.Name,
+			In:       param.In,
+			Required: param.Required || param.In == "path",
+			Type:     goType,
+			Default:  def,
+			Doc:      strings.TrimSpace(firstLine(param.Description)),
+		})
+	}
+
+	if operation.RequestBody != nil {
+		body, err := s.requestBody(operation.RequestBody)
+		if err != nil {
+			return nil, err
+		}
+		op.Body = &APIBody{Type: "string", Required: body.Required, ContentType: firstMediaType(body.Content)}
+		if contentType, media := jsonMedia(body.Content); media != nil {
+			op.Body.ContentType = contentType
+			op.Body.JSON = true
+			op.Body.Type = "json.RawMessage"
+			if media.Schema != nil {
+				goType, err := s.goType(op.Name+"Request", media.Schema, true)
+				if err != nil {
+					return nil, fmt.Errorf("request body: %w", err)
+				}
+				op.Body.Type = goType
+			}
+		}
+	}
+
+	if err := s.resolveResponse(op, operation.Responses); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// resolveResponse picks the first 2xx response as the operation's result
+func (s *APISpec) resolveResponse(op *APIOperation, responses map[string]*specResponse) error {
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	op.Status = http.StatusOK
+	for _, code := range codes {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 200 || status > 299 {
+			if code != "2XX" && code != "2xx" {
+				continue
+			}
+			status = http.StatusOK
+		}
+		op.Status = status
+
+		response, err := s.response(responses[code])
+		if err != nil {
+			return err
+		}
+		if len(response.Content) == 0 || status == http.StatusNoContent {
+			return nil
+		}
+
+		contentType, media := jsonMedia(response.Content)
+		if media == nil {
+			op.Accept = firstMediaType(response.Content)
+			op.RawOutput = true
+			op.Output = "apievent.Response"
+			return nil
+		}
+		op.Accept = contentType
+		op.Output = "json.RawMessage"
+		if media.Schema != nil {
+			goType, err := s.goType(op.Name+"Response", media.Schema, true)
+			if err != nil {
+				return fmt.Errorf("response %s: %w", code, err)
+			}
+			op.Output = goType
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// paramType maps a parameter schema to a Go type. Parameters use plain
+// strings for enums, so they stay easy to compare and log.
+func (s *APISpec) paramType(hint string, schema *specSchema) (string, string, error) {
+	if schema == nil {
+		return "string", "", nil
+	}
+	schema, err := s.schema(schema)
+	if err != nil {
+		return "", "", err
+	}
+
+	goType := "string"
+	switch schema.Type.Name {
+	case "array":
+		itemType, _, err := s.paramType(hint, schema.Items)
+		if err != nil {
+			return "", "", err
+		}
+		return "[]" + itemType, "", nil
+	case "integer", "number", "boolean":
+		goType = scalarType(schema)
+	case "string":
+		if schema.Format == "date-time" {
+			goType = "time.Time"
+		}
+	}
+
+	return goType, goLiteral(goType, schema.Default), nil
+}
+
+// goType returns the Go type of schema, defining named types for objects
+// and enums. hint names inline types; pointer makes structs pointers.
+func (s *APISpec) goType(hint string, schema *specSchema, pointer bool) (string, error) {
+	if schema.Ref != "" {
+		name, err := schemaRefName(schema.Ref)
+		if err != nil {
+			return "", err
+		}
+		target, ok := s.types[name]
+		if !ok {
+			return "", fmt.Errorf("unknown schema %s", schema.Ref)
+		}
+		if pointer && s.doc.Components.Schemas[name].isObject() {
+			return "*" + target.Name, nil
+		}
+		return target.Name, nil
+	}
+
+	switch {
+	case len(schema.OneOf) > 0 || len(schema.AnyOf) > 0:
+		return "json.RawMessage", nil
+	case len(schema.Enum) > 0 && schema.Type.Name == "string":
+		t := s.newType(hint)
+		return t.Name, s.defineType(t, schema)
+	case schema.isObject() && (len(schema.Properties.Names) > 0 || len(schema.AllOf) > 0):
+		t := s.newType(hint)
+		if err := s.defineType(t, schema); err != nil {
+			return "", err
+		}
+		if pointer {
+			return "*" + t.Name, nil
+		}
+		return t.Name, nil
+	}
+
+	switch schema.Type.Name {
+	case "array":
+		if schema.Items == nil {
+			return "[]interface{}", nil
+		}
+		itemType, err := s.goType(hint+"Item", schema.Items, false)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + itemType, nil
+	case "object", "":
+		if schema.AdditionalProperties != nil && schema.Type.Name == "object" {
+			valueType, err := s.goType(hint+"Value", schema.AdditionalProperties, false)
+			if err != nil {
+				return "", err
+			}
+			return "map[string]" + valueType, nil
+		}
+		if schema.Type.Name == "object" {
+			return "map[string]interface{}", nil
+		}
+		return "interface{}", nil
+	case "string":
+		if schema.Format == "date-time" {
+			return "time.Time", nil
+		}
+		return "string", nil
+	default:
+		return scalarType(schema), nil
+	}
+}
+
+// defineType fills t from a component or inline schema
+func (s *APISpec) defineType(t *APIType, schema *specSchema) error {
+	s.Types = append(s.Types, t)
+	t.Doc = commentLines(schema.Description)
+
+	switch {
+	case schema.Ref != "":
+		target, err := s.goType(t.Name, schema, false)
+		if err != nil {
+			return err
+		}
+		t.Kind, t.Base = "alias", target
+	case len(schema.Enum) > 0 && schema.Type.Name == "string":
+		t.Kind, t.Base = "enum", "string"
+		names := make(map[string]bool)
+		for _, value := range schema.Enum {
+			str, ok := value.(string)
+			if !ok {
+				continue
+			}
+			t.Values = append(t.Values, &APIEnumValue{
+				Name:  uniqueName(t.Name+typeName(str), names),
+				Value: strconv.Quote(str),
+			})
+		}
+	case schema.isObject() && (len(schema.Properties.Names) > 0 || len(schema.AllOf) > 0):
+		t.Kind = "struct"
+		return s.defineStruct(t, schema)
+	default:
+		base, err := s.goType(t.Name, schema, false)
+		if err != nil {
+			return err
+		}
+		t.Kind, t.Base = "alias", base
+	}
+
+	return nil
+}
+
+func (s *APISpec) defineStruct(t *APIType, schema *specSchema) error {
+	// allOf members are embedded, so their fields are promoted and
+	// encoded inline
+	var properties []*schemaProperties
+	var required []string
+	for i, part := range schema.AllOf {
+		if part.Ref != "" {
+			target, err := s.goType(t.Name, part, false)
+			if err != nil {
+				return err
+			}
+			t.Embeds = append(t.Embeds, target)
+			continue
+		}
+		if !part.isObject() {
+			return fmt.Errorf("allOf member %d is not an object", i)
+		}
+		properties = append(properties, &part.Properties)
+		required = append(required, part.Required...)
+	}
+	properties = append(properties, &schema.Properties)
+	required = append(required, schema.Required...)
+
+	isRequired := make(map[string]bool)
+	for _, name := range required {
+		isRequired[name] = true
+	}
+
+	fields := make(map[string]bool)
+	for _, props := range properties {
+		for _, key := range props.Names {
+			prop := props.Schemas[key]
+			name := uniqueName(fieldName(key), fields)
+
+			resolved, err := s.schema(prop)
+			if err != nil {
+				return fmt.Errorf("property %s: %w", key, err)
+			}
+			optional := !isRequired[key]
+			pointer := optional || resolved.Nullable || resolved.Type.Nullable
+			goType, err := s.goType(t.Name+name, prop, pointer)
+			if err != nil {
+				return fmt.Errorf("property %s: %w", key, err)
+			}
+			if pointer && (goType == "time.Time" || ((resolved.Nullable || resolved.Type.Nullable) && isScalar(goType))) {
+				goType = "*" + goType
+			}
+
+			tag := key
+			if optional {
+				tag += ",omitempty"
+			}
+			t.Fields = append(t.Fields, &APIField{
+				Name:     name,
+				Type:     goType,
+				Tag:      "`json:\"" + tag + "\"`",
+				Doc:      commentLines(prop.Description),
+				Key:      key,
+				Optional: optional,
+				Nullable: resolved.Nullable || resolved.Type.Nullable,
+			})
+		}
+	}
+
+	return nil
+}
+
+// newType registers a type for an inline schema under a free name
+func (s *APISpec) newType(hint string) *APIType {
+	taken := make(map[string]bool)
+	for _, t := range s.types {
+		taken[t.Name] = true
+	}
+	for _, t := range s.Types {
+		taken[t.Name] = true
+	}
+	name := uniqueName(hint, taken)
+	t := &APIType{Name: name}
+	s.types["#inline/"+name] = t
+	return t
+}
+
+// schema follows a schema $ref to its component
+func (s *APISpec) schema(schema *specSchema) (*specSchema, error) {
+	for depth := 0; schema.Ref != ""; depth++ {
+		if depth > 16 {
+			return nil, fmt.Errorf("$ref cycle at %s", schema.Ref)
+		}
+		name, err := schemaRefName(schema.Ref)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := s.doc.Components.Schemas[name]
+		if !ok || target == nil {
+			return nil, fmt.Errorf("unknown schema %s", schema.Ref)
+		}
+		schema = target
+	}
+	return schema, nil
+}
+
+func (s *APISpec) parameter(param *specParameter) (*specParameter, error) {
+	if param.Ref == "" {
+		return param, nil
+	}
+	target, ok := s.doc.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+	if !ok || target == nil || target.Ref != "" {
+		return nil, fmt.Errorf("unknown parameter %s", param.Ref)
+	}
+	return target, nil
+}
+
+func (s *APISpec) requestBody(body *specRequestBody) (*specRequestBody, error) {
+	if body.Ref == "" {
+		return body, nil
+	}
+	target, ok := s.doc.Components.RequestBodies[strings.TrimPrefix(body.Ref, "#/components/requestBodies/")]
+	if !ok || target == nil || target.Ref != "" {
+		return nil, fmt.Errorf("unknown request body %s", body.Ref)
+	}
+	return target, nil
+}
+
+func (s *APISpec) response(response *specResponse) (*specResponse, error) {
+	if response == nil {
+		return &specResponse{}, nil
+	}
+	if response.Ref == "" {
+		return response, nil
+	}
+	target, ok := s.doc.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	if !ok || target == nil || target.Ref != "" {
+		return nil, fmt.Errorf("unknown response %s", response.Ref)
+	}
+	return target, nil
+}
+
+// Handler returns the name of the router method decoding the request
+func (o *APIOperation) Handler() string {
+	name := strings.ToLower(o.Name[:1]) + o.Name[1:]
+	if token.IsKeyword(name) {
+		name += "Operation"
+	}
+	return name
+}
+
+// Signature returns the operation's method signature
+func (o *APIOperation) Signature() string {
+	params := "ctx context.Context"
+	if o.Input != "" {
+		params += ", input *" + o.Input
+	}
+	if o.Output == "" {
+		return fmt.Sprintf("%s(%s) error", o.Name, params)
+	}
+	return fmt.Sprintf("%s(%s) (%s, error)", o.Name, params, o.Output)
+}
+
+// Returns is what the generated stub returns besides the error, e.g.
+// "nil, "
+func (o *APIOperation) Returns() string {
+	if o.zero == "" {
+		return ""
+	}
+	return o.zero + ", "
+}
+
+// Imports returns the standard library packages the signature uses
+func (o *APIOperation) Imports() []string {
+	imports := []string{"context"}
+	signature := o.Signature()
+	if strings.Contains(signature, "json.") {
+		imports = append(imports, "encoding/json")
+	}
+	if strings.Contains(signature, "time.") {
+		imports = append(imports, "time")
+	}
+	return imports
+}
+
+// MethodConst returns the net/http constant of the method
+func (o *APIOperation) MethodConst() string {
+	return "http.Method" + o.Method[:1] + strings.ToLower(o.Method[1:])
+}
+
+// StatusConst returns the net/http constant of the success status
+func (o *APIOperation) StatusConst() string {
+	if name, ok := statusConsts[o.Status]; ok {
+		return "http." + name
+	}
+	return strconv.Itoa(o.Status)
+}
+
+var statusConsts = map[int]string{
+	http.StatusOK:             "StatusOK",
+	http.StatusCreated:        "StatusCreated",
+	http.StatusAccepted:       "StatusAccepted",
+	http.StatusNoContent:      "StatusNoContent",
+	http.StatusResetContent:   "StatusResetContent",
+	http.StatusPartialContent: "StatusPartialContent",
+}
+
+// Source returns the expression reading the parameter from a request
+func (p *APIParam) Source(path string) string {
+	switch p.In {
+	case "path":
+		return fmt.Sprintf("apievent.PathParameter(request, %q, %q)", path, p.Key)
+	case "header":
+		return fmt.Sprintf("apievent.Header(request, %q)", p.Key)
+	default:
+		return fmt.Sprintf("request.QueryStringParameters[%q]", p.Key)
+	}
+}
+
+func (i *specPathItem) operation(method string) *specOperation {
+	switch method {
+	case http.MethodGet:
+		return i.Get
+	case http.MethodPost:
+		return i.Post
+	case http.MethodPut:
+		return i.Put
+	case http.MethodPatch:
+		return i.Patch
+	case http.MethodDelete:
+		return i.Delete
+	case http.MethodHead:
+		return i.Head
+	case http.MethodOptions:
+		return i.Options
+	default:
+		return nil
+	}
+}
+
+func (s *specSchema) isObject() bool {
+	if s == nil {
+		return false
+	}
+	return s.Type.Name == "object" || (s.Type.Name == "" && (len(s.Properties.Names) > 0 || len(s.AllOf) > 0))
+}
+
+// jsonMedia returns the JSON content of a body or response and its media
+// type, or nil
+func jsonMedia(content map[string]*specMediaType) (string, *specMediaType) {
+	for _, key := range mediaTypes(content) {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.Split(key, ";")[0]))
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			if content[key] == nil {
+				return key, &specMediaType{}
+			}
+			return key, content[key]
+		}
+	}
+	return "", nil
+}
+
+// firstMediaType returns the media type a non-JSON body or response is
+// sent with
+func firstMediaType(content map[string]*specMediaType) string {
+	if keys := mediaTypes(content); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+func mediaTypes(content map[string]*specMediaType) []string {
+	keys := make([]string, 0, len(content))
+	for key := range content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func scalarType(schema *specSchema) string {
+	switch schema.Type.Name {
+	case "integer":
+		switch schema.Format {
+		case "int32":
+			return "int32"
+		case "int64":
+			return "int64"
+		default:
+			return "int"
+		}
+	case "number":
+		if schema.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	default:
+		return "string"
+	}
+}
+
+func isScalar(goType string) bool {
+	switch goType {
+	case "string", "int", "int32", "int64", "float32", "float64", "bool":
+		return true
+	default:
+		return false
+	}
+}
+
+// goLiteral renders a parameter default as a Go literal, or "" when the
+// type has none
+func goLiteral(goType string, value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	switch goType {
+	case "string":
+		if str, ok := value.(string); ok {
+			return strconv.Quote(str)
+		}
+	case "int", "int32", "int64":
+		if n, ok := value.(int); ok {
+			return strconv.Itoa(n)
+		}
+	case "float32", "float64":
+		switch n := value.(type) {
+		case int:
+			return strconv.Itoa(n)
+		case float64:
+			return strconv.FormatFloat(n, 'g', -1, 64)
+		}
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b)
+		}
+	}
+	return ""
+}
+
+func schemaRefName(ref string) (string, error) {
+	const prefix = "#/components/schemas/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("only local schema references are supported, got %s", ref)
+	}
+	return strings.TrimPrefix(ref, prefix), nil
+}
+
+// operationName is the exported operationId, or one derived from the
+// route, e.g. GetUsersByID for GET /users/{id}
+func operationName(method, path, operationID string) string {
+	if operationID != "" {
+		return typeName(operationID)
+	}
+
+	name := typeName(strings.ToLower(method))
+	for _, segment := range strings.Split(path, "/") {
+		if match := pathParamPattern.FindStringSubmatch(segment); match != nil {
+			name += "By" + typeName(match[1])
+		} else {
+			name += typeName(segment)
+		}
+	}
+	return name
+}
+
+// typeName converts any spec name (camelCase, snake_case, kebab-case or
+// with spaces) to an exported Go name
+func typeName(s string) string {
+	var words []string
+	for _, word := range nonAlnumPattern.Split(camelHumpPattern.ReplaceAllString(s, "${1}_$2"), -1) {
+		switch lower := strings.ToLower(word); lower {
+		case "":
+		case "id", "url", "api", "ip":
+			// Let exportedName spell initialisms, e.g. userId -> UserID
+			words = append(words, lower)
+		default:
+			words = append(words, word)
+		}
+	}
+	name := exportedName(strings.Join(words, "_"))
+	if name == "" {
+		return ""
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "N" + name
+	}
+	return name
+}
+
+func fieldName(key string) string {
+	name := typeName(key)
+	if name == "" {
+		return "Field"
+	}
+	return name
+}
+
+// uniqueName returns name, or name with a numeric suffix when it is taken,
+// and marks it taken
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// routeLess orders paths so that literal segments are matched before
+// parameters, e.g. /users/me before /users/{id}
+func routeLess(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		aParam, bParam := strings.HasPrefix(as[i], "{"), strings.HasPrefix(bs[i], "{")
+		if aParam != bParam {
+			return bParam
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+func commentLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return line
+}
+
+func sortedSchemaNames(schemas map[string]*specSchema) []string {
+	names := make([]string, 0, len(schemas))
+	for name, schema := range schemas {
+		if schema != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
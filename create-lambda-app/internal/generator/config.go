@@ -1,5 +1,7 @@
 package generator
 
+import "strings"
+
 // Config holds the configuration for project generation
 type Config struct {
 	Name             string
@@ -11,6 +13,11 @@ type Config struct {
 	SkipGit          bool
 	SkipInstall      bool
 	Module           string // Go module name
+	APIType          string // rest, http, function-url or alb
+	Router           string // gin, chi, stdlib or none
+
+	AccessPatternsFile string     // Single-table design, empty for the default
+	DataModel          *DataModel // Loaded from AccessPatternsFile
 }
 
 // HasFeature checks if a feature is enabled
@@ -18,6 +25,91 @@ func (c *Config) HasFeature(feature string) bool {
 	return c.Features[feature]
 }
 
+// APITypes are the event sources an API can be served through
+var APITypes = []string{"rest", "http", "function-url", "alb"}
+
+// Routers are the libraries the API handlers can be routed with. "none"
+// dispatches on the event itself, without a router.
+var Routers = []string{"gin", "chi", "stdlib", "none"}
+
+// DefaultRouter is the router of projects generated without --router:
+// clean architecture dispatches on events, the others route with gin
+func DefaultRouter(architecture string) string {
+	if architecture == "clean" {
+		return "none"
+	}
+	return "gin"
+}
+
+// UsesRouter reports whether API requests go through a router library
+func (c *Config) UsesRouter() bool {
+	return c.HasFeature("api") && c.Router != "none"
+}
+
+// GoVersion is the go directive of the generated go.mod. The stdlib router
+// needs the method and wildcard patterns of Go 1.22.
+func (c *Config) GoVersion() string {
+	if c.UsesRouter() && c.Router == "stdlib" {
+		return "1.22"
+	}
+	return "1.21"
+}
+
+// APIRequestType is the aws-lambda-go event the API function receives
+func (c *Config) APIRequestType() string {
+	switch c.APIType {
+	case "http":
+		return "events.APIGatewayV2HTTPRequest"
+	case "function-url":
+		return "events.LambdaFunctionURLRequest"
+	case "alb":
+		return "events.ALBTargetGroupRequest"
+	default:
+		return "events.APIGatewayProxyRequest"
+	}
+}
+
+// APIResponseType is the aws-lambda-go response the API function returns
+func (c *Config) APIResponseType() string {
+	switch c.APIType {
+	case "http":
+		return "events.APIGatewayV2HTTPResponse"
+	case "function-url":
+		return "events.LambdaFunctionURLResponse"
+	case "alb":
+		return "events.ALBTargetGroupResponse"
+	default:
+		return "events.APIGatewayProxyResponse"
+	}
+}
+
+// UsesDataModel reports whether the project gets repositories generated
+// from the single-table design. The simple and DDD structures always
+// include one, clean architecture only with the dynamodb feature.
+func (c *Config) UsesDataModel() bool {
+	return c.Architecture != "clean" || c.HasFeature("dynamodb")
+}
+
+// HasLocalAPI reports whether the project gets cmd/local-api. Clean and
+// simple projects always have an API handler, DDD ones only with the api
+// feature.
+func (c *Config) HasLocalAPI() bool {
+	return c.Architecture != "ddd" || c.HasFeature("api")
+}
+
+// ClientName returns the class of the project's TypeScript client, e.g.
+// OrdersAPI
+func (c *Config) ClientName() string {
+	name := typeName(c.Name)
+	if name == "" {
+		name = "Service"
+	}
+	if !strings.HasSuffix(name, "API") {
+		name += "API"
+	}
+	return name
+}
+
 // GetEnabledFeatures returns a list of enabled features
 func (c *Config) GetEnabledFeatures() []string {
 	var features []string
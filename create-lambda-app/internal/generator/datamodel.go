@@ -0,0 +1,518 @@
+package generator
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/leeguooooo/create-lambda-app/internal/templates"
+)
+
+// DataModel is a DynamoDB single-table design loaded from an access-pattern
+// file. It drives the generated key schema, secondary indexes, typed
+// access-pattern methods and the table definition in the IaC templates.
+type DataModel struct {
+	Table    *TableDef `yaml:"table"`
+	Entities []*Entity `yaml:"entities"`
+
+	// Source is the YAML the model was loaded from
+	Source []byte `yaml:"-"`
+}
+
+// TableDef describes the table key attributes and its secondary indexes
+type TableDef struct {
+	Name         string      `yaml:"name"`
+	PartitionKey string      `yaml:"partition_key"`
+	SortKey      string      `yaml:"sort_key"`
+	Indexes      []*IndexDef `yaml:"indexes"`
+}
+
+// IndexDef describes a global secondary index
+type IndexDef struct {
+	Name         string `yaml:"name"`
+	PartitionKey string `yaml:"partition_key"`
+	SortKey      string `yaml:"sort_key"`
+}
+
+// Entity is an item type stored in the table
+type Entity struct {
+	Name           string            `yaml:"name"`
+	Keys           map[string]string `yaml:"keys"`
+	AccessPatterns []*AccessPattern  `yaml:"access_patterns"`
+
+	// KeyAttributes are the entity's key values in table, then index, order
+	KeyAttributes []*KeyAttribute `yaml:"-"`
+	// Fields are the placeholders referenced by the key templates
+	Fields []*KeyField `yaml:"-"`
+}
+
+// KeyAttribute is a key attribute and the template its value is built from
+type KeyAttribute struct {
+	Attribute string
+	Template  *KeyTemplate
+	Primary   bool
+}
+
+// AccessPattern is a read the application performs against the table
+type AccessPattern struct {
+	Name          string `yaml:"name"`
+	Description   string `yaml:"description"`
+	Index         string `yaml:"index"`
+	Unique        bool   `yaml:"unique"`
+	SortKeyPrefix string `yaml:"sort_key_prefix"`
+	Descending    bool   `yaml:"descending"`
+
+	Entity   *Entity   `yaml:"-"`
+	IndexDef *IndexDef `yaml:"-"` // nil for patterns on the table
+	// Operation is "get" for GetItem on the table and "query" otherwise
+	Operation    string       `yaml:"-"`
+	PartitionKey string       `yaml:"-"`
+	SortKey      string       `yaml:"-"`
+	PK           *KeyTemplate `yaml:"-"`
+	SK           *KeyTemplate `yaml:"-"`
+	Prefix       *KeyTemplate `yaml:"-"`
+	Params       []*KeyField  `yaml:"-"`
+}
+
+// KeyField is a {placeholder} used in a key template
+type KeyField struct {
+	Placeholder string
+	Name        string // exported Go name, e.g. CreatedAt
+	Param       string // parameter name, e.g. createdAt
+}
+
+// KeyTemplate is a key value such as "USER#{id}"
+type KeyTemplate struct {
+	Raw    string
+	parts  []string
+	fields []*KeyField
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{([a-z][a-z0-9_]*)\}`)
+	identifierPattern  = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+)
+
+// userKeyFields maps the placeholders the generated user repositories can
+// fill to the expression producing them from a user value
+var userKeyFields = map[string]string{
+	"id":         "%s.ID",
+	"email":      "%s.Email",
+	"name":       "%s.Name",
+	"status":     "string(%s.Status)",
+	"created_at": "KeyTime(%s.CreatedAt)",
+	"updated_at": "KeyTime(%s.UpdatedAt)",
+}
+
+// LoadDataModel reads an access-pattern file. An empty path loads the
+// default single-table design.
+func LoadDataModel(path string) (*DataModel, error) {
+	source := []byte(templates.AccessPatterns)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read access patterns: %w", err)
+		}
+		source = data
+	}
+
+	model := &DataModel{}
+	if err := yaml.Unmarshal(source, model); err != nil {
+		return nil, fmt.Errorf("failed to parse access patterns: %w", err)
+	}
+	model.Source = source
+
+	if err := model.resolve(); err != nil {
+		return nil, fmt.Errorf("invalid access patterns: %w", err)
+	}
+
+	return model, nil
+}
+
+// KeyAttributes returns every attribute used as a table or index key
+func (m *DataModel) KeyAttributes() []string {
+	var attrs []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			attrs = append(attrs, name)
+		}
+	}
+
+	add(m.Table.PartitionKey)
+	add(m.Table.SortKey)
+	for _, index := range m.Table.Indexes {
+		add(index.PartitionKey)
+		add(index.SortKey)
+	}
+
+	return attrs
+}
+
+// AccessPatterns returns the access patterns of every entity
+func (m *DataModel) AccessPatterns() []*AccessPattern {
+	var patterns []*AccessPattern
+	for _, entity := range m.Entities {
+		patterns = append(patterns, entity.AccessPatterns...)
+	}
+	return patterns
+}
+
+// Entity returns the entity with the given name, or nil
+func (m *DataModel) Entity(name string) *Entity {
+	for _, entity := range m.Entities {
+		if entity.Name == name {
+			return entity
+		}
+	}
+	return nil
+}
+
+// Pattern returns the access pattern with the given name, or nil
+func (m *DataModel) Pattern(name string) *AccessPattern {
+	for _, pattern := range m.AccessPatterns() {
+		if pattern.Name == name {
+			return pattern
+		}
+	}
+	return nil
+}
+
+// ValidateForUsers checks that the model still provides what the generated
+// user repositories rely on
+func (m *DataModel) ValidateForUsers() error {
+	user := m.Entity("User")
+	if user == nil {
+		return fmt.Errorf("the generated repositories need a User entity")
+	}
+
+	for _, field := range user.Fields {
+		if _, ok := userKeyFields[field.Placeholder]; !ok {
+			return fmt.Errorf("User key placeholder {%s} is not a user field (use %s)", field.Placeholder, strings.Join(sortedKeys(userKeyFields), ", "))
+		}
+	}
+
+	// Updates and deletes address a user by ID alone
+	usesID := false
+	for _, attr := range user.KeyAttributes {
+		if !attr.Primary {
+			continue
+		}
+		for _, field := range attr.Template.Fields() {
+			if field.Placeholder != "id" {
+				return fmt.Errorf("the User %s key may only use {id}", attr.Attribute)
+			}
+			usesID = true
+		}
+	}
+	if !usesID {
+		return fmt.Errorf("the User primary key must use {id}")
+	}
+
+	required := []struct{ name, param string }{
+		{"GetUserByID", "id"},
+		{"GetUserByEmail", "email"},
+		{"ListUsers", ""},
+	}
+	for _, r := range required {
+		name, param := r.name, r.param
+		pattern := m.Pattern(name)
+		if pattern == nil || pattern.Entity != user {
+			return fmt.Errorf("the generated repositories need a User access pattern named %s", name)
+		}
+		if param == "" && len(pattern.Params) > 0 {
+			return fmt.Errorf("%s must not take parameters", name)
+		}
+		if param != "" && (len(pattern.Params) != 1 || pattern.Params[0].Placeholder != param) {
+			return fmt.Errorf("%s must take exactly {%s}", name, param)
+		}
+		if param != "" && pattern.Operation == "query" && !pattern.Unique {
+			return fmt.Errorf("%s must be unique", name)
+		}
+	}
+
+	return nil
+}
+
+func (m *DataModel) resolve() error {
+	if m.Table == nil || m.Table.PartitionKey == "" {
+		return fmt.Errorf("table.partition_key is required")
+	}
+	if m.Table.Name == "" {
+		m.Table.Name = "main"
+	}
+
+	indexes := make(map[string]*IndexDef)
+	for _, index := range m.Table.Indexes {
+		if index.Name == "" || index.PartitionKey == "" {
+			return fmt.Errorf("every index needs a name and a partition_key")
+		}
+		if !identifierPattern.MatchString(index.GoName()) {
+			return fmt.Errorf("index name %q must be letters, digits, '-' and '_'", index.Name)
+		}
+		if indexes[index.Name] != nil {
+			return fmt.Errorf("index %s is defined twice", index.Name)
+		}
+		indexes[index.Name] = index
+	}
+
+	keyAttrs := make(map[string]bool)
+	for _, attr := range m.KeyAttributes() {
+		keyAttrs[attr] = true
+	}
+
+	patternNames := make(map[string]bool)
+	for _, entity := range m.Entities {
+		if !identifierPattern.MatchString(entity.Name) {
+			return fmt.Errorf("entity name %q must be an exported Go identifier", entity.Name)
+		}
+		if entity.Keys[m.Table.PartitionKey] == "" {
+			return fmt.Errorf("entity %s has no %s key", entity.Name, m.Table.PartitionKey)
+		}
+		if m.Table.SortKey != "" && entity.Keys[m.Table.SortKey] == "" {
+			return fmt.Errorf("entity %s has no %s key", entity.Name, m.Table.SortKey)
+		}
+		for attr := range entity.Keys {
+			if !keyAttrs[attr] {
+				return fmt.Errorf("entity %s sets %s, which is not a table or index key", entity.Name, attr)
+			}
+		}
+
+		entity.KeyAttributes = nil
+		fields := &fieldSet{}
+		for _, attr := range m.KeyAttributes() {
+			raw, ok := entity.Keys[attr]
+			if !ok {
+				continue
+			}
+			tmpl := parseKeyTemplate(raw)
+			fields.add(tmpl.fields...)
+			entity.KeyAttributes = append(entity.KeyAttributes, &KeyAttribute{
+				Attribute: attr,
+				Template:  tmpl,
+				Primary:   attr == m.Table.PartitionKey || attr == m.Table.SortKey,
+			})
+		}
+		entity.Fields = fields.list
+
+		for _, pattern := range entity.AccessPatterns {
+			if !identifierPattern.MatchString(pattern.Name) {
+				return fmt.Errorf("access pattern name %q must be an exported Go identifier", pattern.Name)
+			}
+			if patternNames[pattern.Name] {
+				return fmt.Errorf("access pattern %s is defined twice", pattern.Name)
+			}
+			patternNames[pattern.Name] = true
+
+			if err := pattern.resolve(entity, m.Table, indexes); err != nil {
+				return fmt.Errorf("access pattern %s: %w", pattern.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *AccessPattern) resolve(entity *Entity, table *TableDef, indexes map[string]*IndexDef) error {
+	p.Entity = entity
+	p.PartitionKey, p.SortKey = table.PartitionKey, table.SortKey
+
+	if p.Index == "table" {
+		p.Index = ""
+	}
+	if p.Index != "" {
+		index, ok := indexes[p.Index]
+		if !ok {
+			return fmt.Errorf("unknown index %s", p.Index)
+		}
+		p.IndexDef = index
+		p.PartitionKey, p.SortKey = index.PartitionKey, index.SortKey
+	}
+
+	pk, ok := entity.Keys[p.PartitionKey]
+	if !ok {
+		return fmt.Errorf("entity %s has no %s key", entity.Name, p.PartitionKey)
+	}
+	p.PK = parseKeyTemplate(pk)
+	params := &fieldSet{}
+	params.add(p.PK.fields...)
+
+	if p.SortKeyPrefix != "" {
+		if p.SortKey == "" {
+			return fmt.Errorf("sort_key_prefix needs an index with a sort key")
+		}
+		p.Prefix = parseKeyTemplate(p.SortKeyPrefix)
+		params.add(p.Prefix.fields...)
+	}
+	p.Params = params.list
+
+	// A pattern on the table whose sort key is fully determined by the
+	// partition key parameters is a single GetItem
+	p.Operation = "query"
+	if p.Index == "" && p.Prefix == nil {
+		p.Operation = "get"
+		if p.SortKey != "" {
+			p.SK = parseKeyTemplate(entity.Keys[p.SortKey])
+			for _, field := range p.SK.fields {
+				if !params.has(field.Placeholder) {
+					p.Operation = "query"
+					p.SK = nil
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// GoName returns the name of the generated index constant, e.g. IndexGsi1
+func (i *IndexDef) GoName() string {
+	return "Index" + exportedName(i.Name)
+}
+
+// FieldName returns the field name padded to align the entity's key struct
+func (e *Entity) FieldName(field *KeyField) string {
+	return field.Name + strings.Repeat(" ", e.fieldWidth()-len(field.Name))
+}
+
+// FieldKey returns "Name:" padded to align a composite literal of the
+// entity's key struct
+func (e *Entity) FieldKey(field *KeyField) string {
+	return field.Name + ":" + strings.Repeat(" ", e.fieldWidth()-len(field.Name))
+}
+
+func (e *Entity) fieldWidth() int {
+	width := 0
+	for _, field := range e.Fields {
+		if len(field.Name) > width {
+			width = len(field.Name)
+		}
+	}
+	return width
+}
+
+// InputFunc returns the name of the generated query input builder
+func (p *AccessPattern) InputFunc() string {
+	return strings.ToLower(p.Name[:1]) + p.Name[1:] + "Input"
+}
+
+// Fields returns the placeholders referenced by the template
+func (t *KeyTemplate) Fields() []*KeyField {
+	return t.fields
+}
+
+// GoExpr renders the template as a Go string expression. Placeholders
+// become recv.Field when recv is set and plain parameters otherwise.
+func (t *KeyTemplate) GoExpr(recv string) string {
+	var terms []string
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			if part != "" {
+				terms = append(terms, fmt.Sprintf("%q", part))
+			}
+			continue
+		}
+		field := newKeyField(part)
+		if recv != "" {
+			terms = append(terms, recv+"."+field.Name)
+		} else {
+			terms = append(terms, field.Param)
+		}
+	}
+
+	if len(terms) == 0 {
+		return `""`
+	}
+	return strings.Join(terms, " + ")
+}
+
+// UserExpr returns the expression that fills the field from a user value
+func (f *KeyField) UserExpr(recv string) string {
+	return fmt.Sprintf(userKeyFields[f.Placeholder], recv)
+}
+
+// parseKeyTemplate splits a template into alternating literal and
+// placeholder parts, starting with a literal
+func parseKeyTemplate(raw string) *KeyTemplate {
+	tmpl := &KeyTemplate{Raw: raw}
+	fields := &fieldSet{}
+
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(raw, -1) {
+		tmpl.parts = append(tmpl.parts, raw[last:match[0]], raw[match[2]:match[3]])
+		fields.add(newKeyField(raw[match[2]:match[3]]))
+		last = match[1]
+	}
+	tmpl.parts = append(tmpl.parts, raw[last:])
+	tmpl.fields = fields.list
+
+	return tmpl
+}
+
+func newKeyField(placeholder string) *KeyField {
+	name := exportedName(placeholder)
+
+	param := name
+	if strings.ToUpper(param) == param {
+		param = strings.ToLower(param)
+	} else {
+		param = strings.ToLower(param[:1]) + param[1:]
+	}
+	if token.IsKeyword(param) {
+		param += "Key"
+	}
+
+	return &KeyField{Placeholder: placeholder, Name: name, Param: param}
+}
+
+// exportedName converts a snake or kebab case name to an exported Go name
+func exportedName(s string) string {
+	var name strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' }) {
+		switch word {
+		case "id", "url", "api", "ip":
+			name.WriteString(strings.ToUpper(word))
+		default:
+			name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return name.String()
+}
+
+// fieldSet keeps key fields unique and in first-seen order
+type fieldSet struct {
+	list []*KeyField
+}
+
+func (s *fieldSet) add(fields ...*KeyField) {
+	for _, field := range fields {
+		if !s.has(field.Placeholder) {
+			s.list = append(s.list, field)
+		}
+	}
+}
+
+func (s *fieldSet) has(placeholder string) bool {
+	for _, field := range s.list {
+		if field.Placeholder == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
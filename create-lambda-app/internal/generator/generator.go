@@ -33,6 +33,39 @@ func Generate(config *Config) error {
 		config.Module = fmt.Sprintf("github.com/%s/%s", getGitHubUsername(), config.Name)
 	}
 
+	// REST API Gateway is the default event source
+	if config.APIType == "" {
+		config.APIType = "rest"
+	}
+	if !isAPIType(config.APIType) {
+		return fmt.Errorf("unknown API type: %s (want one of %s)", config.APIType, strings.Join(APITypes, ", "))
+	}
+
+	// Only API projects route requests
+	if config.Router == "" {
+		config.Router = DefaultRouter(config.Architecture)
+	}
+	if !isRouter(config.Router) {
+		return fmt.Errorf("unknown router: %s (want one of %s)", config.Router, strings.Join(Routers, ", "))
+	}
+	if !config.HasFeature("api") {
+		config.Router = "none"
+	}
+
+	// Load the single-table design
+	if config.DataModel == nil {
+		model, err := LoadDataModel(config.AccessPatternsFile)
+		if err != nil {
+			return err
+		}
+		config.DataModel = model
+	}
+	if config.UsesDataModel() {
+		if err := config.DataModel.ValidateForUsers(); err != nil {
+			return fmt.Errorf("invalid access patterns: %w", err)
+		}
+	}
+
 	// Create project directory
 	projectPath := filepath.Join(".", config.Name)
 	if err := os.MkdirAll(projectPath, 0755); err != nil {
@@ -72,6 +105,16 @@ func Generate(config *Config) error {
 		return err
 	}
 
+	// Generate in-memory fakes for tests
+	if err := generateFakes(projectPath, config); err != nil {
+		return err
+	}
+
+	// Generate tests for the selected testing framework
+	if err := generateTests(projectPath, config); err != nil {
+		return err
+	}
+
 	// Create metadata file
 	if err := createMetadataFile(projectPath, config); err != nil {
 		return err
@@ -80,6 +123,24 @@ func Generate(config *Config) error {
 	return nil
 }
 
+func isAPIType(apiType string) bool {
+	for _, t := range APITypes {
+		if t == apiType {
+			return true
+		}
+	}
+	return false
+}
+
+func isRouter(router string) bool {
+	for _, r := range Routers {
+		if r == router {
+			return true
+		}
+	}
+	return false
+}
+
 func generateCleanArchitecture(projectPath string, config *Config) error {
 	dirs := []string{
 		"cmd",
@@ -119,8 +180,16 @@ func generateCleanArchitecture(projectPath string, config *Config) error {
 		"internal/interfaces/lambda/handler.go":     templates.CleanLambdaHandler,
 		"internal/infrastructure/config/config.go":  templates.CleanConfig,
 		"pkg/logger/logger.go":                      templates.Logger,
+		"pkg/logger/redact.go":                      templates.LoggerRedaction,
 		"pkg/errors/errors.go":                      templates.CustomErrors,
 		"pkg/middleware/middleware.go":              templates.Middleware,
+		"pkg/middleware/idempotency.go":             templates.Idempotency,
+		"pkg/middleware/idempotency_memory.go":      templates.IdempotencyMemoryStore,
+		"pkg/middleware/ratelimit.go":               templates.RateLimit,
+		"pkg/middleware/ratelimit_memory.go":        templates.RateLimitMemoryStore,
+		"pkg/middleware/timeout.go":                 templates.Timeout,
+		"pkg/middleware/response_size.go":           templates.ResponseSize,
+		"pkg/middleware/response_offload_s3.go":     templates.ResponseOffloadS3,
 	}
 
 	for path, content := range files {
@@ -156,6 +225,7 @@ func generateSimpleStructure(projectPath string, config *Config) error {
 		"handlers/main.go":     templates.SimpleHandler,
 		"models/models.go":     templates.SimpleModels,
 		"services/service.go":  templates.SimpleService,
+		"services/table.go":    templates.DynamoDBTable,
 		"utils/utils.go":       templates.SimpleUtils,
 		"config/config.go":     templates.SimpleConfig,
 	}
@@ -182,6 +252,7 @@ func generateDDDStructure(projectPath string, config *Config) error {
 		"application/query",
 		"application/handler",
 		"infrastructure/persistence",
+		"infrastructure/outbox",
 		"infrastructure/messaging",
 		"infrastructure/config",
 		"interfaces/lambda",
@@ -205,9 +276,14 @@ func generateDDDStructure(projectPath string, config *Config) error {
 		"domain/valueobject/base.go":            templates.DDDValueObject,
 		"domain/repository/interfaces.go":       templates.DDDRepository,
 		"domain/event/base.go":                  templates.DDDEvent,
+		"domain/event/registry.go":              templates.DDDEventRegistry,
 		"application/command/base.go":           templates.DDDCommand,
 		"application/query/base.go":             templates.DDDQuery,
 		"infrastructure/persistence/dynamodb.go": templates.DDDPersistence,
+		"infrastructure/persistence/table.go":    templates.DynamoDBTable,
+		"infrastructure/outbox/outbox.go":       templates.DDDOutbox,
+		"infrastructure/config/config.go":       templates.DDDConfig,
+		"infrastructure/infrastructure.go":      templates.DDDInfrastructure,
 	}
 
 	for path, content := range files {
@@ -236,6 +312,31 @@ func generateCommonFiles(projectPath string, config *Config) error {
 		"scripts/generate-handler.go": templates.HandlerGenerator,
 		"scripts/local-setup.sh": templates.LocalSetupScript,
 		"test/testutils/utils.go": templates.TestUtils,
+		"pkg/apievent/apievent.go": templates.APIEvent,
+		"pkg/apievent/http.go":     templates.APIEventHTTP,
+		"pkg/tracing/tracing.go":     templates.Tracing,
+		"pkg/tracing/invocation.go":  templates.TracingInvocation,
+		"pkg/tracing/propagation.go": templates.TracingPropagation,
+		"pkg/tracing/aws.go":         templates.TracingAWS,
+		"pkg/metrics/metrics.go":     templates.Metrics,
+		"pkg/metrics/invocation.go":  templates.MetricsInvocation,
+		"pkg/metrics/capture.go":     templates.MetricsCapture,
+		"pkg/problem/problem.go":     templates.Problem,
+		"pkg/resilience/resilience.go": templates.Resilience,
+		"pkg/resilience/clock.go":      templates.ResilienceClock,
+		"pkg/resilience/retry.go":      templates.ResilienceRetry,
+		"pkg/resilience/breaker.go":    templates.ResilienceBreaker,
+		"pkg/resilience/bulkhead.go":   templates.ResilienceBulkhead,
+		"pkg/resilience/aws.go":        templates.ResilienceAWS,
+		"pkg/configsource/configsource.go": templates.ConfigSource,
+		"pkg/configsource/aws.go":          templates.ConfigSourceAWS,
+		"pkg/configsource/file.go":         templates.ConfigSourceFile,
+		"local/ssm.json":                   templates.ConfigSourceLocalSSM,
+		"local/feature-flags.json":         templates.ConfigSourceLocalFlags,
+	}
+
+	if config.HasLocalAPI() {
+		files["cmd/local-api/main.go"] = templates.LocalAPIServer
 	}
 
 	// Create .github/workflows directory
@@ -249,6 +350,20 @@ func generateCommonFiles(projectPath string, config *Config) error {
 		}
 	}
 
+	// Keep the design the table code and IaC were generated from
+	if config.UsesDataModel() {
+		if err := os.WriteFile(filepath.Join(projectPath, "access-patterns.yaml"), config.DataModel.Source, 0644); err != nil {
+			return fmt.Errorf("failed to write access-patterns.yaml: %w", err)
+		}
+	}
+
+	// The local bootstrap is only useful when there are resources to create
+	if config.HasFeature("dynamodb") || config.HasFeature("sqs") || config.HasFeature("s3") {
+		if err := generateFile(filepath.Join(projectPath, "scripts/bootstrap/main.go"), templates.LocalBootstrap, config); err != nil {
+			return err
+		}
+	}
+
 	// Make scripts executable
 	scripts := []string{"scripts/local-setup.sh"}
 	for _, script := range scripts {
@@ -406,23 +521,47 @@ func generateAPIFeature(projectPath string, config *Config) error {
 	switch config.Architecture {
 	case "clean":
 		files = map[string]string{
-			"internal/interfaces/api/router.go":     templates.CleanAPIRouter,
-			"internal/interfaces/api/handlers.go":   templates.CleanAPIHandlers,
-			"internal/interfaces/api/middleware.go": templates.CleanAPIMiddleware,
-			"internal/interfaces/api/responses.go":  templates.CleanAPIResponses,
+			"pkg/middleware/validation.go": templates.OpenAPIValidationMiddleware,
+		}
+		if config.UsesRouter() {
+			handlers := templates.CleanAPIHandlersHTTP
+			if config.Router == "gin" {
+				handlers = templates.CleanAPIHandlers
+			}
+			files["internal/interfaces/api/router.go"] = templates.CleanAPIRouter
+			files["internal/interfaces/api/handlers.go"] = handlers
+			files["internal/interfaces/api/middleware.go"] = templates.CleanAPIMiddleware
+			files["internal/interfaces/api/responses.go"] = templates.CleanAPIResponses
 		}
 	case "simple":
 		files = map[string]string{
-			"handlers/api.go":       templates.SimpleAPIHandler,
 			"models/api_models.go":  templates.SimpleAPIModels,
 			"utils/api_utils.go":    templates.SimpleAPIUtils,
 		}
+		switch config.Router {
+		case "gin":
+			files["handlers/api.go"] = templates.SimpleAPIHandler
+		case "chi", "stdlib":
+			files["handlers/api.go"] = templates.SimpleAPIHandlerHTTP
+		}
 	case "ddd":
 		files = map[string]string{
-			"interfaces/api/router.go":            templates.DDDAPIRouter,
 			"interfaces/api/handlers.go":          templates.DDDAPIHandlers,
 			"application/handler/api_handler.go":  templates.DDDAPIApplicationHandler,
 		}
+		switch config.Router {
+		case "gin":
+			files["interfaces/api/router.go"] = templates.DDDAPIRouter
+		case "chi", "stdlib":
+			files["interfaces/api/router.go"] = templates.DDDAPIRouterHTTP
+		default:
+			files["interfaces/api/router.go"] = templates.DDDAPIRouterEvents
+		}
+	}
+
+	// Builds the router of the chosen library for the routes above
+	if config.UsesRouter() {
+		files["pkg/routing/routing.go"] = templates.Routing
 	}
 
 	for path, content := range files {
@@ -431,10 +570,16 @@ func generateAPIFeature(projectPath string, config *Config) error {
 		}
 	}
 
-	// Generate OpenAPI spec
+	// Generate OpenAPI spec, embedded for request validation
 	if err := generateFile(filepath.Join(projectPath, "docs/openapi.yaml"), templates.OpenAPISpec, config); err != nil {
 		return err
 	}
+	if err := generateFile(filepath.Join(projectPath, "docs/openapi.go"), templates.OpenAPIEmbed, config); err != nil {
+		return err
+	}
+	if err := generateFile(filepath.Join(projectPath, "pkg/openapi/validator.go"), templates.OpenAPIValidator, config); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -447,7 +592,10 @@ func generateDynamoDBFeature(projectPath string, config *Config) error {
 		files = map[string]string{
 			"internal/infrastructure/database/dynamodb.go":     templates.CleanDynamoDBClient,
 			"internal/infrastructure/database/repository.go":   templates.CleanDynamoDBRepository,
+			"internal/infrastructure/database/table.go":        templates.DynamoDBTable,
 			"internal/domain/repositories/user_repository.go":  templates.CleanUserRepository,
+			"pkg/middleware/idempotency_dynamodb.go":           templates.IdempotencyDynamoDBStore,
+			"pkg/middleware/ratelimit_dynamodb.go":             templates.RateLimitDynamoDBStore,
 		}
 	case "simple":
 		files = map[string]string{
@@ -458,6 +606,16 @@ func generateDynamoDBFeature(projectPath string, config *Config) error {
 		files = map[string]string{
 			"infrastructure/persistence/dynamodb_repository.go": templates.DDDDynamoDBRepository,
 			"domain/repository/user_repository.go":              templates.DDDUserRepository,
+			"infrastructure/eventstore/dynamodb.go":             templates.DDDDynamoDBEventStore,
+			"infrastructure/outbox/relay.go":                    templates.DDDOutboxRelay,
+			"infrastructure/outbox/publisher_sqs.go":            templates.DDDOutboxSQSPublisher,
+			"cmd/outbox-relay/main.go":                          templates.DDDOutboxRelayMain,
+		}
+		if config.HasFeature("sns") {
+			files["infrastructure/outbox/publisher_sns.go"] = templates.DDDOutboxSNSPublisher
+		}
+		if config.HasFeature("eventbridge") {
+			files["infrastructure/outbox/publisher_eventbridge.go"] = templates.DDDOutboxEventBridgePublisher
 		}
 	}
 
@@ -503,6 +661,115 @@ func generateSQSFeature(projectPath string, config *Config) error {
 	return nil
 }
 
+func generateFakes(projectPath string, config *Config) error {
+	files := map[string]string{
+		"test/fakes/fakes.go": templates.FakesRecorder,
+	}
+
+	switch config.Architecture {
+	case "clean":
+		files["test/fakes/user_repository.go"] = templates.CleanFakeUserRepository
+		if config.HasFeature("sqs") {
+			files["test/fakes/sqs_client.go"] = templates.CleanFakeSQSClient
+		}
+	case "simple":
+		files["test/fakes/dynamodb.go"] = templates.SimpleFakeDynamoDB
+		if config.HasFeature("sqs") {
+			files["test/fakes/sqs.go"] = templates.SimpleFakeSQS
+		}
+	case "ddd":
+		files["test/fakes/user_repository.go"] = templates.DDDFakeUserRepository
+		files["test/fakes/event_bus.go"] = templates.DDDFakeEventBus
+		files["test/fakes/event_store.go"] = templates.DDDFakeEventStore
+		if config.HasFeature("sqs") {
+			files["test/fakes/event_publisher.go"] = templates.DDDFakeEventPublisher
+		}
+	}
+
+	for path, content := range files {
+		if err := generateFile(filepath.Join(projectPath, path), content, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func generateTests(projectPath string, config *Config) error {
+	files := map[string]string{
+		"test/testutils/utils_test.go": templates.TestUtilsTest,
+		"pkg/apievent/apievent_test.go": templates.APIEventTest,
+		"pkg/tracing/tracing_test.go":   templates.TracingTest,
+		"pkg/metrics/metrics_test.go":   templates.MetricsTest,
+		"pkg/problem/problem_test.go":   templates.ProblemTest,
+		"pkg/resilience/resilience_test.go": templates.ResilienceTest,
+		"pkg/configsource/configsource_test.go": templates.ConfigSourceTest,
+	}
+	if config.HasFeature("api") {
+		files["pkg/openapi/validator_test.go"] = templates.OpenAPIValidatorTest
+	}
+	if config.UsesRouter() {
+		files["pkg/routing/routing_test.go"] = templates.RoutingTest
+	}
+
+	switch config.Architecture {
+	case "clean":
+		files["internal/interfaces/lambda/handler_test.go"] = templates.CleanHandlerTest
+		files["pkg/logger/redact_test.go"] = templates.LoggerRedactionTest
+		files["pkg/middleware/middleware_test.go"] = templates.MiddlewareTest
+		files["pkg/middleware/idempotency_test.go"] = templates.IdempotencyTest
+		files["pkg/middleware/ratelimit_test.go"] = templates.RateLimitTest
+		files["pkg/middleware/timeout_test.go"] = templates.TimeoutTest
+		files["pkg/middleware/response_size_test.go"] = templates.ResponseSizeTest
+		if config.HasFeature("dynamodb") {
+			files["internal/infrastructure/database/repository_test.go"] = templates.CleanRepositoryTest
+		}
+		if config.HasFeature("sqs") {
+			files["internal/usecases/process_message_test.go"] = templates.CleanProcessMessageTest
+			files["internal/interfaces/lambda/sqs_handler_test.go"] = templates.CleanSQSHandlerTest
+		}
+	case "simple":
+		files["handlers/main_test.go"] = templates.SimpleHandlerTest
+		files["test/fakes/dynamodb_test.go"] = templates.SimpleFakeDynamoDBTest
+		if config.HasFeature("dynamodb") {
+			files["services/service_test.go"] = templates.SimpleServiceTest
+		}
+		if config.HasFeature("sqs") {
+			files["handlers/sqs_test.go"] = templates.SimpleSQSHandlerTest
+		}
+	case "ddd":
+		files["domain/aggregate/user_test.go"] = templates.DDDAggregateTest
+		files["application/command/create_user_test.go"] = templates.DDDCommandTest
+		files["infrastructure/persistence/dynamodb_test.go"] = templates.DDDPersistenceTest
+		if config.HasFeature("dynamodb") {
+			files["infrastructure/outbox/relay_test.go"] = templates.DDDOutboxRelayTest
+		}
+		if config.HasFeature("sqs") {
+			files["interfaces/lambda/sqs_handler_test.go"] = templates.DDDSQSHandlerTest
+		}
+	}
+
+	for path, content := range files {
+		if err := generateFile(filepath.Join(projectPath, path), content, config); err != nil {
+			return err
+		}
+	}
+
+	// Ginkgo needs a suite bootstrap in every package that has specs
+	if config.TestingFramework == "ginkgo" {
+		for path := range files {
+			dir := filepath.Dir(path)
+			pkg := filepath.Base(dir)
+			suite := fmt.Sprintf(templates.GinkgoSuite, pkg, strings.ToUpper(pkg[:1])+pkg[1:])
+			if err := generateFile(filepath.Join(projectPath, dir, "suite_test.go"), suite, config); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func generateFile(path, templateContent string, config *Config) error {
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(path)
@@ -539,17 +806,12 @@ func generateFile(path, templateContent string, config *Config) error {
 }
 
 func createMetadataFile(projectPath string, config *Config) error {
-	metadata := fmt.Sprintf(`{
-  "generator": "create-lambda-app",
-  "version": "1.0.0",
-  "created": "%s",
-  "architecture": "%s",
-  "deployment": "%s",
-  "features": %v,
-  "testing": "%s"
-}`, time.Now().Format(time.RFC3339), config.Architecture, config.DeploymentTool, config.GetEnabledFeatures(), config.TestingFramework)
-
-	return os.WriteFile(filepath.Join(projectPath, ".create-lambda-app"), []byte(metadata), 0644)
+	metadata, err := projectMetadataFor(config, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to encode project metadata: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(projectPath, MetadataFile), metadata, 0644)
 }
 
 // InitGit initializes a git repository in the project directory
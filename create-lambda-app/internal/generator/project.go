@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MetadataFile records how a project was generated, so later commands such
+// as "generate api" produce code matching it
+const MetadataFile = ".create-lambda-app"
+
+// projectMetadata is the content of MetadataFile
+type projectMetadata struct {
+	Generator    string   `json:"generator"`
+	Version      string   `json:"version"`
+	Created      string   `json:"created"`
+	Name         string   `json:"name,omitempty"`
+	Architecture string   `json:"architecture"`
+	Deployment   string   `json:"deployment"`
+	Features     []string `json:"features"`
+	Testing      string   `json:"testing"`
+	APIType      string   `json:"api_type"`
+	Router       string   `json:"router"`
+}
+
+// Earlier versions wrote the features as a Go slice, e.g. [api dynamodb]
+var legacyFeaturesPattern = regexp.MustCompile(`"features":\s*\[([^\]"]*)\]`)
+
+// LoadProject reads the configuration of a generated project from its
+// metadata file and go.mod
+func LoadProject(projectPath string) (*Config, error) {
+	data, err := os.ReadFile(filepath.Join(projectPath, MetadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s is not a create-lambda-app project (no %s file)", projectPath, MetadataFile)
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", MetadataFile, err)
+	}
+
+	data = legacyFeaturesPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		list := legacyFeaturesPattern.FindSubmatch(match)[1]
+		quoted, _ := json.Marshal(strings.Fields(string(list)))
+		return append([]byte(`"features": `), quoted...)
+	})
+
+	var metadata projectMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", MetadataFile, err)
+	}
+
+	module, err := readModulePath(filepath.Join(projectPath, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{
+		Name:             metadata.Name,
+		DeploymentTool:   metadata.Deployment,
+		Architecture:     metadata.Architecture,
+		TestingFramework: metadata.Testing,
+		Features:         make(map[string]bool),
+		Module:           module,
+		APIType:          metadata.APIType,
+		Router:           metadata.Router,
+	}
+	if config.Name == "" {
+		config.Name = filepath.Base(module)
+	}
+	if config.APIType == "" {
+		config.APIType = "rest"
+	}
+	for _, feature := range metadata.Features {
+		config.Features[feature] = true
+	}
+	if config.Router == "" {
+		// Projects generated before --router existed
+		config.Router = DefaultRouter(config.Architecture)
+		if !config.HasFeature("api") {
+			config.Router = "none"
+		}
+	}
+
+	return config, nil
+}
+
+// projectMetadataFor returns the metadata recorded for a new project
+func projectMetadataFor(config *Config, created string) ([]byte, error) {
+	features := config.GetEnabledFeatures()
+	sort.Strings(features)
+
+	data, err := json.MarshalIndent(projectMetadata{
+		Generator:    "create-lambda-app",
+		Version:      "1.0.0",
+		Created:      created,
+		Name:         config.Name,
+		Architecture: config.Architecture,
+		Deployment:   config.DeploymentTool,
+		Features:     features,
+		Testing:      config.TestingFramework,
+		APIType:      config.APIType,
+		Router:       config.Router,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func readModulePath(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if module, ok := strings.CutPrefix(line, "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("go.mod has no module directive")
+}
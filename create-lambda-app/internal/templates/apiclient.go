@@ -0,0 +1,1350 @@
+package templates
+
+// Templates for "create-lambda-app generate client". The Go client reuses
+// APIGenTypes for its types; client.gen.go, its tests and src/index.ts are
+// rewritten on every run, the TypeScript package files only created when
+// missing.
+
+const APIClientGo = `// Code generated by create-lambda-app {{.Command}} from {{.SpecFile}}. DO NOT EDIT.
+
+// Package {{.Package}} is a typed client of the {{.Name}} API, with a method
+// per operation:
+//
+//	api := {{.Package}}.New(baseURL, {{.Package}}.WithBearerToken(token))
+//
+// Error responses are returned as *{{.Package}}.Error, which errors.Is matches
+// against the Err variables by type, e.g. errors.Is(err, {{.Package}}.ErrNotFound).
+package {{.Package}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the operations of the {{.Name}} API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	tokens     TokenSource
+	headers    http.Header
+	retry      RetryPolicy
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// TokenSource returns the bearer token of a request, so a token that
+// expires (e.g. a Cognito ID token) can be refreshed
+type TokenSource func(ctx context.Context) (string, error)
+
+// RetryPolicy controls retries. Throttled requests (429) and requests the
+// API did not accept (503) are retried for every method; gateway errors
+// (502, 504) and network errors only for idempotent methods, since a POST
+// or PATCH may already have been processed.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt; 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles on every
+	// retry, with full jitter, up to MaxDelay. A Retry-After header
+	// takes precedence.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is given
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// New creates a client of the API at baseURL
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		headers:    make(http.Header),
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.httpClient = stopAtSeeOther(c.httpClient)
+	return c
+}
+
+// stopAtSeeOther returns a copy of httpClient that does not follow 303 See
+// Other, which the API answers with the presigned URL of a response too
+// large to return, so do fetches it without the client's headers
+func stopAtSeeOther(httpClient *http.Client) *http.Client {
+	client := *httpClient
+	checkRedirect := httpClient.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.Response != nil && req.Response.StatusCode == http.StatusSeeOther {
+			return http.ErrUseLastResponse
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &client
+}
+
+// WithHTTPClient sends requests with httpClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBearerToken sends token in the Authorization header
+func WithBearerToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithTokenSource sends the token of source in the Authorization header
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.tokens = source
+	}
+}
+
+// WithAPIKey sends key in the X-Api-Key header, which API Gateway usage
+// plans and the rate limiter of the API meter
+func WithAPIKey(key string) Option {
+	return WithHeader("X-Api-Key", key)
+}
+
+// WithHeader sends a header with every request, e.g. an API key
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		c.headers.Set(name, value)
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// ErrorType is the type of an API error: the code of its problem details
+type ErrorType string
+
+// Error types of the API
+const (
+	ErrorTypeValidation       ErrorType = "VALIDATION"
+	ErrorTypeUnauthorized     ErrorType = "UNAUTHORIZED"
+	ErrorTypeForbidden        ErrorType = "FORBIDDEN"
+	ErrorTypeNotFound         ErrorType = "NOT_FOUND"
+	ErrorTypeMethodNotAllowed ErrorType = "METHOD_NOT_ALLOWED"
+	ErrorTypeConflict         ErrorType = "CONFLICT"
+	ErrorTypeUnprocessable    ErrorType = "UNPROCESSABLE"
+	ErrorTypeTooManyRequests  ErrorType = "TOO_MANY_REQUESTS"
+	ErrorTypeInternal         ErrorType = "INTERNAL"
+	ErrorTypeNotImplemented   ErrorType = "NOT_IMPLEMENTED"
+	ErrorTypeExternal         ErrorType = "EXTERNAL"
+	ErrorTypeTimeout          ErrorType = "TIMEOUT"
+)
+
+// Error is an error response of the API, read from its RFC 7807 problem
+// details
+type Error struct {
+	StatusCode int
+	Type       ErrorType
+	TypeURI    string
+	Title      string
+	Message    string                 // the problem's detail
+	Details    map[string]interface{} // the problem's extension members
+	RequestID  string                 // the problem's instance
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s (HTTP %d)", e.Type, e.Message, e.StatusCode)
+}
+
+// Is checks if the error is of a specific type, so errors.Is(err,
+// ErrNotFound) matches any not found response
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Type == t.Type
+}
+
+// Targets for errors.Is, one per error type
+var (
+	ErrValidation       = &Error{Type: ErrorTypeValidation}
+	ErrUnauthorized     = &Error{Type: ErrorTypeUnauthorized}
+	ErrForbidden        = &Error{Type: ErrorTypeForbidden}
+	ErrNotFound         = &Error{Type: ErrorTypeNotFound}
+	ErrMethodNotAllowed = &Error{Type: ErrorTypeMethodNotAllowed}
+	ErrConflict         = &Error{Type: ErrorTypeConflict}
+	ErrUnprocessable    = &Error{Type: ErrorTypeUnprocessable}
+	ErrTooManyRequests  = &Error{Type: ErrorTypeTooManyRequests}
+	ErrInternal         = &Error{Type: ErrorTypeInternal}
+	ErrNotImplemented   = &Error{Type: ErrorTypeNotImplemented}
+	ErrExternal         = &Error{Type: ErrorTypeExternal}
+	ErrTimeout          = &Error{Type: ErrorTypeTimeout}
+)
+{{- range .Spec.Operations }}
+
+// {{.Name}} calls {{.Method}} {{.Path}}{{ if .Summary }}: {{.Summary}}{{ end }}
+func (c *Client) {{.ClientSignature}} {
+	{{- if .Input }}
+	if input == nil {
+		input = &{{.Input}}{}
+	}
+	{{- end }}
+	req := newRequest({{.MethodConst}}, {{ printf "%q" .Path }})
+	{{- range .Params }}
+	{{- if eq .In "path" }}
+	req.pathParam({{ printf "%q" .Key }}, input.{{.Name}})
+	{{- else }}
+	req.{{.In}}Param({{ printf "%q" .Key }}, input.{{.Name}}, {{.Required}})
+	{{- end }}
+	{{- end }}
+	{{- if .Body }}
+	{{- if .Body.JSON }}
+	req.jsonBody(input.Body, {{ printf "%q" .Body.ContentType }})
+	{{- else }}
+	req.rawBody(input.Body, {{ printf "%q" .Body.ContentType }})
+	{{- end }}
+	{{- end }}
+	{{- if .Accept }}
+	req.accept = {{ printf "%q" .Accept }}
+	{{- end }}
+	{{- if .Output }}
+
+	var output {{.ClientResult}}
+	if err := c.do(ctx, req, &output); err != nil {
+		return {{.ClientZero}}, err
+	}
+	return {{ if .ClientPointer }}&{{ end }}output, nil
+	{{- else }}
+	return c.do(ctx, req, nil)
+	{{- end }}
+}
+{{- end }}
+
+// request is an API request being built by an operation method
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        []byte
+	contentType string
+	accept      string
+	err         error
+}
+
+func newRequest(method, path string) *request {
+	return &request{method: method, path: path, query: make(url.Values), header: make(http.Header)}
+}
+
+func (r *request) pathParam(name string, value interface{}) {
+	r.path = strings.Replace(r.path, "{"+name+"}", url.PathEscape(formatParam(value)), 1)
+}
+
+// queryParam and headerParam leave out optional parameters with zero
+// values, so the API applies its defaults
+func (r *request) queryParam(name string, value interface{}, required bool) {
+	if required || !reflect.ValueOf(value).IsZero() {
+		r.query.Set(name, formatParam(value))
+	}
+}
+
+func (r *request) headerParam(name string, value interface{}, required bool) {
+	if required || !reflect.ValueOf(value).IsZero() {
+		r.header.Set(name, formatParam(value))
+	}
+}
+
+func (r *request) jsonBody(value interface{}, contentType string) {
+	if v := reflect.ValueOf(value); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		r.err = fmt.Errorf("failed to encode request body: %w", err)
+		return
+	}
+	r.body, r.contentType = data, contentType
+}
+
+func (r *request) rawBody(value, contentType string) {
+	r.body, r.contentType = []byte(value), contentType
+}
+
+// formatParam formats a parameter the way the API's router parses it:
+// arrays are comma separated and times are RFC 3339
+func formatParam(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Slice {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = formatParam(v.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// do sends a request, retrying it according to the retry policy, and
+// decodes a successful response into output
+func (c *Client) do(ctx context.Context, req *request, output interface{}) error {
+	if req.err != nil {
+		return req.err
+	}
+
+	header := c.headers.Clone()
+	for name, values := range req.header {
+		header[name] = values
+	}
+	if req.contentType != "" {
+		header.Set("Content-Type", req.contentType)
+	}
+	if req.accept != "" {
+		header.Set("Accept", req.accept)
+	}
+	if c.tokens != nil {
+		token, err := c.tokens(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	for attempt := 1; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header = header.Clone()
+
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			if ctx.Err() != nil || !idempotent(req.method) || attempt >= c.retry.MaxAttempts {
+				return err
+			}
+			if err := c.wait(ctx, attempt, 0); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response of %s %s: %w", req.method, req.path, err)
+		}
+
+		if location := resp.Header.Get("Location"); resp.StatusCode == http.StatusSeeOther && location != "" {
+			return c.fetchOffloaded(ctx, location, output)
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return decodeOutput(data, output)
+		}
+		if attempt < c.retry.MaxAttempts && retryable(req.method, resp.StatusCode) {
+			if err := c.wait(ctx, attempt, retryAfter(resp.Header)); err != nil {
+				return err
+			}
+			continue
+		}
+		return newError(resp.StatusCode, data)
+	}
+}
+
+// fetchOffloaded reads a body the API answered 303 See Other for, because
+// it was too large for Lambda to return, into output. Its presigned URL
+// carries its own credentials, so none of the client's headers are sent.
+func (c *Client) fetchOffloaded(ctx context.Context, location string, output interface{}) error {
+	if output == nil {
+		return nil
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to fetch offloaded response: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to fetch offloaded response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch offloaded response: HTTP %d", resp.StatusCode)
+	}
+	return decodeOutput(data, output)
+}
+
+// wait sleeps before a retry, or returns early when ctx is done
+func (c *Client) wait(ctx context.Context, attempt int, delay time.Duration) error {
+	if delay <= 0 {
+		backoff := c.retry.BaseDelay << (attempt - 1)
+		if backoff <= 0 || (c.retry.MaxDelay > 0 && backoff > c.retry.MaxDelay) {
+			backoff = c.retry.MaxDelay
+		}
+		if backoff > 0 {
+			delay = time.Duration(rand.Int63n(int64(backoff)))
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	default:
+		return false
+	}
+}
+
+// retryAfter reads a Retry-After header given in seconds or as a date
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+func decodeOutput(data []byte, output interface{}) error {
+	switch out := output.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out = data
+		return nil
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, output); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// errorBody is a problem details document. Errors raised by API Gateway
+// itself only carry a message.
+type errorBody struct {
+	Type     string    ` + "`json:\"type\"`" + `
+	Title    string    ` + "`json:\"title\"`" + `
+	Detail   string    ` + "`json:\"detail\"`" + `
+	Instance string    ` + "`json:\"instance\"`" + `
+	Code     ErrorType ` + "`json:\"code\"`" + `
+	Message  string    ` + "`json:\"message\"`" + `
+}
+
+// problemMembers are the members of a problem document that are not
+// extensions
+var problemMembers = []string{"type", "title", "status", "detail", "instance", "code"}
+
+func newError(status int, data []byte) *Error {
+	apiErr := &Error{
+		StatusCode: status,
+		Type:       errorTypeFor(status),
+		Message:    http.StatusText(status),
+	}
+
+	var body errorBody
+	if json.Unmarshal(data, &body) != nil {
+		return apiErr
+	}
+	if body.Code != "" {
+		apiErr.Type = body.Code
+	}
+	switch {
+	case body.Detail != "":
+		apiErr.Message = body.Detail
+	case body.Title != "":
+		apiErr.Message = body.Title
+	case body.Message != "":
+		apiErr.Message = body.Message
+	}
+	apiErr.TypeURI = body.Type
+	apiErr.Title = body.Title
+	apiErr.RequestID = body.Instance
+
+	var members map[string]interface{}
+	if body.Code != "" && json.Unmarshal(data, &members) == nil {
+		for _, member := range problemMembers {
+			delete(members, member)
+		}
+		if len(members) > 0 {
+			apiErr.Details = members
+		}
+	}
+	return apiErr
+}
+
+// errorTypeFor maps the status of a response without an error type
+func errorTypeFor(status int) ErrorType {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorTypeValidation
+	case http.StatusUnauthorized:
+		return ErrorTypeUnauthorized
+	case http.StatusForbidden:
+		return ErrorTypeForbidden
+	case http.StatusNotFound:
+		return ErrorTypeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorTypeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrorTypeConflict
+	case http.StatusUnprocessableEntity:
+		return ErrorTypeUnprocessable
+	case http.StatusTooManyRequests:
+		return ErrorTypeTooManyRequests
+	case http.StatusNotImplemented:
+		return ErrorTypeNotImplemented
+	case http.StatusBadGateway:
+		return ErrorTypeExternal
+	case http.StatusGatewayTimeout:
+		return ErrorTypeTimeout
+	default:
+		return ErrorTypeInternal
+	}
+}
+`
+
+const APIClientGoTest = `// Code generated by create-lambda-app {{.Command}} from {{.SpecFile}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+// sentRequest is what the API received from the client
+type sentRequest struct {
+	Method string
+	Path   string // as sent, with escapes
+	Query  string
+	Header map[string]string // the sentHeaders that were set
+	Body   string
+}
+
+var sentHeaders = []string{"Authorization", "X-Api-Key", "Content-Type", "Accept", "X-Request-Id", "X-Trace-Id"}
+
+var requestTestCases = []struct {
+	name  string
+	build func() *request
+	opts  []Option
+	want  sentRequest
+}{
+	{
+		name: "escapes path parameters",
+		build: func() *request {
+			req := newRequest(http.MethodGet, "/users/{id}/posts/{postId}")
+			req.pathParam("id", "a b/c")
+			req.pathParam("postId", 42)
+			return req
+		},
+		want: sentRequest{Method: http.MethodGet, Path: "/users/a%20b%2Fc/posts/42"},
+	},
+	{
+		name: "encodes query parameters and leaves out zero optional ones",
+		build: func() *request {
+			req := newRequest(http.MethodGet, "/users")
+			req.queryParam("q", "a&b=c", true)
+			req.queryParam("limit", 0, false)
+			req.queryParam("cursor", "", false)
+			req.queryParam("tags", []string{"x", "y"}, false)
+			req.queryParam("since", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), true)
+			return req
+		},
+		want: sentRequest{Method: http.MethodGet, Path: "/users", Query: "q=a%26b%3Dc&since=2024-01-02T03%3A04%3A05Z&tags=x%2Cy"},
+	},
+	{
+		name: "sends the JSON body, header parameters and credentials",
+		build: func() *request {
+			req := newRequest(http.MethodPost, "/users")
+			req.headerParam("X-Request-Id", "req-1", true)
+			req.headerParam("X-Trace-Id", "", false)
+			req.jsonBody(map[string]string{"name": "Ada"}, "application/json")
+			req.accept = "application/json"
+			return req
+		},
+		opts: []Option{WithBearerToken("token-1"), WithAPIKey("key-1")},
+		want: sentRequest{
+			Method: http.MethodPost,
+			Path:   "/users",
+			Header: map[string]string{
+				"Authorization": "Bearer token-1",
+				"X-Api-Key":     "key-1",
+				"Content-Type":  "application/json",
+				"Accept":        "application/json",
+				"X-Request-Id":  "req-1",
+			},
+			Body: "{\"name\":\"Ada\"}",
+		},
+	},
+}
+
+var errorTestCases = []struct {
+	name   string
+	status int
+	body   string
+	target error
+	want   *Error
+}{
+	{
+		name:   "problem details",
+		status: http.StatusNotFound,
+		body:   "{\"type\":\"https://example.com/problems/not-found\",\"title\":\"Resource not found\",\"status\":404,\"detail\":\"user not found\",\"instance\":\"req-1\",\"code\":\"NOT_FOUND\",\"user_id\":\"user-1\"}",
+		target: ErrNotFound,
+		want: &Error{
+			StatusCode: http.StatusNotFound,
+			Type:       ErrorTypeNotFound,
+			TypeURI:    "https://example.com/problems/not-found",
+			Title:      "Resource not found",
+			Message:    "user not found",
+			Details:    map[string]interface{}{"user_id": "user-1"},
+			RequestID:  "req-1",
+		},
+	},
+	{
+		name:   "API Gateway message",
+		status: http.StatusForbidden,
+		body:   "{\"message\":\"Forbidden\"}",
+		target: ErrForbidden,
+		want:   &Error{StatusCode: http.StatusForbidden, Type: ErrorTypeForbidden, Message: "Forbidden"},
+	},
+	{
+		name:   "body that is not JSON",
+		status: http.StatusBadGateway,
+		body:   "<html>Bad Gateway</html>",
+		target: ErrExternal,
+		want:   &Error{StatusCode: http.StatusBadGateway, Type: ErrorTypeExternal, Message: "Bad Gateway"},
+	},
+}
+
+// roundTrip sends req to an API that answers status and body, without
+// retries, and returns what the API received
+func roundTrip(status int, body string, req *request, output interface{}, opts ...Option) (sentRequest, error) {
+	var sent sentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		sent = sentRequest{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, Body: string(data)}
+		for _, name := range sentHeaders {
+			if value := r.Header.Get(name); value != "" {
+				if sent.Header == nil {
+					sent.Header = make(map[string]string)
+				}
+				sent.Header[name] = value
+			}
+		}
+
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	opts = append(opts, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	err := New(server.URL, opts...).do(context.Background(), req, output)
+	return sent, err
+}
+
+// getOffloaded gets a user from an API that offloads the response, and
+// returns the Authorization header the offloaded body was fetched with
+func getOffloaded(output interface{}) (string, error) {
+	var authorization string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/users/user-1", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/responses/1", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/responses/1", func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_, _ = io.WriteString(w, "{\"id\":\"user-1\"}")
+	})
+
+	err := New(server.URL, WithBearerToken("token-1")).do(context.Background(), newRequest(http.MethodGet, "/users/user-1"), output)
+	return authorization, err
+}
+
+// getUser sends a GET to an API that answers status and body
+func getUser(status int, body string, output interface{}) error {
+	_, err := roundTrip(status, body, newRequest(http.MethodGet, "/users/user-1"), output)
+	return err
+}
+
+// typeCheckTypeScript runs tsc over the TypeScript client generated next
+// to this one. It returns why the check was skipped when the client or tsc
+// is missing.
+func typeCheckTypeScript() (skipped string, output []byte, err error) {
+	dir := filepath.Join("..", "..", "clients", "typescript")
+	if _, err := os.Stat(filepath.Join(dir, "src", "index.ts")); err != nil {
+		return "the TypeScript client was not generated", nil, nil
+	}
+
+	tsc := filepath.Join(dir, "node_modules", ".bin", "tsc")
+	if _, err := os.Stat(tsc); err != nil {
+		if tsc, err = exec.LookPath("tsc"); err != nil {
+			return "tsc is not installed; run npm install in clients/typescript", nil, nil
+		}
+	}
+	output, err = exec.Command(tsc, "--noEmit", "-p", dir).CombinedOutput()
+	return "", output, err
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Client", func() {
+	for _, tc := range requestTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			sent, err := roundTrip(http.StatusNoContent, "", tc.build(), nil, tc.opts...)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sent).To(Equal(tc.want))
+		})
+	}
+
+	It("decodes the response into the output", func() {
+		var output map[string]string
+		err := getUser(http.StatusOK, "{\"id\":\"user-1\"}", &output)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal(map[string]string{"id": "user-1"}))
+	})
+
+	It("fetches an offloaded response without the client's credentials", func() {
+		var output map[string]string
+		authorization, err := getOffloaded(&output)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal(map[string]string{"id": "user-1"}))
+		Expect(authorization).To(BeEmpty())
+	})
+
+	for _, tc := range errorTestCases {
+		tc := tc
+
+		It("decodes an error from "+tc.name, func() {
+			err := getUser(tc.status, tc.body, nil)
+
+			var apiErr *Error
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+			Expect(apiErr).To(Equal(tc.want))
+			Expect(errors.Is(err, tc.target)).To(BeTrue())
+		})
+	}
+
+	It("generates a TypeScript client that type checks", func() {
+		skipped, output, err := typeCheckTypeScript()
+		if skipped != "" {
+			Skip(skipped)
+		}
+
+		Expect(err).NotTo(HaveOccurred(), string(output))
+	})
+})
+{{- else }}
+
+func TestClient_BuildsRequests(t *testing.T) {
+	for _, tc := range requestTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sent, err := roundTrip(http.StatusNoContent, "", tc.build(), nil, tc.opts...)
+{{- if eq .TestingFramework "standard" }}
+			if err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+			if !reflect.DeepEqual(sent, tc.want) {
+				t.Errorf("sent %+v, want %+v", sent, tc.want)
+			}
+{{- else }}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, sent)
+{{- end }}
+		})
+	}
+}
+
+func TestClient_DecodesOutput(t *testing.T) {
+	var output map[string]string
+	err := getUser(http.StatusOK, "{\"id\":\"user-1\"}", &output)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if output["id"] != "user-1" {
+		t.Errorf("output = %v, want id user-1", output)
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "user-1"}, output)
+{{- end }}
+}
+
+func TestClient_FetchesOffloadedOutput(t *testing.T) {
+	var output map[string]string
+	authorization, err := getOffloaded(&output)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if output["id"] != "user-1" {
+		t.Errorf("output = %v, want id user-1", output)
+	}
+	if authorization != "" {
+		t.Errorf("offloaded body fetched with Authorization %q, want none", authorization)
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "user-1"}, output)
+	assert.Empty(t, authorization, "the offloaded body was fetched with the client's credentials")
+{{- end }}
+}
+
+func TestClient_DecodesErrors(t *testing.T) {
+	for _, tc := range errorTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := getUser(tc.status, tc.body, nil)
+
+			var apiErr *Error
+{{- if eq .TestingFramework "standard" }}
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("do() error = %v, want *Error", err)
+			}
+			if !reflect.DeepEqual(apiErr, tc.want) {
+				t.Errorf("error = %+v, want %+v", apiErr, tc.want)
+			}
+			if !errors.Is(err, tc.target) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tc.target)
+			}
+{{- else }}
+			require.True(t, errors.As(err, &apiErr), "do() error = %v, want *Error", err)
+			assert.Equal(t, tc.want, apiErr)
+			assert.True(t, errors.Is(err, tc.target))
+{{- end }}
+		})
+	}
+}
+
+func TestClient_TypeScriptTypeChecks(t *testing.T) {
+	skipped, output, err := typeCheckTypeScript()
+	if skipped != "" {
+		t.Skip(skipped)
+	}
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Errorf("tsc: %v\n%s", err, output)
+	}
+{{- else }}
+	assert.NoError(t, err, string(output))
+{{- end }}
+}
+{{- end }}
+`
+
+const APIClientTypeScript = `// Code generated by create-lambda-app {{.Command}} from {{.SpecFile}}. DO NOT EDIT.
+{{- range .Spec.Types }}
+{{ if .Doc }}
+/**
+{{- range .Doc }}
+ * {{ . }}
+{{- end }}
+ */
+{{- end }}
+{{- if eq .Kind "struct" }}
+export interface {{.Name}}{{.TSExtends}} {
+  {{- range .Fields }}
+  {{- if .Doc }}
+  /** {{ range $i, $line := .Doc }}{{ if $i }} {{ end }}{{ $line }}{{ end }} */
+  {{- end }}
+  {{.TSProperty}}: {{.TSType}};
+  {{- end }}
+}
+{{- else }}
+export type {{.Name}} = {{.TSType}};
+{{- end }}
+{{- end }}
+{{- range .Spec.Operations }}
+{{- if .Input }}
+
+/** Parameters{{ if .Body }} and body{{ end }} of {{.TSMethod}} */
+export interface {{.Input}} {
+  {{- range .Params }}
+  {{- if .Doc }}
+  /** {{.Doc}} */
+  {{- end }}
+  {{.TSProperty}}: {{.TSType}};
+  {{- end }}
+  {{- if .Body }}
+  body{{ if not .Body.Required }}?{{ end }}: {{.Body.TSType}};
+  {{- end }}
+}
+{{- end }}
+{{- end }}
+
+/** Error types of the API: the codes of its problem details */
+export type ErrorType =
+  | 'VALIDATION'
+  | 'UNAUTHORIZED'
+  | 'FORBIDDEN'
+  | 'NOT_FOUND'
+  | 'METHOD_NOT_ALLOWED'
+  | 'CONFLICT'
+  | 'UNPROCESSABLE'
+  | 'TOO_MANY_REQUESTS'
+  | 'INTERNAL'
+  | 'NOT_IMPLEMENTED'
+  | 'EXTERNAL'
+  | 'TIMEOUT';
+
+/** An RFC 7807 problem details document, with every member optional so any error response can be read */
+export interface ProblemDocument {
+  type?: string;
+  title?: string;
+  status?: number;
+  detail?: string;
+  instance?: string;
+  code?: string;
+  [member: string]: unknown;
+}
+
+/** An error response of the API, read from its problem details */
+export class APIError extends Error {
+  readonly status: number;
+  /** The problem's code */
+  readonly type: ErrorType | (string & {});
+  /** The problem's type URI */
+  readonly typeURI?: string;
+  readonly title?: string;
+  /** The problem's extension members */
+  readonly details?: Record<string, unknown>;
+  /** The problem's instance */
+  readonly requestId?: string;
+
+  constructor(status: number, type: string, message: string, problem: ProblemDocument = {}) {
+    super(message);
+    this.name = 'APIError';
+    this.status = status;
+    this.type = type;
+    this.typeURI = problem.type;
+    this.title = problem.title;
+    this.requestId = problem.instance;
+
+    const { type: _type, title: _title, status: _status, detail: _detail, instance: _instance, code: _code, ...details } = problem;
+    if (problem.code !== undefined && Object.keys(details).length > 0) {
+      this.details = details;
+    }
+  }
+
+  /** Reports whether the error has the given type */
+  is(type: ErrorType): boolean {
+    return this.type === type;
+  }
+}
+
+/** Reports whether error is an APIError, of the given type if one is passed */
+export function isAPIError(error: unknown, type?: ErrorType): error is APIError {
+  return error instanceof APIError && (type === undefined || error.type === type);
+}
+
+/**
+ * Throttled requests (429) and requests the API did not accept (503) are
+ * retried for every method; gateway errors (502, 504) and network errors
+ * only for idempotent methods, since a POST or PATCH may already have been
+ * processed.
+ */
+export interface RetryPolicy {
+  /** Includes the first attempt; 1 disables retries */
+  maxAttempts: number;
+  /** Doubles on every retry, with full jitter, up to maxDelayMs. A Retry-After header takes precedence. */
+  baseDelayMs: number;
+  maxDelayMs: number;
+}
+
+export const defaultRetryPolicy: RetryPolicy = {
+  maxAttempts: 3,
+  baseDelayMs: 100,
+  maxDelayMs: 2000,
+};
+
+/** Returns the bearer token of a request, so a token that expires can be refreshed */
+export type TokenSource = () => string | undefined | Promise<string | undefined>;
+
+export interface ClientOptions {
+  baseURL: string;
+  /** Sent in the Authorization header */
+  token?: string | TokenSource;
+  /** Sent in the X-Api-Key header, which API Gateway usage plans and the API's rate limiter meter */
+  apiKey?: string;
+  /** Sent with every request */
+  headers?: Record<string, string>;
+  retry?: Partial<RetryPolicy>;
+  /** Defaults to the global fetch */
+  fetch?: typeof fetch;
+}
+
+export interface RequestOptions {
+  signal?: AbortSignal;
+  headers?: Record<string, string>;
+}
+
+interface PendingRequest {
+  method: string;
+  path: string;
+  query: URLSearchParams;
+  headers: Record<string, string>;
+  body?: string;
+  raw?: boolean;
+}
+
+/** Client of the {{.Name}} API */
+export class {{.ClientName}} {
+  private readonly baseURL: string;
+  private readonly token?: string | TokenSource;
+  private readonly headers: Record<string, string>;
+  private readonly retry: RetryPolicy;
+  private readonly fetch: typeof fetch;
+
+  constructor(options: ClientOptions) {
+    this.baseURL = options.baseURL.replace(/\/+$/, '');
+    this.token = options.token;
+    this.headers = { ...options.headers };
+    if (options.apiKey) {
+      this.headers['X-Api-Key'] = options.apiKey;
+    }
+    this.retry = { ...defaultRetryPolicy, ...options.retry };
+    this.fetch = options.fetch ?? globalThis.fetch.bind(globalThis);
+  }
+{{- range .Spec.Operations }}
+
+  /** {{ if .Summary }}{{.Summary}}: {{ end }}{{.Method}} {{.Path}} */
+  async {{.TSMethod}}({{ if .Input }}input: {{.Input}}{{ if .TSInputOptional }} = {}{{ end }}, {{ end }}options?: RequestOptions): Promise<{{.TSOutput}}> {
+    const request = newRequest('{{.Method}}', '{{.Path}}');
+    {{- range .Params }}
+    {{- if eq .In "path" }}
+    pathParam(request, '{{.Key}}', {{.TSAccess}});
+    {{- else }}
+    {{.In}}Param(request, '{{.Key}}', {{.TSAccess}});
+    {{- end }}
+    {{- end }}
+    {{- if .Body }}
+    {{- if .Body.JSON }}
+    if (input.body !== undefined) {
+      request.body = JSON.stringify(input.body);
+      request.headers['Content-Type'] = '{{.Body.ContentType}}';
+    }
+    {{- else }}
+    if (input.body !== undefined) {
+      request.body = input.body;
+      request.headers['Content-Type'] = '{{.Body.ContentType}}';
+    }
+    {{- end }}
+    {{- end }}
+    {{- if .Accept }}
+    request.headers.Accept = '{{.Accept}}';
+    {{- end }}
+    {{- if .RawOutput }}
+    request.raw = true;
+    {{- end }}
+    {{- if .Output }}
+    return (await this.send(request, options)) as {{.TSOutput}};
+    {{- else }}
+    await this.send(request, options);
+    {{- end }}
+  }
+{{- end }}
+
+  private async send(request: PendingRequest, options: RequestOptions = {}): Promise<unknown> {
+    const query = request.query.toString();
+    const url = this.baseURL + request.path + (query ? '?' + query : '');
+    const headers: Record<string, string> = { ...this.headers, ...request.headers, ...options.headers };
+    const token = typeof this.token === 'function' ? await this.token() : this.token;
+    if (token) {
+      headers.Authorization = 'Bearer ' + token;
+    }
+
+    for (let attempt = 1; ; attempt++) {
+      let response: Response;
+      try {
+        response = await this.fetch(url, { method: request.method, headers, body: request.body, signal: options.signal });
+      } catch (error) {
+        if (options.signal?.aborted || !idempotent(request.method) || attempt >= this.retry.maxAttempts) {
+          throw error;
+        }
+        await this.wait(attempt, 0, options.signal);
+        continue;
+      }
+
+      // fetch follows the 303 See Other the API answers for a response too
+      // large to return, unless it was given redirect: 'manual'
+      const location = response.headers.get('Location');
+      if (response.status === 303 && location) {
+        return this.fetchOffloaded(location, request.raw, options.signal);
+      }
+      if (response.ok) {
+        return parseBody(await response.blob(), request.raw);
+      }
+      if (attempt < this.retry.maxAttempts && retryable(request.method, response.status)) {
+        await this.wait(attempt, retryAfter(response.headers), options.signal);
+        continue;
+      }
+      throw await toAPIError(response);
+    }
+  }
+
+  /**
+   * Fetches a body the API answered 303 See Other for because it was too
+   * large to return. The presigned URL carries its own credentials, so none of the
+   * client's headers are sent.
+   */
+  private async fetchOffloaded(location: string, raw: boolean | undefined, signal?: AbortSignal): Promise<unknown> {
+    const response = await this.fetch(location, { signal });
+    if (!response.ok) {
+      throw new Error('failed to fetch offloaded response: HTTP ' + response.status);
+    }
+    return parseBody(await response.blob(), raw);
+  }
+
+  private wait(attempt: number, delayMs: number, signal?: AbortSignal): Promise<void> {
+    if (delayMs <= 0) {
+      const backoff = Math.min(this.retry.baseDelayMs * 2 ** (attempt - 1), this.retry.maxDelayMs);
+      delayMs = Math.random() * backoff;
+    }
+    return new Promise((resolve, reject) => {
+      const timer = setTimeout(resolve, delayMs);
+      signal?.addEventListener('abort', () => {
+        clearTimeout(timer);
+        reject(signal.reason);
+      }, { once: true });
+    });
+  }
+}
+
+async function parseBody(body: Blob, raw: boolean | undefined): Promise<unknown> {
+  if (raw) {
+    return body;
+  }
+  const text = await body.text();
+  return text ? JSON.parse(text) : undefined;
+}
+
+function newRequest(method: string, path: string): PendingRequest {
+  return { method, path, query: new URLSearchParams(), headers: {} };
+}
+
+/** Formats a parameter the way the API's router parses it: arrays are comma separated */
+function formatParam(value: unknown): string {
+  return Array.isArray(value) ? value.map(String).join(',') : String(value);
+}
+
+function pathParam(request: PendingRequest, name: string, value: unknown): void {
+  request.path = request.path.replace('{' + name + '}', encodeURIComponent(formatParam(value)));
+}
+
+function queryParam(request: PendingRequest, name: string, value: unknown): void {
+  if (value !== undefined && value !== null) {
+    request.query.set(name, formatParam(value));
+  }
+}
+
+function headerParam(request: PendingRequest, name: string, value: unknown): void {
+  if (value !== undefined && value !== null) {
+    request.headers[name] = formatParam(value);
+  }
+}
+
+function idempotent(method: string): boolean {
+  return ['GET', 'HEAD', 'PUT', 'DELETE', 'OPTIONS'].includes(method);
+}
+
+function retryable(method: string, status: number): boolean {
+  if (status === 429 || status === 503) {
+    return true;
+  }
+  return (status === 502 || status === 504) && idempotent(method);
+}
+
+/** Reads a Retry-After header given in seconds or as a date */
+function retryAfter(headers: Headers): number {
+  const value = headers.get('Retry-After');
+  if (!value) {
+    return 0;
+  }
+  const seconds = Number(value);
+  if (!Number.isNaN(seconds)) {
+    return seconds * 1000;
+  }
+  const at = Date.parse(value);
+  return Number.isNaN(at) ? 0 : at - Date.now();
+}
+
+/** Maps the status of a response without an error type */
+function errorTypeFor(status: number): ErrorType {
+  switch (status) {
+    case 400:
+      return 'VALIDATION';
+    case 401:
+      return 'UNAUTHORIZED';
+    case 403:
+      return 'FORBIDDEN';
+    case 404:
+      return 'NOT_FOUND';
+    case 405:
+      return 'METHOD_NOT_ALLOWED';
+    case 409:
+      return 'CONFLICT';
+    case 422:
+      return 'UNPROCESSABLE';
+    case 429:
+      return 'TOO_MANY_REQUESTS';
+    case 501:
+      return 'NOT_IMPLEMENTED';
+    case 502:
+      return 'EXTERNAL';
+    case 504:
+      return 'TIMEOUT';
+    default:
+      return 'INTERNAL';
+  }
+}
+
+/** Builds an APIError from the API's problem details; errors raised by API Gateway itself only carry a message */
+async function toAPIError(response: Response): Promise<APIError> {
+  let body: any;
+  try {
+    body = await response.json();
+  } catch {
+    body = undefined;
+  }
+  const problem: ProblemDocument = body !== null && typeof body === 'object' ? body : {};
+  return new APIError(
+    response.status,
+    problem.code ?? errorTypeFor(response.status),
+    problem.detail ?? problem.title ?? body?.message ?? (response.statusText || 'HTTP ' + response.status),
+    problem,
+  );
+}
+`
+
+const APIClientPackageJSON = `{
+  "name": "{{.Name}}-client",
+  "version": "1.0.0",
+  "description": "TypeScript client of the {{.Name}} API",
+  "type": "module",
+  "main": "dist/index.js",
+  "types": "dist/index.d.ts",
+  "files": [
+    "dist"
+  ],
+  "scripts": {
+    "build": "tsc",
+    "typecheck": "tsc --noEmit"
+  },
+  "devDependencies": {
+    "typescript": "^5.3.0"
+  }
+}
+`
+
+const APIClientTSConfig = `{
+  "compilerOptions": {
+    "target": "ES2020",
+    "module": "ES2020",
+    "moduleResolution": "node",
+    "lib": ["ES2020", "DOM"],
+    "declaration": true,
+    "outDir": "dist",
+    "rootDir": "src",
+    "strict": true,
+    "skipLibCheck": true
+  },
+  "include": ["src"]
+}
+`
@@ -0,0 +1,620 @@
+package templates
+
+// API event source templates. The project is generated for one event source
+// (REST API, HTTP API, function URL or ALB); pkg/apievent aliases its
+// aws-lambda-go types and reads the fields that differ between them, so
+// handlers, middleware and tests are written once.
+
+const APIEvent = `// Package apievent hides which event source invokes the API function. The
+// project was generated for {{.APIType}}: Request and Response are the
+// aws-lambda-go types of that source, and the helpers read the fields that
+// differ between sources.
+package apievent
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if ne .APIType "alb" }}
+	"github.com/google/uuid"
+{{- end }}
+)
+
+// Source is the event source the project was generated for
+const Source = "{{.APIType}}"
+
+// Request is the event the API function receives
+type Request = {{.APIRequestType}}
+
+// Response is what the API function returns
+type Response = {{.APIResponseType}}
+
+// Method returns the HTTP method of the request
+func Method(request Request) string {
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+	return request.RequestContext.HTTP.Method
+{{- else }}
+	return request.HTTPMethod
+{{- end }}
+}
+
+// Path returns the request path without the query string
+func Path(request Request) string {
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+	return request.RawPath
+{{- else }}
+	return request.Path
+{{- end }}
+}
+
+// RequestID returns the ID the event source assigned to the request
+{{- if eq .APIType "alb" }}. ALB
+// requests carry none, so it is always empty and callers fall back to the
+// Lambda request ID.
+func RequestID(request Request) string {
+	return ""
+}
+{{- else }}
+func RequestID(request Request) string {
+	return request.RequestContext.RequestID
+}
+{{- end }}
+
+// UserID returns the ID of the caller the authorizer identified, or ""
+// when the request was not authorized
+{{- if eq .APIType "rest" }}: the "sub" claim of a Cognito authorizer or
+// the principal ID of a Lambda authorizer.
+func UserID(request Request) string {
+	if claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		if sub, ok := claims["sub"].(string); ok && sub != "" {
+			return sub
+		}
+	}
+	principalID, _ := request.RequestContext.Authorizer["principalId"].(string)
+	return principalID
+}
+{{- else if eq .APIType "http" }}: the "sub" claim of a JWT authorizer or
+// the IAM user ID.
+func UserID(request Request) string {
+	authorizer := request.RequestContext.Authorizer
+	switch {
+	case authorizer == nil:
+		return ""
+	case authorizer.JWT != nil:
+		return authorizer.JWT.Claims["sub"]
+	case authorizer.IAM != nil:
+		return authorizer.IAM.UserID
+	}
+	return ""
+}
+{{- else if eq .APIType "function-url" }}: the IAM user ID of
+// a URL with AWS_IAM auth.
+func UserID(request Request) string {
+	if authorizer := request.RequestContext.Authorizer; authorizer != nil && authorizer.IAM != nil {
+		return authorizer.IAM.UserID
+	}
+	return ""
+}
+{{- else }}: the subject an ALB that
+// authenticates users sends in the X-Amzn-Oidc-Identity header.
+func UserID(request Request) string {
+	return Header(request, "X-Amzn-Oidc-Identity")
+}
+{{- end }}
+
+// WithUserID returns request as the event source sends it once its
+// authorizer identified the caller as userID. Tests and the local server
+// use it.
+func WithUserID(request Request, userID string) Request {
+{{- if eq .APIType "rest" }}
+	request.RequestContext.Authorizer = map[string]interface{}{
+		"claims": map[string]interface{}{"sub": userID},
+	}
+{{- else if eq .APIType "http" }}
+	request.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"sub": userID},
+		},
+	}
+{{- else if eq .APIType "function-url" }}
+	request.RequestContext.Authorizer = &events.LambdaFunctionURLRequestContextAuthorizerDescription{
+		IAM: &events.LambdaFunctionURLRequestContextAuthorizerIAMDescription{UserID: userID},
+	}
+{{- else }}
+	headers := make(map[string]string, len(request.Headers)+1)
+	for key, value := range request.Headers {
+		headers[key] = value
+	}
+	headers["x-amzn-oidc-identity"] = userID
+	request.Headers = headers
+{{- end }}
+	return request
+}
+
+// Header looks up a header case-insensitively
+func Header(request Request, name string) string {
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Body returns the request body, decoding it when the source sent it base64
+// encoded
+func Body(request Request) (string, error) {
+	if !request.IsBase64Encoded {
+		return request.Body, nil
+	}
+	body, err := base64.StdEncoding.DecodeString(request.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// Matches reports whether the request has the given method and its path
+// matches pattern, where a segment such as {id} matches any one segment
+func Matches(request Request, method, pattern string) bool {
+	if Method(request) != method {
+		return false
+	}
+	_, ok := matchPath(pattern, Path(request))
+	return ok
+}
+
+// PathParameter returns the path segment pattern names {name}
+{{- if or (eq .APIType "rest") (eq .APIType "http") }}. API
+// Gateway resolves it from its routes; the path is matched as a fallback for
+// requests built without one.
+func PathParameter(request Request, pattern, name string) string {
+	if value := request.PathParameters[name]; value != "" {
+		return value
+	}
+	params, _ := matchPath(pattern, Path(request))
+	return params[name]
+}
+{{- else }}.
+// {{ if eq .APIType "alb" }}ALBs{{ else }}Function URLs{{ end }} do not route, so it is taken from the path.
+func PathParameter(request Request, pattern, name string) string {
+	params, _ := matchPath(pattern, Path(request))
+	return params[name]
+}
+{{- end }}
+
+// NewRequest builds the request the event source sends for method, target
+// (a path with an optional query string) and body. Tests and the local
+// server use it; it assigns a fresh request ID where the source has one.
+func NewRequest(method, target, body string) Request {
+	path, rawQuery, _ := strings.Cut(target, "?")
+	query := make(map[string]string)
+	if values, err := url.ParseQuery(rawQuery); err == nil {
+		for key := range values {
+			query[key] = values.Get(key)
+		}
+	}
+{{- if eq .APIType "http" }}
+
+	request := events.APIGatewayV2HTTPRequest{
+		Version:               "2.0",
+		RouteKey:              "$default",
+		RawPath:               path,
+		RawQueryString:        rawQuery,
+		Headers:               make(map[string]string),
+		QueryStringParameters: query,
+		Body:                  body,
+	}
+	request.RequestContext.RequestID = uuid.New().String()
+	request.RequestContext.Stage = "$default"
+	request.RequestContext.HTTP.Method = method
+	request.RequestContext.HTTP.Path = path
+	request.RequestContext.HTTP.Protocol = "HTTP/1.1"
+	return request
+{{- else if eq .APIType "function-url" }}
+
+	request := events.LambdaFunctionURLRequest{
+		Version:               "2.0",
+		RawPath:               path,
+		RawQueryString:        rawQuery,
+		Headers:               make(map[string]string),
+		QueryStringParameters: query,
+		Body:                  body,
+	}
+	request.RequestContext.RequestID = uuid.New().String()
+	request.RequestContext.HTTP.Method = method
+	request.RequestContext.HTTP.Path = path
+	request.RequestContext.HTTP.Protocol = "HTTP/1.1"
+	return request
+{{- else if eq .APIType "alb" }}
+
+	return events.ALBTargetGroupRequest{
+		HTTPMethod:            method,
+		Path:                  path,
+		Headers:               make(map[string]string),
+		QueryStringParameters: query,
+		Body:                  body,
+	}
+{{- else }}
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:            method,
+		Path:                  path,
+		Headers:               make(map[string]string),
+		QueryStringParameters: query,
+		Body:                  body,
+	}
+	request.RequestContext.RequestID = uuid.New().String()
+	request.RequestContext.Stage = "local"
+	request.RequestContext.HTTPMethod = method
+	request.RequestContext.Path = path
+	return request
+{{- end }}
+}
+
+// NewResponse builds a response with the given status, headers and body
+func NewResponse(statusCode int, headers map[string]string, body string) Response {
+{{- if eq .APIType "alb" }}
+	return Response{
+		StatusCode:        statusCode,
+		StatusDescription: statusDescription(statusCode),
+		Headers:           headers,
+		Body:              body,
+	}
+{{- else }}
+	return Response{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       body,
+	}
+{{- end }}
+}
+
+// matchPath matches path against pattern segment by segment and returns
+// the values of its {name} segments
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			value, err := url.PathUnescape(pathSegments[i])
+			if err != nil {
+				return nil, false
+			}
+			params[strings.Trim(segment, "{}")] = value
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+`
+
+const APIEventHTTP = `package apievent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Serve runs an http.Handler, such as a gin engine, for an event: the event
+// becomes an *http.Request and what the handler writes becomes the Response
+func Serve(ctx context.Context, handler http.Handler, request Request) (Response, error) {
+	httpRequest, err := ToHTTPRequest(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	writer := newResponseWriter()
+	handler.ServeHTTP(writer, httpRequest)
+	return writer.response(), nil
+}
+
+// LocalHandler serves a Lambda API handler over HTTP the way the event
+// source invokes it in AWS, so the function can run locally
+func LocalHandler(handler func(ctx context.Context, request Request) (Response, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, err := FromHTTPRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		requestID := RequestID(request)
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		ctx := lambdacontext.NewContext(r.Context(), &lambdacontext.LambdaContext{AwsRequestID: requestID})
+
+		response, err := handler(ctx, request)
+		if err != nil {
+			// The event source answers a failed invocation with 502
+			log.Error().Err(err).Str("request_id", requestID).Msg("Handler returned an error")
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		if err := WriteResponse(w, response); err != nil {
+			log.Error().Err(err).Str("request_id", requestID).Msg("Failed to write response")
+		}
+	})
+}
+
+// ToHTTPRequest turns an event into the *http.Request it describes
+func ToHTTPRequest(ctx context.Context, request Request) (*http.Request, error) {
+	body, err := Body(request)
+	if err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+
+	target := Path(request)
+	if query := encodeQuery(request.QueryStringParameters); query != "" {
+		target += "?" + query
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, Method(request), target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range request.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+	for _, cookie := range request.Cookies {
+		httpRequest.Header.Add("Cookie", cookie)
+	}
+{{- end }}
+	httpRequest.Host = httpRequest.Header.Get("Host")
+	httpRequest.RequestURI = target
+	return httpRequest, nil
+}
+
+// FromHTTPRequest turns an *http.Request into the event the source would
+// send for it
+func FromHTTPRequest(r *http.Request) (Request, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Request{}, fmt.Errorf("read body: %w", err)
+	}
+
+	request := NewRequest(r.Method, r.URL.RequestURI(), string(body))
+	if !utf8.Valid(body) {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+	for key, values := range r.Header {
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+		if key == "Cookie" {
+			// The source passes cookies separately from the headers
+			request.Cookies = values
+			continue
+		}
+{{- end }}
+		request.Headers[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+	if r.Host != "" {
+		request.Headers["host"] = r.Host
+	}
+	return request, nil
+}
+
+// WriteResponse writes an event response to w
+func WriteResponse(w http.ResponseWriter, response Response) error {
+	for key, value := range response.Headers {
+		w.Header().Set(key, value)
+	}
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+	for _, cookie := range response.Cookies {
+		w.Header().Add("Set-Cookie", cookie)
+	}
+{{- else }}
+	for key, values := range response.MultiValueHeaders {
+		w.Header()[http.CanonicalHeaderKey(key)] = values
+	}
+{{- end }}
+
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			return fmt.Errorf("decode body: %w", err)
+		}
+		body = decoded
+	}
+
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	_, err := w.Write(body)
+	return err
+}
+
+// responseWriter records what an http.Handler writes
+type responseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: make(http.Header)}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+// response converts what was written into a Response
+func (w *responseWriter) response() Response {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	headers := make(map[string]string, len(w.header))
+	for key, values := range w.header {
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+		if key == "Set-Cookie" {
+			continue
+		}
+{{- end }}
+		headers[key] = strings.Join(values, ",")
+	}
+
+	response := NewResponse(w.statusCode, headers, w.body.String())
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+	response.Cookies = w.header.Values("Set-Cookie")
+{{- else if eq .APIType "rest" }}
+	response.MultiValueHeaders = w.header
+{{- end }}
+	if !utf8.Valid(w.body.Bytes()) {
+		response.Body = base64.StdEncoding.EncodeToString(w.body.Bytes())
+		response.IsBase64Encoded = true
+	}
+	return response
+}
+{{- if eq .APIType "alb" }}
+
+// statusDescription is the status line ALB expects, such as "200 OK"
+func statusDescription(statusCode int) string {
+	return fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+}
+{{- end }}
+
+// encodeQuery encodes query parameters in a stable order
+func encodeQuery(params map[string]string) string {
+	values := make(url.Values, len(params))
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+`
+
+const LocalAPIServer = `// Command local-api serves the API function on localhost, translating
+// HTTP requests into {{.APIType}} events the way AWS does:
+//
+//	go run ./cmd/local-api
+//
+// It listens on PORT (default 3000) and reads the same configuration as the
+// deployed function. Set TRACING_EXPORTER=stdout to print the spans of each
+// request.
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog/log"
+{{ if eq .Architecture "clean" }}
+	"{{.Module}}/internal/infrastructure/config"
+	lambdahandler "{{.Module}}/internal/interfaces/lambda"
+{{- else if eq .Architecture "simple" }}
+	"{{.Module}}/config"
+	"{{.Module}}/handlers"
+{{- else }}
+	"{{.Module}}/infrastructure/config"
+	"{{.Module}}/interfaces/api"
+{{- end }}
+	"{{.Module}}/pkg/apievent"
+{{- if ne .Architecture "clean" }}
+	"{{.Module}}/pkg/configsource"
+{{- end }}
+	"{{.Module}}/pkg/metrics"
+{{- if and (ne .Architecture "clean") (.HasFeature "api") }}
+	"{{.Module}}/pkg/openapi"
+{{- end }}
+	"{{.Module}}/pkg/tracing"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+{{- if eq .Architecture "clean" }}
+
+	handler := lambdahandler.APIHandler(cfg)
+{{- else if eq .Architecture "simple" }}
+{{- if .HasFeature "api" }}
+
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+{{- if .UsesRouter }}
+	handler := tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(handlers.APIHandler))))
+{{- else }}
+	handler := tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(handlers.Handler))))
+{{- end }}
+{{- else }}
+
+	handler := tracing.Wrap(metrics.Wrap(configsource.Wrap(handlers.Handler)))
+{{- end }}
+{{- else }}
+
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+	apiHandler, err := api.Bootstrap(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize infrastructure")
+	}
+	handler := tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(apiHandler.HandleRequest))))
+{{- end }}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+
+	addr := "localhost:" + port
+	log.Info().Str("addr", addr).Str("source", apievent.Source).Msg("Serving API locally")
+	if err := http.ListenAndServe(addr, apievent.LocalHandler(handler)); err != nil {
+		log.Fatal().Err(err).Msg("Local API server stopped")
+	}
+}
+`
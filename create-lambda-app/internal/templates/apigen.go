@@ -0,0 +1,393 @@
+package templates
+
+// Templates for "create-lambda-app generate api". types.gen.go and
+// router.gen.go are rewritten on every run; handlers.go and the operation
+// stubs are only created when missing, so implemented operations are kept.
+
+const APIGenTypes = `// Code generated by create-lambda-app {{.Command}} from {{.SpecFile}}. DO NOT EDIT.
+
+package {{.Package}}
+{{- if or (.Spec.UsesPackage "json") (.Spec.UsesPackage "time") }}
+
+import (
+	{{- if .Spec.UsesPackage "json" }}
+	"encoding/json"
+	{{- end }}
+	{{- if .Spec.UsesPackage "time" }}
+	"time"
+	{{- end }}
+)
+{{- end }}
+{{- range .Spec.Types }}
+
+{{- if .Doc }}
+{{ range .Doc }}
+// {{ . }}
+{{- end }}
+{{- else }}
+
+// {{.Name}} is a schema of the API spec
+{{- end }}
+{{- if eq .Kind "struct" }}
+type {{.Name}} struct {
+	{{- range .Embeds }}
+	{{ . }}
+	{{- end }}
+	{{- range .Fields }}
+	{{- range .Doc }}
+	// {{ . }}
+	{{- end }}
+	{{.Name}} {{.Type}} {{.Tag}}
+	{{- end }}
+}
+{{- else if eq .Kind "enum" }}
+type {{.Name}} {{.Base}}
+
+// {{.Name}} values
+const (
+	{{- $type := .Name }}
+	{{- range .Values }}
+	{{.Name}} {{$type}} = {{.Value}}
+	{{- end }}
+)
+{{- else }}
+type {{.Name}} {{.Base}}
+{{- end }}
+{{- end }}
+{{- range .Spec.Operations }}
+{{- if .Input }}
+
+// {{.Input}} holds the parameters{{ if .Body }} and body{{ end }} of {{.Name}}
+type {{.Input}} struct {
+	{{- range .Params }}
+	// {{.Name}} is the {{.Key}} {{.In}} parameter{{ if .Doc }}: {{.Doc}}{{ end }}
+	{{.Name}} {{.Type}}
+	{{- end }}
+	{{- if .Body }}
+	// Body is the request body
+	Body {{.Body.Type}}
+	{{- end }}
+}
+{{- end }}
+{{- end }}
+`
+
+const APIGenRouter = `// Code generated by create-lambda-app {{.Command}} from {{.SpecFile}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/problem"
+)
+
+// Operations are the operations of {{.SpecFile}}. Handlers implements
+// them; "create-lambda-app generate api" adds a stub for every operation
+// added to the spec.
+type Operations interface {
+	{{- range .Spec.Operations }}
+	// {{.Name}} handles {{.Method}} {{.Path}}
+	{{.Signature}}
+	{{- end }}
+}
+
+// Router routes API requests to the operations. HandleRequest has the
+// signature of the other API handlers, so it takes the same middleware.
+type Router struct {
+	operations Operations
+}
+
+// NewRouter creates a router serving operations
+func NewRouter(operations Operations) *Router {
+	return &Router{operations: operations}
+}
+
+// HandleRequest decodes the request for its operation and encodes the
+// result. Routes are tried in the spec's order, literal segments first.
+func (r *Router) HandleRequest(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	switch {
+	{{- range .Spec.Operations }}
+	case apievent.Matches(request, {{.MethodConst}}, {{printf "%q" .Path}}):
+		return r.{{.Handler}}(ctx, request)
+	{{- end }}
+	}
+
+	// Matching with the request's own method compares the path alone
+	for _, path := range paths {
+		if apievent.Matches(request, apievent.Method(request), path) {
+			return problem.Response(ctx, request, problem.New(problem.TypeMethodNotAllowed, "Method not allowed")), nil
+		}
+	}
+	return problem.Response(ctx, request, problem.New(problem.TypeNotFound, "Route not found")), nil
+}
+
+// paths are the paths of the spec
+var paths = []string{
+	{{- range .Spec.Paths }}
+	{{printf "%q" .}},
+	{{- end }}
+}
+{{- range $op := .Spec.Operations }}
+
+func (r *Router) {{.Handler}}(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	{{- if .Input }}
+	input := &{{.Input}}{}
+	{{- range .Params }}
+	{{- if .Default }}
+	input.{{.Name}} = {{.Default}}
+	{{- end }}
+	if value := {{.Source $op.Path}}; value != "" {
+		if err := decodeParam(value, &input.{{.Name}}); err != nil {
+			return badRequest(ctx, request, {{printf "%q" .In}}, {{printf "%q" .Key}}, err.Error())
+		}
+	}
+	{{- if .Required }} else {
+		return badRequest(ctx, request, {{printf "%q" .In}}, {{printf "%q" .Key}}, "is required")
+	}
+	{{- end }}
+	{{- end }}
+	{{- if .Body }}
+	{{- if .Body.JSON }}
+	if err := decodeBody(request, &input.Body, {{.Body.Required}}); err != nil {
+		return badRequest(ctx, request, "body", "", err.Error())
+	}
+	{{- else }}
+	body, err := apievent.Body(request)
+	if err != nil {
+		return badRequest(ctx, request, "body", "", err.Error())
+	}
+	input.Body = body
+	{{- end }}
+	{{- end }}
+{{ end }}
+	{{- $args := "ctx" }}
+	{{- if .Input }}{{ $args = "ctx, input" }}{{ end }}
+	{{- if .RawOutput }}
+	response, err := r.operations.{{.Name}}({{$args}})
+	if err != nil {
+		return failure(ctx, request, err)
+	}
+	return response, nil
+	{{- else if .Output }}
+	output, err := r.operations.{{.Name}}({{$args}})
+	if err != nil {
+		return failure(ctx, request, err)
+	}
+	return jsonResponse({{.StatusConst}}, output)
+	{{- else }}
+	if err := r.operations.{{.Name}}({{$args}}); err != nil {
+		return failure(ctx, request, err)
+	}
+	return apievent.NewResponse({{.StatusConst}}, nil, ""), nil
+	{{- end }}
+}
+{{- end }}
+
+// Error is an error an operation returns to answer with a given status.
+// A *problem.Problem is answered as it is
+{{- if eq .Architecture "clean" }}, and so are the errors of
+// pkg/errors
+{{- end }}; any other error is logged and answered with a 500.
+type Error struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError creates an error answered with statusCode, e.g.
+// NewError(http.StatusNotFound, "NOT_FOUND", "User not found")
+func NewError(statusCode int, errorType, message string) *Error {
+	return &Error{StatusCode: statusCode, Type: errorType, Message: message}
+}
+
+// ErrNotImplemented is returned by operation stubs until they are
+// implemented
+var ErrNotImplemented = NewError(http.StatusNotImplemented, "NOT_IMPLEMENTED", "Operation is not implemented yet")
+
+// failure answers an operation's error as a problem document
+func failure(ctx context.Context, request apievent.Request, err error) (apievent.Response, error) {
+	var opErr *Error
+	if errors.As(err, &opErr) {
+		p := problem.New(problem.Type(opErr.Type), opErr.Message)
+		p.Status = opErr.StatusCode
+		return problem.Response(ctx, request, p), nil
+	}
+
+	p := problem.FromError(err)
+	if p.Type == problem.TypeInternal {
+		log.Error().Err(err).Msg("Unexpected error in operation")
+	}
+	return problem.Response(ctx, request, p), nil
+}
+
+// fieldError matches the validation errors of pkg/openapi
+type fieldError struct {
+	In     string ` + "`" + `json:"in"` + "`" + `
+	Name   string ` + "`" + `json:"name,omitempty"` + "`" + `
+	Reason string ` + "`" + `json:"reason"` + "`" + `
+}
+
+func badRequest(ctx context.Context, request apievent.Request, in, name, reason string) (apievent.Response, error) {
+	p := problem.New(problem.TypeValidation, "Request does not match the API specification").
+		With("errors", []fieldError{fieldErr(in, name, reason)})
+	return problem.Response(ctx, request, p), nil
+}
+
+func fieldErr(in, name, reason string) fieldError {
+	return fieldError{In: in, Name: name, Reason: reason}
+}
+
+func jsonResponse(statusCode int, output interface{}) (apievent.Response, error) {
+	body, err := json.Marshal(output)
+	if err != nil {
+		return apievent.Response{}, fmt.Errorf("failed to encode response: %w", err)
+	}
+	return apievent.NewResponse(statusCode, map[string]string{"Content-Type": "application/json"}, string(body)), nil
+}
+
+// decodeBody decodes a JSON request body into target
+func decodeBody(request apievent.Request, target interface{}, required bool) error {
+	body, err := apievent.Body(request)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(body) == "" {
+		if required {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+	if err := json.Unmarshal([]byte(body), target); err != nil {
+		return fmt.Errorf("is not valid: %w", err)
+	}
+	return nil
+}
+
+// decodeParam parses a parameter into target, a pointer to its field.
+// Array parameters are comma-separated.
+func decodeParam(value string, target interface{}) error {
+	field := reflect.ValueOf(target).Elem()
+	if field.Kind() != reflect.Slice {
+		return decodeScalar(value, field)
+	}
+
+	items := strings.Split(value, ",")
+	slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+	for i, item := range items {
+		if err := decodeScalar(strings.TrimSpace(item), slice.Index(i)); err != nil {
+			return err
+		}
+	}
+	field.Set(slice)
+	return nil
+}
+
+func decodeScalar(value string, field reflect.Value) error {
+	if field.Type() == reflect.TypeOf(time.Time{}) {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("must be an RFC 3339 date-time")
+		}
+		field.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetFloat(parsed)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(parsed)
+	default:
+		return fmt.Errorf("has an unsupported type %s", field.Type())
+	}
+	return nil
+}
+`
+
+const APIGenHandlers = `package {{.Package}}
+
+{{- if eq .Architecture "clean" }}
+
+// Handlers implements the operations of {{.SpecFile}}, one file per
+// operation. Keep them thin: call a use case and return its result.
+// Errors from pkg/errors are answered with their status.
+{{- else if eq .Architecture "ddd" }}
+
+// Handlers implements the operations of {{.SpecFile}}, one file per
+// operation, by dispatching commands and queries to the application layer.
+{{- else }}
+
+// Handlers implements the operations of {{.SpecFile}}, one file per
+// operation, by calling the services package.
+{{- end }}
+type Handlers struct {
+	// Add the dependencies the operations need
+}
+
+// Handlers must implement every operation of the spec
+var _ Operations = (*Handlers)(nil)
+
+// NewHandlers creates the handlers
+func NewHandlers() *Handlers {
+	return &Handlers{}
+}
+`
+
+const APIGenStub = `package {{.Package}}
+
+import (
+	{{- range .Operation.Imports }}
+	"{{ . }}"
+	{{- end }}
+	{{- if .Operation.RawOutput }}
+
+	"{{.Module}}/pkg/apievent"
+	{{- end }}
+)
+
+// {{.Operation.Name}} handles {{.Operation.Method}} {{.Operation.Path}}{{ if .Operation.Summary }}: {{.Operation.Summary}}{{ end }}
+func (h *Handlers) {{.Operation.Signature}} {
+	{{- $result := "" }}
+	{{- if .Operation.Output }}{{ $result = " and return its result" }}{{ end }}
+	{{- if eq .Architecture "clean" }}
+	// TODO: call a use case{{ $result }}
+	{{- else if eq .Architecture "ddd" }}
+	// TODO: dispatch a command or query{{ $result }}
+	{{- else }}
+	// TODO: call a service{{ $result }}
+	{{- end }}
+	return {{.Operation.Returns}}ErrNotImplemented
+}
+`
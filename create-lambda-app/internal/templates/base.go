@@ -4,15 +4,24 @@ package templates
 
 const GoMod = `module {{.Module}}
 
-go 1.21
+go {{.GoVersion}}
 
 require (
 	github.com/aws/aws-lambda-go v1.41.0
 	github.com/aws/aws-sdk-go-v2 v1.24.0
 	github.com/aws/aws-sdk-go-v2/config v1.26.1
-	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.26.0
+	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.12.11
+	github.com/aws/aws-sdk-go-v2/service/appconfigdata v1.11.4
+	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.26.5
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.47.0
-	github.com/aws/aws-sdk-go-v2/service/sqs v1.28.0
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.29.5
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.44.5
+	{{- if .HasFeature "sns" }}
+	github.com/aws/aws-sdk-go-v2/service/sns v1.26.0
+	{{- end }}
+	{{- if .HasFeature "eventbridge" }}
+	github.com/aws/aws-sdk-go-v2/service/eventbridge v1.26.0
+	{{- end }}
 	github.com/aws/smithy-go v1.19.0
 	github.com/caarlos0/env/v10 v10.0.0
 	github.com/google/uuid v1.5.0
@@ -26,9 +35,14 @@ require (
 	github.com/onsi/gomega v1.30.0
 	{{- end }}
 	{{- if .HasFeature "api" }}
+	github.com/getkin/kin-openapi v0.128.0
+	{{- end }}
+	{{- if and .UsesRouter (eq .Router "gin") }}
 	github.com/gin-gonic/gin v1.9.1
 	github.com/swaggo/swag v1.16.2
 	github.com/swaggo/gin-swagger v1.6.0
+	{{- else if and .UsesRouter (eq .Router "chi") }}
+	github.com/go-chi/chi/v5 v5.1.0
 	{{- end }}
 	{{- if .HasFeature "cognito" }}
 	github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider v1.31.0
@@ -36,12 +50,16 @@ require (
 	{{- if .HasFeature "secrets" }}
 	github.com/aws/aws-sdk-go-v2/service/secretsmanager v1.25.0
 	{{- end }}
+	go.opentelemetry.io/contrib/propagators/aws v1.20.0
 	go.opentelemetry.io/otel v1.21.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.21.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.21.0
+	go.opentelemetry.io/otel/sdk v1.21.0
 	go.opentelemetry.io/otel/trace v1.21.0
 )
 `
 
-const Makefile = `.PHONY: build test clean deploy run-local generate-handler lint fmt
+const Makefile = `.PHONY: build test clean deploy run-local local-api generate-handler lint fmt local-up local-down local-bootstrap
 
 # Variables
 BINARY_NAME={{.Name}}
@@ -62,7 +80,7 @@ build:
 	@mkdir -p build
 	{{- if eq .Architecture "clean" }}
 	@for dir in cmd/*; do \
-		if [ -d "$$dir" ]; then \
+		if [ -d "$$dir" ] && [ "$$dir" != "cmd/local-api" ]; then \
 			func=$$(basename $$dir); \
 			echo "Building $$func..."; \
 			GOOS=$(GOOS) GOARCH=$(GOARCH) CGO_ENABLED=$(CGO_ENABLED) go build -tags lambda.norpc -o build/$$func/bootstrap $$dir/main.go; \
@@ -80,7 +98,7 @@ build:
 	done
 	{{- else }}
 	@for dir in cmd/*; do \
-		if [ -d "$$dir" ]; then \
+		if [ -d "$$dir" ] && [ "$$dir" != "cmd/local-api" ]; then \
 			func=$$(basename $$dir); \
 			echo "Building $$func..."; \
 			GOOS=$(GOOS) GOARCH=$(GOARCH) CGO_ENABLED=$(CGO_ENABLED) go build -tags lambda.norpc -o build/$$func/bootstrap $$dir/main.go; \
@@ -134,13 +152,41 @@ generate-handler:
 # Run locally with SAM
 run-local:
 	@echo "$(GREEN)Starting local development server...$(NC)"
-	{{- if eq .DeploymentTool "sam" }}
+	{{- if and (eq .DeploymentTool "sam") (or (eq .APIType "rest") (eq .APIType "http")) }}
 	@sam local start-api --env-vars .env.local
-	{{- else if eq .DeploymentTool "serverless" }}
+	{{- else if and (eq .DeploymentTool "serverless") (or (eq .APIType "rest") (eq .APIType "http")) }}
 	@serverless offline
+	{{- else if .HasLocalAPI }}
+	@$(MAKE) local-api
 	{{- else }}
 	@echo "$(RED)Local development not configured for {{.DeploymentTool}}$(NC)"
 	{{- end }}
+{{- if .HasLocalAPI }}
+
+# Serve the API function on localhost as {{.APIType}} events, without SAM or Serverless
+local-api:
+	@echo "$(GREEN)Serving the API on http://localhost:$${PORT:-3000}...$(NC)"
+	@go run ./cmd/local-api
+{{- end }}
+
+# Start the local AWS stack and create its resources
+local-up:
+	@echo "$(GREEN)Starting local AWS stack...$(NC)"
+	@docker-compose up -d
+	@$(MAKE) local-bootstrap
+
+# Create the IaC tables, queues and buckets in the local stack
+local-bootstrap:
+	{{- if or (.HasFeature "dynamodb") (.HasFeature "sqs") (.HasFeature "s3") }}
+	@go run ./scripts/bootstrap
+	{{- else }}
+	@echo "$(YELLOW)No local resources to create$(NC)"
+	{{- end }}
+
+# Stop the local AWS stack
+local-down:
+	@echo "$(YELLOW)Stopping local AWS stack...$(NC)"
+	@docker-compose down
 
 # Deploy to development
 deploy-dev:
@@ -230,6 +276,12 @@ help:
 	@echo "  make fmt             - Format code"
 	@echo "  make generate-handler - Generate new Lambda handler"
 	@echo "  make run-local       - Run locally with SAM/Serverless"
+	{{- if .HasLocalAPI }}
+	@echo "  make local-api       - Serve the API function on localhost"
+	{{- end }}
+	@echo "  make local-up        - Start the local AWS stack and create resources"
+	@echo "  make local-bootstrap - Create local tables, queues and buckets"
+	@echo "  make local-down      - Stop the local AWS stack"
 	@echo "  make deploy-dev      - Deploy to development"
 	@echo "  make deploy-staging  - Deploy to staging"
 	@echo "  make deploy-prod     - Deploy to production"
@@ -252,8 +304,16 @@ const README = `# {{.Name}}
 - **Deployment**: {{.DeploymentTool}} for infrastructure management
 - **Testing**: {{.TestingFramework}} for comprehensive testing
 {{- if .HasFeature "api" }}
+{{- if eq .APIType "http" }}
+- **API Gateway HTTP API**: HTTP API with OpenAPI documentation
+{{- else if eq .APIType "function-url" }}
+- **Function URL**: HTTPS endpoint on the function with OpenAPI documentation
+{{- else if eq .APIType "alb" }}
+- **Application Load Balancer**: HTTP API behind an ALB with OpenAPI documentation
+{{- else }}
 - **API Gateway**: RESTful API with OpenAPI documentation
 {{- end }}
+{{- end }}
 {{- if .HasFeature "dynamodb" }}
 - **DynamoDB**: NoSQL database integration
 {{- end }}
@@ -275,7 +335,7 @@ const README = `# {{.Name}}
 
 ## 📋 Prerequisites
 
-- Go 1.21 or higher
+- Go {{.GoVersion}} or higher
 - AWS CLI configured with appropriate credentials
 - {{.DeploymentTool}} installed
 {{- if eq .DeploymentTool "sam" }}
@@ -348,11 +408,271 @@ const README = `# {{.Name}}
 │   ├── lambda/            # Lambda handlers
 │   └── api/               # API handlers
 {{- end }}
+├── local/                 # Stand-ins for SSM Parameter Store and AppConfig
 ├── test/                  # Test files and utilities
 ├── scripts/               # Build and deployment scripts
 ├── deployments/           # Environment-specific configs
+{{- if .UsesDataModel }}
+├── access-patterns.yaml   # DynamoDB single-table design
+{{- end }}
 └── docs/                  # Documentation
 ` + "```" + `
+{{- if .UsesDataModel }}
+
+### Data Model
+
+The DynamoDB table uses a single-table design described in ` + "`access-patterns.yaml`" + `: the table and index keys, the key templates of every entity and the access patterns the application needs. The typed access-pattern methods in ` + "`table.go`" + ` ({{ range $i, $p := .DataModel.AccessPatterns }}{{ if $i }}, {{ end }}` + "`{{ $p.Name }}`" + `{{ end }}) and the table definition in the deployment template are generated from that file, so the code and the infrastructure always agree. To change the design, edit the file and regenerate the project with ` + "`create-lambda-app --access-patterns access-patterns.yaml`" + `.
+
+List endpoints page with cursors rather than page numbers: pass ` + "`limit`" + ` and the ` + "`next_cursor`" + ` of the previous response as ` + "`cursor`" + `. A cursor wraps DynamoDB's ` + "`LastEvaluatedKey`" + `, so deep pages cost the same as the first. Set ` + "`CURSOR_SECRET`" + ` to sign cursors; tampered ones are rejected with 400.
+
+Writes use optimistic locking: every item carries a ` + "`version`" + ` attribute and an update only lands if the stored version is still the one that was read. A request that loses the race gets ` + "`409 Conflict`" + ` and should reload the resource and retry. The fakes in ` + "`test/fakes`" + ` can replay such races with ` + "`BeforeNext`" + `.
+{{- end }}
+{{- if eq .Architecture "ddd" }}
+
+### Event Sourcing
+
+Users are persisted one way: ` + "`persistence.DynamoDBUserRepository`" + ` stores the current state of the aggregate and writes its events to the outbox (below). ` + "`UpdateProfile`" + `, ` + "`ChangeStatus`" + ` and ` + "`Delete`" + ` record domain events, and ` + "`LoadFromHistory`" + ` rebuilds a user by replaying them, for example from the published events. A save that loses a race with another request returns ` + "`repository.ErrVersionConflict`" + `, which the API answers with 409; reload the user and retry the command.
+
+Aggregates you choose to event-source are stored through an ` + "`event.EventStore`" + `, which keeps one stream per aggregate and only appends while the stream is still at the version the aggregate was loaded at. A lost race returns ` + "`event.ErrConcurrencyConflict`" + `.
+{{- if .HasFeature "dynamodb" }}
+
+` + "`eventstore.DynamoDBEventStore`" + ` stores events in the ` + "`EVENT_STORE_TABLE_NAME`" + ` table, keyed on the stream ID and the event's version, and appends in a single transaction. The ` + "`events-by-day`" + ` index serves ` + "`GetEventsSince`" + `, which reads every stream from a point in time, for example to rebuild a projection. A snapshot of the aggregate is stored every ` + "`EVENT_STORE_SNAPSHOT_EVERY`" + ` events so loads only replay the events recorded after it.
+{{- end }}
+
+Tests use the in-memory ` + "`fakes.EventStore`" + `, which enforces the same version checks.
+
+### Outbox
+
+Command handlers never publish events directly. The user repository writes an aggregate's uncommitted events as outbox items in the same ` + "`TransactWriteItems`" + ` call as the aggregate, so an event exists exactly when the change it describes was stored.
+{{- if .HasFeature "dynamodb" }} The ` + "`outbox-relay`" + ` Lambda reads the outbox items from the table's stream and publishes them to every configured target: ` + "`OUTBOX_QUEUE_URL`" + ` (SQS){{ if .HasFeature "sns" }}, ` + "`OUTBOX_TOPIC_ARN`" + ` (SNS){{ end }}{{ if .HasFeature "eventbridge" }}, ` + "`OUTBOX_EVENT_BUS_NAME`" + ` (EventBridge){{ end }}. Outbox items carry an ` + "`expires_at`" + ` attribute, so the table's TTL deletes them a week after they were written.
+
+Delivery is at least once: a failed publish is retried from that stream record, so consumers can see an event twice. Every message carries the event's ` + "`event_id`" + `; FIFO queues and topics use it as the deduplication ID, and other consumers should drop IDs they have already processed.
+{{- end }}
+{{- end }}
+
+
+### API Event Source
+
+The project was generated with ` + "`--api-type {{.APIType}}`" + `. ` + "`pkg/apievent`" + ` aliases the matching aws-lambda-go types as ` + "`apievent.Request`" + ` and ` + "`apievent.Response`" + ` and reads the fields that differ between sources (` + "`apievent.Method`" + `, ` + "`apievent.Path`" + `, ` + "`apievent.Header`" + `, ` + "`apievent.PathParameter`" + `), so handlers, middleware and tests do not depend on the source. ` + "`apievent.NewRequest`" + ` builds the event the source sends, and ` + "`apievent.Serve`" + ` runs an ` + "`http.Handler`" + ` such as a router for an event.
+{{- if .HasLocalAPI }}
+
+` + "`cmd/local-api`" + ` serves the API function on localhost, translating HTTP requests into {{.APIType}} events and responses back the way AWS does. It is not deployed.
+{{- end }}
+{{- if .HasFeature "api" }}
+{{- if eq .APIType "rest" }}
+
+Every route of the REST API requires an API key in the ` + "`X-Api-Key`" + ` header. The deployment creates a key and a usage plan that throttles it and caps its requests per day; API Gateway answers 429 before the function runs once either is used up.
+{{- else if eq .APIType "http" }}
+
+The stage of the HTTP API throttles every route, and API Gateway answers 429 before the function runs once the rate or burst is used up. HTTP APIs have no usage plans or API keys.
+{{- end }}
+
+### Routing
+{{- if .UsesRouter }}
+
+The project was generated with ` + "`--router {{.Router}}`" + `: API requests are routed by {{ if eq .Router "gin" }}gin{{ else if eq .Router "chi" }}chi{{ else }}an ` + "`http.ServeMux`" + ` with Go 1.22 patterns such as ` + "`GET /users/{id}`" + `{{ end }}, which ` + "`apievent.Serve`" + ` runs for each event. ` + "`pkg/routing`" + ` builds the router so unknown routes, methods a route does not allow and panics are answered with problem details. ` + "`routing.Middleware`" + ` is plain ` + "`net/http`" + ` middleware for the routes{{ if eq .Router "gin" }}, which ` + "`routing.Adapt`" + ` turns into gin middleware{{ end }}. Routes are registered in ` + "`{{ if eq .Architecture \"clean\" }}internal/interfaces/api/router.go{{ else if eq .Architecture \"simple\" }}handlers/api.go{{ else }}interfaces/api/router.go{{ end }}`" + `.
+{{- else }}
+
+The project was generated with ` + "`--router none`" + `: handlers dispatch on the event itself with ` + "`apievent.Matches`" + `, so no router library is loaded at cold start. Routes are the cases of the switch in ` + "`{{ if eq .Architecture \"clean\" }}internal/interfaces/lambda/handler.go{{ else if eq .Architecture \"simple\" }}handlers/main.go{{ else }}interfaces/api/router.go{{ end }}`" + `.
+{{- end }}
+{{- end }}
+
+### Tracing
+
+` + "`pkg/tracing`" + ` records OpenTelemetry spans. ` + "`tracing.Init`" + ` installs the tracer provider at startup and ` + "`tracing.Wrap`" + ` runs every invocation in a span that continues the trace of the event: the ` + "`traceparent`" + ` or ` + "`X-Amzn-Trace-Id`" + ` header of an API request, or the segment Lambda starts. Each SQS message is processed in a span that continues the trace it was sent in (` + "`tracing.ProcessSQSMessage`" + `), and outgoing messages carry the trace context in their attributes (` + "`tracing.InjectSQS`" + `). ` + "`tracing.InjectHTTP`" + ` does the same for HTTP calls, and AWS SDK clients built from a config passed through ` + "`tracing.InstrumentAWS`" + ` record a span per call.
+
+` + "`TRACING_EXPORTER`" + ` selects where spans go:
+
+| Exporter | Use |
+|----------|-----|
+| ` + "`xray`" + ` | Deployed functions (default): the ADOT collector layer forwards spans to X-Ray |
+| ` + "`otlp`" + ` | Any OTLP/HTTP collector at ` + "`TRACING_ENDPOINT`" + ` |
+| ` + "`stdout`" + ` | Local development: prints each span |
+| ` + "`memory`" + ` | Tests: read spans with ` + "`tracing.Spans`" + ` |
+| ` + "`none`" + ` | No spans; incoming trace context is still passed on |
+
+Handlers added with ` + "`scripts/generate-handler.go`" + ` call neither; add ` + "`tracing.Init`" + ` and ` + "`tracing.Wrap`" + ` to their ` + "`main`" + ` to trace them.
+
+### Metrics
+
+` + "`pkg/metrics`" + ` records CloudWatch metrics in the Embedded Metric Format: each invocation's metrics are written as one JSON document through the logger, and CloudWatch extracts them from the log stream, so recording a metric makes no API call. ` + "`metrics.Wrap`" + ` flushes a document when an invocation returns, with:
+
+| Metric | Recorded for |
+|--------|--------------|
+| ` + "`Latency`" + `, ` + "`Errors`" + ` | Every invocation |
+| ` + "`ColdStart`" + ` | The first invocation of an execution environment |
+| ` + "`Status2xx`" + `, ` + "`Status4xx`" + `, ` + "`Status5xx`" + ` | API invocations |
+| ` + "`Processed`" + `, ` + "`Failed`" + ` | SQS and DynamoDB Stream batches |
+
+Handlers record their own with the context they are given:
+
+` + "```go" + `
+metrics.Add(ctx, "UsersCreated", metrics.Count, 1)
+` + "```" + `
+
+Metrics are published under ` + "`METRICS_NAMESPACE`" + ` with a ` + "`service`" + ` dimension set to ` + "`APP_NAME`" + `; ` + "`metrics.FromContext(ctx).AddDimension`" + ` adds more. In tests, pass a ` + "`metrics.Capture`" + ` as ` + "`metrics.Options.Output`" + ` to read the documents back. As with tracing, handlers added with ` + "`scripts/generate-handler.go`" + ` need ` + "`metrics.Init`" + ` and ` + "`metrics.Wrap`" + ` in their ` + "`main`" + `.
+
+### Resilience
+
+` + "`pkg/resilience`" + ` makes calls to other services reliable:
+
+| Tool | Does |
+|------|------|
+| ` + "`resilience.Retry`" + ` | Retries throttling, timeouts and 5xx errors with jittered exponential backoff, and gives up before the invocation deadline |
+| ` + "`resilience.Breaker`" + ` | Opens after repeated failures and rejects calls with ` + "`ErrCircuitOpen`" + ` until a trial call succeeds |
+| ` + "`resilience.NewBulkhead`" + ` | Caps the calls to a service in flight at once |
+
+` + "```go" + `
+var usersAPI = resilience.Breaker("users-api", resilience.BreakerSettings{})
+
+err := resilience.Retry(ctx, resilience.RetryPolicy{Name: "users-api"}, func(ctx context.Context) error {
+	return usersAPI.Execute(ctx, func(ctx context.Context) error {
+		return client.Call(ctx)
+	})
+})
+` + "```" + `
+
+Circuit breakers returned by ` + "`resilience.Breaker`" + ` live as long as the execution environment, so a service that failed in one invocation stays cut off in the next ones. Retries, rejections and state changes are logged and recorded as the ` + "`Retries`" + `, ` + "`RetriesExhausted`" + `, ` + "`CircuitBreakerOpened`" + `, ` + "`CircuitBreakerRejected`" + ` and ` + "`BulkheadRejected`" + ` metrics. Wrap errors that retrying cannot fix with ` + "`resilience.Permanent`" + `{{if eq .Architecture "clean"}}; the external and timeout errors of ` + "`pkg/errors`" + ` are retried{{end}}. AWS SDK clients of the project are built from a config passed through ` + "`resilience.InstrumentAWS`" + `, so they retry with the same jittered backoff, up to ` + "`AWS_MAX_ATTEMPTS`" + ` attempts, and count their retries as ` + "`AWSRetries`" + `. In tests, ` + "`resilience.NewFakeClock`" + ` makes backoff and open circuits take no time.
+
+### Configuration Sources
+
+` + "`pkg/configsource`" + ` fills the configuration from SSM Parameter Store and AppConfig as well as the environment. Struct tags say where each field comes from:
+
+` + "```go" + `
+CursorSecret string ` + "`" + `env:"CURSOR_SECRET" ssm:"cursor-secret"` + "`" + `
+DebugLogging bool   ` + "`" + `env:"DEBUG_LOGGING" appconfig:"debugLogging.enabled"` + "`" + `
+` + "```" + `
+
+A field takes the first value it finds: its environment variable unless empty, then AppConfig, then the parameter under ` + "`/{{.Name}}/<environment>`" + ` in Parameter Store, then its ` + "`envDefault`" + `. The deployment creates an AppConfig application with a ` + "`feature-flags`" + ` profile{{if ne .Architecture "ddd"}}, whose ` + "`debugLogging`" + ` flag switches on debug logs without a redeploy,{{end}} and lets the functions read both sources. Warm functions load the configuration again before an invocation once ` + "`CONFIG_REFRESH_INTERVAL`" + ` (default 5m) has passed; a source that fails keeps its last values and counts a ` + "`ConfigRefreshFailures`" + ` metric. A refresh swaps in a new configuration instead of changing the one ` + "`config.Load`" + ` returned, so nothing reads a half-written value; read ` + "`config.Current()`" + ` for the refreshed one. Settings copied out of the configuration at startup, such as middleware options, change at the next cold start. Locally, ` + "`CONFIG_SSM_FILE`" + ` and ` + "`CONFIG_APPCONFIG_FILE`" + ` point at the JSON files in ` + "`local/`" + `, which stand in for the two services.
+
+### Error Responses
+
+API errors are answered with [RFC 7807](https://www.rfc-editor.org/rfc/rfc7807) problem details (` + "`application/problem+json`" + `), rendered by ` + "`pkg/problem`" + `:
+
+` + "```json" + `
+{
+  "type": "https://api.{{.Name}}.com/problems/not-found",
+  "title": "Resource not found",
+  "status": 404,
+  "detail": "user not found",
+  "instance": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+  "code": "NOT_FOUND"
+}
+` + "```" + `
+
+Each ` + "`problem.Type`" + ` has a fixed status, title and ` + "`type`" + ` URI under ` + "`problem.TypeBaseURI`" + `; ` + "`instance`" + ` is the request ID. Handlers answer with ` + "`problem.Response(ctx, request, problem.New(problem.TypeNotFound, \"user not found\"))`" + `, or ` + "`problem.Write`" + ` behind a router, and ` + "`With`" + ` adds extension members. A ` + "`*problem.Problem`" + ` is an error too, and ` + "`problem.FromError`" + ` answers it as it is
+{{- if eq .Architecture "clean" }}, maps an ` + "`AppError`" + ` of ` + "`pkg/errors`" + ` to the problem of its type with its details as extensions,
+{{- end }} and hides anything else behind an ` + "`INTERNAL`" + ` problem.
+{{- if eq .Architecture "clean" }} The ` + "`Recovery`" + ` and ` + "`Idempotency`" + ` middleware answer with problems too.
+{{- end }}
+{{- if .HasFeature "api" }}
+
+### Request Validation
+
+` + "`docs/openapi.yaml`" + ` is embedded in the binary (` + "`docs/openapi.go`" + `) and every API request is checked against it before it reaches a handler: path parameters, query parameters and JSON bodies must match the operation's schema. A request that does not gets a 400 problem document, with one entry per field in ` + "`errors`" + `:
+
+` + "```json" + `
+{
+  "type": "https://api.{{.Name}}.com/problems/validation",
+  "title": "Invalid request",
+  "status": 400,
+  "detail": "Request does not match the API specification",
+  "instance": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+  "code": "VALIDATION",
+  "errors": [
+    {"in": "body", "name": "name", "reason": "minimum string length is 2"}
+  ]
+}
+` + "```" + `
+
+Routes that are not in the spec are passed through unchanged, and credentials are left to the API Gateway authorizers. With ` + "`OPENAPI_VALIDATE_RESPONSES=true`" + ` responses are checked too, and one that breaks the spec is logged and replaced with a 500, so drift between the handlers and the spec shows up in development and tests; it is never applied in production.
+{{- if eq .Architecture "clean" }} ` + "`APIHandler`" + ` adds ` + "`middleware.Validation`" + ` to its chain.
+{{- else }} The API ` + "`main`" + ` wraps its handler with ` + "`validator.Wrap`" + ` from ` + "`pkg/openapi`" + `.
+{{- end }} Keep the spec up to date when adding endpoints.
+
+### Spec-first Operations
+
+The spec can also drive the code. Run the generator from the project root after editing ` + "`docs/openapi.yaml`" + `:
+
+` + "```bash" + `
+create-lambda-app generate api
+` + "```" + `
+
+It writes ` + "`{{ if eq .Architecture \"clean\" }}internal/interfaces/operations{{ else if eq .Architecture \"ddd\" }}interfaces/operations{{ else }}operations{{ end }}`" + `: ` + "`types.gen.go`" + ` with the schemas and operation inputs, ` + "`router.gen.go`" + ` with the ` + "`Operations`" + ` interface and a router that decodes parameters and bodies, and one file per operation whose ` + "`Handlers`" + ` method returns 501 until it is implemented. The ` + "`.gen.go`" + ` files are rewritten on every run; operation files are only created, so the code in them is kept.
+{{- if or (and (eq .Architecture "clean") (or (eq .APIType "rest") (eq .APIType "http")) (ne .DeploymentTool "terraform")) (and (eq .APIType "http") (eq .DeploymentTool "terraform")) }} Routes between the ` + "`BEGIN API routes`" + ` and ` + "`END API routes`" + ` markers of the deployment template are rewritten from the spec too.
+{{- end }} Serve the operations through the usual middleware chain:
+
+` + "```go" + `
+router := operations.NewRouter(operations.NewHandlers())
+handler := chain(router.HandleRequest)
+` + "```" + `
+
+### API Clients
+
+Frontends and other services call the API through typed clients generated from the same spec:
+
+` + "```bash" + `
+create-lambda-app generate client                  # both clients
+create-lambda-app generate client --lang go        # go or typescript
+` + "```" + `
+
+- ` + "`pkg/client`" + `: a Go package with a method per operation, importable as ` + "`{{.Module}}/pkg/client`" + `
+- ` + "`clients/typescript`" + `: a TypeScript package exporting ` + "`{{.ClientName}}`" + `, built with ` + "`npm run build`" + `
+
+Both send a bearer token (` + "`client.WithTokenSource`" + `, or the ` + "`token`" + ` option) and any other auth header, and retry throttled and unavailable requests with exponential backoff; a POST is only retried when the API did not accept it. Error responses become ` + "`*client.Error`" + ` or ` + "`APIError`" + ` carrying the ` + "`code`" + ` of the problem details, so ` + "`errors.Is(err, client.ErrNotFound)`" + ` and ` + "`isAPIError(error, 'NOT_FOUND')`" + ` work as expected. Both clients are rewritten on every run, the Go client with tests of its requests and error decoding; the TypeScript ` + "`package.json`" + ` and ` + "`tsconfig.json`" + ` are only created once.
+{{- end }}
+{{- if eq .Architecture "clean" }}
+
+### Middleware
+
+` + "`pkg/middleware`" + ` is generic over the event type: a ` + "`middleware.Middleware[In, Out]`" + ` wraps a ` + "`middleware.HandlerFunc[In, Out]`" + `, so API, SQS, SNS, S3, DynamoDB Stream, EventBridge and scheduled handlers share the same chain. ` + "`middleware.DefaultChain`" + ` adds tracing, metrics, panic recovery, request and correlation IDs, invocation logging and a timeout:
+
+` + "```go" + `
+handler := middleware.DefaultChain[events.SQSEvent, events.SQSEventResponse]()(processor.HandleRequest)
+lambda.Start(handler)
+` + "```" + `
+
+The correlation ID comes from the ` + "`X-Correlation-ID`" + ` header, the ` + "`correlation_id`" + ` SQS or SNS message attribute, the ` + "`correlation_id`" + ` field of an EventBridge detail or DynamoDB item, or the S3 request ID, and falls back to the Lambda request ID. Both IDs are added to the context logger; use ` + "`middleware.CorrelationID`" + ` to read it from other events.
+
+Handlers get a context that is cancelled ` + "`middleware.DefaultTimeoutMargin`" + ` (500ms) before the Lambda deadline. A handler still running then is abandoned: API requests get a 504 ` + "`TIMEOUT`" + ` problem and other handlers return ` + "`errors.NewTimeoutError`" + `, so the event is retried, and the invocation is still logged, traced and counted, with a ` + "`Timeouts`" + ` metric, before Lambda would have killed it. Pass the context to every downstream call so the abandoned work stops too.
+
+### Log Redaction
+
+` + "`config.Load`" + ` calls ` + "`logger.Init`" + `, which puts a redactor in front of the global logger, so nothing reaches CloudWatch before it is masked. Values of sensitive headers (` + "`Authorization`" + `, cookies, API keys) and JSON fields (` + "`password`" + `, ` + "`token`" + `, ` + "`api_key`" + `, ...) are replaced with ` + "`[REDACTED]`" + ` at any depth of a log line, including request bodies logged as strings, and so are emails, bearer tokens, JWTs and AWS access keys wherever they appear. Add your own rules with ` + "`LOG_REDACT_HEADERS`" + `, ` + "`LOG_REDACT_FIELDS`" + ` (comma-separated) and ` + "`LOG_REDACT_PATTERNS`" + ` (regular expressions separated by semicolons).
+
+### Idempotency
+
+Lambda delivers events at least once, so ` + "`pkg/middleware`" + ` can make handlers safe to run twice. ` + "`middleware.Idempotency`" + `{{ if .HasFeature "dynamodb" }} runs in the default API chain{{ else }} can wrap the API handler given a store every container shares; without the dynamodb feature there is none, so the default API chain leaves it out{{ end }}: a request that repeats an ` + "`Idempotency-Key`" + ` header gets the stored response back with ` + "`Idempotent-Replayed: true`" + `, reusing a key for a different body gets 422, and a duplicate that arrives while the first request is still running gets 409. Keys are scoped to the caller, the user from ` + "`middleware.UserID`" + ` or else the API key, and to the method and path, so two callers or two routes never share a response. ` + "`middleware.IdempotentEvent`" + ` wraps event handlers and keys on the value at a JSON path of the payload, such as ` + "`detail.order_id`" + `{{ if .HasFeature "sqs" }}; once ` + "`WithIdempotency`" + ` gives the SQS handler a store, it uses it to skip messages SQS delivers again after they were processed, keyed on their message ID or, with a ` + "`KeyPath`" + `, on a field of their body so a message its producer sent twice is caught too{{ end }}. An in-progress lock expires at the invocation's deadline, so a retry after a crash or timeout is not blocked.
+
+Failed calls and 5xx responses are not stored, so they can be retried with the same key.
+{{- if .HasFeature "dynamodb" }} ` + "`middleware.DynamoDBIdempotencyStore`" + ` keeps records in the ` + "`IDEMPOTENCY_TABLE_NAME`" + ` table, and its TTL deletes them once ` + "`IDEMPOTENCY_TTL`" + ` has passed.
+{{- end }} ` + "`middleware.NewInMemoryIdempotencyStore`" + ` is for tests: each container keeps its own records, so a retry served by another container would run again.
+{{- if .HasFeature "api" }}
+
+### Rate Limiting
+
+` + "`middleware.RateLimit`" + ` runs in front of the API handler with a token bucket per API key, read from the ` + "`X-Api-Key`" + ` header, and one per user. ` + "`middleware.UserID`" + ` runs before it and reads the user from the API's authorizer with ` + "`apievent.UserID`" + `: {{ if eq .APIType "rest" }}the ` + "`sub`" + ` claim of a Cognito authorizer or the principal ID of a Lambda authorizer{{ else if eq .APIType "http" }}the ` + "`sub`" + ` claim of a JWT authorizer or the IAM user ID{{ else if eq .APIType "function-url" }}the IAM user ID of a URL with ` + "`AWS_IAM`" + ` auth{{ else }}the ` + "`X-Amzn-Oidc-Identity`" + ` header of an ALB that authenticates users{{ end }}. A request takes a token only once all of its buckets allow it. A caller whose bucket is empty gets a 429 ` + "`TOO_MANY_REQUESTS`" + ` problem with a ` + "`Retry-After`" + ` header; other responses carry ` + "`X-RateLimit-Limit`" + `, ` + "`X-RateLimit-Remaining`" + ` and ` + "`X-RateLimit-Reset`" + `. ` + "`RATE_LIMIT_API_KEY_RATE`" + ` and ` + "`RATE_LIMIT_USER_RATE`" + ` set the requests per second, ` + "`RATE_LIMIT_API_KEY_BURST`" + ` and ` + "`RATE_LIMIT_USER_BURST`" + ` how many may arrive at once.
+
+Each container keeps its own buckets by default, so a caller served by several containers gets the limit of each.
+{{- if .HasFeature "dynamodb" }} Set ` + "`RATE_LIMIT_TABLE_NAME`" + ` to keep them in DynamoDB, where they hold across containers; the deployment sets it to the rate limit table it creates.
+{{- end }}
+{{- if eq .APIType "rest" }} API Gateway throttles before the function runs too: every route requires an API key, and the key the deployment creates belongs to a usage plan with a rate, burst and daily quota.
+{{- else if eq .APIType "http" }} API Gateway throttles before the function runs too: the stage limits the rate and burst of every route. HTTP APIs have no usage plans or API keys, so per-key limits are only enforced here.
+{{- end }}
+
+### Response Size
+
+{{if eq .APIType "alb"}}An Application Load Balancer takes at most 1 MB from a Lambda target{{else}}Lambda returns at most 6 MB from a synchronous invocation{{end}}, so ` + "`middleware.ResponseSize`" + ` checks every API response before it is returned. Responses of ` + "`RESPONSE_GZIP_MIN_SIZE`" + ` bytes or more are gzip-compressed for clients that send ` + "`Accept-Encoding: gzip`" + `
+{{- if eq .APIType "rest" }}. It defaults to 0, which turns compression off, because REST APIs only return compressed bodies for the API's binary media types
+{{- end }}. A response still too large is uploaded to the ` + "`RESPONSE_BUCKET_NAME`" + ` bucket and answered ` + "`303 See Other`" + `, with a presigned URL valid for ` + "`RESPONSE_URL_EXPIRY`" + ` in the ` + "`Location`" + ` header; HTTP clients and the generated API clients follow it to the body. The deployment creates the bucket, which deletes offloaded responses after a day. Without a bucket such responses are answered 500.
+{{- end }}
+{{- end }}
+{{- if .HasFeature "sqs" }}
+
+### Queue Processing
+
+The message processor handles every record in an SQS batch and returns the IDs of the ones that failed as ` + "`BatchItemFailures`" + `. The event source mapping enables ` + "`ReportBatchItemFailures`" + `, so SQS only redelivers those messages instead of the whole batch; a message that keeps failing ends up in the dead-letter queue. Messages that cannot be parsed are logged and dropped, since retrying them would never succeed.
+{{- end }}
 
 ## 🚀 Development
 
@@ -362,7 +682,26 @@ const README = `# {{.Name}}
 make run-local
 ` + "```" + `
 
+{{- if and (or (eq .DeploymentTool "sam") (eq .DeploymentTool "serverless")) (or (eq .APIType "rest") (eq .APIType "http")) }}
 This starts a local development server using {{.DeploymentTool}}.
+{{- if .HasLocalAPI }} ` + "`make local-api`" + ` serves the function without it, on ` + "`PORT`" + ` (default 3000).
+{{- end }}
+{{- else if .HasLocalAPI }}
+This runs ` + "`cmd/local-api`" + `, which serves the API function on ` + "`PORT`" + ` (default 3000) as {{.APIType}} events.
+{{- else }}
+This starts a local development server using {{.DeploymentTool}}.
+{{- end }}
+{{- if or (.HasFeature "dynamodb") (.HasFeature "sqs") (.HasFeature "s3") }}
+
+### Local AWS Stack
+
+` + "```bash" + `
+cp .env.example .env.local
+make local-up
+` + "```" + `
+
+This starts {{if .HasFeature "dynamodb"}}DynamoDB Local (port 8000), {{end}}{{if .HasFeature "sqs"}}ElasticMQ (port 9324), {{end}}{{if .HasFeature "s3"}}MinIO (port 9000), {{end}}and runs ` + "`scripts/bootstrap`" + `, which creates the same tables, indexes, queues and buckets as the deployment template. The bootstrap is idempotent and only talks to the endpoints set in ` + "`.env.local`" + ` (` + "`DYNAMODB_ENDPOINT`" + `, ` + "`SQS_ENDPOINT`" + `, ` + "`S3_ENDPOINT`" + `); it refuses to run without them. Stop the stack with ` + "`make local-down`" + `.
+{{- end }}
 
 ### Generating New Handlers
 
@@ -427,9 +766,9 @@ make deploy-prod
 
 ## 📊 Monitoring
 
-- CloudWatch Logs: All Lambda functions automatically log to CloudWatch
-- X-Ray Tracing: Distributed tracing is enabled for all functions
-- Custom Metrics: Business metrics are sent to CloudWatch Metrics
+- CloudWatch Logs: All Lambda functions automatically log to CloudWatch{{ if eq .Architecture "clean" }}, with secrets and personal data redacted (see [Log Redaction](#log-redaction)){{ end }}
+- Tracing: OpenTelemetry spans for every invocation, SQS message and AWS call, sent to X-Ray through the ADOT collector layer (see [Tracing](#tracing))
+- Metrics: Latency, errors, status codes, batch outcomes and cold starts in CloudWatch Embedded Metric Format, plus your own through ` + "`metrics.Add`" + ` (see [Metrics](#metrics))
 
 ## 🔐 Security
 
@@ -479,6 +818,9 @@ go.work
 
 # Dependency directories
 vendor/
+{{- if .HasFeature "api" }}
+clients/typescript/node_modules/
+{{- end }}
 
 # Build directories
 build/
@@ -535,26 +877,69 @@ const EnvExample = `# Application Configuration
 APP_NAME={{.Name}}
 APP_ENV=development
 LOG_LEVEL=debug
+{{- if eq .Architecture "clean" }}
+
+# Extra log redaction, on top of the defaults of pkg/logger. Patterns are
+# separated by semicolons.
+# LOG_REDACT_HEADERS=X-Tenant-Secret
+# LOG_REDACT_FIELDS=pin,iban
+# LOG_REDACT_PATTERNS=\b\d{4}-\d{4}-\d{4}-\d{4}\b
+{{- end }}
 
 # AWS Configuration
 AWS_REGION=us-east-1
 AWS_PROFILE=default
 
+{{- if or (.HasFeature "dynamodb") (.HasFeature "sqs") (.HasFeature "s3") }}
+
+# Local stack (docker-compose). Any credentials work for DynamoDB Local and
+# ElasticMQ; MinIO expects the root user from docker-compose.yml.
+AWS_ACCESS_KEY_ID=local
+AWS_SECRET_ACCESS_KEY=localsecret
+{{- end }}
+
 {{- if .HasFeature "dynamodb" }}
 # DynamoDB Configuration
+{{- if eq .Architecture "clean" }}
 DYNAMODB_TABLE_PREFIX={{.Name}}_
+{{- else }}
+DYNAMODB_TABLE_NAME={{.Name}}-{{.DataModel.Table.Name}}
+{{- end }}
 DYNAMODB_ENDPOINT=http://localhost:8000
+# Signs pagination cursors so clients cannot forge them; leave empty to
+# use the cursor-secret parameter, and both empty to issue unsigned cursors
+CURSOR_SECRET=
+{{- if eq .Architecture "clean" }}
+# Idempotency records and how long a completed response is replayed
+IDEMPOTENCY_TABLE_NAME={{.Name}}-idempotency
+IDEMPOTENCY_TTL=24h
+{{- end }}
+{{- if eq .Architecture "ddd" }}
+# Event store; a snapshot is taken every N events of a stream
+EVENT_STORE_TABLE_NAME={{.Name}}-events
+EVENT_STORE_SNAPSHOT_EVERY=50
+# Outbox relay targets; events are published to every one that is set
+OUTBOX_QUEUE_URL={{ if .HasFeature "sqs" }}http://localhost:9324/000000000000/{{.Name}}-messages{{ end }}
+{{- if .HasFeature "sns" }}
+OUTBOX_TOPIC_ARN=
+{{- end }}
+{{- if .HasFeature "eventbridge" }}
+OUTBOX_EVENT_BUS_NAME=default
+{{- end }}
+{{- end }}
 {{- end }}
 
 {{- if .HasFeature "sqs" }}
 # SQS Configuration
-SQS_QUEUE_URL=https://sqs.us-east-1.amazonaws.com/123456789012/my-queue
-SQS_DLQ_URL=https://sqs.us-east-1.amazonaws.com/123456789012/my-queue-dlq
+SQS_QUEUE_URL=http://localhost:9324/000000000000/{{.Name}}-messages
+SQS_DLQ_URL=http://localhost:9324/000000000000/{{.Name}}-messages-dlq
+SQS_ENDPOINT=http://localhost:9324
 {{- end }}
 
 {{- if .HasFeature "s3" }}
 # S3 Configuration
-S3_BUCKET_NAME={{.Name}}-bucket
+S3_BUCKET_NAME={{.Name}}-storage
+S3_ENDPOINT=http://localhost:9000
 {{- end }}
 
 {{- if .HasFeature "api" }}
@@ -562,6 +947,25 @@ S3_BUCKET_NAME={{.Name}}-bucket
 API_BASE_URL=http://localhost:3000
 API_KEY=your-api-key-here
 CORS_ORIGINS=http://localhost:3000,http://localhost:8080
+# Check responses against docs/openapi.yaml too (never applied in production)
+OPENAPI_VALIDATE_RESPONSES=true
+{{- if eq .Architecture "clean" }}
+# Requests per second and burst allowed per API key and per user; 0 turns a
+# limit off
+RATE_LIMIT_API_KEY_RATE=50
+RATE_LIMIT_API_KEY_BURST=100
+RATE_LIMIT_USER_RATE=10
+RATE_LIMIT_USER_BURST=20
+{{- if .HasFeature "dynamodb" }}
+# Shares the limits across containers; leave empty to limit each on its own
+RATE_LIMIT_TABLE_NAME=
+{{- end }}
+# Compress responses from this many bytes (0 turns it off) and upload those
+# too large to return to the bucket, answering with a presigned URL
+RESPONSE_GZIP_MIN_SIZE={{if eq .APIType "rest"}}0{{else}}1024{{end}}
+RESPONSE_BUCKET_NAME=
+RESPONSE_URL_EXPIRY=15m
+{{- end }}
 {{- end }}
 
 {{- if .HasFeature "cognito" }}
@@ -575,13 +979,26 @@ COGNITO_CLIENT_ID=xxxxxxxxxxxxxxxxxxxxxxxxx
 SECRETS_PREFIX={{.Name}}/
 {{- end }}
 
+# Configuration sources (pkg/configsource). These files stand in for SSM
+# Parameter Store and the AppConfig feature flags; deployed functions read
+# CONFIG_SSM_PATH and CONFIG_APPCONFIG_* from the IaC template instead.
+# Variables set here override both.
+CONFIG_SSM_FILE=local/ssm.json
+CONFIG_APPCONFIG_FILE=local/feature-flags.json
+CONFIG_REFRESH_INTERVAL=30s
+
 # Monitoring
-ENABLE_XRAY=true
+# Tracing exporter: xray (ADOT collector on Lambda), otlp, stdout, memory or none
+TRACING_EXPORTER=stdout
+TRACING_ENDPOINT=localhost:4318
+METRICS_NAMESPACE={{.Name}}
 ENABLE_PROFILING=false
 `
 
 const DockerCompose = `version: '3.8'
 
+# Local AWS stack. Start it with "make local-up", which also creates the
+# tables, queues and buckets from the IaC template via scripts/bootstrap.
 services:
 {{- if .HasFeature "dynamodb" }}
   dynamodb-local:
@@ -597,18 +1014,27 @@ services:
 {{- end }}
 
 {{- if .HasFeature "sqs" }}
-  localstack:
-    image: localstack/localstack:latest
-    container_name: {{.Name}}-localstack
+  elasticmq:
+    image: softwaremill/elasticmq-native:latest
+    container_name: {{.Name}}-elasticmq
     ports:
-      - "4566:4566"
+      - "9324:9324"
+      - "9325:9325"
+{{- end }}
+
+{{- if .HasFeature "s3" }}
+  minio:
+    image: minio/minio:latest
+    container_name: {{.Name}}-minio
+    command: server /data --console-address ":9001"
+    ports:
+      - "9000:9000"
+      - "9001:9001"
     environment:
-      - SERVICES=sqs,sns,s3,secretsmanager
-      - DEBUG=0
-      - DATA_DIR=/tmp/localstack/data
+      - MINIO_ROOT_USER=local
+      - MINIO_ROOT_PASSWORD=localsecret
     volumes:
-      - "./scripts/localstack:/docker-entrypoint-initaws.d"
-      - "localstack-data:/tmp/localstack"
+      - "minio-data:/data"
 {{- end }}
 
 {{- if .HasFeature "api" }}
@@ -622,13 +1048,15 @@ services:
     volumes:
       - "./docs:/docs"
 {{- end }}
+{{- if .HasFeature "s3" }}
 
 volumes:
-  localstack-data:
+  minio-data:
+{{- end }}
 `
 
 const Dockerfile = `# Build stage
-FROM golang:1.21-alpine AS builder
+FROM golang:{{.GoVersion}}-alpine AS builder
 
 # Install dependencies
 RUN apk add --no-cache git make
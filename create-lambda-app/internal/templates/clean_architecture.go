@@ -12,10 +12,10 @@ import (
 
 // BaseEntity contains common fields for all entities
 type BaseEntity struct {
-	ID        string    ` + "`json:\"id\"`" + `
-	CreatedAt time.Time ` + "`json:\"created_at\"`" + `
-	UpdatedAt time.Time ` + "`json:\"updated_at\"`" + `
-	Version   int       ` + "`json:\"version\"`" + `
+	ID        string    ` + "`json:\"id\" dynamodbav:\"id\"`" + `
+	CreatedAt time.Time ` + "`json:\"created_at\" dynamodbav:\"created_at\"`" + `
+	UpdatedAt time.Time ` + "`json:\"updated_at\" dynamodbav:\"updated_at\"`" + `
+	Version   int       ` + "`json:\"version\" dynamodbav:\"version\"`" + `
 }
 
 // NewBaseEntity creates a new base entity with generated ID
@@ -29,7 +29,9 @@ func NewBaseEntity() BaseEntity {
 	}
 }
 
-// UpdateVersion increments the version and updates the timestamp
+// UpdateVersion increments the version and updates the timestamp.
+// Repositories call it when they persist a change, so the version always
+// matches the stored copy that optimistic locking checks against.
 func (e *BaseEntity) UpdateVersion() {
 	e.Version++
 	e.UpdatedAt = time.Now().UTC()
@@ -38,10 +40,10 @@ func (e *BaseEntity) UpdateVersion() {
 // Example User entity
 type User struct {
 	BaseEntity
-	Email     string    ` + "`json:\"email\"`" + `
-	Name      string    ` + "`json:\"name\"`" + `
-	Status    string    ` + "`json:\"status\"`" + `
-	DeletedAt *time.Time ` + "`json:\"deleted_at,omitempty\"`" + `
+	Email     string    ` + "`json:\"email\" dynamodbav:\"email\"`" + `
+	Name      string    ` + "`json:\"name\" dynamodbav:\"name\"`" + `
+	Status    string    ` + "`json:\"status\" dynamodbav:\"status\"`" + `
+	DeletedAt *time.Time ` + "`json:\"deleted_at,omitempty\" dynamodbav:\"deleted_at,omitempty\"`" + `
 }
 
 // NewUser creates a new user entity
@@ -64,7 +66,7 @@ func (u *User) SoftDelete() {
 	now := time.Now().UTC()
 	u.DeletedAt = &now
 	u.Status = "deleted"
-	u.UpdateVersion()
+	u.UpdatedAt = now
 }
 `
 
@@ -72,6 +74,7 @@ const CleanRepositoryInterface = `package repositories
 
 import (
 	"context"
+	"fmt"
 	
 	"{{.Module}}/internal/domain/entities"
 )
@@ -87,14 +90,17 @@ type UserRepository interface {
 	// GetByEmail retrieves a user by email
 	GetByEmail(ctx context.Context, email string) (*entities.User, error)
 	
-	// Update saves changes to an existing user
+	// Update saves changes to an existing user. It succeeds only while the
+	// stored user still has user.Version and then bumps the version;
+	// otherwise it returns a *ConflictError.
 	Update(ctx context.Context, user *entities.User) error
 	
 	// Delete removes a user
 	Delete(ctx context.Context, id string) error
 	
-	// List retrieves users with pagination
-	List(ctx context.Context, offset, limit int) ([]*entities.User, error)
+	// List retrieves up to limit users in creation order, starting after
+	// cursor. It returns the cursor of the next page, or "" on the last page.
+	List(ctx context.Context, cursor string, limit int) ([]*entities.User, string, error)
 	
 	// Count returns the total number of users
 	Count(ctx context.Context) (int64, error)
@@ -120,6 +126,17 @@ func (e *RepositoryError) Error() string {
 	return e.Message
 }
 
+// ConflictError is returned when an optimistic-locking write finds that
+// another writer changed the user since it was read. Reload and retry.
+type ConflictError struct {
+	ID      string
+	Version int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("user %s was modified concurrently (version %d is stale)", e.ID, e.Version)
+}
+
 // Common error codes
 const (
 	ErrCodeNotFound     = "NOT_FOUND"
@@ -139,6 +156,11 @@ var (
 		Code:    ErrCodeDuplicate,
 		Message: "user already exists",
 	}
+	
+	ErrInvalidCursor = &RepositoryError{
+		Code:    ErrCodeInvalidInput,
+		Message: "invalid pagination cursor",
+	}
 )
 `
 
@@ -175,8 +197,9 @@ type UserUseCase interface {
 	// DeleteUser deletes a user
 	DeleteUser(ctx context.Context, userID string) error
 	
-	// ListUsers lists users with pagination
-	ListUsers(ctx context.Context, page, pageSize int) (*ListUsersOutput, error)
+	// ListUsers lists up to limit users in creation order, starting after
+	// cursor. An invalid cursor is a validation error.
+	ListUsers(ctx context.Context, cursor string, limit int) (*ListUsersOutput, error)
 }
 
 // UpdateUserInput represents the input for updating a user
@@ -185,12 +208,12 @@ type UpdateUserInput struct {
 	Status *string ` + "`json:\"status,omitempty\" validate:\"omitempty,oneof=active inactive\"`" + `
 }
 
-// ListUsersOutput represents paginated user list output
+// ListUsersOutput represents one page of users. NextCursor is empty on
+// the last page.
 type ListUsersOutput struct {
 	Users      []*entities.User ` + "`json:\"users\"`" + `
-	TotalCount int64           ` + "`json:\"total_count\"`" + `
-	Page       int             ` + "`json:\"page\"`" + `
-	PageSize   int             ` + "`json:\"page_size\"`" + `
+	NextCursor string           ` + "`json:\"next_cursor,omitempty\"`" + `
+	Limit      int              ` + "`json:\"limit\"`" + `
 }
 
 // UseCaseError represents a use case level error
@@ -220,15 +243,36 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+{{- if .HasFeature "api" }}
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+{{- end }}
 	"github.com/rs/zerolog/log"
 	
+	"{{.Module}}/internal/domain/repositories"
 	"{{.Module}}/internal/infrastructure/config"
+{{- if .HasFeature "dynamodb" }}
+	"{{.Module}}/internal/infrastructure/database"
+{{- end }}
+{{- if .UsesRouter }}
+	"{{.Module}}/internal/interfaces/api"
+{{- end }}
 	"{{.Module}}/internal/usecases"
+	"{{.Module}}/pkg/apievent"
 	"{{.Module}}/pkg/errors"
 	"{{.Module}}/pkg/middleware"
+	"{{.Module}}/pkg/metrics"
+{{- if .HasFeature "api" }}
+	"{{.Module}}/pkg/openapi"
+{{- end }}
+	"{{.Module}}/pkg/problem"
+{{- if .HasFeature "api" }}
+	"{{.Module}}/pkg/resilience"
+{{- end }}
+	"{{.Module}}/pkg/tracing"
 )
 
 // Handler represents a Lambda handler with dependencies
@@ -246,114 +290,106 @@ func NewHandler(userUseCase usecases.UserUseCase, cfg *config.Config) *Handler {
 }
 
 // HandleRequest processes the Lambda request
-func (h *Handler) HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Add request ID to context
-	ctx = middleware.WithRequestID(ctx, request.RequestContext.RequestID)
-	
-	// Log request
-	log.Ctx(ctx).Info().
-		Str("method", request.HTTPMethod).
-		Str("path", request.Path).
-		Str("request_id", request.RequestContext.RequestID).
-		Msg("Processing request")
+func (h *Handler) HandleRequest(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	// Request ID, logging and recovery come from the middleware chain in Start
+	log.Ctx(ctx).Debug().
+		Str("method", apievent.Method(request)).
+		Str("path", apievent.Path(request)).
+		Msg("Routing request")
 	
 	// Route based on path and method
 	switch {
-	case request.Path == "/users" && request.HTTPMethod == http.MethodPost:
+	case apievent.Matches(request, http.MethodPost, "/users"):
 		return h.createUser(ctx, request)
-	case request.Path == "/users" && request.HTTPMethod == http.MethodGet:
+	case apievent.Matches(request, http.MethodGet, "/users"):
 		return h.listUsers(ctx, request)
-	case request.Path == "/users/{id}" && request.HTTPMethod == http.MethodGet:
+	case apievent.Matches(request, http.MethodGet, "/users/{id}"):
 		return h.getUser(ctx, request)
-	case request.Path == "/users/{id}" && request.HTTPMethod == http.MethodPut:
+	case apievent.Matches(request, http.MethodPut, "/users/{id}"):
 		return h.updateUser(ctx, request)
-	case request.Path == "/users/{id}" && request.HTTPMethod == http.MethodDelete:
+	case apievent.Matches(request, http.MethodDelete, "/users/{id}"):
 		return h.deleteUser(ctx, request)
 	default:
-		return errorResponse(http.StatusNotFound, "Route not found")
+		return errorResponse(ctx, request, problem.New(problem.TypeNotFound, "Route not found"))
 	}
 }
 
 // createUser handles user creation
-func (h *Handler) createUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handler) createUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
 	var input usecases.CreateUserInput
 	if err := json.Unmarshal([]byte(request.Body), &input); err != nil {
-		return errorResponse(http.StatusBadRequest, "Invalid request body")
+		return errorResponse(ctx, request, problem.New(problem.TypeValidation, "Invalid request body"))
 	}
 	
 	output, err := h.userUseCase.CreateUser(ctx, input)
 	if err != nil {
-		return handleUseCaseError(err)
+		return handleUseCaseError(ctx, request, err)
 	}
 	
 	return successResponse(http.StatusCreated, output)
 }
 
 // getUser handles retrieving a user
-func (h *Handler) getUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.PathParameters["id"]
+func (h *Handler) getUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	userID := apievent.PathParameter(request, "/users/{id}", "id")
 	if userID == "" {
-		return errorResponse(http.StatusBadRequest, "User ID is required")
+		return errorResponse(ctx, request, problem.New(problem.TypeValidation, "User ID is required"))
 	}
 	
 	user, err := h.userUseCase.GetUser(ctx, userID)
 	if err != nil {
-		return handleUseCaseError(err)
+		return handleUseCaseError(ctx, request, err)
 	}
 	
 	return successResponse(http.StatusOK, user)
 }
 
-// listUsers handles listing users with pagination
-func (h *Handler) listUsers(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	page := 1
-	pageSize := 20
+// listUsers handles listing users with cursor pagination
+func (h *Handler) listUsers(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	limit := 20
 	
 	// Parse query parameters
-	if pageParam := request.QueryStringParameters["page"]; pageParam != "" {
-		// Parse page parameter
-	}
-	if pageSizeParam := request.QueryStringParameters["page_size"]; pageSizeParam != "" {
-		// Parse page size parameter
+	if l, err := strconv.Atoi(request.QueryStringParameters["limit"]); err == nil && l > 0 && l <= 100 {
+		limit = l
 	}
 	
-	output, err := h.userUseCase.ListUsers(ctx, page, pageSize)
+	output, err := h.userUseCase.ListUsers(ctx, request.QueryStringParameters["cursor"], limit)
 	if err != nil {
-		return handleUseCaseError(err)
+		return handleUseCaseError(ctx, request, err)
 	}
 	
 	return successResponse(http.StatusOK, output)
 }
 
 // updateUser handles updating a user
-func (h *Handler) updateUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.PathParameters["id"]
+func (h *Handler) updateUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	userID := apievent.PathParameter(request, "/users/{id}", "id")
 	if userID == "" {
-		return errorResponse(http.StatusBadRequest, "User ID is required")
+		return errorResponse(ctx, request, problem.New(problem.TypeValidation, "User ID is required"))
 	}
 	
 	var input usecases.UpdateUserInput
 	if err := json.Unmarshal([]byte(request.Body), &input); err != nil {
-		return errorResponse(http.StatusBadRequest, "Invalid request body")
+		return errorResponse(ctx, request, problem.New(problem.TypeValidation, "Invalid request body"))
 	}
 	
 	user, err := h.userUseCase.UpdateUser(ctx, userID, input)
 	if err != nil {
-		return handleUseCaseError(err)
+		return handleUseCaseError(ctx, request, err)
 	}
 	
 	return successResponse(http.StatusOK, user)
 }
 
 // deleteUser handles deleting a user
-func (h *Handler) deleteUser(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.PathParameters["id"]
+func (h *Handler) deleteUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	userID := apievent.PathParameter(request, "/users/{id}", "id")
 	if userID == "" {
-		return errorResponse(http.StatusBadRequest, "User ID is required")
+		return errorResponse(ctx, request, problem.New(problem.TypeValidation, "User ID is required"))
 	}
 	
 	if err := h.userUseCase.DeleteUser(ctx, userID); err != nil {
-		return handleUseCaseError(err)
+		return handleUseCaseError(ctx, request, err)
 	}
 	
 	return successResponse(http.StatusNoContent, nil)
@@ -361,60 +397,161 @@ func (h *Handler) deleteUser(ctx context.Context, request events.APIGatewayProxy
 
 // Helper functions
 
-func successResponse(statusCode int, data interface{}) (events.APIGatewayProxyResponse, error) {
+func successResponse(statusCode int, data interface{}) (apievent.Response, error) {
 	body, _ := json.Marshal(map[string]interface{}{
 		"success": true,
 		"data":    data,
 	})
 	
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"X-Request-ID": middleware.GetRequestID(context.Background()),
-		},
-		Body: string(body),
-	}, nil
+	return apievent.NewResponse(statusCode, map[string]string{
+		"Content-Type": "application/json",
+		"X-Request-ID": middleware.GetRequestID(context.Background()),
+	}, string(body)), nil
 }
 
-func errorResponse(statusCode int, message string) (events.APIGatewayProxyResponse, error) {
-	body, _ := json.Marshal(map[string]interface{}{
-		"success": false,
-		"error": map[string]interface{}{
-			"message": message,
-		},
-	})
-	
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"X-Request-ID": middleware.GetRequestID(context.Background()),
-		},
-		Body: string(body),
-	}, nil
+// errorResponse answers request with p
+func errorResponse(ctx context.Context, request apievent.Request, p *problem.Problem) (apievent.Response, error) {
+	return problem.Response(ctx, request, p), nil
 }
 
-func handleUseCaseError(err error) (events.APIGatewayProxyResponse, error) {
+// handleUseCaseError answers a use case error with the problem of its type
+func handleUseCaseError(ctx context.Context, request apievent.Request, err error) (apievent.Response, error) {
 	if ucErr, ok := err.(*usecases.UseCaseError); ok {
 		switch ucErr.Type {
 		case usecases.ErrTypeValidation:
-			return errorResponse(http.StatusBadRequest, ucErr.Message)
+			return errorResponse(ctx, request, problem.New(problem.TypeValidation, ucErr.Message))
 		case usecases.ErrTypeNotFound:
-			return errorResponse(http.StatusNotFound, ucErr.Message)
+			return errorResponse(ctx, request, problem.New(problem.TypeNotFound, ucErr.Message))
 		case usecases.ErrTypeConflict:
-			return errorResponse(http.StatusConflict, ucErr.Message)
+			return errorResponse(ctx, request, problem.New(problem.TypeConflict, ucErr.Message))
 		case usecases.ErrTypeUnauthorized:
-			return errorResponse(http.StatusUnauthorized, ucErr.Message)
+			return errorResponse(ctx, request, problem.New(problem.TypeUnauthorized, ucErr.Message))
 		default:
-			return errorResponse(http.StatusInternalServerError, "Internal server error")
+			return errorResponse(ctx, request, problem.Internal())
 		}
 	}
 	
-	log.Error().Err(err).Msg("Unexpected error in handler")
-	return errorResponse(http.StatusInternalServerError, "Internal server error")
+	// A concurrent writer won an optimistic-locking race
+	var conflict *repositories.ConflictError
+	if errors.As(err, &conflict) {
+		err = errors.NewConflictError(conflict.Error()).WithCause(conflict)
+	}
+	if errors.IsConflictError(err) {
+		log.Warn().Err(err).Msg("Write conflict in handler")
+		return errorResponse(ctx, request, problem.New(problem.TypeConflict, "Resource was modified by another request, reload and retry"))
+	}
+	
+	p := problem.FromError(err)
+	if p.Type == problem.TypeInternal {
+		log.Error().Err(err).Msg("Unexpected error in handler")
+	}
+	return errorResponse(ctx, request, p)
+}
+
+// APIHandler wires the handler's dependencies and wraps it in the default
+// middleware chain. Start serves it on Lambda, cmd/local-api on localhost.
+{{- if .HasFeature "dynamodb" }}
+// Requests that repeat an Idempotency-Key get the stored response back.
+{{- end }}
+{{- if .HasFeature "api" }}
+// Callers over their rate limit, and requests that break
+// docs/openapi.yaml, are rejected before they reach the handler; responses
+// too large for Lambda to return are offloaded to S3.
+{{- end }}
+{{- if .UsesRouter }}
+// Requests are routed by the {{.Router}} router of internal/interfaces/api.
+{{- end }}
+func APIHandler(cfg *config.Config) middleware.APIHandlerFunc {
+	// Initialize dependencies
+	// TODO: Initialize repositories, use cases, etc.
+	
+	// Create handler
+{{- if .UsesRouter }}
+	handler := api.NewRouter(nil) // Pass real dependencies
+{{- else }}
+	handler := NewHandler(nil, cfg) // Pass real dependencies
+{{- end }}
+	
+	chain := middleware.DefaultChain[apievent.Request, apievent.Response]()
+	{{- if .HasFeature "api" }}
+	
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+	chain = middleware.Chain(chain, middleware.ResponseSize(middleware.ResponseSizeConfig{
+		GzipMinSize: cfg.ResponseGzipMinSize,
+		OffloadSize: cfg.ResponseOffloadSize,
+		Offloader:   responseOffloader(cfg),
+	}), middleware.UserID(), middleware.RateLimit(middleware.RateLimitConfig{
+		Store: rateLimitStore(cfg),
+		Rules: []middleware.RateLimitRule{
+			middleware.PerAPIKey(middleware.Limit{Rate: cfg.RateLimitAPIKeyRate, Burst: cfg.RateLimitAPIKeyBurst}),
+			middleware.PerUser(middleware.Limit{Rate: cfg.RateLimitUserRate, Burst: cfg.RateLimitUserBurst}),
+		},
+	}),{{ if .HasFeature "dynamodb" }} middleware.Idempotency(idempotencyConfig(cfg)),{{ end }} middleware.Validation(validator))
+	{{- else }}
+	chain = middleware.Chain(chain, middleware.UserID(){{ if .HasFeature "dynamodb" }}, middleware.Idempotency(idempotencyConfig(cfg)){{ end }})
+	{{- end }}
+	return chain(handler.HandleRequest)
+}
+
+{{- if .HasFeature "dynamodb" }}
+
+// idempotencyConfig keeps the idempotency records in the
+// IDEMPOTENCY_TABLE_NAME table, so a retry served by another container is
+// recognized
+func idempotencyConfig(cfg *config.Config) middleware.IdempotencyConfig {
+	client, err := database.NewDynamoDBClient(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create DynamoDB client")
+	}
+	return middleware.IdempotencyConfig{
+		Store: middleware.NewDynamoDBIdempotencyStore(client.GetClient(), cfg.IdempotencyTableName),
+		TTL:   cfg.IdempotencyTTL,
+	}
+}
+{{- end }}
+{{- if .HasFeature "api" }}
+
+// rateLimitStore keeps the rate limit buckets of each container in memory
+{{- if .HasFeature "dynamodb" }}, or
+// in the RATE_LIMIT_TABLE_NAME table so the limits hold across containers
+{{- end }}
+func rateLimitStore(cfg *config.Config) middleware.RateLimitStore {
+{{- if .HasFeature "dynamodb" }}
+	if cfg.RateLimitTableName != "" {
+		client, err := database.NewDynamoDBClient(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create DynamoDB client")
+		}
+		return middleware.NewDynamoDBRateLimitStore(client.GetClient(), cfg.RateLimitTableName)
+	}
+{{- end }}
+	return middleware.NewInMemoryRateLimitStore()
 }
 
+// responseOffloader uploads responses too large to return to the
+// RESPONSE_BUCKET_NAME bucket; without one they are answered 500
+func responseOffloader(cfg *config.Config) middleware.ResponseOffloader {
+	if cfg.ResponseBucketName == "" {
+		return nil
+	}
+
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion(cfg.AWSRegion),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load AWS config")
+	}
+	tracing.InstrumentAWS(&awsConfig)
+	resilience.InstrumentAWS(&awsConfig, resilience.DefaultBackoff)
+
+	client := s3.NewFromConfig(awsConfig)
+	return middleware.NewS3ResponseOffloader(client, s3.NewPresignClient(client), cfg.ResponseBucketName, cfg.ResponseURLExpiry)
+}
+{{- end }}
+
 // Start initializes and starts the Lambda function
 func Start() {
 	// Initialize configuration
@@ -423,35 +560,53 @@ func Start() {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 	
-	// Initialize dependencies
-	// TODO: Initialize repositories, use cases, etc.
-	
-	// Create handler
-	handler := NewHandler(nil, cfg) // Pass real dependencies
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
 	
 	// Start Lambda
-	lambda.Start(handler.HandleRequest)
+	lambda.Start(APIHandler(cfg))
 }
 `
 
 const CleanConfig = `package config
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 	
-	"github.com/caarlos0/env/v10"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/logger"
+	"{{.Module}}/pkg/metrics"
+{{- if .HasFeature "api" }}
+	"{{.Module}}/pkg/openapi"
+{{- end }}
+	"{{.Module}}/pkg/tracing"
 )
 
-// Config holds all configuration for the application
+// Config holds all configuration for the application. Fields tagged ssm or
+// appconfig can also come from SSM Parameter Store or AppConfig (see
+// pkg/configsource).
 type Config struct {
 	// Application
 	AppName     string ` + "`env:\"APP_NAME\" envDefault:\"{{.Name}}\"`" + `
 	Environment string ` + "`env:\"APP_ENV\" envDefault:\"development\"`" + `
 	LogLevel    string ` + "`env:\"LOG_LEVEL\" envDefault:\"info\"`" + `
+
+	// Feature flags, switched at runtime in AppConfig
+	DebugLogging bool ` + "`env:\"DEBUG_LOGGING\" appconfig:\"debugLogging.enabled\"`" + `
+	
+	// Log redaction, on top of logger.DefaultRedaction
+	LogRedactHeaders  []string ` + "`env:\"LOG_REDACT_HEADERS\" envSeparator:\",\"`" + `
+	LogRedactFields   []string ` + "`env:\"LOG_REDACT_FIELDS\" envSeparator:\",\"`" + `
+	LogRedactPatterns []string ` + "`env:\"LOG_REDACT_PATTERNS\" envSeparator:\";\"`" + `
 	
 	// AWS
 	AWSRegion  string ` + "`env:\"AWS_REGION\" envDefault:\"us-east-1\"`" + `
@@ -461,6 +616,11 @@ type Config struct {
 	// DynamoDB
 	DynamoDBTablePrefix string ` + "`env:\"DYNAMODB_TABLE_PREFIX\" envDefault:\"{{.Name}}_\"`" + `
 	DynamoDBEndpoint    string ` + "`env:\"DYNAMODB_ENDPOINT\"`" + `
+	CursorSecret        string ` + "`env:\"CURSOR_SECRET\" ssm:\"cursor-secret\"`" + `
+
+	// Idempotency
+	IdempotencyTableName string        ` + "`env:\"IDEMPOTENCY_TABLE_NAME\" envDefault:\"{{.Name}}-idempotency\"`" + `
+	IdempotencyTTL       time.Duration ` + "`env:\"IDEMPOTENCY_TTL\" envDefault:\"24h\"`" + `
 	{{- end }}
 	
 	{{- if .HasFeature "sqs" }}
@@ -468,18 +628,42 @@ type Config struct {
 	SQSQueueURL    string ` + "`env:\"SQS_QUEUE_URL\"`" + `
 	SQSDLQueueURL  string ` + "`env:\"SQS_DLQ_URL\"`" + `
 	SQSMaxRetries  int    ` + "`env:\"SQS_MAX_RETRIES\" envDefault:\"3\"`" + `
+	SQSEndpoint    string ` + "`env:\"SQS_ENDPOINT\"`" + `
 	{{- end }}
 	
 	{{- if .HasFeature "s3" }}
 	// S3
 	S3BucketName string ` + "`env:\"S3_BUCKET_NAME\"`" + `
+	S3Endpoint   string ` + "`env:\"S3_ENDPOINT\"`" + `
 	{{- end }}
 	
 	{{- if .HasFeature "api" }}
 	// API
-	APIBaseURL   string   ` + "`env:\"API_BASE_URL\" envDefault:\"http://localhost:3000\"`" + `
-	APIKey       string   ` + "`env:\"API_KEY\"`" + `
-	CORSOrigins  []string ` + "`env:\"CORS_ORIGINS\" envSeparator:\",\"`" + `
+	APIBaseURL        string   ` + "`env:\"API_BASE_URL\" envDefault:\"http://localhost:3000\"`" + `
+	APIKey            string   ` + "`env:\"API_KEY\" ssm:\"api-key\"`" + `
+	CORSOrigins       []string ` + "`env:\"CORS_ORIGINS\" envSeparator:\",\"`" + `
+	ValidateResponses bool     ` + "`env:\"OPENAPI_VALIDATE_RESPONSES\" envDefault:\"true\"`" + `
+
+	// Rate limiting, per API key and per user; a zero rate turns a limit off
+	RateLimitAPIKeyRate  float64 ` + "`env:\"RATE_LIMIT_API_KEY_RATE\" envDefault:\"50\"`" + `
+	RateLimitAPIKeyBurst int     ` + "`env:\"RATE_LIMIT_API_KEY_BURST\" envDefault:\"100\"`" + `
+	RateLimitUserRate    float64 ` + "`env:\"RATE_LIMIT_USER_RATE\" envDefault:\"10\"`" + `
+	RateLimitUserBurst   int     ` + "`env:\"RATE_LIMIT_USER_BURST\" envDefault:\"20\"`" + `
+	{{- if .HasFeature "dynamodb" }}
+	// RateLimitTableName keeps the buckets in DynamoDB so the limits hold
+	// across containers; unset, each container limits on its own
+	RateLimitTableName string ` + "`env:\"RATE_LIMIT_TABLE_NAME\"`" + `
+	{{- end }}
+
+	// Response size: responses from ResponseGzipMinSize bytes are
+	// gzip-compressed for clients that accept it, zero turns that off.
+	// Responses above ResponseOffloadSize, zero for the middleware's
+	// default, are uploaded to ResponseBucketName and answered with a URL
+	// that expires after ResponseURLExpiry.
+	ResponseGzipMinSize int           ` + "`env:\"RESPONSE_GZIP_MIN_SIZE\" envDefault:\"{{if eq .APIType \"rest\"}}0{{else}}1024{{end}}\"`" + `
+	ResponseOffloadSize int           ` + "`env:\"RESPONSE_OFFLOAD_SIZE\"`" + `
+	ResponseBucketName  string        ` + "`env:\"RESPONSE_BUCKET_NAME\"`" + `
+	ResponseURLExpiry   time.Duration ` + "`env:\"RESPONSE_URL_EXPIRY\" envDefault:\"15m\"`" + `
 	{{- end }}
 	
 	{{- if .HasFeature "cognito" }}
@@ -494,23 +678,81 @@ type Config struct {
 	{{- end }}
 	
 	// Monitoring
-	EnableXRay      bool ` + "`env:\"ENABLE_XRAY\" envDefault:\"true\"`" + `
-	EnableProfiling bool ` + "`env:\"ENABLE_PROFILING\" envDefault:\"false\"`" + `
+	TracingExporter  string ` + "`env:\"TRACING_EXPORTER\" envDefault:\"xray\"`" + `
+	TracingEndpoint  string ` + "`env:\"TRACING_ENDPOINT\" envDefault:\"localhost:4318\"`" + `
+	MetricsNamespace string ` + "`env:\"METRICS_NAMESPACE\" envDefault:\"{{.Name}}\"`" + `
+	EnableProfiling  bool   ` + "`env:\"ENABLE_PROFILING\" envDefault:\"false\"`" + `
+}
+
+// TracingOptions configures tracing.Init
+func (c *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		ServiceName: c.AppName,
+		Exporter:    c.TracingExporter,
+		Endpoint:    c.TracingEndpoint,
+	}
 }
 
-// Load loads configuration from environment variables
+// MetricsOptions configures metrics.Init
+func (c *Config) MetricsOptions() metrics.Options {
+	return metrics.Options{
+		Namespace: c.MetricsNamespace,
+		Service:   c.AppName,
+	}
+}
+
+// LoggerOptions configures logger.Init: the default redaction rules plus
+// the project's own
+func (c *Config) LoggerOptions() logger.Options {
+	return logger.Options{
+		Redaction: logger.DefaultRedaction().With(logger.Redaction{
+			Headers:  c.LogRedactHeaders,
+			Fields:   c.LogRedactFields,
+			Patterns: c.LogRedactPatterns,
+		}),
+		Console: c.LogLevel == "debug",
+	}
+}
+{{- if .HasFeature "api" }}
+
+// OpenAPIOptions configures openapi.Load. Responses are never validated in
+// production.
+func (c *Config) OpenAPIOptions() openapi.Options {
+	return openapi.Options{
+		ValidateResponses: c.ValidateResponses && !c.IsProduction(),
+	}
+}
+{{- end }}
+
+// current is the configuration as last refreshed
+var current atomic.Pointer[Config]
+
+// Current returns the configuration as last refreshed by configsource.Wrap.
+// The Config returned by Load keeps the values it was loaded with.
+func Current() *Config {
+	return current.Load()
+}
+
+// Load loads configuration from environment variables and the sources of
+// pkg/configsource, which Wrap refreshes in warm containers
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load(".env.local")
 	_ = godotenv.Load(".env")
 	
+	ctx := context.Background()
+	loader, err := configsource.FromEnvironment(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up configuration sources: %w", err)
+	}
+
 	cfg := &Config{}
-	if err := env.Parse(cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse environment variables: %w", err)
+	if err := loader.Load(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
 	
 	// Configure logging
-	if err := configureLogging(cfg.LogLevel); err != nil {
+	if err := configureLogging(cfg); err != nil {
 		return nil, fmt.Errorf("failed to configure logging: %w", err)
 	}
 	
@@ -518,6 +760,10 @@ func Load() (*Config, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	current.Store(cfg)
+	configsource.Watch(loader, &current, func(ctx context.Context, cfg *Config) {
+		cfg.applyLogLevel()
+	})
 	
 	log.Info().
 		Str("app_name", cfg.AppName).
@@ -533,6 +779,9 @@ func (c *Config) Validate() error {
 	if c.DynamoDBTablePrefix == "" {
 		return fmt.Errorf("DYNAMODB_TABLE_PREFIX is required")
 	}
+	if c.IdempotencyTableName == "" {
+		return fmt.Errorf("IDEMPOTENCY_TABLE_NAME is required")
+	}
 	{{- end }}
 	
 	{{- if .HasFeature "sqs" }}
@@ -566,36 +815,44 @@ func (c *Config) IsProduction() bool {
 	return c.Environment == "production" || c.Environment == "prod"
 }
 
-// configureLogging configures the global logger
-func configureLogging(level string) error {
+// configureLogging configures the global logger and its redaction
+func configureLogging(cfg *Config) error {
 	// Configure zerolog
 	zerolog.TimeFieldFormat = time.RFC3339
 	
 	// Parse log level
-	logLevel, err := zerolog.ParseLevel(level)
+	logLevel, err := zerolog.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		return fmt.Errorf("invalid log level: %w", err)
 	}
 	
 	zerolog.SetGlobalLevel(logLevel)
 	
-	// Use console writer for development
-	if level == "debug" {
-		log.Logger = log.Output(zerolog.ConsoleWriter{
-			Out:        os.Stderr,
-			TimeFormat: time.Kitchen,
-		})
+	if err := logger.Init(cfg.LoggerOptions()); err != nil {
+		return err
 	}
-	
+	cfg.applyLogLevel()
 	return nil
 }
+
+// applyLogLevel logs at debug level while the debugLogging flag is on, and
+// at LOG_LEVEL otherwise
+func (c *Config) applyLogLevel() {
+	if c.DebugLogging {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if level, err := zerolog.ParseLevel(c.LogLevel); err == nil {
+		zerolog.SetGlobalLevel(level)
+	}
+}
 `
 
 const Logger = `package logger
 
 import (
 	"context"
+	"io"
 	"os"
+	"time"
 	
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -617,9 +874,48 @@ type Logger struct {
 	*zerolog.Logger
 }
 
+// Options configures Init
+type Options struct {
+	// Redaction lists what is masked in every log line
+	Redaction Redaction
+
+	// Console writes human-readable lines to stderr instead of JSON to
+	// stdout, for local development
+	Console bool
+
+	// Output receives the log lines instead of stdout; tests pass a buffer
+	Output io.Writer
+}
+
+// output is where New and the global zerolog logger write
+var output io.Writer = os.Stdout
+
+// Init puts a Redactor with the redaction rules in front of the global
+// zerolog logger and of the loggers New creates, so nothing is written
+// before it is redacted. Call it once at startup, before logging.
+func Init(opts Options) error {
+	redactor, err := NewRedactor(opts.Redaction)
+	if err != nil {
+		return err
+	}
+
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+		if opts.Console {
+			out = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.Kitchen}
+		}
+	}
+	output = redactor.Writer(out)
+
+	log.Logger = zerolog.New(output).With().Timestamp().Logger()
+	defaultLogger = New()
+	return nil
+}
+
 // New creates a new logger instance
 func New() *Logger {
-	logger := zerolog.New(os.Stdout).With().
+	logger := zerolog.New(output).With().
 		Timestamp().
 		Str("service", "{{.Name}}").
 		Logger()
@@ -874,6 +1170,18 @@ func NewTimeoutError(operation string) *AppError {
 
 // Helper functions
 
+// Is reports whether any error in err's chain matches target. It mirrors
+// the standard library so callers don't need to import both packages.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target. It mirrors
+// the standard library so callers don't need to import both packages.
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
 // IsValidationError checks if an error is a validation error
 func IsValidationError(err error) bool {
 	var appErr *AppError
@@ -927,11 +1235,23 @@ const Middleware = `package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"time"
-	
+
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/tracing"
 )
 
 // contextKey is a custom type for context keys
@@ -940,23 +1260,38 @@ type contextKey string
 const (
 	// RequestIDKey is the context key for request ID
 	RequestIDKey contextKey = "request_id"
-	
+
 	// CorrelationIDKey is the context key for correlation ID
 	CorrelationIDKey contextKey = "correlation_id"
-	
+
 	// UserIDKey is the context key for user ID
 	UserIDKey contextKey = "user_id"
 )
 
-// HandlerFunc represents a Lambda handler function
-type HandlerFunc func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+const (
+	// CorrelationIDHeader carries the correlation ID of an HTTP request
+	CorrelationIDHeader = "X-Correlation-ID"
+
+	// CorrelationIDAttribute carries the correlation ID of SQS and SNS
+	// messages, and of EventBridge details and DynamoDB items
+	CorrelationIDAttribute = "correlation_id"
+)
+
+// HandlerFunc is a Lambda handler for events of type In
+type HandlerFunc[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+// Middleware wraps a handler for events of type In
+type Middleware[In, Out any] func(HandlerFunc[In, Out]) HandlerFunc[In, Out]
 
-// Middleware represents a middleware function
-type Middleware func(HandlerFunc) HandlerFunc
+// APIHandlerFunc is a handler for the project's API event source
+type APIHandlerFunc = HandlerFunc[apievent.Request, apievent.Response]
 
-// Chain creates a middleware chain
-func Chain(middlewares ...Middleware) Middleware {
-	return func(handler HandlerFunc) HandlerFunc {
+// APIMiddleware wraps an APIHandlerFunc
+type APIMiddleware = Middleware[apievent.Request, apievent.Response]
+
+// Chain creates a middleware chain; the first middleware is the outermost
+func Chain[In, Out any](middlewares ...Middleware[In, Out]) Middleware[In, Out] {
+	return func(handler HandlerFunc[In, Out]) HandlerFunc[In, Out] {
 		for i := len(middlewares) - 1; i >= 0; i-- {
 			handler = middlewares[i](handler)
 		}
@@ -964,110 +1299,168 @@ func Chain(middlewares ...Middleware) Middleware {
 	}
 }
 
-// RequestID middleware adds request ID to context
-func RequestID() Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-			requestID := request.RequestContext.RequestID
-			if requestID == "" {
-				requestID = uuid.New().String()
+// DefaultChain is the chain every handler should run behind: Tracing,
+// Metrics, Recovery, RequestID, Logging, RefreshConfig and Timeout. Tracing
+// and Metrics are outermost so the span and the metrics record what
+// Recovery makes of a panic; Timeout is innermost so a handler that runs
+// out of time is still logged, traced and counted.
+func DefaultChain[In, Out any]() Middleware[In, Out] {
+	return Chain(Tracing[In, Out](), Metrics[In, Out](), Recovery[In, Out](), RequestID[In, Out](), Logging[In, Out](), RefreshConfig[In, Out](), Timeout[In, Out](DefaultTimeoutMargin))
+}
+
+// RequestID adds the request ID and correlation ID to the context and to
+// the context logger. The request ID comes from API Gateway or the Lambda
+// invocation; the correlation ID is read from the event by
+// ExtractCorrelationID and falls back to the request ID. API responses get
+// an X-Request-ID header.
+func RequestID[In, Out any]() Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return func(ctx context.Context, in In) (Out, error) {
+			requestID := requestIDFor(ctx, in)
+			correlationID := ExtractCorrelationID(in)
+			if correlationID == "" {
+				correlationID = requestID
 			}
-			
+
 			ctx = WithRequestID(ctx, requestID)
-			
-			response, err := next(ctx, request)
-			
-			// Add request ID to response headers
-			if response.Headers == nil {
-				response.Headers = make(map[string]string)
+			ctx = WithCorrelationID(ctx, correlationID)
+			ctx = contextLogger(ctx).With().
+				Str("request_id", requestID).
+				Str("correlation_id", correlationID).
+				Logger().
+				WithContext(ctx)
+
+			out, err := next(ctx, in)
+
+			if response, ok := any(&out).(*apievent.Response); ok {
+				if response.Headers == nil {
+					response.Headers = make(map[string]string)
+				}
+				response.Headers["X-Request-ID"] = requestID
 			}
-			response.Headers["X-Request-ID"] = requestID
-			
-			return response, err
+
+			return out, err
+		}
+	}
+}
+
+// CorrelationID overrides the correlation ID with the one extract finds in
+// the event, for triggers ExtractCorrelationID does not know. It must run
+// after RequestID.
+func CorrelationID[In, Out any](extract func(In) string) Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return func(ctx context.Context, in In) (Out, error) {
+			if correlationID := extract(in); correlationID != "" {
+				ctx = WithCorrelationID(ctx, correlationID)
+				ctx = contextLogger(ctx).With().
+					Str("correlation_id", correlationID).
+					Logger().
+					WithContext(ctx)
+			}
+			return next(ctx, in)
 		}
 	}
 }
 
-// Logging middleware logs request and response details
-func Logging() Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+// UserID adds the ID of the caller the API's authorizer identified, read
+// by apievent.UserID, to the context and to the context logger. Rules such
+// as PerUser read it with GetUserID; anonymous requests are passed on
+// unchanged.
+func UserID() APIMiddleware {
+	return func(next APIHandlerFunc) APIHandlerFunc {
+		return func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+			if userID := apievent.UserID(request); userID != "" {
+				ctx = WithUserID(ctx, userID)
+				ctx = contextLogger(ctx).With().
+					Str("user_id", userID).
+					Logger().
+					WithContext(ctx)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Logging logs each invocation with its trigger and duration, and the
+// headers of API requests; their bodies are logged at debug level. Secrets
+// in them are masked by the Redactor logger.Init puts in front of the
+// logger.
+func Logging[In, Out any]() Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return func(ctx context.Context, in In) (Out, error) {
 			start := time.Now()
-			
-			// Log request
-			log.Ctx(ctx).Info().
-				Str("method", request.HTTPMethod).
-				Str("path", request.Path).
-				Interface("headers", request.Headers).
-				Interface("query_params", request.QueryStringParameters).
-				Msg("Incoming request")
-			
-			response, err := next(ctx, request)
-			
-			// Log response
-			duration := time.Since(start)
-			logger := log.Ctx(ctx).With().
-				Int("status_code", response.StatusCode).
-				Dur("duration_ms", duration).
-				Logger()
-			
+			logger := contextLogger(ctx)
+
+			event := logger.Info()
+			describeEvent(event, in)
+			event.Msg("Incoming event")
+
+			if request, ok := any(in).(apievent.Request); ok {
+				if entry := logger.Debug(); entry.Enabled() {
+					body, _ := apievent.Body(request)
+					entry.Str("body", body).Msg("Request body")
+				}
+			}
+
+			out, err := next(ctx, in)
+
+			done := logger.Info()
 			if err != nil {
-				logger.Error().
-					Err(err).
-					Msg("Request failed")
-			} else {
-				logger.Info().
-					Msg("Request completed")
+				done = logger.Error().Err(err)
 			}
-			
-			return response, err
+			if response, ok := any(out).(apievent.Response); ok {
+				done = done.Int("status_code", response.StatusCode)
+			}
+			done.Dur("duration_ms", time.Since(start)).Msg("Event handled")
+
+			return out, err
 		}
 	}
 }
 
-// Recovery middleware recovers from panics
-func Recovery() Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(ctx context.Context, request events.APIGatewayProxyRequest) (response events.APIGatewayProxyResponse, err error) {
+// Recovery recovers from panics. API handlers answer 500; other handlers
+// return an error so the trigger retries the event.
+func Recovery[In, Out any]() Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return func(ctx context.Context, in In) (out Out, err error) {
 			defer func() {
-				if r := recover(); r != nil {
-					log.Ctx(ctx).Error().
-						Interface("panic", r).
-						Msg("Recovered from panic")
-					
-					response = events.APIGatewayProxyResponse{
-						StatusCode: 500,
-						Headers: map[string]string{
-							"Content-Type": "application/json",
-							"X-Request-ID": GetRequestID(ctx),
-						},
-						Body: ` + "`" + `{"success":false,"error":{"message":"Internal server error"}}` + "`" + `,
-					}
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				contextLogger(ctx).Error().
+					Interface("panic", r).
+					Msg("Recovered from panic")
+
+				var zero Out
+				out, err = zero, fmt.Errorf("panic: %v", r)
+				if response, ok := any(&out).(*apievent.Response); ok {
+					request, _ := any(in).(apievent.Request)
+					*response = problem.Response(ctx, request, problem.Internal())
+					err = nil
 				}
 			}()
-			
-			return next(ctx, request)
+
+			return next(ctx, in)
 		}
 	}
 }
 
 // CORS middleware adds CORS headers
-func CORS(allowedOrigins []string) Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func CORS(allowedOrigins []string) APIMiddleware {
+	return func(next APIHandlerFunc) APIHandlerFunc {
+		return func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
 			response, err := next(ctx, request)
-			
+
 			// Initialize headers if nil
 			if response.Headers == nil {
 				response.Headers = make(map[string]string)
 			}
-			
+
 			// Set CORS headers
-			origin := request.Headers["Origin"]
-			if origin == "" {
-				origin = request.Headers["origin"]
-			}
-			
+			origin := apievent.Header(request, "Origin")
+
 			// Check if origin is allowed
 			allowed := false
 			for _, allowedOrigin := range allowedOrigins {
@@ -1076,34 +1469,200 @@ func CORS(allowedOrigins []string) Middleware {
 					break
 				}
 			}
-			
+
 			if allowed {
 				response.Headers["Access-Control-Allow-Origin"] = origin
 				response.Headers["Access-Control-Allow-Methods"] = "GET, POST, PUT, DELETE, OPTIONS"
 				response.Headers["Access-Control-Allow-Headers"] = "Content-Type, Authorization, X-Request-ID"
 				response.Headers["Access-Control-Max-Age"] = "86400"
 			}
-			
+
 			// Handle preflight requests
-			if request.HTTPMethod == "OPTIONS" {
+			if apievent.Method(request) == http.MethodOptions {
 				response.StatusCode = 204
 				response.Body = ""
 			}
-			
+
 			return response, err
 		}
 	}
 }
 
-// Tracing middleware adds AWS X-Ray tracing
-func Tracing() Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-			// TODO: Implement X-Ray tracing
-			// This would integrate with AWS X-Ray SDK
-			return next(ctx, request)
+// Tracing runs each invocation in a span (see tracing.Wrap) and adds its
+// trace ID to the context logger so logs and traces can be matched up
+func Tracing[In, Out any]() Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return tracing.Wrap(func(ctx context.Context, in In) (Out, error) {
+			if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+				ctx = contextLogger(ctx).With().
+					Str("trace_id", spanContext.TraceID().String()).
+					Logger().
+					WithContext(ctx)
+			}
+			return next(ctx, in)
+		})
+	}
+}
+
+// Metrics records latency, errors and status codes or batch record counts
+// of each invocation and flushes them as one EMF document (see
+// metrics.Wrap). Handlers add their own with metrics.Add.
+func Metrics[In, Out any]() Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return metrics.Wrap(next)
+	}
+}
+
+// RefreshConfig loads the configuration again before the invocation once
+// its refresh interval has passed (see configsource.Wrap)
+func RefreshConfig[In, Out any]() Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return configsource.Wrap(next)
+	}
+}
+
+// ExtractCorrelationID returns the correlation ID carried by an event of a
+// known trigger, or "" when there is none
+func ExtractCorrelationID(in any) string {
+	switch event := in.(type) {
+	case apievent.Request:
+		return APICorrelationID(event)
+	case events.SQSEvent:
+		return SQSCorrelationID(event)
+	case events.SNSEvent:
+		return SNSCorrelationID(event)
+	case events.CloudWatchEvent:
+		return EventBridgeCorrelationID(event)
+	case events.DynamoDBEvent:
+		return DynamoDBStreamCorrelationID(event)
+	case events.S3Event:
+		return S3CorrelationID(event)
+	default:
+		return ""
+	}
+}
+
+// APICorrelationID reads the X-Correlation-ID header
+func APICorrelationID(request apievent.Request) string {
+	return apievent.Header(request, CorrelationIDHeader)
+}
+
+// SQSCorrelationID returns the correlation ID of the first message that has
+// one. Batches can mix messages from different flows, so handlers that
+// process records one by one should use SQSMessageCorrelationID.
+func SQSCorrelationID(event events.SQSEvent) string {
+	for _, record := range event.Records {
+		if correlationID := SQSMessageCorrelationID(record); correlationID != "" {
+			return correlationID
+		}
+	}
+	return ""
+}
+
+// SQSMessageCorrelationID reads the correlation_id message attribute
+func SQSMessageCorrelationID(message events.SQSMessage) string {
+	if attribute, ok := message.MessageAttributes[CorrelationIDAttribute]; ok && attribute.StringValue != nil {
+		return *attribute.StringValue
+	}
+	return ""
+}
+
+// SNSCorrelationID reads the correlation_id message attribute of the first
+// record that has one
+func SNSCorrelationID(event events.SNSEvent) string {
+	for _, record := range event.Records {
+		attribute, ok := record.SNS.MessageAttributes[CorrelationIDAttribute].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := attribute["Value"].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// EventBridgeCorrelationID reads correlation_id from the event detail and
+// falls back to the event ID, which is stable across retries. Scheduled
+// events use the same shape.
+func EventBridgeCorrelationID(event events.CloudWatchEvent) string {
+	var detail map[string]interface{}
+	if err := json.Unmarshal(event.Detail, &detail); err == nil {
+		if value, ok := detail[CorrelationIDAttribute].(string); ok && value != "" {
+			return value
 		}
 	}
+	return event.ID
+}
+
+// DynamoDBStreamCorrelationID reads the correlation_id attribute of the
+// first changed item that has one
+func DynamoDBStreamCorrelationID(event events.DynamoDBEvent) string {
+	for _, record := range event.Records {
+		attribute, ok := record.Change.NewImage[CorrelationIDAttribute]
+		if ok && attribute.DataType() == events.DataTypeString && attribute.String() != "" {
+			return attribute.String()
+		}
+	}
+	return ""
+}
+
+// S3CorrelationID returns the S3 request ID of the first record, which ties
+// the notification to the request that changed the object
+func S3CorrelationID(event events.S3Event) string {
+	for _, record := range event.Records {
+		if requestID := record.ResponseElements["x-amz-request-id"]; requestID != "" {
+			return requestID
+		}
+	}
+	return ""
+}
+
+// requestIDFor prefers the ID the API event source assigned, then the
+// Lambda request ID
+func requestIDFor(ctx context.Context, in any) string {
+	if request, ok := in.(apievent.Request); ok && apievent.RequestID(request) != "" {
+		return apievent.RequestID(request)
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		return lc.AwsRequestID
+	}
+	return uuid.New().String()
+}
+
+// describeEvent adds the trigger-specific fields of an event to a log entry
+func describeEvent(entry *zerolog.Event, in any) {
+	switch event := in.(type) {
+	case apievent.Request:
+		entry.Str("trigger", "api").
+			Str("method", apievent.Method(event)).
+			Str("path", apievent.Path(event)).
+			Interface("query_params", event.QueryStringParameters).
+			Interface("headers", event.Headers)
+	case events.SQSEvent:
+		entry.Str("trigger", "sqs").Int("record_count", len(event.Records))
+	case events.SNSEvent:
+		entry.Str("trigger", "sns").Int("record_count", len(event.Records))
+	case events.CloudWatchEvent:
+		entry.Str("trigger", "eventbridge").
+			Str("source", event.Source).
+			Str("detail_type", event.DetailType)
+	case events.DynamoDBEvent:
+		entry.Str("trigger", "dynamodb").Int("record_count", len(event.Records))
+	case events.S3Event:
+		entry.Str("trigger", "s3").Int("record_count", len(event.Records))
+	default:
+		entry.Str("trigger", fmt.Sprintf("%T", in))
+	}
+}
+
+// contextLogger returns the logger carried by ctx, or the global logger
+func contextLogger(ctx context.Context) *zerolog.Logger {
+	logger := zerolog.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		return &log.Logger
+	}
+	return logger
 }
 
 // Context helper functions
@@ -1146,4 +1705,4 @@ func GetUserID(ctx context.Context) string {
 	}
 	return ""
 }
-`
\ No newline at end of file
+`
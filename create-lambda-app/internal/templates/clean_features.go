@@ -5,43 +5,63 @@ package templates
 const CleanAPIRouter = `package api
 
 import (
-	"github.com/gin-gonic/gin"
+	"context"
+	"net/http"
+
 	"{{.Module}}/internal/usecases"
-	"{{.Module}}/pkg/middleware"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/routing"
 )
 
-// Router handles API routing
+// Router serves the API through {{.Router}}
 type Router struct {
 	userUseCase usecases.UserUseCase
+	handler     http.Handler
 }
 
-// NewRouter creates a new router
+// NewRouter creates a router with the routes of docs/openapi.yaml
 func NewRouter(userUseCase usecases.UserUseCase) *Router {
-	return &Router{
+	r := &Router{
 		userUseCase: userUseCase,
 	}
-}
 
-// Setup sets up all routes
-func (r *Router) Setup(engine *gin.Engine) {
-	// Apply global middleware
-	engine.Use(middleware.Logger())
-	engine.Use(middleware.Recovery())
-	engine.Use(middleware.RequestID())
-	
-	// API v1 routes
-	v1 := engine.Group("/v1")
+	mux := routing.New(routeMiddleware()...)
+{{- if eq .Router "gin" }}
+	users := mux.Group("/users")
 	{
-		// User routes
-		users := v1.Group("/users")
-		{
-			users.POST("", r.createUser)
-			users.GET("/:id", r.getUser)
-			users.GET("", r.listUsers)
-			users.PUT("/:id", r.updateUser)
-			users.DELETE("/:id", r.deleteUser)
-		}
-	}
+		users.POST("", r.createUser)
+		users.GET("", r.listUsers)
+		users.GET("/:id", r.getUser)
+		users.PUT("/:id", r.updateUser)
+		users.DELETE("/:id", r.deleteUser)
+	}
+{{- else if eq .Router "chi" }}
+	mux.Post("/users", r.createUser)
+	mux.Get("/users", r.listUsers)
+	mux.Get("/users/{id}", r.getUser)
+	mux.Put("/users/{id}", r.updateUser)
+	mux.Delete("/users/{id}", r.deleteUser)
+{{- else }}
+	mux.HandleFunc("POST /users", r.createUser)
+	mux.HandleFunc("GET /users", r.listUsers)
+	mux.HandleFunc("GET /users/{id}", r.getUser)
+	mux.HandleFunc("PUT /users/{id}", r.updateUser)
+	mux.HandleFunc("DELETE /users/{id}", r.deleteUser)
+{{- end }}
+	r.handler = mux
+
+	return r
+}
+
+// ServeHTTP serves a request through the routes
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
+
+// HandleRequest serves an API event through the routes. It takes the place
+// of the event dispatch of lambda.Handler in APIHandler.
+func (r *Router) HandleRequest(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	return apievent.Serve(ctx, r, request)
 }
 `
 
@@ -52,15 +72,18 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"{{.Module}}/internal/domain/repositories"
 	"{{.Module}}/internal/usecases"
 	"{{.Module}}/pkg/errors"
+	"{{.Module}}/pkg/middleware"
+	"{{.Module}}/pkg/problem"
 )
 
 // createUser handles user creation
 func (r *Router) createUser(c *gin.Context) {
 	var input usecases.CreateUserInput
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
 		return
 	}
 
@@ -70,7 +93,7 @@ func (r *Router) createUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, output)
+	c.JSON(http.StatusCreated, NewSuccessResponse(output, middleware.GetRequestID(c.Request.Context())))
 }
 
 // getUser handles getting a user
@@ -83,28 +106,23 @@ func (r *Router) getUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, NewSuccessResponse(user, middleware.GetRequestID(c.Request.Context())))
 }
 
-// listUsers handles listing users
+// listUsers handles listing users with cursor pagination
 func (r *Router) listUsers(c *gin.Context) {
-	page := 1
-	pageSize := 20
-	
-	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
-		page = p
-	}
-	if ps, err := strconv.Atoi(c.Query("page_size")); err == nil && ps > 0 && ps <= 100 {
-		pageSize = ps
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
 	}
 
-	output, err := r.userUseCase.ListUsers(c.Request.Context(), page, pageSize)
+	output, err := r.userUseCase.ListUsers(c.Request.Context(), c.Query("cursor"), limit)
 	if err != nil {
 		handleError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, output)
+	c.JSON(http.StatusOK, NewSuccessResponse(output, middleware.GetRequestID(c.Request.Context())))
 }
 
 // updateUser handles updating a user
@@ -113,7 +131,7 @@ func (r *Router) updateUser(c *gin.Context) {
 	
 	var input usecases.UpdateUserInput
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
 		return
 	}
 
@@ -123,7 +141,7 @@ func (r *Router) updateUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, NewSuccessResponse(user, middleware.GetRequestID(c.Request.Context())))
 }
 
 // deleteUser handles deleting a user
@@ -138,45 +156,185 @@ func (r *Router) deleteUser(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// handleError converts use case errors to HTTP responses
+// handleError answers a use case error with the problem of its type
 func handleError(c *gin.Context, err error) {
 	if ucErr, ok := err.(*usecases.UseCaseError); ok {
 		switch ucErr.Type {
 		case usecases.ErrTypeValidation:
-			c.JSON(http.StatusBadRequest, gin.H{"error": ucErr.Message})
+			writeProblem(c, problem.New(problem.TypeValidation, ucErr.Message))
 		case usecases.ErrTypeNotFound:
-			c.JSON(http.StatusNotFound, gin.H{"error": ucErr.Message})
+			writeProblem(c, problem.New(problem.TypeNotFound, ucErr.Message))
 		case usecases.ErrTypeConflict:
-			c.JSON(http.StatusConflict, gin.H{"error": ucErr.Message})
+			writeProblem(c, problem.New(problem.TypeConflict, ucErr.Message))
 		case usecases.ErrTypeUnauthorized:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": ucErr.Message})
+			writeProblem(c, problem.New(problem.TypeUnauthorized, ucErr.Message))
 		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			writeProblem(c, problem.Internal())
 		}
 		return
 	}
 
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	// A concurrent writer won an optimistic-locking race
+	var conflict *repositories.ConflictError
+	if errors.As(err, &conflict) {
+		err = errors.NewConflictError(conflict.Error()).WithCause(conflict)
+	}
+	if errors.IsConflictError(err) {
+		writeProblem(c, problem.New(problem.TypeConflict, "Resource was modified by another request, reload and retry"))
+		return
+	}
+
+	writeProblem(c, problem.FromError(err))
+}
+
+// writeProblem answers the request with p and stops the handler chain
+func writeProblem(c *gin.Context, p *problem.Problem) {
+	problem.Write(c.Writer, c.Request, p)
+	c.Abort()
 }
 `
 
-const CleanAPIMiddleware = `package api
+const CleanAPIHandlersHTTP = `package api
 
 import (
-	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+
+	"{{.Module}}/internal/domain/repositories"
+	"{{.Module}}/internal/usecases"
+	"{{.Module}}/pkg/errors"
 	"{{.Module}}/pkg/middleware"
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/routing"
 )
 
-// ApplyMiddleware applies API-specific middleware
-func ApplyMiddleware(engine *gin.Engine) {
-	// CORS middleware
-	engine.Use(middleware.CORS([]string{"*"}))
-	
-	// Rate limiting
-	engine.Use(middleware.RateLimit())
-	
-	// Request validation
-	engine.Use(middleware.ValidateHeaders())
+// createUser handles user creation
+func (r *Router) createUser(w http.ResponseWriter, req *http.Request) {
+	var input usecases.CreateUserInput
+	if err := routing.Decode(req, &input); err != nil {
+		problem.Write(w, req, problem.New(problem.TypeValidation, err.Error()))
+		return
+	}
+
+	output, err := r.userUseCase.CreateUser(req.Context(), input)
+	if err != nil {
+		handleError(w, req, err)
+		return
+	}
+
+	routing.JSON(w, req, http.StatusCreated, NewSuccessResponse(output, middleware.GetRequestID(req.Context())))
+}
+
+// getUser handles getting a user
+func (r *Router) getUser(w http.ResponseWriter, req *http.Request) {
+	user, err := r.userUseCase.GetUser(req.Context(), routing.Param(req, "id"))
+	if err != nil {
+		handleError(w, req, err)
+		return
+	}
+
+	routing.JSON(w, req, http.StatusOK, NewSuccessResponse(user, middleware.GetRequestID(req.Context())))
+}
+
+// listUsers handles listing users with cursor pagination
+func (r *Router) listUsers(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit := 20
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	output, err := r.userUseCase.ListUsers(req.Context(), query.Get("cursor"), limit)
+	if err != nil {
+		handleError(w, req, err)
+		return
+	}
+
+	routing.JSON(w, req, http.StatusOK, NewSuccessResponse(output, middleware.GetRequestID(req.Context())))
+}
+
+// updateUser handles updating a user
+func (r *Router) updateUser(w http.ResponseWriter, req *http.Request) {
+	var input usecases.UpdateUserInput
+	if err := routing.Decode(req, &input); err != nil {
+		problem.Write(w, req, problem.New(problem.TypeValidation, err.Error()))
+		return
+	}
+
+	user, err := r.userUseCase.UpdateUser(req.Context(), routing.Param(req, "id"), input)
+	if err != nil {
+		handleError(w, req, err)
+		return
+	}
+
+	routing.JSON(w, req, http.StatusOK, NewSuccessResponse(user, middleware.GetRequestID(req.Context())))
+}
+
+// deleteUser handles deleting a user
+func (r *Router) deleteUser(w http.ResponseWriter, req *http.Request) {
+	if err := r.userUseCase.DeleteUser(req.Context(), routing.Param(req, "id")); err != nil {
+		handleError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleError answers a use case error with the problem of its type
+func handleError(w http.ResponseWriter, req *http.Request, err error) {
+	if ucErr, ok := err.(*usecases.UseCaseError); ok {
+		switch ucErr.Type {
+		case usecases.ErrTypeValidation:
+			problem.Write(w, req, problem.New(problem.TypeValidation, ucErr.Message))
+		case usecases.ErrTypeNotFound:
+			problem.Write(w, req, problem.New(problem.TypeNotFound, ucErr.Message))
+		case usecases.ErrTypeConflict:
+			problem.Write(w, req, problem.New(problem.TypeConflict, ucErr.Message))
+		case usecases.ErrTypeUnauthorized:
+			problem.Write(w, req, problem.New(problem.TypeUnauthorized, ucErr.Message))
+		default:
+			problem.Write(w, req, problem.Internal())
+		}
+		return
+	}
+
+	// A concurrent writer won an optimistic-locking race
+	var conflict *repositories.ConflictError
+	if errors.As(err, &conflict) {
+		err = errors.NewConflictError(conflict.Error()).WithCause(conflict)
+	}
+	if errors.IsConflictError(err) {
+		problem.Write(w, req, problem.New(problem.TypeConflict, "Resource was modified by another request, reload and retry"))
+		return
+	}
+
+	problem.Write(w, req, problem.FromError(err))
+}
+`
+
+const CleanAPIMiddleware = `package api
+
+import (
+	"net/http"
+
+	"{{.Module}}/pkg/routing"
+)
+
+// routeMiddleware is the middleware of the routes. Request IDs, logging
+// and request validation already run around every event in the middleware
+// chain of APIHandler.
+func routeMiddleware() []routing.Middleware {
+	return []routing.Middleware{
+		noStore,
+	}
+}
+
+// noStore keeps clients and proxies from caching user data
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
 }
 `
 
@@ -184,25 +342,16 @@ const CleanAPIResponses = `package api
 
 import (
 	"time"
-
-	"github.com/google/uuid"
 )
 
-// Response is the standard API response
+// Response is the standard API response. Errors are not wrapped in it:
+// they are answered with the problem documents of pkg/problem.
 type Response struct {
 	Success bool        ` + "`json:\"success\"`" + `
 	Data    interface{} ` + "`json:\"data,omitempty\"`" + `
-	Error   *Error      ` + "`json:\"error,omitempty\"`" + `
 	Meta    *Meta       ` + "`json:\"meta\"`" + `
 }
 
-// Error represents an API error
-type Error struct {
-	Type    string                 ` + "`json:\"type\"`" + `
-	Message string                 ` + "`json:\"message\"`" + `
-	Details map[string]interface{} ` + "`json:\"details,omitempty\"`" + `
-}
-
 // Meta contains response metadata
 type Meta struct {
 	RequestID string    ` + "`json:\"request_id\"`" + `
@@ -220,21 +369,6 @@ func NewSuccessResponse(data interface{}, requestID string) Response {
 		},
 	}
 }
-
-// NewErrorResponse creates an error response
-func NewErrorResponse(errType, message string, requestID string) Response {
-	return Response{
-		Success: false,
-		Error: &Error{
-			Type:    errType,
-			Message: message,
-		},
-		Meta: &Meta{
-			RequestID: requestID,
-			Timestamp: time.Now().UTC(),
-		},
-	}
-}
 `
 
 const CleanDynamoDBClient = `package database
@@ -243,10 +377,11 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"{{.Module}}/internal/infrastructure/config"
+	"{{.Module}}/pkg/resilience"
+	"{{.Module}}/pkg/tracing"
 )
 
 // DynamoDBClient wraps the AWS DynamoDB client
@@ -257,12 +392,14 @@ type DynamoDBClient struct {
 
 // NewDynamoDBClient creates a new DynamoDB client
 func NewDynamoDBClient(cfg *config.Config) (*DynamoDBClient, error) {
-	awsConfig, err := config.LoadDefaultConfig(context.Background(),
-		config.WithRegion(cfg.AWSRegion),
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion(cfg.AWSRegion),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
+	tracing.InstrumentAWS(&awsConfig)
+	resilience.InstrumentAWS(&awsConfig, resilience.DefaultBackoff)
 
 	client := dynamodb.NewFromConfig(awsConfig)
 
@@ -294,8 +431,10 @@ const CleanDynamoDBRepository = `package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -303,31 +442,32 @@ import (
 	"{{.Module}}/internal/domain/repositories"
 )
 
-// dynamoDBUserRepository implements UserRepository using DynamoDB
+// dynamoDBUserRepository implements UserRepository on the single-table
+// design generated from access-patterns.yaml
 type dynamoDBUserRepository struct {
-	client    *DynamoDBClient
-	tableName string
+	client *DynamoDBClient
+	table  *Table
 }
 
 // NewDynamoDBUserRepository creates a new DynamoDB user repository
 func NewDynamoDBUserRepository(client *DynamoDBClient) repositories.UserRepository {
 	return &dynamoDBUserRepository{
-		client:    client,
-		tableName: client.GetTableName("users"),
+		client: client,
+		table:  NewTable(client.GetClient(), client.GetTableName("{{.DataModel.Table.Name}}")).WithCursorSecret(client.config.CursorSecret),
 	}
 }
 
 // Create saves a new user
 func (r *dynamoDBUserRepository) Create(ctx context.Context, user *entities.User) error {
-	item, err := attributevalue.MarshalMap(user)
+	item, err := marshalUser(user)
 	if err != nil {
-		return fmt.Errorf("failed to marshal user: %w", err)
+		return err
 	}
 
 	_, err = r.client.GetClient().PutItem(ctx, &dynamodb.PutItemInput{
-		TableName:           aws.String(r.tableName),
+		TableName:           aws.String(r.table.Name()),
 		Item:                item,
-		ConditionExpression: aws.String("attribute_not_exists(id)"),
+		ConditionExpression: aws.String("attribute_not_exists(" + TablePartitionKey + ")"),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
@@ -338,23 +478,12 @@ func (r *dynamoDBUserRepository) Create(ctx context.Context, user *entities.User
 
 // GetByID retrieves a user by ID
 func (r *dynamoDBUserRepository) GetByID(ctx context.Context, id string) (*entities.User, error) {
-	result, err := r.client.GetClient().GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(r.tableName),
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: id},
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
-	}
-
-	if result.Item == nil {
-		return nil, repositories.ErrUserNotFound
-	}
-
 	var user entities.User
-	if err := attributevalue.UnmarshalMap(result.Item, &user); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	if err := r.table.GetUserByID(ctx, id, &user); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, repositories.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	return &user, nil
@@ -362,63 +491,54 @@ func (r *dynamoDBUserRepository) GetByID(ctx context.Context, id string) (*entit
 
 // GetByEmail retrieves a user by email
 func (r *dynamoDBUserRepository) GetByEmail(ctx context.Context, email string) (*entities.User, error) {
-	result, err := r.client.GetClient().Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(r.tableName),
-		IndexName:              aws.String("email-index"),
-		KeyConditionExpression: aws.String("email = :email"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":email": &types.AttributeValueMemberS{Value: email},
-		},
-		Limit: aws.Int32(1),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to query user by email: %w", err)
-	}
-
-	if len(result.Items) == 0 {
-		return nil, repositories.ErrUserNotFound
-	}
-
 	var user entities.User
-	if err := attributevalue.UnmarshalMap(result.Items[0], &user); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	if err := r.table.GetUserByEmail(ctx, email, &user); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, repositories.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to query user by email: %w", err)
 	}
 
 	return &user, nil
 }
 
-// Update saves changes to an existing user
+// Update saves changes to an existing user with optimistic locking: the
+// write only lands if the stored user still has the version that was read
 func (r *dynamoDBUserRepository) Update(ctx context.Context, user *entities.User) error {
-	user.UpdateVersion()
+	next := *user
+	next.UpdateVersion()
 
-	item, err := attributevalue.MarshalMap(user)
+	item, err := marshalUser(&next)
 	if err != nil {
-		return fmt.Errorf("failed to marshal user: %w", err)
+		return err
 	}
 
 	_, err = r.client.GetClient().PutItem(ctx, &dynamodb.PutItemInput{
-		TableName:           aws.String(r.tableName),
+		TableName:           aws.String(r.table.Name()),
 		Item:                item,
-		ConditionExpression: aws.String("attribute_exists(id) AND version = :old_version"),
+		ConditionExpression: aws.String("attribute_exists(" + TablePartitionKey + ") AND version = :expected_version"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":old_version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", user.Version-1)},
+			":expected_version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", user.Version)},
 		},
 	})
 	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return &repositories.ConflictError{ID: user.ID, Version: user.Version}
+		}
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
+	*user = next
 	return nil
 }
 
 // Delete removes a user
 func (r *dynamoDBUserRepository) Delete(ctx context.Context, id string) error {
 	_, err := r.client.GetClient().DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(r.tableName),
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: id},
-		},
-		ConditionExpression: aws.String("attribute_exists(id)"),
+		TableName:           aws.String(r.table.Name()),
+		Key:                 UserKeys{ID: id}.Key(),
+		ConditionExpression: aws.String("attribute_exists(" + TablePartitionKey + ")"),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
@@ -427,41 +547,50 @@ func (r *dynamoDBUserRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-// List retrieves users with pagination
-func (r *dynamoDBUserRepository) List(ctx context.Context, offset, limit int) ([]*entities.User, error) {
-	// DynamoDB doesn't support offset directly, need to implement with LastEvaluatedKey
-	// This is a simplified implementation
-	result, err := r.client.GetClient().Scan(ctx, &dynamodb.ScanInput{
-		TableName: aws.String(r.tableName),
-		Limit:     aws.Int32(int32(limit)),
-	})
+// List retrieves a page of users in creation order
+func (r *dynamoDBUserRepository) List(ctx context.Context, cursor string, limit int) ([]*entities.User, string, error) {
+	users := []*entities.User{}
+	next, err := r.table.ListUsers(ctx, limit, cursor, &users)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list users: %w", err)
-	}
-
-	users := make([]*entities.User, 0, len(result.Items))
-	for _, item := range result.Items {
-		var user entities.User
-		if err := attributevalue.UnmarshalMap(item, &user); err != nil {
-			continue
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, "", repositories.ErrInvalidCursor
 		}
-		users = append(users, &user)
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
 	}
 
-	return users, nil
+	return users, next, nil
 }
 
 // Count returns the total number of users
 func (r *dynamoDBUserRepository) Count(ctx context.Context) (int64, error) {
-	result, err := r.client.GetClient().Scan(ctx, &dynamodb.ScanInput{
-		TableName: aws.String(r.tableName),
-		Select:    types.SelectCount,
-	})
+	count, err := r.table.ListUsersCount(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count users: %w", err)
 	}
 
-	return int64(result.Count), nil
+	return count, nil
+}
+
+// marshalUser converts a user to an item carrying its table and index keys
+func marshalUser(user *entities.User) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	keys := UserKeys{
+{{- with .DataModel.Entity "User" }}
+{{- $entity := . }}
+{{- range .Fields }}
+		{{ $entity.FieldKey . }} {{ .UserExpr "user" }},
+{{- end }}
+{{- end }}
+	}
+	for name, value := range keys.Attributes() {
+		item[name] = value
+	}
+
+	return item, nil
 }
 `
 
@@ -479,12 +608,20 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"{{.Module}}/internal/infrastructure/config"
+	"{{.Module}}/pkg/resilience"
+	"{{.Module}}/pkg/tracing"
 )
 
+// MessageSender sends messages to a queue
+type MessageSender interface {
+	SendMessage(ctx context.Context, messageType string, payload interface{}) error
+	SendBatch(ctx context.Context, messages []Message) error
+}
+
 // SQSClient wraps the AWS SQS client
 type SQSClient struct {
 	client   *sqs.Client
@@ -493,14 +630,21 @@ type SQSClient struct {
 
 // NewSQSClient creates a new SQS client
 func NewSQSClient(cfg *config.Config) (*SQSClient, error) {
-	awsConfig, err := config.LoadDefaultConfig(context.Background(),
-		config.WithRegion(cfg.AWSRegion),
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion(cfg.AWSRegion),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
+	tracing.InstrumentAWS(&awsConfig)
+	resilience.InstrumentAWS(&awsConfig, resilience.DefaultBackoff)
 
-	client := sqs.NewFromConfig(awsConfig)
+	client := sqs.NewFromConfig(awsConfig, func(o *sqs.Options) {
+		// Use custom endpoint for local development
+		if cfg.SQSEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.SQSEndpoint)
+		}
+	})
 
 	return &SQSClient{
 		client:   client,
@@ -508,7 +652,8 @@ func NewSQSClient(cfg *config.Config) (*SQSClient, error) {
 	}, nil
 }
 
-// SendMessage sends a message to the queue
+// SendMessage sends a message to the queue. The message carries the trace
+// context of ctx in its attributes.
 func (c *SQSClient) SendMessage(ctx context.Context, messageType string, payload interface{}) error {
 	body, err := json.Marshal(map[string]interface{}{
 		"type":    messageType,
@@ -521,12 +666,12 @@ func (c *SQSClient) SendMessage(ctx context.Context, messageType string, payload
 	_, err = c.client.SendMessage(ctx, &sqs.SendMessageInput{
 		QueueUrl:    aws.String(c.queueURL),
 		MessageBody: aws.String(string(body)),
-		MessageAttributes: map[string]types.MessageAttributeValue{
+		MessageAttributes: tracing.InjectSQS(ctx, map[string]types.MessageAttributeValue{
 			"Type": {
 				DataType:    aws.String("String"),
 				StringValue: aws.String(messageType),
 			},
-		},
+		}),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
@@ -546,8 +691,9 @@ func (c *SQSClient) SendBatch(ctx context.Context, messages []Message) error {
 		}
 
 		entries = append(entries, types.SendMessageBatchRequestEntry{
-			Id:          aws.String(fmt.Sprintf("%d", i)),
-			MessageBody: aws.String(string(body)),
+			Id:                aws.String(fmt.Sprintf("%d", i)),
+			MessageBody:       aws.String(string(body)),
+			MessageAttributes: tracing.InjectSQS(ctx, nil),
 		})
 	}
 
@@ -577,39 +723,95 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"{{.Module}}/internal/usecases"
+	"{{.Module}}/pkg/middleware"
+	"{{.Module}}/pkg/tracing"
 	"github.com/rs/zerolog/log"
 )
 
 // sqsHandler handles SQS messages
 type sqsHandler struct {
 	processMessageUseCase usecases.ProcessMessageUseCase
+	idempotency           middleware.IdempotencyConfig
 }
 
-// NewSQSHandler creates a new SQS handler
+// NewSQSHandler creates a new SQS handler that processes every message it
+// is delivered; see WithIdempotency
 func NewSQSHandler(processMessageUseCase usecases.ProcessMessageUseCase) *sqsHandler {
 	return &sqsHandler{
 		processMessageUseCase: processMessageUseCase,
 	}
 }
 
-// HandleRequest processes SQS events
-func (h *sqsHandler) HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) error {
+// WithIdempotency skips messages that were already processed, remembered in
+// cfg.Store. SQS often redelivers a message to another container, so use a
+// store every container shares, such as a DynamoDBIdempotencyStore. A
+// KeyPath keys messages on a field of their body, e.g. "payload.order_id".
+func (h *sqsHandler) WithIdempotency(cfg middleware.IdempotencyConfig) *sqsHandler {
+	h.idempotency = cfg
+	return h
+}
+
+// Handler returns HandleRequest behind the default middleware chain, ready
+// for lambda.Start
+func (h *sqsHandler) Handler() middleware.HandlerFunc[events.SQSEvent, events.SQSEventResponse] {
+	return middleware.DefaultChain[events.SQSEvent, events.SQSEventResponse]()(h.HandleRequest)
+}
+
+// HandleRequest processes SQS events. Every record is attempted, in a span
+// that continues the trace it was sent in, and the ones that fail are
+// reported back so SQS retries only those messages. With WithIdempotency,
+// a message SQS delivers again after it was processed is skipped.
+func (h *sqsHandler) HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	log.Ctx(ctx).Info().
 		Int("message_count", len(sqsEvent.Records)).
 		Msg("Processing SQS event")
 
+	var response events.SQSEventResponse
 	for _, record := range sqsEvent.Records {
-		if err := h.processMessage(ctx, record); err != nil {
+		err := tracing.ProcessSQSMessage(ctx, record, func(ctx context.Context) error {
+			return h.processOnce(ctx, record)
+		})
+		if err != nil {
 			log.Ctx(ctx).Error().
 				Err(err).
 				Str("message_id", record.MessageId).
+				Str("message_correlation_id", middleware.SQSMessageCorrelationID(record)).
 				Msg("Failed to process message")
-			// Return error to retry the message
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+		}
+	}
+
+	return response, nil
+}
+
+// processOnce runs processMessage unless the handler has an idempotency
+// store and the message was already processed, keyed by the value at the KeyPath of the idempotency config in its body,
+// which also catches a producer sending the message twice. Without a
+// KeyPath it is keyed by its queue and message ID, since the receipt handle
+// changes with every delivery.
+func (h *sqsHandler) processOnce(ctx context.Context, record events.SQSMessage) error {
+	if h.idempotency.Store == nil {
+		return h.processMessage(ctx, record)
+	}
+
+	payload := json.RawMessage(record.Body)
+	if h.idempotency.KeyPath == "" {
+		var err error
+		payload, err = json.Marshal(map[string]string{
+			"queue":      record.EventSourceARN,
+			"message_id": record.MessageId,
+		})
+		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	_, err := middleware.IdempotentEvent(h.idempotency, func(ctx context.Context, _ json.RawMessage) (json.RawMessage, error) {
+		return nil, h.processMessage(ctx, record)
+	})(ctx, payload)
+	return err
 }
 
 func (h *sqsHandler) processMessage(ctx context.Context, record events.SQSMessage) error {
@@ -0,0 +1,944 @@
+package templates
+
+// Configuration source templates: SSM Parameter Store, AppConfig and their
+// local file stand-ins
+
+const ConfigSource = `// Package configsource fills configuration structs from more places than
+// the environment. Fields tagged ssm come from SSM Parameter Store and
+// fields tagged appconfig from an AppConfig configuration profile, such as
+// the project's feature flags:
+//
+//	CursorSecret string ` + "`" + `env:"CURSOR_SECRET" ssm:"cursor-secret"` + "`" + `
+//	DebugLogging bool   ` + "`" + `env:"DEBUG_LOGGING" appconfig:"debugLogging.enabled"` + "`" + `
+//
+// Each field is set from the first of these that has a value:
+//
+//  1. the environment variable of its env tag, .env files included, unless
+//     it is empty
+//  2. AppConfig
+//  3. SSM Parameter Store
+//  4. its envDefault tag
+//
+// so a variable set in the environment overrides every other source, and a
+// tagged field still needs an env tag. Values are parsed as caarlos0/env
+// parses variables.
+//
+// Warm containers load again every RefreshInterval: Wrap refreshes the
+// configuration passed to Watch before an invocation once it is due,
+// swapping in a new configuration rather than changing the current one.
+// Every source has a local file stand-in, a JSON file read in place of the
+// AWS service, so local runs and tests need no AWS account.
+package configsource
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/metrics"
+)
+
+// Source reads configuration values by key: parameter names for SSM, paths
+// through the document for AppConfig
+type Source interface {
+	Values(ctx context.Context) (map[string]string, error)
+}
+
+// Validator is implemented by configurations that check themselves. Refresh
+// keeps the current configuration when a new one is invalid.
+type Validator interface {
+	Validate() error
+}
+
+// Options configures NewLoader
+type Options struct {
+	// SSM supplies the fields tagged ssm; without it they are left to the
+	// environment
+	SSM Source
+
+	// AppConfig supplies the fields tagged appconfig
+	AppConfig Source
+
+	// RefreshInterval is how long a load is used before Refresh loads
+	// again; zero never refreshes
+	RefreshInterval time.Duration
+
+	// Environment replaces the environment variables of the process
+	Environment map[string]string
+
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// Loader loads configuration structs from the environment and its sources
+type Loader struct {
+	opts Options
+
+	mu     sync.Mutex
+	loaded time.Time
+	last   map[string]map[string]string
+}
+
+// NewLoader creates a Loader
+func NewLoader(opts Options) *Loader {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Loader{opts: opts, last: map[string]map[string]string{}}
+}
+
+// Load fills cfg, a pointer to a struct, from the environment and the
+// sources. A source that fails fails the load.
+func (l *Loader) Load(ctx context.Context, cfg interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	environment, err := l.environment(ctx, reflect.TypeOf(cfg), false)
+	if err != nil {
+		return err
+	}
+	if err := env.ParseWithOptions(cfg, env.Options{Environment: environment}); err != nil {
+		return fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	l.loaded = l.opts.Now()
+	return nil
+}
+
+// Refresh loads a new configuration of cfg's type once RefreshInterval has
+// passed since the last load, or returns nil while it has not. A source
+// that fails keeps the values it returned last. Only a configuration that
+// parses and, for a Validator, is valid is returned. cfg itself is left
+// alone, so goroutines still reading it do not race with the refresh.
+func (l *Loader) Refresh(ctx context.Context, cfg interface{}) (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.opts.Now()
+	if l.opts.RefreshInterval <= 0 || now.Sub(l.loaded) < l.opts.RefreshInterval {
+		return nil, nil
+	}
+	// Failed refreshes wait for the next interval too, rather than slowing
+	// down every invocation
+	l.loaded = now
+
+	environment, err := l.environment(ctx, reflect.TypeOf(cfg), true)
+	if err != nil {
+		return nil, err
+	}
+	fresh := reflect.New(reflect.TypeOf(cfg).Elem()).Interface()
+	if err := env.ParseWithOptions(fresh, env.Options{Environment: environment}); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	if validator, ok := fresh.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	return fresh, nil
+}
+
+// environment returns the variables cfg is parsed from: the values of the
+// sources under the names of the env tags of their fields, overridden by
+// the environment. An empty variable does not override a source, so a
+// blank line in .env does not hide a parameter. With keepLast a source that
+// fails keeps its last values.
+func (l *Loader) environment(ctx context.Context, typ reflect.Type, keepLast bool) (map[string]string, error) {
+	environment := map[string]string{}
+	// In order of precedence, lowest first
+	for _, tag := range []string{"ssm", "appconfig"} {
+		source := l.opts.SSM
+		if tag == "appconfig" {
+			source = l.opts.AppConfig
+		}
+		if source == nil {
+			continue
+		}
+
+		bindings, err := tagBindings(typ, tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(bindings) == 0 {
+			continue
+		}
+
+		values, err := source.Values(ctx)
+		switch {
+		case err != nil && !keepLast:
+			return nil, fmt.Errorf("failed to read %s: %w", tag, err)
+		case err != nil:
+			log.Ctx(ctx).Warn().Err(err).Str("source", tag).Msg("Failed to read configuration source, keeping its last values")
+			values = l.last[tag]
+		default:
+			l.last[tag] = values
+		}
+
+		for _, binding := range bindings {
+			if value, ok := values[binding.key]; ok {
+				environment[binding.env] = value
+			}
+		}
+	}
+
+	variables := l.opts.Environment
+	if variables == nil {
+		variables = map[string]string{}
+		for _, variable := range os.Environ() {
+			name, value, _ := strings.Cut(variable, "=")
+			variables[name] = value
+		}
+	}
+	for name, value := range variables {
+		if _, ok := environment[name]; ok && value == "" {
+			continue
+		}
+		environment[name] = value
+	}
+	return environment, nil
+}
+
+// binding ties the key of a field in a source to its environment variable
+type binding struct {
+	key string
+	env string
+}
+
+// tagBindings returns the bindings of the fields of the struct typ points
+// to that have tag
+func tagBindings(typ reflect.Type, tag string) ([]binding, error) {
+	if typ == nil || typ.Kind() != reflect.Pointer || typ.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("configuration must be a pointer to a struct, not %v", typ)
+	}
+	typ = typ.Elem()
+
+	var bindings []binding
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key, ok := field.Tag.Lookup(tag)
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+		if name == "" {
+			return nil, fmt.Errorf("field %s has a %s tag but no env tag", field.Name, tag)
+		}
+		bindings = append(bindings, binding{key: key, env: name})
+	}
+	return bindings, nil
+}
+
+var watched struct {
+	sync.Mutex
+	refresh func(ctx context.Context) (bool, error)
+}
+
+// Watch makes Wrap refresh the configuration current points to with
+// loader. A refresh stores a new configuration in current instead of
+// changing the one it pointed to, so code holding that one keeps reading
+// consistent values and sees the new ones by loading current again.
+// onRefresh, if not nil, runs with the new configuration, to apply
+// settings read at startup such as the log level.
+func Watch[T any](loader *Loader, current *atomic.Pointer[T], onRefresh func(ctx context.Context, cfg *T)) {
+	watched.Lock()
+	defer watched.Unlock()
+	watched.refresh = func(ctx context.Context) (bool, error) {
+		fresh, err := loader.Refresh(ctx, current.Load())
+		if err != nil || fresh == nil {
+			return false, err
+		}
+
+		cfg := fresh.(*T)
+		current.Store(cfg)
+		if onRefresh != nil {
+			onRefresh(ctx, cfg)
+		}
+		return true, nil
+	}
+}
+
+// Wrap refreshes the configuration passed to Watch before an invocation of
+// handler once its refresh interval has passed. An invocation that loads
+// the configuration from Watch's pointer sees the values of one refresh
+// throughout, even when invocations overlap as in the local API server;
+// values copied out of the configuration at startup keep their old value.
+func Wrap[In, Out any](handler func(ctx context.Context, in In) (Out, error)) func(ctx context.Context, in In) (Out, error) {
+	return func(ctx context.Context, in In) (Out, error) {
+		refresh(ctx)
+		return handler(ctx, in)
+	}
+}
+
+func refresh(ctx context.Context) {
+	watched.Lock()
+	defer watched.Unlock()
+	if watched.refresh == nil {
+		return
+	}
+
+	refreshed, err := watched.refresh(ctx)
+	switch {
+	case err != nil:
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to refresh configuration, keeping the current one")
+		metrics.Add(ctx, "ConfigRefreshFailures", metrics.Count, 1)
+	case refreshed:
+		log.Ctx(ctx).Debug().Msg("Configuration refreshed")
+	}
+}
+`
+
+const ConfigSourceAWS = `package configsource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/caarlos0/env/v10"
+
+	"{{.Module}}/pkg/resilience"
+	"{{.Module}}/pkg/tracing"
+)
+
+// Settings says where configuration comes from. It is read from the
+// environment alone. A source's file, when set, is read in place of the
+// AWS service.
+type Settings struct {
+	SSMPath string ` + "`env:\"CONFIG_SSM_PATH\"`" + `
+	SSMFile string ` + "`env:\"CONFIG_SSM_FILE\"`" + `
+
+	AppConfigApplication string ` + "`env:\"CONFIG_APPCONFIG_APPLICATION\"`" + `
+	AppConfigEnvironment string ` + "`env:\"CONFIG_APPCONFIG_ENVIRONMENT\"`" + `
+	AppConfigProfile     string ` + "`env:\"CONFIG_APPCONFIG_PROFILE\"`" + `
+	AppConfigFile        string ` + "`env:\"CONFIG_APPCONFIG_FILE\"`" + `
+
+	RefreshInterval time.Duration ` + "`env:\"CONFIG_REFRESH_INTERVAL\" envDefault:\"5m\"`" + `
+}
+
+// FromEnvironment creates a Loader for the sources the environment sets
+// up with Settings. Sources that are not set up are left out.
+func FromEnvironment(ctx context.Context) (*Loader, error) {
+	var settings Settings
+	if err := env.Parse(&settings); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration source settings: %w", err)
+	}
+
+	var (
+		awsConfig aws.Config
+		loaded    bool
+	)
+	loadAWSConfig := func() (aws.Config, error) {
+		if loaded {
+			return awsConfig, nil
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		tracing.InstrumentAWS(&cfg)
+		resilience.InstrumentAWS(&cfg, resilience.DefaultBackoff)
+		awsConfig, loaded = cfg, true
+		return awsConfig, nil
+	}
+
+	opts := Options{RefreshInterval: settings.RefreshInterval}
+	switch {
+	case settings.SSMFile != "":
+		opts.SSM = NewFileSource(settings.SSMFile)
+	case settings.SSMPath != "":
+		cfg, err := loadAWSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SSM = NewSSMSource(ssm.NewFromConfig(cfg), settings.SSMPath)
+	}
+	switch {
+	case settings.AppConfigFile != "":
+		opts.AppConfig = NewFileSource(settings.AppConfigFile)
+	case settings.AppConfigApplication != "":
+		cfg, err := loadAWSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.AppConfig = NewAppConfigSource(appconfigdata.NewFromConfig(cfg), settings.AppConfigApplication, settings.AppConfigEnvironment, settings.AppConfigProfile)
+	}
+	return NewLoader(opts), nil
+}
+
+// SSMAPI is the part of the SSM client SSMSource uses
+type SSMAPI interface {
+	GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+}
+
+// SSMSource reads the parameters under a path of Parameter Store,
+// decrypting SecureStrings. Keys are parameter names relative to the path:
+// /orders/prod/db/password under /orders/prod is db/password. StringLists
+// are comma separated, as envSeparator expects.
+type SSMSource struct {
+	client SSMAPI
+	path   string
+}
+
+// NewSSMSource creates a source for the parameters under path
+func NewSSMSource(client SSMAPI, path string) *SSMSource {
+	return &SSMSource{client: client, path: path}
+}
+
+// Values implements Source
+func (s *SSMSource) Values(ctx context.Context) (map[string]string, error) {
+	prefix := strings.TrimSuffix(s.path, "/") + "/"
+	paginator := ssm.NewGetParametersByPathPaginator(s.client, &ssm.GetParametersByPathInput{
+		Path:           aws.String(s.path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+
+	values := map[string]string{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parameters under %s: %w", s.path, err)
+		}
+		for _, parameter := range page.Parameters {
+			values[strings.TrimPrefix(aws.ToString(parameter.Name), prefix)] = aws.ToString(parameter.Value)
+		}
+	}
+	return values, nil
+}
+
+// AppConfigAPI is the part of the AppConfig Data client AppConfigSource
+// uses
+type AppConfigAPI interface {
+	StartConfigurationSession(ctx context.Context, params *appconfigdata.StartConfigurationSessionInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.StartConfigurationSessionOutput, error)
+	GetLatestConfiguration(ctx context.Context, params *appconfigdata.GetLatestConfigurationInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.GetLatestConfigurationOutput, error)
+}
+
+// appConfigMinPollInterval is the shortest poll interval AppConfig allows;
+// polling sooner fails
+const appConfigMinPollInterval = 15
+
+// AppConfigSource reads a JSON configuration profile of AppConfig, such as
+// a feature flag profile. Keys are paths through the document joined with
+// dots: the flag checkout of a feature flag profile is checkout.enabled.
+// The source polls a configuration session, which only returns the
+// document when it has changed, so RefreshInterval should be at least 15
+// seconds.
+type AppConfigSource struct {
+	client                            AppConfigAPI
+	application, environment, profile string
+
+	mu     sync.Mutex
+	token  *string
+	values map[string]string
+}
+
+// NewAppConfigSource creates a source for a configuration profile of an
+// application environment
+func NewAppConfigSource(client AppConfigAPI, application, environment, profile string) *AppConfigSource {
+	return &AppConfigSource{
+		client:      client,
+		application: application,
+		environment: environment,
+		profile:     profile,
+	}
+}
+
+// Values implements Source
+func (s *AppConfigSource) Values(ctx context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		session, err := s.client.StartConfigurationSession(ctx, &appconfigdata.StartConfigurationSessionInput{
+			ApplicationIdentifier:                aws.String(s.application),
+			EnvironmentIdentifier:                aws.String(s.environment),
+			ConfigurationProfileIdentifier:       aws.String(s.profile),
+			RequiredMinimumPollIntervalInSeconds: aws.Int32(appConfigMinPollInterval),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start AppConfig session: %w", err)
+		}
+		s.token = session.InitialConfigurationToken
+	}
+
+	latest, err := s.client.GetLatestConfiguration(ctx, &appconfigdata.GetLatestConfigurationInput{
+		ConfigurationToken: s.token,
+	})
+	if err != nil {
+		// Tokens expire after a day; the next poll starts a new session
+		s.token = nil
+		return nil, fmt.Errorf("failed to get AppConfig configuration: %w", err)
+	}
+	s.token = latest.NextPollConfigurationToken
+
+	// An empty configuration has not changed since the last poll
+	if len(latest.Configuration) > 0 {
+		values, err := parseDocument(latest.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AppConfig configuration: %w", err)
+		}
+		s.values = values
+	}
+	return s.values, nil
+}
+`
+
+const ConfigSourceFile = `package configsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FileSource reads a local JSON file that stands in for SSM or AppConfig:
+// an object of parameter names and values, or the document AppConfig would
+// return. The file is read on every load, so edits show up at the next
+// refresh.
+type FileSource struct {
+	path string
+}
+
+// NewFileSource creates a source for the JSON file at path
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+// Values implements Source
+func (s *FileSource) Values(ctx context.Context) (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return values, nil
+}
+
+// parseDocument flattens a JSON object into values keyed by the path to
+// each, joined with dots. Arrays are joined with commas, nulls are left
+// out.
+func parseDocument(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	flatten(values, "", document)
+	return values, nil
+}
+
+func flatten(values map[string]string, key string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, child := range value {
+			if key != "" {
+				name = key + "." + name
+			}
+			flatten(values, name, child)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[key] = strings.Join(items, ",")
+	case nil:
+	default:
+		values[key] = fmt.Sprint(value)
+	}
+}
+`
+
+// ConfigSourceLocalSSM stands in for the project's parameters in SSM
+// Parameter Store, keyed by name relative to CONFIG_SSM_PATH
+const ConfigSourceLocalSSM = `{
+  "cursor-secret": "local-cursor-secret",
+  "api-key": "local-api-key"
+}
+`
+
+// ConfigSourceLocalFlags stands in for the feature flag profile in
+// AppConfig, in the form GetLatestConfiguration returns it
+const ConfigSourceLocalFlags = `{
+  "debugLogging": {
+    "enabled": false
+  }
+}
+`
+
+const ConfigSourceTest = `package configsource
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+// staticSource returns values, or err when it is set
+type staticSource struct {
+	values map[string]string
+	err    error
+}
+
+func (s *staticSource) Values(context.Context) (map[string]string, error) {
+	return s.values, s.err
+}
+
+type testConfig struct {
+	Secret string ` + "`" + `env:"SECRET" ssm:"secret"` + "`" + `
+	Level  string ` + "`" + `env:"LEVEL" ssm:"level" appconfig:"level"` + "`" + `
+	Region string ` + "`" + `env:"REGION" ssm:"region"` + "`" + `
+	Debug  bool   ` + "`" + `env:"DEBUG" appconfig:"debug.enabled"` + "`" + `
+	Port   int    ` + "`" + `env:"PORT" envDefault:"8080"` + "`" + `
+}
+
+// testLoader is a Loader over an SSM and an AppConfig source whose clock
+// only moves when the test moves it
+type testLoader struct {
+	*Loader
+	ssm, appConfig *staticSource
+	now            time.Time
+}
+
+func newTestLoader(environment map[string]string) *testLoader {
+	l := &testLoader{
+		ssm: &staticSource{values: map[string]string{
+			"secret": "from-ssm",
+			"level":  "info",
+			"region": "us-west-2",
+		}},
+		appConfig: &staticSource{values: map[string]string{
+			"level":         "warn",
+			"debug.enabled": "false",
+		}},
+		now: time.Now(),
+	}
+	l.Loader = NewLoader(Options{
+		SSM:             l.ssm,
+		AppConfig:       l.appConfig,
+		RefreshInterval: time.Minute,
+		Environment:     environment,
+		Now:             func() time.Time { return l.now },
+	})
+	return l
+}
+
+// load loads a testConfig with environment overriding the sources
+func load(environment map[string]string) (testConfig, error) {
+	var cfg testConfig
+	err := newTestLoader(environment).Load(context.Background(), &cfg)
+	return cfg, err
+}
+
+// refreshRun is what refreshAfter saw
+type refreshRun struct {
+	err   error
+	cfg   testConfig  // the loaded configuration, after the refresh
+	fresh *testConfig // the refreshed one, nil when there was none
+}
+
+// refreshAfter loads a testConfig, turns the debug flag on and, unless
+// appConfigErr is set, refreshes after wait
+func refreshAfter(wait time.Duration, appConfigErr error) refreshRun {
+	ctx := context.Background()
+	l := newTestLoader(map[string]string{})
+
+	var run refreshRun
+	if run.err = l.Load(ctx, &run.cfg); run.err != nil {
+		return run
+	}
+	l.appConfig.values = map[string]string{"level": "warn", "debug.enabled": "true"}
+	l.appConfig.err = appConfigErr
+	l.now = l.now.Add(wait)
+
+	var fresh interface{}
+	fresh, run.err = l.Refresh(ctx, &run.cfg)
+	if fresh != nil {
+		run.fresh = fresh.(*testConfig)
+	}
+	return run
+}
+
+// refreshWatched watches a loaded testConfig, turns the debug flag on and
+// runs a handler wrapped by Wrap once the interval has passed, while
+// another goroutine keeps reading the configuration. It returns the
+// loaded configuration and the current one.
+func refreshWatched() (loaded, current *testConfig, err error) {
+	ctx := context.Background()
+	l := newTestLoader(map[string]string{})
+
+	var pointer atomic.Pointer[testConfig]
+	loaded = &testConfig{}
+	if err := l.Load(ctx, loaded); err != nil {
+		return nil, nil, err
+	}
+	pointer.Store(loaded)
+	Watch(l.Loader, &pointer, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = loaded.Debug
+			_ = pointer.Load().Debug
+		}
+	}()
+
+	l.appConfig.values = map[string]string{"level": "warn", "debug.enabled": "true"}
+	l.now = l.now.Add(time.Minute)
+	_, err = Wrap(func(context.Context, struct{}) (struct{}, error) {
+		return struct{}{}, nil
+	})(ctx, struct{}{})
+	<-done
+	return loaded, pointer.Load(), err
+}
+
+// parseFile writes contents to a file and reads it with a FileSource
+func parseFile(contents string) (map[string]string, error) {
+	dir, err := os.MkdirTemp("", "configsource")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "appconfig.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		return nil, err
+	}
+	return NewFileSource(path).Values(context.Background())
+}
+
+const flagsDocument = ` + "`" + `{"debug": {"enabled": true}, "regions": ["eu-west-1", "us-east-1"], "limit": 10, "removed": null}` + "`" + `
+
+var errUnavailable = errors.New("service unavailable")
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Loader", func() {
+	It("prefers the environment, then AppConfig, then SSM, then defaults", func() {
+		cfg, err := load(map[string]string{"REGION": "eu-west-1", "SECRET": ""})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(Equal(testConfig{Secret: "from-ssm", Level: "warn", Region: "eu-west-1", Port: 8080}))
+	})
+
+	It("fails when a field has a source tag but no env tag", func() {
+		var cfg struct {
+			Secret string ` + "`" + `ssm:"secret"` + "`" + `
+		}
+		err := newTestLoader(nil).Load(context.Background(), &cfg)
+
+		Expect(err).To(MatchError(ContainSubstring("no env tag")))
+	})
+
+	It("does not refresh before the interval has passed", func() {
+		run := refreshAfter(30*time.Second, nil)
+
+		Expect(run.err).NotTo(HaveOccurred())
+		Expect(run.fresh).To(BeNil())
+	})
+
+	It("refreshes into a new configuration once the interval has passed", func() {
+		run := refreshAfter(time.Minute, nil)
+
+		Expect(run.err).NotTo(HaveOccurred())
+		Expect(run.fresh).NotTo(BeNil())
+		Expect(run.fresh.Debug).To(BeTrue())
+		Expect(run.cfg.Debug).To(BeFalse())
+	})
+
+	It("keeps the last values of a source that fails to refresh", func() {
+		run := refreshAfter(time.Minute, errUnavailable)
+
+		Expect(run.err).NotTo(HaveOccurred())
+		Expect(run.fresh).NotTo(BeNil())
+		Expect(run.fresh.Level).To(Equal("warn"))
+		Expect(run.fresh.Debug).To(BeFalse())
+	})
+})
+
+var _ = Describe("Wrap", func() {
+	It("swaps in the refreshed configuration without changing the loaded one", func() {
+		loaded, current, err := refreshWatched()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.Debug).To(BeFalse())
+		Expect(current.Debug).To(BeTrue())
+	})
+})
+
+var _ = Describe("FileSource", func() {
+	It("flattens the document into dotted keys", func() {
+		values, err := parseFile(flagsDocument)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string]string{
+			"debug.enabled": "true",
+			"regions":       "eu-west-1,us-east-1",
+			"limit":         "10",
+		}))
+	})
+})
+{{- else }}
+
+func TestLoadPrecedence(t *testing.T) {
+	cfg, err := load(map[string]string{"REGION": "eu-west-1", "SECRET": ""})
+	want := testConfig{Secret: "from-ssm", Level: "warn", Region: "eu-west-1", Port: 8080}
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg != want {
+		t.Errorf("Load() = %+v, want %+v", cfg, want)
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, want, cfg)
+{{- end }}
+}
+
+func TestLoadRequiresEnvTag(t *testing.T) {
+	var cfg struct {
+		Secret string ` + "`" + `ssm:"secret"` + "`" + `
+	}
+	err := newTestLoader(nil).Load(context.Background(), &cfg)
+{{- if eq .TestingFramework "standard" }}
+	if err == nil {
+		t.Error("Load() error = nil, want an error for the missing env tag")
+	}
+{{- else }}
+	assert.ErrorContains(t, err, "no env tag")
+{{- end }}
+}
+
+func TestRefreshWaitsForInterval(t *testing.T) {
+	run := refreshAfter(30*time.Second, nil)
+{{- if eq .TestingFramework "standard" }}
+	if run.err != nil || run.fresh != nil {
+		t.Errorf("Refresh() = %+v, %v, want nil, nil", run.fresh, run.err)
+	}
+{{- else }}
+	require.NoError(t, run.err)
+	assert.Nil(t, run.fresh)
+{{- end }}
+}
+
+func TestRefreshAfterInterval(t *testing.T) {
+	run := refreshAfter(time.Minute, nil)
+{{- if eq .TestingFramework "standard" }}
+	if run.err != nil || run.fresh == nil {
+		t.Fatalf("Refresh() = %+v, %v, want a configuration", run.fresh, run.err)
+	}
+	if !run.fresh.Debug {
+		t.Error("Debug = false after the refresh")
+	}
+	if run.cfg.Debug {
+		t.Error("Refresh() changed the loaded configuration")
+	}
+{{- else }}
+	require.NoError(t, run.err)
+	require.NotNil(t, run.fresh)
+	assert.True(t, run.fresh.Debug)
+	assert.False(t, run.cfg.Debug, "Refresh() changed the loaded configuration")
+{{- end }}
+}
+
+func TestRefreshKeepsLastValuesOfFailingSource(t *testing.T) {
+	run := refreshAfter(time.Minute, errUnavailable)
+{{- if eq .TestingFramework "standard" }}
+	if run.err != nil || run.fresh == nil {
+		t.Fatalf("Refresh() = %+v, %v, want a configuration", run.fresh, run.err)
+	}
+	if run.fresh.Level != "warn" || run.fresh.Debug {
+		t.Errorf("Level, Debug = %q, %v, want the last AppConfig values warn, false", run.fresh.Level, run.fresh.Debug)
+	}
+{{- else }}
+	require.NoError(t, run.err)
+	require.NotNil(t, run.fresh)
+	assert.Equal(t, "warn", run.fresh.Level)
+	assert.False(t, run.fresh.Debug)
+{{- end }}
+}
+
+func TestWrapSwapsInRefreshedConfiguration(t *testing.T) {
+	loaded, current, err := refreshWatched()
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+	if loaded.Debug {
+		t.Error("the refresh changed the loaded configuration")
+	}
+	if !current.Debug {
+		t.Error("Debug = false in the current configuration after the refresh")
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.False(t, loaded.Debug, "the refresh changed the loaded configuration")
+	assert.True(t, current.Debug)
+{{- end }}
+}
+
+func TestFileSourceFlattensDocument(t *testing.T) {
+	values, err := parseFile(flagsDocument)
+	want := map[string]string{
+		"debug.enabled": "true",
+		"regions":       "eu-west-1,us-east-1",
+		"limit":         "10",
+	}
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if len(values) != len(want) {
+		t.Errorf("Values() = %v, want %v", values, want)
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("Values()[%q] = %q, want %q", key, values[key], value)
+		}
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, want, values)
+{{- end }}
+}
+{{- end }}
+`
@@ -0,0 +1,412 @@
+package templates
+
+// DynamoDB single-table design
+//
+// AccessPatterns is the default access-pattern file. It is written to the
+// project as-is, while DynamoDBTable and the table definitions in the
+// deployment templates are rendered from the model the generator loads
+// from it (.DataModel).
+
+const AccessPatterns = `# DynamoDB single-table design
+#
+# The key schema, the secondary indexes, the typed access-pattern methods in
+# table.go and the table definition in the deployment templates are all
+# generated from this file, so the code and the infrastructure cannot drift.
+# To change the design, edit a copy and pass it to create-lambda-app with
+# --access-patterns.
+#
+# Key values are templates: {placeholder} is replaced with the entity field
+# of the same name, e.g. {created_at} with the user's CreatedAt. Timestamps
+# are formatted so that they sort chronologically.
+#
+# An access pattern on the table whose sort key is fully determined by its
+# parameters becomes a GetItem, every other pattern becomes a Query. Set
+# unique on queries that return a single item, sort_key_prefix to narrow a
+# query with begins_with and descending to read the newest items first. The
+# description completes the sentence "<Name> ..." in the generated code.
+
+table:
+  name: users
+  partition_key: pk
+  sort_key: sk
+  indexes:
+    - name: gsi1
+      partition_key: gsi1pk
+      sort_key: gsi1sk
+    - name: gsi2
+      partition_key: gsi2pk
+      sort_key: gsi2sk
+
+entities:
+  - name: User
+    keys:
+      pk: "USER#{id}"
+      sk: "PROFILE"
+      gsi1pk: "EMAIL#{email}"
+      gsi1sk: "USER#{id}"
+      gsi2pk: "USER"
+      gsi2sk: "{created_at}#{id}"
+    access_patterns:
+      - name: GetUserByID
+        description: fetches a user by ID
+      - name: GetUserByEmail
+        description: looks a user up by email address
+        index: gsi1
+        unique: true
+      - name: ListUsers
+        description: lists users in creation order
+        index: gsi2
+`
+
+const DynamoDBTable = `// Code generated by create-lambda-app from access-patterns.yaml. DO NOT EDIT.
+
+package {{ if eq .Architecture "clean" }}database{{ else if eq .Architecture "simple" }}services{{ else }}persistence{{ end }}
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+{{- if .DataModel.AccessPatterns }}
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+{{- end }}
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+{{- with .DataModel }}
+
+// TablePartitionKey is the partition key attribute of the {{ .Table.Name }} table
+const TablePartitionKey = "{{ .Table.PartitionKey }}"
+{{- if .Table.SortKey }}
+
+// TableSortKey is the sort key attribute of the {{ .Table.Name }} table
+const TableSortKey = "{{ .Table.SortKey }}"
+{{- end }}
+{{- range .Table.Indexes }}
+
+// {{ .GoName }} is the name of the {{ .Name }} global secondary index
+const {{ .GoName }} = "{{ .Name }}"
+{{- end }}
+
+// ErrItemNotFound is returned when a get or unique access pattern finds
+// no item
+var ErrItemNotFound = errors.New("item not found")
+
+// ErrInvalidCursor is returned for a pagination cursor that is malformed,
+// carries a bad signature or was issued by another access pattern
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TableAPI is the subset of the DynamoDB client used by Table
+type TableAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// Table runs the access patterns of the single-table design
+type Table struct {
+	client TableAPI
+	name   string
+	secret []byte
+}
+
+// QueryOption customizes the query an access pattern runs, e.g. to add a
+// filter expression
+type QueryOption func(*dynamodb.QueryInput)
+
+// NewTable creates a table backed by client
+func NewTable(client TableAPI, name string) *Table {
+	return &Table{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name returns the table name
+func (t *Table) Name() string {
+	return t.name
+}
+
+// WithCursorSecret signs the pagination cursors the table issues with
+// HMAC-SHA256 and rejects cursors without a valid signature. An empty
+// secret leaves cursors unsigned.
+func (t *Table) WithCursorSecret(secret string) *Table {
+	t.secret = []byte(secret)
+	return t
+}
+
+// WithFilter adds a filter expression to a query. Filters run after the
+// key condition, so a limited query keeps reading until enough items match.
+func WithFilter(expression string, names map[string]string, values map[string]types.AttributeValue) QueryOption {
+	return func(input *dynamodb.QueryInput) {
+		input.FilterExpression = aws.String(expression)
+		for name, attribute := range names {
+			input.ExpressionAttributeNames[name] = attribute
+		}
+		for name, value := range values {
+			input.ExpressionAttributeValues[name] = value
+		}
+	}
+}
+
+// KeyTime formats a timestamp for use in a key. The fixed-width UTC layout
+// makes keys sort chronologically.
+func KeyTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+{{- range .Entities }}
+{{- $entity := . }}
+
+// {{ .Name }}Keys holds the values the {{ .Name }} key templates are built from
+type {{ .Name }}Keys struct {
+{{- range .Fields }}
+	{{ $entity.FieldName . }} string
+{{- end }}
+}
+
+// Key returns the {{ .Name }} primary key
+func (k {{ .Name }}Keys) Key() map[string]types.AttributeValue {
+	key := make(map[string]types.AttributeValue)
+{{- range .KeyAttributes }}
+{{- if .Primary }}
+	key[{{ if eq .Attribute $.DataModel.Table.PartitionKey }}TablePartitionKey{{ else }}TableSortKey{{ end }}] = keyValue({{ .Template.GoExpr "k" }})
+{{- end }}
+{{- end }}
+	return key
+}
+
+// Attributes returns the {{ .Name }} table and index key attributes. Merge
+// them into the item before writing it.
+func (k {{ .Name }}Keys) Attributes() map[string]types.AttributeValue {
+	attributes := k.Key()
+{{- range .KeyAttributes }}
+{{- if not .Primary }}
+	attributes["{{ .Attribute }}"] = keyValue({{ .Template.GoExpr "k" }})
+{{- end }}
+{{- end }}
+	return attributes
+}
+{{- end }}
+{{- range .AccessPatterns }}
+{{- if eq .Operation "get" }}
+
+// {{ .Name }} {{ if .Description }}{{ .Description }}{{ else }}runs the {{ .Name }} access pattern{{ end }}
+func (t *Table) {{ .Name }}(ctx context.Context{{ range .Params }}, {{ .Param }} string{{ end }}, out interface{}) error {
+	key := make(map[string]types.AttributeValue)
+	key[TablePartitionKey] = keyValue({{ .PK.GoExpr "" }})
+{{- if .SK }}
+	key[TableSortKey] = keyValue({{ .SK.GoExpr "" }})
+{{- end }}
+
+	result, err := t.client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(t.name), Key: key})
+	if err != nil {
+		return fmt.Errorf("{{ .Name }}: %w", err)
+	}
+	if result.Item == nil {
+		return ErrItemNotFound
+	}
+
+	return attributevalue.UnmarshalMap(result.Item, out)
+}
+{{- else if .Unique }}
+
+// {{ .Name }} {{ if .Description }}{{ .Description }}{{ else }}runs the {{ .Name }} access pattern{{ end }}
+func (t *Table) {{ .Name }}(ctx context.Context{{ range .Params }}, {{ .Param }} string{{ end }}, out interface{}) error {
+	items, _, err := t.query(ctx, t.{{ .InputFunc }}({{ range $i, $p := .Params }}{{ if $i }}, {{ end }}{{ $p.Param }}{{ end }}), 1)
+	if err != nil {
+		return fmt.Errorf("{{ .Name }}: %w", err)
+	}
+	if len(items) == 0 {
+		return ErrItemNotFound
+	}
+
+	return attributevalue.UnmarshalMap(items[0], out)
+}
+{{- else }}
+
+// {{ .Name }} {{ if .Description }}{{ .Description }}{{ else }}runs the {{ .Name }} access pattern{{ end }}.
+// It reads up to limit items after cursor and returns the cursor of the
+// next page, or "" after the last page. A limit of zero or less reads
+// every item.
+func (t *Table) {{ .Name }}(ctx context.Context{{ range .Params }}, {{ .Param }} string{{ end }}, limit int, cursor string, out interface{}, opts ...QueryOption) (string, error) {
+	input := t.{{ .InputFunc }}({{ range $i, $p := .Params }}{{ if $i }}, {{ end }}{{ $p.Param }}{{ end }}{{ if .Params }}, {{ end }}opts...)
+	startKey, err := t.decodeCursor("{{ .Name }}", cursor)
+	if err != nil {
+		return "", err
+	}
+	input.ExclusiveStartKey = startKey
+
+	items, lastKey, err := t.query(ctx, input, limit)
+	if err != nil {
+		return "", fmt.Errorf("{{ .Name }}: %w", err)
+	}
+	if err := attributevalue.UnmarshalListOfMaps(items, out); err != nil {
+		return "", err
+	}
+
+	return t.encodeCursor("{{ .Name }}", lastKey)
+}
+
+// {{ .Name }}Count counts the items {{ .Name }} reads
+func (t *Table) {{ .Name }}Count(ctx context.Context{{ range .Params }}, {{ .Param }} string{{ end }}, opts ...QueryOption) (int64, error) {
+	count, err := t.count(ctx, t.{{ .InputFunc }}({{ range $i, $p := .Params }}{{ if $i }}, {{ end }}{{ $p.Param }}{{ end }}{{ if .Params }}, {{ end }}opts...))
+	if err != nil {
+		return 0, fmt.Errorf("{{ .Name }}Count: %w", err)
+	}
+
+	return count, nil
+}
+{{- end }}
+{{- if eq .Operation "query" }}
+
+func (t *Table) {{ .InputFunc }}({{ range .Params }}{{ .Param }} string, {{ end }}opts ...QueryOption) *dynamodb.QueryInput {
+	input := &dynamodb.QueryInput{TableName: aws.String(t.name)}
+{{- if .IndexDef }}
+	input.IndexName = aws.String({{ .IndexDef.GoName }})
+{{- end }}
+{{- if .Prefix }}
+	input.KeyConditionExpression = aws.String("#pk = :pk AND begins_with(#sk, :sk)")
+	input.ExpressionAttributeNames = map[string]string{"#pk": "{{ .PartitionKey }}", "#sk": "{{ .SortKey }}"}
+	input.ExpressionAttributeValues = map[string]types.AttributeValue{":pk": keyValue({{ .PK.GoExpr "" }}), ":sk": keyValue({{ .Prefix.GoExpr "" }})}
+{{- else }}
+	input.KeyConditionExpression = aws.String("#pk = :pk")
+	input.ExpressionAttributeNames = map[string]string{"#pk": "{{ .PartitionKey }}"}
+	input.ExpressionAttributeValues = map[string]types.AttributeValue{":pk": keyValue({{ .PK.GoExpr "" }})}
+{{- end }}
+{{- if .Descending }}
+	input.ScanIndexForward = aws.Bool(false)
+{{- end }}
+
+	for _, opt := range opts {
+		opt(input)
+	}
+	return input
+}
+{{- end }}
+{{- end }}
+{{- end }}
+
+// query runs input page by page until limit items are read or the results
+// run out, and returns the key to resume from, if any. A limit of zero or
+// less reads every page.
+func (t *Table) query(ctx context.Context, input *dynamodb.QueryInput, limit int) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	for {
+		if limit > 0 {
+			input.Limit = aws.Int32(int32(limit - len(items)))
+		}
+
+		result, err := t.client.Query(ctx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, result.Items...)
+
+		if result.LastEvaluatedKey == nil || (limit > 0 && len(items) >= limit) {
+			return items, result.LastEvaluatedKey, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// count runs input page by page and adds up the matching items
+func (t *Table) count(ctx context.Context, input *dynamodb.QueryInput) (int64, error) {
+	input.Select = types.SelectCount
+
+	var count int64
+	for {
+		result, err := t.client.Query(ctx, input)
+		if err != nil {
+			return 0, err
+		}
+		count += int64(result.Count)
+
+		if result.LastEvaluatedKey == nil {
+			return count, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// pageCursor is the payload of a pagination cursor: the access pattern
+// that issued it and the LastEvaluatedKey to resume from
+type pageCursor struct {
+	Pattern string            ` + "`json:\"p\"`" + `
+	Key     map[string]string ` + "`json:\"k\"`" + `
+}
+
+// encodeCursor turns a LastEvaluatedKey into an opaque, URL-safe cursor,
+// signed when the table has a cursor secret. A nil key has no next page.
+func (t *Table) encodeCursor(pattern string, key map[string]types.AttributeValue) (string, error) {
+	if key == nil {
+		return "", nil
+	}
+
+	cursor := pageCursor{Pattern: pattern, Key: make(map[string]string, len(key))}
+	for name, value := range key {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("%s: key attribute %s is not a string", pattern, name)
+		}
+		cursor.Key[name] = s.Value
+	}
+
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to encode cursor: %w", pattern, err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(payload)
+	if len(t.secret) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(t.sign(payload))
+	}
+	return token, nil
+}
+
+// decodeCursor returns the ExclusiveStartKey encoded in token. An empty
+// token starts from the first page.
+func (t *Table) decodeCursor(pattern, token string) (map[string]types.AttributeValue, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	encoded, signature, signed := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if len(t.secret) > 0 {
+		mac, err := base64.RawURLEncoding.DecodeString(signature)
+		if !signed || err != nil || !hmac.Equal(mac, t.sign(payload)) {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil || cursor.Pattern != pattern || len(cursor.Key) == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]types.AttributeValue, len(cursor.Key))
+	for name, value := range cursor.Key {
+		key[name] = keyValue(value)
+	}
+	return key, nil
+}
+
+func (t *Table) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func keyValue(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+`
@@ -5,6 +5,7 @@ package templates
 const DDDAggregateBase = `package aggregate
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -90,18 +91,10 @@ func NewUser(email, name string) (*User, error) {
 	
 	user := &User{
 		AggregateRoot: NewAggregateRoot(""),
-		Email:         email,
-		Name:          name,
-		Status:        UserStatusActive,
-		EmailVerified: false,
 	}
 	
 	// Record domain event
-	user.RecordEvent(&event.UserCreated{
-		UserID: user.ID,
-		Email:  email,
-		Name:   name,
-	})
+	user.raise(event.NewUserCreated(user.ID, email, name))
 	
 	return user, nil
 }
@@ -112,15 +105,8 @@ func (u *User) UpdateProfile(name string) error {
 		return ErrInvalidName
 	}
 	
-	oldName := u.Name
-	u.Name = name
-	
 	// Record domain event
-	u.RecordEvent(&event.UserProfileUpdated{
-		UserID:  u.ID,
-		OldName: oldName,
-		NewName: name,
-	})
+	u.raise(event.NewUserProfileUpdated(u.ID, u.Name, name))
 	
 	return nil
 }
@@ -131,15 +117,8 @@ func (u *User) ChangeStatus(status UserStatus) error {
 		return nil // No change
 	}
 	
-	oldStatus := u.Status
-	u.Status = status
-	
 	// Record domain event
-	u.RecordEvent(&event.UserStatusChanged{
-		UserID:    u.ID,
-		OldStatus: string(oldStatus),
-		NewStatus: string(status),
-	})
+	u.raise(event.NewUserStatusChanged(u.ID, string(u.Status), string(status)))
 	
 	return nil
 }
@@ -150,19 +129,58 @@ func (u *User) Delete() error {
 		return ErrUserAlreadyDeleted
 	}
 	
-	now := time.Now().UTC()
-	u.DeletedAt = &now
-	u.Status = UserStatusDeleted
-	
 	// Record domain event
-	u.RecordEvent(&event.UserDeleted{
-		UserID:    u.ID,
-		DeletedAt: now,
-	})
+	u.raise(event.NewUserDeleted(u.ID, time.Now().UTC()))
 	
 	return nil
 }
 
+// LoadFromHistory rebuilds the user by replaying stored events without
+// recording them, advancing Version by one per event. Start from a zero
+// User, or from a snapshot to replay only the events recorded after it.
+func (u *User) LoadFromHistory(events []event.DomainEvent) error {
+	for _, evt := range events {
+		if !u.apply(evt) {
+			return fmt.Errorf("aggregate: user cannot apply %s event", evt.EventType())
+		}
+		u.Version++
+		u.UpdatedAt = evt.OccurredAt()
+	}
+	return nil
+}
+
+// raise applies a new event to the user and records it
+func (u *User) raise(evt event.DomainEvent) {
+	u.apply(evt)
+	u.RecordEvent(evt)
+}
+
+// apply changes the user's state for an event. It is the only place that
+// state changes, so replaying the history yields the same user. It
+// reports false for events the user does not handle.
+func (u *User) apply(evt event.DomainEvent) bool {
+	switch e := evt.(type) {
+	case *event.UserCreated:
+		u.ID = e.UserID
+		u.Email = e.Email
+		u.Name = e.Name
+		u.Status = UserStatusActive
+		u.EmailVerified = false
+		u.CreatedAt = e.OccurredAt()
+	case *event.UserProfileUpdated:
+		u.Name = e.NewName
+	case *event.UserStatusChanged:
+		u.Status = UserStatus(e.NewStatus)
+	case *event.UserDeleted:
+		deletedAt := e.DeletedAt
+		u.DeletedAt = &deletedAt
+		u.Status = UserStatusDeleted
+	default:
+		return false
+	}
+	return true
+}
+
 // IsActive checks if the user is active
 func (u *User) IsActive() bool {
 	return u.Status == UserStatusActive && u.DeletedAt == nil
@@ -285,6 +303,7 @@ const DDDValueObject = `package valueobject
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -464,13 +483,33 @@ const DDDRepository = `package repository
 
 import (
 	"context"
+	"errors"
 
 	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/entity"
+)
+
+var (
+	// ErrUserNotFound is returned when a user does not exist
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrUserAlreadyExists is returned when a user with the same email exists
+	ErrUserAlreadyExists = errors.New("user already exists")
+
+	// ErrInvalidCursor is returned when a pagination cursor is malformed,
+	// tampered with or was issued for another listing
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+	// ErrVersionConflict is returned by Save when the stored user changed
+	// since it was loaded; reload it and retry the command
+	ErrVersionConflict = errors.New("user was modified by another request, reload and retry")
 )
 
 // UserRepository defines the interface for user persistence
 type UserRepository interface {
-	// Save saves a user aggregate
+	// Save saves a user aggregate together with its uncommitted events.
+	// The events go to the outbox in the same write as the user, so they
+	// are published if and only if the change is stored.
 	Save(ctx context.Context, user *aggregate.User) error
 	
 	// FindByID finds a user by ID
@@ -479,8 +518,9 @@ type UserRepository interface {
 	// FindByEmail finds a user by email
 	FindByEmail(ctx context.Context, email string) (*aggregate.User, error)
 	
-	// List lists users with pagination
-	List(ctx context.Context, offset, limit int) ([]*aggregate.User, error)
+	// List lists up to limit users in creation order, starting after cursor.
+	// It returns the cursor of the next page, or "" on the last page.
+	List(ctx context.Context, cursor string, limit int) ([]*aggregate.User, string, error)
 	
 	// Count returns the total number of users
 	Count(ctx context.Context) (int64, error)
@@ -522,7 +562,7 @@ type UnitOfWork interface {
 // Specification defines a query specification
 type Specification interface {
 	// IsSatisfiedBy checks if the specification is satisfied
-	IsSatisfiedBy(aggregate interface{}) bool
+	IsSatisfiedBy(candidate interface{}) bool
 	
 	// And creates an AND specification
 	And(spec Specification) Specification
@@ -540,15 +580,15 @@ type BaseSpecification struct {
 }
 
 // IsSatisfiedBy checks if the specification is satisfied
-func (s *BaseSpecification) IsSatisfiedBy(aggregate interface{}) bool {
-	return s.predicate(aggregate)
+func (s *BaseSpecification) IsSatisfiedBy(candidate interface{}) bool {
+	return s.predicate(candidate)
 }
 
 // And creates an AND specification
 func (s *BaseSpecification) And(spec Specification) Specification {
 	return &BaseSpecification{
-		predicate: func(aggregate interface{}) bool {
-			return s.IsSatisfiedBy(aggregate) && spec.IsSatisfiedBy(aggregate)
+		predicate: func(candidate interface{}) bool {
+			return s.IsSatisfiedBy(candidate) && spec.IsSatisfiedBy(candidate)
 		},
 	}
 }
@@ -556,8 +596,8 @@ func (s *BaseSpecification) And(spec Specification) Specification {
 // Or creates an OR specification
 func (s *BaseSpecification) Or(spec Specification) Specification {
 	return &BaseSpecification{
-		predicate: func(aggregate interface{}) bool {
-			return s.IsSatisfiedBy(aggregate) || spec.IsSatisfiedBy(aggregate)
+		predicate: func(candidate interface{}) bool {
+			return s.IsSatisfiedBy(candidate) || spec.IsSatisfiedBy(candidate)
 		},
 	}
 }
@@ -565,8 +605,8 @@ func (s *BaseSpecification) Or(spec Specification) Specification {
 // Not creates a NOT specification
 func (s *BaseSpecification) Not() Specification {
 	return &BaseSpecification{
-		predicate: func(aggregate interface{}) bool {
-			return !s.IsSatisfiedBy(aggregate)
+		predicate: func(candidate interface{}) bool {
+			return !s.IsSatisfiedBy(candidate)
 		},
 	}
 }
@@ -574,8 +614,8 @@ func (s *BaseSpecification) Not() Specification {
 // ActiveUserSpecification is a specification for active users
 func ActiveUserSpecification() Specification {
 	return &BaseSpecification{
-		predicate: func(aggregate interface{}) bool {
-			if user, ok := aggregate.(*aggregate.User); ok {
+		predicate: func(candidate interface{}) bool {
+			if user, ok := candidate.(*aggregate.User); ok {
 				return user.IsActive()
 			}
 			return false
@@ -587,6 +627,8 @@ func ActiveUserSpecification() Specification {
 const DDDEvent = `package event
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -638,6 +680,14 @@ func (e BaseDomainEvent) AggregateID() string {
 	return e.AggregateId
 }
 
+// User domain event types
+const (
+	EventTypeUserCreated        = "user.created"
+	EventTypeUserProfileUpdated = "user.profile_updated"
+	EventTypeUserStatusChanged  = "user.status_changed"
+	EventTypeUserDeleted        = "user.deleted"
+)
+
 // User domain events
 
 // UserCreated event
@@ -651,7 +701,7 @@ type UserCreated struct {
 // NewUserCreated creates a new UserCreated event
 func NewUserCreated(userID, email, name string) *UserCreated {
 	return &UserCreated{
-		BaseDomainEvent: NewBaseDomainEvent("user.created", userID),
+		BaseDomainEvent: NewBaseDomainEvent(EventTypeUserCreated, userID),
 		UserID:          userID,
 		Email:           email,
 		Name:            name,
@@ -666,6 +716,16 @@ type UserProfileUpdated struct {
 	NewName string ` + "`json:\"new_name\"`" + `
 }
 
+// NewUserProfileUpdated creates a new UserProfileUpdated event
+func NewUserProfileUpdated(userID, oldName, newName string) *UserProfileUpdated {
+	return &UserProfileUpdated{
+		BaseDomainEvent: NewBaseDomainEvent(EventTypeUserProfileUpdated, userID),
+		UserID:          userID,
+		OldName:         oldName,
+		NewName:         newName,
+	}
+}
+
 // UserStatusChanged event
 type UserStatusChanged struct {
 	BaseDomainEvent
@@ -674,6 +734,16 @@ type UserStatusChanged struct {
 	NewStatus string ` + "`json:\"new_status\"`" + `
 }
 
+// NewUserStatusChanged creates a new UserStatusChanged event
+func NewUserStatusChanged(userID, oldStatus, newStatus string) *UserStatusChanged {
+	return &UserStatusChanged{
+		BaseDomainEvent: NewBaseDomainEvent(EventTypeUserStatusChanged, userID),
+		UserID:          userID,
+		OldStatus:       oldStatus,
+		NewStatus:       newStatus,
+	}
+}
+
 // UserDeleted event
 type UserDeleted struct {
 	BaseDomainEvent
@@ -681,18 +751,55 @@ type UserDeleted struct {
 	DeletedAt time.Time ` + "`json:\"deleted_at\"`" + `
 }
 
-// EventStore interface for persisting events
+// NewUserDeleted creates a new UserDeleted event
+func NewUserDeleted(userID string, deletedAt time.Time) *UserDeleted {
+	return &UserDeleted{
+		BaseDomainEvent: NewBaseDomainEvent(EventTypeUserDeleted, userID),
+		UserID:          userID,
+		DeletedAt:       deletedAt,
+	}
+}
+
+// ErrConcurrencyConflict is returned when an append finds that the stream
+// is no longer at the expected version: another writer appended first.
+// Reload the aggregate and retry the command.
+var ErrConcurrencyConflict = errors.New("event stream was modified concurrently")
+
+// EventStore interface for persisting events. Every aggregate has its own
+// stream; the Nth event of a stream has version N.
 type EventStore interface {
-	// Save saves events
-	Save(ctx context.Context, events []DomainEvent) error
+	// Save appends events to the aggregate's stream. The append is atomic
+	// and only lands while the stream is at expectedVersion (0 for a new
+	// stream); otherwise it returns ErrConcurrencyConflict.
+	Save(ctx context.Context, aggregateID string, expectedVersion int, events []DomainEvent) error
 	
-	// GetEvents gets events for an aggregate
-	GetEvents(ctx context.Context, aggregateID string) ([]DomainEvent, error)
+	// GetEvents gets the events of an aggregate with a version above
+	// afterVersion, in order. Pass 0 for the whole stream.
+	GetEvents(ctx context.Context, aggregateID string, afterVersion int) ([]DomainEvent, error)
 	
-	// GetEventsSince gets events since a specific time
+	// GetEventsSince gets the events of all aggregates that occurred at or
+	// after since, ordered by time
 	GetEventsSince(ctx context.Context, since time.Time) ([]DomainEvent, error)
 }
 
+// Snapshot is the serialized state of an aggregate at a stream version.
+// Loading from a snapshot only replays the events recorded after it.
+type Snapshot struct {
+	AggregateID string
+	Version     int
+	State       []byte
+	TakenAt     time.Time
+}
+
+// SnapshotStore keeps the latest snapshot of each aggregate
+type SnapshotStore interface {
+	// SaveSnapshot stores a snapshot unless a newer one exists
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+	
+	// GetSnapshot returns the latest snapshot of an aggregate, or nil
+	GetSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error)
+}
+
 // EventPublisher interface for publishing events
 type EventPublisher interface {
 	// Publish publishes domain events
@@ -718,13 +825,72 @@ type EventBus interface {
 }
 `
 
+const DDDEventRegistry = `package event
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Registry maps event types to constructors so that stored events can be
+// decoded back into their concrete types
+type Registry struct {
+	factories map[string]func() DomainEvent
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]func() DomainEvent)}
+}
+
+// NewUserEventRegistry creates a registry of the user domain events
+func NewUserEventRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(EventTypeUserCreated, func() DomainEvent { return &UserCreated{} })
+	r.Register(EventTypeUserProfileUpdated, func() DomainEvent { return &UserProfileUpdated{} })
+	r.Register(EventTypeUserStatusChanged, func() DomainEvent { return &UserStatusChanged{} })
+	r.Register(EventTypeUserDeleted, func() DomainEvent { return &UserDeleted{} })
+	return r
+}
+
+// Register registers the constructor of an event type. factory must
+// return a pointer for the decoded data to be stored in it.
+func (r *Registry) Register(eventType string, factory func() DomainEvent) {
+	r.factories[eventType] = factory
+}
+
+// Encode serializes an event for storage
+func (r *Registry) Encode(evt DomainEvent) ([]byte, error) {
+	if _, ok := r.factories[evt.EventType()]; !ok {
+		return nil, fmt.Errorf("event: unregistered event type %q", evt.EventType())
+	}
+	return json.Marshal(evt)
+}
+
+// Decode deserializes a stored event of the given type
+func (r *Registry) Decode(eventType string, data []byte) (DomainEvent, error) {
+	factory, ok := r.factories[eventType]
+	if !ok {
+		return nil, fmt.Errorf("event: unregistered event type %q", eventType)
+	}
+
+	evt := factory()
+	if err := json.Unmarshal(data, evt); err != nil {
+		return nil, fmt.Errorf("event: failed to decode %s: %w", eventType, err)
+	}
+	return evt, nil
+}
+`
+
 const DDDCommand = `package command
 
 import (
 	"context"
-	"time"
+	"errors"
 
 	"github.com/google/uuid"
+	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/repository"
 )
 
 // Command is the base interface for all commands
@@ -811,17 +977,17 @@ type DeleteUserCommand struct {
 
 // Command handlers
 
-// CreateUserHandler handles user creation
+// CreateUserHandler handles user creation. It does not publish the
+// user's events itself: the repository writes them to the outbox along
+// with the user, and the outbox relay publishes them.
 type CreateUserHandler struct {
 	userRepo repository.UserRepository
-	eventBus event.EventBus
 }
 
 // NewCreateUserHandler creates a new CreateUserHandler
-func NewCreateUserHandler(userRepo repository.UserRepository, eventBus event.EventBus) *CreateUserHandler {
+func NewCreateUserHandler(userRepo repository.UserRepository) *CreateUserHandler {
 	return &CreateUserHandler{
 		userRepo: userRepo,
-		eventBus: eventBus,
 	}
 }
 
@@ -844,16 +1010,11 @@ func (h *CreateUserHandler) Handle(ctx context.Context, cmd Command) error {
 		return err
 	}
 	
-	// Save user
+	// Save user and its events
 	if err := h.userRepo.Save(ctx, user); err != nil {
 		return err
 	}
 	
-	// Publish events
-	if err := h.eventBus.Publish(ctx, user.GetUncommittedEvents()); err != nil {
-		return err
-	}
-	
 	// Mark events as committed
 	user.MarkEventsAsCommitted()
 	
@@ -865,6 +1026,10 @@ const DDDQuery = `package query
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"{{.Module}}/domain/repository"
 )
 
 // Query is the base interface for all queries
@@ -908,11 +1073,11 @@ func (q *GetUserByEmailQuery) QueryType() string {
 	return "user.get_by_email"
 }
 
-// ListUsersQuery lists users with pagination
+// ListUsersQuery lists users with cursor pagination
 type ListUsersQuery struct {
-	Page     int    ` + "`json:\"page\"`" + `
-	PageSize int    ` + "`json:\"page_size\"`" + `
-	Status   string ` + "`json:\"status,omitempty\"`" + `
+	Cursor string ` + "`json:\"cursor,omitempty\"`" + `
+	Limit  int    ` + "`json:\"limit\"`" + `
+	Status string ` + "`json:\"status,omitempty\"`" + `
 }
 
 // QueryType returns the query type
@@ -933,12 +1098,12 @@ type UserDTO struct {
 	UpdatedAt     time.Time ` + "`json:\"updated_at\"`" + `
 }
 
-// ListUsersResult is the result of listing users
+// ListUsersResult is one page of users. NextCursor is empty on the last
+// page.
 type ListUsersResult struct {
 	Users      []*UserDTO ` + "`json:\"users\"`" + `
-	TotalCount int64      ` + "`json:\"total_count\"`" + `
-	Page       int        ` + "`json:\"page\"`" + `
-	PageSize   int        ` + "`json:\"page_size\"`" + `
+	NextCursor string     ` + "`json:\"next_cursor,omitempty\"`" + `
+	Limit      int        ` + "`json:\"limit\"`" + `
 }
 
 // Query handlers
@@ -983,6 +1148,13 @@ type ListUsersHandler struct {
 	userRepo repository.UserRepository
 }
 
+// NewListUsersHandler creates a new ListUsersHandler
+func NewListUsersHandler(userRepo repository.UserRepository) *ListUsersHandler {
+	return &ListUsersHandler{
+		userRepo: userRepo,
+	}
+}
+
 // Handle handles the query
 func (h *ListUsersHandler) Handle(ctx context.Context, q Query) (interface{}, error) {
 	query, ok := q.(*ListUsersQuery)
@@ -990,17 +1162,8 @@ func (h *ListUsersHandler) Handle(ctx context.Context, q Query) (interface{}, er
 		return nil, errors.New("invalid query type")
 	}
 	
-	// Calculate offset
-	offset := (query.Page - 1) * query.PageSize
-	
 	// Get users
-	users, err := h.userRepo.List(ctx, offset, query.PageSize)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Get total count
-	totalCount, err := h.userRepo.Count(ctx)
+	users, next, err := h.userRepo.List(ctx, query.Cursor, query.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -1021,9 +1184,8 @@ func (h *ListUsersHandler) Handle(ctx context.Context, q Query) (interface{}, er
 	
 	return &ListUsersResult{
 		Users:      userDTOs,
-		TotalCount: totalCount,
-		Page:       query.Page,
-		PageSize:   query.PageSize,
+		NextCursor: next,
+		Limit:      query.Limit,
 	}, nil
 }
 `
@@ -1032,37 +1194,55 @@ const DDDPersistence = `package persistence
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/event"
 	"{{.Module}}/domain/repository"
+	"{{.Module}}/infrastructure/outbox"
 )
 
-// DynamoDBUserRepository implements UserRepository using DynamoDB
+// maxOutboxEvents is the most events one Save can write: a DynamoDB
+// transaction holds 100 items, one of which is the user
+const maxOutboxEvents = 99
+
+// DynamoDBUserRepository implements UserRepository on the single-table
+// design generated from access-patterns.yaml
 type DynamoDBUserRepository struct {
-	client    *dynamodb.Client
-	tableName string
+	client *dynamodb.Client
+	table  *Table
 }
 
 // NewDynamoDBUserRepository creates a new DynamoDB user repository
 func NewDynamoDBUserRepository(client *dynamodb.Client, tableName string) *DynamoDBUserRepository {
 	return &DynamoDBUserRepository{
-		client:    client,
-		tableName: tableName,
+		client: client,
+		table:  NewTable(client, tableName),
 	}
 }
 
-// Save saves a user aggregate
+// WithCursorSecret signs the pagination cursors returned by List
+func (r *DynamoDBUserRepository) WithCursorSecret(secret string) *DynamoDBUserRepository {
+	r.table.WithCursorSecret(secret)
+	return r
+}
+
+// Save saves a user aggregate and writes its uncommitted events to the
+// outbox in one transaction
 func (r *DynamoDBUserRepository) Save(ctx context.Context, user *aggregate.User) error {
+	pending := user.GetUncommittedEvents()
+	if len(pending) > maxOutboxEvents {
+		return fmt.Errorf("cannot save %d events at once, the limit is %d", len(pending), maxOutboxEvents)
+	}
+
 	// Convert to DynamoDB item
 	item := map[string]interface{}{
-		"pk":             "USER#" + user.ID,
-		"sk":             "USER#" + user.ID,
 		"type":           "User",
 		"id":             user.ID,
 		"email":          user.Email,
@@ -1085,147 +1265,145 @@ func (r *DynamoDBUserRepository) Save(ctx context.Context, user *aggregate.User)
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
 	
-	// Save to DynamoDB with optimistic locking
-	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      av,
-		ConditionExpression: aws.String("attribute_not_exists(pk) OR version = :old_version"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":old_version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", user.Version-1)},
+	// Add the table and index keys
+	keys := UserKeys{
+{{- with .DataModel.Entity "User" }}
+{{- $entity := . }}
+{{- range .Fields }}
+		{{ $entity.FieldKey . }} {{ .UserExpr "user" }},
+{{- end }}
+{{- end }}
+	}
+	for name, value := range keys.Attributes() {
+		av[name] = value
+	}
+	
+	// Save the user with optimistic locking: the stored version must be the
+	// one the user had before its pending events
+	items := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName:           aws.String(r.table.Name()),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(" + TablePartitionKey + ") OR version = :old_version"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":old_version": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", user.Version-len(pending))},
+				},
+			},
 		},
+	}
+	
+	// Write the events to the outbox in the same transaction
+	for _, evt := range pending {
+		item, err := outboxItem(evt)
+		if err != nil {
+			return err
+		}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(r.table.Name()),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(" + TablePartitionKey + ")"),
+			},
+		})
+	}
+	
+	_, err = r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
 	})
 	if err != nil {
+		if userConditionFailed(err) {
+			return repository.ErrVersionConflict
+		}
 		return fmt.Errorf("failed to save user: %w", err)
 	}
 	
 	return nil
 }
 
-// FindByID finds a user by ID
-func (r *DynamoDBUserRepository) FindByID(ctx context.Context, id string) (*aggregate.User, error) {
-	result, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(r.tableName),
-		Key: map[string]types.AttributeValue{
-			"pk": &types.AttributeValueMemberS{Value: "USER#" + id},
-			"sk": &types.AttributeValueMemberS{Value: "USER#" + id},
-		},
-	})
+// userConditionFailed reports whether a Save transaction was canceled
+// because the version condition on the user, its first item, failed
+func userConditionFailed(err error) bool {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) || len(canceled.CancellationReasons) == 0 {
+		return false
+	}
+	return aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed"
+}
+
+// outboxItem builds the outbox item of a domain event. It is keyed on the
+// event ID and has none of the index attributes, so it only shows up on
+// the table's stream, and expires after outbox.Retention.
+func outboxItem(evt event.DomainEvent) (map[string]types.AttributeValue, error) {
+	msg, err := outbox.NewMessage(evt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, err
+	}
+	
+	item, err := attributevalue.MarshalMap(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbox message: %w", err)
 	}
 	
-	if result.Item == nil {
-		return nil, repository.ErrUserNotFound
+	item["type"] = &types.AttributeValueMemberS{Value: outbox.ItemType}
+	item["expires_at"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Add(outbox.Retention).Unix())}
+	item[TablePartitionKey] = keyValue(outbox.KeyPrefix + msg.EventID)
+{{- if .DataModel.Table.SortKey }}
+	item[TableSortKey] = keyValue(outbox.KeyPrefix + msg.EventID)
+{{- end }}
+	return item, nil
+}
+
+// FindByID finds a user by ID
+func (r *DynamoDBUserRepository) FindByID(ctx context.Context, id string) (*aggregate.User, error) {
+	var item userItem
+	if err := r.table.GetUserByID(ctx, id, &item); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, repository.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 	
-	return r.unmarshalUser(result.Item)
+	return item.toAggregate(), nil
 }
 
 // FindByEmail finds a user by email
 func (r *DynamoDBUserRepository) FindByEmail(ctx context.Context, email string) (*aggregate.User, error) {
-	result, err := r.client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(r.tableName),
-		IndexName:              aws.String("email-index"),
-		KeyConditionExpression: aws.String("email = :email"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":email": &types.AttributeValueMemberS{Value: email},
-		},
-		Limit: aws.Int32(1),
-	})
-	if err != nil {
+	var item userItem
+	if err := r.table.GetUserByEmail(ctx, email, &item); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, repository.ErrUserNotFound
+		}
 		return nil, fmt.Errorf("failed to query user by email: %w", err)
 	}
 	
-	if len(result.Items) == 0 {
-		return nil, repository.ErrUserNotFound
-	}
-	
-	return r.unmarshalUser(result.Items[0])
+	return item.toAggregate(), nil
 }
 
-// List lists users with pagination
-func (r *DynamoDBUserRepository) List(ctx context.Context, offset, limit int) ([]*aggregate.User, error) {
-	var users []*aggregate.User
-	var lastEvaluatedKey map[string]types.AttributeValue
-	
-	// DynamoDB doesn't support offset directly, so we need to scan
-	scanned := 0
-	for {
-		input := &dynamodb.ScanInput{
-			TableName:        aws.String(r.tableName),
-			FilterExpression: aws.String("#type = :type"),
-			ExpressionAttributeNames: map[string]string{
-				"#type": "type",
-			},
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":type": &types.AttributeValueMemberS{Value: "User"},
-			},
-			Limit: aws.Int32(int32(limit + offset - scanned)),
-		}
-		
-		if lastEvaluatedKey != nil {
-			input.ExclusiveStartKey = lastEvaluatedKey
-		}
-		
-		result, err := r.client.Scan(ctx, input)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan users: %w", err)
-		}
-		
-		for _, item := range result.Items {
-			if scanned >= offset && len(users) < limit {
-				user, err := r.unmarshalUser(item)
-				if err == nil {
-					users = append(users, user)
-				}
-			}
-			scanned++
-		}
-		
-		if result.LastEvaluatedKey == nil || len(users) >= limit {
-			break
+// List lists a page of users in creation order
+func (r *DynamoDBUserRepository) List(ctx context.Context, cursor string, limit int) ([]*aggregate.User, string, error) {
+	var items []userItem
+	next, err := r.table.ListUsers(ctx, limit, cursor, &items)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, "", repository.ErrInvalidCursor
 		}
-		
-		lastEvaluatedKey = result.LastEvaluatedKey
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
+	}
+	
+	users := make([]*aggregate.User, 0, len(items))
+	for i := range items {
+		users = append(users, items[i].toAggregate())
 	}
 	
-	return users, nil
+	return users, next, nil
 }
 
 // Count returns the total number of users
 func (r *DynamoDBUserRepository) Count(ctx context.Context) (int64, error) {
-	var count int64
-	var lastEvaluatedKey map[string]types.AttributeValue
-	
-	for {
-		input := &dynamodb.ScanInput{
-			TableName:        aws.String(r.tableName),
-			FilterExpression: aws.String("#type = :type"),
-			ExpressionAttributeNames: map[string]string{
-				"#type": "type",
-			},
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":type": &types.AttributeValueMemberS{Value: "User"},
-			},
-			Select: types.SelectCount,
-		}
-		
-		if lastEvaluatedKey != nil {
-			input.ExclusiveStartKey = lastEvaluatedKey
-		}
-		
-		result, err := r.client.Scan(ctx, input)
-		if err != nil {
-			return 0, fmt.Errorf("failed to count users: %w", err)
-		}
-		
-		count += int64(result.Count)
-		
-		if result.LastEvaluatedKey == nil {
-			break
-		}
-		
-		lastEvaluatedKey = result.LastEvaluatedKey
+	count, err := r.table.ListUsersCount(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
 	}
 	
 	return count, nil
@@ -1234,11 +1412,8 @@ func (r *DynamoDBUserRepository) Count(ctx context.Context) (int64, error) {
 // Delete deletes a user
 func (r *DynamoDBUserRepository) Delete(ctx context.Context, id string) error {
 	_, err := r.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(r.tableName),
-		Key: map[string]types.AttributeValue{
-			"pk": &types.AttributeValueMemberS{Value: "USER#" + id},
-			"sk": &types.AttributeValueMemberS{Value: "USER#" + id},
-		},
+		TableName: aws.String(r.table.Name()),
+		Key:       UserKeys{ID: id}.Key(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
@@ -1247,42 +1422,36 @@ func (r *DynamoDBUserRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-// unmarshalUser unmarshals a DynamoDB item to a user aggregate
-func (r *DynamoDBUserRepository) unmarshalUser(item map[string]types.AttributeValue) (*aggregate.User, error) {
-	var data struct {
-		ID            string     ` + "`dynamodbav:\"id\"`" + `
-		Email         string     ` + "`dynamodbav:\"email\"`" + `
-		Name          string     ` + "`dynamodbav:\"name\"`" + `
-		Status        string     ` + "`dynamodbav:\"status\"`" + `
-		EmailVerified bool       ` + "`dynamodbav:\"email_verified\"`" + `
-		PasswordHash  string     ` + "`dynamodbav:\"password_hash\"`" + `
-		CreatedAt     time.Time  ` + "`dynamodbav:\"created_at\"`" + `
-		UpdatedAt     time.Time  ` + "`dynamodbav:\"updated_at\"`" + `
-		DeletedAt     *time.Time ` + "`dynamodbav:\"deleted_at\"`" + `
-		Version       int        ` + "`dynamodbav:\"version\"`" + `
-	}
-	
-	if err := attributevalue.UnmarshalMap(item, &data); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
-	}
-	
-	// Reconstruct user aggregate
-	user := &aggregate.User{
+// userItem is the stored form of a user aggregate
+type userItem struct {
+	ID            string     ` + "`dynamodbav:\"id\"`" + `
+	Email         string     ` + "`dynamodbav:\"email\"`" + `
+	Name          string     ` + "`dynamodbav:\"name\"`" + `
+	Status        string     ` + "`dynamodbav:\"status\"`" + `
+	EmailVerified bool       ` + "`dynamodbav:\"email_verified\"`" + `
+	PasswordHash  string     ` + "`dynamodbav:\"password_hash\"`" + `
+	CreatedAt     time.Time  ` + "`dynamodbav:\"created_at\"`" + `
+	UpdatedAt     time.Time  ` + "`dynamodbav:\"updated_at\"`" + `
+	DeletedAt     *time.Time ` + "`dynamodbav:\"deleted_at\"`" + `
+	Version       int        ` + "`dynamodbav:\"version\"`" + `
+}
+
+// toAggregate reconstructs the user aggregate
+func (i userItem) toAggregate() *aggregate.User {
+	return &aggregate.User{
 		AggregateRoot: aggregate.AggregateRoot{
-			ID:        data.ID,
-			Version:   data.Version,
-			CreatedAt: data.CreatedAt,
-			UpdatedAt: data.UpdatedAt,
+			ID:        i.ID,
+			Version:   i.Version,
+			CreatedAt: i.CreatedAt,
+			UpdatedAt: i.UpdatedAt,
 		},
-		Email:         data.Email,
-		Name:          data.Name,
-		Status:        aggregate.UserStatus(data.Status),
-		EmailVerified: data.EmailVerified,
-		PasswordHash:  data.PasswordHash,
-		DeletedAt:     data.DeletedAt,
+		Email:         i.Email,
+		Name:          i.Name,
+		Status:        aggregate.UserStatus(i.Status),
+		EmailVerified: i.EmailVerified,
+		PasswordHash:  i.PasswordHash,
+		DeletedAt:     i.DeletedAt,
 	}
-	
-	return user, nil
 }
 `
 
@@ -1290,9 +1459,17 @@ func (r *DynamoDBUserRepository) unmarshalUser(item map[string]types.AttributeVa
 const DDDAPIRouter = `package api
 
 import (
+	"errors"
+	"net/http"
+	"strconv"
+
 	"github.com/gin-gonic/gin"
 	"{{.Module}}/application/command"
 	"{{.Module}}/application/query"
+	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/repository"
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/routing"
 )
 
 // Router holds the API dependencies
@@ -1309,8 +1486,10 @@ func NewRouter(commandBus command.CommandBus, queryBus query.QueryBus) *Router {
 	}
 }
 
-// SetupRoutes sets up the API routes
-func (r *Router) SetupRoutes(engine *gin.Engine) {
+// Handler returns the API routes served through gin
+func (r *Router) Handler() http.Handler {
+	engine := routing.New()
+	
 	// Health check
 	engine.GET("/health", r.healthCheck)
 	
@@ -1323,6 +1502,8 @@ func (r *Router) SetupRoutes(engine *gin.Engine) {
 		users.PUT("/:id", r.updateUser)
 		users.DELETE("/:id", r.deleteUser)
 	}
+	
+	return engine
 }
 
 func (r *Router) healthCheck(c *gin.Context) {
@@ -1339,7 +1520,7 @@ func (r *Router) createUser(c *gin.Context) {
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
 		return
 	}
 	
@@ -1372,22 +1553,20 @@ func (r *Router) getUser(c *gin.Context) {
 }
 
 func (r *Router) listUsers(c *gin.Context) {
-	page := 1
-	pageSize := 20
+	limit := 20
 	
 	// Parse query parameters
-	if p := c.Query("page"); p != "" {
-		// Parse page
-	}
-	if ps := c.Query("page_size"); ps != "" {
-		// Parse page size
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
 	}
 	
 	// Create query
 	q := &query.ListUsersQuery{
-		Page:     page,
-		PageSize: pageSize,
-		Status:   c.Query("status"),
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+		Status: c.Query("status"),
 	}
 	
 	// Dispatch query
@@ -1409,7 +1588,7 @@ func (r *Router) updateUser(c *gin.Context) {
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
 		return
 	}
 	
@@ -1462,98 +1641,560 @@ func (r *Router) deleteUser(c *gin.Context) {
 	c.Status(204)
 }
 
+// handleError answers a domain error with the problem of its code
 func handleError(c *gin.Context, err error) {
 	// Handle domain errors
 	if domainErr, ok := err.(*aggregate.DomainError); ok {
 		switch domainErr.Code {
 		case "USER_NOT_FOUND":
-			c.JSON(404, gin.H{"error": domainErr.Message})
+			writeProblem(c, problem.New(problem.TypeNotFound, domainErr.Message))
 		case "USER_EXISTS":
-			c.JSON(409, gin.H{"error": domainErr.Message})
+			writeProblem(c, problem.New(problem.TypeConflict, domainErr.Message))
 		default:
-			c.JSON(400, gin.H{"error": domainErr.Message})
+			writeProblem(c, problem.New(problem.TypeValidation, domainErr.Message))
 		}
 		return
 	}
 	
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		writeProblem(c, problem.New(problem.TypeConflict, err.Error()))
+		return
+	}
+	
 	// Default error
-	c.JSON(500, gin.H{"error": "Internal server error"})
+	writeProblem(c, problem.FromError(err))
+}
+
+// writeProblem answers the request with p and stops the handler chain
+func writeProblem(c *gin.Context, p *problem.Problem) {
+	problem.Write(c.Writer, c.Request, p)
+	c.Abort()
 }
 `
 
-const DDDAPIHandlers = `package api
+const DDDAPIRouterHTTP = `package api
 
 import (
-	"context"
+	"errors"
+	"net/http"
+	"strconv"
 
-	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/awslabs/aws-lambda-go-api-proxy/gin"
-	"github.com/gin-gonic/gin"
 	"{{.Module}}/application/command"
 	"{{.Module}}/application/query"
-	"{{.Module}}/infrastructure/config"
-	"github.com/rs/zerolog/log"
+	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/repository"
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/routing"
 )
 
-var ginLambda *ginadapter.GinLambda
+// Router holds the API dependencies
+type Router struct {
+	commandBus command.CommandBus
+	queryBus   query.QueryBus
+}
 
-// Handler handles API Gateway requests
-type Handler struct {
-	router *Router
+// NewRouter creates a new API router
+func NewRouter(commandBus command.CommandBus, queryBus query.QueryBus) *Router {
+	return &Router{
+		commandBus: commandBus,
+		queryBus:   queryBus,
+	}
 }
 
-// NewHandler creates a new API handler
-func NewHandler(commandBus command.CommandBus, queryBus query.QueryBus) *Handler {
-	// Create Gin engine
-	engine := gin.New()
-	engine.Use(gin.Recovery())
+// Handler returns the API routes served through {{.Router}}
+func (r *Router) Handler() http.Handler {
+	mux := routing.New()
+{{- if eq .Router "chi" }}
 	
-	// Create router and setup routes
-	router := NewRouter(commandBus, queryBus)
-	router.SetupRoutes(engine)
+	// Health check
+	mux.Get("/health", r.healthCheck)
 	
-	// Create Gin Lambda adapter
-	ginLambda = ginadapter.New(engine)
+	// User routes
+	mux.Post("/users", r.createUser)
+	mux.Get("/users/{id}", r.getUser)
+	mux.Get("/users", r.listUsers)
+	mux.Put("/users/{id}", r.updateUser)
+	mux.Delete("/users/{id}", r.deleteUser)
+{{- else }}
 	
-	return &Handler{
-		router: router,
-	}
+	// Health check
+	mux.HandleFunc("GET /health", r.healthCheck)
+	
+	// User routes
+	mux.HandleFunc("POST /users", r.createUser)
+	mux.HandleFunc("GET /users/{id}", r.getUser)
+	mux.HandleFunc("GET /users", r.listUsers)
+	mux.HandleFunc("PUT /users/{id}", r.updateUser)
+	mux.HandleFunc("DELETE /users/{id}", r.deleteUser)
+{{- end }}
+	
+	return mux
 }
 
-// HandleRequest handles the Lambda request
-func (h *Handler) HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Add request ID to logs
-	log.Ctx(ctx).Info().
-		Str("method", request.HTTPMethod).
-		Str("path", request.Path).
-		Str("request_id", request.RequestContext.RequestID).
-		Msg("Processing API request")
-	
-	// Process request
-	return ginLambda.ProxyWithContext(ctx, request)
+func (r *Router) healthCheck(w http.ResponseWriter, req *http.Request) {
+	routing.JSON(w, req, http.StatusOK, map[string]string{
+		"status":  "healthy",
+		"service": "{{.Name}}",
+	})
 }
 
-// Start starts the Lambda handler
-func Start() {
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load configuration")
+func (r *Router) createUser(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Email string ` + "`json:\"email\"`" + `
+		Name  string ` + "`json:\"name\"`" + `
 	}
 	
-	// Initialize infrastructure
-	infra, err := infrastructure.New(cfg)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize infrastructure")
+	if err := routing.Decode(req, &body); err != nil {
+		problem.Write(w, req, problem.New(problem.TypeValidation, err.Error()))
+		return
+	}
+	if body.Email == "" || body.Name == "" {
+		problem.Write(w, req, problem.New(problem.TypeValidation, "email and name are required"))
+		return
 	}
 	
-	// Create command bus
-	commandBus := infrastructure.NewCommandBus()
-	
-	// Register command handlers
-	commandBus.Register("user.create", command.NewCreateUserHandler(infra.UserRepository(), infra.EventBus()))
-	// Register other command handlers...
+	// Create command
+	cmd := command.NewCreateUserCommand(body.Email, body.Name)
+	
+	// Dispatch command
+	if err := r.commandBus.Dispatch(req.Context(), cmd); err != nil {
+		handleError(w, req, err)
+		return
+	}
+	
+	routing.JSON(w, req, http.StatusCreated, map[string]bool{"success": true})
+}
+
+func (r *Router) getUser(w http.ResponseWriter, req *http.Request) {
+	// Create query
+	q := &query.GetUserByIDQuery{UserID: routing.Param(req, "id")}
+	
+	// Dispatch query
+	result, err := r.queryBus.Dispatch(req.Context(), q)
+	if err != nil {
+		handleError(w, req, err)
+		return
+	}
+	
+	routing.JSON(w, req, http.StatusOK, result)
+}
+
+func (r *Router) listUsers(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	limit := 20
+	
+	// Parse query parameters
+	if l := params.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+	
+	// Create query
+	q := &query.ListUsersQuery{
+		Cursor: params.Get("cursor"),
+		Limit:  limit,
+		Status: params.Get("status"),
+	}
+	
+	// Dispatch query
+	result, err := r.queryBus.Dispatch(req.Context(), q)
+	if err != nil {
+		handleError(w, req, err)
+		return
+	}
+	
+	routing.JSON(w, req, http.StatusOK, result)
+}
+
+func (r *Router) updateUser(w http.ResponseWriter, req *http.Request) {
+	userID := routing.Param(req, "id")
+	
+	var body struct {
+		Name   *string ` + "`json:\"name\"`" + `
+		Status *string ` + "`json:\"status\"`" + `
+	}
+	
+	if err := routing.Decode(req, &body); err != nil {
+		problem.Write(w, req, problem.New(problem.TypeValidation, err.Error()))
+		return
+	}
+	
+	// Handle profile update
+	if body.Name != nil {
+		cmd := &command.UpdateUserProfileCommand{
+			BaseCommand: command.NewBaseCommand("user.update_profile"),
+			UserID:      userID,
+			Name:        *body.Name,
+		}
+		
+		if err := r.commandBus.Dispatch(req.Context(), cmd); err != nil {
+			handleError(w, req, err)
+			return
+		}
+	}
+	
+	// Handle status change
+	if body.Status != nil {
+		cmd := &command.ChangeUserStatusCommand{
+			BaseCommand: command.NewBaseCommand("user.change_status"),
+			UserID:      userID,
+			Status:      *body.Status,
+		}
+		
+		if err := r.commandBus.Dispatch(req.Context(), cmd); err != nil {
+			handleError(w, req, err)
+			return
+		}
+	}
+	
+	routing.JSON(w, req, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (r *Router) deleteUser(w http.ResponseWriter, req *http.Request) {
+	// Create command
+	cmd := &command.DeleteUserCommand{
+		BaseCommand: command.NewBaseCommand("user.delete"),
+		UserID:      routing.Param(req, "id"),
+	}
+	
+	// Dispatch command
+	if err := r.commandBus.Dispatch(req.Context(), cmd); err != nil {
+		handleError(w, req, err)
+		return
+	}
+	
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleError answers a domain error with the problem of its code
+func handleError(w http.ResponseWriter, req *http.Request, err error) {
+	problem.Write(w, req, domainProblem(err))
+}
+
+// domainProblem is the problem answering a domain error
+func domainProblem(err error) *problem.Problem {
+	if domainErr, ok := err.(*aggregate.DomainError); ok {
+		switch domainErr.Code {
+		case "USER_NOT_FOUND":
+			return problem.New(problem.TypeNotFound, domainErr.Message)
+		case "USER_EXISTS":
+			return problem.New(problem.TypeConflict, domainErr.Message)
+		default:
+			return problem.New(problem.TypeValidation, domainErr.Message)
+		}
+	}
+	
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return problem.New(problem.TypeValidation, err.Error())
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return problem.New(problem.TypeConflict, err.Error())
+	}
+	
+	return problem.FromError(err)
+}
+`
+
+const DDDAPIRouterEvents = `package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"{{.Module}}/application/command"
+	"{{.Module}}/application/query"
+	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/repository"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/problem"
+)
+
+// Router holds the API dependencies
+type Router struct {
+	commandBus command.CommandBus
+	queryBus   query.QueryBus
+}
+
+// NewRouter creates a new API router
+func NewRouter(commandBus command.CommandBus, queryBus query.QueryBus) *Router {
+	return &Router{
+		commandBus: commandBus,
+		queryBus:   queryBus,
+	}
+}
+
+// HandleRequest dispatches an API event to the handler of its route
+func (r *Router) HandleRequest(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	switch {
+	case apievent.Matches(request, http.MethodGet, "/health"):
+		return jsonResponse(http.StatusOK, map[string]string{
+			"status":  "healthy",
+			"service": "{{.Name}}",
+		})
+	case apievent.Matches(request, http.MethodPost, "/users"):
+		return r.createUser(ctx, request)
+	case apievent.Matches(request, http.MethodGet, "/users"):
+		return r.listUsers(ctx, request)
+	case apievent.Matches(request, http.MethodGet, "/users/{id}"):
+		return r.getUser(ctx, request)
+	case apievent.Matches(request, http.MethodPut, "/users/{id}"):
+		return r.updateUser(ctx, request)
+	case apievent.Matches(request, http.MethodDelete, "/users/{id}"):
+		return r.deleteUser(ctx, request)
+	default:
+		return problem.Response(ctx, request, problem.New(problem.TypeNotFound, "Route not found")), nil
+	}
+}
+
+func (r *Router) createUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	var body struct {
+		Email string ` + "`json:\"email\"`" + `
+		Name  string ` + "`json:\"name\"`" + `
+	}
+	
+	if err := decodeBody(request, &body); err != nil {
+		return problem.Response(ctx, request, problem.New(problem.TypeValidation, err.Error())), nil
+	}
+	if body.Email == "" || body.Name == "" {
+		return problem.Response(ctx, request, problem.New(problem.TypeValidation, "email and name are required")), nil
+	}
+	
+	// Create command
+	cmd := command.NewCreateUserCommand(body.Email, body.Name)
+	
+	// Dispatch command
+	if err := r.commandBus.Dispatch(ctx, cmd); err != nil {
+		return problem.Response(ctx, request, domainProblem(err)), nil
+	}
+	
+	return jsonResponse(http.StatusCreated, map[string]bool{"success": true})
+}
+
+func (r *Router) getUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	// Create query
+	q := &query.GetUserByIDQuery{UserID: apievent.PathParameter(request, "/users/{id}", "id")}
+	
+	// Dispatch query
+	result, err := r.queryBus.Dispatch(ctx, q)
+	if err != nil {
+		return problem.Response(ctx, request, domainProblem(err)), nil
+	}
+	
+	return jsonResponse(http.StatusOK, result)
+}
+
+func (r *Router) listUsers(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	params := request.QueryStringParameters
+	limit := 20
+	
+	// Parse query parameters
+	if l := params["limit"]; l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+	
+	// Create query
+	q := &query.ListUsersQuery{
+		Cursor: params["cursor"],
+		Limit:  limit,
+		Status: params["status"],
+	}
+	
+	// Dispatch query
+	result, err := r.queryBus.Dispatch(ctx, q)
+	if err != nil {
+		return problem.Response(ctx, request, domainProblem(err)), nil
+	}
+	
+	return jsonResponse(http.StatusOK, result)
+}
+
+func (r *Router) updateUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	userID := apievent.PathParameter(request, "/users/{id}", "id")
+	
+	var body struct {
+		Name   *string ` + "`json:\"name\"`" + `
+		Status *string ` + "`json:\"status\"`" + `
+	}
+	
+	if err := decodeBody(request, &body); err != nil {
+		return problem.Response(ctx, request, problem.New(problem.TypeValidation, err.Error())), nil
+	}
+	
+	// Handle profile update
+	if body.Name != nil {
+		cmd := &command.UpdateUserProfileCommand{
+			BaseCommand: command.NewBaseCommand("user.update_profile"),
+			UserID:      userID,
+			Name:        *body.Name,
+		}
+		
+		if err := r.commandBus.Dispatch(ctx, cmd); err != nil {
+			return problem.Response(ctx, request, domainProblem(err)), nil
+		}
+	}
+	
+	// Handle status change
+	if body.Status != nil {
+		cmd := &command.ChangeUserStatusCommand{
+			BaseCommand: command.NewBaseCommand("user.change_status"),
+			UserID:      userID,
+			Status:      *body.Status,
+		}
+		
+		if err := r.commandBus.Dispatch(ctx, cmd); err != nil {
+			return problem.Response(ctx, request, domainProblem(err)), nil
+		}
+	}
+	
+	return jsonResponse(http.StatusOK, map[string]bool{"success": true})
+}
+
+func (r *Router) deleteUser(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	// Create command
+	cmd := &command.DeleteUserCommand{
+		BaseCommand: command.NewBaseCommand("user.delete"),
+		UserID:      apievent.PathParameter(request, "/users/{id}", "id"),
+	}
+	
+	// Dispatch command
+	if err := r.commandBus.Dispatch(ctx, cmd); err != nil {
+		return problem.Response(ctx, request, domainProblem(err)), nil
+	}
+	
+	return apievent.NewResponse(http.StatusNoContent, nil, ""), nil
+}
+
+// domainProblem is the problem answering a domain error
+func domainProblem(err error) *problem.Problem {
+	if domainErr, ok := err.(*aggregate.DomainError); ok {
+		switch domainErr.Code {
+		case "USER_NOT_FOUND":
+			return problem.New(problem.TypeNotFound, domainErr.Message)
+		case "USER_EXISTS":
+			return problem.New(problem.TypeConflict, domainErr.Message)
+		default:
+			return problem.New(problem.TypeValidation, domainErr.Message)
+		}
+	}
+	
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return problem.New(problem.TypeValidation, err.Error())
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return problem.New(problem.TypeConflict, err.Error())
+	}
+	
+	return problem.FromError(err)
+}
+
+// decodeBody reads the JSON body of request into v
+func decodeBody(request apievent.Request, v interface{}) error {
+	body, err := apievent.Body(request)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		return errors.New("invalid request body")
+	}
+	return nil
+}
+
+func jsonResponse(statusCode int, v interface{}) (apievent.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return apievent.Response{}, err
+	}
+	return apievent.NewResponse(statusCode, map[string]string{"Content-Type": "application/json"}, string(body)), nil
+}
+`
+
+const DDDAPIHandlers = `package api
+
+import (
+	"context"
+{{- if .UsesRouter }}
+	"net/http"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"{{.Module}}/application/command"
+	"{{.Module}}/application/query"
+	"{{.Module}}/infrastructure"
+	"{{.Module}}/infrastructure/config"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/openapi"
+	"{{.Module}}/pkg/tracing"
+	"github.com/rs/zerolog/log"
+)
+
+// Handler handles API requests
+type Handler struct {
+	router *Router
+{{- if .UsesRouter }}
+	routes http.Handler
+{{- end }}
+}
+
+// NewHandler creates a new API handler
+func NewHandler(commandBus command.CommandBus, queryBus query.QueryBus) *Handler {
+	// Create router and setup routes
+	router := NewRouter(commandBus, queryBus)
+	
+	return &Handler{
+		router: router,
+{{- if .UsesRouter }}
+		routes: router.Handler(),
+{{- end }}
+	}
+}
+
+// HandleRequest handles the Lambda request
+func (h *Handler) HandleRequest(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	// Add request ID to logs
+	log.Ctx(ctx).Info().
+		Str("method", apievent.Method(request)).
+		Str("path", apievent.Path(request)).
+		Str("request_id", apievent.RequestID(request)).
+		Msg("Processing API request")
+	
+{{- if .UsesRouter }}
+	
+	// Process request through {{.Router}}
+	return apievent.Serve(ctx, h.routes, request)
+{{- else }}
+	
+	// Dispatch on the event itself
+	return h.router.HandleRequest(ctx, request)
+{{- end }}
+}
+
+// Bootstrap wires the command and query buses into a Handler. Start serves
+// it on Lambda, cmd/local-api on localhost.
+func Bootstrap(cfg *config.Config) (*Handler, error) {
+	// Initialize infrastructure
+	infra, err := infrastructure.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Create command bus
+	commandBus := infrastructure.NewCommandBus()
+	
+	// Register command handlers
+	commandBus.Register("user.create", command.NewCreateUserHandler(infra.UserRepository()))
+	// Register other command handlers...
 	
 	// Create query bus
 	queryBus := infrastructure.NewQueryBus()
@@ -1563,11 +2204,34 @@ func Start() {
 	queryBus.Register("user.list", query.NewListUsersHandler(infra.UserRepository()))
 	// Register other query handlers...
 	
-	// Create handler
-	handler := NewHandler(commandBus, queryBus)
+	return NewHandler(commandBus, queryBus), nil
+}
+
+// Start starts the Lambda handler
+func Start() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+	
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+	
+	handler, err := Bootstrap(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize infrastructure")
+	}
 	
 	// Start Lambda
-	lambda.Start(handler.HandleRequest)
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(handler.HandleRequest)))))
 }
 `
 
@@ -1612,11 +2276,11 @@ func (h *APIHandler) GetUser(ctx context.Context, userID string) (*query.UserDTO
 }
 
 // ListUsers handles listing users
-func (h *APIHandler) ListUsers(ctx context.Context, page, pageSize int, status string) (*query.ListUsersResult, error) {
+func (h *APIHandler) ListUsers(ctx context.Context, cursor string, limit int, status string) (*query.ListUsersResult, error) {
 	q := &query.ListUsersQuery{
-		Page:     page,
-		PageSize: pageSize,
-		Status:   status,
+		Cursor: cursor,
+		Limit:  limit,
+		Status: status,
 	}
 	
 	result, err := h.queryBus.Dispatch(ctx, q)
@@ -1670,8 +2334,11 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"{{.Module}}/application/handler"
-	"{{.Module}}/domain/event"
+	"{{.Module}}/infrastructure"
 	"{{.Module}}/infrastructure/config"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/tracing"
 	"github.com/rs/zerolog/log"
 )
 
@@ -1687,24 +2354,30 @@ func NewSQSHandler(messageHandler *handler.MessageHandler) *SQSHandler {
 	}
 }
 
-// HandleRequest processes SQS events
-func (h *SQSHandler) HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) error {
+// HandleRequest processes SQS events. Failed records are returned as batch
+// item failures so SQS redelivers only those messages.
+func (h *SQSHandler) HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	log.Ctx(ctx).Info().
 		Int("message_count", len(sqsEvent.Records)).
 		Msg("Processing SQS messages")
 	
+	var response events.SQSEventResponse
 	for _, record := range sqsEvent.Records {
-		if err := h.processMessage(ctx, record); err != nil {
+		err := tracing.ProcessSQSMessage(ctx, record, func(ctx context.Context) error {
+			return h.processMessage(ctx, record)
+		})
+		if err != nil {
 			log.Ctx(ctx).Error().
 				Err(err).
 				Str("message_id", record.MessageId).
 				Msg("Failed to process message")
-			// Return error to retry the message
-			return err
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
 		}
 	}
 	
-	return nil
+	return response, nil
 }
 
 func (h *SQSHandler) processMessage(ctx context.Context, record events.SQSMessage) error {
@@ -1737,6 +2410,11 @@ func Start() {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 	
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+	
 	// Initialize infrastructure
 	infra, err := infrastructure.New(cfg)
 	if err != nil {
@@ -1755,7 +2433,7 @@ func Start() {
 	sqsHandler := NewSQSHandler(messageHandler)
 	
 	// Start Lambda
-	lambda.Start(sqsHandler.HandleRequest)
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(sqsHandler.HandleRequest))))
 }
 `
 
@@ -1768,7 +2446,9 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"{{.Module}}/domain/event"
+	"{{.Module}}/pkg/tracing"
 	"github.com/rs/zerolog/log"
 )
 
@@ -1806,7 +2486,7 @@ func (c *SQSClient) PublishEvent(ctx context.Context, event event.DomainEvent) e
 	_, err = c.client.SendMessage(ctx, &sqs.SendMessageInput{
 		QueueUrl:    aws.String(c.queueURL),
 		MessageBody: aws.String(string(body)),
-		MessageAttributes: map[string]types.MessageAttributeValue{
+		MessageAttributes: tracing.InjectSQS(ctx, map[string]types.MessageAttributeValue{
 			"event_type": {
 				DataType:    aws.String("String"),
 				StringValue: aws.String(event.EventType()),
@@ -1815,7 +2495,7 @@ func (c *SQSClient) PublishEvent(ctx context.Context, event event.DomainEvent) e
 				DataType:    aws.String("String"),
 				StringValue: aws.String(event.AggregateID()),
 			},
-		},
+		}),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
@@ -1838,6 +2518,11 @@ func (c *SQSClient) PublishEvents(ctx context.Context, events []event.DomainEven
 	}
 	return nil
 }
+
+// Publish implements event.EventPublisher
+func (c *SQSClient) Publish(ctx context.Context, events []event.DomainEvent) error {
+	return c.PublishEvents(ctx, events)
+}
 `
 
 const DDDMessageHandler = `package handler
@@ -1848,6 +2533,7 @@ import (
 	"fmt"
 
 	"{{.Module}}/domain/event"
+	"{{.Module}}/domain/repository"
 	"github.com/rs/zerolog/log"
 )
 
@@ -1946,56 +2632,163 @@ func (h *UserUpdatedHandler) Handle(ctx context.Context, payload json.RawMessage
 }
 `
 
-const DDDDynamoDBRepository = `package repository
+const DDDDynamoDBRepository = `package persistence
+
+import (
+	"{{.Module}}/domain/repository"
+)
+
+// DynamoDBUserRepository is the domain's UserRepository; the transactional
+// outbox write in Save is what the domain relies on
+var _ repository.UserRepository = (*DynamoDBUserRepository)(nil)
+
+// NewUserRepository returns the DynamoDB repository as the domain's
+// UserRepository
+func NewUserRepository(dynamoRepo *DynamoDBUserRepository) repository.UserRepository {
+	return dynamoRepo
+}
+`
+
+const DDDInfrastructure = `package infrastructure
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
-	"{{.Module}}/domain/aggregate"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"{{.Module}}/application/command"
+	"{{.Module}}/application/query"
+	"{{.Module}}/domain/event"
 	"{{.Module}}/domain/repository"
+	"{{.Module}}/infrastructure/config"
 	"{{.Module}}/infrastructure/persistence"
+	"{{.Module}}/pkg/resilience"
+	"{{.Module}}/pkg/tracing"
 )
 
-// userRepository implements the domain UserRepository interface
-type userRepository struct {
-	dynamoRepo *persistence.DynamoDBUserRepository
+// Infrastructure holds the adapters the application layer is wired to
+type Infrastructure struct {
+	userRepo repository.UserRepository
+	eventBus event.EventBus
 }
 
-// NewUserRepository creates a new user repository
-func NewUserRepository(dynamoRepo *persistence.DynamoDBUserRepository) repository.UserRepository {
-	return &userRepository{
-		dynamoRepo: dynamoRepo,
+// New creates the AWS clients and adapters described by cfg
+func New(cfg *config.Config) (*Infrastructure, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
 	}
+	tracing.InstrumentAWS(&awsCfg)
+	resilience.InstrumentAWS(&awsCfg, resilience.DefaultBackoff)
+
+	client := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	})
+
+	return &Infrastructure{
+		userRepo: persistence.NewDynamoDBUserRepository(client, cfg.DynamoDBTableName).WithCursorSecret(cfg.CursorSecret),
+		eventBus: NewEventBus(),
+	}, nil
 }
 
-// Save delegates to the DynamoDB repository
-func (r *userRepository) Save(ctx context.Context, user *aggregate.User) error {
-	return r.dynamoRepo.Save(ctx, user)
+// UserRepository returns the user repository
+func (i *Infrastructure) UserRepository() repository.UserRepository {
+	return i.userRepo
 }
 
-// FindByID delegates to the DynamoDB repository
-func (r *userRepository) FindByID(ctx context.Context, id string) (*aggregate.User, error) {
-	return r.dynamoRepo.FindByID(ctx, id)
+// EventBus returns the in-process event bus
+func (i *Infrastructure) EventBus() event.EventBus {
+	return i.eventBus
 }
 
-// FindByEmail delegates to the DynamoDB repository
-func (r *userRepository) FindByEmail(ctx context.Context, email string) (*aggregate.User, error) {
-	return r.dynamoRepo.FindByEmail(ctx, email)
+// EventBus delivers events to the subscribed handlers in the same process
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []event.EventHandler
 }
 
-// List delegates to the DynamoDB repository
-func (r *userRepository) List(ctx context.Context, offset, limit int) ([]*aggregate.User, error) {
-	return r.dynamoRepo.List(ctx, offset, limit)
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
 }
 
-// Count delegates to the DynamoDB repository
-func (r *userRepository) Count(ctx context.Context) (int64, error) {
-	return r.dynamoRepo.Count(ctx)
+// Subscribe implements event.EventBus
+func (b *EventBus) Subscribe(handler event.EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
 }
 
-// Delete delegates to the DynamoDB repository
-func (r *userRepository) Delete(ctx context.Context, id string) error {
-	return r.dynamoRepo.Delete(ctx, id)
+// Publish implements event.EventBus. It stops at the first handler error.
+func (b *EventBus) Publish(ctx context.Context, events []event.DomainEvent) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, evt := range events {
+		for _, handler := range handlers {
+			if !handler.CanHandle(evt) {
+				continue
+			}
+			if err := handler.Handle(ctx, evt); err != nil {
+				return fmt.Errorf("failed to handle %s: %w", evt.EventType(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// CommandBus dispatches commands to the handler registered for their type
+type CommandBus struct {
+	handlers map[string]command.CommandHandler
+}
+
+// NewCommandBus creates a command bus without handlers
+func NewCommandBus() *CommandBus {
+	return &CommandBus{handlers: make(map[string]command.CommandHandler)}
+}
+
+// Register implements command.CommandBus
+func (b *CommandBus) Register(commandType string, handler command.CommandHandler) {
+	b.handlers[commandType] = handler
+}
+
+// Dispatch implements command.CommandBus
+func (b *CommandBus) Dispatch(ctx context.Context, cmd command.Command) error {
+	handler, ok := b.handlers[cmd.CommandType()]
+	if !ok {
+		return fmt.Errorf("no handler registered for command %q", cmd.CommandType())
+	}
+	return handler.Handle(ctx, cmd)
+}
+
+// QueryBus dispatches queries to the handler registered for their type
+type QueryBus struct {
+	handlers map[string]query.QueryHandler
+}
+
+// NewQueryBus creates a query bus without handlers
+func NewQueryBus() *QueryBus {
+	return &QueryBus{handlers: make(map[string]query.QueryHandler)}
+}
+
+// Register implements query.QueryBus
+func (b *QueryBus) Register(queryType string, handler query.QueryHandler) {
+	b.handlers[queryType] = handler
+}
+
+// Dispatch implements query.QueryBus
+func (b *QueryBus) Dispatch(ctx context.Context, q query.Query) (interface{}, error) {
+	handler, ok := b.handlers[q.QueryType()]
+	if !ok {
+		return nil, fmt.Errorf("no handler registered for query %q", q.QueryType())
+	}
+	return handler.Handle(ctx, q)
 }
 `
 
@@ -2003,4 +2796,138 @@ const DDDUserRepository = `package repository
 
 // This file is generated by DDDRepository template
 // It provides the concrete implementation of UserRepository for the infrastructure layer
-`
\ No newline at end of file
+`
+const DDDConfig = `package config
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/joho/godotenv"
+
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+{{- if .HasFeature "api" }}
+	"{{.Module}}/pkg/openapi"
+{{- end }}
+	"{{.Module}}/pkg/tracing"
+)
+
+// Config holds all configuration for the application. Fields tagged ssm or
+// appconfig can also come from SSM Parameter Store or AppConfig (see
+// pkg/configsource).
+type Config struct {
+	// Application
+	AppName     string ` + "`env:\"APP_NAME\" envDefault:\"{{.Name}}\"`" + `
+	Environment string ` + "`env:\"APP_ENV\" envDefault:\"development\"`" + `
+	LogLevel    string ` + "`env:\"LOG_LEVEL\" envDefault:\"info\"`" + `
+	
+	// AWS
+	AWSRegion string ` + "`env:\"AWS_REGION\" envDefault:\"us-east-1\"`" + `
+	
+	// Tracing
+	TracingExporter string ` + "`env:\"TRACING_EXPORTER\" envDefault:\"xray\"`" + `
+	TracingEndpoint string ` + "`env:\"TRACING_ENDPOINT\" envDefault:\"localhost:4318\"`" + `
+	
+	// Metrics
+	MetricsNamespace string ` + "`env:\"METRICS_NAMESPACE\" envDefault:\"{{.Name}}\"`" + `
+	
+	// DynamoDB
+	DynamoDBTableName string ` + "`env:\"DYNAMODB_TABLE_NAME\" envDefault:\"{{.Name}}-{{.DataModel.Table.Name}}\"`" + `
+	DynamoDBEndpoint  string ` + "`env:\"DYNAMODB_ENDPOINT\"`" + `
+	CursorSecret      string ` + "`env:\"CURSOR_SECRET\" ssm:\"cursor-secret\"`" + `
+	{{- if .HasFeature "dynamodb" }}
+
+	// Event store
+	EventStoreTableName string ` + "`env:\"EVENT_STORE_TABLE_NAME\" envDefault:\"{{.Name}}-events\"`" + `
+	SnapshotEvery       int    ` + "`env:\"EVENT_STORE_SNAPSHOT_EVERY\" envDefault:\"50\"`" + `
+
+	// Outbox relay targets; the relay publishes to every one that is set
+	OutboxQueueURL string ` + "`env:\"OUTBOX_QUEUE_URL\"`" + `
+	{{- if .HasFeature "sns" }}
+	OutboxTopicARN string ` + "`env:\"OUTBOX_TOPIC_ARN\"`" + `
+	{{- end }}
+	{{- if .HasFeature "eventbridge" }}
+	OutboxEventBusName string ` + "`env:\"OUTBOX_EVENT_BUS_NAME\"`" + `
+	{{- end }}
+	{{- end }}
+	
+	{{- if .HasFeature "sqs" }}
+	// SQS
+	SQSQueueURL   string ` + "`env:\"SQS_QUEUE_URL\"`" + `
+	SQSDLQueueURL string ` + "`env:\"SQS_DLQ_URL\"`" + `
+	SQSEndpoint   string ` + "`env:\"SQS_ENDPOINT\"`" + `
+	{{- end }}
+	
+	{{- if .HasFeature "s3" }}
+	// S3
+	S3BucketName string ` + "`env:\"S3_BUCKET_NAME\"`" + `
+	S3Endpoint   string ` + "`env:\"S3_ENDPOINT\"`" + `
+	{{- end }}
+	
+	{{- if .HasFeature "api" }}
+	// API
+	ValidateResponses bool ` + "`env:\"OPENAPI_VALIDATE_RESPONSES\" envDefault:\"true\"`" + `
+	{{- end }}
+}
+
+// current is the configuration as last refreshed
+var current atomic.Pointer[Config]
+
+// Current returns the configuration as last refreshed by configsource.Wrap.
+// The Config returned by Load keeps the values it was loaded with.
+func Current() *Config {
+	return current.Load()
+}
+
+// Load loads configuration from environment variables and the sources of
+// pkg/configsource, which Wrap refreshes in warm containers
+func Load() (*Config, error) {
+	// Load .env file if it exists
+	_ = godotenv.Load(".env.local")
+	_ = godotenv.Load(".env")
+	
+	ctx := context.Background()
+	loader, err := configsource.FromEnvironment(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up configuration sources: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := loader.Load(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	current.Store(cfg)
+	configsource.Watch(loader, &current, nil)
+	
+	return cfg, nil
+}
+
+// TracingOptions configures tracing.Init
+func (c *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		ServiceName: c.AppName,
+		Exporter:    c.TracingExporter,
+		Endpoint:    c.TracingEndpoint,
+	}
+}
+
+// MetricsOptions configures metrics.Init
+func (c *Config) MetricsOptions() metrics.Options {
+	return metrics.Options{
+		Namespace: c.MetricsNamespace,
+		Service:   c.AppName,
+	}
+}
+{{- if .HasFeature "api" }}
+
+// OpenAPIOptions configures openapi.Load. Responses are never validated in
+// production.
+func (c *Config) OpenAPIOptions() openapi.Options {
+	return openapi.Options{
+		ValidateResponses: c.ValidateResponses && c.Environment != "production" && c.Environment != "prod",
+	}
+}
+{{- end }}
+`
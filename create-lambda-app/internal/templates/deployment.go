@@ -24,6 +24,15 @@ Globals:
         LOG_LEVEL: !Ref LogLevel
         AWS_XRAY_TRACING_NAME: {{.Name}}
         _X_AMZN_TRACE_ID: !Ref AWS::NoValue
+        TRACING_EXPORTER: xray
+        CONFIG_SSM_PATH: !Sub /{{.Name}}/${Environment}
+        CONFIG_APPCONFIG_APPLICATION: !Ref ConfigApplication
+        CONFIG_APPCONFIG_ENVIRONMENT: !Ref ConfigEnvironment
+        CONFIG_APPCONFIG_PROFILE: !Ref FeatureFlagsProfile
+    # ADOT collector extension: receives the functions' OTLP spans on
+    # localhost:4318 and forwards them to X-Ray
+    Layers:
+      - !Sub arn:aws:lambda:${AWS::Region}:901920570463:layer:aws-otel-collector-amd64-ver-0-90-1:1
     Tracing: Active
     Tags:
       Application: {{.Name}}
@@ -48,9 +57,38 @@ Parameters:
       - warn
       - error
     Description: Application log level
+  {{- if and (.HasFeature "api") (eq .APIType "alb") (eq .Architecture "clean") }}
+
+  VpcId:
+    Type: AWS::EC2::VPC::Id
+    Description: VPC of the load balancer
+
+  SubnetIds:
+    Type: List<AWS::EC2::Subnet::Id>
+    Description: Public subnets of the load balancer, in at least two availability zones
+  {{- end }}
+  {{- if and (.HasFeature "api") (or (eq .APIType "rest") (eq .APIType "http")) }}
+
+  ApiThrottleRateLimit:
+    Type: Number
+    Default: 50
+    Description: Requests per second API Gateway lets through{{ if eq .APIType "rest" }} per API key{{ end }}
+
+  ApiThrottleBurstLimit:
+    Type: Number
+    Default: 100
+    Description: Requests API Gateway lets through at once{{ if eq .APIType "rest" }} per API key{{ end }}
+  {{- end }}
+  {{- if and (.HasFeature "api") (eq .APIType "rest") }}
+
+  ApiQuotaLimit:
+    Type: Number
+    Default: 100000
+    Description: Requests per API key per day
+  {{- end }}
 
 Resources:
-  {{- if .HasFeature "api" }}
+  {{- if and (.HasFeature "api") (eq .APIType "rest") }}
   # API Gateway
   ApiGateway:
     Type: AWS::Serverless::Api
@@ -68,11 +106,97 @@ Resources:
           CognitoAuthorizer:
             UserPoolArn: !GetAtt CognitoUserPool.Arn
         {{- end }}
+        # Every route requires the X-Api-Key header; the key SAM creates
+        # (ApiGatewayApiKey) is throttled by the usage plan
+        ApiKeyRequired: true
+        # Browsers send CORS preflights without the key
+        AddApiKeyRequiredToCorsPreflight: false
+        UsagePlan:
+          CreateUsagePlan: PER_API
+          Description: !Sub ${AWS::StackName} API clients
+          Throttle:
+            RateLimit: !Ref ApiThrottleRateLimit
+            BurstLimit: !Ref ApiThrottleBurstLimit
+          Quota:
+            Limit: !Ref ApiQuotaLimit
+            Period: DAY
       DefinitionBody:
         Fn::Transform:
           Name: AWS::Include
           Parameters:
             Location: ./docs/openapi.yaml
+  {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+  # API Gateway HTTP API, routes come from the function events
+  HttpApi:
+    Type: AWS::Serverless::HttpApi
+    Properties:
+      StageName: !Ref Environment
+      # HTTP APIs have no usage plans; the stage throttles every route
+      DefaultRouteSettings:
+        ThrottlingRateLimit: !Ref ApiThrottleRateLimit
+        ThrottlingBurstLimit: !Ref ApiThrottleBurstLimit
+      CorsConfiguration:
+        AllowMethods:
+          - "*"
+        AllowHeaders:
+          - "*"
+        AllowOrigins:
+          - "*"
+      {{- if .HasFeature "cognito" }}
+      Auth:
+        Authorizers:
+          CognitoAuthorizer:
+            IdentitySource: $request.header.Authorization
+            JwtConfiguration:
+              issuer: !Sub https://cognito-idp.${AWS::Region}.amazonaws.com/${CognitoUserPool}
+              audience:
+                - !Ref CognitoUserPoolClient
+      {{- end }}
+  {{- else if and (.HasFeature "api") (eq .APIType "alb") (eq .Architecture "clean") }}
+  # Application Load Balancer forwarding every request to the user function
+  LoadBalancerSecurityGroup:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupDescription: !Sub ${AWS::StackName} load balancer
+      VpcId: !Ref VpcId
+      SecurityGroupIngress:
+        - IpProtocol: tcp
+          FromPort: 80
+          ToPort: 80
+          CidrIp: 0.0.0.0/0
+
+  LoadBalancer:
+    Type: AWS::ElasticLoadBalancingV2::LoadBalancer
+    Properties:
+      Scheme: internet-facing
+      Subnets: !Ref SubnetIds
+      SecurityGroups:
+        - !Ref LoadBalancerSecurityGroup
+
+  UserFunctionLoadBalancerPermission:
+    Type: AWS::Lambda::Permission
+    Properties:
+      FunctionName: !GetAtt UserFunction.Arn
+      Action: lambda:InvokeFunction
+      Principal: elasticloadbalancing.amazonaws.com
+
+  UserTargetGroup:
+    Type: AWS::ElasticLoadBalancingV2::TargetGroup
+    DependsOn: UserFunctionLoadBalancerPermission
+    Properties:
+      TargetType: lambda
+      Targets:
+        - Id: !GetAtt UserFunction.Arn
+
+  LoadBalancerListener:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      LoadBalancerArn: !Ref LoadBalancer
+      Port: 80
+      Protocol: HTTP
+      DefaultActions:
+        - Type: forward
+          TargetGroupArn: !Ref UserTargetGroup
   {{- end }}
 
   # Lambda Functions
@@ -83,8 +207,9 @@ Resources:
       FunctionName: !Sub ${AWS::StackName}-user-handler
       CodeUri: build/
       Handler: user/bootstrap
-      {{- if .HasFeature "api" }}
+      {{- if and (.HasFeature "api") (eq .APIType "rest") }}
       Events:
+        # BEGIN API routes (create-lambda-app generate api)
         CreateUser:
           Type: Api
           Properties:
@@ -115,17 +240,80 @@ Resources:
             RestApiId: !Ref ApiGateway
             Path: /users/{id}
             Method: DELETE
+        # END API routes
+      {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+      Events:
+        # BEGIN API routes (create-lambda-app generate api)
+        CreateUser:
+          Type: HttpApi
+          Properties:
+            ApiId: !Ref HttpApi
+            Path: /users
+            Method: POST
+        GetUser:
+          Type: HttpApi
+          Properties:
+            ApiId: !Ref HttpApi
+            Path: /users/{id}
+            Method: GET
+        ListUsers:
+          Type: HttpApi
+          Properties:
+            ApiId: !Ref HttpApi
+            Path: /users
+            Method: GET
+        UpdateUser:
+          Type: HttpApi
+          Properties:
+            ApiId: !Ref HttpApi
+            Path: /users/{id}
+            Method: PUT
+        DeleteUser:
+          Type: HttpApi
+          Properties:
+            ApiId: !Ref HttpApi
+            Path: /users/{id}
+            Method: DELETE
+        # END API routes
+      {{- else if and (.HasFeature "api") (eq .APIType "function-url") }}
+      FunctionUrlConfig:
+        AuthType: NONE
+        Cors:
+          AllowOrigins:
+            - "*"
+          AllowMethods:
+            - "*"
+          AllowHeaders:
+            - "*"
       {{- end }}
       Environment:
         Variables:
           {{- if .HasFeature "dynamodb" }}
           DYNAMODB_TABLE_NAME: !Ref UserTable
+          IDEMPOTENCY_TABLE_NAME: !Ref IdempotencyTable
+          {{- if .HasFeature "api" }}
+          RATE_LIMIT_TABLE_NAME: !Ref RateLimitTable
+          {{- end }}
+          {{- end }}
+          {{- if .HasFeature "api" }}
+          RESPONSE_BUCKET_NAME: !Ref ResponseBucket
           {{- end }}
       Policies:
         - AWSLambdaBasicExecutionRole
+        - !Ref ConfigReadPolicy
         {{- if .HasFeature "dynamodb" }}
         - DynamoDBCrudPolicy:
             TableName: !Ref UserTable
+        - DynamoDBCrudPolicy:
+            TableName: !Ref IdempotencyTable
+        {{- if .HasFeature "api" }}
+        - DynamoDBCrudPolicy:
+            TableName: !Ref RateLimitTable
+        {{- end }}
+        {{- end }}
+        {{- if .HasFeature "api" }}
+        - S3CrudPolicy:
+            BucketName: !Ref ResponseBucket
         {{- end }}
   {{- end }}
 
@@ -142,13 +330,23 @@ Resources:
           Properties:
             Queue: !GetAtt MessageQueue.Arn
             BatchSize: 10
+            FunctionResponseTypes:
+              - ReportBatchItemFailures
       Environment:
         Variables:
           SQS_QUEUE_URL: !Ref MessageQueue
+          {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+          IDEMPOTENCY_TABLE_NAME: !Ref IdempotencyTable
+          {{- end }}
       Policies:
         - AWSLambdaBasicExecutionRole
+        - !Ref ConfigReadPolicy
         - SQSPollerPolicy:
             QueueName: !GetAtt MessageQueue.QueueName
+        {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+        - DynamoDBCrudPolicy:
+            TableName: !Ref IdempotencyTable
+        {{- end }}
   {{- end }}
 
   {{- if .HasFeature "eventbridge" }}
@@ -171,6 +369,50 @@ Resources:
                 - UserDeleted
       Policies:
         - AWSLambdaBasicExecutionRole
+        - !Ref ConfigReadPolicy
+  {{- end }}
+
+  {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+  # Publishes the domain events the repository writes to the outbox
+  OutboxRelayFunction:
+    Type: AWS::Serverless::Function
+    Properties:
+      FunctionName: !Sub ${AWS::StackName}-outbox-relay
+      CodeUri: build/
+      Handler: outbox-relay/bootstrap
+      Events:
+        OutboxStream:
+          Type: DynamoDB
+          Properties:
+            Stream: !GetAtt UserTable.StreamArn
+            StartingPosition: TRIM_HORIZON
+            BatchSize: 100
+            FunctionResponseTypes:
+              - ReportBatchItemFailures
+            FilterCriteria:
+              Filters:
+                - Pattern: '{"eventName": ["INSERT"], "dynamodb": {"NewImage": {"type": {"S": ["OutboxMessage"]}}}}'
+      {{- if or (.HasFeature "sqs") (.HasFeature "eventbridge") }}
+      Environment:
+        Variables:
+          {{- if .HasFeature "sqs" }}
+          OUTBOX_QUEUE_URL: !Ref MessageQueue
+          {{- end }}
+          {{- if .HasFeature "eventbridge" }}
+          OUTBOX_EVENT_BUS_NAME: default
+          {{- end }}
+      {{- end }}
+      Policies:
+        - AWSLambdaBasicExecutionRole
+        - !Ref ConfigReadPolicy
+        {{- if .HasFeature "sqs" }}
+        - SQSSendMessagePolicy:
+            QueueName: !GetAtt MessageQueue.QueueName
+        {{- end }}
+        {{- if .HasFeature "eventbridge" }}
+        - EventBridgePutEventsPolicy:
+            EventBusName: default
+        {{- end }}
   {{- end }}
 
   # Infrastructure Resources
@@ -178,23 +420,83 @@ Resources:
   UserTable:
     Type: AWS::DynamoDB::Table
     Properties:
-      TableName: !Sub ${AWS::StackName}-users
+      # Generated from access-patterns.yaml
+      TableName: !Sub ${AWS::StackName}-{{.DataModel.Table.Name}}
       BillingMode: PAY_PER_REQUEST
       StreamSpecification:
         StreamViewType: NEW_AND_OLD_IMAGES
       AttributeDefinitions:
-        - AttributeName: id
+        {{- range .DataModel.KeyAttributes }}
+        - AttributeName: {{ . }}
+          AttributeType: S
+        {{- end }}
+      KeySchema:
+        - AttributeName: {{ .DataModel.Table.PartitionKey }}
+          KeyType: HASH
+        {{- if .DataModel.Table.SortKey }}
+        - AttributeName: {{ .DataModel.Table.SortKey }}
+          KeyType: RANGE
+        {{- end }}
+      {{- if .DataModel.Table.Indexes }}
+      GlobalSecondaryIndexes:
+        {{- range .DataModel.Table.Indexes }}
+        - IndexName: {{ .Name }}
+          KeySchema:
+            - AttributeName: {{ .PartitionKey }}
+              KeyType: HASH
+            {{- if .SortKey }}
+            - AttributeName: {{ .SortKey }}
+              KeyType: RANGE
+            {{- end }}
+          Projection:
+            ProjectionType: ALL
+        {{- end }}
+      {{- end }}
+      {{- if eq .Architecture "ddd" }}
+      # Deletes outbox items once their expires_at passes
+      TimeToLiveSpecification:
+        AttributeName: expires_at
+        Enabled: true
+      {{- end }}
+      PointInTimeRecoverySpecification:
+        PointInTimeRecoveryEnabled: true
+      SSESpecification:
+        SSEEnabled: true
+      Tags:
+        - Key: Application
+          Value: {{.Name}}
+        - Key: Environment
+          Value: !Ref Environment
+  {{- end }}
+
+  {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+  # One stream per aggregate; the events-by-day index serves GetEventsSince
+  EventStoreTable:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      TableName: !Sub ${AWS::StackName}-events
+      BillingMode: PAY_PER_REQUEST
+      AttributeDefinitions:
+        - AttributeName: stream_id
           AttributeType: S
-        - AttributeName: email
+        - AttributeName: version
+          AttributeType: N
+        - AttributeName: day
+          AttributeType: S
+        - AttributeName: occurred_at
           AttributeType: S
       KeySchema:
-        - AttributeName: id
+        - AttributeName: stream_id
           KeyType: HASH
+        - AttributeName: version
+          KeyType: RANGE
       GlobalSecondaryIndexes:
-        - IndexName: email-index
+        - IndexName: events-by-day
           KeySchema:
-            - AttributeName: email
+            - AttributeName: day
               KeyType: HASH
+            - AttributeName: occurred_at
+              KeyType: RANGE
           Projection:
             ProjectionType: ALL
       PointInTimeRecoverySpecification:
@@ -208,6 +510,57 @@ Resources:
           Value: !Ref Environment
   {{- end }}
 
+  {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+  # Idempotency records; DynamoDB's TTL deletes them once expires_at passes
+  IdempotencyTable:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      TableName: !Sub ${AWS::StackName}-idempotency
+      BillingMode: PAY_PER_REQUEST
+      AttributeDefinitions:
+        - AttributeName: id
+          AttributeType: S
+      KeySchema:
+        - AttributeName: id
+          KeyType: HASH
+      TimeToLiveSpecification:
+        AttributeName: expires_at
+        Enabled: true
+      SSESpecification:
+        SSEEnabled: true
+      Tags:
+        - Key: Application
+          Value: {{.Name}}
+        - Key: Environment
+          Value: !Ref Environment
+  {{- if .HasFeature "api" }}
+
+  # Rate limit buckets shared by every container; DynamoDB's TTL deletes a
+  # bucket once it has refilled
+  RateLimitTable:
+    Type: AWS::DynamoDB::Table
+    Properties:
+      TableName: !Sub ${AWS::StackName}-rate-limits
+      BillingMode: PAY_PER_REQUEST
+      AttributeDefinitions:
+        - AttributeName: id
+          AttributeType: S
+      KeySchema:
+        - AttributeName: id
+          KeyType: HASH
+      TimeToLiveSpecification:
+        AttributeName: expires_at
+        Enabled: true
+      SSESpecification:
+        SSEEnabled: true
+      Tags:
+        - Key: Application
+          Value: {{.Name}}
+        - Key: Environment
+          Value: !Ref Environment
+  {{- end }}
+  {{- end }}
+
   {{- if .HasFeature "sqs" }}
   MessageQueue:
     Type: AWS::SQS::Queue
@@ -266,6 +619,35 @@ Resources:
           Value: !Ref Environment
   {{- end }}
 
+  {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+
+  # Responses too large for Lambda to return, fetched by clients through
+  # presigned URLs
+  ResponseBucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketEncryption:
+        ServerSideEncryptionConfiguration:
+          - ServerSideEncryptionByDefault:
+              SSEAlgorithm: AES256
+      LifecycleConfiguration:
+        Rules:
+          - Id: DeleteOffloadedResponses
+            Prefix: responses/
+            ExpirationInDays: 1
+            Status: Enabled
+      PublicAccessBlockConfiguration:
+        BlockPublicAcls: true
+        BlockPublicPolicy: true
+        IgnorePublicAcls: true
+        RestrictPublicBuckets: true
+      Tags:
+        - Key: Application
+          Value: {{.Name}}
+        - Key: Environment
+          Value: !Ref Environment
+  {{- end }}
+
   {{- if .HasFeature "cognito" }}
   CognitoUserPool:
     Type: AWS::Cognito::UserPool
@@ -299,11 +681,86 @@ Resources:
         - ALLOW_REFRESH_TOKEN_AUTH
   {{- end }}
 
+  # Runtime configuration read by pkg/configsource: parameters under
+  # /{{.Name}}/<environment> in SSM Parameter Store, and feature flags in
+  # AppConfig
+  ConfigApplication:
+    Type: AWS::AppConfig::Application
+    Properties:
+      Name: !Ref AWS::StackName
+
+  ConfigEnvironment:
+    Type: AWS::AppConfig::Environment
+    Properties:
+      ApplicationId: !Ref ConfigApplication
+      Name: !Ref Environment
+
+  FeatureFlagsProfile:
+    Type: AWS::AppConfig::ConfigurationProfile
+    Properties:
+      ApplicationId: !Ref ConfigApplication
+      Name: feature-flags
+      LocationUri: hosted
+      Type: AWS.AppConfig.FeatureFlags
+
+  # Initial flags; change them in the AppConfig console, warm functions
+  # pick them up within CONFIG_REFRESH_INTERVAL
+  FeatureFlagsVersion:
+    Type: AWS::AppConfig::HostedConfigurationVersion
+    Properties:
+      ApplicationId: !Ref ConfigApplication
+      ConfigurationProfileId: !Ref FeatureFlagsProfile
+      ContentType: application/json
+      Content: '{"version": "1", "flags": {"debugLogging": {"name": "Debug logging"}}, "values": {"debugLogging": {"enabled": false}}}'
+
+  FeatureFlagsDeployment:
+    Type: AWS::AppConfig::Deployment
+    Properties:
+      ApplicationId: !Ref ConfigApplication
+      EnvironmentId: !Ref ConfigEnvironment
+      ConfigurationProfileId: !Ref FeatureFlagsProfile
+      ConfigurationVersion: !Ref FeatureFlagsVersion
+      DeploymentStrategyId: AppConfig.AllAtOnce
+
+  ConfigReadPolicy:
+    Type: AWS::IAM::ManagedPolicy
+    Properties:
+      Description: Lets the functions read their configuration from SSM Parameter Store and AppConfig
+      PolicyDocument:
+        Version: '2012-10-17'
+        Statement:
+          - Effect: Allow
+            Action: ssm:GetParametersByPath
+            Resource:
+              - !Sub arn:aws:ssm:${AWS::Region}:${AWS::AccountId}:parameter/{{.Name}}/${Environment}
+              - !Sub arn:aws:ssm:${AWS::Region}:${AWS::AccountId}:parameter/{{.Name}}/${Environment}/*
+          - Effect: Allow
+            Action:
+              - appconfig:StartConfigurationSession
+              - appconfig:GetLatestConfiguration
+            Resource: !Sub arn:aws:appconfig:${AWS::Region}:${AWS::AccountId}:application/${ConfigApplication}/environment/${ConfigEnvironment}/configuration/${FeatureFlagsProfile}
+
 Outputs:
-  {{- if .HasFeature "api" }}
+  {{- if and (.HasFeature "api") (eq .APIType "rest") }}
   ApiUrl:
     Description: API Gateway endpoint URL
     Value: !Sub https://${ApiGateway}.execute-api.${AWS::Region}.amazonaws.com/${Environment}
+
+  ApiKeyId:
+    Description: ID of the API key; read its value with aws apigateway get-api-key --include-value
+    Value: !Ref ApiGatewayApiKey
+  {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+  ApiUrl:
+    Description: API Gateway HTTP API endpoint URL
+    Value: !Sub https://${HttpApi}.execute-api.${AWS::Region}.amazonaws.com/${Environment}
+  {{- else if and (.HasFeature "api") (eq .APIType "function-url") (eq .Architecture "clean") }}
+  ApiUrl:
+    Description: User function URL
+    Value: !GetAtt UserFunctionUrl.FunctionUrl
+  {{- else if and (.HasFeature "api") (eq .APIType "alb") (eq .Architecture "clean") }}
+  ApiUrl:
+    Description: Load balancer URL
+    Value: !Sub http://${LoadBalancer.DNSName}
   {{- end }}
 
   {{- if .HasFeature "dynamodb" }}
@@ -312,6 +769,24 @@ Outputs:
     Value: !Ref UserTable
   {{- end }}
 
+  {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+  EventStoreTableName:
+    Description: DynamoDB table name for the event store
+    Value: !Ref EventStoreTable
+  {{- end }}
+
+  {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+  IdempotencyTableName:
+    Description: DynamoDB table name for idempotency records
+    Value: !Ref IdempotencyTable
+  {{- if .HasFeature "api" }}
+
+  RateLimitTableName:
+    Description: DynamoDB table name for rate limit buckets
+    Value: !Ref RateLimitTable
+  {{- end }}
+  {{- end }}
+
   {{- if .HasFeature "sqs" }}
   MessageQueueUrl:
     Description: SQS queue URL
@@ -328,6 +803,12 @@ Outputs:
     Value: !Ref StorageBucket
   {{- end }}
 
+  {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+  ResponseBucketName:
+    Description: S3 bucket name for offloaded responses
+    Value: !Ref ResponseBucket
+  {{- end }}
+
   {{- if .HasFeature "cognito" }}
   UserPoolId:
     Description: Cognito User Pool ID
@@ -337,6 +818,14 @@ Outputs:
     Description: Cognito User Pool Client ID
     Value: !Ref CognitoUserPoolClient
   {{- end }}
+
+  ConfigParameterPath:
+    Description: SSM Parameter Store path the functions read their configuration from
+    Value: !Sub /{{.Name}}/${Environment}
+
+  ConfigApplicationId:
+    Description: AppConfig application holding the feature flags
+    Value: !Ref ConfigApplication
 `
 
 const SAMConfig = `version = 0.1
@@ -533,12 +1022,12 @@ const CDKPackageJSON = `{
     "@types/node": "20.8.10",
     "jest": "^29.7.0",
     "ts-jest": "^29.1.1",
-    "aws-cdk": "2.110.0",
+    "aws-cdk": "2.112.0",
     "ts-node": "^10.9.1",
     "typescript": "~5.2.2"
   },
   "dependencies": {
-    "aws-cdk-lib": "2.110.0",
+    "aws-cdk-lib": "2.112.0",
     "constructs": "^10.0.0",
     "source-map-support": "^0.5.21"
   }
@@ -548,7 +1037,18 @@ const CDKPackageJSON = `{
 const CDKStack = `import * as cdk from 'aws-cdk-lib';
 import { Construct } from 'constructs';
 import * as lambda from 'aws-cdk-lib/aws-lambda';
+{{- if .HasFeature "api" }}
+{{- if eq .APIType "rest" }}
 import * as apigateway from 'aws-cdk-lib/aws-apigateway';
+{{- else if eq .APIType "http" }}
+import * as apigwv2 from 'aws-cdk-lib/aws-apigatewayv2';
+import { HttpLambdaIntegration } from 'aws-cdk-lib/aws-apigatewayv2-integrations';
+{{- else if and (eq .APIType "alb") (eq .Architecture "clean") }}
+import * as ec2 from 'aws-cdk-lib/aws-ec2';
+import * as elbv2 from 'aws-cdk-lib/aws-elasticloadbalancingv2';
+import { LambdaTarget } from 'aws-cdk-lib/aws-elasticloadbalancingv2-targets';
+{{- end }}
+{{- end }}
 {{- if .HasFeature "dynamodb" }}
 import * as dynamodb from 'aws-cdk-lib/aws-dynamodb';
 {{- end }}
@@ -556,12 +1056,20 @@ import * as dynamodb from 'aws-cdk-lib/aws-dynamodb';
 import * as sqs from 'aws-cdk-lib/aws-sqs';
 import { SqsEventSource } from 'aws-cdk-lib/aws-lambda-event-sources';
 {{- end }}
-{{- if .HasFeature "s3" }}
+{{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+import { DynamoEventSource } from 'aws-cdk-lib/aws-lambda-event-sources';
+{{- if .HasFeature "eventbridge" }}
+import * as events from 'aws-cdk-lib/aws-events';
+{{- end }}
+{{- end }}
+{{- if or (.HasFeature "s3") (and (eq .Architecture "clean") (.HasFeature "api")) }}
 import * as s3 from 'aws-cdk-lib/aws-s3';
 {{- end }}
 {{- if .HasFeature "cognito" }}
 import * as cognito from 'aws-cdk-lib/aws-cognito';
 {{- end }}
+import * as appconfig from 'aws-cdk-lib/aws-appconfig';
+import * as iam from 'aws-cdk-lib/aws-iam';
 import * as logs from 'aws-cdk-lib/aws-logs';
 import * as path from 'path';
 
@@ -576,29 +1084,107 @@ export class {{.Name}}Stack extends cdk.Stack {
     const env = props.environment;
 
     {{- if .HasFeature "dynamodb" }}
-    // DynamoDB Table
+    // DynamoDB Table, generated from access-patterns.yaml
     const userTable = new dynamodb.Table(this, 'UserTable', {
-      tableName: ` + "`${this.stackName}-users`" + `,
+      tableName: ` + "`${this.stackName}-{{.DataModel.Table.Name}}`" + `,
       partitionKey: {
-        name: 'id',
+        name: '{{.DataModel.Table.PartitionKey}}',
         type: dynamodb.AttributeType.STRING
       },
+      {{- if .DataModel.Table.SortKey }}
+      sortKey: {
+        name: '{{.DataModel.Table.SortKey}}',
+        type: dynamodb.AttributeType.STRING
+      },
+      {{- end }}
       billingMode: dynamodb.BillingMode.PAY_PER_REQUEST,
       encryption: dynamodb.TableEncryption.AWS_MANAGED,
       pointInTimeRecovery: true,
       stream: dynamodb.StreamViewType.NEW_AND_OLD_IMAGES,
+      {{- if eq .Architecture "ddd" }}
+      // Deletes outbox items once their expires_at passes
+      timeToLiveAttribute: 'expires_at',
+      {{- end }}
     });
+    {{- range .DataModel.Table.Indexes }}
 
     userTable.addGlobalSecondaryIndex({
-      indexName: 'email-index',
+      indexName: '{{ .Name }}',
+      partitionKey: {
+        name: '{{ .PartitionKey }}',
+        type: dynamodb.AttributeType.STRING
+      },
+      {{- if .SortKey }}
+      sortKey: {
+        name: '{{ .SortKey }}',
+        type: dynamodb.AttributeType.STRING
+      },
+      {{- end }}
+      projectionType: dynamodb.ProjectionType.ALL
+    });
+    {{- end }}
+    {{- end }}
+
+    {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+    // Event store: one stream per aggregate, with an index for GetEventsSince
+    const eventStoreTable = new dynamodb.Table(this, 'EventStoreTable', {
+      tableName: ` + "`${this.stackName}-events`" + `,
+      partitionKey: {
+        name: 'stream_id',
+        type: dynamodb.AttributeType.STRING
+      },
+      sortKey: {
+        name: 'version',
+        type: dynamodb.AttributeType.NUMBER
+      },
+      billingMode: dynamodb.BillingMode.PAY_PER_REQUEST,
+      encryption: dynamodb.TableEncryption.AWS_MANAGED,
+      pointInTimeRecovery: true,
+    });
+
+    eventStoreTable.addGlobalSecondaryIndex({
+      indexName: 'events-by-day',
       partitionKey: {
-        name: 'email',
+        name: 'day',
+        type: dynamodb.AttributeType.STRING
+      },
+      sortKey: {
+        name: 'occurred_at',
         type: dynamodb.AttributeType.STRING
       },
       projectionType: dynamodb.ProjectionType.ALL
     });
     {{- end }}
 
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    // Idempotency records; DynamoDB's TTL deletes them once expires_at passes
+    const idempotencyTable = new dynamodb.Table(this, 'IdempotencyTable', {
+      tableName: ` + "`${this.stackName}-idempotency`" + `,
+      partitionKey: {
+        name: 'id',
+        type: dynamodb.AttributeType.STRING
+      },
+      billingMode: dynamodb.BillingMode.PAY_PER_REQUEST,
+      encryption: dynamodb.TableEncryption.AWS_MANAGED,
+      timeToLiveAttribute: 'expires_at',
+    });
+    {{- if .HasFeature "api" }}
+
+    // Rate limit buckets shared by every container; DynamoDB's TTL deletes a
+    // bucket once it has refilled
+    const rateLimitTable = new dynamodb.Table(this, 'RateLimitTable', {
+      tableName: ` + "`${this.stackName}-rate-limits`" + `,
+      partitionKey: {
+        name: 'id',
+        type: dynamodb.AttributeType.STRING
+      },
+      billingMode: dynamodb.BillingMode.PAY_PER_REQUEST,
+      encryption: dynamodb.TableEncryption.AWS_MANAGED,
+      timeToLiveAttribute: 'expires_at',
+    });
+    {{- end }}
+    {{- end }}
+
     {{- if .HasFeature "sqs" }}
     // SQS Queues
     const deadLetterQueue = new sqs.Queue(this, 'DeadLetterQueue', {
@@ -633,6 +1219,22 @@ export class {{.Name}}Stack extends cdk.Stack {
     });
     {{- end }}
 
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+
+    // Responses too large for Lambda to return, fetched by clients through
+    // presigned URLs
+    const responseBucket = new s3.Bucket(this, 'ResponseBucket', {
+      encryption: s3.BucketEncryption.S3_MANAGED,
+      lifecycleRules: [{
+        prefix: 'responses/',
+        expiration: cdk.Duration.days(1),
+      }],
+      blockPublicAccess: s3.BlockPublicAccess.BLOCK_ALL,
+      removalPolicy: cdk.RemovalPolicy.DESTROY,
+      autoDeleteObjects: true,
+    });
+    {{- end }}
+
     {{- if .HasFeature "cognito" }}
     // Cognito User Pool
     const userPool = new cognito.UserPool(this, 'UserPool', {
@@ -669,13 +1271,77 @@ export class {{.Name}}Stack extends cdk.Stack {
     });
     {{- end }}
 
-    // Lambda Functions
-    const lambdaEnvironment = {
-      APP_NAME: '{{.Name}}',
-      APP_ENV: env,
-      LOG_LEVEL: env === 'dev' ? 'debug' : env === 'staging' ? 'info' : 'warn',
-      {{- if .HasFeature "dynamodb" }}
-      DYNAMODB_TABLE_NAME: userTable.tableName,
+    // Runtime configuration read by pkg/configsource: parameters under
+    // /{{.Name}}/<environment> in SSM Parameter Store, and feature flags in
+    // AppConfig
+    const configParameterPath = ` + "`/{{.Name}}/${env}`" + `;
+    const configApplication = new appconfig.CfnApplication(this, 'ConfigApplication', {
+      name: this.stackName,
+    });
+    const configEnvironment = new appconfig.CfnEnvironment(this, 'ConfigEnvironment', {
+      applicationId: configApplication.ref,
+      name: env,
+    });
+    const featureFlagsProfile = new appconfig.CfnConfigurationProfile(this, 'FeatureFlagsProfile', {
+      applicationId: configApplication.ref,
+      name: 'feature-flags',
+      locationUri: 'hosted',
+      type: 'AWS.AppConfig.FeatureFlags',
+    });
+
+    // Initial flags; change them in the AppConfig console, warm functions
+    // pick them up within CONFIG_REFRESH_INTERVAL
+    const featureFlagsVersion = new appconfig.CfnHostedConfigurationVersion(this, 'FeatureFlagsVersion', {
+      applicationId: configApplication.ref,
+      configurationProfileId: featureFlagsProfile.ref,
+      contentType: 'application/json',
+      content: JSON.stringify({
+        version: '1',
+        flags: { debugLogging: { name: 'Debug logging' } },
+        values: { debugLogging: { enabled: false } },
+      }),
+    });
+    new appconfig.CfnDeployment(this, 'FeatureFlagsDeployment', {
+      applicationId: configApplication.ref,
+      environmentId: configEnvironment.ref,
+      configurationProfileId: featureFlagsProfile.ref,
+      configurationVersion: featureFlagsVersion.ref,
+      deploymentStrategyId: 'AppConfig.AllAtOnce',
+    });
+
+    const configReadPolicy = new iam.Policy(this, 'ConfigReadPolicy', {
+      statements: [
+        new iam.PolicyStatement({
+          actions: ['ssm:GetParametersByPath'],
+          resources: [
+            ` + "`arn:aws:ssm:${this.region}:${this.account}:parameter${configParameterPath}`" + `,
+            ` + "`arn:aws:ssm:${this.region}:${this.account}:parameter${configParameterPath}/*`" + `,
+          ],
+        }),
+        new iam.PolicyStatement({
+          actions: ['appconfig:StartConfigurationSession', 'appconfig:GetLatestConfiguration'],
+          resources: [
+            ` + "`arn:aws:appconfig:${this.region}:${this.account}:application/${configApplication.ref}/environment/${configEnvironment.ref}/configuration/${featureFlagsProfile.ref}`" + `,
+          ],
+        }),
+      ],
+    });
+
+    // Lambda Functions
+    const lambdaEnvironment = {
+      APP_NAME: '{{.Name}}',
+      APP_ENV: env,
+      LOG_LEVEL: env === 'dev' ? 'debug' : env === 'staging' ? 'info' : 'warn',
+      {{- if .HasFeature "dynamodb" }}
+      DYNAMODB_TABLE_NAME: userTable.tableName,
+      {{- if eq .Architecture "ddd" }}
+      EVENT_STORE_TABLE_NAME: eventStoreTable.tableName,
+      {{- else if eq .Architecture "clean" }}
+      IDEMPOTENCY_TABLE_NAME: idempotencyTable.tableName,
+      {{- if .HasFeature "api" }}
+      RATE_LIMIT_TABLE_NAME: rateLimitTable.tableName,
+      {{- end }}
+      {{- end }}
       {{- end }}
       {{- if .HasFeature "sqs" }}
       SQS_QUEUE_URL: messageQueue.queueUrl,
@@ -683,12 +1349,25 @@ export class {{.Name}}Stack extends cdk.Stack {
       {{- if .HasFeature "s3" }}
       S3_BUCKET_NAME: storageBucket.bucketName,
       {{- end }}
+      {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+      RESPONSE_BUCKET_NAME: responseBucket.bucketName,
+      {{- end }}
       {{- if .HasFeature "cognito" }}
       COGNITO_USER_POOL_ID: userPool.userPoolId,
       COGNITO_CLIENT_ID: userPoolClient.userPoolClientId,
       {{- end }}
+      TRACING_EXPORTER: 'xray',
+      CONFIG_SSM_PATH: configParameterPath,
+      CONFIG_APPCONFIG_APPLICATION: configApplication.ref,
+      CONFIG_APPCONFIG_ENVIRONMENT: configEnvironment.ref,
+      CONFIG_APPCONFIG_PROFILE: featureFlagsProfile.ref,
     };
 
+    // ADOT collector extension: receives the functions' OTLP spans on
+    // localhost:4318 and forwards them to X-Ray
+    const adotCollectorLayer = lambda.LayerVersion.fromLayerVersionArn(this, 'AdotCollectorLayer',
+      ` + "`arn:aws:lambda:${this.region}:901920570463:layer:aws-otel-collector-amd64-ver-0-90-1:1`" + `);
+
     {{- if eq .Architecture "clean" }}
     const userFunction = new lambda.Function(this, 'UserFunction', {
       functionName: ` + "`${this.stackName}-user-handler`" + `,
@@ -699,11 +1378,20 @@ export class {{.Name}}Stack extends cdk.Stack {
       timeout: cdk.Duration.seconds(30),
       environment: lambdaEnvironment,
       tracing: lambda.Tracing.ACTIVE,
+      layers: [adotCollectorLayer],
       logRetention: logs.RetentionDays.ONE_WEEK,
     });
 
+    configReadPolicy.attachToRole(userFunction.role!);
     {{- if .HasFeature "dynamodb" }}
     userTable.grantReadWriteData(userFunction);
+    idempotencyTable.grantReadWriteData(userFunction);
+    {{- if .HasFeature "api" }}
+    rateLimitTable.grantReadWriteData(userFunction);
+    {{- end }}
+    {{- end }}
+    {{- if .HasFeature "api" }}
+    responseBucket.grantReadWrite(userFunction, 'responses/*');
     {{- end }}
     {{- end }}
 
@@ -717,17 +1405,66 @@ export class {{.Name}}Stack extends cdk.Stack {
       timeout: cdk.Duration.seconds(180),
       environment: lambdaEnvironment,
       tracing: lambda.Tracing.ACTIVE,
+      layers: [adotCollectorLayer],
       logRetention: logs.RetentionDays.ONE_WEEK,
     });
 
+    configReadPolicy.attachToRole(messageProcessorFunction.role!);
     messageQueue.grantConsumeMessages(messageProcessorFunction);
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    idempotencyTable.grantReadWriteData(messageProcessorFunction);
+    {{- end }}
     messageProcessorFunction.addEventSource(new SqsEventSource(messageQueue, {
       batchSize: 10,
       maxBatchingWindow: cdk.Duration.seconds(5),
+      reportBatchItemFailures: true,
     }));
     {{- end }}
 
-    {{- if .HasFeature "api" }}
+    {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+    // Publishes the domain events the repository writes to the outbox
+    const outboxRelayFunction = new lambda.Function(this, 'OutboxRelayFunction', {
+      functionName: ` + "`${this.stackName}-outbox-relay`" + `,
+      runtime: lambda.Runtime.PROVIDED_AL2023,
+      handler: 'bootstrap',
+      code: lambda.Code.fromAsset(path.join(__dirname, '../../build/outbox-relay')),
+      memorySize: 512,
+      timeout: cdk.Duration.seconds(30),
+      environment: {
+        ...lambdaEnvironment,
+        {{- if .HasFeature "sqs" }}
+        OUTBOX_QUEUE_URL: messageQueue.queueUrl,
+        {{- end }}
+        {{- if .HasFeature "eventbridge" }}
+        OUTBOX_EVENT_BUS_NAME: 'default',
+        {{- end }}
+      },
+      tracing: lambda.Tracing.ACTIVE,
+      layers: [adotCollectorLayer],
+      logRetention: logs.RetentionDays.ONE_WEEK,
+    });
+
+    outboxRelayFunction.addEventSource(new DynamoEventSource(userTable, {
+      startingPosition: lambda.StartingPosition.TRIM_HORIZON,
+      batchSize: 100,
+      reportBatchItemFailures: true,
+      filters: [
+        lambda.FilterCriteria.filter({
+          eventName: lambda.FilterRule.isEqual('INSERT'),
+          dynamodb: { NewImage: { type: { S: lambda.FilterRule.isEqual('OutboxMessage') } } },
+        }),
+      ],
+    }));
+    configReadPolicy.attachToRole(outboxRelayFunction.role!);
+    {{- if .HasFeature "sqs" }}
+    messageQueue.grantSendMessages(outboxRelayFunction);
+    {{- end }}
+    {{- if .HasFeature "eventbridge" }}
+    events.EventBus.fromEventBusName(this, 'DefaultEventBus', 'default').grantPutEventsTo(outboxRelayFunction);
+    {{- end }}
+    {{- end }}
+
+    {{- if and (.HasFeature "api") (eq .APIType "rest") }}
     // API Gateway
     const api = new apigateway.RestApi(this, 'Api', {
       restApiName: ` + "`${this.stackName}-api`" + `,
@@ -747,20 +1484,41 @@ export class {{.Name}}Stack extends cdk.Stack {
         allowMethods: apigateway.Cors.ALL_METHODS,
         allowHeaders: ['Content-Type', 'X-Amz-Date', 'Authorization', 'X-Api-Key', 'X-Request-ID'],
       },
+      // Every route requires the X-Api-Key header, metered by the usage plan
+      defaultMethodOptions: {
+        apiKeyRequired: true,
+      },
+    });
+
+    const apiKey = api.addApiKey('ApiKey', {
+      apiKeyName: ` + "`${this.stackName}-key`" + `,
+    });
+    const usagePlan = api.addUsagePlan('UsagePlan', {
+      name: ` + "`${this.stackName}-usage-plan`" + `,
+      throttle: {
+        rateLimit: 50,
+        burstLimit: 100,
+      },
+      quota: {
+        limit: 100000,
+        period: apigateway.Period.DAY,
+      },
     });
+    usagePlan.addApiKey(apiKey);
+    usagePlan.addApiStage({ stage: api.deploymentStage });
 
     {{- if eq .Architecture "clean" }}
     // User endpoints
-    const users = api.root.addResource('users');
     const userIntegration = new apigateway.LambdaIntegration(userFunction);
-    
+    // BEGIN API routes (create-lambda-app generate api)
+    const users = api.root.addResource('users');
     users.addMethod('POST', userIntegration);
     users.addMethod('GET', userIntegration);
-    
     const userById = users.addResource('{id}');
     userById.addMethod('GET', userIntegration);
     userById.addMethod('PUT', userIntegration);
     userById.addMethod('DELETE', userIntegration);
+    // END API routes
     {{- end }}
 
     // Output the API URL
@@ -768,6 +1526,97 @@ export class {{.Name}}Stack extends cdk.Stack {
       value: api.url,
       description: 'API Gateway endpoint URL',
     });
+
+    new cdk.CfnOutput(this, 'ApiKeyId', {
+      value: apiKey.keyId,
+      description: 'ID of the API key; read its value with aws apigateway get-api-key --include-value',
+    });
+    {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+    // API Gateway HTTP API
+    const api = new apigwv2.HttpApi(this, 'Api', {
+      apiName: ` + "`${this.stackName}-api`" + `,
+      corsPreflight: {
+        allowOrigins: env === 'dev' 
+          ? ['http://localhost:3000', 'http://localhost:8080']
+          : env === 'staging'
+          ? ['https://staging.{{.Name}}.com']
+          : ['https://{{.Name}}.com', 'https://www.{{.Name}}.com'],
+        allowMethods: [apigwv2.CorsHttpMethod.ANY],
+        allowHeaders: ['Content-Type', 'X-Amz-Date', 'Authorization', 'X-Api-Key', 'X-Request-ID'],
+      },
+    });
+
+    // HTTP APIs have no usage plans; the stage throttles every route
+    const defaultStage = api.defaultStage!.node.defaultChild as apigwv2.CfnStage;
+    defaultStage.defaultRouteSettings = {
+      throttlingRateLimit: 50,
+      throttlingBurstLimit: 100,
+    };
+
+    {{- if eq .Architecture "clean" }}
+    // User endpoints
+    const userIntegration = new HttpLambdaIntegration('UserIntegration', userFunction);
+
+    // BEGIN API routes (create-lambda-app generate api)
+    api.addRoutes({
+      path: '/users',
+      methods: [apigwv2.HttpMethod.POST, apigwv2.HttpMethod.GET],
+      integration: userIntegration,
+    });
+    api.addRoutes({
+      path: '/users/{id}',
+      methods: [apigwv2.HttpMethod.GET, apigwv2.HttpMethod.PUT, apigwv2.HttpMethod.DELETE],
+      integration: userIntegration,
+    });
+    // END API routes
+    {{- end }}
+
+    // Output the API URL
+    new cdk.CfnOutput(this, 'ApiUrl', {
+      value: api.apiEndpoint,
+      description: 'API Gateway HTTP API endpoint URL',
+    });
+    {{- else if and (.HasFeature "api") (eq .APIType "function-url") (eq .Architecture "clean") }}
+    // Function URL
+    const userFunctionUrl = userFunction.addFunctionUrl({
+      authType: lambda.FunctionUrlAuthType.NONE,
+      cors: {
+        allowedOrigins: ['*'],
+        allowedMethods: [lambda.HttpMethod.ALL],
+        allowedHeaders: ['Content-Type', 'Authorization', 'X-Request-ID'],
+      },
+    });
+
+    // Output the function URL
+    new cdk.CfnOutput(this, 'ApiUrl', {
+      value: userFunctionUrl.url,
+      description: 'User function URL',
+    });
+    {{- else if and (.HasFeature "api") (eq .APIType "alb") (eq .Architecture "clean") }}
+    // Application Load Balancer, in the VPC given as -c vpcId=... or a new one
+    const vpcId = this.node.tryGetContext('vpcId');
+    const vpc = vpcId
+      ? ec2.Vpc.fromLookup(this, 'Vpc', { vpcId })
+      : new ec2.Vpc(this, 'Vpc', { maxAzs: 2, natGateways: 0 });
+
+    const loadBalancer = new elbv2.ApplicationLoadBalancer(this, 'LoadBalancer', {
+      vpc,
+      internetFacing: true,
+    });
+
+    const listener = loadBalancer.addListener('Listener', {
+      port: 80,
+      open: true,
+    });
+    listener.addTargets('UserTarget', {
+      targets: [new LambdaTarget(userFunction)],
+    });
+
+    // Output the load balancer URL
+    new cdk.CfnOutput(this, 'ApiUrl', {
+      value: ` + "`http://${loadBalancer.loadBalancerDnsName}`" + `,
+      description: 'Load balancer URL',
+    });
     {{- end }}
 
     // Stack outputs
@@ -778,6 +1627,34 @@ export class {{.Name}}Stack extends cdk.Stack {
     });
     {{- end }}
 
+    {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+    new cdk.CfnOutput(this, 'EventStoreTableName', {
+      value: eventStoreTable.tableName,
+      description: 'DynamoDB table name for the event store',
+    });
+    {{- end }}
+
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    new cdk.CfnOutput(this, 'IdempotencyTableName', {
+      value: idempotencyTable.tableName,
+      description: 'DynamoDB table name for idempotency records',
+    });
+    {{- if .HasFeature "api" }}
+
+    new cdk.CfnOutput(this, 'RateLimitTableName', {
+      value: rateLimitTable.tableName,
+      description: 'DynamoDB table name for rate limit buckets',
+    });
+    {{- end }}
+    {{- end }}
+
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+    new cdk.CfnOutput(this, 'ResponseBucketName', {
+      value: responseBucket.bucketName,
+      description: 'S3 bucket name for offloaded responses',
+    });
+    {{- end }}
+
     {{- if .HasFeature "sqs" }}
     new cdk.CfnOutput(this, 'MessageQueueUrl', {
       value: messageQueue.queueUrl,
@@ -796,6 +1673,16 @@ export class {{.Name}}Stack extends cdk.Stack {
       description: 'Cognito User Pool Client ID',
     });
     {{- end }}
+
+    new cdk.CfnOutput(this, 'ConfigParameterPath', {
+      value: configParameterPath,
+      description: 'SSM Parameter Store path the functions read their configuration from',
+    });
+
+    new cdk.CfnOutput(this, 'ConfigApplicationId', {
+      value: configApplication.ref,
+      description: 'AppConfig application holding the feature flags',
+    });
   }
 }
 `
@@ -835,17 +1722,42 @@ describe('{{.Name}}Stack', () => {
     
     const template = Template.fromStack(stack);
 
-    {{- if .HasFeature "api" }}
+    {{- if and (.HasFeature "api") (eq .APIType "rest") }}
     // Check API Gateway exists
     template.hasResourceProperties('AWS::ApiGateway::RestApi', {
       Name: 'TestStack-api',
     });
+
+    // Check API clients are throttled by a usage plan
+    template.hasResourceProperties('AWS::ApiGateway::UsagePlan', {
+      Throttle: {
+        RateLimit: 50,
+        BurstLimit: 100,
+      },
+    });
+    template.resourceCountIs('AWS::ApiGateway::UsagePlanKey', 1);
+    {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+    // Check the HTTP API exists
+    template.hasResourceProperties('AWS::ApiGatewayV2::Api', {
+      Name: 'TestStack-api',
+      ProtocolType: 'HTTP',
+    });
+    {{- else if and (.HasFeature "api") (eq .APIType "function-url") (eq .Architecture "clean") }}
+    // Check the function URL exists
+    template.hasResourceProperties('AWS::Lambda::Url', {
+      AuthType: 'NONE',
+    });
+    {{- else if and (.HasFeature "api") (eq .APIType "alb") (eq .Architecture "clean") }}
+    // Check the load balancer forwards to the function
+    template.hasResourceProperties('AWS::ElasticLoadBalancingV2::TargetGroup', {
+      TargetType: 'lambda',
+    });
     {{- end }}
 
     {{- if .HasFeature "dynamodb" }}
     // Check DynamoDB table exists
     template.hasResourceProperties('AWS::DynamoDB::Table', {
-      TableName: 'TestStack-users',
+      TableName: 'TestStack-{{.DataModel.Table.Name}}',
       BillingMode: 'PAY_PER_REQUEST',
     });
     {{- end }}
@@ -863,6 +1775,13 @@ describe('{{.Name}}Stack', () => {
       UserPoolName: 'TestStack-users',
     });
     {{- end }}
+
+    // Check the feature flags are deployed to AppConfig
+    template.hasResourceProperties('AWS::AppConfig::ConfigurationProfile', {
+      Name: 'feature-flags',
+      Type: 'AWS.AppConfig.FeatureFlags',
+    });
+    template.resourceCountIs('AWS::AppConfig::Deployment', 1);
   });
 });
 `
@@ -882,20 +1801,76 @@ provider:
   timeout: 30
   tracing:
     lambda: true
+    {{- if and (.HasFeature "api") (eq .APIType "rest") }}
     apiGateway: true
+    {{- end }}
+  # ADOT collector extension: receives the functions' OTLP spans on
+  # localhost:4318 and forwards them to X-Ray
+  layers:
+    - arn:aws:lambda:${self:provider.region}:901920570463:layer:aws-otel-collector-amd64-ver-0-90-1:1
+  {{- if and (.HasFeature "api") (eq .APIType "http") }}
+  httpApi:
+    cors:
+      allowedOrigins: ${self:custom.cors.${self:provider.stage}.origins}
+      allowedHeaders:
+        - Content-Type
+        - Authorization
+        - X-Request-ID
+  {{- else if and (.HasFeature "api") (eq .APIType "rest") }}
+  # Private routes require the X-Api-Key header; the key is throttled by the
+  # usage plan
+  apiGateway:
+    apiKeys:
+      - ${self:service}-${self:provider.stage}-key
+    usagePlan:
+      throttle:
+        rateLimit: 50
+        burstLimit: 100
+      quota:
+        limit: 100000
+        period: DAY
+  {{- end }}
   environment:
     APP_NAME: ${self:service}
     APP_ENV: ${self:provider.stage}
     LOG_LEVEL: ${self:custom.logLevel.${self:provider.stage}}
+    TRACING_EXPORTER: xray
+    CONFIG_SSM_PATH: /${self:service}/${self:provider.stage}
+    CONFIG_APPCONFIG_APPLICATION: !Ref ConfigApplication
+    CONFIG_APPCONFIG_ENVIRONMENT: !Ref ConfigEnvironment
+    CONFIG_APPCONFIG_PROFILE: !Ref FeatureFlagsProfile
     {{- if .HasFeature "dynamodb" }}
-    DYNAMODB_TABLE_NAME: ${self:service}-${self:provider.stage}-users
+    DYNAMODB_TABLE_NAME: ${self:service}-${self:provider.stage}-{{.DataModel.Table.Name}}
+    {{- if eq .Architecture "ddd" }}
+    EVENT_STORE_TABLE_NAME: ${self:service}-${self:provider.stage}-events
+    {{- else if eq .Architecture "clean" }}
+    IDEMPOTENCY_TABLE_NAME: ${self:service}-${self:provider.stage}-idempotency
+    {{- if .HasFeature "api" }}
+    RATE_LIMIT_TABLE_NAME: ${self:service}-${self:provider.stage}-rate-limits
+    {{- end }}
+    {{- end }}
     {{- end }}
     {{- if .HasFeature "sqs" }}
     SQS_QUEUE_URL: !Ref MessageQueue
     {{- end }}
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+    RESPONSE_BUCKET_NAME: !Ref ResponseBucket
+    {{- end }}
   iam:
     role:
       statements:
+        - Effect: Allow
+          Action:
+            - ssm:GetParametersByPath
+          Resource:
+            - !Sub "arn:aws:ssm:${AWS::Region}:${AWS::AccountId}:parameter/${self:service}/${self:provider.stage}"
+            - !Sub "arn:aws:ssm:${AWS::Region}:${AWS::AccountId}:parameter/${self:service}/${self:provider.stage}/*"
+        - Effect: Allow
+          Action:
+            - appconfig:StartConfigurationSession
+            - appconfig:GetLatestConfiguration
+          Resource:
+            - !Sub "arn:aws:appconfig:${AWS::Region}:${AWS::AccountId}:application/${ConfigApplication}/environment/${ConfigEnvironment}/configuration/${FeatureFlagsProfile}"
         {{- if .HasFeature "dynamodb" }}
         - Effect: Allow
           Action:
@@ -909,6 +1884,29 @@ provider:
           Resource:
             - !GetAtt UserTable.Arn
             - !Sub "${UserTable.Arn}/index/*"
+        {{- if eq .Architecture "ddd" }}
+        - Effect: Allow
+          Action:
+            - dynamodb:Query
+            - dynamodb:GetItem
+            - dynamodb:PutItem
+            - dynamodb:ConditionCheckItem
+          Resource:
+            - !GetAtt EventStoreTable.Arn
+            - !Sub "${EventStoreTable.Arn}/index/*"
+        {{- else if eq .Architecture "clean" }}
+        - Effect: Allow
+          Action:
+            - dynamodb:GetItem
+            - dynamodb:PutItem
+            - dynamodb:UpdateItem
+            - dynamodb:DeleteItem
+          Resource:
+            - !GetAtt IdempotencyTable.Arn
+            {{- if .HasFeature "api" }}
+            - !GetAtt RateLimitTable.Arn
+            {{- end }}
+        {{- end }}
         {{- end }}
         {{- if .HasFeature "sqs" }}
         - Effect: Allow
@@ -920,6 +1918,21 @@ provider:
           Resource:
             - !GetAtt MessageQueue.Arn
         {{- end }}
+        {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+        - Effect: Allow
+          Action:
+            - s3:PutObject
+            - s3:GetObject
+          Resource:
+            - !Sub "${ResponseBucket.Arn}/responses/*"
+        {{- end }}
+        {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") (.HasFeature "eventbridge") }}
+        - Effect: Allow
+          Action:
+            - events:PutEvents
+          Resource:
+            - !Sub "arn:aws:events:${AWS::Region}:${AWS::AccountId}:event-bus/default"
+        {{- end }}
         {{- if .HasFeature "s3" }}
         - Effect: Allow
           Action:
@@ -948,6 +1961,10 @@ custom:
       origins:
         - https://{{.Name}}.com
         - https://www.{{.Name}}.com
+  {{- if and (eq .APIType "alb") (eq .Architecture "clean") }}
+  # Listener of an existing load balancer the function is attached to
+  albListenerArn: ${env:ALB_LISTENER_ARN}
+  {{- end }}
   {{- end }}
 
 functions:
@@ -956,28 +1973,67 @@ functions:
     handler: bootstrap
     package:
       artifact: build/user.zip
-    {{- if .HasFeature "api" }}
+    {{- if and (.HasFeature "api") (eq .APIType "rest") }}
     events:
+      # BEGIN API routes (create-lambda-app generate api)
       - http:
           path: users
           method: POST
           cors: ${self:custom.cors.${self:provider.stage}}
+          private: true
       - http:
           path: users
           method: GET
           cors: ${self:custom.cors.${self:provider.stage}}
+          private: true
       - http:
           path: users/{id}
           method: GET
           cors: ${self:custom.cors.${self:provider.stage}}
+          private: true
       - http:
           path: users/{id}
           method: PUT
           cors: ${self:custom.cors.${self:provider.stage}}
+          private: true
       - http:
           path: users/{id}
           method: DELETE
           cors: ${self:custom.cors.${self:provider.stage}}
+          private: true
+      # END API routes
+    {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+    events:
+      # BEGIN API routes (create-lambda-app generate api)
+      - httpApi:
+          path: /users
+          method: POST
+      - httpApi:
+          path: /users
+          method: GET
+      - httpApi:
+          path: /users/{id}
+          method: GET
+      - httpApi:
+          path: /users/{id}
+          method: PUT
+      - httpApi:
+          path: /users/{id}
+          method: DELETE
+      # END API routes
+    {{- else if and (.HasFeature "api") (eq .APIType "function-url") }}
+    url:
+      cors:
+        allowedOrigins: ${self:custom.cors.${self:provider.stage}.origins}
+    {{- else if and (.HasFeature "api") (eq .APIType "alb") }}
+    events:
+      - alb:
+          listenerArn: ${self:custom.albListenerArn}
+          priority: 1
+          conditions:
+            path:
+              - /users
+              - /users/*
     {{- end }}
   {{- end }}
 
@@ -990,6 +2046,36 @@ functions:
       - sqs:
           arn: !GetAtt MessageQueue.Arn
           batchSize: 10
+          functionResponseType: ReportBatchItemFailures
+  {{- end }}
+
+  {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+  outboxRelay:
+    handler: bootstrap
+    package:
+      artifact: build/outbox-relay.zip
+    {{- if or (.HasFeature "sqs") (.HasFeature "eventbridge") }}
+    environment:
+      {{- if .HasFeature "sqs" }}
+      OUTBOX_QUEUE_URL: !Ref MessageQueue
+      {{- end }}
+      {{- if .HasFeature "eventbridge" }}
+      OUTBOX_EVENT_BUS_NAME: default
+      {{- end }}
+    {{- end }}
+    events:
+      - stream:
+          type: dynamodb
+          arn: !GetAtt UserTable.StreamArn
+          startingPosition: TRIM_HORIZON
+          batchSize: 100
+          functionResponseType: ReportBatchItemFailures
+          filterPatterns:
+            - eventName: [INSERT]
+              dynamodb:
+                NewImage:
+                  type:
+                    S: [OutboxMessage]
   {{- end }}
 
 resources:
@@ -998,23 +2084,77 @@ resources:
     UserTable:
       Type: AWS::DynamoDB::Table
       Properties:
-        TableName: ${self:service}-${self:provider.stage}-users
+        # Generated from access-patterns.yaml
+        TableName: ${self:service}-${self:provider.stage}-{{.DataModel.Table.Name}}
         BillingMode: PAY_PER_REQUEST
         StreamSpecification:
           StreamViewType: NEW_AND_OLD_IMAGES
         AttributeDefinitions:
-          - AttributeName: id
+          {{- range .DataModel.KeyAttributes }}
+          - AttributeName: {{ . }}
+            AttributeType: S
+          {{- end }}
+        KeySchema:
+          - AttributeName: {{ .DataModel.Table.PartitionKey }}
+            KeyType: HASH
+          {{- if .DataModel.Table.SortKey }}
+          - AttributeName: {{ .DataModel.Table.SortKey }}
+            KeyType: RANGE
+          {{- end }}
+        {{- if .DataModel.Table.Indexes }}
+        GlobalSecondaryIndexes:
+          {{- range .DataModel.Table.Indexes }}
+          - IndexName: {{ .Name }}
+            KeySchema:
+              - AttributeName: {{ .PartitionKey }}
+                KeyType: HASH
+              {{- if .SortKey }}
+              - AttributeName: {{ .SortKey }}
+                KeyType: RANGE
+              {{- end }}
+            Projection:
+              ProjectionType: ALL
+          {{- end }}
+        {{- end }}
+        {{- if eq .Architecture "ddd" }}
+        # Deletes outbox items once their expires_at passes
+        TimeToLiveSpecification:
+          AttributeName: expires_at
+          Enabled: true
+        {{- end }}
+        PointInTimeRecoverySpecification:
+          PointInTimeRecoveryEnabled: true
+        SSESpecification:
+          SSEEnabled: true
+    {{- end }}
+
+    {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+    EventStoreTable:
+      Type: AWS::DynamoDB::Table
+      Properties:
+        TableName: ${self:service}-${self:provider.stage}-events
+        BillingMode: PAY_PER_REQUEST
+        AttributeDefinitions:
+          - AttributeName: stream_id
+            AttributeType: S
+          - AttributeName: version
+            AttributeType: N
+          - AttributeName: day
             AttributeType: S
-          - AttributeName: email
+          - AttributeName: occurred_at
             AttributeType: S
         KeySchema:
-          - AttributeName: id
+          - AttributeName: stream_id
             KeyType: HASH
+          - AttributeName: version
+            KeyType: RANGE
         GlobalSecondaryIndexes:
-          - IndexName: email-index
+          - IndexName: events-by-day
             KeySchema:
-              - AttributeName: email
+              - AttributeName: day
                 KeyType: HASH
+              - AttributeName: occurred_at
+                KeyType: RANGE
             Projection:
               ProjectionType: ALL
         PointInTimeRecoverySpecification:
@@ -1023,6 +2163,46 @@ resources:
           SSEEnabled: true
     {{- end }}
 
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    IdempotencyTable:
+      Type: AWS::DynamoDB::Table
+      Properties:
+        TableName: ${self:service}-${self:provider.stage}-idempotency
+        BillingMode: PAY_PER_REQUEST
+        AttributeDefinitions:
+          - AttributeName: id
+            AttributeType: S
+        KeySchema:
+          - AttributeName: id
+            KeyType: HASH
+        TimeToLiveSpecification:
+          AttributeName: expires_at
+          Enabled: true
+        SSESpecification:
+          SSEEnabled: true
+    {{- if .HasFeature "api" }}
+
+    # Rate limit buckets shared by every container; DynamoDB's TTL deletes
+    # a bucket once it has refilled
+    RateLimitTable:
+      Type: AWS::DynamoDB::Table
+      Properties:
+        TableName: ${self:service}-${self:provider.stage}-rate-limits
+        BillingMode: PAY_PER_REQUEST
+        AttributeDefinitions:
+          - AttributeName: id
+            AttributeType: S
+        KeySchema:
+          - AttributeName: id
+            KeyType: HASH
+        TimeToLiveSpecification:
+          AttributeName: expires_at
+          Enabled: true
+        SSESpecification:
+          SSEEnabled: true
+    {{- end }}
+    {{- end }}
+
     {{- if .HasFeature "sqs" }}
     MessageQueue:
       Type: AWS::SQS::Queue
@@ -1060,6 +2240,29 @@ resources:
           RestrictPublicBuckets: true
     {{- end }}
 
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+    # Responses too large for Lambda to return, fetched by clients through
+    # presigned URLs
+    ResponseBucket:
+      Type: AWS::S3::Bucket
+      Properties:
+        BucketEncryption:
+          ServerSideEncryptionConfiguration:
+            - ServerSideEncryptionByDefault:
+                SSEAlgorithm: AES256
+        LifecycleConfiguration:
+          Rules:
+            - Id: DeleteOffloadedResponses
+              Prefix: responses/
+              ExpirationInDays: 1
+              Status: Enabled
+        PublicAccessBlockConfiguration:
+          BlockPublicAcls: true
+          BlockPublicPolicy: true
+          IgnorePublicAcls: true
+          RestrictPublicBuckets: true
+    {{- end }}
+
     {{- if .HasFeature "cognito" }}
     CognitoUserPool:
       Type: AWS::Cognito::UserPool
@@ -1087,15 +2290,78 @@ resources:
           - ALLOW_REFRESH_TOKEN_AUTH
     {{- end }}
 
+    # Runtime configuration read by pkg/configsource: parameters under
+    # /{{.Name}}/<stage> in SSM Parameter Store, and feature flags in
+    # AppConfig
+    ConfigApplication:
+      Type: AWS::AppConfig::Application
+      Properties:
+        Name: ${self:service}-${self:provider.stage}
+
+    ConfigEnvironment:
+      Type: AWS::AppConfig::Environment
+      Properties:
+        ApplicationId: !Ref ConfigApplication
+        Name: ${self:provider.stage}
+
+    FeatureFlagsProfile:
+      Type: AWS::AppConfig::ConfigurationProfile
+      Properties:
+        ApplicationId: !Ref ConfigApplication
+        Name: feature-flags
+        LocationUri: hosted
+        Type: AWS.AppConfig.FeatureFlags
+
+    # Initial flags; change them in the AppConfig console, warm functions
+    # pick them up within CONFIG_REFRESH_INTERVAL
+    FeatureFlagsVersion:
+      Type: AWS::AppConfig::HostedConfigurationVersion
+      Properties:
+        ApplicationId: !Ref ConfigApplication
+        ConfigurationProfileId: !Ref FeatureFlagsProfile
+        ContentType: application/json
+        Content: '{"version": "1", "flags": {"debugLogging": {"name": "Debug logging"}}, "values": {"debugLogging": {"enabled": false}}}'
+
+    FeatureFlagsDeployment:
+      Type: AWS::AppConfig::Deployment
+      Properties:
+        ApplicationId: !Ref ConfigApplication
+        EnvironmentId: !Ref ConfigEnvironment
+        ConfigurationProfileId: !Ref FeatureFlagsProfile
+        ConfigurationVersion: !Ref FeatureFlagsVersion
+        DeploymentStrategyId: AppConfig.AllAtOnce
+
   Outputs:
-    {{- if .HasFeature "api" }}
+    {{- if and (.HasFeature "api") (eq .APIType "rest") }}
     ApiUrl:
       Value: !Sub https://${ApiGatewayRestApi}.execute-api.${AWS::Region}.amazonaws.com/${self:provider.stage}
+    {{- else if and (.HasFeature "api") (eq .APIType "http") }}
+    ApiUrl:
+      Value: !Sub https://${HttpApi}.execute-api.${AWS::Region}.amazonaws.com
+    {{- else if and (.HasFeature "api") (eq .APIType "function-url") (eq .Architecture "clean") }}
+    ApiUrl:
+      Value: !GetAtt UserHandlerLambdaFunctionUrl.FunctionUrl
     {{- end }}
     {{- if .HasFeature "dynamodb" }}
     UserTableName:
       Value: !Ref UserTable
     {{- end }}
+    {{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+    EventStoreTableName:
+      Value: !Ref EventStoreTable
+    {{- end }}
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    IdempotencyTableName:
+      Value: !Ref IdempotencyTable
+    {{- if .HasFeature "api" }}
+    RateLimitTableName:
+      Value: !Ref RateLimitTable
+    {{- end }}
+    {{- end }}
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+    ResponseBucketName:
+      Value: !Ref ResponseBucket
+    {{- end }}
     {{- if .HasFeature "sqs" }}
     MessageQueueUrl:
       Value: !Ref MessageQueue
@@ -1106,6 +2372,20 @@ resources:
     UserPoolClientId:
       Value: !Ref CognitoUserPoolClient
     {{- end }}
+    ConfigParameterPath:
+      Value: /${self:service}/${self:provider.stage}
+    ConfigApplicationId:
+      Value: !Ref ConfigApplication
+  {{- if and (.HasFeature "api") (eq .APIType "http") }}
+
+  extensions:
+    # HTTP APIs have no usage plans; the stage throttles every route
+    HttpApiStage:
+      Properties:
+        DefaultRouteSettings:
+          ThrottlingRateLimit: 50
+          ThrottlingBurstLimit: 100
+  {{- end }}
 
 plugins:
   - serverless-offline
@@ -1159,9 +2439,13 @@ provider "aws" {
 
 locals {
   app_prefix = "${var.app_name}-${var.environment}"
+
+  # ADOT collector extension: receives the functions' OTLP spans on
+  # localhost:4318 and forwards them to X-Ray
+  adot_collector_layer_arn = "arn:aws:lambda:${var.aws_region}:901920570463:layer:aws-otel-collector-amd64-ver-0-90-1:1"
 }
 
-{{- if .HasFeature "api" }}
+{{- if and (.HasFeature "api") (eq .APIType "rest") }}
 # API Gateway
 resource "aws_api_gateway_rest_api" "api" {
   name        = "${local.app_prefix}-api"
@@ -1213,34 +2497,273 @@ resource "aws_cloudwatch_log_group" "api_gateway" {
   name              = "/aws/apigateway/${local.app_prefix}"
   retention_in_days = var.log_retention_days
 }
+
+# API clients send the key in the X-Api-Key header; methods must set
+# api_key_required = true to be metered by the usage plan
+resource "aws_api_gateway_api_key" "api" {
+  name = "${local.app_prefix}-key"
+}
+
+resource "aws_api_gateway_usage_plan" "api" {
+  name = "${local.app_prefix}-usage-plan"
+  
+  api_stages {
+    api_id = aws_api_gateway_rest_api.api.id
+    stage  = aws_api_gateway_stage.api.stage_name
+  }
+  
+  throttle_settings {
+    rate_limit  = var.api_throttle_rate_limit
+    burst_limit = var.api_throttle_burst_limit
+  }
+  
+  quota_settings {
+    limit  = var.api_quota_limit
+    period = "DAY"
+  }
+}
+
+resource "aws_api_gateway_usage_plan_key" "api" {
+  key_id        = aws_api_gateway_api_key.api.id
+  key_type      = "API_KEY"
+  usage_plan_id = aws_api_gateway_usage_plan.api.id
+}
+{{- else if and (.HasFeature "api") (eq .APIType "http") }}
+# API Gateway HTTP API
+resource "aws_apigatewayv2_api" "api" {
+  name          = "${local.app_prefix}-api"
+  description   = "HTTP API for ${var.app_name}"
+  protocol_type = "HTTP"
+  
+  cors_configuration {
+    allow_origins = var.cors_origins
+    allow_methods = ["*"]
+    allow_headers = ["content-type", "authorization", "x-request-id"]
+  }
+}
+
+resource "aws_apigatewayv2_integration" "user" {
+  api_id                 = aws_apigatewayv2_api.api.id
+  integration_type       = "AWS_PROXY"
+  integration_uri        = module.user_function.invoke_arn
+  payload_format_version = "2.0"
+}
+
+resource "aws_apigatewayv2_route" "user" {
+  for_each = toset([
+    # BEGIN API routes (create-lambda-app generate api)
+    "POST /users",
+    "GET /users",
+    "GET /users/{id}",
+    "PUT /users/{id}",
+    "DELETE /users/{id}",
+    # END API routes
+  ])
+  
+  api_id    = aws_apigatewayv2_api.api.id
+  route_key = each.value
+  target    = "integrations/${aws_apigatewayv2_integration.user.id}"
+}
+
+resource "aws_apigatewayv2_stage" "api" {
+  api_id      = aws_apigatewayv2_api.api.id
+  name        = var.environment
+  auto_deploy = true
+  
+  # HTTP APIs have no usage plans; the stage throttles every route
+  default_route_settings {
+    throttling_rate_limit  = var.api_throttle_rate_limit
+    throttling_burst_limit = var.api_throttle_burst_limit
+  }
+  
+  access_log_settings {
+    destination_arn = aws_cloudwatch_log_group.api_gateway.arn
+    format = jsonencode({
+      requestId      = "$context.requestId"
+      ip             = "$context.identity.sourceIp"
+      requestTime    = "$context.requestTime"
+      httpMethod     = "$context.httpMethod"
+      routeKey       = "$context.routeKey"
+      status         = "$context.status"
+      protocol       = "$context.protocol"
+      responseLength = "$context.responseLength"
+    })
+  }
+}
+
+resource "aws_lambda_permission" "api" {
+  statement_id  = "AllowHTTPAPIInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = module.user_function.function_name
+  principal     = "apigateway.amazonaws.com"
+  source_arn    = "${aws_apigatewayv2_api.api.execution_arn}/*/*"
+}
+
+resource "aws_cloudwatch_log_group" "api_gateway" {
+  name              = "/aws/apigateway/${local.app_prefix}"
+  retention_in_days = var.log_retention_days
+}
+{{- else if and (.HasFeature "api") (eq .APIType "function-url") }}
+# Function URL
+resource "aws_lambda_function_url" "user" {
+  function_name      = module.user_function.function_name
+  authorization_type = "NONE"
+  
+  cors {
+    allow_origins = var.cors_origins
+    allow_methods = ["*"]
+    allow_headers = ["content-type", "authorization", "x-request-id"]
+  }
+}
+{{- else if and (.HasFeature "api") (eq .APIType "alb") }}
+# Application Load Balancer
+resource "aws_security_group" "alb" {
+  name        = "${local.app_prefix}-alb"
+  description = "Load balancer for ${var.app_name}"
+  vpc_id      = var.vpc_id
+  
+  ingress {
+    from_port   = 80
+    to_port     = 80
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+  
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_lb" "api" {
+  name               = "${local.app_prefix}-alb"
+  load_balancer_type = "application"
+  security_groups    = [aws_security_group.alb.id]
+  subnets            = var.subnet_ids
+}
+
+resource "aws_lb_target_group" "user" {
+  name        = "${local.app_prefix}-user"
+  target_type = "lambda"
+}
+
+resource "aws_lambda_permission" "alb" {
+  statement_id  = "AllowALBInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = module.user_function.function_name
+  principal     = "elasticloadbalancing.amazonaws.com"
+  source_arn    = aws_lb_target_group.user.arn
+}
+
+resource "aws_lb_target_group_attachment" "user" {
+  target_group_arn = aws_lb_target_group.user.arn
+  target_id        = module.user_function.function_arn
+  depends_on       = [aws_lambda_permission.alb]
+}
+
+resource "aws_lb_listener" "http" {
+  load_balancer_arn = aws_lb.api.arn
+  port              = 80
+  protocol          = "HTTP"
+  
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.user.arn
+  }
+}
 {{- end }}
 
 {{- if .HasFeature "dynamodb" }}
 # DynamoDB Table
+# Generated from access-patterns.yaml
 resource "aws_dynamodb_table" "users" {
-  name         = "${local.app_prefix}-users"
+  name         = "${local.app_prefix}-{{.DataModel.Table.Name}}"
   billing_mode = "PAY_PER_REQUEST"
-  hash_key     = "id"
+  hash_key     = "{{.DataModel.Table.PartitionKey}}"
+  {{- if .DataModel.Table.SortKey }}
+  range_key    = "{{.DataModel.Table.SortKey}}"
+  {{- end }}
+  {{- range .DataModel.KeyAttributes }}
   
   attribute {
-    name = "id"
+    name = "{{ . }}"
+    type = "S"
+  }
+  {{- end }}
+  {{- range .DataModel.Table.Indexes }}
+  
+  global_secondary_index {
+    name            = "{{ .Name }}"
+    hash_key        = "{{ .PartitionKey }}"
+    {{- if .SortKey }}
+    range_key       = "{{ .SortKey }}"
+    {{- end }}
+    projection_type = "ALL"
+  }
+  {{- end }}
+  
+  stream_enabled   = true
+  stream_view_type = "NEW_AND_OLD_IMAGES"
+  {{- if eq .Architecture "ddd" }}
+  
+  # Deletes outbox items once their expires_at passes
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+  {{- end }}
+  
+  point_in_time_recovery {
+    enabled = true
+  }
+  
+  server_side_encryption {
+    enabled = true
+  }
+  
+  lifecycle {
+    prevent_destroy = true
+  }
+}
+{{- end }}
+
+{{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+# Event store: one stream per aggregate, with an index for GetEventsSince
+resource "aws_dynamodb_table" "events" {
+  name         = "${local.app_prefix}-events"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "stream_id"
+  range_key    = "version"
+  
+  attribute {
+    name = "stream_id"
     type = "S"
   }
   
   attribute {
-    name = "email"
+    name = "version"
+    type = "N"
+  }
+  
+  attribute {
+    name = "day"
+    type = "S"
+  }
+  
+  attribute {
+    name = "occurred_at"
     type = "S"
   }
   
   global_secondary_index {
-    name            = "email-index"
-    hash_key        = "email"
+    name            = "events-by-day"
+    hash_key        = "day"
+    range_key       = "occurred_at"
     projection_type = "ALL"
   }
   
-  stream_enabled   = true
-  stream_view_type = "NEW_AND_OLD_IMAGES"
-  
   point_in_time_recovery {
     enabled = true
   }
@@ -1255,6 +2778,54 @@ resource "aws_dynamodb_table" "users" {
 }
 {{- end }}
 
+{{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+
+# Idempotency records; DynamoDB's TTL deletes them once expires_at passes
+resource "aws_dynamodb_table" "idempotency" {
+  name         = "${local.app_prefix}-idempotency"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "id"
+  
+  attribute {
+    name = "id"
+    type = "S"
+  }
+  
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+  
+  server_side_encryption {
+    enabled = true
+  }
+}
+{{- if .HasFeature "api" }}
+
+# Rate limit buckets shared by every container; DynamoDB's TTL deletes a
+# bucket once it has refilled
+resource "aws_dynamodb_table" "rate_limits" {
+  name         = "${local.app_prefix}-rate-limits"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "id"
+  
+  attribute {
+    name = "id"
+    type = "S"
+  }
+  
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+  
+  server_side_encryption {
+    enabled = true
+  }
+}
+{{- end }}
+{{- end }}
+
 {{- if .HasFeature "sqs" }}
 # SQS Queues
 resource "aws_sqs_queue" "dlq" {
@@ -1322,6 +2893,51 @@ resource "aws_s3_bucket_lifecycle_configuration" "storage" {
   }
 }
 {{- end }}
+{{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+
+# Responses too large for Lambda to return, fetched by clients through
+# presigned URLs
+resource "aws_s3_bucket" "responses" {
+  bucket_prefix = "${local.app_prefix}-responses-"
+  force_destroy = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "responses" {
+  bucket = aws_s3_bucket.responses.id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+resource "aws_s3_bucket_public_access_block" "responses" {
+  bucket = aws_s3_bucket.responses.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_lifecycle_configuration" "responses" {
+  bucket = aws_s3_bucket.responses.id
+
+  rule {
+    id     = "delete-offloaded-responses"
+    status = "Enabled"
+
+    filter {
+      prefix = "responses/"
+    }
+
+    expiration {
+      days = 1
+    }
+  }
+}
+{{- end }}
 
 {{- if .HasFeature "cognito" }}
 # Cognito User Pool
@@ -1370,27 +2986,113 @@ resource "aws_cognito_user_pool_client" "client" {
 }
 {{- end }}
 
+# Runtime configuration read by pkg/configsource: parameters under
+# /<app_name>/<environment> in SSM Parameter Store, and feature flags in
+# AppConfig
+resource "aws_appconfig_application" "config" {
+  name = local.app_prefix
+}
+
+resource "aws_appconfig_environment" "config" {
+  name           = var.environment
+  application_id = aws_appconfig_application.config.id
+}
+
+resource "aws_appconfig_configuration_profile" "feature_flags" {
+  application_id = aws_appconfig_application.config.id
+  name           = "feature-flags"
+  location_uri   = "hosted"
+  type           = "AWS.AppConfig.FeatureFlags"
+}
+
+# Initial flags; change them in the AppConfig console, warm functions pick
+# them up within CONFIG_REFRESH_INTERVAL
+resource "aws_appconfig_hosted_configuration_version" "feature_flags" {
+  application_id           = aws_appconfig_application.config.id
+  configuration_profile_id = aws_appconfig_configuration_profile.feature_flags.configuration_profile_id
+  content_type             = "application/json"
+  content = jsonencode({
+    version = "1"
+    flags   = { debugLogging = { name = "Debug logging" } }
+    values  = { debugLogging = { enabled = false } }
+  })
+}
+
+resource "aws_appconfig_deployment" "feature_flags" {
+  application_id           = aws_appconfig_application.config.id
+  environment_id           = aws_appconfig_environment.config.environment_id
+  configuration_profile_id = aws_appconfig_configuration_profile.feature_flags.configuration_profile_id
+  configuration_version    = aws_appconfig_hosted_configuration_version.feature_flags.version_number
+  deployment_strategy_id   = "AppConfig.AllAtOnce"
+}
+
+locals {
+  config_ssm_path = "/${var.app_name}/${var.environment}"
+
+  # Where every function reads its configuration from, and the permission
+  # to read it
+  config_environment_variables = {
+    CONFIG_SSM_PATH              = local.config_ssm_path
+    CONFIG_APPCONFIG_APPLICATION = aws_appconfig_application.config.id
+    CONFIG_APPCONFIG_ENVIRONMENT = aws_appconfig_environment.config.environment_id
+    CONFIG_APPCONFIG_PROFILE     = aws_appconfig_configuration_profile.feature_flags.configuration_profile_id
+  }
+  config_policy_statements = {
+    config_parameters = {
+      effect  = "Allow"
+      actions = ["ssm:GetParametersByPath"]
+      resources = [
+        "arn:aws:ssm:${var.aws_region}:${data.aws_caller_identity.current.account_id}:parameter${local.config_ssm_path}",
+        "arn:aws:ssm:${var.aws_region}:${data.aws_caller_identity.current.account_id}:parameter${local.config_ssm_path}/*"
+      ]
+    }
+    config_feature_flags = {
+      effect = "Allow"
+      actions = [
+        "appconfig:StartConfigurationSession",
+        "appconfig:GetLatestConfiguration"
+      ]
+      resources = ["arn:aws:appconfig:${var.aws_region}:${data.aws_caller_identity.current.account_id}:application/${aws_appconfig_application.config.id}/environment/${aws_appconfig_environment.config.environment_id}/configuration/${aws_appconfig_configuration_profile.feature_flags.configuration_profile_id}"]
+    }
+  }
+}
+
 # Lambda Functions
 module "user_function" {
   source = "./modules/lambda"
   
+  layers = [local.adot_collector_layer_arn]
+  
   function_name = "${local.app_prefix}-user-handler"
   handler       = "bootstrap"
   runtime       = "provided.al2023"
   filename      = "../build/user.zip"
   
-  environment_variables = {
+  environment_variables = merge(local.config_environment_variables, {
     APP_NAME     = var.app_name
     APP_ENV      = var.environment
     LOG_LEVEL    = var.log_level
+    TRACING_EXPORTER = "xray"
     {{- if .HasFeature "dynamodb" }}
     DYNAMODB_TABLE_NAME = aws_dynamodb_table.users.name
+    {{- if eq .Architecture "ddd" }}
+    EVENT_STORE_TABLE_NAME = aws_dynamodb_table.events.name
+    {{- else if eq .Architecture "clean" }}
+    IDEMPOTENCY_TABLE_NAME = aws_dynamodb_table.idempotency.name
+    {{- if .HasFeature "api" }}
+    RATE_LIMIT_TABLE_NAME  = aws_dynamodb_table.rate_limits.name
     {{- end }}
-  }
+    {{- end }}
+    {{- end }}
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+    RESPONSE_BUCKET_NAME = aws_s3_bucket.responses.id
+    {{- end }}
+  })
   
-  {{- if .HasFeature "dynamodb" }}
   attach_policy_statements = true
-  policy_statements = {
+  {{- if or (.HasFeature "dynamodb") (and (eq .Architecture "clean") (.HasFeature "api")) }}
+  policy_statements = merge(local.config_policy_statements, {
+    {{- if .HasFeature "dynamodb" }}
     dynamodb = {
       effect = "Allow"
       actions = [
@@ -1406,7 +3108,56 @@ module "user_function" {
         "${aws_dynamodb_table.users.arn}/index/*"
       ]
     }
-  }
+    {{- if eq .Architecture "ddd" }}
+    event_store = {
+      effect = "Allow"
+      actions = [
+        "dynamodb:GetItem",
+        "dynamodb:PutItem",
+        "dynamodb:Query",
+        "dynamodb:ConditionCheckItem"
+      ]
+      resources = [
+        aws_dynamodb_table.events.arn,
+        "${aws_dynamodb_table.events.arn}/index/*"
+      ]
+    }
+    {{- else if eq .Architecture "clean" }}
+    idempotency = {
+      effect = "Allow"
+      actions = [
+        "dynamodb:GetItem",
+        "dynamodb:PutItem",
+        "dynamodb:UpdateItem",
+        "dynamodb:DeleteItem"
+      ]
+      resources = [aws_dynamodb_table.idempotency.arn]
+    }
+    {{- if .HasFeature "api" }}
+    rate_limits = {
+      effect = "Allow"
+      actions = [
+        "dynamodb:GetItem",
+        "dynamodb:PutItem"
+      ]
+      resources = [aws_dynamodb_table.rate_limits.arn]
+    }
+    {{- end }}
+    {{- end }}
+    {{- end }}
+    {{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+    responses = {
+      effect = "Allow"
+      actions = [
+        "s3:PutObject",
+        "s3:GetObject"
+      ]
+      resources = ["${aws_s3_bucket.responses.arn}/responses/*"]
+    }
+    {{- end }}
+  })
+  {{- else }}
+  policy_statements        = local.config_policy_statements
   {{- end }}
 }
 
@@ -1414,21 +3165,27 @@ module "user_function" {
 module "message_processor_function" {
   source = "./modules/lambda"
   
+  layers = [local.adot_collector_layer_arn]
+  
   function_name = "${local.app_prefix}-message-processor"
   handler       = "bootstrap"
   runtime       = "provided.al2023"
   filename      = "../build/message-processor.zip"
   timeout       = 180
   
-  environment_variables = {
+  environment_variables = merge(local.config_environment_variables, {
     APP_NAME      = var.app_name
     APP_ENV       = var.environment
     LOG_LEVEL     = var.log_level
+    TRACING_EXPORTER = "xray"
     SQS_QUEUE_URL = aws_sqs_queue.messages.url
-  }
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    IDEMPOTENCY_TABLE_NAME = aws_dynamodb_table.idempotency.name
+    {{- end }}
+  })
   
   attach_policy_statements = true
-  policy_statements = {
+  policy_statements = merge(local.config_policy_statements, {
     sqs = {
       effect = "Allow"
       actions = [
@@ -1438,7 +3195,19 @@ module "message_processor_function" {
       ]
       resources = [aws_sqs_queue.messages.arn]
     }
-  }
+    {{- if and (eq .Architecture "clean") (.HasFeature "dynamodb") }}
+    idempotency = {
+      effect = "Allow"
+      actions = [
+        "dynamodb:GetItem",
+        "dynamodb:PutItem",
+        "dynamodb:UpdateItem",
+        "dynamodb:DeleteItem"
+      ]
+      resources = [aws_dynamodb_table.idempotency.arn]
+    }
+    {{- end }}
+  })
 }
 
 # SQS trigger for Lambda
@@ -1446,6 +3215,81 @@ resource "aws_lambda_event_source_mapping" "sqs" {
   event_source_arn = aws_sqs_queue.messages.arn
   function_name    = module.message_processor_function.function_name
   batch_size       = 10
+
+  function_response_types = ["ReportBatchItemFailures"]
+}
+{{- end }}
+
+{{- if and (eq .Architecture "ddd") (.HasFeature "dynamodb") }}
+# Publishes the domain events the repository writes to the outbox
+module "outbox_relay_function" {
+  source = "./modules/lambda"
+  
+  layers = [local.adot_collector_layer_arn]
+  
+  function_name = "${local.app_prefix}-outbox-relay"
+  handler       = "bootstrap"
+  runtime       = "provided.al2023"
+  filename      = "../build/outbox-relay.zip"
+  
+  environment_variables = merge(local.config_environment_variables, {
+    APP_NAME  = var.app_name
+    APP_ENV   = var.environment
+    LOG_LEVEL = var.log_level
+    TRACING_EXPORTER = "xray"
+    {{- if .HasFeature "sqs" }}
+    OUTBOX_QUEUE_URL = aws_sqs_queue.messages.url
+    {{- end }}
+    {{- if .HasFeature "eventbridge" }}
+    OUTBOX_EVENT_BUS_NAME = "default"
+    {{- end }}
+  })
+  
+  attach_policy_statements = true
+  policy_statements = merge(local.config_policy_statements, {
+    stream = {
+      effect = "Allow"
+      actions = [
+        "dynamodb:DescribeStream",
+        "dynamodb:GetRecords",
+        "dynamodb:GetShardIterator",
+        "dynamodb:ListStreams"
+      ]
+      resources = [aws_dynamodb_table.users.stream_arn]
+    }
+    {{- if .HasFeature "sqs" }}
+    sqs = {
+      effect    = "Allow"
+      actions   = ["sqs:SendMessage"]
+      resources = [aws_sqs_queue.messages.arn]
+    }
+    {{- end }}
+    {{- if .HasFeature "eventbridge" }}
+    events = {
+      effect    = "Allow"
+      actions   = ["events:PutEvents"]
+      resources = ["arn:aws:events:*:${data.aws_caller_identity.current.account_id}:event-bus/default"]
+    }
+    {{- end }}
+  })
+}
+
+# DynamoDB stream trigger for the outbox relay
+resource "aws_lambda_event_source_mapping" "outbox" {
+  event_source_arn        = aws_dynamodb_table.users.stream_arn
+  function_name           = module.outbox_relay_function.function_name
+  starting_position       = "TRIM_HORIZON"
+  batch_size              = 100
+  function_response_types = ["ReportBatchItemFailures"]
+  
+  filter_criteria {
+    filter {
+      pattern = jsonencode({
+        eventName = ["INSERT"]
+        dynamodb  = { NewImage = { type = { S = ["OutboxMessage"] } } }
+      })
+    }
+  }
 }
 {{- end }}
 
@@ -1496,14 +3340,67 @@ variable "cors_origins" {
   type        = list(string)
   default     = ["*"]
 }
+{{- if or (eq .APIType "rest") (eq .APIType "http") }}
+
+variable "api_throttle_rate_limit" {
+  description = "Requests per second API Gateway lets through{{ if eq .APIType "rest" }} per API key{{ end }}"
+  type        = number
+  default     = 50
+}
+
+variable "api_throttle_burst_limit" {
+  description = "Requests API Gateway lets through at once{{ if eq .APIType "rest" }} per API key{{ end }}"
+  type        = number
+  default     = 100
+}
+{{- end }}
+{{- if eq .APIType "rest" }}
+
+variable "api_quota_limit" {
+  description = "Requests per API key per day"
+  type        = number
+  default     = 100000
+}
+{{- end }}
+{{- if eq .APIType "alb" }}
+
+variable "vpc_id" {
+  description = "VPC of the load balancer"
+  type        = string
+}
+
+variable "subnet_ids" {
+  description = "Public subnets of the load balancer, in at least two availability zones"
+  type        = list(string)
+}
+{{- end }}
 {{- end }}
 `
 
 const TerraformOutputs = `{{- if .HasFeature "api" }}
 output "api_url" {
+{{- if eq .APIType "http" }}
+  description = "HTTP API URL"
+  value       = "${aws_apigatewayv2_stage.api.invoke_url}/"
+{{- else if eq .APIType "function-url" }}
+  description = "User function URL"
+  value       = aws_lambda_function_url.user.function_url
+{{- else if eq .APIType "alb" }}
+  description = "Load balancer URL"
+  value       = "http://${aws_lb.api.dns_name}/"
+{{- else }}
   description = "API Gateway URL"
   value       = "${aws_api_gateway_stage.api.invoke_url}/"
+{{- end }}
 }
+{{- if eq .APIType "rest" }}
+
+output "api_key" {
+  description = "API key to send in the X-Api-Key header"
+  value       = aws_api_gateway_api_key.api.value
+  sensitive   = true
+}
+{{- end }}
 {{- end }}
 
 {{- if .HasFeature "dynamodb" }}
@@ -1516,6 +3413,34 @@ output "user_table_arn" {
   description = "DynamoDB table ARN"
   value       = aws_dynamodb_table.users.arn
 }
+{{- if eq .Architecture "ddd" }}
+
+output "event_store_table_name" {
+  description = "DynamoDB table name for the event store"
+  value       = aws_dynamodb_table.events.name
+}
+{{- else if eq .Architecture "clean" }}
+
+output "idempotency_table_name" {
+  description = "DynamoDB table name for idempotency records"
+  value       = aws_dynamodb_table.idempotency.name
+}
+{{- if .HasFeature "api" }}
+
+output "rate_limit_table_name" {
+  description = "DynamoDB table name for rate limit buckets"
+  value       = aws_dynamodb_table.rate_limits.name
+}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- if and (eq .Architecture "clean") (.HasFeature "api") }}
+
+output "response_bucket_name" {
+  description = "S3 bucket name for offloaded responses"
+  value       = aws_s3_bucket.responses.id
+}
 {{- end }}
 
 {{- if .HasFeature "sqs" }}
@@ -1558,6 +3483,16 @@ output "lambda_function_names" {
     {{- end }}
   }
 }
+
+output "config_parameter_path" {
+  description = "SSM Parameter Store path the functions read their configuration from"
+  value       = local.config_ssm_path
+}
+
+output "config_application_id" {
+  description = "AppConfig application holding the feature flags"
+  value       = aws_appconfig_application.config.id
+}
 `
 
 const TerraformVersions = `terraform {
@@ -1624,6 +3559,12 @@ variable "environment_variables" {
   default     = {}
 }
 
+variable "layers" {
+  description = "Lambda layer ARNs"
+  type        = list(string)
+  default     = []
+}
+
 variable "attach_policy_statements" {
   description = "Whether to attach policy statements"
   type        = bool
@@ -1644,6 +3585,7 @@ resource "aws_lambda_function" "this" {
   memory_size   = var.memory_size
   timeout       = var.timeout
   filename      = var.filename
+  layers        = var.layers
   
   environment {
     variables = var.environment_variables
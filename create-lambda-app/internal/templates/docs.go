@@ -112,7 +112,7 @@ The application follows DDD principles with clear bounded contexts and aggregate
 
 {{- if .HasFeature "api" }}
 #### API Functions
-- Handle HTTP requests via API Gateway
+- Handle HTTP requests via {{ if eq .APIType "http" }}an API Gateway HTTP API{{ else if eq .APIType "function-url" }}a function URL{{ else if eq .APIType "alb" }}an Application Load Balancer{{ else }}API Gateway{{ end }}
 - RESTful endpoints
 - Request/response transformation
 - Authentication and authorization
@@ -141,7 +141,7 @@ Each Lambda function is configured with:
 - Timeout: 30 seconds (API), 180 seconds (async)
 - Environment variables
 - IAM role with least privileges
-- X-Ray tracing enabled
+- X-Ray tracing enabled, with the ADOT collector layer receiving OpenTelemetry spans
 - CloudWatch Logs integration
 
 ## Data Flow
@@ -191,13 +191,14 @@ Event Source → Lambda → Business Logic → Database/Queue → Next Process
 
 ### CloudWatch Metrics
 - Function invocations
-- Error rates
-- Duration metrics
-- Custom business metrics
-
-### X-Ray Tracing
-- End-to-end request tracing
-- Performance bottleneck identification
+- Latency, error, status code and cold start metrics from ` + "`pkg/metrics`" + `, in Embedded Metric Format
+- Processed and failed record counts for SQS and DynamoDB Stream batches
+- Custom business metrics recorded with ` + "`metrics.Add`" + `
+
+### Tracing
+- OpenTelemetry spans from ` + "`pkg/tracing`" + `, exported to X-Ray through the ADOT collector layer
+- Trace context carried through API headers and SQS message attributes, so a request and the messages it causes form one trace
+- A span per AWS SDK call
 - Service map visualization
 
 ### Logging
@@ -218,6 +219,11 @@ Event Source → Lambda → Business Logic → Database/Queue → Next Process
 - Validation errors
 - External service errors
 
+### Error Responses
+- Every API error is an RFC 7807 problem+json document rendered by ` + "`pkg/problem`" + `
+- Handlers, middleware and the request validator map their errors to a problem type; its ` + "`code`" + ` and ` + "`type`" + ` URI are stable
+- Unexpected errors are logged and answered with an ` + "`INTERNAL`" + ` problem that hides the cause
+
 ## Testing Strategy
 
 ### Unit Tests
@@ -320,6 +326,19 @@ The application supports multiple environments:
 - **Development** (dev): For active development and testing
 - **Staging** (staging): Pre-production environment
 - **Production** (prod): Live production environment
+{{- if .HasFeature "api" }}
+
+## API Event Source
+
+The API is served through {{ if eq .APIType "http" }}an API Gateway HTTP API (payload format 2.0){{ else if eq .APIType "function-url" }}a Lambda function URL{{ else if eq .APIType "alb" }}an Application Load Balancer{{ else }}an API Gateway REST API{{ end }}, chosen with ` + "`--api-type`" + ` when the project was generated. Handlers only see it through ` + "`pkg/apievent`" + `, so switching sources means regenerating ` + "`pkg/apievent`" + ` and the deployment configuration.
+{{- if eq .APIType "alb" }}
+{{- if eq .DeploymentTool "sam" }} Pass the load balancer's ` + "`VpcId`" + ` and ` + "`SubnetIds`" + ` parameters on deploy.
+{{- else if eq .DeploymentTool "cdk" }} Pass ` + "`-c vpcId=vpc-...`" + ` to place the load balancer in an existing VPC; without it the stack creates one.
+{{- else if eq .DeploymentTool "serverless" }} The function is attached to an existing load balancer: set ` + "`ALB_LISTENER_ARN`" + ` to its listener before deploying.
+{{- else if eq .DeploymentTool "terraform" }} Set the ` + "`vpc_id`" + ` and ` + "`subnet_ids`" + ` variables for the load balancer.
+{{- end }}
+{{- end }}
+{{- end }}
 
 ## Build Process
 
@@ -723,25 +742,24 @@ All responses follow this structure:
 }
 ` + "```" + `
 
-Error responses:
+Errors are answered with [RFC 7807](https://www.rfc-editor.org/rfc/rfc7807) problem details, with the ` + "`application/problem+json`" + ` content type:
 
 ` + "```json" + `
 {
-  "success": false,
-  "error": {
-    "type": "VALIDATION_ERROR",
-    "message": "Invalid input",
-    "details": {
-      // Error details
-    }
-  },
-  "meta": {
-    "request_id": "550e8400-e29b-41d4-a716-446655440000",
-    "timestamp": "2024-01-15T09:30:00Z"
-  }
+  "type": "https://api.{{.Name}}.com/problems/validation",
+  "title": "Invalid request",
+  "status": 400,
+  "detail": "Request does not match the API specification",
+  "instance": "550e8400-e29b-41d4-a716-446655440000",
+  "code": "VALIDATION",
+  "errors": [
+    {"in": "body", "name": "name", "reason": "minimum string length is 2"}
+  ]
 }
 ` + "```" + `
 
+` + "`type`" + ` and ` + "`code`" + ` identify the kind of problem and do not change, so clients can branch on them; ` + "`detail`" + ` is meant for people. ` + "`instance`" + ` is the request ID, the same as the ` + "`X-Request-ID`" + ` header. Some problems carry extra members, like ` + "`errors`" + ` on validation problems.
+
 ## Endpoints
 
 ### Health Check
@@ -829,11 +847,11 @@ Retrieve a user by ID.
 GET /users
 ` + "```" + `
 
-List all users with pagination.
+List users in creation order, one page at a time.
 
 **Query Parameters:**
-- ` + "`page`" + `: Page number (default: 1)
-- ` + "`page_size`" + `: Items per page (default: 20, max: 100)
+- ` + "`cursor`" + `: ` + "`next_cursor`" + ` from the previous page (omit for the first page)
+- ` + "`limit`" + `: Items per page (default: 20, max: 100)
 - ` + "`status`" + `: Filter by status (active/inactive)
 
 **Response:**
@@ -851,9 +869,8 @@ List all users with pagination.
         "updated_at": "2024-01-15T09:30:00Z"
       }
     ],
-    "total_count": 100,
-    "page": 1,
-    "page_size": 20
+    "next_cursor": "eyJwIjoiTGlzdFVzZXJzIiwiayI6e319",
+    "limit": 20
   }
 }
 ` + "```" + `
@@ -915,26 +932,32 @@ Delete a user (soft delete).
 - ` + "`200 OK`" + `: Successful request
 - ` + "`201 Created`" + `: Resource created successfully
 - ` + "`204 No Content`" + `: Successful request with no content
-- ` + "`400 Bad Request`" + `: Invalid request data
+- ` + "`400 Bad Request`" + `: Invalid request data, or a request that does not match the OpenAPI specification
 - ` + "`401 Unauthorized`" + `: Authentication required
 - ` + "`403 Forbidden`" + `: Insufficient permissions
 - ` + "`404 Not Found`" + `: Resource not found
-- ` + "`409 Conflict`" + `: Resource already exists
-- ` + "`422 Unprocessable Entity`" + `: Validation error
+- ` + "`409 Conflict`" + `: Resource already exists, or was modified by another request since it was read
+- ` + "`422 Unprocessable Entity`" + `: An idempotency key was reused for a different request
 - ` + "`429 Too Many Requests`" + `: Rate limit exceeded
 - ` + "`500 Internal Server Error`" + `: Server error
 - ` + "`503 Service Unavailable`" + `: Service temporarily unavailable
 
 ### Error Types
 
-- ` + "`VALIDATION_ERROR`" + `: Input validation failed
-- ` + "`NOT_FOUND`" + `: Resource not found
-- ` + "`CONFLICT`" + `: Resource conflict (e.g., duplicate)
-- ` + "`UNAUTHORIZED`" + `: Authentication failed
-- ` + "`FORBIDDEN`" + `: Insufficient permissions
-- ` + "`INTERNAL_ERROR`" + `: Internal server error
-- ` + "`EXTERNAL_ERROR`" + `: External service error
-- ` + "`TIMEOUT`" + `: Request timeout
+The ` + "`code`" + ` of a problem, and the last segment of its ` + "`type`" + ` URI:
+
+- ` + "`VALIDATION`" + ` (` + "`validation`" + `, 400): The request is invalid
+- ` + "`UNAUTHORIZED`" + ` (` + "`unauthorized`" + `, 401): Authentication failed
+- ` + "`FORBIDDEN`" + ` (` + "`forbidden`" + `, 403): Insufficient permissions
+- ` + "`NOT_FOUND`" + ` (` + "`not-found`" + `, 404): Resource or route not found
+- ` + "`METHOD_NOT_ALLOWED`" + ` (` + "`method-not-allowed`" + `, 405): The route does not support the method
+- ` + "`CONFLICT`" + ` (` + "`conflict`" + `, 409): Resource conflict (e.g., duplicate or concurrent update)
+- ` + "`UNPROCESSABLE`" + ` (` + "`unprocessable`" + `, 422): An idempotency key was reused for a different request
+- ` + "`TOO_MANY_REQUESTS`" + ` (` + "`too-many-requests`" + `, 429): Rate limit exceeded, retry after ` + "`Retry-After`" + ` seconds
+- ` + "`INTERNAL`" + ` (` + "`internal`" + `, 500): Internal server error
+- ` + "`NOT_IMPLEMENTED`" + ` (` + "`not-implemented`" + `, 501): The operation is not implemented yet
+- ` + "`EXTERNAL`" + ` (` + "`external`" + `, 502): External service error
+- ` + "`TIMEOUT`" + ` (` + "`timeout`" + `, 504): Request timeout
 
 ## Rate Limiting
 
@@ -950,14 +973,20 @@ Rate limit headers:
 
 ## Pagination
 
+List endpoints use cursor pagination, backed by DynamoDB's
+` + "`LastEvaluatedKey`" + `, so every page costs one query no matter how deep it is.
+
 Paginated endpoints support these parameters:
-- ` + "`page`" + `: Page number (starts at 1)
-- ` + "`page_size`" + `: Items per page
+- ` + "`cursor`" + `: Opaque cursor from the previous response; omit it for the first page
+- ` + "`limit`" + `: Items per page (1-100)
 
 Response includes:
-- ` + "`total_count`" + `: Total number of items
-- ` + "`page`" + `: Current page
-- ` + "`page_size`" + `: Items per page
+- ` + "`next_cursor`" + `: Cursor of the next page; absent on the last page
+- ` + "`limit`" + `: Items per page
+
+Treat cursors as opaque. When ` + "`CURSOR_SECRET`" + ` is set they are signed, and a
+tampered or foreign cursor is rejected with ` + "`400 Bad Request`" + `. A filtered
+page may hold fewer than ` + "`limit`" + ` items even when more follow.
 
 ## Versioning
 
@@ -983,49 +1012,60 @@ curl https://api.{{.Name}}.com/openapi.yaml
 
 ## SDK Examples
 
+Typed clients are generated from this spec with ` + "`create-lambda-app generate client`" + `:
+a Go package in ` + "`pkg/client`" + ` and a TypeScript package in ` + "`clients/typescript`" + `.
+Both send the bearer token, retry throttled (429) and unavailable (503) requests
+with exponential backoff, and turn problem details into typed errors carrying
+the ` + "`code`" + ` of the problem.
+
 ### JavaScript/TypeScript
 
-` + "```javascript" + `
-const api = new {{.Name}}API({
+` + "```typescript" + `
+import { {{.ClientName}}, isAPIError } from '{{.Name}}-client';
+
+const api = new {{.ClientName}}({
   baseURL: 'https://api.{{.Name}}.com',
-  apiKey: 'your-api-key'
+  token: () => session.idToken, // or a fixed string
 });
 
 // Create user
-const user = await api.users.create({
-  email: 'user@example.com',
-  name: 'John Doe'
+const created = await api.createUser({
+  body: { email: 'user@example.com', name: 'John Doe' },
 });
 
 // Get user
-const user = await api.users.get('user-id');
+try {
+  const { data: user } = await api.getUser({ id: 'user-id' });
+} catch (error) {
+  if (isAPIError(error, 'NOT_FOUND')) {
+    // ...
+  }
+}
 
 // List users
-const { users, totalCount } = await api.users.list({
-  page: 1,
-  pageSize: 20
-});
+const { data } = await api.listUsers({ limit: 20 });
+// Pass data.next_cursor as cursor to fetch the next page
 ` + "```" + `
 
 ### Go
 
 ` + "```go" + `
-client := New{{.Name}}Client("https://api.{{.Name}}.com", "your-api-key")
+api := client.New("https://api.{{.Name}}.com", client.WithBearerToken(token))
 
 // Create user
-user, err := client.CreateUser(ctx, CreateUserInput{
-    Email: "user@example.com",
-    Name:  "John Doe",
+created, err := api.CreateUser(ctx, &client.CreateUserInput{
+    Body: &client.CreateUserRequest{Email: "user@example.com", Name: "John Doe"},
 })
 
 // Get user
-user, err := client.GetUser(ctx, "user-id")
+user, err := api.GetUser(ctx, &client.GetUserInput{ID: "user-id"})
+if errors.Is(err, client.ErrNotFound) {
+    // ...
+}
 
 // List users
-result, err := client.ListUsers(ctx, ListUsersOptions{
-    Page:     1,
-    PageSize: 20,
-})
+result, err := api.ListUsers(ctx, &client.ListUsersInput{Limit: 20})
+// Pass result.Data.NextCursor as Cursor to fetch the next page
 ` + "```" + `
 
 ### Python
@@ -1046,7 +1086,7 @@ user = client.users.create(
 user = client.users.get("user-id")
 
 # List users
-result = client.users.list(page=1, page_size=20)
+result = client.users.list(limit=20)
 ` + "```" + `
 
 ## Webhooks
@@ -1097,7 +1137,7 @@ curl https://api.{{.Name}}.com/users/550e8400-e29b-41d4-a716-446655440000 \
   -H "Authorization: Bearer <token>"
 
 # List users
-curl "https://api.{{.Name}}.com/users?page=1&page_size=20" \
+curl "https://api.{{.Name}}.com/users?limit=20" \
   -H "Authorization: Bearer <token>"
 ` + "```" + `
 
@@ -1161,13 +1201,12 @@ paths:
       tags:
         - Users
       parameters:
-        - name: page
+        - name: cursor
           in: query
+          description: next_cursor from the previous page; omit for the first page
           schema:
-            type: integer
-            minimum: 1
-            default: 1
-        - name: page_size
+            type: string
+        - name: limit
           in: query
           schema:
             type: integer
@@ -1186,8 +1225,12 @@ paths:
             application/json:
               schema:
                 $ref: '#/components/schemas/UserListResponse'
+        '400':
+          $ref: '#/components/responses/BadRequest'
         '401':
           $ref: '#/components/responses/Unauthorized'
+        '429':
+          $ref: '#/components/responses/TooManyRequests'
     post:
       summary: Create user
       operationId: createUser
@@ -1212,6 +1255,8 @@ paths:
           $ref: '#/components/responses/Unauthorized'
         '409':
           $ref: '#/components/responses/Conflict'
+        '429':
+          $ref: '#/components/responses/TooManyRequests'
   /users/{id}:
     get:
       summary: Get user
@@ -1236,6 +1281,8 @@ paths:
           $ref: '#/components/responses/Unauthorized'
         '404':
           $ref: '#/components/responses/NotFound'
+        '429':
+          $ref: '#/components/responses/TooManyRequests'
     put:
       summary: Update user
       operationId: updateUser
@@ -1267,6 +1314,10 @@ paths:
           $ref: '#/components/responses/Unauthorized'
         '404':
           $ref: '#/components/responses/NotFound'
+        '409':
+          $ref: '#/components/responses/Conflict'
+        '429':
+          $ref: '#/components/responses/TooManyRequests'
     delete:
       summary: Delete user
       operationId: deleteUser
@@ -1286,6 +1337,8 @@ paths:
           $ref: '#/components/responses/Unauthorized'
         '404':
           $ref: '#/components/responses/NotFound'
+        '429':
+          $ref: '#/components/responses/TooManyRequests'
 components:
   {{- if .HasFeature "cognito" }}
   securitySchemes:
@@ -1330,6 +1383,9 @@ components:
         updated_at:
           type: string
           format: date-time
+        version:
+          type: integer
+          description: Incremented on every write; used for optimistic locking
     CreateUserRequest:
       type: object
       required:
@@ -1374,31 +1430,58 @@ components:
               type: array
               items:
                 $ref: '#/components/schemas/User'
-            total_count:
-              type: integer
-            page:
-              type: integer
-            page_size:
+            next_cursor:
+              type: string
+              description: Cursor of the next page; absent on the last page
+            limit:
               type: integer
         meta:
           $ref: '#/components/schemas/ResponseMeta'
-    ErrorResponse:
+    Problem:
       type: object
+      description: RFC 7807 problem details, served as application/problem+json
+      required: [type, title, status, code]
       properties:
-        success:
-          type: boolean
-          example: false
-        error:
-          type: object
-          properties:
-            type:
-              type: string
-            message:
-              type: string
-            details:
-              type: object
-        meta:
-          $ref: '#/components/schemas/ResponseMeta'
+        type:
+          type: string
+          description: URI of the problem type
+          example: https://api.{{.Name}}.com/problems/not-found
+        title:
+          type: string
+          example: Resource not found
+        status:
+          type: integer
+          example: 404
+        detail:
+          type: string
+          example: user not found
+        instance:
+          type: string
+          description: ID of the request, as in the X-Request-ID header
+        code:
+          type: string
+          description: >-
+            Stable code of the problem type: VALIDATION, UNAUTHORIZED,
+            FORBIDDEN, NOT_FOUND, METHOD_NOT_ALLOWED, CONFLICT, UNPROCESSABLE,
+            TOO_MANY_REQUESTS, INTERNAL, NOT_IMPLEMENTED, EXTERNAL or TIMEOUT
+          example: NOT_FOUND
+        errors:
+          type: array
+          description: What is wrong with the request, on VALIDATION problems
+          items:
+            $ref: '#/components/schemas/FieldError'
+    FieldError:
+      type: object
+      required: [in, reason]
+      properties:
+        in:
+          type: string
+          example: body
+        name:
+          type: string
+          example: email
+        reason:
+          type: string
     ResponseMeta:
       type: object
       properties:
@@ -1412,31 +1495,42 @@ components:
     BadRequest:
       description: Bad request
       content:
-        application/json:
+        application/problem+json:
           schema:
-            $ref: '#/components/schemas/ErrorResponse'
+            $ref: '#/components/schemas/Problem'
     Unauthorized:
       description: Unauthorized
       content:
-        application/json:
+        application/problem+json:
           schema:
-            $ref: '#/components/schemas/ErrorResponse'
+            $ref: '#/components/schemas/Problem'
     NotFound:
       description: Not found
       content:
-        application/json:
+        application/problem+json:
           schema:
-            $ref: '#/components/schemas/ErrorResponse'
+            $ref: '#/components/schemas/Problem'
     Conflict:
       description: Conflict
       content:
-        application/json:
+        application/problem+json:
+          schema:
+            $ref: '#/components/schemas/Problem'
+    TooManyRequests:
+      description: Rate limit exceeded
+      headers:
+        Retry-After:
+          description: Seconds until the request may be retried
+          schema:
+            type: integer
+      content:
+        application/problem+json:
           schema:
-            $ref: '#/components/schemas/ErrorResponse'
+            $ref: '#/components/schemas/Problem'
     InternalError:
       description: Internal server error
       content:
-        application/json:
+        application/problem+json:
           schema:
-            $ref: '#/components/schemas/ErrorResponse'
+            $ref: '#/components/schemas/Problem'
 `
\ No newline at end of file
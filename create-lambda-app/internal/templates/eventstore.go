@@ -0,0 +1,309 @@
+package templates
+
+// Event sourcing for the DDD template
+//
+// The event store keeps one stream per aggregate. Appends are conditioned
+// on the stream version so concurrent writers cannot interleave, and
+// aggregates are rebuilt by replaying their stream on top of the latest
+// snapshot. Users are stored as state by the persistence package, so the
+// store serves aggregates a project chooses to event-source.
+
+const DDDDynamoDBEventStore = `package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"{{.Module}}/domain/event"
+)
+
+// EventsByDayIndex is the global secondary index that serves
+// GetEventsSince. It is partitioned by the UTC day an event occurred on
+// and sorted by its timestamp.
+const EventsByDayIndex = "events-by-day"
+
+// maxAppend is the most events one Save can append: a DynamoDB
+// transaction holds 100 items, one of which checks the stream version
+const maxAppend = 99
+
+// occurredAtLayout formats timestamps with fixed width so that they sort
+// as strings
+const occurredAtLayout = "2006-01-02T15:04:05.000000000Z"
+
+// DynamoDBAPI is the subset of the DynamoDB client used by the event store
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// DynamoDBEventStore stores one item per event, keyed on the aggregate ID
+// (stream_id) and the event's version within the stream. The latest
+// snapshot of a stream is kept at version 0, which no event uses.
+type DynamoDBEventStore struct {
+	client    DynamoDBAPI
+	tableName string
+	registry  *event.Registry
+}
+
+var (
+	_ event.EventStore    = (*DynamoDBEventStore)(nil)
+	_ event.SnapshotStore = (*DynamoDBEventStore)(nil)
+)
+
+// NewDynamoDBEventStore creates an event store on the given table. The
+// registry decodes stored events back into their concrete types.
+func NewDynamoDBEventStore(client DynamoDBAPI, tableName string, registry *event.Registry) *DynamoDBEventStore {
+	return &DynamoDBEventStore{
+		client:    client,
+		tableName: tableName,
+		registry:  registry,
+	}
+}
+
+// eventItem is the stored form of an event
+type eventItem struct {
+	StreamID   string ` + "`dynamodbav:\"stream_id\"`" + `
+	Version    int    ` + "`dynamodbav:\"version\"`" + `
+	EventID    string ` + "`dynamodbav:\"event_id\"`" + `
+	Type       string ` + "`dynamodbav:\"type\"`" + `
+	Day        string ` + "`dynamodbav:\"day\"`" + `
+	OccurredAt string ` + "`dynamodbav:\"occurred_at\"`" + `
+	Data       string ` + "`dynamodbav:\"data\"`" + `
+}
+
+// snapshotItem is the stored form of a snapshot. It has no day attribute,
+// so it stays out of the events-by-day index.
+type snapshotItem struct {
+	StreamID        string    ` + "`dynamodbav:\"stream_id\"`" + `
+	Version         int       ` + "`dynamodbav:\"version\"`" + `
+	SnapshotVersion int       ` + "`dynamodbav:\"snapshot_version\"`" + `
+	State           string    ` + "`dynamodbav:\"state\"`" + `
+	TakenAt         time.Time ` + "`dynamodbav:\"taken_at\"`" + `
+}
+
+// Save appends events to the aggregate's stream in one transaction. Each
+// event is written only if its version is free, and the stream's current
+// last event must exist, so the append lands only at expectedVersion.
+func (s *DynamoDBEventStore) Save(ctx context.Context, aggregateID string, expectedVersion int, events []event.DomainEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if len(events) > maxAppend {
+		return fmt.Errorf("cannot append %d events at once, the limit is %d", len(events), maxAppend)
+	}
+
+	items := make([]types.TransactWriteItem, 0, len(events)+1)
+	if expectedVersion > 0 {
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName:           aws.String(s.tableName),
+				Key:                 s.key(aggregateID, expectedVersion),
+				ConditionExpression: aws.String("attribute_exists(stream_id)"),
+			},
+		})
+	}
+
+	for i, evt := range events {
+		data, err := s.registry.Encode(evt)
+		if err != nil {
+			return err
+		}
+
+		occurredAt := evt.OccurredAt().UTC()
+		item, err := attributevalue.MarshalMap(eventItem{
+			StreamID:   aggregateID,
+			Version:    expectedVersion + i + 1,
+			EventID:    evt.EventID(),
+			Type:       evt.EventType(),
+			Day:        occurredAt.Format("2006-01-02"),
+			OccurredAt: occurredAt.Format(occurredAtLayout),
+			Data:       string(data),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(s.tableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(stream_id)"),
+			},
+		})
+	}
+
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		if isConditionFailure(err) {
+			return event.ErrConcurrencyConflict
+		}
+		return fmt.Errorf("failed to append events: %w", err)
+	}
+
+	return nil
+}
+
+// GetEvents returns the events of a stream after afterVersion, in order
+func (s *DynamoDBEventStore) GetEvents(ctx context.Context, aggregateID string, afterVersion int) ([]event.DomainEvent, error) {
+	return s.query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("stream_id = :stream_id AND version > :after"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":stream_id": &types.AttributeValueMemberS{Value: aggregateID},
+			":after":     &types.AttributeValueMemberN{Value: strconv.Itoa(afterVersion)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+}
+
+// GetEventsSince returns the events of all streams that occurred at or
+// after since, ordered by time. It runs one index query per day, so keep
+// the window short.
+func (s *DynamoDBEventStore) GetEventsSince(ctx context.Context, since time.Time) ([]event.DomainEvent, error) {
+	since = since.UTC()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var events []event.DomainEvent
+	for day := since.Truncate(24 * time.Hour); !day.After(today); day = day.AddDate(0, 0, 1) {
+		page, err := s.query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(s.tableName),
+			IndexName:              aws.String(EventsByDayIndex),
+			KeyConditionExpression: aws.String("#day = :day AND occurred_at >= :since"),
+			ExpressionAttributeNames: map[string]string{
+				"#day": "day",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":day":   &types.AttributeValueMemberS{Value: day.Format("2006-01-02")},
+				":since": &types.AttributeValueMemberS{Value: since.Format(occurredAtLayout)},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+	}
+
+	return events, nil
+}
+
+// SaveSnapshot stores a snapshot unless the stream has a newer one
+func (s *DynamoDBEventStore) SaveSnapshot(ctx context.Context, snapshot event.Snapshot) error {
+	item, err := attributevalue.MarshalMap(snapshotItem{
+		StreamID:        snapshot.AggregateID,
+		Version:         0,
+		SnapshotVersion: snapshot.Version,
+		State:           string(snapshot.State),
+		TakenAt:         snapshot.TakenAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(stream_id) OR snapshot_version < :version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberN{Value: strconv.Itoa(snapshot.Version)},
+		},
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil // a newer snapshot already exists
+		}
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// GetSnapshot returns the latest snapshot of a stream, or nil
+func (s *DynamoDBEventStore) GetSnapshot(ctx context.Context, aggregateID string) (*event.Snapshot, error) {
+	resp, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            s.key(aggregateID, 0),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot: %w", err)
+	}
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	var item snapshotItem
+	if err := attributevalue.UnmarshalMap(resp.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+
+	return &event.Snapshot{
+		AggregateID: item.StreamID,
+		Version:     item.SnapshotVersion,
+		State:       []byte(item.State),
+		TakenAt:     item.TakenAt,
+	}, nil
+}
+
+// query runs a query to completion and decodes the events it returns
+func (s *DynamoDBEventStore) query(ctx context.Context, input *dynamodb.QueryInput) ([]event.DomainEvent, error) {
+	var events []event.DomainEvent
+	for {
+		resp, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query events: %w", err)
+		}
+
+		var items []eventItem
+		if err := attributevalue.UnmarshalListOfMaps(resp.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal events: %w", err)
+		}
+		for _, item := range items {
+			evt, err := s.registry.Decode(item.Type, []byte(item.Data))
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, evt)
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return events, nil
+		}
+		input.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+func (s *DynamoDBEventStore) key(aggregateID string, version int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"stream_id": &types.AttributeValueMemberS{Value: aggregateID},
+		"version":   &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+	}
+}
+
+// isConditionFailure reports whether a transaction was canceled because
+// one of its conditions failed
+func isConditionFailure(err error) bool {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return false
+	}
+	for _, reason := range canceled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+`
@@ -0,0 +1,1352 @@
+package templates
+
+// In-memory fakes
+//
+// Fakes live in test/fakes and implement the generated repository and AWS
+// client interfaces with real behavior backed by maps. They import nothing
+// test-framework specific, so they work with testify, the standard library
+// and ginkgo alike.
+
+const FakesRecorder = `package fakes
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrConditionFailed is returned when a conditional write is rejected,
+// mirroring DynamoDB's ConditionalCheckFailedException
+var ErrConditionFailed = errors.New("conditional check failed")
+
+// Call is a single recorded method invocation
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Recorder records calls made to a fake and returns injected errors.
+// Fakes embed it and call record at the start of every method.
+type Recorder struct {
+	mu       sync.Mutex
+	calls    []Call
+	failures map[string]error
+	once     map[string][]error
+	before   map[string][]func()
+}
+
+// FailWith makes every call to method return err. A nil err clears it.
+func (r *Recorder) FailWith(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures == nil {
+		r.failures = make(map[string]error)
+	}
+	if err == nil {
+		delete(r.failures, method)
+		return
+	}
+	r.failures[method] = err
+}
+
+// FailNext makes only the next call to method return err. Repeated calls
+// queue errors for consecutive invocations.
+func (r *Recorder) FailNext(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.once == nil {
+		r.once = make(map[string][]error)
+	}
+	r.once[method] = append(r.once[method], err)
+}
+
+// BeforeNext runs fn once, just before the next call to method takes
+// effect. Use it to simulate a concurrent writer that lands between a
+// read and the conditional write that depends on it. fn may call the fake.
+func (r *Recorder) BeforeNext(method string, fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.before == nil {
+		r.before = make(map[string][]func())
+	}
+	r.before[method] = append(r.before[method], fn)
+}
+
+// Calls returns every recorded call in order
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	calls := make([]Call, len(r.calls))
+	copy(calls, r.calls)
+	return calls
+}
+
+// CallsTo returns the recorded calls to method in order
+func (r *Recorder) CallsTo(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var calls []Call
+	for _, call := range r.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CallCount returns how many times method was called
+func (r *Recorder) CallCount(method string) int {
+	return len(r.CallsTo(method))
+}
+
+// Reset clears recorded calls and injected errors
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = nil
+	r.failures = nil
+	r.once = nil
+	r.before = nil
+}
+
+// record stores the call, runs any BeforeNext hook and returns the error
+// injected for method, if any
+func (r *Recorder) record(method string, args ...interface{}) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+
+	var hook func()
+	if queued := r.before[method]; len(queued) > 0 {
+		hook = queued[0]
+		r.before[method] = queued[1:]
+	}
+	r.mu.Unlock()
+
+	if hook != nil {
+		hook()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if queued := r.once[method]; len(queued) > 0 {
+		r.once[method] = queued[1:]
+		return queued[0]
+	}
+	return r.failures[method]
+}
+`
+
+// Clean Architecture fakes
+
+const CleanFakeUserRepository = `package fakes
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+
+	"{{.Module}}/internal/domain/entities"
+	"{{.Module}}/internal/domain/repositories"
+)
+
+// UserRepository is an in-memory repositories.UserRepository. It applies
+// the same version check as the DynamoDB implementation.
+type UserRepository struct {
+	Recorder
+
+	mu    sync.RWMutex
+	users map[string]entities.User
+}
+
+var _ repositories.UserRepository = (*UserRepository)(nil)
+
+// NewUserRepository creates an in-memory user repository seeded with users
+func NewUserRepository(users ...*entities.User) *UserRepository {
+	r := &UserRepository{users: make(map[string]entities.User)}
+	for _, user := range users {
+		r.users[user.ID] = *user
+	}
+	return r
+}
+
+// Create saves a new user
+func (r *UserRepository) Create(ctx context.Context, user *entities.User) error {
+	if err := r.record("Create", user); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[user.ID]; exists {
+		return fmt.Errorf("failed to create user: %w", ErrConditionFailed)
+	}
+
+	r.users[user.ID] = *user
+	return nil
+}
+
+// GetByID retrieves a user by ID
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*entities.User, error) {
+	if err := r.record("GetByID", id); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repositories.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// GetByEmail retrieves a user by email
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*entities.User, error) {
+	if err := r.record("GetByEmail", email); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, repositories.ErrUserNotFound
+}
+
+// Update saves changes to an existing user
+func (r *UserRepository) Update(ctx context.Context, user *entities.User) error {
+	if err := r.record("Update", user); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.users[user.ID]
+	if !exists || stored.Version != user.Version {
+		return &repositories.ConflictError{ID: user.ID, Version: user.Version}
+	}
+
+	user.UpdateVersion()
+	r.users[user.ID] = *user
+	return nil
+}
+
+// Delete removes a user
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	if err := r.record("Delete", id); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[id]; !exists {
+		return fmt.Errorf("failed to delete user: %w", ErrConditionFailed)
+	}
+
+	delete(r.users, id)
+	return nil
+}
+
+// List retrieves a page of users ordered by creation time. Cursors are
+// the encoded ID of the last user on the previous page.
+func (r *UserRepository) List(ctx context.Context, cursor string, limit int) ([]*entities.User, string, error) {
+	if err := r.record("List", cursor, limit); err != nil {
+		return nil, "", err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make([]*entities.User, 0, len(r.users))
+	for _, user := range r.users {
+		user := user
+		all = append(all, &user)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].ID < all[j].ID
+		}
+		return all[i].CreatedAt.Before(all[j].CreatedAt)
+	})
+
+	start := 0
+	if cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", repositories.ErrInvalidCursor
+		}
+		start = -1
+		for i, user := range all {
+			if user.ID == string(id) {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, "", repositories.ErrInvalidCursor
+		}
+	}
+
+	end := start + limit
+	if limit <= 0 || end > len(all) {
+		end = len(all)
+	}
+	page := all[start:end]
+
+	next := ""
+	if end < len(all) {
+		next = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1].ID))
+	}
+	return page, next, nil
+}
+
+// Count returns the total number of users
+func (r *UserRepository) Count(ctx context.Context) (int64, error) {
+	if err := r.record("Count"); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.users)), nil
+}
+`
+
+const CleanFakeSQSClient = `package fakes
+
+import (
+	"context"
+	"sync"
+
+	infraaws "{{.Module}}/internal/infrastructure/aws"
+)
+
+// SQSClient is an in-memory aws.MessageSender that keeps every message sent
+type SQSClient struct {
+	Recorder
+
+	mu       sync.Mutex
+	messages []infraaws.Message
+}
+
+var _ infraaws.MessageSender = (*SQSClient)(nil)
+
+// NewSQSClient creates an empty in-memory SQS client
+func NewSQSClient() *SQSClient {
+	return &SQSClient{}
+}
+
+// SendMessage sends a message to the queue
+func (c *SQSClient) SendMessage(ctx context.Context, messageType string, payload interface{}) error {
+	if err := c.record("SendMessage", messageType, payload); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = append(c.messages, infraaws.Message{Type: messageType, Payload: payload})
+	return nil
+}
+
+// SendBatch sends multiple messages
+func (c *SQSClient) SendBatch(ctx context.Context, messages []infraaws.Message) error {
+	if err := c.record("SendBatch", messages); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = append(c.messages, messages...)
+	return nil
+}
+
+// Messages returns every message sent so far
+func (c *SQSClient) Messages() []infraaws.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	messages := make([]infraaws.Message, len(c.messages))
+	copy(messages, c.messages)
+	return messages
+}
+
+// MessagesOfType returns the messages sent with the given type
+func (c *SQSClient) MessagesOfType(messageType string) []infraaws.Message {
+	var messages []infraaws.Message
+	for _, msg := range c.Messages() {
+		if msg.Type == messageType {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+`
+
+// Simple structure fakes
+
+const SimpleFakeDynamoDB = `package fakes
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDB is an in-memory stand-in for the DynamoDB client used by the
+// services. Items are keyed on the table's partition and sort key from
+// access-patterns.yaml and queries are served from its global secondary
+// indexes, sorted by the sort key and paged with Limit and
+// ExclusiveStartKey like the real service.
+//
+// Condition, key condition and filter expressions support clauses of the
+// form "a = :v", "begins_with(a, :v)", "attribute_exists(a)" and
+// "attribute_not_exists(a)" joined with AND, which covers everything the
+// generated services use.
+type DynamoDB struct {
+	Recorder
+
+	mu      sync.RWMutex
+	key     keySchema
+	indexes map[string]keySchema
+	tables  map[string]map[string]map[string]types.AttributeValue
+}
+
+// keySchema names the partition and optional sort key attributes of the
+// table or an index
+type keySchema struct {
+	partition string
+	sort      string
+}
+
+// NewDynamoDB creates an empty fake with the key schema and indexes of the
+// single-table design
+func NewDynamoDB() *DynamoDB {
+	f := &DynamoDB{
+		key:     keySchema{partition: "{{ .DataModel.Table.PartitionKey }}", sort: "{{ .DataModel.Table.SortKey }}"},
+		indexes: make(map[string]keySchema),
+		tables:  make(map[string]map[string]map[string]types.AttributeValue),
+	}
+{{- range .DataModel.Table.Indexes }}
+	f.WithIndex("{{ .Name }}", "{{ .PartitionKey }}", "{{ .SortKey }}")
+{{- end }}
+
+	return f
+}
+
+// WithIndex registers a global secondary index. sortKey may be empty.
+func (f *DynamoDB) WithIndex(name, partitionKey, sortKey string) *DynamoDB {
+	f.indexes[name] = keySchema{partition: partitionKey, sort: sortKey}
+	return f
+}
+
+// PutItem stores an item, honoring ConditionExpression
+func (f *DynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if err := f.record("PutItem", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	table := f.table(aws.ToString(params.TableName))
+	key, err := f.keyOf(params.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.ConditionExpression != nil {
+		ok, err := matches(table[key], aws.ToString(params.ConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, conditionFailed()
+		}
+	}
+
+	table[key] = copyItem(params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// GetItem returns the item stored under Key
+func (f *DynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if err := f.record("GetItem", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	key, err := f.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := f.tables[aws.ToString(params.TableName)][key]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: copyItem(item)}, nil
+}
+
+// DeleteItem removes the item stored under Key, honoring ConditionExpression
+func (f *DynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if err := f.record("DeleteItem", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	table := f.table(aws.ToString(params.TableName))
+	key, err := f.keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.ConditionExpression != nil {
+		ok, err := matches(table[key], aws.ToString(params.ConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, conditionFailed()
+		}
+	}
+
+	delete(table, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// Query returns the items matching KeyConditionExpression on the table or
+// on the index named by IndexName. Items without the index key attributes
+// are left out, as in a sparse index.
+func (f *DynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if err := f.record("Query", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	schema := f.key
+	if name := aws.ToString(params.IndexName); name != "" {
+		index, ok := f.indexes[name]
+		if !ok {
+			return nil, fmt.Errorf("fakes: unknown index %q", name)
+		}
+		schema = index
+	}
+
+	items, err := f.filter(aws.ToString(params.TableName), aws.ToString(params.KeyConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		if _, ok := item[schema.partition]; !ok {
+			continue
+		}
+		if _, ok := item[schema.sort]; schema.sort != "" && !ok {
+			continue
+		}
+		indexed = append(indexed, item)
+	}
+	sortItems(indexed, schema.sort, params.ScanIndexForward != nil && !*params.ScanIndexForward)
+
+	// Limit applies before the filter expression, as in DynamoDB
+	page, lastKey := f.page(indexed, schema, params.ExclusiveStartKey, params.Limit)
+	if params.FilterExpression != nil {
+		if page, err = filterItems(page, aws.ToString(params.FilterExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.Select == types.SelectCount {
+		return &dynamodb.QueryOutput{Count: int32(len(page)), LastEvaluatedKey: lastKey}, nil
+	}
+	return &dynamodb.QueryOutput{Items: page, Count: int32(len(page)), LastEvaluatedKey: lastKey}, nil
+}
+
+// Scan returns the items in the table that match FilterExpression in key
+// order, paged with Limit and ExclusiveStartKey like Query
+func (f *DynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if err := f.record("Scan", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	items, err := f.filter(aws.ToString(params.TableName), "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Limit applies before the filter expression, as in DynamoDB
+	page, lastKey := f.page(items, f.key, params.ExclusiveStartKey, params.Limit)
+	if params.FilterExpression != nil {
+		if page, err = filterItems(page, aws.ToString(params.FilterExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.Select == types.SelectCount {
+		return &dynamodb.ScanOutput{Count: int32(len(page)), LastEvaluatedKey: lastKey}, nil
+	}
+	return &dynamodb.ScanOutput{Items: page, Count: int32(len(page)), LastEvaluatedKey: lastKey}, nil
+}
+
+// BatchWriteItem applies every put and delete request
+func (f *DynamoDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := f.record("BatchWriteItem", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for tableName, requests := range params.RequestItems {
+		table := f.table(tableName)
+		for _, request := range requests {
+			switch {
+			case request.PutRequest != nil:
+				key, err := f.keyOf(request.PutRequest.Item)
+				if err != nil {
+					return nil, err
+				}
+				table[key] = copyItem(request.PutRequest.Item)
+			case request.DeleteRequest != nil:
+				key, err := f.keyOf(request.DeleteRequest.Key)
+				if err != nil {
+					return nil, err
+				}
+				delete(table, key)
+			}
+		}
+	}
+
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+// Items returns a copy of every item stored in table
+func (f *DynamoDB) Items(table string) []map[string]types.AttributeValue {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	items, _ := f.filter(table, "", nil, nil)
+	return items
+}
+
+func (f *DynamoDB) table(name string) map[string]map[string]types.AttributeValue {
+	table, ok := f.tables[name]
+	if !ok {
+		table = make(map[string]map[string]types.AttributeValue)
+		f.tables[name] = table
+	}
+	return table
+}
+
+// keyOf returns the primary key of item as a single string
+func (f *DynamoDB) keyOf(item map[string]types.AttributeValue) (string, error) {
+	partition, ok := item[f.key.partition].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("fakes: item is missing string key %q", f.key.partition)
+	}
+	if f.key.sort == "" {
+		return partition.Value, nil
+	}
+
+	sortKey, ok := item[f.key.sort].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("fakes: item is missing string key %q", f.key.sort)
+	}
+	return partition.Value + "\x00" + sortKey.Value, nil
+}
+
+// page returns up to max items following startKey and the key to resume
+// from when more items remain
+func (f *DynamoDB) page(items []map[string]types.AttributeValue, schema keySchema, startKey map[string]types.AttributeValue, max *int32) ([]map[string]types.AttributeValue, map[string]types.AttributeValue) {
+	if startKey != nil {
+		start, _ := f.keyOf(startKey)
+		for i, item := range items {
+			if key, _ := f.keyOf(item); key == start {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	if max == nil || int(*max) >= len(items) {
+		return items, nil
+	}
+
+	items = items[:*max]
+	last := items[len(items)-1]
+	lastKey := make(map[string]types.AttributeValue)
+	for _, name := range []string{f.key.partition, f.key.sort, schema.partition, schema.sort} {
+		if value, ok := last[name]; ok && name != "" {
+			lastKey[name] = value
+		}
+	}
+	return items, lastKey
+}
+
+// filter returns the items in table matching expression in key order
+func (f *DynamoDB) filter(table, expression string, names map[string]string, values map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	keys := make([]string, 0, len(f.tables[table]))
+	for key := range f.tables[table] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := make([]map[string]types.AttributeValue, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, copyItem(f.tables[table][key]))
+	}
+
+	return filterItems(items, expression, names, values)
+}
+
+func filterItems(items []map[string]types.AttributeValue, expression string, names map[string]string, values map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	if expression == "" {
+		return items, nil
+	}
+
+	matched := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		ok, err := matches(item, expression, names, values)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, item)
+		}
+	}
+	return matched, nil
+}
+
+// matches evaluates an AND-joined expression against item. A nil item
+// models an item that does not exist yet.
+func matches(item map[string]types.AttributeValue, expression string, names map[string]string, values map[string]types.AttributeValue) (bool, error) {
+	for _, clause := range strings.Split(expression, " AND ") {
+		clause = strings.TrimSpace(clause)
+
+		switch {
+		case strings.HasPrefix(clause, "attribute_not_exists(") && strings.HasSuffix(clause, ")"):
+			name := resolveName(clause[len("attribute_not_exists("):len(clause)-1], names)
+			if _, ok := item[name]; ok {
+				return false, nil
+			}
+		case strings.HasPrefix(clause, "attribute_exists(") && strings.HasSuffix(clause, ")"):
+			name := resolveName(clause[len("attribute_exists("):len(clause)-1], names)
+			if _, ok := item[name]; !ok {
+				return false, nil
+			}
+		case strings.HasPrefix(clause, "begins_with(") && strings.HasSuffix(clause, ")"):
+			args := strings.SplitN(clause[len("begins_with("):len(clause)-1], ",", 2)
+			if len(args) != 2 {
+				return false, fmt.Errorf("fakes: unsupported expression %q", clause)
+			}
+			name := resolveName(args[0], names)
+			prefix, ok := values[strings.TrimSpace(args[1])].(*types.AttributeValueMemberS)
+			if !ok {
+				return false, fmt.Errorf("fakes: missing string expression value %s", args[1])
+			}
+			value, ok := item[name].(*types.AttributeValueMemberS)
+			if !ok || !strings.HasPrefix(value.Value, prefix.Value) {
+				return false, nil
+			}
+		case strings.Contains(clause, " = "):
+			parts := strings.SplitN(clause, " = ", 2)
+			name := resolveName(strings.TrimSpace(parts[0]), names)
+			want, ok := values[strings.TrimSpace(parts[1])]
+			if !ok {
+				return false, fmt.Errorf("fakes: missing expression value %s", parts[1])
+			}
+			if !reflect.DeepEqual(item[name], want) {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("fakes: unsupported expression %q", clause)
+		}
+	}
+	return true, nil
+}
+
+func resolveName(name string, names map[string]string) string {
+	name = strings.TrimSpace(name)
+	if resolved, ok := names[name]; ok {
+		return resolved
+	}
+	return name
+}
+
+// sortItems orders items by the string sort key attribute. Items with
+// equal sort keys keep their order.
+func sortItems(items []map[string]types.AttributeValue, attribute string, descending bool) {
+	if attribute == "" {
+		return
+	}
+
+	value := func(item map[string]types.AttributeValue) string {
+		if s, ok := item[attribute].(*types.AttributeValueMemberS); ok {
+			return s.Value
+		}
+		return ""
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if descending {
+			return value(items[i]) > value(items[j])
+		}
+		return value(items[i]) < value(items[j])
+	})
+}
+
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	copied := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		copied[k] = v
+	}
+	return copied
+}
+
+func conditionFailed() error {
+	return &types.ConditionalCheckFailedException{Message: aws.String(ErrConditionFailed.Error())}
+}
+`
+
+const SimpleFakeSQS = `package fakes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SQS is an in-memory stand-in for the SQS client that keeps every
+// message sent, grouped by queue URL
+type SQS struct {
+	Recorder
+
+	mu     sync.Mutex
+	queues map[string][]types.Message
+}
+
+// NewSQS creates an empty in-memory SQS client
+func NewSQS() *SQS {
+	return &SQS{queues: make(map[string][]types.Message)}
+}
+
+// SendMessage appends a message to the queue
+func (f *SQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if err := f.record("SendMessage", params); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queueURL := aws.ToString(params.QueueUrl)
+	messageID := fmt.Sprintf("message-%d", len(f.queues[queueURL])+1)
+	f.queues[queueURL] = append(f.queues[queueURL], types.Message{
+		MessageId:         aws.String(messageID),
+		Body:              params.MessageBody,
+		MessageAttributes: params.MessageAttributes,
+	})
+
+	return &sqs.SendMessageOutput{MessageId: aws.String(messageID)}, nil
+}
+
+// Messages returns the messages sent to queueURL
+func (f *SQS) Messages(queueURL string) []types.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]types.Message, len(f.queues[queueURL]))
+	copy(messages, f.queues[queueURL])
+	return messages
+}
+`
+
+// DDD fakes
+
+const DDDFakeUserRepository = `package fakes
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"sync"
+
+	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/event"
+	"{{.Module}}/domain/repository"
+)
+
+// UserRepository is an in-memory repository.UserRepository. It applies
+// the same version check as the DynamoDB implementation and keeps the
+// events a successful Save writes to the outbox.
+type UserRepository struct {
+	Recorder
+
+	mu     sync.RWMutex
+	users  map[string]aggregate.User
+	outbox []event.DomainEvent
+}
+
+var _ repository.UserRepository = (*UserRepository)(nil)
+
+// NewUserRepository creates an in-memory user repository seeded with users
+func NewUserRepository(users ...*aggregate.User) *UserRepository {
+	r := &UserRepository{users: make(map[string]aggregate.User)}
+	for _, user := range users {
+		r.users[user.ID] = *user
+	}
+	return r
+}
+
+// Save saves a user aggregate
+func (r *UserRepository) Save(ctx context.Context, user *aggregate.User) error {
+	if err := r.record("Save", user); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := user.GetUncommittedEvents()
+	if stored, exists := r.users[user.ID]; exists && stored.Version != user.Version-len(pending) {
+		return repository.ErrVersionConflict
+	}
+
+	stored := *user
+	stored.MarkEventsAsCommitted()
+	r.users[user.ID] = stored
+	r.outbox = append(r.outbox, pending...)
+	return nil
+}
+
+// Outbox returns every event saved to the outbox so far
+func (r *UserRepository) Outbox() []event.DomainEvent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := make([]event.DomainEvent, len(r.outbox))
+	copy(events, r.outbox)
+	return events
+}
+
+// FindByID finds a user by ID
+func (r *UserRepository) FindByID(ctx context.Context, id string) (*aggregate.User, error) {
+	if err := r.record("FindByID", id); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// FindByEmail finds a user by email
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*aggregate.User, error) {
+	if err := r.record("FindByEmail", email); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+// List retrieves a page of users ordered by creation time. Cursors are
+// the encoded ID of the last user on the previous page.
+func (r *UserRepository) List(ctx context.Context, cursor string, limit int) ([]*aggregate.User, string, error) {
+	if err := r.record("List", cursor, limit); err != nil {
+		return nil, "", err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make([]*aggregate.User, 0, len(r.users))
+	for _, user := range r.users {
+		user := user
+		all = append(all, &user)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].ID < all[j].ID
+		}
+		return all[i].CreatedAt.Before(all[j].CreatedAt)
+	})
+
+	start := 0
+	if cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", repository.ErrInvalidCursor
+		}
+		start = -1
+		for i, user := range all {
+			if user.ID == string(id) {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, "", repository.ErrInvalidCursor
+		}
+	}
+
+	end := start + limit
+	if limit <= 0 || end > len(all) {
+		end = len(all)
+	}
+	page := all[start:end]
+
+	next := ""
+	if end < len(all) {
+		next = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1].ID))
+	}
+	return page, next, nil
+}
+
+// Count returns the total number of users
+func (r *UserRepository) Count(ctx context.Context) (int64, error) {
+	if err := r.record("Count"); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.users)), nil
+}
+
+// Delete deletes a user
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	if err := r.record("Delete", id); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+`
+
+const DDDFakeEventBus = `package fakes
+
+import (
+	"context"
+	"sync"
+
+	"{{.Module}}/domain/event"
+)
+
+// EventBus is an in-memory event.EventBus. Published events are kept for
+// inspection and delivered synchronously to subscribed handlers.
+type EventBus struct {
+	Recorder
+
+	mu        sync.Mutex
+	handlers  []event.EventHandler
+	published []event.DomainEvent
+}
+
+var _ event.EventBus = (*EventBus)(nil)
+
+// NewEventBus creates an in-memory event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe subscribes a handler to events
+func (b *EventBus) Subscribe(handler event.EventHandler) {
+	_ = b.record("Subscribe", handler)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish publishes events to all handlers
+func (b *EventBus) Publish(ctx context.Context, events []event.DomainEvent) error {
+	if err := b.record("Publish", events); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.published = append(b.published, events...)
+	handlers := append([]event.EventHandler(nil), b.handlers...)
+	b.mu.Unlock()
+
+	for _, evt := range events {
+		for _, handler := range handlers {
+			if !handler.CanHandle(evt) {
+				continue
+			}
+			if err := handler.Handle(ctx, evt); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Published returns every event published so far
+func (b *EventBus) Published() []event.DomainEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make([]event.DomainEvent, len(b.published))
+	copy(events, b.published)
+	return events
+}
+
+// PublishedOfType returns the published events with the given type
+func (b *EventBus) PublishedOfType(eventType string) []event.DomainEvent {
+	var events []event.DomainEvent
+	for _, evt := range b.Published() {
+		if evt.EventType() == eventType {
+			events = append(events, evt)
+		}
+	}
+	return events
+}
+`
+
+const DDDFakeEventStore = `package fakes
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"{{.Module}}/domain/event"
+)
+
+// EventStore is an in-memory event.EventStore and event.SnapshotStore.
+// Appends are checked against the stream version like the DynamoDB store,
+// so BeforeNext("Save", ...) can simulate a concurrent writer.
+type EventStore struct {
+	Recorder
+
+	mu        sync.RWMutex
+	streams   map[string][]event.DomainEvent
+	all       []event.DomainEvent
+	snapshots map[string]event.Snapshot
+}
+
+var (
+	_ event.EventStore    = (*EventStore)(nil)
+	_ event.SnapshotStore = (*EventStore)(nil)
+)
+
+// NewEventStore creates an empty in-memory event store
+func NewEventStore() *EventStore {
+	return &EventStore{
+		streams:   make(map[string][]event.DomainEvent),
+		snapshots: make(map[string]event.Snapshot),
+	}
+}
+
+// Save appends events to a stream if it is still at expectedVersion
+func (s *EventStore) Save(ctx context.Context, aggregateID string, expectedVersion int, events []event.DomainEvent) error {
+	if err := s.record("Save", aggregateID, expectedVersion, events); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.streams[aggregateID]) != expectedVersion {
+		return event.ErrConcurrencyConflict
+	}
+	s.streams[aggregateID] = append(s.streams[aggregateID], events...)
+	s.all = append(s.all, events...)
+	return nil
+}
+
+// GetEvents returns the events of a stream after afterVersion
+func (s *EventStore) GetEvents(ctx context.Context, aggregateID string, afterVersion int) ([]event.DomainEvent, error) {
+	if err := s.record("GetEvents", aggregateID, afterVersion); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream := s.streams[aggregateID]
+	if afterVersion >= len(stream) {
+		return nil, nil
+	}
+	events := make([]event.DomainEvent, len(stream)-afterVersion)
+	copy(events, stream[afterVersion:])
+	return events, nil
+}
+
+// GetEventsSince returns the events of all streams that occurred at or
+// after since, ordered by time
+func (s *EventStore) GetEventsSince(ctx context.Context, since time.Time) ([]event.DomainEvent, error) {
+	if err := s.record("GetEventsSince", since); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []event.DomainEvent
+	for _, evt := range s.all {
+		if !evt.OccurredAt().Before(since) {
+			events = append(events, evt)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt().Before(events[j].OccurredAt())
+	})
+	return events, nil
+}
+
+// SaveSnapshot stores a snapshot unless the stream has a newer one
+func (s *EventStore) SaveSnapshot(ctx context.Context, snapshot event.Snapshot) error {
+	if err := s.record("SaveSnapshot", snapshot); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.snapshots[snapshot.AggregateID]; ok && current.Version >= snapshot.Version {
+		return nil
+	}
+	s.snapshots[snapshot.AggregateID] = snapshot
+	return nil
+}
+
+// GetSnapshot returns the latest snapshot of a stream, or nil
+func (s *EventStore) GetSnapshot(ctx context.Context, aggregateID string) (*event.Snapshot, error) {
+	if err := s.record("GetSnapshot", aggregateID); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[aggregateID]
+	if !ok {
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+// Stream returns every event stored for an aggregate
+func (s *EventStore) Stream(aggregateID string) []event.DomainEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]event.DomainEvent, len(s.streams[aggregateID]))
+	copy(events, s.streams[aggregateID])
+	return events
+}
+`
+
+const DDDFakeEventPublisher = `package fakes
+
+import (
+	"context"
+	"sync"
+
+	"{{.Module}}/domain/event"
+)
+
+// EventPublisher is an in-memory event.EventPublisher standing in for the
+// SQS messaging client
+type EventPublisher struct {
+	Recorder
+
+	mu        sync.Mutex
+	published []event.DomainEvent
+}
+
+var _ event.EventPublisher = (*EventPublisher)(nil)
+
+// NewEventPublisher creates an empty in-memory event publisher
+func NewEventPublisher() *EventPublisher {
+	return &EventPublisher{}
+}
+
+// Publish publishes domain events
+func (p *EventPublisher) Publish(ctx context.Context, events []event.DomainEvent) error {
+	if err := p.record("Publish", events); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.published = append(p.published, events...)
+	return nil
+}
+
+// Published returns every event published so far
+func (p *EventPublisher) Published() []event.DomainEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make([]event.DomainEvent, len(p.published))
+	copy(events, p.published)
+	return events
+}
+`
@@ -174,40 +174,67 @@ func generateTestFile(config *HandlerConfig) error {
 	}
 
 	// Create test template
-	testTemplate := ` + "`" + `package {{if eq .Architecture "simple"}}handlers{{else if eq .Architecture "ddd"}}{{.Name}}{{else}}main{{end}}
+	testTemplate := ` + "`" + `package {{if eq .Architecture "simple"}}handlers{{else}}main{{end}}
+{{- if eq .TestingFramework "ginkgo" }}
 
 import (
 	"context"
-	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"{{.Module}}/pkg/apievent"
+)
+
+var _ = Describe("Handler", func() {
+	DescribeTable("HandleRequest",
+		func(request apievent.Request, expectedStatus int) {
+			// Create handler
+			handler := NewHandler(nil) // Pass mock dependencies
+
+			// Execute
+			response, err := handler.HandleRequest(context.Background(), request)
+
+			// Assert
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(expectedStatus))
+		},
+		Entry("successful request", apievent.NewRequest("GET", "/test", ""), 200),
+		Entry("invalid request", apievent.NewRequest("POST", "/test", "invalid json"), 400),
+	)
+})
+{{- else }}
+
+import (
+	"context"
+{{- if eq .TestingFramework "standard" }}
+	"strings"
+{{- end }}
 	"testing"
+{{- if eq .TestingFramework "testify" }}
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
 )
 
 func TestHandler(t *testing.T) {
 	tests := []struct {
 		name           string
-		request        events.APIGatewayProxyRequest
+		request        apievent.Request
 		expectedStatus int
 		expectedBody   string
 	}{
 		{
-			name: "successful request",
-			request: events.APIGatewayProxyRequest{
-				HTTPMethod: "GET",
-				Path:       "/test",
-			},
+			name:           "successful request",
+			request:        apievent.NewRequest("GET", "/test", ""),
 			expectedStatus: 200,
 		},
 		{
-			name: "invalid request",
-			request: events.APIGatewayProxyRequest{
-				HTTPMethod: "POST",
-				Path:       "/test",
-				Body:       "invalid json",
-			},
+			name:           "invalid request",
+			request:        apievent.NewRequest("POST", "/test", "invalid json"),
 			expectedStatus: 400,
 		},
 	}
@@ -221,15 +248,29 @@ func TestHandler(t *testing.T) {
 			response, err := handler.HandleRequest(context.Background(), tt.request)
 			
 			// Assert
+{{- if eq .TestingFramework "standard" }}
+			if err != nil {
+				t.Fatalf("HandleRequest() error = %v", err)
+			}
+			if response.StatusCode != tt.expectedStatus {
+				t.Errorf("StatusCode = %d, want %d", response.StatusCode, tt.expectedStatus)
+			}
+			
+			if tt.expectedBody != "" && !strings.Contains(response.Body, tt.expectedBody) {
+				t.Errorf("Body = %q, want it to contain %q", response.Body, tt.expectedBody)
+			}
+{{- else }}
 			require.NoError(t, err)
 			assert.Equal(t, tt.expectedStatus, response.StatusCode)
 			
 			if tt.expectedBody != "" {
 				assert.Contains(t, response.Body, tt.expectedBody)
 			}
+{{- end }}
 		})
 	}
 }
+{{- end }}
 ` + "`" + `
 
 	// Parse and execute template
@@ -245,7 +286,35 @@ func TestHandler(t *testing.T) {
 	}
 	defer file.Close()
 
-	return tmpl.Execute(file, config)
+	if err := tmpl.Execute(file, config); err != nil {
+		return err
+	}
+{{- if eq .TestingFramework "ginkgo" }}
+
+	// Bootstrap the Ginkgo suite once per package
+	suitePath := filepath.Join(filepath.Dir(testPath), "suite_test.go")
+	if _, err := os.Stat(suitePath); os.IsNotExist(err) {
+		suite := ` + "`" + `package {{if eq .Architecture "simple"}}handlers{{else}}main{{end}}
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHandler(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handler Suite")
+}
+` + "`" + `
+		if err := os.WriteFile(suitePath, []byte(suite), 0644); err != nil {
+			return fmt.Errorf("failed to write suite file: %w", err)
+		}
+	}
+{{- end }}
+
+	return nil
 }
 
 // Template functions for different handler types
@@ -291,9 +360,9 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/rs/zerolog/log"
+	"{{.Module}}/pkg/apievent"
 )
 
 type Handler struct {
@@ -306,28 +375,24 @@ func NewHandler() *Handler {
 	}
 }
 
-func (h *Handler) HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handler) HandleRequest(ctx context.Context, request apievent.Request) (apievent.Response, error) {
 	log.Ctx(ctx).Info().
-		Str("method", request.HTTPMethod).
-		Str("path", request.Path).
+		Str("method", apievent.Method(request)).
+		Str("path", apievent.Path(request)).
 		Msg("Processing {{.Name}} request")
 
 	// Add your handler logic here
 	response := map[string]interface{}{
 		"message": "Hello from {{.Name}}",
-		"path":    request.Path,
-		"method":  request.HTTPMethod,
+		"path":    apievent.Path(request),
+		"method":  apievent.Method(request),
 	}
 
 	body, _ := json.Marshal(response)
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body: string(body),
-	}, nil
+	return apievent.NewResponse(http.StatusOK, map[string]string{
+		"Content-Type": "application/json",
+	}, string(body)), nil
 }
 
 func main() {
@@ -343,33 +408,29 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/rs/zerolog/log"
+	"{{.Module}}/pkg/apievent"
 )
 
-func {{.Name}}Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func {{.Name}}Handler(ctx context.Context, request apievent.Request) (apievent.Response, error) {
 	log.Ctx(ctx).Info().
-		Str("method", request.HTTPMethod).
-		Str("path", request.Path).
+		Str("method", apievent.Method(request)).
+		Str("path", apievent.Path(request)).
 		Msg("Processing {{.Name}} request")
 
 	// Add your handler logic here
 	response := map[string]interface{}{
 		"message": "Hello from {{.Name}}",
-		"path":    request.Path,
-		"method":  request.HTTPMethod,
+		"path":    apievent.Path(request),
+		"method":  apievent.Method(request),
 	}
 
 	body, _ := json.Marshal(response)
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body: string(body),
-	}, nil
+	return apievent.NewResponse(http.StatusOK, map[string]string{
+		"Content-Type": "application/json",
+	}, string(body)), nil
 }
 
 func init() {
@@ -382,6 +443,7 @@ const cleanSQSHandlerTemplate = ` + "`" + `package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -394,9 +456,9 @@ type Handler struct {
 
 type Message struct {
 	// Define your message structure
-	ID      string ` + "`json:\"id\"`" + `
-	Type    string ` + "`json:\"type\"`" + `
-	Payload json.RawMessage ` + "`json:\"payload\"`" + `
+	ID      string ` + "` + \"`json:\\\"id\\\"`\" + `" + `
+	Type    string ` + "` + \"`json:\\\"type\\\"`\" + `" + `
+	Payload json.RawMessage ` + "` + \"`json:\\\"payload\\\"`\" + `" + `
 }
 
 func NewHandler() *Handler {
@@ -405,23 +467,27 @@ func NewHandler() *Handler {
 	}
 }
 
-func (h *Handler) HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) error {
+// HandleRequest processes every record in the batch and reports the ones that
+// failed, so only those messages are retried.
+func (h *Handler) HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	log.Ctx(ctx).Info().
 		Int("message_count", len(sqsEvent.Records)).
 		Msg("Processing SQS messages")
 
+	var response events.SQSEventResponse
 	for _, record := range sqsEvent.Records {
 		if err := h.processMessage(ctx, record); err != nil {
 			log.Ctx(ctx).Error().
 				Err(err).
 				Str("message_id", record.MessageId).
 				Msg("Failed to process message")
-			// Return error to retry the message
-			return err
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
 		}
 	}
 
-	return nil
+	return response, nil
 }
 
 func (h *Handler) processMessage(ctx context.Context, record events.SQSMessage) error {
@@ -461,7 +527,6 @@ const simpleSQSHandlerTemplate = ` + "`" + `package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -470,16 +535,17 @@ import (
 
 type Message struct {
 	// Define your message structure
-	ID      string ` + "`json:\"id\"`" + `
-	Type    string ` + "`json:\"type\"`" + `
-	Payload json.RawMessage ` + "`json:\"payload\"`" + `
+	ID      string ` + "` + \"`json:\\\"id\\\"`\" + `" + `
+	Type    string ` + "` + \"`json:\\\"type\\\"`\" + `" + `
+	Payload json.RawMessage ` + "` + \"`json:\\\"payload\\\"`\" + `" + `
 }
 
-func {{.Name}}Handler(ctx context.Context, sqsEvent events.SQSEvent) error {
+func {{.Name}}Handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	log.Ctx(ctx).Info().
 		Int("message_count", len(sqsEvent.Records)).
 		Msg("Processing SQS messages")
 
+	var response events.SQSEventResponse
 	for _, record := range sqsEvent.Records {
 		var msg Message
 		if err := json.Unmarshal([]byte(record.Body), &msg); err != nil {
@@ -487,7 +553,10 @@ func {{.Name}}Handler(ctx context.Context, sqsEvent events.SQSEvent) error {
 				Err(err).
 				Str("message_id", record.MessageId).
 				Msg("Failed to unmarshal message")
-			return err
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+			continue
 		}
 
 		// Process message
@@ -499,7 +568,7 @@ func {{.Name}}Handler(ctx context.Context, sqsEvent events.SQSEvent) error {
 		// Add your message processing logic here
 	}
 
-	return nil
+	return response, nil
 }
 
 func init() {
@@ -863,14 +932,14 @@ type Handler struct {
 // Define your input and output types
 type Input struct {
 	// Add your input fields
-	Message string ` + "`json:\"message\"`" + `
+	Message string ` + "` + \"`json:\\\"message\\\"`\" + `" + `
 }
 
 type Output struct {
 	// Add your output fields
-	Success bool   ` + "`json:\"success\"`" + `
-	Message string ` + "`json:\"message\"`" + `
-	Result  interface{} ` + "`json:\"result,omitempty\"`" + `
+	Success bool   ` + "` + \"`json:\\\"success\\\"`\" + `" + `
+	Message string ` + "` + \"`json:\\\"message\\\"`\" + `" + `
+	Result  interface{} ` + "` + \"`json:\\\"result,omitempty\\\"`\" + `" + `
 }
 
 func NewHandler() *Handler {
@@ -922,7 +991,7 @@ echo "Checking prerequisites..."
 # Check Go
 if ! command -v go &> /dev/null; then
     echo -e "${RED}❌ Go is not installed${NC}"
-    echo "Please install Go 1.21 or higher: https://golang.org/dl/"
+    echo "Please install Go {{.GoVersion}} or higher: https://golang.org/dl/"
     exit 1
 else
     echo -e "${GREEN}✓ Go $(go version | awk '{print $3}')${NC}"
@@ -1003,37 +1072,28 @@ else
     echo -e "${GREEN}✓ .env.local already exists${NC}"
 fi
 
-{{- if .HasFeature "dynamodb" }}
-# Start local DynamoDB
-echo
-echo "Starting local DynamoDB..."
-if command -v docker &> /dev/null; then
-    docker-compose up -d dynamodb-local
-    echo -e "${GREEN}✓ Local DynamoDB started on port 8000${NC}"
-    
-    # Create tables
-    echo "Creating DynamoDB tables..."
-    # Add table creation commands here
-else
-    echo -e "${YELLOW}⚠️  Docker not available, skipping local DynamoDB setup${NC}"
-fi
-{{- end }}
-
-{{- if .HasFeature "sqs" }}
-# Start LocalStack for SQS
+{{- if or (.HasFeature "dynamodb") (.HasFeature "sqs") (.HasFeature "s3") }}
+# Start the local AWS stack
 echo
-echo "Starting LocalStack..."
+echo "Starting local AWS stack..."
 if command -v docker &> /dev/null; then
-    docker-compose up -d localstack
-    echo -e "${GREEN}✓ LocalStack started on port 4566${NC}"
-    
-    # Create queues
-    echo "Creating SQS queues..."
-    aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name {{.Name}}-messages --region us-east-1
-    aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name {{.Name}}-messages-dlq --region us-east-1
-    echo -e "${GREEN}✓ SQS queues created${NC}"
+    docker-compose up -d
+    {{- if .HasFeature "dynamodb" }}
+    echo -e "${GREEN}✓ DynamoDB Local started on port 8000${NC}"
+    {{- end }}
+    {{- if .HasFeature "sqs" }}
+    echo -e "${GREEN}✓ ElasticMQ started on port 9324${NC}"
+    {{- end }}
+    {{- if .HasFeature "s3" }}
+    echo -e "${GREEN}✓ MinIO started on port 9000${NC}"
+    {{- end }}
+
+    # Create tables, queues and buckets
+    echo "Creating local resources..."
+    go run ./scripts/bootstrap
+    echo -e "${GREEN}✓ Local resources created${NC}"
 else
-    echo -e "${YELLOW}⚠️  Docker not available, skipping LocalStack setup${NC}"
+    echo -e "${YELLOW}⚠️  Docker not available, skipping local AWS stack setup${NC}"
 fi
 {{- end }}
 
@@ -1066,32 +1126,39 @@ const TestUtils = `package testutils
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+{{- if eq .TestingFramework "ginkgo" }}
+
+	"github.com/aws/aws-lambda-go/events"
+	. "github.com/onsi/gomega"
+{{- else if eq .TestingFramework "standard" }}
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+{{- else }}
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/stretchr/testify/require"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
 )
 
-// CreateAPIGatewayRequest creates a test API Gateway request
-func CreateAPIGatewayRequest(method, path string, body interface{}) events.APIGatewayProxyRequest {
+// CreateAPIRequest creates a test request as the API event source
+// ({{.APIType}}) sends it; path may include a query string
+func CreateAPIRequest(method, path string, body interface{}) apievent.Request {
 	var bodyStr string
 	if body != nil {
 		bodyBytes, _ := json.Marshal(body)
 		bodyStr = string(bodyBytes)
 	}
 
-	return events.APIGatewayProxyRequest{
-		HTTPMethod: method,
-		Path:       path,
-		Body:       bodyStr,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		RequestContext: events.APIGatewayProxyRequestContext{
-			RequestID: "test-request-id",
-			Stage:     "test",
-		},
-	}
+	request := apievent.NewRequest(method, path, bodyStr)
+	request.Headers["Content-Type"] = "application/json"
+	return request
 }
 
 // CreateSQSEvent creates a test SQS event
@@ -1146,8 +1213,68 @@ func CreateS3Event(bucket, key, eventName string) events.S3Event {
 	}
 }
 
-// AssertAPIResponse asserts API Gateway response
-func AssertAPIResponse(t *testing.T, response events.APIGatewayProxyResponse, expectedStatus int, expectedBody interface{}) {
+// TestContext creates a test context with common values
+func TestContext() context.Context {
+	ctx := context.Background()
+	// Add common test context values here
+	return ctx
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+// AssertAPIResponse asserts an API response inside a Ginkgo spec
+func AssertAPIResponse(response apievent.Response, expectedStatus int, expectedBody interface{}) {
+	ExpectWithOffset(1, response.StatusCode).To(Equal(expectedStatus))
+
+	if expectedBody != nil {
+		var actualBody interface{}
+		ExpectWithOffset(1, json.Unmarshal([]byte(response.Body), &actualBody)).To(Succeed())
+		ExpectWithOffset(1, actualBody).To(Equal(expectedBody))
+	}
+}
+
+// LoadFixture loads a JSON fixture file inside a Ginkgo spec
+func LoadFixture(path string, v interface{}) {
+	data, err := os.ReadFile(path)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	ExpectWithOffset(1, json.Unmarshal(data, v)).To(Succeed())
+}
+{{- else if eq .TestingFramework "standard" }}
+
+// AssertAPIResponse asserts an API response
+func AssertAPIResponse(t testing.TB, response apievent.Response, expectedStatus int, expectedBody interface{}) {
+	t.Helper()
+
+	if response.StatusCode != expectedStatus {
+		t.Fatalf("StatusCode = %d, want %d (body: %s)", response.StatusCode, expectedStatus, response.Body)
+	}
+
+	if expectedBody != nil {
+		var actualBody interface{}
+		if err := json.Unmarshal([]byte(response.Body), &actualBody); err != nil {
+			t.Fatalf("failed to unmarshal response body: %v", err)
+		}
+		if !reflect.DeepEqual(expectedBody, actualBody) {
+			t.Fatalf("Body = %#v, want %#v", actualBody, expectedBody)
+		}
+	}
+}
+
+// LoadFixture loads a JSON fixture file
+func LoadFixture(t testing.TB, path string, v interface{}) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("failed to unmarshal fixture %s: %v", path, err)
+	}
+}
+{{- else }}
+
+// AssertAPIResponse asserts an API response
+func AssertAPIResponse(t *testing.T, response apievent.Response, expectedStatus int, expectedBody interface{}) {
 	require.Equal(t, expectedStatus, response.StatusCode)
 
 	if expectedBody != nil {
@@ -1158,13 +1285,6 @@ func AssertAPIResponse(t *testing.T, response events.APIGatewayProxyResponse, ex
 	}
 }
 
-// TestContext creates a test context with common values
-func TestContext() context.Context {
-	ctx := context.Background()
-	// Add common test context values here
-	return ctx
-}
-
 // LoadFixture loads a JSON fixture file
 func LoadFixture(t *testing.T, path string, v interface{}) {
 	data, err := os.ReadFile(path)
@@ -1172,4 +1292,373 @@ func LoadFixture(t *testing.T, path string, v interface{}) {
 	err = json.Unmarshal(data, v)
 	require.NoError(t, err)
 }
-`
\ No newline at end of file
+{{- end }}
+`
+
+const LocalBootstrap = `// Command bootstrap creates the DynamoDB tables, SQS queues and S3 buckets
+// from the deployment template in the local docker-compose stack.
+//
+//	docker-compose up -d
+//	go run ./scripts/bootstrap
+//
+// It only talks to the endpoints configured in .env.local and is safe to
+// run repeatedly.
+package main
+
+import (
+	"context"
+{{- if .HasFeature "sqs" }}
+	"encoding/json"
+{{- end }}
+	"errors"
+	"fmt"
+	"os"
+{{- if .HasFeature "sqs" }}
+	"path"
+{{- end }}
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+{{- if .HasFeature "dynamodb" }}
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+{{- end }}
+{{- if .HasFeature "s3" }}
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+{{- end }}
+{{- if .HasFeature "sqs" }}
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+{{- end }}
+{{ if eq .Architecture "clean" }}
+	"{{.Module}}/internal/infrastructure/config"
+{{- else if eq .Architecture "simple" }}
+	"{{.Module}}/config"
+{{- else }}
+	"{{.Module}}/infrastructure/config"
+{{- end }}
+)
+
+func main() {
+	if err := run(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "bootstrap failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+{{- if .HasFeature "dynamodb" }}
+
+	if err := createTables(ctx, awsCfg, cfg); err != nil {
+		return err
+	}
+{{- end }}
+{{- if .HasFeature "sqs" }}
+
+	if err := createQueues(ctx, awsCfg, cfg); err != nil {
+		return err
+	}
+{{- end }}
+{{- if .HasFeature "s3" }}
+
+	if err := createBuckets(ctx, awsCfg, cfg); err != nil {
+		return err
+	}
+{{- end }}
+
+	fmt.Println("Local resources are ready")
+	return nil
+}
+
+// requireEndpoint guards against creating resources in a real AWS account
+func requireEndpoint(name, endpoint string) error {
+	if endpoint == "" {
+		return fmt.Errorf("%s is not set; refusing to create resources outside the local stack", name)
+	}
+	return nil
+}
+{{- if .HasFeature "dynamodb" }}
+
+// createTables mirrors the UserTable resource in the deployment template.
+// Both are generated from access-patterns.yaml.
+{{- if eq .Architecture "ddd" }} Its TTL expires outbox items, and the
+// event store table is created alongside it.
+{{- else if eq .Architecture "clean" }} The idempotency table is created
+// alongside it.
+{{- end }}
+func createTables(ctx context.Context, awsCfg aws.Config, cfg *config.Config) error {
+	if err := requireEndpoint("DYNAMODB_ENDPOINT", cfg.DynamoDBEndpoint); err != nil {
+		return err
+	}
+
+	client := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+	})
+{{ if eq .Architecture "clean" }}
+	tableName := cfg.DynamoDBTablePrefix + "{{.DataModel.Table.Name}}"
+{{- else }}
+	tableName := cfg.DynamoDBTableName
+{{- end }}
+
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: dynamodbtypes.BillingModePayPerRequest,
+		AttributeDefinitions: []dynamodbtypes.AttributeDefinition{
+{{- range .DataModel.KeyAttributes }}
+			{AttributeName: aws.String("{{ . }}"), AttributeType: dynamodbtypes.ScalarAttributeTypeS},
+{{- end }}
+		},
+		KeySchema: []dynamodbtypes.KeySchemaElement{
+			{AttributeName: aws.String("{{ .DataModel.Table.PartitionKey }}"), KeyType: dynamodbtypes.KeyTypeHash},
+{{- if .DataModel.Table.SortKey }}
+			{AttributeName: aws.String("{{ .DataModel.Table.SortKey }}"), KeyType: dynamodbtypes.KeyTypeRange},
+{{- end }}
+		},
+{{- if .DataModel.Table.Indexes }}
+		GlobalSecondaryIndexes: []dynamodbtypes.GlobalSecondaryIndex{
+{{- range .DataModel.Table.Indexes }}
+			{
+				IndexName: aws.String("{{ .Name }}"),
+				KeySchema: []dynamodbtypes.KeySchemaElement{
+					{AttributeName: aws.String("{{ .PartitionKey }}"), KeyType: dynamodbtypes.KeyTypeHash},
+{{- if .SortKey }}
+					{AttributeName: aws.String("{{ .SortKey }}"), KeyType: dynamodbtypes.KeyTypeRange},
+{{- end }}
+				},
+				Projection: &dynamodbtypes.Projection{ProjectionType: dynamodbtypes.ProjectionTypeAll},
+			},
+{{- end }}
+		},
+{{- end }}
+		StreamSpecification: &dynamodbtypes.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: dynamodbtypes.StreamViewTypeNewAndOldImages,
+		},
+	})
+
+	var inUse *dynamodbtypes.ResourceInUseException
+	switch {
+	case errors.As(err, &inUse):
+		fmt.Printf("Table %s already exists\n", tableName)
+	case err != nil:
+		return fmt.Errorf("failed to create table %s: %w", tableName, err)
+	default:
+		fmt.Printf("Created table %s\n", tableName)
+	}
+{{- if eq .Architecture "ddd" }}
+
+	// Outbox items expire through the table's TTL, as in the deployment
+	if err == nil {
+		_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+			TableName: aws.String(tableName),
+			TimeToLiveSpecification: &dynamodbtypes.TimeToLiveSpecification{
+				AttributeName: aws.String("expires_at"),
+				Enabled:       aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to enable TTL on %s: %w", tableName, err)
+		}
+	}
+
+	return createEventStoreTable(ctx, client, cfg.EventStoreTableName)
+}
+
+// createEventStoreTable mirrors the EventStoreTable resource in the
+// deployment template
+func createEventStoreTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: dynamodbtypes.BillingModePayPerRequest,
+		AttributeDefinitions: []dynamodbtypes.AttributeDefinition{
+			{AttributeName: aws.String("stream_id"), AttributeType: dynamodbtypes.ScalarAttributeTypeS},
+			{AttributeName: aws.String("version"), AttributeType: dynamodbtypes.ScalarAttributeTypeN},
+			{AttributeName: aws.String("day"), AttributeType: dynamodbtypes.ScalarAttributeTypeS},
+			{AttributeName: aws.String("occurred_at"), AttributeType: dynamodbtypes.ScalarAttributeTypeS},
+		},
+		KeySchema: []dynamodbtypes.KeySchemaElement{
+			{AttributeName: aws.String("stream_id"), KeyType: dynamodbtypes.KeyTypeHash},
+			{AttributeName: aws.String("version"), KeyType: dynamodbtypes.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []dynamodbtypes.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("events-by-day"),
+				KeySchema: []dynamodbtypes.KeySchemaElement{
+					{AttributeName: aws.String("day"), KeyType: dynamodbtypes.KeyTypeHash},
+					{AttributeName: aws.String("occurred_at"), KeyType: dynamodbtypes.KeyTypeRange},
+				},
+				Projection: &dynamodbtypes.Projection{ProjectionType: dynamodbtypes.ProjectionTypeAll},
+			},
+		},
+	})
+
+	var inUse *dynamodbtypes.ResourceInUseException
+	switch {
+	case errors.As(err, &inUse):
+		fmt.Printf("Table %s already exists\n", tableName)
+	case err != nil:
+		return fmt.Errorf("failed to create table %s: %w", tableName, err)
+	default:
+		fmt.Printf("Created table %s\n", tableName)
+	}
+{{- else if eq .Architecture "clean" }}
+
+	return createIdempotencyTable(ctx, client, cfg.IdempotencyTableName)
+}
+
+// createIdempotencyTable mirrors the IdempotencyTable resource in the
+// deployment template, including its TTL attribute
+func createIdempotencyTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: dynamodbtypes.BillingModePayPerRequest,
+		AttributeDefinitions: []dynamodbtypes.AttributeDefinition{
+			{AttributeName: aws.String("id"), AttributeType: dynamodbtypes.ScalarAttributeTypeS},
+		},
+		KeySchema: []dynamodbtypes.KeySchemaElement{
+			{AttributeName: aws.String("id"), KeyType: dynamodbtypes.KeyTypeHash},
+		},
+	})
+
+	var inUse *dynamodbtypes.ResourceInUseException
+	switch {
+	case errors.As(err, &inUse):
+		fmt.Printf("Table %s already exists\n", tableName)
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to create table %s: %w", tableName, err)
+	default:
+		fmt.Printf("Created table %s\n", tableName)
+	}
+
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &dynamodbtypes.TimeToLiveSpecification{
+			AttributeName: aws.String("expires_at"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable TTL on %s: %w", tableName, err)
+	}
+{{- end }}
+
+	return nil
+}
+{{- end }}
+{{- if .HasFeature "sqs" }}
+
+// createQueues mirrors the MessageQueue and DeadLetterQueue resources in the
+// deployment template. Queue names are taken from the configured URLs.
+func createQueues(ctx context.Context, awsCfg aws.Config, cfg *config.Config) error {
+	if err := requireEndpoint("SQS_ENDPOINT", cfg.SQSEndpoint); err != nil {
+		return err
+	}
+	if cfg.SQSQueueURL == "" {
+		return errors.New("SQS_QUEUE_URL is not set")
+	}
+
+	client := sqs.NewFromConfig(awsCfg, func(o *sqs.Options) {
+		o.BaseEndpoint = aws.String(cfg.SQSEndpoint)
+	})
+
+	queueName := path.Base(cfg.SQSQueueURL)
+	dlqName := queueName + "-dlq"
+	if cfg.SQSDLQueueURL != "" {
+		dlqName = path.Base(cfg.SQSDLQueueURL)
+	}
+
+	dlq, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(dlqName),
+		Attributes: map[string]string{
+			string(sqstypes.QueueAttributeNameMessageRetentionPeriod): "1209600",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create queue %s: %w", dlqName, err)
+	}
+	fmt.Printf("Queue %s ready at %s\n", dlqName, aws.ToString(dlq.QueueUrl))
+
+	attrs, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       dlq.QueueUrl,
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read attributes of %s: %w", dlqName, err)
+	}
+
+	redrivePolicy, err := json.Marshal(map[string]string{
+		"deadLetterTargetArn": attrs.Attributes[string(sqstypes.QueueAttributeNameQueueArn)],
+		"maxReceiveCount":     "3",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal redrive policy: %w", err)
+	}
+
+	queue, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(queueName),
+		Attributes: map[string]string{
+			string(sqstypes.QueueAttributeNameVisibilityTimeout):      "180",
+			string(sqstypes.QueueAttributeNameMessageRetentionPeriod): "1209600",
+			string(sqstypes.QueueAttributeNameRedrivePolicy):          string(redrivePolicy),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create queue %s: %w", queueName, err)
+	}
+	fmt.Printf("Queue %s ready at %s\n", queueName, aws.ToString(queue.QueueUrl))
+
+	return nil
+}
+{{- end }}
+{{- if .HasFeature "s3" }}
+
+// createBuckets mirrors the StorageBucket resource in the deployment template
+func createBuckets(ctx context.Context, awsCfg aws.Config, cfg *config.Config) error {
+	if err := requireEndpoint("S3_ENDPOINT", cfg.S3Endpoint); err != nil {
+		return err
+	}
+	if cfg.S3BucketName == "" {
+		return errors.New("S3_BUCKET_NAME is not set")
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+		// MinIO serves buckets by path rather than by subdomain
+		o.UsePathStyle = true
+	})
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(cfg.S3BucketName)}
+	if cfg.AWSRegion != "us-east-1" {
+		input.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(cfg.AWSRegion),
+		}
+	}
+
+	_, err := client.CreateBucket(ctx, input)
+
+	var owned *s3types.BucketAlreadyOwnedByYou
+	var exists *s3types.BucketAlreadyExists
+	switch {
+	case errors.As(err, &owned), errors.As(err, &exists):
+		fmt.Printf("Bucket %s already exists\n", cfg.S3BucketName)
+	case err != nil:
+		return fmt.Errorf("failed to create bucket %s: %w", cfg.S3BucketName, err)
+	default:
+		fmt.Printf("Created bucket %s\n", cfg.S3BucketName)
+	}
+
+	return nil
+}
+{{- end }}
+`
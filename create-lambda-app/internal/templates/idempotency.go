@@ -0,0 +1,607 @@
+package templates
+
+// Idempotency templates for the middleware package
+
+const Idempotency = `package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/problem"
+)
+
+// IdempotencyKeyHeader is the request header API clients set to make a
+// request safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ReplayedHeader marks a response that was served from the idempotency store
+const ReplayedHeader = "Idempotent-Replayed"
+
+// RecordStatus is the state of an idempotency record
+type RecordStatus string
+
+const (
+	// StatusInProgress marks a key whose handler is still running
+	StatusInProgress RecordStatus = "IN_PROGRESS"
+
+	// StatusCompleted marks a key whose response has been stored
+	StatusCompleted RecordStatus = "COMPLETED"
+)
+
+var (
+	// ErrRecordExists is returned by IdempotencyStore.Begin when the key
+	// already has a live record
+	ErrRecordExists = errors.New("idempotency record already exists")
+
+	// ErrInProgress is returned for a duplicate that arrives while the first
+	// call with the same key is still running
+	ErrInProgress = errors.New("a call with this idempotency key is already in progress")
+
+	// ErrPayloadMismatch is returned when a key is reused for a different request
+	ErrPayloadMismatch = errors.New("idempotency key was already used for a different request")
+)
+
+// IdempotencyRecord is what an IdempotencyStore keeps for one key
+type IdempotencyRecord struct {
+	Key         string
+	Status      RecordStatus
+	PayloadHash string
+	// Response is the serialized result of a completed call
+	Response []byte
+	// LockExpiresAt bounds how long an in-progress record blocks duplicates,
+	// so an invocation that crashed does not hold the key until ExpiresAt
+	LockExpiresAt time.Time
+	// ExpiresAt is when the record is forgotten
+	ExpiresAt time.Time
+}
+
+// live reports whether the record still answers duplicates at now
+func (r IdempotencyRecord) live(now time.Time) bool {
+	if !now.Before(r.ExpiresAt) {
+		return false
+	}
+	return r.Status == StatusCompleted || now.Before(r.LockExpiresAt)
+}
+
+// IdempotencyStore persists idempotency records
+type IdempotencyStore interface {
+	// Begin saves record unless its key already has a record that is live at
+	// now; in that case it returns the existing record and ErrRecordExists.
+	Begin(ctx context.Context, record IdempotencyRecord, now time.Time) (*IdempotencyRecord, error)
+
+	// Complete stores the response for key and marks the record completed
+	Complete(ctx context.Context, key string, response []byte, expiresAt time.Time) error
+
+	// Release deletes the record for key so the call can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyConfig configures the idempotency middleware
+type IdempotencyConfig struct {
+	// Store keeps the records: a DynamoDBIdempotencyStore in Lambda, an
+	// InMemoryIdempotencyStore in tests
+	Store IdempotencyStore
+
+	// TTL is how long a completed response is replayed (default 24h)
+	TTL time.Duration
+
+	// LockTimeout is how long an in-progress call blocks duplicates
+	// (default 15m, Lambda's maximum). The lock expires at the deadline of
+	// the call's context when that comes first, so a retry of an invocation
+	// that crashed or timed out is not blocked.
+	LockTimeout time.Duration
+
+	// KeyPath is the dot-separated path of the key in an event payload, for
+	// example "detail.order_id" or "items.0.id"; a leading "$." is ignored.
+	// An empty path keys on the whole payload. Only used by IdempotentEvent.
+	KeyPath string
+
+	// Now returns the current time (default time.Now)
+	Now func() time.Time
+}
+
+func (c IdempotencyConfig) withDefaults() IdempotencyConfig {
+	if c.TTL <= 0 {
+		c.TTL = 24 * time.Hour
+	}
+	if c.LockTimeout <= 0 {
+		c.LockTimeout = 15 * time.Minute
+	}
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	return c
+}
+
+// do runs fn at most once per key. A duplicate of a completed call gets the
+// stored response back with replayed set; a duplicate of a running call gets
+// ErrInProgress. fn reports whether its response may be stored; if not, the
+// key is released so the call can be retried.
+func (c IdempotencyConfig) do(ctx context.Context, key, payloadHash string, fn func(context.Context) ([]byte, bool)) (response []byte, replayed bool, err error) {
+	now := c.Now()
+	lockExpiresAt := now.Add(c.LockTimeout)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(lockExpiresAt) {
+		lockExpiresAt = deadline
+	}
+
+	existing, err := c.Store.Begin(ctx, IdempotencyRecord{
+		Key:           key,
+		Status:        StatusInProgress,
+		PayloadHash:   payloadHash,
+		LockExpiresAt: lockExpiresAt,
+		ExpiresAt:     now.Add(c.TTL),
+	}, now)
+	switch {
+	case errors.Is(err, ErrRecordExists):
+		if payloadHash != "" && existing.PayloadHash != payloadHash {
+			return nil, false, ErrPayloadMismatch
+		}
+		if existing.Status != StatusCompleted {
+			return nil, false, ErrInProgress
+		}
+		return existing.Response, true, nil
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to begin idempotent call: %w", err)
+	}
+
+	response, ok := fn(ctx)
+	if !ok {
+		if err := c.Store.Release(ctx, key); err != nil {
+			contextLogger(ctx).Warn().
+				Err(err).
+				Str("idempotency_key", key).
+				Msg("Failed to release idempotency record")
+		}
+		return response, false, nil
+	}
+
+	// The call already happened; failing to record it only weakens the
+	// guarantee for later duplicates, so the response is still returned
+	if err := c.Store.Complete(ctx, key, response, c.Now().Add(c.TTL)); err != nil {
+		contextLogger(ctx).Warn().
+			Err(err).
+			Str("idempotency_key", key).
+			Msg("Failed to store idempotent response")
+	}
+	return response, false, nil
+}
+
+// Idempotency replays the stored response when a request repeats an
+// Idempotency-Key header. Keys are scoped to the caller, as identified by
+// the UserID middleware or the X-Api-Key header, and to the method and
+// path, so clients that pick the same key never see each other's
+// responses. Reusing a key for a different body gets 422, and a duplicate
+// of a request that is still running gets 409. Errors and 5xx responses
+// are not stored, so the client can retry them with the same key. Requests
+// without the header pass through.
+func Idempotency(cfg IdempotencyConfig) APIMiddleware {
+	cfg = cfg.withDefaults()
+
+	return func(next APIHandlerFunc) APIHandlerFunc {
+		return func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+			key := apievent.Header(request, IdempotencyKeyHeader)
+			if key == "" {
+				return next(ctx, request)
+			}
+
+			var (
+				response   apievent.Response
+				handlerErr error
+			)
+			scoped := "api#" + hashParts(idempotencyCaller(ctx, request), apievent.Method(request), apievent.Path(request), key)
+			stored, replayed, err := cfg.do(ctx, scoped, hashParts(request.Body), func(ctx context.Context) ([]byte, bool) {
+				response, handlerErr = next(ctx, request)
+				if handlerErr != nil || response.StatusCode >= http.StatusInternalServerError {
+					return nil, false
+				}
+				body, err := json.Marshal(response)
+				return body, err == nil
+			})
+
+			switch {
+			case errors.Is(err, ErrPayloadMismatch):
+				return problem.Response(ctx, request, problem.New(problem.TypeUnprocessable, err.Error())), nil
+			case errors.Is(err, ErrInProgress):
+				return problem.Response(ctx, request, problem.New(problem.TypeConflict, err.Error())), nil
+			case err != nil:
+				contextLogger(ctx).Error().Err(err).Msg("Idempotency store unavailable")
+				return problem.Response(ctx, request, problem.Internal()), nil
+			case !replayed:
+				return response, handlerErr
+			}
+
+			var cached apievent.Response
+			if err := json.Unmarshal(stored, &cached); err != nil {
+				contextLogger(ctx).Error().Err(err).Msg("Failed to decode stored response")
+				return problem.Response(ctx, request, problem.Internal()), nil
+			}
+			if cached.Headers == nil {
+				cached.Headers = make(map[string]string)
+			}
+			cached.Headers[ReplayedHeader] = "true"
+
+			return cached, nil
+		}
+	}
+}
+
+// idempotencyCaller returns who sent request, or "" for an anonymous caller
+func idempotencyCaller(ctx context.Context, request apievent.Request) string {
+	if userID := GetUserID(ctx); userID != "" {
+		return "user#" + userID
+	}
+	if apiKey := APIKey(ctx, request); apiKey != "" {
+		return "api_key#" + apiKey
+	}
+	return ""
+}
+
+// EventHandlerFunc processes one event payload, such as an SQS message body
+// or an EventBridge detail, and returns its result
+type EventHandlerFunc = HandlerFunc[json.RawMessage, json.RawMessage]
+
+// IdempotentEvent runs next at most once per value found at cfg.KeyPath in
+// the payload; the rest of the payload is not compared. A duplicate of a
+// completed event returns the stored result without calling next, and a
+// duplicate of a running event fails with ErrInProgress so its source
+// retries it later. A failed call is released for retry. Payloads without
+// the key are processed normally.
+func IdempotentEvent(cfg IdempotencyConfig, next EventHandlerFunc) EventHandlerFunc {
+	cfg = cfg.withDefaults()
+
+	return func(ctx context.Context, payload json.RawMessage) (json.RawMessage, error) {
+		value, ok := valueAtPath(payload, cfg.KeyPath)
+		if !ok {
+			contextLogger(ctx).Warn().
+				Str("key_path", cfg.KeyPath).
+				Msg("Idempotency key not found in payload")
+			return next(ctx, payload)
+		}
+
+		var (
+			result     json.RawMessage
+			handlerErr error
+		)
+		stored, replayed, err := cfg.do(ctx, "event#"+hashParts(cfg.KeyPath, value), "", func(ctx context.Context) ([]byte, bool) {
+			result, handlerErr = next(ctx, payload)
+			return result, handlerErr == nil
+		})
+		switch {
+		case err != nil:
+			return nil, err
+		case replayed:
+			return stored, nil
+		}
+
+		return result, handlerErr
+	}
+}
+
+// valueAtPath returns the JSON value at a dot-separated path; numeric
+// segments index into arrays. Strings are returned unquoted.
+func valueAtPath(payload json.RawMessage, path string) (string, bool) {
+	path = strings.TrimPrefix(path, "$.")
+	if path == "" {
+		return string(payload), len(payload) > 0
+	}
+
+	var current interface{}
+	if err := json.Unmarshal(payload, &current); err != nil {
+		return "", false
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			current = node[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", false
+			}
+			current = node[index]
+		default:
+			return "", false
+		}
+	}
+
+	switch value := current.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, value != ""
+	default:
+		encoded, err := json.Marshal(value)
+		return string(encoded), err == nil
+	}
+}
+
+// hashParts hashes the parts so keys stay short whatever the input
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+`
+
+const IdempotencyMemoryStore = `package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxInMemoryRecords is how many records an InMemoryIdempotencyStore holds
+// before it drops the expired ones
+const maxInMemoryRecords = 10000
+
+// inMemorySweepInterval is how often Begin drops expired records when the
+// store is below maxInMemoryRecords
+const inMemorySweepInterval = time.Minute
+
+// InMemoryIdempotencyStore is an IdempotencyStore for tests. Each container
+// keeps its own records, so a retry served by another container runs again;
+// use a DynamoDBIdempotencyStore wherever that matters.
+type InMemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+	sweptAt time.Time
+}
+
+// NewInMemoryIdempotencyStore creates an empty in-memory store
+func NewInMemoryIdempotencyStore() *InMemoryIdempotencyStore {
+	return &InMemoryIdempotencyStore{
+		records: make(map[string]IdempotencyRecord),
+	}
+}
+
+// Begin implements IdempotencyStore
+func (s *InMemoryIdempotencyStore) Begin(ctx context.Context, record IdempotencyRecord, now time.Time) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[record.Key]; ok && existing.live(now) {
+		return &existing, ErrRecordExists
+	}
+
+	if len(s.records) >= maxInMemoryRecords || !now.Before(s.sweptAt.Add(inMemorySweepInterval)) {
+		s.dropExpired(now)
+	}
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+// dropExpired forgets the records that no longer answer duplicates; Begin
+// treats those as missing, so this changes no outcome
+func (s *InMemoryIdempotencyStore) dropExpired(now time.Time) {
+	for key, record := range s.records {
+		if !record.live(now) {
+			delete(s.records, key)
+		}
+	}
+	s.sweptAt = now
+}
+
+// Complete implements IdempotencyStore
+func (s *InMemoryIdempotencyStore) Complete(ctx context.Context, key string, response []byte, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return fmt.Errorf("no idempotency record for key %q", key)
+	}
+
+	record.Status = StatusCompleted
+	record.Response = append([]byte(nil), response...)
+	record.ExpiresAt = expiresAt
+	s.records[key] = record
+
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (s *InMemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// Len returns the number of records held, including expired ones that
+// were not dropped yet
+func (s *InMemoryIdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.records)
+}
+`
+
+const IdempotencyDynamoDBStore = `package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// IdempotencyDynamoDBAPI is the subset of the DynamoDB client used by
+// DynamoDBIdempotencyStore
+type IdempotencyDynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// DynamoDBIdempotencyStore keeps idempotency records in a table keyed on
+// "id". The table's TTL attribute is "expires_at"; since TTL deletes lag,
+// Begin also treats expired records as absent.
+type DynamoDBIdempotencyStore struct {
+	client    IdempotencyDynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBIdempotencyStore creates a store backed by tableName
+func NewDynamoDBIdempotencyStore(client IdempotencyDynamoDBAPI, tableName string) *DynamoDBIdempotencyStore {
+	return &DynamoDBIdempotencyStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// Begin implements IdempotencyStore with a conditional put, so concurrent
+// invocations cannot both claim a key
+func (s *DynamoDBIdempotencyStore) Begin(ctx context.Context, record IdempotencyRecord, now time.Time) (*IdempotencyRecord, error) {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			"id":              &types.AttributeValueMemberS{Value: record.Key},
+			"status":          &types.AttributeValueMemberS{Value: string(record.Status)},
+			"payload_hash":    &types.AttributeValueMemberS{Value: record.PayloadHash},
+			"lock_expires_at": unixAttribute(record.LockExpiresAt),
+			"expires_at":      unixAttribute(record.ExpiresAt),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#id) OR expires_at <= :now OR (#status = :in_progress AND lock_expires_at <= :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":     "id",
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":         unixAttribute(now),
+			":in_progress": &types.AttributeValueMemberS{Value: string(StatusInProgress)},
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailed) {
+		return nil, fmt.Errorf("failed to put idempotency record: %w", err)
+	}
+
+	existing, err := s.get(ctx, record.Key)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("idempotency record %q expired while being claimed", record.Key)
+	}
+	return existing, ErrRecordExists
+}
+
+// Complete implements IdempotencyStore
+func (s *DynamoDBIdempotencyStore) Complete(ctx context.Context, key string, response []byte, expiresAt time.Time) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression:    aws.String("SET #status = :completed, response = :response, expires_at = :expires_at"),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":     "id",
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":completed":  &types.AttributeValueMemberS{Value: string(StatusCompleted)},
+			":response":   &types.AttributeValueMemberB{Value: response},
+			":expires_at": unixAttribute(expiresAt),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency record: %w", err)
+	}
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (s *DynamoDBIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency record: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBIdempotencyStore) get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	record := &IdempotencyRecord{
+		Key:           key,
+		Status:        RecordStatus(stringValue(output.Item["status"])),
+		PayloadHash:   stringValue(output.Item["payload_hash"]),
+		LockExpiresAt: unixValue(output.Item["lock_expires_at"]),
+		ExpiresAt:     unixValue(output.Item["expires_at"]),
+	}
+	if response, ok := output.Item["response"].(*types.AttributeValueMemberB); ok {
+		record.Response = response.Value
+	}
+	return record, nil
+}
+
+// unixAttribute stores t as epoch seconds, the format DynamoDB TTL expects
+func unixAttribute(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func unixValue(attr types.AttributeValue) time.Time {
+	n, ok := attr.(*types.AttributeValueMemberN)
+	if !ok {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(n.Value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func stringValue(attr types.AttributeValue) string {
+	if s, ok := attr.(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+`
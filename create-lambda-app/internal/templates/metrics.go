@@ -0,0 +1,833 @@
+package templates
+
+// Metrics templates. pkg/metrics is generated for every architecture: it
+// writes CloudWatch Embedded Metric Format (EMF) documents through the
+// zerolog logger, so Lambda's log stream carries the metrics and no API
+// calls are made.
+
+const Metrics = `// Package metrics records CloudWatch metrics in the Embedded Metric Format:
+// each flush writes one JSON document through the zerolog logger and
+// CloudWatch extracts the metrics from the function's log stream.
+//
+// Wrap collects the metrics of an invocation in a Metrics it puts in the
+// context; Add records more from anywhere the context reaches, and they are
+// flushed together when the invocation returns.
+package metrics
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Unit is the unit of a metric
+type Unit string
+
+// Units CloudWatch accepts
+const (
+	Count        Unit = "Count"
+	Milliseconds Unit = "Milliseconds"
+	Seconds      Unit = "Seconds"
+	Bytes        Unit = "Bytes"
+	Percent      Unit = "Percent"
+	None         Unit = "None"
+)
+
+const (
+	// maxMetrics is the number of metrics CloudWatch accepts per document
+	maxMetrics = 100
+
+	// maxValues is the number of values CloudWatch accepts per metric and
+	// document
+	maxValues = 100
+)
+
+// ServiceDimension is the dimension every metric has
+const ServiceDimension = "service"
+
+// Options configures Init
+type Options struct {
+	// Namespace is the CloudWatch namespace metrics are published under
+	Namespace string
+
+	// Service is the value of the service dimension
+	Service string
+
+	// Output receives the documents instead of the global logger; tests
+	// pass a Capture
+	Output io.Writer
+}
+
+var (
+	namespace = "{{.Name}}"
+	service   = "{{.Name}}"
+	output    *zerolog.Logger
+)
+
+// Init sets the namespace, service and output. Call it once at startup;
+// empty options keep their defaults.
+func Init(opts Options) {
+	if opts.Namespace != "" {
+		namespace = opts.Namespace
+	}
+	if opts.Service != "" {
+		service = opts.Service
+	}
+	output = nil
+	if opts.Output != nil {
+		logger := zerolog.New(opts.Output)
+		output = &logger
+	}
+}
+
+// Metadata is the _aws member of an EMF document
+type Metadata struct {
+	Timestamp         int64       ` + "`" + `json:"Timestamp"` + "`" + `
+	CloudWatchMetrics []Directive ` + "`" + `json:"CloudWatchMetrics"` + "`" + `
+}
+
+// Directive tells CloudWatch which members of a document are metrics and
+// which are their dimensions
+type Directive struct {
+	Namespace  string             ` + "`" + `json:"Namespace"` + "`" + `
+	Dimensions [][]string         ` + "`" + `json:"Dimensions"` + "`" + `
+	Metrics    []MetricDefinition ` + "`" + `json:"Metrics"` + "`" + `
+}
+
+// MetricDefinition names a metric of a document
+type MetricDefinition struct {
+	Name string ` + "`" + `json:"Name"` + "`" + `
+	Unit Unit   ` + "`" + `json:"Unit"` + "`" + `
+}
+
+// Metrics collects metrics until they are flushed as one document. It is
+// safe for concurrent use.
+type Metrics struct {
+	mu         sync.Mutex
+	dimensions []dimension
+	names      []string
+	metrics    map[string]*metric
+	properties map[string]interface{}
+}
+
+type dimension struct {
+	name  string
+	value string
+}
+
+type metric struct {
+	unit   Unit
+	values []float64
+}
+
+// New creates an empty Metrics with the service dimension
+func New() *Metrics {
+	serviceDimension := dimension{name: ServiceDimension, value: service}
+	return &Metrics{
+		dimensions: []dimension{serviceDimension},
+		metrics:    make(map[string]*metric),
+		properties: make(map[string]interface{}),
+	}
+}
+
+// AddDimension adds a dimension to every metric of the document. CloudWatch
+// treats each combination of dimension values as its own metric, so keep
+// their values few.
+func (m *Metrics) AddDimension(name, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, d := range m.dimensions {
+		if d.name == name {
+			m.dimensions[i].value = value
+			return
+		}
+	}
+	m.dimensions = append(m.dimensions, dimension{name: name, value: value})
+}
+
+// AddProperty adds a member that is searchable in the logs but is not a
+// metric, such as a request ID
+func (m *Metrics) AddProperty(name string, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.properties[name] = value
+}
+
+// Add records a value of metric name. Values of the same metric are
+// published together; adding one past the CloudWatch limits flushes first.
+func (m *Metrics) Add(name string, unit Unit, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.metrics[name]
+	if (!ok && len(m.names) == maxMetrics) || (ok && len(existing.values) == maxValues) {
+		m.flushLocked()
+		existing, ok = nil, false
+	}
+	if !ok {
+		existing = &metric{unit: unit}
+		m.metrics[name] = existing
+		m.names = append(m.names, name)
+	}
+	existing.values = append(existing.values, value)
+}
+
+// Flush writes the recorded metrics as an EMF document and clears them.
+// Dimensions and properties are kept.
+func (m *Metrics) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flushLocked()
+}
+
+func (m *Metrics) flushLocked() {
+	if len(m.names) == 0 {
+		return
+	}
+
+	logger := log.Logger
+	if output != nil {
+		logger = *output
+	}
+	logger.Log().Fields(m.document(time.Now())).Send()
+
+	m.names = nil
+	m.metrics = make(map[string]*metric)
+}
+
+// document builds the EMF document of the recorded metrics
+func (m *Metrics) document(timestamp time.Time) map[string]interface{} {
+	doc := make(map[string]interface{}, len(m.properties)+len(m.dimensions)+len(m.names)+1)
+	for name, value := range m.properties {
+		doc[name] = value
+	}
+
+	dimensionNames := make([]string, 0, len(m.dimensions))
+	for _, d := range m.dimensions {
+		dimensionNames = append(dimensionNames, d.name)
+		doc[d.name] = d.value
+	}
+
+	definitions := make([]MetricDefinition, 0, len(m.names))
+	for _, name := range m.names {
+		metric := m.metrics[name]
+		definitions = append(definitions, MetricDefinition{Name: name, Unit: metric.unit})
+		if len(metric.values) == 1 {
+			doc[name] = metric.values[0]
+		} else {
+			doc[name] = metric.values
+		}
+	}
+
+	directive := Directive{
+		Namespace:  namespace,
+		Dimensions: [][]string{dimensionNames},
+		Metrics:    definitions,
+	}
+	doc["_aws"] = Metadata{
+		Timestamp:         timestamp.UnixMilli(),
+		CloudWatchMetrics: []Directive{directive},
+	}
+	return doc
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying m
+func NewContext(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns the Metrics of the invocation of ctx, or nil
+func FromContext(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(contextKey{}).(*Metrics)
+	return m
+}
+
+// Add records a value of metric name for the invocation of ctx. Outside an
+// invocation the value is written right away.
+func Add(ctx context.Context, name string, unit Unit, value float64) {
+	if m := FromContext(ctx); m != nil {
+		m.Add(name, unit, value)
+		return
+	}
+
+	m := New()
+	m.Add(name, unit, value)
+	m.Flush()
+}
+`
+
+const MetricsInvocation = `package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+
+	"{{.Module}}/pkg/apievent"
+)
+
+var warm atomic.Bool
+
+// Wrap records the metrics of every invocation of handler and flushes them
+// when it returns:
+//
+//   - Latency, Errors and ColdStart for every handler
+//   - Status2xx, Status4xx, Status5xx and so on for API handlers
+//   - Processed and Failed record counts for SQS and DynamoDB Stream batches
+//
+// The handler records its own with Add and the context it is given.
+func Wrap[In, Out any](handler func(ctx context.Context, in In) (Out, error)) func(ctx context.Context, in In) (Out, error) {
+	return func(ctx context.Context, in In) (out Out, err error) {
+		m := New()
+		ctx = NewContext(ctx, m)
+		if lc, ok := lambdacontext.FromContext(ctx); ok {
+			m.AddProperty("request_id", lc.AwsRequestID)
+		}
+		if !warm.Swap(true) {
+			m.Add("ColdStart", Count, 1)
+		}
+
+		start := time.Now()
+		defer func() {
+			m.Add("Latency", Milliseconds, float64(time.Since(start).Microseconds())/1000)
+			recordOutcome(m, in, out, err)
+			m.Flush()
+		}()
+
+		return handler(ctx, in)
+	}
+}
+
+// recordOutcome records what an invocation returned
+func recordOutcome(m *Metrics, in, out any, err error) {
+	failed := err != nil
+
+	switch response := out.(type) {
+	case apievent.Response:
+		if err == nil {
+			m.Add(fmt.Sprintf("Status%dxx", response.StatusCode/100), Count, 1)
+			failed = response.StatusCode >= http.StatusInternalServerError
+		}
+	case events.SQSEventResponse:
+		recordBatch(m, in, len(response.BatchItemFailures), err)
+	case events.DynamoDBEventResponse:
+		recordBatch(m, in, len(response.BatchItemFailures), err)
+	}
+
+	errors := 0.0
+	if failed {
+		errors = 1
+	}
+	m.Add("Errors", Count, errors)
+}
+
+// recordBatch records how many records of a batch were processed. When the
+// handler fails the whole batch is retried, so every record failed.
+func recordBatch(m *Metrics, in any, failures int, err error) {
+	var records int
+	switch event := in.(type) {
+	case events.SQSEvent:
+		records = len(event.Records)
+	case events.DynamoDBEvent:
+		records = len(event.Records)
+	}
+	if err != nil {
+		failures = records
+	}
+
+	m.Add("Processed", Count, float64(records-failures))
+	m.Add("Failed", Count, float64(failures))
+}
+`
+
+const MetricsCapture = `package metrics
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Capture keeps the documents written to it. Pass it as Options.Output in
+// tests to check the metrics code records.
+type Capture struct {
+	mu        sync.Mutex
+	documents []Document
+}
+
+// Write decodes one document
+func (c *Capture) Write(p []byte) (int, error) {
+	var doc Document
+	if err := json.Unmarshal(p, &doc); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.documents = append(c.documents, doc)
+	return len(p), nil
+}
+
+// Documents returns the documents written so far
+func (c *Capture) Documents() []Document {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Document(nil), c.documents...)
+}
+
+// Document is a decoded EMF document
+type Document struct {
+	Metadata Metadata
+
+	// Members holds the dimension, metric and property values by name
+	Members map[string]interface{}
+}
+
+// UnmarshalJSON decodes an EMF document
+func (d *Document) UnmarshalJSON(data []byte) error {
+	var envelope struct {
+		Metadata Metadata ` + "`" + `json:"_aws"` + "`" + `
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+	d.Metadata = envelope.Metadata
+	return json.Unmarshal(data, &d.Members)
+}
+
+// Namespace returns the namespace of the document's metrics
+func (d Document) Namespace() string {
+	if len(d.Metadata.CloudWatchMetrics) == 0 {
+		return ""
+	}
+	return d.Metadata.CloudWatchMetrics[0].Namespace
+}
+
+// Dimensions returns the dimension names of the document's metrics
+func (d Document) Dimensions() []string {
+	if len(d.Metadata.CloudWatchMetrics) == 0 || len(d.Metadata.CloudWatchMetrics[0].Dimensions) == 0 {
+		return nil
+	}
+	return d.Metadata.CloudWatchMetrics[0].Dimensions[0]
+}
+
+// Unit returns the unit of metric name, or "" when the document does not
+// define it
+func (d Document) Unit(name string) Unit {
+	for _, directive := range d.Metadata.CloudWatchMetrics {
+		for _, metric := range directive.Metrics {
+			if metric.Name == name {
+				return metric.Unit
+			}
+		}
+	}
+	return ""
+}
+
+// Values returns the values of metric name
+func (d Document) Values(name string) []float64 {
+	if d.Unit(name) == "" {
+		return nil
+	}
+
+	switch value := d.Members[name].(type) {
+	case float64:
+		return []float64{value}
+	case []interface{}:
+		values := make([]float64, 0, len(value))
+		for _, v := range value {
+			if f, ok := v.(float64); ok {
+				values = append(values, f)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+`
+
+const MetricsTest = `package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+
+// captureMetrics sends documents to a new Capture. Invocations count as
+// warm unless a test resets warm.
+func captureMetrics() *Capture {
+	capture := &Capture{}
+	Init(Options{Namespace: "test", Service: "orders", Output: capture})
+	warm.Store(true)
+	return capture
+}
+
+// flushDocument records metrics of each kind and flushes them
+func flushDocument() []Document {
+	capture := captureMetrics()
+	m := New()
+	m.AddDimension("route", "/users")
+	m.AddProperty("request_id", "req-1")
+	m.Add("Latency", Milliseconds, 12)
+	m.Add("Latency", Milliseconds, 8)
+	m.Add("Created", Count, 1)
+	m.Flush()
+	return capture.Documents()
+}
+
+// flushMany records one metric more than a document holds
+func flushMany() []Document {
+	capture := captureMetrics()
+	m := New()
+	for i := 0; i <= maxMetrics; i++ {
+		m.Add(fmt.Sprintf("Metric%d", i), Count, 1)
+	}
+	m.Flush()
+	return capture.Documents()
+}
+
+// invokeAPI runs a wrapped API handler that records a metric of its own
+func invokeAPI(statusCode int, handlerErr error) []Document {
+	capture := captureMetrics()
+	handler := Wrap(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		Add(ctx, "Created", Count, 1)
+		return apievent.NewResponse(statusCode, nil, ""), handlerErr
+	})
+
+	_, _ = handler(context.Background(), apievent.NewRequest(http.MethodPost, "/users", ""))
+	return capture.Documents()
+}
+
+// invokeTwice runs a wrapped handler twice after a cold start
+func invokeTwice() []Document {
+	capture := captureMetrics()
+	warm.Store(false)
+	handler := Wrap(func(ctx context.Context, event events.CloudWatchEvent) (struct{}, error) {
+		return struct{}{}, nil
+	})
+
+	_, _ = handler(context.Background(), events.CloudWatchEvent{})
+	_, _ = handler(context.Background(), events.CloudWatchEvent{})
+	return capture.Documents()
+}
+
+// invokeSQS runs a wrapped SQS handler that fails one of three messages
+func invokeSQS(handlerErr error) []Document {
+	capture := captureMetrics()
+	handler := Wrap(func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		failure := events.SQSBatchItemFailure{ItemIdentifier: event.Records[1].MessageId}
+		return events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{failure}}, handlerErr
+	})
+
+	var event events.SQSEvent
+	for _, id := range []string{"1", "2", "3"} {
+		event.Records = append(event.Records, events.SQSMessage{MessageId: id})
+	}
+	_, _ = handler(context.Background(), event)
+	return capture.Documents()
+}
+
+// addOutsideInvocation records a metric without a Metrics in the context
+func addOutsideInvocation() []Document {
+	capture := captureMetrics()
+	Add(context.Background(), "Started", Count, 1)
+	return capture.Documents()
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Metrics", func() {
+	It("flushes an EMF document", func() {
+		docs := flushDocument()
+
+		Expect(docs).To(HaveLen(1))
+		doc := docs[0]
+		Expect(doc.Metadata.Timestamp).To(BeNumerically(">", 0))
+		Expect(doc.Namespace()).To(Equal("test"))
+		Expect(doc.Dimensions()).To(Equal([]string{ServiceDimension, "route"}))
+		Expect(doc.Members).To(HaveKeyWithValue(ServiceDimension, "orders"))
+		Expect(doc.Members).To(HaveKeyWithValue("route", "/users"))
+		Expect(doc.Members).To(HaveKeyWithValue("request_id", "req-1"))
+		Expect(doc.Unit("Latency")).To(Equal(Milliseconds))
+		Expect(doc.Values("Latency")).To(Equal([]float64{12, 8}))
+		Expect(doc.Unit("Created")).To(Equal(Count))
+		Expect(doc.Values("Created")).To(Equal([]float64{1}))
+	})
+
+	It("splits metrics over documents CloudWatch accepts", func() {
+		docs := flushMany()
+
+		Expect(docs).To(HaveLen(2))
+		Expect(docs[0].Metadata.CloudWatchMetrics[0].Metrics).To(HaveLen(maxMetrics))
+		Expect(docs[1].Metadata.CloudWatchMetrics[0].Metrics).To(HaveLen(1))
+	})
+
+	It("writes metrics added outside an invocation right away", func() {
+		docs := addOutsideInvocation()
+
+		Expect(docs).To(HaveLen(1))
+		Expect(docs[0].Values("Started")).To(Equal([]float64{1}))
+	})
+})
+
+var _ = Describe("Wrap", func() {
+	It("records latency and status of API invocations", func() {
+		docs := invokeAPI(http.StatusCreated, nil)
+
+		Expect(docs).To(HaveLen(1))
+		Expect(docs[0].Unit("Latency")).To(Equal(Milliseconds))
+		Expect(docs[0].Values("Latency")).To(HaveLen(1))
+		Expect(docs[0].Values("Status2xx")).To(Equal([]float64{1}))
+		Expect(docs[0].Values("Errors")).To(Equal([]float64{0}))
+		Expect(docs[0].Values("Created")).To(Equal([]float64{1}))
+	})
+
+	It("counts 5xx responses and handler errors as errors", func() {
+		serverError := invokeAPI(http.StatusBadGateway, nil)
+		handlerError := invokeAPI(http.StatusOK, errors.New("boom"))
+
+		Expect(serverError).To(HaveLen(1))
+		Expect(serverError[0].Values("Status5xx")).To(Equal([]float64{1}))
+		Expect(serverError[0].Values("Errors")).To(Equal([]float64{1}))
+		Expect(handlerError).To(HaveLen(1))
+		Expect(handlerError[0].Values("Errors")).To(Equal([]float64{1}))
+	})
+
+	It("records the cold start once", func() {
+		docs := invokeTwice()
+
+		Expect(docs).To(HaveLen(2))
+		Expect(docs[0].Values("ColdStart")).To(Equal([]float64{1}))
+		Expect(docs[1].Values("ColdStart")).To(BeEmpty())
+	})
+
+	It("counts processed and failed batch records", func() {
+		partial := invokeSQS(nil)
+		failed := invokeSQS(errors.New("boom"))
+
+		Expect(partial).To(HaveLen(1))
+		Expect(partial[0].Values("Processed")).To(Equal([]float64{2}))
+		Expect(partial[0].Values("Failed")).To(Equal([]float64{1}))
+		Expect(partial[0].Values("Errors")).To(Equal([]float64{0}))
+		Expect(failed).To(HaveLen(1))
+		Expect(failed[0].Values("Processed")).To(Equal([]float64{0}))
+		Expect(failed[0].Values("Failed")).To(Equal([]float64{3}))
+	})
+})
+{{- else }}
+{{- if eq .TestingFramework "standard" }}
+
+// equalValues reports whether got and want hold the same values
+func equalValues(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkValues fails t when metric name of doc does not have want
+func checkValues(t *testing.T, doc Document, name string, want ...float64) {
+	t.Helper()
+	if got := doc.Values(name); !equalValues(got, want) {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+{{- end }}
+
+func TestFlushWritesEMFDocument(t *testing.T) {
+	docs := flushDocument()
+{{- if eq .TestingFramework "standard" }}
+	if len(docs) != 1 {
+		t.Fatalf("flushed %d documents, want 1", len(docs))
+	}
+	doc := docs[0]
+	if doc.Metadata.Timestamp <= 0 {
+		t.Errorf("Timestamp = %d, want it set", doc.Metadata.Timestamp)
+	}
+	if got := doc.Namespace(); got != "test" {
+		t.Errorf("Namespace = %q, want %q", got, "test")
+	}
+	if got := doc.Dimensions(); len(got) != 2 || got[0] != ServiceDimension || got[1] != "route" {
+		t.Errorf("Dimensions = %v, want [%s route]", got, ServiceDimension)
+	}
+	for name, want := range map[string]string{ServiceDimension: "orders", "route": "/users", "request_id": "req-1"} {
+		if got := doc.Members[name]; got != want {
+			t.Errorf("%s = %v, want %q", name, got, want)
+		}
+	}
+	if got := doc.Unit("Latency"); got != Milliseconds {
+		t.Errorf("Latency unit = %q, want %q", got, Milliseconds)
+	}
+	checkValues(t, doc, "Latency", 12, 8)
+	if got := doc.Unit("Created"); got != Count {
+		t.Errorf("Created unit = %q, want %q", got, Count)
+	}
+	checkValues(t, doc, "Created", 1)
+{{- else }}
+	require.Len(t, docs, 1)
+	doc := docs[0]
+	assert.Positive(t, doc.Metadata.Timestamp)
+	assert.Equal(t, "test", doc.Namespace())
+	assert.Equal(t, []string{ServiceDimension, "route"}, doc.Dimensions())
+	assert.Equal(t, "orders", doc.Members[ServiceDimension])
+	assert.Equal(t, "/users", doc.Members["route"])
+	assert.Equal(t, "req-1", doc.Members["request_id"])
+	assert.Equal(t, Milliseconds, doc.Unit("Latency"))
+	assert.Equal(t, []float64{12, 8}, doc.Values("Latency"))
+	assert.Equal(t, Count, doc.Unit("Created"))
+	assert.Equal(t, []float64{1}, doc.Values("Created"))
+{{- end }}
+}
+
+func TestFlushSplitsDocuments(t *testing.T) {
+	docs := flushMany()
+{{- if eq .TestingFramework "standard" }}
+	if len(docs) != 2 {
+		t.Fatalf("flushed %d documents, want 2", len(docs))
+	}
+	if got := len(docs[0].Metadata.CloudWatchMetrics[0].Metrics); got != maxMetrics {
+		t.Errorf("first document has %d metrics, want %d", got, maxMetrics)
+	}
+	if got := len(docs[1].Metadata.CloudWatchMetrics[0].Metrics); got != 1 {
+		t.Errorf("second document has %d metrics, want 1", got)
+	}
+{{- else }}
+	require.Len(t, docs, 2)
+	assert.Len(t, docs[0].Metadata.CloudWatchMetrics[0].Metrics, maxMetrics)
+	assert.Len(t, docs[1].Metadata.CloudWatchMetrics[0].Metrics, 1)
+{{- end }}
+}
+
+func TestAddOutsideInvocation(t *testing.T) {
+	docs := addOutsideInvocation()
+{{- if eq .TestingFramework "standard" }}
+	if len(docs) != 1 {
+		t.Fatalf("flushed %d documents, want 1", len(docs))
+	}
+	checkValues(t, docs[0], "Started", 1)
+{{- else }}
+	require.Len(t, docs, 1)
+	assert.Equal(t, []float64{1}, docs[0].Values("Started"))
+{{- end }}
+}
+
+func TestWrapRecordsAPIInvocation(t *testing.T) {
+	docs := invokeAPI(http.StatusCreated, nil)
+{{- if eq .TestingFramework "standard" }}
+	if len(docs) != 1 {
+		t.Fatalf("flushed %d documents, want 1", len(docs))
+	}
+	doc := docs[0]
+	if got := doc.Unit("Latency"); got != Milliseconds {
+		t.Errorf("Latency unit = %q, want %q", got, Milliseconds)
+	}
+	if got := doc.Values("Latency"); len(got) != 1 {
+		t.Errorf("Latency = %v, want one value", got)
+	}
+	checkValues(t, doc, "Status2xx", 1)
+	checkValues(t, doc, "Errors", 0)
+	checkValues(t, doc, "Created", 1)
+{{- else }}
+	require.Len(t, docs, 1)
+	doc := docs[0]
+	assert.Equal(t, Milliseconds, doc.Unit("Latency"))
+	assert.Len(t, doc.Values("Latency"), 1)
+	assert.Equal(t, []float64{1}, doc.Values("Status2xx"))
+	assert.Equal(t, []float64{0}, doc.Values("Errors"))
+	assert.Equal(t, []float64{1}, doc.Values("Created"))
+{{- end }}
+}
+
+func TestWrapCountsErrors(t *testing.T) {
+	serverError := invokeAPI(http.StatusBadGateway, nil)
+	handlerError := invokeAPI(http.StatusOK, errors.New("boom"))
+{{- if eq .TestingFramework "standard" }}
+	if len(serverError) != 1 || len(handlerError) != 1 {
+		t.Fatalf("flushed %d and %d documents, want 1 each", len(serverError), len(handlerError))
+	}
+	checkValues(t, serverError[0], "Status5xx", 1)
+	checkValues(t, serverError[0], "Errors", 1)
+	checkValues(t, handlerError[0], "Errors", 1)
+{{- else }}
+	require.Len(t, serverError, 1)
+	assert.Equal(t, []float64{1}, serverError[0].Values("Status5xx"))
+	assert.Equal(t, []float64{1}, serverError[0].Values("Errors"))
+	require.Len(t, handlerError, 1)
+	assert.Equal(t, []float64{1}, handlerError[0].Values("Errors"))
+{{- end }}
+}
+
+func TestWrapRecordsColdStartOnce(t *testing.T) {
+	docs := invokeTwice()
+{{- if eq .TestingFramework "standard" }}
+	if len(docs) != 2 {
+		t.Fatalf("flushed %d documents, want 2", len(docs))
+	}
+	checkValues(t, docs[0], "ColdStart", 1)
+	checkValues(t, docs[1], "ColdStart")
+{{- else }}
+	require.Len(t, docs, 2)
+	assert.Equal(t, []float64{1}, docs[0].Values("ColdStart"))
+	assert.Empty(t, docs[1].Values("ColdStart"))
+{{- end }}
+}
+
+func TestWrapCountsBatchRecords(t *testing.T) {
+	partial := invokeSQS(nil)
+	failed := invokeSQS(errors.New("boom"))
+{{- if eq .TestingFramework "standard" }}
+	if len(partial) != 1 || len(failed) != 1 {
+		t.Fatalf("flushed %d and %d documents, want 1 each", len(partial), len(failed))
+	}
+	checkValues(t, partial[0], "Processed", 2)
+	checkValues(t, partial[0], "Failed", 1)
+	checkValues(t, partial[0], "Errors", 0)
+	checkValues(t, failed[0], "Processed", 0)
+	checkValues(t, failed[0], "Failed", 3)
+{{- else }}
+	require.Len(t, partial, 1)
+	assert.Equal(t, []float64{2}, partial[0].Values("Processed"))
+	assert.Equal(t, []float64{1}, partial[0].Values("Failed"))
+	assert.Equal(t, []float64{0}, partial[0].Values("Errors"))
+	require.Len(t, failed, 1)
+	assert.Equal(t, []float64{0}, failed[0].Values("Processed"))
+	assert.Equal(t, []float64{3}, failed[0].Values("Failed"))
+{{- end }}
+}
+{{- end }}
+`
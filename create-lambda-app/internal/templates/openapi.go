@@ -0,0 +1,529 @@
+package templates
+
+// OpenAPI validation templates, generated with the api feature. The spec in
+// docs/openapi.yaml is embedded by the docs package and pkg/openapi checks
+// requests, and optionally responses, against it.
+
+const OpenAPIEmbed = `// Package docs embeds the documents the service uses at runtime
+package docs
+
+import _ "embed"
+
+// OpenAPI is the API contract in docs/openapi.yaml; pkg/openapi validates
+// requests against it
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+`
+
+const OpenAPIValidator = `// Package openapi checks API requests, and optionally responses, against
+// the project's OpenAPI spec, so handlers only see requests the contract
+// allows.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/docs"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/problem"
+)
+
+func init() {
+	// The spec uses these formats; kin-openapi leaves defining them to us
+	openapi3.DefineStringFormatValidator("uuid", openapi3.NewRegexpFormatValidator(openapi3.FormatOfStringForUUIDOfRFC4122))
+	openapi3.DefineStringFormatValidator("email", openapi3.NewRegexpFormatValidator(openapi3.FormatOfStringForEmail))
+}
+
+// Options configures a Validator
+type Options struct {
+	// ValidateResponses checks responses too and replaces one that breaks
+	// the spec with a 500. Keep it off in production: a response that
+	// breaks the contract is a bug to catch in development and staging,
+	// not a reason to fail a user's request.
+	ValidateResponses bool
+}
+
+// Validator checks requests against the operations of a spec
+type Validator struct {
+	router  routers.Router
+	options Options
+}
+
+// Load creates a Validator for docs/openapi.yaml. Call it at cold start.
+func Load(opts Options) (*Validator, error) {
+	return New(docs.OpenAPI, opts)
+}
+
+// New creates a Validator for spec
+func New(spec []byte, opts Options) (*Validator, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+
+	// Requests arrive on API Gateway, function URL and local hosts alike,
+	// so operations are matched on the path alone
+	doc.Servers = nil
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to route OpenAPI spec: %w", err)
+	}
+	return &Validator{router: router, options: opts}, nil
+}
+
+// FieldError is one way a request breaks the spec
+type FieldError struct {
+	// In is where the value is: path, query, header, cookie or body
+	In string ` + "`" + `json:"in"` + "`" + `
+
+	// Name is the parameter name, or the dotted path of a body field
+	Name string ` + "`" + `json:"name,omitempty"` + "`" + `
+
+	// Reason says what is wrong
+	Reason string ` + "`" + `json:"reason"` + "`" + `
+}
+
+func newFieldError(in, name, reason string) FieldError {
+	return FieldError{In: in, Name: name, Reason: reason}
+}
+
+// ValidationError lists how a request breaks the spec
+type ValidationError struct {
+	Errors []FieldError
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		if fieldErr.Name == "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", fieldErr.In, fieldErr.Reason))
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%s %s: %s", fieldErr.In, fieldErr.Name, fieldErr.Reason))
+	}
+	return "request does not match the API specification: " + strings.Join(reasons, "; ")
+}
+
+// Wrap checks requests before they reach handler. A request that breaks
+// the spec gets a 400 listing its field errors; one for a route the spec
+// does not describe is passed on for the handler to answer. With
+// ValidateResponses, a response that breaks the spec is logged and
+// replaced with a 500.
+func (v *Validator) Wrap(handler func(ctx context.Context, request apievent.Request) (apievent.Response, error)) func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	return func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		input, err := v.validateRequest(ctx, request)
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			log.Info().
+				Str("request_id", apievent.RequestID(request)).
+				Interface("errors", invalid.Errors).
+				Msg("Rejected request that does not match the API specification")
+			return problem.Response(ctx, request, problem.New(problem.TypeValidation, "Request does not match the API specification").With("errors", invalid.Errors)), nil
+		}
+
+		response, err := handler(ctx, request)
+		if err != nil || input == nil || !v.options.ValidateResponses {
+			return response, err
+		}
+
+		if err := v.validateResponse(ctx, input, response); err != nil {
+			log.Error().
+				Err(err).
+				Str("request_id", apievent.RequestID(request)).
+				Int("status_code", response.StatusCode).
+				Msg("Response does not match the API specification")
+			return problem.Response(ctx, request, problem.New(problem.TypeInternal, "Response does not match the API specification")), nil
+		}
+		return response, nil
+	}
+}
+
+// ValidateRequest checks request against the operation it is routed to. It
+// returns a *ValidationError for a request that breaks the spec, and nil
+// for one no operation matches.
+func (v *Validator) ValidateRequest(ctx context.Context, request apievent.Request) error {
+	_, err := v.validateRequest(ctx, request)
+	return err
+}
+
+// validateRequest returns the validation input of the operation request is
+// routed to, or nil when there is none
+func (v *Validator) validateRequest(ctx context.Context, request apievent.Request) (*openapi3filter.RequestValidationInput, error) {
+	httpRequest, err := apievent.ToHTTPRequest(ctx, request)
+	if err != nil {
+		return nil, &ValidationError{Errors: []FieldError{newFieldError("body", "", err.Error())}}
+	}
+
+	route, pathParams, err := v.router.FindRoute(httpRequest)
+	if err != nil {
+		return nil, nil
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    httpRequest,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError: true,
+			// API Gateway authorizers check credentials
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	if err := openapi3filter.ValidateRequest(ctx, input); err != nil {
+		return input, &ValidationError{Errors: fieldErrors(err)}
+	}
+	return input, nil
+}
+
+// validateResponse checks response against the operation of input
+func (v *Validator) validateResponse(ctx context.Context, input *openapi3filter.RequestValidationInput, response apievent.Response) error {
+	recorded := newRecorder()
+	if err := apievent.WriteResponse(recorded, response); err != nil {
+		return err
+	}
+
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 recorded.statusCode,
+		Header:                 recorded.header,
+		Options:                &openapi3filter.Options{MultiError: true},
+	}
+	responseInput.SetBodyBytes(recorded.body.Bytes())
+	return openapi3filter.ValidateResponse(ctx, responseInput)
+}
+
+// fieldErrors lists the field errors in an error of openapi3filter
+func fieldErrors(err error) []FieldError {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var all []FieldError
+		for _, inner := range e {
+			all = append(all, fieldErrors(inner)...)
+		}
+		return all
+	case *openapi3filter.RequestError:
+		in, name := "body", ""
+		if e.Parameter != nil {
+			in, name = e.Parameter.In, e.Parameter.Name
+		}
+		if schemaErrs := schemaErrors(e.Err, in, name); len(schemaErrs) > 0 {
+			return schemaErrs
+		}
+
+		reason := e.Reason
+		if reason == "" && e.Err != nil {
+			reason = e.Err.Error()
+		}
+		return []FieldError{newFieldError(in, name, reason)}
+	default:
+		return []FieldError{newFieldError("request", "", err.Error())}
+	}
+}
+
+// schemaErrors lists the schema violations in err. Body fields are named
+// by their path in the document.
+func schemaErrors(err error, in, name string) []FieldError {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var all []FieldError
+		for _, inner := range e {
+			all = append(all, schemaErrors(inner, in, name)...)
+		}
+		return all
+	case *openapi3.SchemaError:
+		if in == "body" {
+			name = strings.Join(e.JSONPointer(), ".")
+		}
+		return []FieldError{newFieldError(in, name, e.Reason)}
+	default:
+		return nil
+	}
+}
+
+// recorder captures a response replayed through apievent.WriteResponse
+type recorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	return r.body.Write(data)
+}
+
+func (r *recorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+}
+`
+
+const OpenAPIValidationMiddleware = `package middleware
+
+import (
+	"{{.Module}}/pkg/openapi"
+)
+
+// Validation checks requests, and responses when validator is set to,
+// against the OpenAPI spec (see openapi.Validator.Wrap). Put it inside
+// DefaultChain so rejected requests are still logged, traced and counted.
+func Validation(validator *openapi.Validator) APIMiddleware {
+	return func(next APIHandlerFunc) APIHandlerFunc {
+		return validator.Wrap(next)
+	}
+}
+`
+
+const OpenAPIValidatorTest = `package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+
+const userID = "3f8a9f5e-1b2c-4d3e-8f4a-5b6c7d8e9f0a"
+
+// newValidator loads the embedded spec
+func newValidator(validateResponses bool) *Validator {
+	validator, err := Load(Options{ValidateResponses: validateResponses})
+	if err != nil {
+		panic(err)
+	}
+	return validator
+}
+
+// jsonRequest builds a request with a JSON body
+func jsonRequest(method, target, body string) apievent.Request {
+	request := apievent.NewRequest(method, target, body)
+	request.Headers["content-type"] = "application/json"
+	return request
+}
+
+// jsonResponse builds a response with a JSON body
+func jsonResponse(statusCode int, body string) apievent.Response {
+	return apievent.NewResponse(statusCode, map[string]string{"Content-Type": "application/json"}, body)
+}
+
+// invoke sends request through a validator to a handler that answers with
+// response, and reports whether the handler ran
+func invoke(validateResponses bool, request apievent.Request, response apievent.Response) (apievent.Response, bool) {
+	called := false
+	handler := newValidator(validateResponses).Wrap(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		called = true
+		return response, nil
+	})
+
+	got, _ := handler(context.Background(), request)
+	return got, called
+}
+
+// createUser posts body to /users
+func createUser(body string) (apievent.Response, bool) {
+	created := jsonResponse(http.StatusCreated, ` + "`" + `{"success":true,"data":{"id":"` + "`" + `+userID+` + "`" + `","email":"ada@example.com","name":"Ada"}}` + "`" + `)
+	return invoke(false, jsonRequest(http.MethodPost, "/users", body), created)
+}
+
+// getUser answers GET /users/{id} with a user whose email is email
+func getUser(validateResponses bool, email string) apievent.Response {
+	user := jsonResponse(http.StatusOK, ` + "`" + `{"success":true,"data":{"id":"` + "`" + `+userID+` + "`" + `","email":"` + "`" + `+email+` + "`" + `"}}` + "`" + `)
+	response, _ := invoke(validateResponses, apievent.NewRequest(http.MethodGet, "/users/"+userID, ""), user)
+	return response
+}
+
+// fieldErrorsOf decodes the field errors of an error response
+func fieldErrorsOf(response apievent.Response) []FieldError {
+	var body struct {
+		Errors []FieldError ` + "`" + `json:"errors"` + "`" + `
+	}
+	_ = json.Unmarshal([]byte(response.Body), &body)
+	return body.Errors
+}
+
+// hasFieldError reports whether errs has one for name in in
+func hasFieldError(errs []FieldError, in, name string) bool {
+	for _, fieldErr := range errs {
+		if fieldErr.In == in && fieldErr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Validator", func() {
+	It("passes requests that match the spec", func() {
+		response, called := createUser(` + "`" + `{"email":"ada@example.com","name":"Ada"}` + "`" + `)
+
+		Expect(called).To(BeTrue())
+		Expect(response.StatusCode).To(Equal(http.StatusCreated))
+	})
+
+	It("rejects bodies that break the schema", func() {
+		response, called := createUser(` + "`" + `{"email":"not-an-email"}` + "`" + `)
+
+		Expect(called).To(BeFalse())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		errs := fieldErrorsOf(response)
+		Expect(hasFieldError(errs, "body", "email")).To(BeTrue(), "errors: %+v", errs)
+		Expect(hasFieldError(errs, "body", "name")).To(BeTrue(), "errors: %+v", errs)
+	})
+
+	It("rejects query and path parameters that break the schema", func() {
+		query, _ := invoke(false, apievent.NewRequest(http.MethodGet, "/users?limit=500", ""), jsonResponse(http.StatusOK, "{}"))
+		path, _ := invoke(false, apievent.NewRequest(http.MethodGet, "/users/not-a-uuid", ""), jsonResponse(http.StatusOK, "{}"))
+
+		Expect(query.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(hasFieldError(fieldErrorsOf(query), "query", "limit")).To(BeTrue())
+		Expect(path.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(hasFieldError(fieldErrorsOf(path), "path", "id")).To(BeTrue())
+	})
+
+	It("leaves routes the spec does not describe to the handler", func() {
+		response, called := invoke(false, apievent.NewRequest(http.MethodGet, "/unknown", ""), jsonResponse(http.StatusNotFound, "{}"))
+
+		Expect(called).To(BeTrue())
+		Expect(response.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("replaces responses that break the spec when asked to", func() {
+		Expect(getUser(true, "ada@example.com").StatusCode).To(Equal(http.StatusOK))
+		Expect(getUser(true, "not-an-email").StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(getUser(false, "not-an-email").StatusCode).To(Equal(http.StatusOK))
+	})
+})
+{{- else }}
+
+func TestValidatorPassesValidRequests(t *testing.T) {
+	response, called := createUser(` + "`" + `{"email":"ada@example.com","name":"Ada"}` + "`" + `)
+{{- if eq .TestingFramework "standard" }}
+	if !called {
+		t.Error("handler did not run for a valid request")
+	}
+	if response.StatusCode != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", response.StatusCode, http.StatusCreated)
+	}
+{{- else }}
+	assert.True(t, called)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+{{- end }}
+}
+
+func TestValidatorRejectsInvalidBody(t *testing.T) {
+	response, called := createUser(` + "`" + `{"email":"not-an-email"}` + "`" + `)
+	errs := fieldErrorsOf(response)
+{{- if eq .TestingFramework "standard" }}
+	if called {
+		t.Error("handler ran for an invalid request")
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("StatusCode = %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+	for _, name := range []string{"email", "name"} {
+		if !hasFieldError(errs, "body", name) {
+			t.Errorf("errors %+v lack body field %s", errs, name)
+		}
+	}
+{{- else }}
+	assert.False(t, called)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.True(t, hasFieldError(errs, "body", "email"), "errors: %+v", errs)
+	assert.True(t, hasFieldError(errs, "body", "name"), "errors: %+v", errs)
+{{- end }}
+}
+
+func TestValidatorRejectsInvalidParameters(t *testing.T) {
+	query, _ := invoke(false, apievent.NewRequest(http.MethodGet, "/users?limit=500", ""), jsonResponse(http.StatusOK, "{}"))
+	path, _ := invoke(false, apievent.NewRequest(http.MethodGet, "/users/not-a-uuid", ""), jsonResponse(http.StatusOK, "{}"))
+{{- if eq .TestingFramework "standard" }}
+	if query.StatusCode != http.StatusBadRequest || !hasFieldError(fieldErrorsOf(query), "query", "limit") {
+		t.Errorf("limit=500 got %d %s, want a 400 for query limit", query.StatusCode, query.Body)
+	}
+	if path.StatusCode != http.StatusBadRequest || !hasFieldError(fieldErrorsOf(path), "path", "id") {
+		t.Errorf("id=not-a-uuid got %d %s, want a 400 for path id", path.StatusCode, path.Body)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusBadRequest, query.StatusCode)
+	assert.True(t, hasFieldError(fieldErrorsOf(query), "query", "limit"))
+	assert.Equal(t, http.StatusBadRequest, path.StatusCode)
+	assert.True(t, hasFieldError(fieldErrorsOf(path), "path", "id"))
+{{- end }}
+}
+
+func TestValidatorPassesUnknownRoutes(t *testing.T) {
+	response, called := invoke(false, apievent.NewRequest(http.MethodGet, "/unknown", ""), jsonResponse(http.StatusNotFound, "{}"))
+{{- if eq .TestingFramework "standard" }}
+	if !called || response.StatusCode != http.StatusNotFound {
+		t.Errorf("called = %v, StatusCode = %d; want the handler's 404", called, response.StatusCode)
+	}
+{{- else }}
+	assert.True(t, called)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+{{- end }}
+}
+
+func TestValidatorChecksResponses(t *testing.T) {
+	tests := []struct {
+		name              string
+		validateResponses bool
+		email             string
+		want              int
+	}{
+		{name: "valid response", validateResponses: true, email: "ada@example.com", want: http.StatusOK},
+		{name: "invalid response", validateResponses: true, email: "not-an-email", want: http.StatusInternalServerError},
+		{name: "not checked", validateResponses: false, email: "not-an-email", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := getUser(tt.validateResponses, tt.email)
+{{- if eq .TestingFramework "standard" }}
+			if response.StatusCode != tt.want {
+				t.Errorf("StatusCode = %d, want %d", response.StatusCode, tt.want)
+			}
+{{- else }}
+			assert.Equal(t, tt.want, response.StatusCode)
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
@@ -0,0 +1,457 @@
+package templates
+
+// Transactional outbox
+//
+// The repository writes a user's uncommitted events as outbox items in the
+// same transaction as the user, and a Lambda reading the table's stream
+// relays them downstream. Delivery is at least once, so every message
+// carries the event ID for consumers and FIFO targets to deduplicate on.
+
+const DDDOutbox = `package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"{{.Module}}/domain/event"
+)
+
+// ItemType is the type attribute of outbox items. The relay only
+// publishes stream records of items with this type.
+const ItemType = "OutboxMessage"
+
+// KeyPrefix prefixes the event ID in the table keys of outbox items
+const KeyPrefix = "OUTBOX#"
+
+// Retention is how long an outbox item stays in the table. The relay reads
+// it from the stream, which keeps records for 24 hours, so the item itself
+// is only kept for inspection; the table's TTL deletes it once its
+// expires_at attribute passes.
+const Retention = 7 * 24 * time.Hour
+
+// Message is a domain event waiting in the outbox to be published
+type Message struct {
+	EventID     string    ` + "`dynamodbav:\"event_id\"`" + `
+	EventType   string    ` + "`dynamodbav:\"event_type\"`" + `
+	AggregateID string    ` + "`dynamodbav:\"aggregate_id\"`" + `
+	OccurredAt  time.Time ` + "`dynamodbav:\"occurred_at\"`" + `
+	Payload     string    ` + "`dynamodbav:\"payload\"`" + `
+}
+
+// NewMessage captures a domain event for the outbox
+func NewMessage(evt event.DomainEvent) (Message, error) {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal %s event: %w", evt.EventType(), err)
+	}
+
+	return Message{
+		EventID:     evt.EventID(),
+		EventType:   evt.EventType(),
+		AggregateID: evt.AggregateID(),
+		OccurredAt:  evt.OccurredAt().UTC(),
+		Payload:     string(payload),
+	}, nil
+}
+
+// Body returns the published form of the message. It is the envelope the
+// SQS message handler reads, plus the event ID to deduplicate on.
+func (m Message) Body() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"event_id":     m.EventID,
+		"type":         m.EventType,
+		"aggregate_id": m.AggregateID,
+		"occurred_at":  m.OccurredAt,
+		"payload":      json.RawMessage(m.Payload),
+	})
+}
+`
+
+const DDDOutboxRelay = `package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/rs/zerolog/log"
+)
+
+// Publisher publishes outbox messages downstream. The relay delivers at
+// least once, so a message may be published again after a failure.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Fanout publishes every message to each of its publishers in turn
+type Fanout []Publisher
+
+// Publish publishes msg to every publisher, stopping at the first error
+func (f Fanout) Publish(ctx context.Context, msg Message) error {
+	for _, publisher := range f {
+		if err := publisher.Publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Relay publishes the outbox items that appear on the table's stream
+type Relay struct {
+	publisher Publisher
+}
+
+// NewRelay creates a relay that publishes through publisher
+func NewRelay(publisher Publisher) *Relay {
+	return &Relay{publisher: publisher}
+}
+
+// HandleStream publishes the outbox items inserted in a batch of stream
+// records, in order. On a failure it reports the failed record, so Lambda
+// retries the batch from there and no event is skipped; the event source
+// mapping must enable ReportBatchItemFailures.
+func (r *Relay) HandleStream(ctx context.Context, streamEvent events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	var response events.DynamoDBEventResponse
+
+	for _, record := range streamEvent.Records {
+		if record.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+
+		msg, ok, err := messageFromImage(record.Change.NewImage)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			// Retrying cannot fix a malformed item
+			log.Ctx(ctx).Error().
+				Err(err).
+				Str("sequence_number", record.Change.SequenceNumber).
+				Msg("Skipping malformed outbox item")
+			continue
+		}
+
+		if err := r.publisher.Publish(ctx, msg); err != nil {
+			log.Ctx(ctx).Error().
+				Err(err).
+				Str("event_id", msg.EventID).
+				Str("event_type", msg.EventType).
+				Msg("Failed to publish outbox message")
+			response.BatchItemFailures = append(response.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: record.Change.SequenceNumber,
+			})
+			return response, nil
+		}
+
+		log.Ctx(ctx).Info().
+			Str("event_id", msg.EventID).
+			Str("event_type", msg.EventType).
+			Msg("Published outbox message")
+	}
+
+	return response, nil
+}
+
+// messageFromImage decodes an outbox item from a stream image. It reports
+// false for items that are not outbox items.
+func messageFromImage(image map[string]events.DynamoDBAttributeValue) (Message, bool, error) {
+	if stringAttribute(image, "type") != ItemType {
+		return Message{}, false, nil
+	}
+
+	msg := Message{
+		EventID:     stringAttribute(image, "event_id"),
+		EventType:   stringAttribute(image, "event_type"),
+		AggregateID: stringAttribute(image, "aggregate_id"),
+		Payload:     stringAttribute(image, "payload"),
+	}
+	if msg.EventID == "" || msg.EventType == "" || msg.Payload == "" {
+		return msg, true, fmt.Errorf("outbox item is missing event_id, event_type or payload")
+	}
+
+	occurredAt, err := time.Parse(time.RFC3339Nano, stringAttribute(image, "occurred_at"))
+	if err != nil {
+		return msg, true, fmt.Errorf("invalid occurred_at: %w", err)
+	}
+	msg.OccurredAt = occurredAt
+
+	return msg, true, nil
+}
+
+func stringAttribute(image map[string]events.DynamoDBAttributeValue, name string) string {
+	value, ok := image[name]
+	if !ok || value.DataType() != events.DataTypeString {
+		return ""
+	}
+	return value.String()
+}
+`
+
+const DDDOutboxSQSPublisher = `package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"{{.Module}}/pkg/tracing"
+)
+
+// SQSAPI is the subset of the SQS client used by SQSPublisher
+type SQSAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// SQSPublisher sends outbox messages to an SQS queue. On a FIFO queue the
+// event ID is the deduplication ID, so SQS drops redeliveries within its
+// five-minute window, and events are ordered per aggregate.
+type SQSPublisher struct {
+	client   SQSAPI
+	queueURL string
+}
+
+// NewSQSPublisher creates a publisher for the queue at queueURL
+func NewSQSPublisher(client SQSAPI, queueURL string) *SQSPublisher {
+	return &SQSPublisher{
+		client:   client,
+		queueURL: queueURL,
+	}
+}
+
+// Publish sends msg to the queue with the trace context of ctx, the relay
+// invocation, in its attributes
+func (p *SQSPublisher) Publish(ctx context.Context, msg Message) error {
+	body, err := msg.Body()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.queueURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: tracing.InjectSQS(ctx, map[string]types.MessageAttributeValue{
+			"event_id": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(msg.EventID),
+			},
+			"event_type": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(msg.EventType),
+			},
+		}),
+	}
+	if strings.HasSuffix(p.queueURL, ".fifo") {
+		input.MessageDeduplicationId = aws.String(msg.EventID)
+		input.MessageGroupId = aws.String(msg.AggregateID)
+	}
+
+	if _, err := p.client.SendMessage(ctx, input); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return nil
+}
+`
+
+const DDDOutboxSNSPublisher = `package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// SNSAPI is the subset of the SNS client used by SNSPublisher
+type SNSAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// SNSPublisher publishes outbox messages to an SNS topic. On a FIFO topic
+// the event ID is the deduplication ID and events are ordered per
+// aggregate.
+type SNSPublisher struct {
+	client   SNSAPI
+	topicARN string
+}
+
+// NewSNSPublisher creates a publisher for the topic with topicARN
+func NewSNSPublisher(client SNSAPI, topicARN string) *SNSPublisher {
+	return &SNSPublisher{
+		client:   client,
+		topicARN: topicARN,
+	}
+}
+
+// Publish publishes msg to the topic
+func (p *SNSPublisher) Publish(ctx context.Context, msg Message) error {
+	body, err := msg.Body()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	input := &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"event_id": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(msg.EventID),
+			},
+			"event_type": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(msg.EventType),
+			},
+		},
+	}
+	if strings.HasSuffix(p.topicARN, ".fifo") {
+		input.MessageDeduplicationId = aws.String(msg.EventID)
+		input.MessageGroupId = aws.String(msg.AggregateID)
+	}
+
+	if _, err := p.client.Publish(ctx, input); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return nil
+}
+`
+
+const DDDOutboxEventBridgePublisher = `package outbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// EventSource is the source of the events published to EventBridge
+const EventSource = "custom.{{.Name}}"
+
+// EventBridgeAPI is the subset of the EventBridge client used by
+// EventBridgePublisher
+type EventBridgeAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// EventBridgePublisher puts outbox messages on an event bus. EventBridge
+// does not deduplicate, so rules and targets should use the event_id in
+// the detail to drop redeliveries.
+type EventBridgePublisher struct {
+	client  EventBridgeAPI
+	busName string
+}
+
+// NewEventBridgePublisher creates a publisher for the named event bus
+func NewEventBridgePublisher(client EventBridgeAPI, busName string) *EventBridgePublisher {
+	return &EventBridgePublisher{
+		client:  client,
+		busName: busName,
+	}
+}
+
+// Publish puts msg on the event bus with the event type as detail type
+func (p *EventBridgePublisher) Publish(ctx context.Context, msg Message) error {
+	detail, err := msg.Body()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	resp, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{
+			{
+				EventBusName: aws.String(p.busName),
+				Source:       aws.String(EventSource),
+				DetailType:   aws.String(msg.EventType),
+				Detail:       aws.String(string(detail)),
+				Resources:    []string{msg.AggregateID},
+				Time:         aws.Time(msg.OccurredAt),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put event: %w", err)
+	}
+	if resp.FailedEntryCount > 0 {
+		entry := resp.Entries[0]
+		return fmt.Errorf("failed to put event: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
+
+	return nil
+}
+`
+
+const DDDOutboxRelayMain = `package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+{{- if .HasFeature "eventbridge" }}
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+{{- end }}
+{{- if .HasFeature "sns" }}
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+{{- end }}
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/infrastructure/config"
+	"{{.Module}}/infrastructure/outbox"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/resilience"
+	"{{.Module}}/pkg/tracing"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load AWS configuration")
+	}
+	tracing.InstrumentAWS(&awsCfg)
+	resilience.InstrumentAWS(&awsCfg, resilience.DefaultBackoff)
+
+	var publishers outbox.Fanout
+	if cfg.OutboxQueueURL != "" {
+		publishers = append(publishers, outbox.NewSQSPublisher(sqs.NewFromConfig(awsCfg), cfg.OutboxQueueURL))
+	}
+{{- if .HasFeature "sns" }}
+	if cfg.OutboxTopicARN != "" {
+		publishers = append(publishers, outbox.NewSNSPublisher(sns.NewFromConfig(awsCfg), cfg.OutboxTopicARN))
+	}
+{{- end }}
+{{- if .HasFeature "eventbridge" }}
+	if cfg.OutboxEventBusName != "" {
+		publishers = append(publishers, outbox.NewEventBridgePublisher(eventbridge.NewFromConfig(awsCfg), cfg.OutboxEventBusName))
+	}
+{{- end }}
+	if len(publishers) == 0 {
+		log.Fatal().Msg("No outbox target configured")
+	}
+
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(outbox.NewRelay(publishers).HandleStream))))
+}
+`
@@ -0,0 +1,489 @@
+package templates
+
+// Problem details templates. pkg/problem is generated for every
+// architecture: handlers, middleware, the request validator and the
+// generated router all answer errors through it, as RFC 7807
+// application/problem+json documents.
+
+const Problem = `// Package problem renders API errors as RFC 7807 problem details
+// (application/problem+json), so clients get one error shape whichever
+// handler or middleware failed:
+//
+//	{
+//	  "type": "https://api.{{.Name}}.com/problems/not-found",
+//	  "title": "Resource not found",
+//	  "status": 404,
+//	  "detail": "user not found",
+//	  "instance": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+//	  "code": "NOT_FOUND"
+//	}
+//
+// type and code are stable, so clients can branch on them; detail is for
+// people. instance is the request ID, the one in the X-Request-ID header.
+// Extensions, such as the field errors of a validation problem, are
+// rendered as further members.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+
+	"{{.Module}}/pkg/apievent"
+{{- if eq .Architecture "clean" }}
+	apperrors "{{.Module}}/pkg/errors"
+{{- end }}
+)
+
+// ContentType is the media type of problem documents
+const ContentType = "application/problem+json"
+
+// TypeBaseURI prefixes the type URI of every problem. Point it at the
+// page that documents the problem types.
+var TypeBaseURI = "https://api.{{.Name}}.com/problems/"
+
+// Type identifies a kind of problem. It is rendered as the "code" member,
+// and as the "type" URI through URI.
+{{- if eq .Architecture "clean" }} The codes of the error types of
+// pkg/errors are the same.
+{{- end }}
+type Type string
+
+const (
+	TypeValidation       Type = "VALIDATION"
+	TypeUnauthorized     Type = "UNAUTHORIZED"
+	TypeForbidden        Type = "FORBIDDEN"
+	TypeNotFound         Type = "NOT_FOUND"
+	TypeMethodNotAllowed Type = "METHOD_NOT_ALLOWED"
+	TypeConflict         Type = "CONFLICT"
+	TypeUnprocessable    Type = "UNPROCESSABLE"
+	TypeTooManyRequests  Type = "TOO_MANY_REQUESTS"
+	TypeInternal         Type = "INTERNAL"
+	TypeNotImplemented   Type = "NOT_IMPLEMENTED"
+	TypeExternal         Type = "EXTERNAL"
+	TypeTimeout          Type = "TIMEOUT"
+)
+
+// kind is the status and title of a Type
+type kind struct {
+	status int
+	title  string
+}
+
+var kinds = map[Type]kind{
+	TypeValidation:       {http.StatusBadRequest, "Invalid request"},
+	TypeUnauthorized:     {http.StatusUnauthorized, "Authentication required"},
+	TypeForbidden:        {http.StatusForbidden, "Access denied"},
+	TypeNotFound:         {http.StatusNotFound, "Resource not found"},
+	TypeMethodNotAllowed: {http.StatusMethodNotAllowed, "Method not allowed"},
+	TypeConflict:         {http.StatusConflict, "Conflicting request"},
+	TypeUnprocessable:    {http.StatusUnprocessableEntity, "Request cannot be processed"},
+	TypeTooManyRequests:  {http.StatusTooManyRequests, "Rate limit exceeded"},
+	TypeInternal:         {http.StatusInternalServerError, "Internal server error"},
+	TypeNotImplemented:   {http.StatusNotImplemented, "Not implemented"},
+	TypeExternal:         {http.StatusBadGateway, "Upstream service failed"},
+	TypeTimeout:          {http.StatusGatewayTimeout, "Request timed out"},
+}
+
+// URI returns the type URI of t, e.g. TypeBaseURI + "not-found"
+func (t Type) URI() string {
+	return TypeBaseURI + strings.ToLower(strings.ReplaceAll(string(t), "_", "-"))
+}
+
+// Problem is a problem details document. It is an error too, so code
+// behind a handler can return one to be answered with it.
+type Problem struct {
+	Type   Type
+	Title  string
+	Status int
+
+	// Detail explains this occurrence to a person; clients should not
+	// parse it
+	Detail string
+
+	// Instance identifies the occurrence. Response and Write set it to the
+	// request ID.
+	Instance string
+
+	// Extensions are rendered as further members of the document. They
+	// cannot replace the members above.
+	Extensions map[string]interface{}
+}
+
+// New creates a problem of type t with the status and title of the type.
+// Types this package does not know are answered with a 500.
+func New(t Type, detail string) *Problem {
+	k, ok := kinds[t]
+	if !ok {
+		k.status = http.StatusInternalServerError
+	}
+	return &Problem{Type: t, Title: k.title, Status: k.status, Detail: detail}
+}
+
+// Internal is the problem unexpected errors are answered with. It says
+// nothing about the cause, which should be logged instead.
+func Internal() *Problem {
+	return New(TypeInternal, "Internal server error")
+}
+
+// Error implements the error interface
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return string(p.Type)
+	}
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+// With sets the extension member key and returns the problem
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// MarshalJSON renders the problem document. An empty title falls back to
+// the text of the status.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(p.Extensions)+6)
+	for key, value := range p.Extensions {
+		if !members[key] {
+			doc[key] = value
+		}
+	}
+
+	doc["type"] = p.Type.URI()
+	doc["code"] = p.Type
+	doc["status"] = p.Status
+	doc["title"] = p.Title
+	if p.Title == "" {
+		doc["title"] = http.StatusText(p.Status)
+	}
+	if p.Detail != "" {
+		doc["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		doc["instance"] = p.Instance
+	}
+	return json.Marshal(doc)
+}
+
+// members are the members of the document extensions cannot replace
+var members = map[string]bool{
+	"type": true, "code": true, "status": true, "title": true, "detail": true, "instance": true,
+}
+
+// FromError returns the problem err is answered with. A *Problem in err's
+// chain is answered as it is
+{{- if eq .Architecture "clean" }}, and an AppError of pkg/errors with the
+// problem of its type and its details as extensions
+{{- end }}. Anything else is an
+// internal error.
+func FromError(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	{{- if eq .Architecture "clean" }}
+
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) && appErr.Type != apperrors.ErrorTypeInternal {
+		p := New(Type(appErr.Type), appErr.Message)
+		for key, value := range appErr.Details {
+			p.With(key, value)
+		}
+		return p
+	}
+	{{- end }}
+	return Internal()
+}
+
+// Response renders p as the response to an API request. The instance
+// defaults to the request ID.
+func Response(ctx context.Context, request apievent.Request, p *Problem) apievent.Response {
+	doc := *p
+	if doc.Instance == "" {
+		doc.Instance = requestID(ctx, apievent.RequestID(request))
+	}
+
+	body, _ := json.Marshal(&doc)
+	return apievent.NewResponse(doc.Status, map[string]string{"Content-Type": ContentType}, string(body))
+}
+
+// Write writes p to w, for handlers served through net/http such as gin.
+// The instance defaults to the X-Request-ID header of the response or of
+// the request.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	doc := *p
+	if doc.Instance == "" {
+		id := w.Header().Get("X-Request-ID")
+		if id == "" {
+			id = r.Header.Get("X-Request-ID")
+		}
+		doc.Instance = requestID(r.Context(), id)
+	}
+
+	body, _ := json.Marshal(&doc)
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(doc.Status)
+	_, _ = w.Write(body)
+}
+
+// requestID falls back to the Lambda request ID when the event source did
+// not assign one
+func requestID(ctx context.Context, id string) string {
+	if id != "" {
+		return id
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return lc.AwsRequestID
+	}
+	return ""
+}
+`
+
+const ProblemTest = `package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+	"strings"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+{{- if eq .Architecture "clean" }}
+	apperrors "{{.Module}}/pkg/errors"
+{{- end }}
+)
+
+var notFound = &Problem{Type: TypeNotFound, Status: http.StatusNotFound, Title: "Resource not found", Detail: "user not found"}
+
+var fromErrorTestCases = []struct {
+	name string
+	err  error
+	want *Problem
+}{
+	{
+		name: "problem",
+		err:  fmt.Errorf("get user: %w", New(TypeNotFound, "user not found")),
+		want: notFound,
+	},
+	{
+		name: "unexpected error",
+		err:  errors.New("connection reset"),
+		want: &Problem{Type: TypeInternal, Status: http.StatusInternalServerError, Title: "Internal server error", Detail: "Internal server error"},
+	},
+{{- if eq .Architecture "clean" }}
+	{
+		name: "application error",
+		err:  apperrors.NewNotFoundError("user"),
+		want: notFound,
+	},
+	{
+		name: "internal application error",
+		err:  apperrors.NewInternalError("database password rejected"),
+		want: &Problem{Type: TypeInternal, Status: http.StatusInternalServerError, Title: "Internal server error", Detail: "Internal server error"},
+	},
+{{- end }}
+}
+
+// lambdaContext is a context of an invocation with Lambda request ID
+// "lambda-1"
+func lambdaContext() context.Context {
+	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "lambda-1"})
+}
+
+// render answers a request with a validation problem and decodes the
+// document
+func render() (apievent.Request, apievent.Response, map[string]interface{}) {
+	request := apievent.NewRequest(http.MethodPost, "/users", "{}")
+	p := New(TypeValidation, "email is required").
+		With("errors", []string{"email"}).
+		With("status", http.StatusOK)
+
+	response := Response(lambdaContext(), request, p)
+	var doc map[string]interface{}
+	_ = json.Unmarshal([]byte(response.Body), &doc)
+	return request, response, doc
+}
+
+// wantInstance is the instance of a problem answering request: its
+// request ID, or the Lambda request ID when the event source has none
+func wantInstance(request apievent.Request) string {
+	if id := apievent.RequestID(request); id != "" {
+		return id
+	}
+	return "lambda-1"
+}
+
+// write writes a conflict through net/http for a request with an
+// X-Request-ID header
+func write() (*httptest.ResponseRecorder, map[string]interface{}) {
+	r := httptest.NewRequest(http.MethodPut, "/users/user-1", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	Write(w, r, New(TypeConflict, "user was modified"))
+
+	var doc map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &doc)
+	return w, doc
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Response", func() {
+	It("renders a problem document", func() {
+		request, response, doc := render()
+
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(response.Headers).To(HaveKeyWithValue("Content-Type", ContentType))
+		Expect(doc).To(HaveKeyWithValue("type", TypeBaseURI+"validation"))
+		Expect(doc).To(HaveKeyWithValue("code", "VALIDATION"))
+		Expect(doc).To(HaveKeyWithValue("title", "Invalid request"))
+		Expect(doc).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusBadRequest)))
+		Expect(doc).To(HaveKeyWithValue("detail", "email is required"))
+		Expect(doc).To(HaveKeyWithValue("instance", wantInstance(request)))
+		Expect(doc).To(HaveKeyWithValue("errors", []interface{}{"email"}))
+	})
+})
+
+var _ = Describe("Write", func() {
+	It("writes a problem document", func() {
+		w, doc := write()
+
+		Expect(w.Code).To(Equal(http.StatusConflict))
+		Expect(w.Header().Get("Content-Type")).To(Equal(ContentType))
+		Expect(doc).To(HaveKeyWithValue("code", "CONFLICT"))
+		Expect(doc).To(HaveKeyWithValue("instance", "req-1"))
+	})
+})
+
+var _ = Describe("New", func() {
+	It("answers unknown types with a 500", func() {
+		p := New("TEAPOT", "")
+		body, err := json.Marshal(p)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Status).To(Equal(http.StatusInternalServerError))
+		Expect(string(body)).To(ContainSubstring(` + "`" + `"title":"Internal Server Error"` + "`" + `))
+	})
+})
+
+var _ = Describe("FromError", func() {
+	for _, tc := range fromErrorTestCases {
+		tc := tc
+
+		It("maps a "+tc.name, func() {
+			Expect(FromError(tc.err)).To(Equal(tc.want))
+		})
+	}
+})
+{{- else }}
+
+func TestResponse(t *testing.T) {
+	request, response, doc := render()
+	want := map[string]interface{}{
+		"type":     TypeBaseURI + "validation",
+		"code":     "VALIDATION",
+		"title":    "Invalid request",
+		"status":   float64(http.StatusBadRequest),
+		"detail":   "email is required",
+		"instance": wantInstance(request),
+		"errors":   []interface{}{"email"},
+	}
+{{- if eq .TestingFramework "standard" }}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("StatusCode = %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+	if got := response.Headers["Content-Type"]; got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("document = %v, want %v", doc, want)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Equal(t, ContentType, response.Headers["Content-Type"])
+	assert.Equal(t, want, doc)
+{{- end }}
+}
+
+func TestWrite(t *testing.T) {
+	w, doc := write()
+{{- if eq .TestingFramework "standard" }}
+	if w.Code != http.StatusConflict {
+		t.Errorf("Code = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	if doc["code"] != "CONFLICT" || doc["instance"] != "req-1" {
+		t.Errorf("document = %v, want code CONFLICT and instance req-1", doc)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "CONFLICT", doc["code"])
+	assert.Equal(t, "req-1", doc["instance"])
+{{- end }}
+}
+
+func TestNewUnknownType(t *testing.T) {
+	p := New("TEAPOT", "")
+	body, err := json.Marshal(p)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if p.Status != http.StatusInternalServerError {
+		t.Errorf("Status = %d, want %d", p.Status, http.StatusInternalServerError)
+	}
+	if !strings.Contains(string(body), ` + "`" + `"title":"Internal Server Error"` + "`" + `) {
+		t.Errorf("document = %s, want the title of the status", body)
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Contains(t, string(body), ` + "`" + `"title":"Internal Server Error"` + "`" + `)
+{{- end }}
+}
+
+func TestFromError(t *testing.T) {
+	for _, tc := range fromErrorTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := FromError(tc.err)
+{{- if eq .TestingFramework "standard" }}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FromError() = %+v, want %+v", got, tc.want)
+			}
+{{- else }}
+			assert.Equal(t, tc.want, got)
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
@@ -0,0 +1,485 @@
+package templates
+
+// Rate limiting templates for the middleware package
+
+const RateLimit = `package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/problem"
+)
+
+// APIKeyHeader carries the API key of a request. API Gateway usage plans
+// meter the same header.
+const APIKeyHeader = "X-Api-Key"
+
+// Rate limit headers of API responses
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// Limit is a token bucket: Burst requests may arrive at once, and the
+// bucket refills at Rate requests per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// enabled reports whether the limit throttles anything; a zero Rate or
+// Burst turns a bucket off
+func (l Limit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Bucket is the state of one token bucket
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// RateLimitDecision is the outcome of taking a token from a bucket
+type RateLimitDecision struct {
+	Allowed bool
+	// Remaining is how many whole tokens are left
+	Remaining int
+	// RetryAfter is how long until the next token, when not allowed
+	RetryAfter time.Duration
+	// ResetAt is when the bucket is full again
+	ResetAt time.Time
+}
+
+// take refills bucket up to now and takes a token from it. A nil bucket is
+// full. The returned bucket is the state to store.
+func (l Limit) take(bucket *Bucket, now time.Time) (Bucket, RateLimitDecision) {
+	tokens := float64(l.Burst)
+	if bucket != nil {
+		elapsed := math.Max(now.Sub(bucket.UpdatedAt).Seconds(), 0)
+		tokens = math.Min(tokens, bucket.Tokens+elapsed*l.Rate)
+	}
+
+	var decision RateLimitDecision
+	if tokens >= 1 {
+		tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsDuration((1 - tokens) / l.Rate)
+	}
+	decision.Remaining = int(tokens)
+	decision.ResetAt = now.Add(secondsDuration((float64(l.Burst) - tokens) / l.Rate))
+
+	return Bucket{Tokens: tokens, UpdatedAt: now}, decision
+}
+
+// RateLimitStore keeps token buckets
+type RateLimitStore interface {
+	// Peek refills the bucket of key up to now and reports whether a token
+	// could be taken from it, without taking one
+	Peek(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error)
+
+	// Take refills the bucket of key up to now and takes a token from it
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error)
+}
+
+// RateLimitKeyFunc returns who a request is limited as, or "" when the
+// rule does not apply to it
+type RateLimitKeyFunc func(ctx context.Context, request apievent.Request) string
+
+// RateLimitRule gives each caller its Key function tells apart a bucket of
+// their own
+type RateLimitRule struct {
+	// Name tells the rule's buckets apart from those of other rules
+	Name  string
+	Key   RateLimitKeyFunc
+	Limit Limit
+}
+
+// PerAPIKey limits each API key, read from the X-Api-Key header
+func PerAPIKey(limit Limit) RateLimitRule {
+	return RateLimitRule{Name: "api_key", Key: APIKey, Limit: limit}
+}
+
+// PerUser limits each user, as identified by the UserID middleware, which
+// must run before RateLimit
+func PerUser(limit Limit) RateLimitRule {
+	return RateLimitRule{
+		Name: "user",
+		Key: func(ctx context.Context, request apievent.Request) string {
+			return GetUserID(ctx)
+		},
+		Limit: limit,
+	}
+}
+
+// APIKey returns the X-Api-Key header of request
+func APIKey(ctx context.Context, request apievent.Request) string {
+	return apievent.Header(request, APIKeyHeader)
+}
+
+// RateLimitConfig configures the rate limit middleware
+type RateLimitConfig struct {
+	// Store keeps the buckets: an InMemoryRateLimitStore limits each
+	// container on its own, a DynamoDBRateLimitStore all of them together
+	Store RateLimitStore
+
+	// Rules are checked in order; each takes a token from its own bucket
+	// once all of them allow the request
+	Rules []RateLimitRule
+
+	// Now returns the current time (default time.Now)
+	Now func() time.Time
+}
+
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	return c
+}
+
+// RateLimit answers 429 with a Retry-After header once a bucket of the
+// request is empty. Each rule whose key function returns a key has a
+// bucket of its own, so a user is limited whatever API key they call with.
+// Every bucket is checked before a token is taken from any, so a request
+// one rule rejects does not use up the tokens of the others. Other
+// responses carry the X-RateLimit headers of the bucket with the fewest
+// tokens left. Keys are hashed before they reach the store. When the store
+// fails the request is let through: losing the limit for a moment is
+// better than failing the API.
+func RateLimit(cfg RateLimitConfig) APIMiddleware {
+	cfg = cfg.withDefaults()
+
+	return func(next APIHandlerFunc) APIHandlerFunc {
+		return func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+			now := cfg.Now()
+
+			var buckets []rateLimitBucket
+			for _, rule := range cfg.Rules {
+				if !rule.Limit.enabled() {
+					continue
+				}
+				key := rule.Key(ctx, request)
+				if key == "" {
+					continue
+				}
+				bucket := rateLimitBucket{rule: rule, key: "ratelimit#" + rule.Name + "#" + hashParts(key)}
+
+				decision, err := cfg.Store.Peek(ctx, bucket.key, rule.Limit, now)
+				if err != nil {
+					storeUnavailable(ctx, rule, err)
+					continue
+				}
+				if !decision.Allowed {
+					return rateLimited(ctx, request, rule, decision), nil
+				}
+				buckets = append(buckets, bucket)
+			}
+
+			var (
+				tightest      *RateLimitDecision
+				tightestLimit Limit
+			)
+			for _, bucket := range buckets {
+				decision, err := cfg.Store.Take(ctx, bucket.key, bucket.rule.Limit, now)
+				if err != nil {
+					storeUnavailable(ctx, bucket.rule, err)
+					continue
+				}
+				if !decision.Allowed {
+					// A concurrent request took the last token since Peek
+					return rateLimited(ctx, request, bucket.rule, decision), nil
+				}
+				if tightest == nil || decision.Remaining < tightest.Remaining {
+					tightest, tightestLimit = &decision, bucket.rule.Limit
+				}
+			}
+
+			response, err := next(ctx, request)
+			if tightest != nil {
+				setRateLimitHeaders(&response, tightestLimit, *tightest)
+			}
+			return response, err
+		}
+	}
+}
+
+// rateLimitBucket is the bucket a rule keeps for the caller of a request
+type rateLimitBucket struct {
+	rule RateLimitRule
+	key  string
+}
+
+func storeUnavailable(ctx context.Context, rule RateLimitRule, err error) {
+	contextLogger(ctx).Warn().
+		Err(err).
+		Str("rule", rule.Name).
+		Msg("Rate limit store unavailable, request let through")
+}
+
+// rateLimited answers a request whose bucket of rule is empty
+func rateLimited(ctx context.Context, request apievent.Request, rule RateLimitRule, decision RateLimitDecision) apievent.Response {
+	contextLogger(ctx).Warn().
+		Str("rule", rule.Name).
+		Dur("retry_after", decision.RetryAfter).
+		Msg("Rate limit exceeded")
+	metrics.Add(ctx, "RateLimited", metrics.Count, 1)
+
+	retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+	p := problem.New(problem.TypeTooManyRequests, fmt.Sprintf("Rate limit of %s exceeded, retry in %d seconds", rule.Name, retryAfter)).
+		With("retry_after", retryAfter)
+
+	response := problem.Response(ctx, request, p)
+	setRateLimitHeaders(&response, rule.Limit, decision)
+	response.Headers[RetryAfterHeader] = strconv.Itoa(retryAfter)
+	return response
+}
+
+func setRateLimitHeaders(response *apievent.Response, limit Limit, decision RateLimitDecision) {
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	response.Headers[RateLimitLimitHeader] = strconv.Itoa(limit.Burst)
+	response.Headers[RateLimitRemainingHeader] = strconv.Itoa(decision.Remaining)
+	response.Headers[RateLimitResetHeader] = strconv.FormatInt(decision.ResetAt.Unix(), 10)
+}
+
+// secondsDuration converts fractional seconds to a Duration
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+`
+
+const RateLimitMemoryStore = `package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxInMemoryBuckets is how many buckets an InMemoryRateLimitStore holds
+// before it drops the full ones
+const maxInMemoryBuckets = 10000
+
+// InMemoryRateLimitStore is a RateLimitStore that limits each container on
+// its own. Lambda runs a container per concurrent request, so the limit
+// of a client adds up over the containers serving it; use a
+// DynamoDBRateLimitStore for limits that must hold globally.
+type InMemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]inMemoryBucket
+}
+
+type inMemoryBucket struct {
+	Bucket
+	fullAt time.Time
+}
+
+// NewInMemoryRateLimitStore creates an empty in-memory store
+func NewInMemoryRateLimitStore() *InMemoryRateLimitStore {
+	return &InMemoryRateLimitStore{
+		buckets: make(map[string]inMemoryBucket),
+	}
+}
+
+// Peek implements RateLimitStore
+func (s *InMemoryRateLimitStore) Peek(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *Bucket
+	if existing, ok := s.buckets[key]; ok {
+		current = &existing.Bucket
+	}
+
+	_, decision := limit.take(current, now)
+	return decision, nil
+}
+
+// Take implements RateLimitStore
+func (s *InMemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *Bucket
+	if existing, ok := s.buckets[key]; ok {
+		current = &existing.Bucket
+	}
+
+	bucket, decision := limit.take(current, now)
+	if len(s.buckets) >= maxInMemoryBuckets {
+		s.dropFull(now)
+	}
+	s.buckets[key] = inMemoryBucket{Bucket: bucket, fullAt: decision.ResetAt}
+
+	return decision, nil
+}
+
+// dropFull forgets the buckets that have refilled; a missing bucket is
+// full, so this changes no decision
+func (s *InMemoryRateLimitStore) dropFull(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets held
+func (s *InMemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.buckets)
+}
+`
+
+const RateLimitDynamoDBStore = `package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// rateLimitAttempts bounds how often Take retries a bucket that
+// concurrent requests keep updating
+const rateLimitAttempts = 5
+
+// RateLimitDynamoDBAPI is the subset of the DynamoDB client used by
+// DynamoDBRateLimitStore
+type RateLimitDynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBRateLimitStore keeps token buckets in a table keyed on "id", so
+// a limit holds across every container of the function. The table's TTL
+// attribute is "expires_at": a bucket is deleted once it has refilled,
+// since a missing bucket is full.
+type DynamoDBRateLimitStore struct {
+	client    RateLimitDynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBRateLimitStore creates a store backed by tableName
+func NewDynamoDBRateLimitStore(client RateLimitDynamoDBAPI, tableName string) *DynamoDBRateLimitStore {
+	return &DynamoDBRateLimitStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// Peek implements RateLimitStore. It only reads the bucket.
+func (s *DynamoDBRateLimitStore) Peek(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error) {
+	current, err := s.get(ctx, key)
+	if err != nil {
+		return RateLimitDecision{}, err
+	}
+
+	_, decision := limit.take(current, now)
+	return decision, nil
+}
+
+// Take implements RateLimitStore. The bucket is read, refilled and written
+// back on condition that nobody updated it in between; a request that
+// loses the race reads it again.
+func (s *DynamoDBRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error) {
+	for attempt := 0; attempt < rateLimitAttempts; attempt++ {
+		current, err := s.get(ctx, key)
+		if err != nil {
+			return RateLimitDecision{}, err
+		}
+
+		bucket, decision := limit.take(current, now)
+		if !decision.Allowed {
+			// Nothing was taken, so there is nothing to write
+			return decision, nil
+		}
+
+		err = s.put(ctx, key, bucket, current, decision.ResetAt)
+		var conditionFailed *types.ConditionalCheckFailedException
+		switch {
+		case err == nil:
+			return decision, nil
+		case !errors.As(err, &conditionFailed):
+			return RateLimitDecision{}, fmt.Errorf("failed to put rate limit bucket: %w", err)
+		}
+	}
+	return RateLimitDecision{}, fmt.Errorf("rate limit bucket %q changed on every one of %d attempts", key, rateLimitAttempts)
+}
+
+// put writes bucket unless the stored bucket is no longer previous
+func (s *DynamoDBRateLimitStore) put(ctx context.Context, key string, bucket Bucket, previous *Bucket, fullAt time.Time) error {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			"id":         &types.AttributeValueMemberS{Value: key},
+			"tokens":     &types.AttributeValueMemberN{Value: strconv.FormatFloat(bucket.Tokens, 'f', -1, 64)},
+			"updated_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(bucket.UpdatedAt.UnixNano(), 10)},
+			// TTL deletes lag, so a bucket is only ever deleted once full
+			"expires_at": unixAttribute(fullAt.Add(time.Second)),
+		},
+	}
+	if previous == nil {
+		input.ConditionExpression = aws.String("attribute_not_exists(#id)")
+		input.ExpressionAttributeNames = map[string]string{"#id": "id"}
+	} else {
+		input.ConditionExpression = aws.String("updated_at = :previous")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":previous": &types.AttributeValueMemberN{Value: strconv.FormatInt(previous.UpdatedAt.UnixNano(), 10)},
+		}
+	}
+
+	_, err := s.client.PutItem(ctx, input)
+	return err
+}
+
+func (s *DynamoDBRateLimitStore) get(ctx context.Context, key string) (*Bucket, error) {
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate limit bucket: %w", err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	tokens, ok := output.Item["tokens"].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, fmt.Errorf("rate limit bucket %q has no tokens", key)
+	}
+	bucket := &Bucket{}
+	if bucket.Tokens, err = strconv.ParseFloat(tokens.Value, 64); err != nil {
+		return nil, fmt.Errorf("rate limit bucket %q has invalid tokens: %w", key, err)
+	}
+	if updatedAt, ok := output.Item["updated_at"].(*types.AttributeValueMemberN); ok {
+		nanos, err := strconv.ParseInt(updatedAt.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit bucket %q has invalid updated_at: %w", key, err)
+		}
+		bucket.UpdatedAt = time.Unix(0, nanos)
+	}
+	return bucket, nil
+}
+`
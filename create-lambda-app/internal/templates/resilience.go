@@ -0,0 +1,1048 @@
+package templates
+
+// Resilience templates for calling downstream services
+
+const Resilience = `// Package resilience makes calls to downstream services reliable. Retry
+// retries failed calls with jittered exponential backoff, a CircuitBreaker
+// stops calling a service that keeps failing and a Bulkhead caps how many
+// calls to a service run at once. InstrumentAWS gives AWS SDK clients the
+// same backoff.
+//
+// Lambda reuses a container for many invocations, so circuit breakers and
+// bulkheads kept in package variables, like those Breaker returns, carry
+// their state over between warm invocations: a service that failed the
+// last invocations stays cut off for the next ones.
+//
+// Retries, rejections and circuit breaker state changes are logged and
+// recorded with pkg/metrics. Time comes from a Clock, and tests pass a
+// FakeClock so backoff and open circuits take no real time.
+package resilience
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+{{- if eq .Architecture "clean" }}
+
+	apperrors "{{.Module}}/pkg/errors"
+{{- end }}
+)
+
+var (
+	// ErrCircuitOpen is returned instead of calling a service whose
+	// circuit breaker is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrBulkheadFull is returned instead of calling a service that has as
+	// many calls in flight as its bulkhead allows
+	ErrBulkheadFull = errors.New("bulkhead is full")
+)
+
+// permanentError marks an error retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// rejectedError is returned when a call to a service is rejected without
+// being made
+type rejectedError struct {
+	name  string
+	cause error
+}
+
+func (e *rejectedError) Error() string { return e.name + ": " + e.cause.Error() }
+{{- if eq .Architecture "clean" }}
+
+// Unwrap makes a rejected call an external error too, which the API
+// answers 502
+func (e *rejectedError) Unwrap() []error {
+	return []error{e.cause, apperrors.NewExternalError(e.name, e.cause.Error())}
+}
+{{- else }}
+func (e *rejectedError) Unwrap() error { return e.cause }
+{{- end }}
+
+// IsRetryable reports whether a call that failed with err may succeed when
+// made again: throttling, timeouts, 5xx responses and connection errors of
+// the AWS SDK
+{{- if eq .Architecture "clean" }}, and the external and timeout errors of
+// pkg/errors
+{{- end }}. Errors marked Permanent, cancellation and rejections by a
+// circuit breaker or bulkhead are not.
+func IsRetryable(err error) bool {
+	var permanent permanentError
+	switch {
+	case err == nil, errors.As(err, &permanent):
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrBulkheadFull):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		// The attempt timed out; Retry stops by itself once the deadline
+		// of the whole call has passed
+		return true
+{{- if eq .Architecture "clean" }}
+	case apperrors.IsExternalError(err), apperrors.IsTimeoutError(err):
+		return true
+{{- end }}
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+`
+
+const ResilienceClock = `package resilience
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+
+	// Sleep waits for d, or returns the error of ctx when it is done first
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// SystemClock is the real Clock
+type SystemClock struct{}
+
+// Now implements Clock
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Sleep implements Clock
+func (SystemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FakeClock is a Clock for tests. Sleep returns at once and moves the clock
+// forward, and the durations slept are kept for assertions.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// NewFakeClock creates a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now implements Clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep implements Clock
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+	return nil
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sleeps returns the durations slept so far
+func (c *FakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+`
+
+const ResilienceRetry = `package resilience
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/metrics"
+)
+
+// Backoff is an exponential backoff. Retry n waits Initial times
+// Multiplier to the power n-1, at most Max, less a random part of up to
+// Jitter of it, so callers that failed together do not retry together.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// DefaultBackoff starts at 100ms and doubles up to 5s, with full jitter
+var DefaultBackoff = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        5 * time.Second,
+	Multiplier: 2,
+	Jitter:     1,
+}
+
+// Delay returns how long to wait before retry n, counted from 1. random is
+// a number in [0, 1) that picks the jitter.
+func (b Backoff) Delay(n int, random float64) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(n-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	delay -= delay * b.Jitter * random
+	return time.Duration(delay)
+}
+
+// RetryPolicy configures Retry
+type RetryPolicy struct {
+	// Name identifies the service called in logs
+	Name string
+
+	// MaxAttempts counts the first call too; it defaults to 3
+	MaxAttempts int
+
+	// Backoff defaults to DefaultBackoff
+	Backoff Backoff
+
+	// Retryable decides which errors are retried; it defaults to
+	// IsRetryable
+	Retryable func(error) bool
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	// Random returns numbers in [0, 1) for the jitter; it defaults to
+	// math/rand
+	Random func() float64
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.Backoff == (Backoff{}) {
+		p.Backoff = DefaultBackoff
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	if p.Clock == nil {
+		p.Clock = SystemClock{}
+	}
+	if p.Random == nil {
+		p.Random = rand.Float64
+	}
+	return p
+}
+
+// Retry calls fn until it succeeds, fails with an error the policy does
+// not retry or has been called MaxAttempts times, waiting the backoff
+// between calls. It gives up early when ctx is done or its deadline would
+// pass while waiting, so retries never outlive the invocation. The error
+// of the last call is returned as is.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	_, err := RetryValue(ctx, policy, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// RetryValue is Retry for calls that return a value
+func RetryValue[T any](ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) (T, error)) (T, error) {
+	policy = policy.withDefaults()
+	logger := log.Ctx(ctx)
+
+	for attempt := 1; ; attempt++ {
+		value, err := fn(ctx)
+		if err == nil || !policy.Retryable(err) {
+			return value, err
+		}
+
+		delay := policy.Backoff.Delay(attempt, policy.Random())
+		deadline, hasDeadline := ctx.Deadline()
+		if attempt >= policy.MaxAttempts || (hasDeadline && policy.Clock.Now().Add(delay).After(deadline)) {
+			logger.Warn().Err(err).
+				Str("dependency", policy.Name).
+				Int("attempts", attempt).
+				Msg("Giving up retrying call")
+			metrics.Add(ctx, "RetriesExhausted", metrics.Count, 1)
+			return value, err
+		}
+
+		logger.Warn().Err(err).
+			Str("dependency", policy.Name).
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Msg("Retrying call")
+		metrics.Add(ctx, "Retries", metrics.Count, 1)
+
+		if policy.Clock.Sleep(ctx, delay) != nil {
+			return value, err
+		}
+	}
+}
+`
+
+const ResilienceBreaker = `package resilience
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/metrics"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+// Circuit breaker states
+const (
+	// StateClosed lets calls through
+	StateClosed State = iota
+	// StateOpen rejects calls
+	StateOpen
+	// StateHalfOpen lets a trial call through
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerSettings configures a CircuitBreaker
+type BreakerSettings struct {
+	// FailureThreshold is how many calls in a row must fail to open the
+	// circuit; it defaults to 5
+	FailureThreshold int
+
+	// OpenTimeout is how long the circuit stays open before a trial call
+	// is let through; it defaults to 30s
+	OpenTimeout time.Duration
+
+	// IsFailure decides which errors count against the service; it
+	// defaults to IsRetryable, so a call rejected as invalid does not
+	IsFailure func(error) bool
+
+	// Clock defaults to SystemClock
+	Clock Clock
+}
+
+func (s BreakerSettings) withDefaults() BreakerSettings {
+	if s.FailureThreshold <= 0 {
+		s.FailureThreshold = 5
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = 30 * time.Second
+	}
+	if s.IsFailure == nil {
+		s.IsFailure = IsRetryable
+	}
+	if s.Clock == nil {
+		s.Clock = SystemClock{}
+	}
+	return s
+}
+
+// CircuitBreaker stops calling a service that keeps failing. After
+// FailureThreshold failures in a row the circuit opens and calls are
+// rejected with ErrCircuitOpen for OpenTimeout. Then a single trial call is
+// let through: if it succeeds the circuit closes, otherwise it opens again.
+type CircuitBreaker struct {
+	name     string
+	settings BreakerSettings
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the service name
+func NewCircuitBreaker(name string, settings BreakerSettings) *CircuitBreaker {
+	return &CircuitBreaker{name: name, settings: settings.withDefaults()}
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*CircuitBreaker{}
+)
+
+// Breaker returns the circuit breaker of the service name, creating it
+// with settings on first use. It lives as long as the container, so its
+// state carries over between warm invocations.
+func Breaker(name string, settings BreakerSettings) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	breaker, ok := breakers[name]
+	if !ok {
+		breaker = NewCircuitBreaker(name, settings)
+		breakers[name] = breaker
+	}
+	return breaker
+}
+
+// State returns the state of the circuit
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.openTimedOut() {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Execute calls fn unless the circuit is open
+func (b *CircuitBreaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.allow(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			b.record(ctx, fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+	}()
+
+	err := fn(ctx)
+	b.record(ctx, err)
+	return err
+}
+
+// allow reserves a call, or rejects it while the circuit is open
+func (b *CircuitBreaker) allow(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.openTimedOut() {
+		b.transition(ctx, StateHalfOpen)
+	}
+
+	switch {
+	case b.state == StateOpen, b.state == StateHalfOpen && b.trial:
+		log.Ctx(ctx).Warn().Str("dependency", b.name).Msg("Circuit breaker rejected call")
+		metrics.Add(ctx, "CircuitBreakerRejected", metrics.Count, 1)
+		return &rejectedError{name: b.name, cause: ErrCircuitOpen}
+	case b.state == StateHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// record counts the outcome of a call
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := err != nil && b.settings.IsFailure(err)
+	switch b.state {
+	case StateHalfOpen:
+		b.trial = false
+		if failed {
+			b.open(ctx)
+		} else {
+			b.failures = 0
+			b.transition(ctx, StateClosed)
+		}
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.open(ctx)
+		}
+	}
+}
+
+func (b *CircuitBreaker) open(ctx context.Context) {
+	b.openedAt = b.settings.Clock.Now()
+	b.transition(ctx, StateOpen)
+	metrics.Add(ctx, "CircuitBreakerOpened", metrics.Count, 1)
+}
+
+func (b *CircuitBreaker) openTimedOut() bool {
+	return b.settings.Clock.Now().Sub(b.openedAt) >= b.settings.OpenTimeout
+}
+
+func (b *CircuitBreaker) transition(ctx context.Context, state State) {
+	log.Ctx(ctx).Warn().
+		Str("dependency", b.name).
+		Stringer("from", b.state).
+		Stringer("to", state).
+		Int("failures", b.failures).
+		Msg("Circuit breaker changed state")
+	b.state = state
+}
+`
+
+const ResilienceBulkhead = `package resilience
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/metrics"
+)
+
+// Bulkhead caps the calls in flight to a service, so a slow service cannot
+// tie up every goroutine and connection of the container. A call that
+// finds the bulkhead full waits up to maxWait for another to finish and is
+// then rejected with ErrBulkheadFull.
+type Bulkhead struct {
+	name    string
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// NewBulkhead creates a bulkhead that lets maxConcurrent calls to the
+// service name run at once
+func NewBulkhead(name string, maxConcurrent int, maxWait time.Duration) *Bulkhead {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &Bulkhead{
+		name:    name,
+		slots:   make(chan struct{}, maxConcurrent),
+		maxWait: maxWait,
+	}
+}
+
+// Execute calls fn once the bulkhead has room for it
+func (b *Bulkhead) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+	defer func() { <-b.slots }()
+
+	return fn(ctx)
+}
+
+// InFlight returns how many calls are running
+func (b *Bulkhead) InFlight() int {
+	return len(b.slots)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if b.maxWait > 0 {
+		timer := time.NewTimer(b.maxWait)
+		defer timer.Stop()
+
+		select {
+		case b.slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	log.Ctx(ctx).Warn().Str("dependency", b.name).Int("in_flight", b.InFlight()).Msg("Bulkhead rejected call")
+	metrics.Add(ctx, "BulkheadRejected", metrics.Count, 1)
+	return &rejectedError{name: b.name, cause: ErrBulkheadFull}
+}
+`
+
+const ResilienceAWS = `package resilience
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/metrics"
+)
+
+// InstrumentAWS makes the clients created from cfg retry with the SDK's
+// standard retryer and backoff, up to cfg.RetryMaxAttempts attempts
+// (AWS_MAX_ATTEMPTS, 3 by default). The SDK decides which errors are
+// retried. Each retried attempt is logged and counted in the AWSRetries
+// metric of the invocation.
+func InstrumentAWS(cfg *aws.Config, backoff Backoff) {
+	maxAttempts := cfg.RetryMaxAttempts
+	cfg.Retryer = func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			if maxAttempts > 0 {
+				o.MaxAttempts = maxAttempts
+			}
+			o.Backoff = retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
+				return backoff.Delay(attempt, rand.Float64()), nil
+			})
+		})
+	}
+
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		// After, so the service and operation names are in the context
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ResilienceRetries", recordAWSRetries), middleware.After)
+	})
+}
+
+func recordAWSRetries(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	out, metadata, err := next.HandleInitialize(ctx, in)
+
+	results, ok := retry.GetAttemptResults(metadata)
+	if !ok {
+		return out, metadata, err
+	}
+
+	dependency := awsmiddleware.GetServiceID(ctx) + "." + awsmiddleware.GetOperationName(ctx)
+	retries := 0
+	for i, attempt := range results.Results {
+		if !attempt.Retried {
+			continue
+		}
+		retries++
+		log.Ctx(ctx).Warn().Err(attempt.Err).
+			Str("dependency", dependency).
+			Int("attempt", i+1).
+			Msg("Retrying AWS call")
+	}
+	if retries > 0 {
+		metrics.Add(ctx, "AWSRetries", metrics.Count, float64(retries))
+	}
+	return out, metadata, err
+}
+`
+
+const ResilienceTest = `package resilience
+
+import (
+	"context"
+	"errors"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+
+	"github.com/aws/smithy-go"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/metrics"
+)
+
+// throttled is an error the AWS SDK retries
+var throttled = &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+
+// retryResult is what a Retry call did
+type retryResult struct {
+	err     error
+	calls   int
+	sleeps  []time.Duration
+	retries []float64
+}
+
+// retryFailing retries a call that fails with err failures times, with a
+// backoff of 100ms, 200ms, 400ms and so on. A timeout other than zero
+// gives the call a deadline.
+func retryFailing(failures int, err error, maxAttempts int, timeout time.Duration) retryResult {
+	capture := &metrics.Capture{}
+	metrics.Init(metrics.Options{Namespace: "test", Service: "orders", Output: capture})
+	m := metrics.New()
+	ctx := metrics.NewContext(context.Background(), m)
+
+	clock := NewFakeClock(time.Now())
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, clock.Now().Add(timeout))
+		defer cancel()
+	}
+
+	var result retryResult
+	result.err = Retry(ctx, RetryPolicy{
+		Name:        "users",
+		MaxAttempts: maxAttempts,
+		Backoff:     Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2},
+		Clock:       clock,
+	}, func(context.Context) error {
+		result.calls++
+		if result.calls <= failures {
+			return err
+		}
+		return nil
+	})
+
+	m.Flush()
+	result.sleeps = clock.Sleeps()
+	for _, doc := range capture.Documents() {
+		result.retries = append(result.retries, doc.Values("Retries")...)
+	}
+	return result
+}
+
+// breakerRun is what runBreaker saw
+type breakerRun struct {
+	// states after two failures, after the open timeout and after the
+	// trial call
+	states   []State
+	rejected error
+	calls    int
+}
+
+// runBreaker trips a breaker with a threshold of two, calls it while it
+// is open, waits out the open timeout and makes a trial call that returns
+// trialErr
+func runBreaker(trialErr error) breakerRun {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	breaker := NewCircuitBreaker("users", BreakerSettings{FailureThreshold: 2, OpenTimeout: 30 * time.Second, Clock: clock})
+
+	var run breakerRun
+	call := func(err error) func(context.Context) error {
+		return func(context.Context) error {
+			run.calls++
+			return err
+		}
+	}
+
+	_ = breaker.Execute(ctx, call(throttled))
+	_ = breaker.Execute(ctx, call(throttled))
+	run.states = append(run.states, breaker.State())
+	run.rejected = breaker.Execute(ctx, call(nil))
+	clock.Advance(30 * time.Second)
+	run.states = append(run.states, breaker.State())
+	_ = breaker.Execute(ctx, call(trialErr))
+	run.states = append(run.states, breaker.State())
+	return run
+}
+
+// failPermanently fails calls through a breaker with a threshold of two
+// with an error that does not count against the service
+func failPermanently() State {
+	breaker := NewCircuitBreaker("users", BreakerSettings{FailureThreshold: 2})
+	for i := 0; i < 3; i++ {
+		_ = breaker.Execute(context.Background(), func(context.Context) error {
+			return Permanent(errors.New("user not found"))
+		})
+	}
+	return breaker.State()
+}
+
+// fillBulkhead calls a bulkhead of one while another call holds it, and
+// again once that call is done
+func fillBulkhead() (whileFull, afterwards error) {
+	ctx := context.Background()
+	bulkhead := NewBulkhead("users", 1, 0)
+	noop := func(context.Context) error { return nil }
+
+	started, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- bulkhead.Execute(ctx, func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+	whileFull = bulkhead.Execute(ctx, noop)
+	close(release)
+	<-done
+	afterwards = bulkhead.Execute(ctx, noop)
+	return whileFull, afterwards
+}
+
+var jitteredBackoff = Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2, Jitter: 1}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Retry", func() {
+	It("retries until the call succeeds", func() {
+		result := retryFailing(2, throttled, 5, 0)
+
+		Expect(result.err).NotTo(HaveOccurred())
+		Expect(result.calls).To(Equal(3))
+		Expect(result.sleeps).To(Equal([]time.Duration{100 * time.Millisecond, 200 * time.Millisecond}))
+		Expect(result.retries).To(Equal([]float64{1, 1}))
+	})
+
+	It("returns the last error after MaxAttempts", func() {
+		result := retryFailing(5, throttled, 3, 0)
+
+		Expect(errors.Is(result.err, throttled)).To(BeTrue())
+		Expect(result.calls).To(Equal(3))
+	})
+
+	It("does not retry permanent errors", func() {
+		result := retryFailing(5, Permanent(errors.New("invalid user")), 3, 0)
+
+		Expect(result.err).To(MatchError("invalid user"))
+		Expect(result.calls).To(Equal(1))
+	})
+
+	It("gives up when the deadline would pass while waiting", func() {
+		result := retryFailing(10, throttled, 10, time.Second)
+
+		Expect(errors.Is(result.err, throttled)).To(BeTrue())
+		Expect(result.calls).To(Equal(4))
+		Expect(result.sleeps).To(HaveLen(3))
+	})
+})
+
+var _ = Describe("Backoff", func() {
+	It("grows exponentially up to Max, less the jitter", func() {
+		Expect(jitteredBackoff.Delay(1, 0)).To(Equal(100 * time.Millisecond))
+		Expect(jitteredBackoff.Delay(1, 0.25)).To(Equal(75 * time.Millisecond))
+		Expect(jitteredBackoff.Delay(3, 0)).To(Equal(400 * time.Millisecond))
+		Expect(jitteredBackoff.Delay(5, 0.5)).To(Equal(500 * time.Millisecond))
+	})
+})
+
+var _ = Describe("CircuitBreaker", func() {
+	It("opens after repeated failures and closes after a successful trial", func() {
+		run := runBreaker(nil)
+
+		Expect(run.states).To(Equal([]State{StateOpen, StateHalfOpen, StateClosed}))
+		Expect(errors.Is(run.rejected, ErrCircuitOpen)).To(BeTrue())
+		Expect(run.calls).To(Equal(3))
+	})
+
+	It("opens again when the trial fails", func() {
+		run := runBreaker(throttled)
+
+		Expect(run.states).To(Equal([]State{StateOpen, StateHalfOpen, StateOpen}))
+	})
+
+	It("does not count errors that are not the service's fault", func() {
+		Expect(failPermanently()).To(Equal(StateClosed))
+	})
+
+	It("is shared by name", func() {
+		Expect(Breaker("orders", BreakerSettings{})).To(BeIdenticalTo(Breaker("orders", BreakerSettings{})))
+	})
+})
+
+var _ = Describe("Bulkhead", func() {
+	It("rejects calls while it is full", func() {
+		whileFull, afterwards := fillBulkhead()
+
+		Expect(errors.Is(whileFull, ErrBulkheadFull)).To(BeTrue())
+		Expect(afterwards).NotTo(HaveOccurred())
+	})
+})
+{{- else }}
+
+func TestRetryRetriesUntilSuccess(t *testing.T) {
+	result := retryFailing(2, throttled, 5, 0)
+{{- if eq .TestingFramework "standard" }}
+	if result.err != nil {
+		t.Fatalf("Retry() error = %v", result.err)
+	}
+	if result.calls != 3 {
+		t.Errorf("calls = %d, want 3", result.calls)
+	}
+	if len(result.sleeps) != 2 || result.sleeps[0] != 100*time.Millisecond || result.sleeps[1] != 200*time.Millisecond {
+		t.Errorf("sleeps = %v, want [100ms 200ms]", result.sleeps)
+	}
+	if len(result.retries) != 2 {
+		t.Errorf("Retries = %v, want [1 1]", result.retries)
+	}
+{{- else }}
+	assert.NoError(t, result.err)
+	assert.Equal(t, 3, result.calls)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, result.sleeps)
+	assert.Equal(t, []float64{1, 1}, result.retries)
+{{- end }}
+}
+
+func TestRetryStopsAfterMaxAttempts(t *testing.T) {
+	result := retryFailing(5, throttled, 3, 0)
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(result.err, throttled) {
+		t.Errorf("Retry() error = %v, want %v", result.err, throttled)
+	}
+	if result.calls != 3 {
+		t.Errorf("calls = %d, want 3", result.calls)
+	}
+{{- else }}
+	assert.ErrorIs(t, result.err, throttled)
+	assert.Equal(t, 3, result.calls)
+{{- end }}
+}
+
+func TestRetrySkipsPermanentErrors(t *testing.T) {
+	result := retryFailing(5, Permanent(errors.New("invalid user")), 3, 0)
+{{- if eq .TestingFramework "standard" }}
+	if result.err == nil || result.err.Error() != "invalid user" {
+		t.Errorf("Retry() error = %v, want invalid user", result.err)
+	}
+	if result.calls != 1 {
+		t.Errorf("calls = %d, want 1", result.calls)
+	}
+{{- else }}
+	assert.EqualError(t, result.err, "invalid user")
+	assert.Equal(t, 1, result.calls)
+{{- end }}
+}
+
+func TestRetryGivesUpBeforeDeadline(t *testing.T) {
+	result := retryFailing(10, throttled, 10, time.Second)
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(result.err, throttled) {
+		t.Errorf("Retry() error = %v, want %v", result.err, throttled)
+	}
+	if result.calls != 4 || len(result.sleeps) != 3 {
+		t.Errorf("made %d calls and slept %v, want 4 calls and 3 sleeps", result.calls, result.sleeps)
+	}
+{{- else }}
+	assert.ErrorIs(t, result.err, throttled)
+	assert.Equal(t, 4, result.calls)
+	assert.Len(t, result.sleeps, 3)
+{{- end }}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for _, tc := range []struct {
+		retry  int
+		random float64
+		want   time.Duration
+	}{
+		{retry: 1, random: 0, want: 100 * time.Millisecond},
+		{retry: 1, random: 0.25, want: 75 * time.Millisecond},
+		{retry: 3, random: 0, want: 400 * time.Millisecond},
+		{retry: 5, random: 0.5, want: 500 * time.Millisecond},
+	} {
+{{- if eq .TestingFramework "standard" }}
+		if got := jitteredBackoff.Delay(tc.retry, tc.random); got != tc.want {
+			t.Errorf("Delay(%d, %v) = %v, want %v", tc.retry, tc.random, got, tc.want)
+		}
+{{- else }}
+		assert.Equal(t, tc.want, jitteredBackoff.Delay(tc.retry, tc.random), "Delay(%d, %v)", tc.retry, tc.random)
+{{- end }}
+	}
+}
+
+func TestCircuitBreakerClosesAfterTrial(t *testing.T) {
+	run := runBreaker(nil)
+{{- if eq .TestingFramework "standard" }}
+	if len(run.states) != 3 || run.states[0] != StateOpen || run.states[1] != StateHalfOpen || run.states[2] != StateClosed {
+		t.Errorf("states = %v, want [open half-open closed]", run.states)
+	}
+	if !errors.Is(run.rejected, ErrCircuitOpen) {
+		t.Errorf("call while open: error = %v, want %v", run.rejected, ErrCircuitOpen)
+	}
+	if run.calls != 3 {
+		t.Errorf("calls = %d, want 3", run.calls)
+	}
+{{- else }}
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateClosed}, run.states)
+	assert.ErrorIs(t, run.rejected, ErrCircuitOpen)
+	assert.Equal(t, 3, run.calls)
+{{- end }}
+}
+
+func TestCircuitBreakerReopensAfterFailedTrial(t *testing.T) {
+	run := runBreaker(throttled)
+{{- if eq .TestingFramework "standard" }}
+	if len(run.states) != 3 || run.states[2] != StateOpen {
+		t.Errorf("states = %v, want [open half-open open]", run.states)
+	}
+{{- else }}
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen}, run.states)
+{{- end }}
+}
+
+func TestCircuitBreakerIgnoresPermanentErrors(t *testing.T) {
+{{- if eq .TestingFramework "standard" }}
+	if got := failPermanently(); got != StateClosed {
+		t.Errorf("State() = %v, want closed", got)
+	}
+{{- else }}
+	assert.Equal(t, StateClosed, failPermanently())
+{{- end }}
+}
+
+func TestBreakerIsSharedByName(t *testing.T) {
+{{- if eq .TestingFramework "standard" }}
+	if Breaker("orders", BreakerSettings{}) != Breaker("orders", BreakerSettings{}) {
+		t.Error("Breaker() returned a new breaker for the same name")
+	}
+{{- else }}
+	assert.Same(t, Breaker("orders", BreakerSettings{}), Breaker("orders", BreakerSettings{}))
+{{- end }}
+}
+
+func TestBulkheadRejectsWhileFull(t *testing.T) {
+	whileFull, afterwards := fillBulkhead()
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(whileFull, ErrBulkheadFull) {
+		t.Errorf("call while full: error = %v, want %v", whileFull, ErrBulkheadFull)
+	}
+	if afterwards != nil {
+		t.Errorf("call afterwards: error = %v", afterwards)
+	}
+{{- else }}
+	assert.ErrorIs(t, whileFull, ErrBulkheadFull)
+	assert.NoError(t, afterwards)
+{{- end }}
+}
+{{- end }}
+`
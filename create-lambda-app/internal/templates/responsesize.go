@@ -0,0 +1,292 @@
+package templates
+
+// Response size templates for the middleware package
+
+const ResponseSize = `package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/problem"
+)
+
+{{- if eq .APIType "alb" }}
+
+// MaxResponseSize is the largest response an Application Load Balancer
+// takes from a Lambda target
+const MaxResponseSize = 1024 * 1024
+{{- else }}
+
+// MaxResponseSize is the largest payload a synchronous Lambda invocation
+// may return
+const MaxResponseSize = 6 * 1024 * 1024
+{{- end }}
+
+// ResponseOffloader stores a response body too large to return and
+// returns a URL the client can fetch it from
+type ResponseOffloader interface {
+	Offload(ctx context.Context, key, contentType string, body []byte) (string, error)
+}
+
+// ResponseSizeConfig configures the response size middleware
+type ResponseSizeConfig struct {
+	// GzipMinSize is the size from which responses are gzip-compressed
+	// for clients that accept it; zero turns compression off
+	GzipMinSize int
+
+	// OffloadSize is the size above which responses are offloaded. It
+	// defaults to nine tenths of MaxResponseSize, which leaves room for
+	// the headers of the outer middleware.
+	OffloadSize int
+
+	// Offloader stores offloaded responses. Without one a response above
+	// OffloadSize is answered 500.
+	Offloader ResponseOffloader
+}
+
+func (c ResponseSizeConfig) withDefaults() ResponseSizeConfig {
+	if c.OffloadSize <= 0 {
+		c.OffloadSize = MaxResponseSize * 9 / 10
+	}
+	return c
+}
+
+// ResponseSize keeps API responses under MaxResponseSize. A response of
+// GzipMinSize bytes or more is gzip-compressed when the Accept-Encoding
+// header of the request allows it. One still larger than OffloadSize is
+// handed to the Offloader and answered 303 See Other, with the URL the
+// body can be fetched from in the Location header. The size is that of the
+// payload Lambda returns, headers and base64 encoding included, and is
+// recorded as the ResponseSize metric.
+{{- if eq .APIType "rest" }}
+//
+// REST APIs only return base64-encoded bodies as binary for the API's
+// binary media types, so keep compression off unless they are set up.
+{{- end }}
+func ResponseSize(cfg ResponseSizeConfig) APIMiddleware {
+	cfg = cfg.withDefaults()
+
+	return func(next APIHandlerFunc) APIHandlerFunc {
+		return func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+			response, err := next(ctx, request)
+			if err != nil {
+				return response, err
+			}
+
+			original := response
+			size := payloadSize(response)
+			if cfg.GzipMinSize > 0 && size >= cfg.GzipMinSize && acceptsGzip(request) && responseHeader(response, "Content-Encoding") == "" {
+				if compressed, err := compress(response); err != nil {
+					contextLogger(ctx).Warn().Err(err).Msg("Failed to compress response")
+				} else {
+					response, size = compressed, payloadSize(compressed)
+				}
+			}
+			metrics.Add(ctx, "ResponseSize", metrics.Bytes, float64(size))
+
+			if size <= cfg.OffloadSize {
+				return response, nil
+			}
+			return offload(ctx, request, original, cfg.Offloader, size), nil
+		}
+	}
+}
+
+// offload stores the body of response with offloader and redirects the
+// client to it. The CORS headers of response are kept, since browsers only
+// follow a cross-origin redirect that passes the CORS check.
+func offload(ctx context.Context, request apievent.Request, response apievent.Response, offloader ResponseOffloader, size int) apievent.Response {
+	logger := contextLogger(ctx)
+	if offloader == nil {
+		logger.Error().Int("size", size).Msg("Response too large and no offloader configured")
+		return problem.Response(ctx, request, problem.Internal())
+	}
+
+	body, err := responseBody(response)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to decode response body")
+		return problem.Response(ctx, request, problem.Internal())
+	}
+
+	key := "responses/" + uuid.NewString()
+	location, err := offloader.Offload(ctx, key, responseHeader(response, "Content-Type"), body)
+	if err != nil {
+		logger.Error().Err(err).Int("size", size).Msg("Failed to offload response")
+		return problem.Response(ctx, request, problem.Internal())
+	}
+
+	logger.Info().Int("size", size).Str("key", key).Msg("Offloaded response")
+	metrics.Add(ctx, "ResponsesOffloaded", metrics.Count, 1)
+
+	headers := map[string]string{
+		"Location":      location,
+		"Cache-Control": "no-store",
+	}
+	for key, value := range response.Headers {
+		if strings.HasPrefix(http.CanonicalHeaderKey(key), "Access-Control-") {
+			headers[key] = value
+		}
+	}
+	return apievent.NewResponse(http.StatusSeeOther, headers, "")
+}
+
+// compress returns response with its body gzip-compressed
+func compress(response apievent.Response) (apievent.Response, error) {
+	body, err := responseBody(response)
+	if err != nil {
+		return response, err
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return response, err
+	}
+	if err := writer.Close(); err != nil {
+		return response, err
+	}
+
+	headers := make(map[string]string, len(response.Headers)+2)
+	for key, value := range response.Headers {
+		headers[key] = value
+	}
+	headers["Content-Encoding"] = "gzip"
+	headers["Vary"] = "Accept-Encoding"
+
+	response.Headers = headers
+	response.Body = base64.StdEncoding.EncodeToString(buf.Bytes())
+	response.IsBase64Encoded = true
+	return response, nil
+}
+
+// payloadSize returns the size of response as Lambda returns it
+func payloadSize(response apievent.Response) int {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return len(response.Body)
+	}
+	return len(payload)
+}
+
+// responseBody returns the body of response, decoded if it is base64
+// encoded
+func responseBody(response apievent.Response) ([]byte, error) {
+	if !response.IsBase64Encoded {
+		return []byte(response.Body), nil
+	}
+	return base64.StdEncoding.DecodeString(response.Body)
+}
+
+// responseHeader returns the response header name, matched case
+// insensitively
+func responseHeader(response apievent.Response, name string) string {
+	for key, value := range response.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of request allows
+// a gzip-compressed response
+func acceptsGzip(request apievent.Request) bool {
+	for _, coding := range strings.Split(apievent.Header(request, "Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+`
+
+const ResponseOffloadS3 = `package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ResponseOffloadS3API is the part of the S3 client S3ResponseOffloader
+// uses
+type ResponseOffloadS3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// ResponseOffloadPresignAPI is the part of the S3 presign client
+// S3ResponseOffloader uses
+type ResponseOffloadPresignAPI interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// S3ResponseOffloader is a ResponseOffloader that uploads bodies to an S3
+// bucket and hands out presigned URLs that are valid for expires. The
+// bucket should delete them soon after; the response bucket of the
+// deployment expires objects after a day.
+type S3ResponseOffloader struct {
+	client    ResponseOffloadS3API
+	presigner ResponseOffloadPresignAPI
+	bucket    string
+	expires   time.Duration
+}
+
+// NewS3ResponseOffloader creates an offloader for bucket
+func NewS3ResponseOffloader(client ResponseOffloadS3API, presigner ResponseOffloadPresignAPI, bucket string, expires time.Duration) *S3ResponseOffloader {
+	return &S3ResponseOffloader{
+		client:    client,
+		presigner: presigner,
+		bucket:    bucket,
+		expires:   expires,
+	}
+}
+
+// Offload implements ResponseOffloader
+func (o *S3ResponseOffloader) Offload(ctx context.Context, key, contentType string, body []byte) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if _, err := o.client.PutObject(ctx, input); err != nil {
+		return "", fmt.Errorf("failed to upload response: %w", err)
+	}
+
+	request, err := o.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(o.expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign response URL: %w", err)
+	}
+	return request.URL, nil
+}
+`
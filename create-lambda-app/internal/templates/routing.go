@@ -0,0 +1,404 @@
+package templates
+
+// Router library templates shared by the architectures
+
+const Routing = `// Package routing builds the {{.Router}} router API requests are served
+// through. Unknown routes, methods a route does not allow and panics are
+// answered with the problem documents of pkg/problem, like the rest of the
+// API.
+package routing
+
+import (
+{{- if ne .Router "gin" }}
+	"encoding/json"
+{{- end }}
+	"fmt"
+	"net/http"
+{{ if eq .Router "gin" }}
+	"github.com/gin-gonic/gin"
+{{- else if eq .Router "chi" }}
+	"github.com/go-chi/chi/v5"
+{{- end }}
+	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/problem"
+)
+
+// Middleware wraps the handlers of the router. The middleware chain of the
+// Lambda handler already runs around every event; Middleware is for
+// concerns of the routes themselves.
+type Middleware func(http.Handler) http.Handler
+{{- if eq .Router "gin" }}
+
+// New returns a gin engine in release mode. Unlike gin's defaults it
+// answers methods a route does not allow with 405 instead of 404.
+func New(middleware ...Middleware) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+
+	engine := gin.New()
+	engine.HandleMethodNotAllowed = true
+	engine.Use(recoverer)
+	for _, mw := range middleware {
+		engine.Use(Adapt(mw))
+	}
+	engine.NoRoute(gin.WrapF(notFound))
+	engine.NoMethod(gin.WrapF(methodNotAllowed))
+	return engine
+}
+
+// Adapt runs a Middleware as gin middleware. The rest of the chain only
+// runs when the middleware calls the handler it wraps; headers it sets are
+// kept, but gin keeps writing to its own ResponseWriter.
+func Adapt(mw Middleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		called := false
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			c.Request = r
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+		if !called {
+			c.Abort()
+		}
+	}
+}
+
+// recoverer answers a panic in the handlers after it with a 500 problem
+func recoverer(c *gin.Context) {
+	defer func() {
+		if v := recover(); v != nil {
+			logPanic(c.Request, v)
+			problem.Write(c.Writer, c.Request, problem.Internal())
+			c.Abort()
+		}
+	}()
+	c.Next()
+}
+{{- else if eq .Router "chi" }}
+
+// methods are the methods a 405 answer may list in its Allow header
+var methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// New returns a chi router whose routes run behind middleware
+func New(middleware ...Middleware) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(Recover)
+	for _, mw := range middleware {
+		router.Use(mw)
+	}
+	router.NotFound(notFound)
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		// chi only sets the Allow header in its own 405 handler
+		for _, method := range methods {
+			if router.Match(chi.NewRouteContext(), method, r.URL.Path) {
+				w.Header().Add("Allow", method)
+			}
+		}
+		methodNotAllowed(w, r)
+	})
+	return router
+}
+
+// Param returns the path parameter name of the route r matched, e.g. "id"
+// of /users/{id}
+func Param(r *http.Request, name string) string {
+	return chi.URLParam(r, name)
+}
+{{- else }}
+
+// Mux is an http.ServeMux, routing with the method and wildcard patterns of
+// Go 1.22 such as "GET /users/{id}". Unlike the ServeMux it answers unknown
+// routes and methods a route does not allow with problems.
+type Mux struct {
+	*http.ServeMux
+	handler http.Handler
+}
+
+// New returns a Mux whose routes run behind middleware
+func New(middleware ...Middleware) *Mux {
+	mux := &Mux{ServeMux: http.NewServeMux()}
+
+	var handler http.Handler = http.HandlerFunc(mux.route)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	mux.handler = Recover(handler)
+	return mux
+}
+
+// ServeHTTP dispatches r to the handler of the pattern it matches
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+// route serves r through the ServeMux, replacing its plain-text 404 and 405
+// answers with problems
+func (m *Mux) route(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := m.Handler(r)
+	if pattern != "" {
+		m.ServeMux.ServeHTTP(w, r)
+		return
+	}
+
+	// Without a pattern the ServeMux answers 404 or 405, see which
+	status := &statusRecorder{header: http.Header{}}
+	handler.ServeHTTP(status, r)
+	switch status.code {
+	case http.StatusMethodNotAllowed:
+		w.Header().Set("Allow", status.header.Get("Allow"))
+		methodNotAllowed(w, r)
+	case http.StatusNotFound:
+		notFound(w, r)
+	default:
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// Param returns the path parameter name of the pattern r matched, e.g. "id"
+// of "GET /users/{id}"
+func Param(r *http.Request, name string) string {
+	return r.PathValue(name)
+}
+
+// statusRecorder keeps the status and headers of a response and drops its
+// body
+type statusRecorder struct {
+	header http.Header
+	code   int
+}
+
+func (s *statusRecorder) Header() http.Header {
+	return s.header
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+}
+{{- end }}
+{{- if ne .Router "gin" }}
+
+// JSON answers with v encoded as JSON
+func JSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to encode response")
+		problem.Write(w, r, problem.Internal())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+// Decode reads the JSON body of r into v
+func Decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+{{- end }}
+
+// Recover answers a panic in next with a 500 problem
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				logPanic(r, v)
+				problem.Write(w, r, problem.Internal())
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logPanic(r *http.Request, v interface{}) {
+	log.Ctx(r.Context()).Error().
+		Interface("panic", v).
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Msg("Recovered from panic in route")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	problem.Write(w, r, problem.New(problem.TypeNotFound, "Route not found"))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	problem.Write(w, r, problem.New(problem.TypeMethodNotAllowed, fmt.Sprintf("Method %s is not allowed on %s", r.Method, r.URL.Path)))
+}
+`
+
+const RoutingTest = `package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+{{- if and (eq .TestingFramework "standard") (ne .Router "gin") }}
+	"strings"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+{{- if or (eq .Router "gin") (ne .TestingFramework "standard") }}
+{{ end }}
+{{- if eq .Router "gin" }}
+	"github.com/gin-gonic/gin"
+{{- end }}
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+var routingTestCases = []struct {
+	name   string
+	method string
+	path   string
+	status int
+	code   string // of the problem answered, empty for the route's answer
+}{
+	{name: "route", method: http.MethodGet, path: "/users/user-1", status: http.StatusOK},
+	{name: "unknown route", method: http.MethodGet, path: "/orders", status: http.StatusNotFound, code: "NOT_FOUND"},
+	{name: "method not allowed", method: http.MethodDelete, path: "/users/user-1", status: http.StatusMethodNotAllowed, code: "METHOD_NOT_ALLOWED"},
+	{name: "panic", method: http.MethodGet, path: "/panic", status: http.StatusInternalServerError, code: "INTERNAL"},
+}
+
+// tag is a middleware marking every response it saw
+func tag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Routed", "true")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRouter returns a router with a /users/{id} route answering the id,
+// and a route that panics
+func newRouter() http.Handler {
+	router := New(tag)
+{{- if eq .Router "gin" }}
+	router.GET("/users/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, map[string]string{"id": c.Param("id")})
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+{{- else if eq .Router "chi" }}
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, r, http.StatusOK, map[string]string{"id": Param(r, "id")})
+	})
+	router.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+{{- else }}
+	router.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, r, http.StatusOK, map[string]string{"id": Param(r, "id")})
+	})
+	router.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+{{- end }}
+	return router
+}
+
+// serve sends a request through the router and decodes the JSON answer
+func serve(method, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+	var doc map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &doc)
+	return w, doc
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Router", func() {
+	for _, tc := range routingTestCases {
+		tc := tc
+
+		It("answers a "+tc.name, func() {
+			w, doc := serve(tc.method, tc.path)
+
+			Expect(w.Code).To(Equal(tc.status))
+			Expect(w.Header().Get("X-Routed")).To(Equal("true"))
+			if tc.code == "" {
+				Expect(doc).To(HaveKeyWithValue("id", "user-1"))
+			} else {
+				Expect(doc).To(HaveKeyWithValue("code", tc.code))
+			}
+		})
+	}
+{{- if ne .Router "gin" }}
+
+	It("lists the allowed methods of a route", func() {
+		w, _ := serve(http.MethodDelete, "/users/user-1")
+
+		Expect(w.Header().Get("Allow")).To(ContainSubstring(http.MethodGet))
+	})
+{{- end }}
+})
+{{- else }}
+
+func TestRouter(t *testing.T) {
+	for _, tc := range routingTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, doc := serve(tc.method, tc.path)
+{{- if eq .TestingFramework "standard" }}
+			if w.Code != tc.status {
+				t.Errorf("Code = %d, want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("X-Routed"); got != "true" {
+				t.Errorf("X-Routed = %q, want the middleware to run", got)
+			}
+			if tc.code == "" && doc["id"] != "user-1" {
+				t.Errorf("body = %v, want id user-1", doc)
+			}
+			if tc.code != "" && doc["code"] != tc.code {
+				t.Errorf("problem = %v, want code %s", doc, tc.code)
+			}
+{{- else }}
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, "true", w.Header().Get("X-Routed"))
+			if tc.code == "" {
+				assert.Equal(t, "user-1", doc["id"])
+			} else {
+				assert.Equal(t, tc.code, doc["code"])
+			}
+{{- end }}
+		})
+	}
+}
+{{- if ne .Router "gin" }}
+
+func TestRouterAllow(t *testing.T) {
+	w, _ := serve(http.MethodDelete, "/users/user-1")
+{{- if eq .TestingFramework "standard" }}
+	if got := w.Header().Get("Allow"); !strings.Contains(got, http.MethodGet) {
+		t.Errorf("Allow = %q, want it to list GET", got)
+	}
+{{- else }}
+	assert.Contains(t, w.Header().Get("Allow"), http.MethodGet)
+{{- end }}
+}
+{{- end }}
+{{- end }}
+`
@@ -9,22 +9,29 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"{{.Module}}/config"
 	"{{.Module}}/models"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+{{- if and (.HasFeature "api") (not .UsesRouter) }}
+	"{{.Module}}/pkg/openapi"
+{{- end }}
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/tracing"
 	"{{.Module}}/services"
 	"{{.Module}}/utils"
 	"github.com/rs/zerolog/log"
 )
 
 // Handler handles the main Lambda function
-func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func Handler(ctx context.Context, request apievent.Request) (apievent.Response, error) {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to load configuration")
-		return utils.ErrorResponse(http.StatusInternalServerError, "Internal server error"), nil
+		return problem.Response(ctx, request, problem.Internal()), nil
 	}
 
 	// Initialize service
@@ -32,66 +39,85 @@ func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 
 	// Route based on path and method
 	switch {
-	case request.Path == "/users" && request.HTTPMethod == http.MethodPost:
+	case apievent.Matches(request, http.MethodPost, "/users"):
 		return createUser(ctx, svc, request)
-	case request.Path == "/users" && request.HTTPMethod == http.MethodGet:
+	case apievent.Matches(request, http.MethodGet, "/users"):
 		return listUsers(ctx, svc, request)
-	case request.Path == "/users/{id}" && request.HTTPMethod == http.MethodGet:
+	case apievent.Matches(request, http.MethodGet, "/users/{id}"):
 		return getUser(ctx, svc, request)
 	default:
-		return utils.ErrorResponse(http.StatusNotFound, "Route not found"), nil
+		return problem.Response(ctx, request, problem.New(problem.TypeNotFound, "Route not found")), nil
 	}
 }
 
-func createUser(ctx context.Context, svc *services.Service, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func createUser(ctx context.Context, svc *services.Service, request apievent.Request) (apievent.Response, error) {
 	var input models.CreateUserInput
 	if err := json.Unmarshal([]byte(request.Body), &input); err != nil {
-		return utils.ErrorResponse(http.StatusBadRequest, "Invalid request body"), nil
+		return problem.Response(ctx, request, problem.New(problem.TypeValidation, "Invalid request body")), nil
 	}
 
 	// Validate input
 	if err := input.Validate(); err != nil {
-		return utils.ValidationErrorResponse(err), nil
+		return problem.Response(ctx, request, problem.New(problem.TypeValidation, err.Error())), nil
 	}
 
 	// Create user
 	user, err := svc.CreateUser(ctx, input)
 	if err != nil {
 		log.Ctx(ctx).Error().Err(err).Msg("Failed to create user")
-		return utils.HandleServiceError(err), nil
+		return problem.Response(ctx, request, utils.ServiceProblem(err)), nil
 	}
 
 	return utils.SuccessResponse(http.StatusCreated, user), nil
 }
 
-func getUser(ctx context.Context, svc *services.Service, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userID := request.PathParameters["id"]
+func getUser(ctx context.Context, svc *services.Service, request apievent.Request) (apievent.Response, error) {
+	userID := apievent.PathParameter(request, "/users/{id}", "id")
 	if userID == "" {
-		return utils.ErrorResponse(http.StatusBadRequest, "User ID is required"), nil
+		return problem.Response(ctx, request, problem.New(problem.TypeValidation, "User ID is required")), nil
 	}
 
 	user, err := svc.GetUser(ctx, userID)
 	if err != nil {
-		return utils.HandleServiceError(err), nil
+		return problem.Response(ctx, request, utils.ServiceProblem(err)), nil
 	}
 
 	return utils.SuccessResponse(http.StatusOK, user), nil
 }
 
-func listUsers(ctx context.Context, svc *services.Service, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func listUsers(ctx context.Context, svc *services.Service, request apievent.Request) (apievent.Response, error) {
 	// Parse query parameters
 	params := utils.ParseListParams(request.QueryStringParameters)
 
 	result, err := svc.ListUsers(ctx, params)
 	if err != nil {
-		return utils.HandleServiceError(err), nil
+		return problem.Response(ctx, request, utils.ServiceProblem(err)), nil
 	}
 
 	return utils.SuccessResponse(http.StatusOK, result), nil
 }
 
-func main() {
-	lambda.Start(Handler)
+// Start serves Handler on Lambda
+func Start() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+{{- if and (.HasFeature "api") (not .UsesRouter) }}
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(Handler)))))
+{{- else }}
+
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(Handler))))
+{{- end }}
 }
 `
 
@@ -111,6 +137,7 @@ type User struct {
 	CreatedAt time.Time ` + "`json:\"created_at\" dynamodbav:\"created_at\"`" + `
 	UpdatedAt time.Time ` + "`json:\"updated_at\" dynamodbav:\"updated_at\"`" + `
 	DeletedAt *time.Time ` + "`json:\"deleted_at,omitempty\" dynamodbav:\"deleted_at,omitempty\"`" + `
+	Version   int       ` + "`json:\"version\" dynamodbav:\"version\"`" + `
 }
 
 // CreateUserInput represents the input for creating a user
@@ -137,19 +164,19 @@ type UpdateUserInput struct {
 	Status *string ` + "`json:\"status,omitempty\"`" + `
 }
 
-// ListUsersResult represents the result of listing users
+// ListUsersResult represents one page of users. NextCursor is empty on
+// the last page.
 type ListUsersResult struct {
 	Users      []*User ` + "`json:\"users\"`" + `
-	TotalCount int64   ` + "`json:\"total_count\"`" + `
-	Page       int     ` + "`json:\"page\"`" + `
-	PageSize   int     ` + "`json:\"page_size\"`" + `
+	NextCursor string  ` + "`json:\"next_cursor,omitempty\"`" + `
+	Limit      int     ` + "`json:\"limit\"`" + `
 }
 
-// ListParams represents pagination parameters
+// ListParams represents cursor pagination parameters
 type ListParams struct {
-	Page     int
-	PageSize int
-	Status   string
+	Cursor string
+	Limit  int
+	Status string
 }
 
 // ServiceError represents a service-level error
@@ -167,6 +194,8 @@ var (
 	ErrUserNotFound      = &ServiceError{Code: "USER_NOT_FOUND", Message: "user not found"}
 	ErrUserAlreadyExists = &ServiceError{Code: "USER_EXISTS", Message: "user already exists"}
 	ErrInvalidInput      = &ServiceError{Code: "INVALID_INPUT", Message: "invalid input"}
+	ErrInvalidCursor     = &ServiceError{Code: "INVALID_INPUT", Message: "invalid pagination cursor"}
+	ErrVersionConflict   = &ServiceError{Code: "VERSION_CONFLICT", Message: "user was modified by another request, reload and retry"}
 )
 `
 
@@ -174,10 +203,13 @@ const SimpleService = `package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
@@ -186,20 +218,44 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// DynamoDBAPI is the subset of the DynamoDB client used by the services.
+// Tests can pass the in-memory fake from test/fakes instead.
+type DynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
 // Service handles business logic
 type Service struct {
 	config   *config.Config
-	dynamoDB *dynamodb.Client
+	dynamoDB DynamoDBAPI
+	table    *Table
 }
 
 // NewService creates a new service instance
 func NewService(cfg *config.Config) *Service {
 	// Initialize AWS clients
 	awsConfig, _ := config.LoadAWSConfig(context.Background())
+	client := dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
+		// Custom endpoint for local development
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	})
 	
+	return NewServiceWithClient(cfg, client)
+}
+
+// NewServiceWithClient creates a service backed by the given DynamoDB client
+func NewServiceWithClient(cfg *config.Config, client DynamoDBAPI) *Service {
 	return &Service{
 		config:   cfg,
-		dynamoDB: dynamodb.NewFromConfig(awsConfig),
+		dynamoDB: client,
+		table:    NewTable(client, cfg.DynamoDBTableName).WithCursorSecret(cfg.CursorSecret),
 	}
 }
 
@@ -222,16 +278,10 @@ func (s *Service) CreateUser(ctx context.Context, input models.CreateUserInput)
 	}
 
 	// Save to DynamoDB
-	item, err := attributevalue.MarshalMap(user)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal user: %w", err)
-	}
-
-	_, err = s.dynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.config.DynamoDBTableName),
-		Item:      item,
-	})
-	if err != nil {
+	if err := s.putUser(ctx, user); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			return nil, models.ErrUserAlreadyExists
+		}
 		return nil, fmt.Errorf("failed to save user: %w", err)
 	}
 
@@ -245,69 +295,46 @@ func (s *Service) CreateUser(ctx context.Context, input models.CreateUserInput)
 
 // GetUser retrieves a user by ID
 func (s *Service) GetUser(ctx context.Context, userID string) (*models.User, error) {
-	result, err := s.dynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(s.config.DynamoDBTableName),
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: userID},
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
-	}
-
-	if result.Item == nil {
-		return nil, models.ErrUserNotFound
-	}
-
 	var user models.User
-	if err := attributevalue.UnmarshalMap(result.Item, &user); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	if err := s.table.GetUserByID(ctx, userID, &user); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, models.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	return &user, nil
 }
 
-// ListUsers lists users with pagination
+// ListUsers lists a page of users in creation order. The status filter
+// is applied after the page is read, so a page may hold fewer than
+// params.Limit users even when more follow.
 func (s *Service) ListUsers(ctx context.Context, params models.ListParams) (*models.ListUsersResult, error) {
-	// Build scan input
-	scanInput := &dynamodb.ScanInput{
-		TableName: aws.String(s.config.DynamoDBTableName),
-		Limit:     aws.Int32(int32(params.PageSize)),
-	}
+	var opts []QueryOption
 
 	// Add filter for status if provided
 	if params.Status != "" {
-		scanInput.FilterExpression = aws.String("#status = :status")
-		scanInput.ExpressionAttributeNames = map[string]string{
-			"#status": "status",
-		}
-		scanInput.ExpressionAttributeValues = map[string]types.AttributeValue{
-			":status": &types.AttributeValueMemberS{Value: params.Status},
-		}
+		opts = append(opts, WithFilter("#status = :status",
+			map[string]string{"#status": "status"},
+			map[string]types.AttributeValue{
+				":status": &types.AttributeValueMemberS{Value: params.Status},
+			},
+		))
 	}
 
-	// Execute scan
-	result, err := s.dynamoDB.Scan(ctx, scanInput)
+	users := []*models.User{}
+	next, err := s.table.ListUsers(ctx, params.Limit, params.Cursor, &users, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list users: %w", err)
-	}
-
-	// Unmarshal users
-	var users []*models.User
-	for _, item := range result.Items {
-		var user models.User
-		if err := attributevalue.UnmarshalMap(item, &user); err != nil {
-			log.Ctx(ctx).Error().Err(err).Msg("Failed to unmarshal user")
-			continue
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, models.ErrInvalidCursor
 		}
-		users = append(users, &user)
+		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
 
 	return &models.ListUsersResult{
 		Users:      users,
-		TotalCount: int64(result.Count),
-		Page:       params.Page,
-		PageSize:   params.PageSize,
+		NextCursor: next,
+		Limit:      params.Limit,
 	}, nil
 }
 
@@ -329,16 +356,10 @@ func (s *Service) UpdateUser(ctx context.Context, userID string, input models.Up
 	user.UpdatedAt = time.Now().UTC()
 
 	// Save to DynamoDB
-	item, err := attributevalue.MarshalMap(user)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal user: %w", err)
-	}
-
-	_, err = s.dynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.config.DynamoDBTableName),
-		Item:      item,
-	})
-	if err != nil {
+	if err := s.putUser(ctx, user); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
@@ -360,47 +381,87 @@ func (s *Service) DeleteUser(ctx context.Context, userID string) error {
 	user.UpdatedAt = now
 
 	// Save to DynamoDB
-	item, err := attributevalue.MarshalMap(user)
+	if err := s.putUser(ctx, user); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			return err
+		}
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
+
+// getUserByEmail retrieves a user by email, or nil if there is none
+func (s *Service) getUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := s.table.GetUserByEmail(ctx, email, &user); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query user by email: %w", err)
+	}
+
+	return &user, nil
+}
+
+// putUser writes a user together with its table and index keys. The write
+// only lands while the stored user still has user.Version (0 for a new
+// user) and then bumps it; otherwise it returns models.ErrVersionConflict.
+func (s *Service) putUser(ctx context.Context, user *models.User) error {
+	next := *user
+	next.Version++
+
+	item, err := attributevalue.MarshalMap(&next)
 	if err != nil {
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
 
+	keys := UserKeys{
+{{- with .DataModel.Entity "User" }}
+{{- $entity := . }}
+{{- range .Fields }}
+		{{ $entity.FieldKey . }} {{ .UserExpr "user" }},
+{{- end }}
+{{- end }}
+	}
+	for name, value := range keys.Attributes() {
+		item[name] = value
+	}
+
+	condition, values := versionCondition(user.Version)
 	_, err = s.dynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.config.DynamoDBTableName),
-		Item:      item,
+		TableName:                 aws.String(s.table.Name()),
+		Item:                      item,
+		ConditionExpression:       condition,
+		ExpressionAttributeValues: values,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		if isConditionFailed(err) {
+			return models.ErrVersionConflict
+		}
+		return err
 	}
 
+	user.Version = next.Version
 	return nil
 }
 
-// getUserByEmail retrieves a user by email
-func (s *Service) getUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	result, err := s.dynamoDB.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(s.config.DynamoDBTableName),
-		IndexName:              aws.String("email-index"),
-		KeyConditionExpression: aws.String("email = :email"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":email": &types.AttributeValueMemberS{Value: email},
-		},
-		Limit: aws.Int32(1),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to query user by email: %w", err)
+// versionCondition returns the condition for a write that expects the
+// stored item to be at version expected. Version 0 means the item must
+// not exist yet.
+func versionCondition(expected int) (*string, map[string]types.AttributeValue) {
+	if expected == 0 {
+		return aws.String("attribute_not_exists(version)"), nil
 	}
-
-	if len(result.Items) == 0 {
-		return nil, nil
-	}
-
-	var user models.User
-	if err := attributevalue.UnmarshalMap(result.Items[0], &user); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	return aws.String("version = :expected_version"), map[string]types.AttributeValue{
+		":expected_version": &types.AttributeValueMemberN{Value: strconv.Itoa(expected)},
 	}
+}
 
-	return &user, nil
+// isConditionFailed reports whether DynamoDB rejected a conditional write
+func isConditionFailed(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionFailed)
 }
 `
 
@@ -408,87 +469,54 @@ const SimpleUtils = `package utils
 
 import (
 	"encoding/json"
-	"net/http"
+	"os"
 	"strconv"
 
-	"github.com/aws/aws-lambda-go/events"
 	"{{.Module}}/models"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/problem"
 )
 
 // SuccessResponse creates a successful API response
-func SuccessResponse(statusCode int, data interface{}) events.APIGatewayProxyResponse {
+func SuccessResponse(statusCode int, data interface{}) apievent.Response {
 	body, _ := json.Marshal(map[string]interface{}{
 		"success": true,
 		"data":    data,
 	})
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body: string(body),
-	}
+	return apievent.NewResponse(statusCode, map[string]string{
+		"Content-Type": "application/json",
+	}, string(body))
 }
 
-// ErrorResponse creates an error API response
-func ErrorResponse(statusCode int, message string) events.APIGatewayProxyResponse {
-	body, _ := json.Marshal(map[string]interface{}{
-		"success": false,
-		"error": map[string]interface{}{
-			"message": message,
-		},
-	})
-
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-		Body: string(body),
-	}
-}
-
-// ValidationErrorResponse creates a validation error response
-func ValidationErrorResponse(err error) events.APIGatewayProxyResponse {
-	return ErrorResponse(http.StatusBadRequest, err.Error())
-}
-
-// HandleServiceError converts service errors to HTTP responses
-func HandleServiceError(err error) events.APIGatewayProxyResponse {
+// ServiceProblem maps a service error to the problem it is answered with
+func ServiceProblem(err error) *problem.Problem {
 	if svcErr, ok := err.(*models.ServiceError); ok {
 		switch svcErr.Code {
 		case "USER_NOT_FOUND":
-			return ErrorResponse(http.StatusNotFound, svcErr.Message)
-		case "USER_EXISTS":
-			return ErrorResponse(http.StatusConflict, svcErr.Message)
+			return problem.New(problem.TypeNotFound, svcErr.Message)
+		case "USER_EXISTS", "VERSION_CONFLICT":
+			return problem.New(problem.TypeConflict, svcErr.Message)
 		case "INVALID_INPUT":
-			return ErrorResponse(http.StatusBadRequest, svcErr.Message)
-		default:
-			return ErrorResponse(http.StatusInternalServerError, "Internal server error")
+			return problem.New(problem.TypeValidation, svcErr.Message)
 		}
 	}
 
-	return ErrorResponse(http.StatusInternalServerError, "Internal server error")
+	return problem.FromError(err)
 }
 
-// ParseListParams parses pagination parameters from query string
+// ParseListParams parses cursor pagination parameters from query string
 func ParseListParams(params map[string]string) models.ListParams {
-	page := 1
-	pageSize := 20
-
-	if p, err := strconv.Atoi(params["page"]); err == nil && p > 0 {
-		page = p
-	}
+	limit := 20
 
-	if ps, err := strconv.Atoi(params["page_size"]); err == nil && ps > 0 && ps <= 100 {
-		pageSize = ps
+	if l, err := strconv.Atoi(params["limit"]); err == nil && l > 0 && l <= 100 {
+		limit = l
 	}
 
 	return models.ListParams{
-		Page:     page,
-		PageSize: pageSize,
-		Status:   params["status"],
+		Cursor: params["cursor"],
+		Limit:  limit,
+		Status: params["status"],
 	}
 }
 
@@ -517,57 +545,106 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/caarlos0/env/v10"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+{{- if .HasFeature "api" }}
+	"{{.Module}}/pkg/openapi"
+{{- end }}
+	"{{.Module}}/pkg/resilience"
+	"{{.Module}}/pkg/tracing"
 )
 
-// Config holds all configuration for the application
+// Config holds all configuration for the application. Fields tagged ssm or
+// appconfig can also come from SSM Parameter Store or AppConfig (see
+// pkg/configsource).
 type Config struct {
 	// Application
 	AppName     string ` + "`env:\"APP_NAME\" envDefault:\"{{.Name}}\"`" + `
 	Environment string ` + "`env:\"APP_ENV\" envDefault:\"development\"`" + `
 	LogLevel    string ` + "`env:\"LOG_LEVEL\" envDefault:\"info\"`" + `
+
+	// Feature flags, switched at runtime in AppConfig
+	DebugLogging bool ` + "`env:\"DEBUG_LOGGING\" appconfig:\"debugLogging.enabled\"`" + `
 	
 	// AWS
 	AWSRegion string ` + "`env:\"AWS_REGION\" envDefault:\"us-east-1\"`" + `
 	
-	{{- if .HasFeature "dynamodb" }}
+	// Tracing
+	TracingExporter string ` + "`env:\"TRACING_EXPORTER\" envDefault:\"xray\"`" + `
+	TracingEndpoint string ` + "`env:\"TRACING_ENDPOINT\" envDefault:\"localhost:4318\"`" + `
+	
+	// Metrics
+	MetricsNamespace string ` + "`env:\"METRICS_NAMESPACE\" envDefault:\"{{.Name}}\"`" + `
+	
 	// DynamoDB
-	DynamoDBTableName string ` + "`env:\"DYNAMODB_TABLE_NAME\"`" + `
+	DynamoDBTableName string ` + "`env:\"DYNAMODB_TABLE_NAME\" envDefault:\"{{.Name}}-{{.DataModel.Table.Name}}\"`" + `
 	DynamoDBEndpoint  string ` + "`env:\"DYNAMODB_ENDPOINT\"`" + `
-	{{- end }}
+	CursorSecret      string ` + "`env:\"CURSOR_SECRET\" ssm:\"cursor-secret\"`" + `
 	
 	{{- if .HasFeature "sqs" }}
 	// SQS
-	SQSQueueURL string ` + "`env:\"SQS_QUEUE_URL\"`" + `
+	SQSQueueURL   string ` + "`env:\"SQS_QUEUE_URL\"`" + `
+	SQSDLQueueURL string ` + "`env:\"SQS_DLQ_URL\"`" + `
+	SQSEndpoint   string ` + "`env:\"SQS_ENDPOINT\"`" + `
 	{{- end }}
 	
 	{{- if .HasFeature "s3" }}
 	// S3
 	S3BucketName string ` + "`env:\"S3_BUCKET_NAME\"`" + `
+	S3Endpoint   string ` + "`env:\"S3_ENDPOINT\"`" + `
+	{{- end }}
+	
+	{{- if .HasFeature "api" }}
+	// API
+	ValidateResponses bool ` + "`env:\"OPENAPI_VALIDATE_RESPONSES\" envDefault:\"true\"`" + `
 	{{- end }}
 }
 
-// Load loads configuration from environment variables
+// current is the configuration as last refreshed
+var current atomic.Pointer[Config]
+
+// Current returns the configuration as last refreshed by configsource.Wrap.
+// The Config returned by Load keeps the values it was loaded with.
+func Current() *Config {
+	return current.Load()
+}
+
+// Load loads configuration from environment variables and the sources of
+// pkg/configsource, which Wrap refreshes in warm containers
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load(".env.local")
 	_ = godotenv.Load(".env")
 	
+	ctx := context.Background()
+	loader, err := configsource.FromEnvironment(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up configuration sources: %w", err)
+	}
+
 	cfg := &Config{}
-	if err := env.Parse(cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse environment variables: %w", err)
+	if err := loader.Load(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
 	
 	// Configure logging
 	if err := configureLogging(cfg.LogLevel); err != nil {
 		return nil, fmt.Errorf("failed to configure logging: %w", err)
 	}
+	cfg.applyLogLevel()
+	current.Store(cfg)
+	configsource.Watch(loader, &current, func(ctx context.Context, cfg *Config) {
+		cfg.applyLogLevel()
+	})
 	
 	log.Info().
 		Str("app_name", cfg.AppName).
@@ -577,7 +654,35 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
-// LoadAWSConfig loads AWS SDK configuration
+// TracingOptions configures tracing.Init
+func (c *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		ServiceName: c.AppName,
+		Exporter:    c.TracingExporter,
+		Endpoint:    c.TracingEndpoint,
+	}
+}
+
+// MetricsOptions configures metrics.Init
+func (c *Config) MetricsOptions() metrics.Options {
+	return metrics.Options{
+		Namespace: c.MetricsNamespace,
+		Service:   c.AppName,
+	}
+}
+{{- if .HasFeature "api" }}
+
+// OpenAPIOptions configures openapi.Load. Responses are never validated in
+// production.
+func (c *Config) OpenAPIOptions() openapi.Options {
+	return openapi.Options{
+		ValidateResponses: c.ValidateResponses && c.Environment != "production" && c.Environment != "prod",
+	}
+}
+{{- end }}
+
+// LoadAWSConfig loads AWS SDK configuration. Clients created from it are
+// traced and retry with jittered backoff.
 func LoadAWSConfig(ctx context.Context) (aws.Config, error) {
 	opts := []func(*config.LoadOptions) error{
 		config.WithRegion(os.Getenv("AWS_REGION")),
@@ -594,7 +699,14 @@ func LoadAWSConfig(ctx context.Context) (aws.Config, error) {
 		))
 	}
 
-	return config.LoadDefaultConfig(ctx, opts...)
+	awsConfig, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	tracing.InstrumentAWS(&awsConfig)
+	resilience.InstrumentAWS(&awsConfig, resilience.DefaultBackoff)
+
+	return awsConfig, nil
 }
 
 // configureLogging configures the global logger
@@ -620,6 +732,16 @@ func configureLogging(level string) error {
 	
 	return nil
 }
+
+// applyLogLevel logs at debug level while the debugLogging flag is on, and
+// at LOG_LEVEL otherwise
+func (c *Config) applyLogLevel() {
+	if c.DebugLogging {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if level, err := zerolog.ParseLevel(c.LogLevel); err == nil {
+		zerolog.SetGlobalLevel(level)
+	}
+}
 `
 
 // Simple architecture feature templates
@@ -627,39 +749,44 @@ const SimpleAPIHandler = `package handlers
 
 import (
 	"context"
+	"strconv"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/gin-gonic/gin"
 	"{{.Module}}/config"
+	"{{.Module}}/models"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/openapi"
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/routing"
+	"{{.Module}}/pkg/tracing"
 	"{{.Module}}/services"
+	"{{.Module}}/utils"
 	"github.com/rs/zerolog/log"
 )
 
-// APIHandler handles API Gateway requests using Gin
-func APIHandler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+// APIHandler handles API requests using Gin
+func APIHandler(ctx context.Context, request apievent.Request) (apievent.Response, error) {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to load configuration")
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       "Internal server error",
-		}, nil
+		return problem.Response(ctx, request, problem.Internal()), nil
 	}
 
 	// Initialize service
 	svc := services.NewService(cfg)
 
 	// Create Gin router
-	router := gin.New()
-	router.Use(gin.Recovery())
+	router := routing.New()
 
 	// Setup routes
 	setupRoutes(router, svc)
 
-	// Convert API Gateway request to Gin
-	return ginLambda.ProxyWithContext(ctx, request, router)
+	// Serve the event through Gin
+	return apievent.Serve(ctx, router, request)
 }
 
 func setupRoutes(router *gin.Engine, svc *services.Service) {
@@ -685,7 +812,7 @@ func createUserHandler(svc *services.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var input models.CreateUserInput
 		if err := c.ShouldBindJSON(&input); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
 			return
 		}
 
@@ -733,7 +860,7 @@ func updateUserHandler(svc *services.Service) gin.HandlerFunc {
 		
 		var input models.UpdateUserInput
 		if err := c.ShouldBindJSON(&input); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			writeProblem(c, problem.New(problem.TypeValidation, err.Error()))
 			return
 		}
 
@@ -761,49 +888,243 @@ func deleteUserHandler(svc *services.Service) gin.HandlerFunc {
 }
 
 func handleError(c *gin.Context, err error) {
-	if svcErr, ok := err.(*models.ServiceError); ok {
-		switch svcErr.Code {
-		case "USER_NOT_FOUND":
-			c.JSON(404, gin.H{"error": svcErr.Message})
-		case "USER_EXISTS":
-			c.JSON(409, gin.H{"error": svcErr.Message})
-		case "INVALID_INPUT":
-			c.JSON(400, gin.H{"error": svcErr.Message})
-		default:
-			c.JSON(500, gin.H{"error": "Internal server error"})
-		}
-		return
+	p := utils.ServiceProblem(err)
+	if p.Type == problem.TypeInternal {
+		log.Error().Err(err).Msg("Unhandled error")
 	}
+	writeProblem(c, p)
+}
 
-	log.Error().Err(err).Msg("Unhandled error")
-	c.JSON(500, gin.H{"error": "Internal server error"})
+// writeProblem answers the request with p and stops the handler chain
+func writeProblem(c *gin.Context, p *problem.Problem) {
+	problem.Write(c.Writer, c.Request, p)
+	c.Abort()
 }
 
 func parseListParams(c *gin.Context) models.ListParams {
-	page := 1
-	pageSize := 20
+	limit := 20
+
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	return models.ListParams{
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+		Status: c.Query("status"),
+	}
+}
+
+// StartAPI serves APIHandler on Lambda
+func StartAPI() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(APIHandler)))))
+}
+`
+
+const SimpleAPIHandlerHTTP = `package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"{{.Module}}/config"
+	"{{.Module}}/models"
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/openapi"
+	"{{.Module}}/pkg/problem"
+	"{{.Module}}/pkg/routing"
+	"{{.Module}}/pkg/tracing"
+	"{{.Module}}/services"
+	"{{.Module}}/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// APIHandler handles API requests through the {{.Router}} router
+func APIHandler(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load configuration")
+		return problem.Response(ctx, request, problem.Internal()), nil
+	}
+
+	// Initialize service
+	svc := services.NewService(cfg)
+
+	// Serve the event through the router
+	return apievent.Serve(ctx, newRouter(svc), request)
+}
 
-	if p := c.Query("page"); p != "" {
-		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
-			page = parsed
+func newRouter(svc *services.Service) http.Handler {
+	router := routing.New()
+{{- if eq .Router "chi" }}
+
+	// Health check
+	router.Get("/health", healthHandler)
+
+	// User routes
+	router.Post("/users", createUserHandler(svc))
+	router.Get("/users", listUsersHandler(svc))
+	router.Get("/users/{id}", getUserHandler(svc))
+	router.Put("/users/{id}", updateUserHandler(svc))
+	router.Delete("/users/{id}", deleteUserHandler(svc))
+{{- else }}
+
+	// Health check
+	router.HandleFunc("GET /health", healthHandler)
+
+	// User routes
+	router.HandleFunc("POST /users", createUserHandler(svc))
+	router.HandleFunc("GET /users", listUsersHandler(svc))
+	router.HandleFunc("GET /users/{id}", getUserHandler(svc))
+	router.HandleFunc("PUT /users/{id}", updateUserHandler(svc))
+	router.HandleFunc("DELETE /users/{id}", deleteUserHandler(svc))
+{{- end }}
+
+	return router
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	routing.JSON(w, r, http.StatusOK, map[string]string{
+		"status": "healthy",
+	})
+}
+
+func createUserHandler(svc *services.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input models.CreateUserInput
+		if err := routing.Decode(r, &input); err != nil {
+			problem.Write(w, r, problem.New(problem.TypeValidation, err.Error()))
+			return
+		}
+		if err := input.Validate(); err != nil {
+			problem.Write(w, r, problem.New(problem.TypeValidation, err.Error()))
+			return
 		}
+
+		user, err := svc.CreateUser(r.Context(), input)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		routing.JSON(w, r, http.StatusCreated, user)
+	}
+}
+
+func getUserHandler(svc *services.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := svc.GetUser(r.Context(), routing.Param(r, "id"))
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		routing.JSON(w, r, http.StatusOK, user)
 	}
+}
 
-	if ps := c.Query("page_size"); ps != "" {
-		if parsed, err := strconv.Atoi(ps); err == nil && parsed > 0 && parsed <= 100 {
-			pageSize = parsed
+func listUsersHandler(svc *services.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := svc.ListUsers(r.Context(), parseListParams(r))
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		routing.JSON(w, r, http.StatusOK, result)
+	}
+}
+
+func updateUserHandler(svc *services.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input models.UpdateUserInput
+		if err := routing.Decode(r, &input); err != nil {
+			problem.Write(w, r, problem.New(problem.TypeValidation, err.Error()))
+			return
+		}
+
+		user, err := svc.UpdateUser(r.Context(), routing.Param(r, "id"), input)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		routing.JSON(w, r, http.StatusOK, user)
+	}
+}
+
+func deleteUserHandler(svc *services.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := svc.DeleteUser(r.Context(), routing.Param(r, "id")); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	p := utils.ServiceProblem(err)
+	if p.Type == problem.TypeInternal {
+		log.Error().Err(err).Msg("Unhandled error")
+	}
+	problem.Write(w, r, p)
+}
+
+func parseListParams(r *http.Request) models.ListParams {
+	query := r.URL.Query()
+	limit := 20
+
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
 		}
 	}
 
 	return models.ListParams{
-		Page:     page,
-		PageSize: pageSize,
-		Status:   c.Query("status"),
+		Cursor: query.Get("cursor"),
+		Limit:  limit,
+		Status: query.Get("status"),
 	}
 }
 
-func main() {
-	lambda.Start(APIHandler)
+// StartAPI serves APIHandler on Lambda
+func StartAPI() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+	validator, err := openapi.Load(cfg.OpenAPIOptions())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load OpenAPI spec")
+	}
+
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(validator.Wrap(APIHandler)))))
 }
 `
 
@@ -832,12 +1153,10 @@ type APIMeta struct {
 	Timestamp string ` + "`json:\"timestamp\"`" + `
 }
 
-// PaginationMeta represents pagination metadata
+// PaginationMeta represents cursor pagination metadata
 type PaginationMeta struct {
-	TotalCount int ` + "`json:\"total_count\"`" + `
-	Page       int ` + "`json:\"page\"`" + `
-	PageSize   int ` + "`json:\"page_size\"`" + `
-	TotalPages int ` + "`json:\"total_pages\"`" + `
+	NextCursor string ` + "`json:\"next_cursor,omitempty\"`" + `
+	Limit      int    ` + "`json:\"limit\"`" + `
 }
 `
 
@@ -863,18 +1182,11 @@ func BuildAPIResponse(success bool, data interface{}, err *models.APIError) mode
 	}
 }
 
-// BuildPaginationMeta builds pagination metadata
-func BuildPaginationMeta(totalCount int64, page, pageSize int) models.PaginationMeta {
-	totalPages := int(totalCount) / pageSize
-	if int(totalCount)%pageSize > 0 {
-		totalPages++
-	}
-
+// BuildPaginationMeta builds cursor pagination metadata
+func BuildPaginationMeta(nextCursor string, limit int) models.PaginationMeta {
 	return models.PaginationMeta{
-		TotalCount: int(totalCount),
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: totalPages,
+		NextCursor: nextCursor,
+		Limit:      limit,
 	}
 }
 `
@@ -889,12 +1201,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"{{.Module}}/config"
-	"github.com/rs/zerolog/log"
+	"{{.Module}}/models"
 )
 
 // DynamoDBService handles DynamoDB operations
 type DynamoDBService struct {
-	client    *dynamodb.Client
+	client    DynamoDBAPI
 	tableName string
 }
 
@@ -920,6 +1232,14 @@ func NewDynamoDBService(cfg *config.Config) (*DynamoDBService, error) {
 	}, nil
 }
 
+// NewDynamoDBServiceWithClient creates a DynamoDB service backed by the given client
+func NewDynamoDBServiceWithClient(cfg *config.Config, client DynamoDBAPI) *DynamoDBService {
+	return &DynamoDBService{
+		client:    client,
+		tableName: cfg.DynamoDBTableName,
+	}
+}
+
 // PutItem saves an item to DynamoDB
 func (s *DynamoDBService) PutItem(ctx context.Context, item interface{}) error {
 	av, err := attributevalue.MarshalMap(item)
@@ -938,6 +1258,33 @@ func (s *DynamoDBService) PutItem(ctx context.Context, item interface{}) error {
 	return nil
 }
 
+// PutVersionedItem saves an item with optimistic locking. The item must
+// carry its new "version" attribute; the write only lands while the stored
+// copy is still at expectedVersion (0 for an item that must not exist yet)
+// and returns models.ErrVersionConflict otherwise.
+func (s *DynamoDBService) PutVersionedItem(ctx context.Context, item interface{}, expectedVersion int) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item: %w", err)
+	}
+
+	condition, values := versionCondition(expectedVersion)
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 &s.tableName,
+		Item:                      av,
+		ConditionExpression:       condition,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return models.ErrVersionConflict
+		}
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+
+	return nil
+}
+
 // GetItem retrieves an item from DynamoDB
 func (s *DynamoDBService) GetItem(ctx context.Context, key map[string]types.AttributeValue, result interface{}) error {
 	resp, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
@@ -1084,35 +1431,47 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"{{.Module}}/config"
 	"{{.Module}}/models"
+	"{{.Module}}/pkg/configsource"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/tracing"
 	"{{.Module}}/services"
 	"github.com/rs/zerolog/log"
 )
 
-// SQSHandler processes messages from SQS queue
-func SQSHandler(ctx context.Context, sqsEvent events.SQSEvent) error {
+// SQSHandler processes messages from SQS queue. Messages that fail are
+// reported as batch item failures so the rest of the batch is not retried.
+func SQSHandler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to load configuration")
-		return err
+		return events.SQSEventResponse{}, err
 	}
 
 	// Initialize service
 	svc := services.NewSQSService(cfg)
 
-	// Process each message
+	return processBatch(ctx, svc, sqsEvent), nil
+}
+
+func processBatch(ctx context.Context, svc *services.SQSService, sqsEvent events.SQSEvent) events.SQSEventResponse {
+	var response events.SQSEventResponse
 	for _, message := range sqsEvent.Records {
-		if err := processMessage(ctx, svc, message); err != nil {
+		err := tracing.ProcessSQSMessage(ctx, message, func(ctx context.Context) error {
+			return processMessage(ctx, svc, message)
+		})
+		if err != nil {
 			log.Error().
 				Err(err).
 				Str("message_id", message.MessageId).
 				Msg("Failed to process message")
-			// Return error to retry the message
-			return err
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
 		}
 	}
 
-	return nil
+	return response
 }
 
 func processMessage(ctx context.Context, svc *services.SQSService, message events.SQSMessage) error {
@@ -1146,8 +1505,18 @@ func processMessage(ctx context.Context, svc *services.SQSService, message event
 	return nil
 }
 
-func main() {
-	lambda.Start(SQSHandler)
+// StartSQS serves SQSHandler on Lambda
+func StartSQS() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if _, err := tracing.Init(context.Background(), cfg.TracingOptions()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	metrics.Init(cfg.MetricsOptions())
+
+	lambda.Start(tracing.Wrap(metrics.Wrap(configsource.Wrap(SQSHandler))))
 }
 `
 
@@ -1158,28 +1527,47 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"{{.Module}}/config"
+	"{{.Module}}/pkg/tracing"
 	"github.com/rs/zerolog/log"
 )
 
+// SQSAPI is the subset of the SQS client used by SQSService
+type SQSAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
 // SQSService handles SQS operations
 type SQSService struct {
-	client   *sqs.Client
+	client   SQSAPI
 	queueURL string
 }
 
 // NewSQSService creates a new SQS service
 func NewSQSService(cfg *config.Config) *SQSService {
 	awsConfig, _ := config.LoadAWSConfig(context.Background())
+	client := sqs.NewFromConfig(awsConfig, func(o *sqs.Options) {
+		// Custom endpoint for local development
+		if cfg.SQSEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.SQSEndpoint)
+		}
+	})
 	
+	return NewSQSServiceWithClient(cfg, client)
+}
+
+// NewSQSServiceWithClient creates an SQS service backed by the given client
+func NewSQSServiceWithClient(cfg *config.Config, client SQSAPI) *SQSService {
 	return &SQSService{
-		client:   sqs.NewFromConfig(awsConfig),
+		client:   client,
 		queueURL: cfg.SQSQueueURL,
 	}
 }
 
-// SendMessage sends a message to the queue
+// SendMessage sends a message to the queue. The message carries the trace
+// context of ctx in its attributes.
 func (s *SQSService) SendMessage(ctx context.Context, messageType string, payload interface{}) error {
 	body, err := json.Marshal(map[string]interface{}{
 		"type":    messageType,
@@ -1190,8 +1578,9 @@ func (s *SQSService) SendMessage(ctx context.Context, messageType string, payloa
 	}
 
 	_, err = s.client.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:    &s.queueURL,
-		MessageBody: aws.String(string(body)),
+		QueueUrl:          &s.queueURL,
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: tracing.InjectSQS(ctx, nil),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
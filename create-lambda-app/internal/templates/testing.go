@@ -0,0 +1,3925 @@
+package templates
+
+// Test templates
+//
+// Every test template renders one of three variants depending on
+// .TestingFramework: testify (assert/require), standard (testing package
+// only) or ginkgo (Ginkgo v2 specs with Gomega matchers). Ginkgo packages
+// additionally get a suite_test.go rendered from GinkgoSuite.
+
+// GinkgoSuite bootstraps a Ginkgo suite for a single package. It is
+// formatted with the package name and a capitalized suite name before
+// being rendered as a template.
+const GinkgoSuite = `package %[1]s
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func Test%[2]s(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "%[2]s Suite")
+}
+`
+
+const TestUtilsTest = `package testutils
+
+import (
+{{- if eq .TestingFramework "ginkgo" }}
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- else }}
+	"net/http"
+	"testing"
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+{{- end }}
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("testutils", func() {
+	Describe("CreateAPIRequest", func() {
+		It("encodes the body as JSON", func() {
+			request := CreateAPIRequest(http.MethodPost, "/users", map[string]string{"name": "Jane"})
+
+			Expect(apievent.Method(request)).To(Equal(http.MethodPost))
+			Expect(apievent.Path(request)).To(Equal("/users"))
+			Expect(request.Body).To(MatchJSON(` + "`" + `{"name":"Jane"}` + "`" + `))
+			Expect(request.Headers).To(HaveKeyWithValue("Content-Type", "application/json"))
+		})
+
+		It("leaves the body empty when none is given", func() {
+			request := CreateAPIRequest(http.MethodGet, "/users", nil)
+
+			Expect(request.Body).To(BeEmpty())
+		})
+	})
+
+	Describe("CreateSQSEvent", func() {
+		It("creates one record per message", func() {
+			event := CreateSQSEvent([]interface{}{"first", "second"})
+
+			Expect(event.Records).To(HaveLen(2))
+			Expect(event.Records[0].MessageId).To(Equal("test-message-0"))
+			Expect(event.Records[1].Body).To(Equal(` + "`" + `"second"` + "`" + `))
+		})
+	})
+
+	Describe("AssertAPIResponse", func() {
+		It("accepts a matching response", func() {
+			response := apievent.NewResponse(http.StatusOK, nil, ` + "`" + `{"success":true}` + "`" + `)
+
+			AssertAPIResponse(response, http.StatusOK, map[string]interface{}{"success": true})
+		})
+	})
+})
+{{- else if eq .TestingFramework "standard" }}
+
+func TestCreateAPIRequest(t *testing.T) {
+	request := CreateAPIRequest(http.MethodPost, "/users", map[string]string{"name": "Jane"})
+
+	if got := apievent.Method(request); got != http.MethodPost {
+		t.Errorf("Method = %q, want %q", got, http.MethodPost)
+	}
+	if got := apievent.Path(request); got != "/users" {
+		t.Errorf("Path = %q, want %q", got, "/users")
+	}
+	if want := ` + "`" + `{"name":"Jane"}` + "`" + `; request.Body != want {
+		t.Errorf("Body = %q, want %q", request.Body, want)
+	}
+	if got := request.Headers["Content-Type"]; got != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/json")
+	}
+}
+
+func TestCreateAPIRequestWithoutBody(t *testing.T) {
+	request := CreateAPIRequest(http.MethodGet, "/users", nil)
+
+	if request.Body != "" {
+		t.Errorf("Body = %q, want empty", request.Body)
+	}
+}
+
+func TestCreateSQSEvent(t *testing.T) {
+	event := CreateSQSEvent([]interface{}{"first", "second"})
+
+	if len(event.Records) != 2 {
+		t.Fatalf("len(Records) = %d, want 2", len(event.Records))
+	}
+	if got := event.Records[0].MessageId; got != "test-message-0" {
+		t.Errorf("MessageId = %q, want %q", got, "test-message-0")
+	}
+	if got := event.Records[1].Body; got != ` + "`" + `"second"` + "`" + ` {
+		t.Errorf("Body = %q, want %q", got, ` + "`" + `"second"` + "`" + `)
+	}
+}
+
+func TestAssertAPIResponse(t *testing.T) {
+	response := apievent.NewResponse(http.StatusOK, nil, ` + "`" + `{"success":true}` + "`" + `)
+
+	AssertAPIResponse(t, response, http.StatusOK, map[string]interface{}{"success": true})
+}
+{{- else }}
+
+func TestCreateAPIRequest(t *testing.T) {
+	request := CreateAPIRequest(http.MethodPost, "/users", map[string]string{"name": "Jane"})
+
+	assert.Equal(t, http.MethodPost, apievent.Method(request))
+	assert.Equal(t, "/users", apievent.Path(request))
+	assert.JSONEq(t, ` + "`" + `{"name":"Jane"}` + "`" + `, request.Body)
+	assert.Equal(t, "application/json", request.Headers["Content-Type"])
+}
+
+func TestCreateAPIRequestWithoutBody(t *testing.T) {
+	request := CreateAPIRequest(http.MethodGet, "/users", nil)
+
+	assert.Empty(t, request.Body)
+}
+
+func TestCreateSQSEvent(t *testing.T) {
+	event := CreateSQSEvent([]interface{}{"first", "second"})
+
+	assert.Len(t, event.Records, 2)
+	assert.Equal(t, "test-message-0", event.Records[0].MessageId)
+	assert.Equal(t, ` + "`" + `"second"` + "`" + `, event.Records[1].Body)
+}
+
+func TestAssertAPIResponse(t *testing.T) {
+	response := apievent.NewResponse(http.StatusOK, nil, ` + "`" + `{"success":true}` + "`" + `)
+
+	AssertAPIResponse(t, response, http.StatusOK, map[string]interface{}{"success": true})
+}
+{{- end }}
+`
+
+// Clean Architecture tests
+
+const CleanHandlerTest = `package lambda
+
+import (
+	"context"
+	"errors"
+	"net/http"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/internal/domain/entities"
+	"{{.Module}}/internal/domain/repositories"
+	"{{.Module}}/internal/usecases"
+	"{{.Module}}/pkg/apievent"
+)
+
+// stubUserUseCase returns canned results for handler tests
+type stubUserUseCase struct {
+	user *entities.User
+	err  error
+}
+
+func (s *stubUserUseCase) CreateUser(ctx context.Context, input usecases.CreateUserInput) (*usecases.CreateUserOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &usecases.CreateUserOutput{User: entities.NewUser(input.Email, input.Name)}, nil
+}
+
+func (s *stubUserUseCase) GetUser(ctx context.Context, userID string) (*entities.User, error) {
+	return s.user, s.err
+}
+
+func (s *stubUserUseCase) UpdateUser(ctx context.Context, userID string, input usecases.UpdateUserInput) (*entities.User, error) {
+	return s.user, s.err
+}
+
+func (s *stubUserUseCase) DeleteUser(ctx context.Context, userID string) error {
+	return s.err
+}
+
+func (s *stubUserUseCase) ListUsers(ctx context.Context, cursor string, limit int) (*usecases.ListUsersOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &usecases.ListUsersOutput{Users: []*entities.User{}, Limit: limit}, nil
+}
+
+var handlerTestCases = []struct {
+	name           string
+	useCase        *stubUserUseCase
+	request        apievent.Request
+	expectedStatus int
+}{
+	{
+		name:           "creates a user",
+		useCase:        &stubUserUseCase{},
+		request:        apievent.NewRequest(http.MethodPost, "/users", ` + "`" + `{"email":"jane@example.com","name":"Jane"}` + "`" + `),
+		expectedStatus: http.StatusCreated,
+	},
+	{
+		name:           "rejects an invalid body",
+		useCase:        &stubUserUseCase{},
+		request:        apievent.NewRequest(http.MethodPost, "/users", "invalid json"),
+		expectedStatus: http.StatusBadRequest,
+	},
+	{
+		name: "maps not found errors to 404",
+		useCase: &stubUserUseCase{
+			err: &usecases.UseCaseError{Type: usecases.ErrTypeNotFound, Message: "user not found"},
+		},
+		request:        apievent.NewRequest(http.MethodGet, "/users/missing", ""),
+		expectedStatus: http.StatusNotFound,
+	},
+	{
+		name:           "maps write conflicts to 409",
+		useCase:        &stubUserUseCase{err: &repositories.ConflictError{ID: "user-1", Version: 1}},
+		request:        apievent.NewRequest(http.MethodPut, "/users/user-1", ` + "`" + `{"name":"Jane"}` + "`" + `),
+		expectedStatus: http.StatusConflict,
+	},
+	{
+		name:           "hides unexpected errors",
+		useCase:        &stubUserUseCase{err: errors.New("boom")},
+		request:        apievent.NewRequest(http.MethodGet, "/users/user-1", ""),
+		expectedStatus: http.StatusInternalServerError,
+	},
+	{
+		name:           "returns 404 for unknown routes",
+		useCase:        &stubUserUseCase{},
+		request:        apievent.NewRequest(http.MethodGet, "/unknown", ""),
+		expectedStatus: http.StatusNotFound,
+	},
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Handler", func() {
+	for _, tc := range handlerTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			handler := NewHandler(tc.useCase, nil)
+
+			response, err := handler.HandleRequest(context.Background(), tc.request)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(tc.expectedStatus))
+		})
+	}
+})
+{{- else }}
+
+func TestHandler_HandleRequest(t *testing.T) {
+	for _, tc := range handlerTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewHandler(tc.useCase, nil)
+
+			response, err := handler.HandleRequest(context.Background(), tc.request)
+{{- if eq .TestingFramework "standard" }}
+			if err != nil {
+				t.Fatalf("HandleRequest() error = %v", err)
+			}
+			if response.StatusCode != tc.expectedStatus {
+				t.Errorf("StatusCode = %d, want %d", response.StatusCode, tc.expectedStatus)
+			}
+{{- else }}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, response.StatusCode)
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
+
+const CleanProcessMessageTest = `package usecases
+
+import (
+	"context"
+	"encoding/json"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+var processMessageTestCases = []struct {
+	name    string
+	input   ProcessMessageInput
+	wantErr bool
+}{
+	{
+		name: "processes user.created messages",
+		input: ProcessMessageInput{
+			MessageID:   "message-1",
+			MessageType: "user.created",
+			Payload:     json.RawMessage(` + "`" + `{"user_id":"user-1","email":"jane@example.com"}` + "`" + `),
+		},
+	},
+	{
+		name: "fails on malformed payloads",
+		input: ProcessMessageInput{
+			MessageID:   "message-2",
+			MessageType: "user.created",
+			Payload:     json.RawMessage("not json"),
+		},
+		wantErr: true,
+	},
+	{
+		name: "ignores unknown message types",
+		input: ProcessMessageInput{
+			MessageID:   "message-3",
+			MessageType: "unknown",
+		},
+	},
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("ProcessMessageUseCase", func() {
+	for _, tc := range processMessageTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			err := NewProcessMessageUseCase().Execute(context.Background(), tc.input)
+
+			if tc.wantErr {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	}
+})
+{{- else }}
+
+func TestProcessMessageUseCase_Execute(t *testing.T) {
+	for _, tc := range processMessageTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewProcessMessageUseCase().Execute(context.Background(), tc.input)
+{{- if eq .TestingFramework "standard" }}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Execute() error = %v, wantErr %v", err, tc.wantErr)
+			}
+{{- else }}
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
+
+const CleanSQSHandlerTest = `package lambda
+
+import (
+	"context"
+	"errors"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/internal/usecases"
+	"{{.Module}}/pkg/middleware"
+)
+
+const userCreatedBody = ` + "`" + `{"type":"user.created","payload":{"user_id":"user-1"}}` + "`" + `
+
+// stubProcessMessageUseCase fails for the message IDs in failFor.
+type stubProcessMessageUseCase struct {
+	failFor   map[string]bool
+	processed []string
+}
+
+func (s *stubProcessMessageUseCase) Execute(ctx context.Context, input usecases.ProcessMessageInput) error {
+	s.processed = append(s.processed, input.MessageID)
+	if s.failFor[input.MessageID] {
+		return errors.New("downstream unavailable")
+	}
+	return nil
+}
+
+var sqsHandlerTestCases = []struct {
+	name          string
+	records       []events.SQSMessage
+	failFor       map[string]bool
+	keyPath       string
+	noStore       bool
+	wantProcessed int
+	wantFailures  []string
+}{
+	{
+		name:          "processes the whole batch",
+		records:       sqsBatch("message-1", "message-2"),
+		wantProcessed: 2,
+	},
+	{
+		name:          "reports only the failed messages in a mixed batch",
+		records:       sqsBatch("message-1", "message-2", "message-3"),
+		failFor:       map[string]bool{"message-2": true},
+		wantProcessed: 3,
+		wantFailures:  []string{"message-2"},
+	},
+	{
+		name:          "reports every message when the whole batch fails",
+		records:       sqsBatch("message-1", "message-2"),
+		failFor:       map[string]bool{"message-1": true, "message-2": true},
+		wantProcessed: 2,
+		wantFailures:  []string{"message-1", "message-2"},
+	},
+	{
+		name:          "processes a redelivered message once",
+		records:       sqsBatch("message-1", "message-1"),
+		wantProcessed: 1,
+	},
+	{
+		name:          "processes a redelivered message again without an idempotency store",
+		records:       sqsBatch("message-1", "message-1"),
+		noStore:       true,
+		wantProcessed: 2,
+	},
+	{
+		name:          "processes a message sent twice once when keyed on its body",
+		records:       sqsBatch("message-1", "message-2"),
+		keyPath:       "payload.user_id",
+		wantProcessed: 1,
+	},
+	{
+		name:          "processes a failed message again when it is redelivered",
+		records:       sqsBatch("message-1", "message-1"),
+		failFor:       map[string]bool{"message-1": true},
+		wantProcessed: 2,
+		wantFailures:  []string{"message-1", "message-1"},
+	},
+	{
+		name: "drops malformed messages",
+		records: []events.SQSMessage{
+			{MessageId: "message-1", Body: "not json"},
+		},
+	},
+}
+
+func sqsBatch(ids ...string) []events.SQSMessage {
+	records := make([]events.SQSMessage, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, events.SQSMessage{MessageId: id, Body: userCreatedBody})
+	}
+	return records
+}
+
+func runSQSHandler(records []events.SQSMessage, failFor map[string]bool, keyPath string, noStore bool) (*stubProcessMessageUseCase, events.SQSEventResponse, error) {
+	useCase := &stubProcessMessageUseCase{failFor: failFor}
+	handler := NewSQSHandler(useCase)
+	if !noStore {
+		handler.WithIdempotency(middleware.IdempotencyConfig{
+			Store:   middleware.NewInMemoryIdempotencyStore(),
+			KeyPath: keyPath,
+		})
+	}
+	response, err := handler.HandleRequest(context.Background(), events.SQSEvent{Records: records})
+	return useCase, response, err
+}
+
+func failedMessageIDs(response events.SQSEventResponse) []string {
+	var ids []string
+	for _, failure := range response.BatchItemFailures {
+		ids = append(ids, failure.ItemIdentifier)
+	}
+	return ids
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("SQSHandler", func() {
+	for _, tc := range sqsHandlerTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			useCase, response, err := runSQSHandler(tc.records, tc.failFor, tc.keyPath, tc.noStore)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(useCase.processed).To(HaveLen(tc.wantProcessed))
+			Expect(failedMessageIDs(response)).To(Equal(tc.wantFailures))
+		})
+	}
+})
+{{- else }}
+
+func TestSQSHandler_HandleRequest(t *testing.T) {
+	for _, tc := range sqsHandlerTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useCase, response, err := runSQSHandler(tc.records, tc.failFor, tc.keyPath, tc.noStore)
+{{- if eq .TestingFramework "standard" }}
+			if err != nil {
+				t.Fatalf("HandleRequest() error = %v", err)
+			}
+			if len(useCase.processed) != tc.wantProcessed {
+				t.Errorf("processed %d messages, want %d", len(useCase.processed), tc.wantProcessed)
+			}
+			if got := failedMessageIDs(response); !reflect.DeepEqual(got, tc.wantFailures) {
+				t.Errorf("BatchItemFailures = %v, want %v", got, tc.wantFailures)
+			}
+{{- else }}
+			require.NoError(t, err)
+			assert.Len(t, useCase.processed, tc.wantProcessed)
+			assert.Equal(t, tc.wantFailures, failedMessageIDs(response))
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
+
+const APIEventTest = `package apievent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+	"strings"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+var matchPathTestCases = []struct {
+	name    string
+	pattern string
+	path    string
+	params  map[string]string
+	ok      bool
+}{
+	{
+		name:    "static path",
+		pattern: "/users",
+		path:    "/users",
+		params:  map[string]string{},
+		ok:      true,
+	},
+	{
+		name:    "trailing slash",
+		pattern: "/users",
+		path:    "/users/",
+		params:  map[string]string{},
+		ok:      true,
+	},
+	{
+		name:    "path parameter",
+		pattern: "/users/{id}",
+		path:    "/users/user-1",
+		params:  map[string]string{"id": "user-1"},
+		ok:      true,
+	},
+	{
+		name:    "escaped path parameter",
+		pattern: "/users/{id}",
+		path:    "/users/user%201",
+		params:  map[string]string{"id": "user 1"},
+		ok:      true,
+	},
+	{
+		name:    "empty path parameter",
+		pattern: "/users/{id}",
+		path:    "/users/",
+		ok:      false,
+	},
+	{
+		name:    "extra segment",
+		pattern: "/users/{id}",
+		path:    "/users/user-1/orders",
+		ok:      false,
+	},
+	{
+		name:    "different segment",
+		pattern: "/users/{id}",
+		path:    "/orders/order-1",
+		ok:      false,
+	},
+}
+
+// echoHandler writes back what it received so tests can check the
+// translation in both directions
+func echoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s %s %s", r.Method, r.URL.Path, r.URL.Query().Get("page"), r.Header.Get("X-Trace"), body)
+	})
+}
+
+// serveEcho runs echoHandler for an event
+func serveEcho() (Response, error) {
+	request := NewRequest(http.MethodPost, "/users?page=2", "hello")
+	request.Headers["x-trace"] = "trace-1"
+	return Serve(context.Background(), echoHandler(), request)
+}
+
+// setCookie returns the Set-Cookie header of a response
+func setCookie(response Response) string {
+{{- if or (eq .APIType "http") (eq .APIType "function-url") }}
+	return strings.Join(response.Cookies, ",")
+{{- else if eq .APIType "rest" }}
+	return strings.Join(response.MultiValueHeaders["Set-Cookie"], ",")
+{{- else }}
+	return response.Headers["Set-Cookie"]
+{{- end }}
+}
+
+// serveLocal sends an HTTP request through LocalHandler to a handler that
+// echoes the event it receives
+func serveLocal(handlerErr error) *httptest.ResponseRecorder {
+	handler := LocalHandler(func(ctx context.Context, request Request) (Response, error) {
+		if handlerErr != nil {
+			return Response{}, handlerErr
+		}
+		body, err := Body(request)
+		if err != nil {
+			return Response{}, err
+		}
+		echo := fmt.Sprintf("%s %s %s %s", Method(request), Path(request), Header(request, "X-Trace"), body)
+		return NewResponse(http.StatusAccepted, map[string]string{"X-Source": Source}, echo), nil
+	})
+
+	r := httptest.NewRequest(http.MethodPut, "/users/user-1?page=2", strings.NewReader("hello"))
+	r.Header.Set("X-Trace", "trace-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("matchPath", func() {
+	for _, tc := range matchPathTestCases {
+		tc := tc
+
+		It("handles a "+tc.name, func() {
+			params, ok := matchPath(tc.pattern, tc.path)
+
+			Expect(ok).To(Equal(tc.ok))
+			if tc.ok {
+				Expect(params).To(Equal(tc.params))
+			}
+		})
+	}
+})
+
+var _ = Describe("NewRequest", func() {
+	It("builds a request the helpers can read", func() {
+		request := NewRequest(http.MethodGet, "/users/user-1?page=2", "")
+		request.Headers["x-trace"] = "trace-1"
+
+		Expect(Method(request)).To(Equal(http.MethodGet))
+		Expect(Path(request)).To(Equal("/users/user-1"))
+		Expect(request.QueryStringParameters).To(HaveKeyWithValue("page", "2"))
+		Expect(Header(request, "X-Trace")).To(Equal("trace-1"))
+		Expect(Matches(request, http.MethodGet, "/users/{id}")).To(BeTrue())
+		Expect(Matches(request, http.MethodDelete, "/users/{id}")).To(BeFalse())
+		Expect(PathParameter(request, "/users/{id}", "id")).To(Equal("user-1"))
+	})
+})
+
+var _ = Describe("Serve", func() {
+	It("translates the event for an http.Handler and back", func() {
+		response, err := serveEcho()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusCreated))
+		Expect(response.Body).To(Equal("POST /users 2 trace-1 hello"))
+		Expect(response.Headers).To(HaveKeyWithValue("Content-Type", "text/plain"))
+		Expect(setCookie(response)).To(Equal("session=abc"))
+	})
+})
+
+var _ = Describe("LocalHandler", func() {
+	It("serves the handler over HTTP", func() {
+		w := serveLocal(nil)
+
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Expect(w.Body.String()).To(Equal("PUT /users/user-1 trace-1 hello"))
+		Expect(w.Header().Get("X-Source")).To(Equal(Source))
+	})
+
+	It("answers a handler error with 502", func() {
+		w := serveLocal(errors.New("boom"))
+
+		Expect(w.Code).To(Equal(http.StatusBadGateway))
+	})
+})
+{{- else }}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range matchPathTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params, ok := matchPath(tc.pattern, tc.path)
+{{- if eq .TestingFramework "standard" }}
+			if ok != tc.ok {
+				t.Fatalf("matchPath() ok = %v, want %v", ok, tc.ok)
+			}
+			if tc.ok && !reflect.DeepEqual(params, tc.params) {
+				t.Errorf("matchPath() params = %v, want %v", params, tc.params)
+			}
+{{- else }}
+			require.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, tc.params, params)
+			}
+{{- end }}
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	request := NewRequest(http.MethodGet, "/users/user-1?page=2", "")
+	request.Headers["x-trace"] = "trace-1"
+{{- if eq .TestingFramework "standard" }}
+	if got := Method(request); got != http.MethodGet {
+		t.Errorf("Method() = %q, want %q", got, http.MethodGet)
+	}
+	if got := Path(request); got != "/users/user-1" {
+		t.Errorf("Path() = %q, want %q", got, "/users/user-1")
+	}
+	if got := request.QueryStringParameters["page"]; got != "2" {
+		t.Errorf("query page = %q, want %q", got, "2")
+	}
+	if got := Header(request, "X-Trace"); got != "trace-1" {
+		t.Errorf("Header() = %q, want %q", got, "trace-1")
+	}
+	if !Matches(request, http.MethodGet, "/users/{id}") {
+		t.Error("expected GET /users/{id} to match")
+	}
+	if Matches(request, http.MethodDelete, "/users/{id}") {
+		t.Error("expected DELETE /users/{id} not to match")
+	}
+	if got := PathParameter(request, "/users/{id}", "id"); got != "user-1" {
+		t.Errorf("PathParameter() = %q, want %q", got, "user-1")
+	}
+{{- else }}
+	assert.Equal(t, http.MethodGet, Method(request))
+	assert.Equal(t, "/users/user-1", Path(request))
+	assert.Equal(t, "2", request.QueryStringParameters["page"])
+	assert.Equal(t, "trace-1", Header(request, "X-Trace"))
+	assert.True(t, Matches(request, http.MethodGet, "/users/{id}"))
+	assert.False(t, Matches(request, http.MethodDelete, "/users/{id}"))
+	assert.Equal(t, "user-1", PathParameter(request, "/users/{id}", "id"))
+{{- end }}
+}
+
+func TestServe(t *testing.T) {
+	response, err := serveEcho()
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	if response.StatusCode != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", response.StatusCode, http.StatusCreated)
+	}
+	if want := "POST /users 2 trace-1 hello"; response.Body != want {
+		t.Errorf("Body = %q, want %q", response.Body, want)
+	}
+	if got := response.Headers["Content-Type"]; got != "text/plain" {
+		t.Errorf("Content-Type = %q, want %q", got, "text/plain")
+	}
+	if got := setCookie(response); got != "session=abc" {
+		t.Errorf("Set-Cookie = %q, want %q", got, "session=abc")
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, "POST /users 2 trace-1 hello", response.Body)
+	assert.Equal(t, "text/plain", response.Headers["Content-Type"])
+	assert.Equal(t, "session=abc", setCookie(response))
+{{- end }}
+}
+
+func TestLocalHandler(t *testing.T) {
+	w := serveLocal(nil)
+	failed := serveLocal(errors.New("boom"))
+{{- if eq .TestingFramework "standard" }}
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if want := "PUT /users/user-1 trace-1 hello"; w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body.String(), want)
+	}
+	if got := w.Header().Get("X-Source"); got != Source {
+		t.Errorf("X-Source = %q, want %q", got, Source)
+	}
+	if failed.Code != http.StatusBadGateway {
+		t.Errorf("status on handler error = %d, want %d", failed.Code, http.StatusBadGateway)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "PUT /users/user-1 trace-1 hello", w.Body.String())
+	assert.Equal(t, Source, w.Header().Get("X-Source"))
+	assert.Equal(t, http.StatusBadGateway, failed.Code)
+{{- end }}
+}
+{{- end }}
+`
+
+const MiddlewareTest = `package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+	"strings"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+{{- if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+	"github.com/rs/zerolog"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+	"{{.Module}}/pkg/logger"
+)
+
+var correlationIDTestCases = []struct {
+	name  string
+	event any
+	want  string
+}{
+	{
+		name:  "API header",
+		event: correlatedAPIRequest("corr-api"),
+		want:  "corr-api",
+	},
+	{
+		name:  "SQS message attribute",
+		event: sqsEvent("", "corr-sqs"),
+		want:  "corr-sqs",
+	},
+	{
+		name:  "SQS message without attribute",
+		event: sqsEvent(""),
+		want:  "",
+	},
+	{
+		name: "SNS message attribute",
+		event: events.SNSEvent{Records: []events.SNSEventRecord{
+			{SNS: events.SNSEntity{MessageAttributes: map[string]interface{}{
+				CorrelationIDAttribute: map[string]interface{}{"Type": "String", "Value": "corr-sns"},
+			}}},
+		}},
+		want: "corr-sns",
+	},
+	{
+		name: "EventBridge detail",
+		event: events.CloudWatchEvent{
+			ID:     "event-1",
+			Detail: json.RawMessage("{\"correlation_id\":\"corr-eb\"}"),
+		},
+		want: "corr-eb",
+	},
+	{
+		name:  "EventBridge event ID fallback",
+		event: events.CloudWatchEvent{ID: "event-1", Detail: json.RawMessage("{}")},
+		want:  "event-1",
+	},
+	{
+		name: "DynamoDB stream new image",
+		event: events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+			{Change: events.DynamoDBStreamRecord{NewImage: map[string]events.DynamoDBAttributeValue{
+				CorrelationIDAttribute: events.NewStringAttribute("corr-ddb"),
+			}}},
+		}},
+		want: "corr-ddb",
+	},
+	{
+		name: "S3 request ID",
+		event: events.S3Event{Records: []events.S3EventRecord{
+			{ResponseElements: map[string]string{"x-amz-request-id": "corr-s3"}},
+		}},
+		want: "corr-s3",
+	},
+	{
+		name:  "unknown trigger",
+		event: struct{}{},
+		want:  "",
+	},
+}
+
+// correlatedAPIRequest builds an API request with a correlation ID header
+func correlatedAPIRequest(correlationID string) apievent.Request {
+	request := apievent.NewRequest(http.MethodGet, "/users", "")
+	request.Headers["x-correlation-id"] = correlationID
+	return request
+}
+
+// sqsEvent builds a batch whose messages carry the given correlation IDs;
+// an empty ID leaves the attribute out
+func sqsEvent(correlationIDs ...string) events.SQSEvent {
+	var event events.SQSEvent
+	for _, correlationID := range correlationIDs {
+		message := events.SQSMessage{MessageId: "msg-1"}
+		if correlationID != "" {
+			value := correlationID
+			message.MessageAttributes = map[string]events.SQSMessageAttribute{
+				CorrelationIDAttribute: {DataType: "String", StringValue: &value},
+			}
+		}
+		event.Records = append(event.Records, message)
+	}
+	return event
+}
+
+// invocationContext is the context Lambda hands a handler
+func invocationContext() context.Context {
+	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "lambda-request-1"})
+}
+
+// runSQSRequestID runs an SQS handler behind RequestID and returns the IDs
+// it saw in its context
+func runSQSRequestID(event events.SQSEvent) (string, string) {
+	var requestID, correlationID string
+	handler := RequestID[events.SQSEvent, events.SQSEventResponse]()(func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		requestID, correlationID = GetRequestID(ctx), GetCorrelationID(ctx)
+		return events.SQSEventResponse{}, nil
+	})
+
+	_, _ = handler(invocationContext(), event)
+	return requestID, correlationID
+}
+
+// runAPIRequestID returns the X-Request-ID header RequestID puts on a
+// response and the request ID it should carry: the event source's, or the
+// Lambda request ID for sources that assign none
+func runAPIRequestID() (string, string) {
+	handler := RequestID[apievent.Request, apievent.Response]()(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		return apievent.NewResponse(http.StatusOK, nil, ""), nil
+	})
+
+	request := apievent.NewRequest(http.MethodGet, "/users", "")
+	want := apievent.RequestID(request)
+	if want == "" {
+		want = "lambda-request-1"
+	}
+
+	response, _ := handler(invocationContext(), request)
+	return response.Headers["X-Request-ID"], want
+}
+
+// runPanickingSQS returns what DefaultChain makes of a panicking SQS handler
+func runPanickingSQS() error {
+	handler := DefaultChain[events.SQSEvent, events.SQSEventResponse]()(func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		panic("boom")
+	})
+
+	_, err := handler(invocationContext(), sqsEvent("corr-1"))
+	return err
+}
+
+// runPanickingAPI returns what DefaultChain makes of a panicking API handler
+func runPanickingAPI() (int, error) {
+	handler := DefaultChain[apievent.Request, apievent.Response]()(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		panic("boom")
+	})
+
+	response, err := handler(invocationContext(), apievent.NewRequest(http.MethodGet, "/users", ""))
+	return response.StatusCode, err
+}
+
+// loggedSecrets are the secrets runLogging sends
+var loggedSecrets = []string{"secret-token", "secret-key", "hunter2", "jane@example.com"}
+
+// runLogging runs an API request carrying secrets in its headers and body
+// through Logging, with the redaction logger.Init sets up, and returns what
+// was logged
+func runLogging() (string, error) {
+	redactor, err := logger.NewRedactor(logger.DefaultRedaction())
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	ctx := zerolog.New(redactor.Writer(&buf)).Level(zerolog.DebugLevel).WithContext(context.Background())
+	handler := Logging[apievent.Request, apievent.Response]()(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		return apievent.NewResponse(http.StatusOK, nil, ""), nil
+	})
+
+	request := apievent.NewRequest(http.MethodPost, "/login", "{\"email\":\"jane@example.com\",\"password\":\"hunter2\"}")
+	request.Headers["Authorization"] = "Bearer secret-token"
+	request.Headers["X-Api-Key"] = "secret-key"
+
+	_, err = handler(ctx, request)
+	return buf.String(), err
+}
+
+// runChain records the order in which chained middlewares run
+func runChain() ([]string, error) {
+	var order []string
+	record := func(name string) Middleware[string, string] {
+		return func(next HandlerFunc[string, string]) HandlerFunc[string, string] {
+			return func(ctx context.Context, in string) (string, error) {
+				order = append(order, name)
+				return next(ctx, in)
+			}
+		}
+	}
+
+	handler := Chain(record("first"), record("second"))(func(ctx context.Context, in string) (string, error) {
+		order = append(order, "handler")
+		return "", errors.New(in)
+	})
+
+	_, err := handler(context.Background(), "handler failed")
+	return order, err
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("ExtractCorrelationID", func() {
+	for _, tc := range correlationIDTestCases {
+		tc := tc
+
+		It("reads the "+tc.name, func() {
+			Expect(ExtractCorrelationID(tc.event)).To(Equal(tc.want))
+		})
+	}
+})
+
+var _ = Describe("RequestID", func() {
+	It("uses the Lambda request ID and the message correlation ID for SQS", func() {
+		requestID, correlationID := runSQSRequestID(sqsEvent("corr-1"))
+
+		Expect(requestID).To(Equal("lambda-request-1"))
+		Expect(correlationID).To(Equal("corr-1"))
+	})
+
+	It("falls back to the request ID as correlation ID", func() {
+		_, correlationID := runSQSRequestID(sqsEvent(""))
+
+		Expect(correlationID).To(Equal("lambda-request-1"))
+	})
+
+	It("sets the request ID on API responses", func() {
+		header, want := runAPIRequestID()
+
+		Expect(header).To(Equal(want))
+	})
+})
+
+var _ = Describe("Logging", func() {
+	It("masks secrets in API headers and bodies", func() {
+		out, err := runLogging()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(ContainSubstring("/login"))
+		Expect(out).To(ContainSubstring(logger.Mask))
+		for _, secret := range loggedSecrets {
+			Expect(out).NotTo(ContainSubstring(secret))
+		}
+	})
+})
+
+var _ = Describe("Recovery", func() {
+	It("turns an event handler panic into an error", func() {
+		Expect(runPanickingSQS()).To(MatchError(ContainSubstring("boom")))
+	})
+
+	It("turns an API handler panic into a 500", func() {
+		status, err := runPanickingAPI()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(http.StatusInternalServerError))
+	})
+})
+
+var _ = Describe("Chain", func() {
+	It("runs middlewares in order around the handler", func() {
+		order, err := runChain()
+
+		Expect(err).To(MatchError("handler failed"))
+		Expect(order).To(Equal([]string{"first", "second", "handler"}))
+	})
+})
+{{- else }}
+
+func TestExtractCorrelationID(t *testing.T) {
+	for _, tc := range correlationIDTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ExtractCorrelationID(tc.event)
+{{- if eq .TestingFramework "standard" }}
+			if got != tc.want {
+				t.Errorf("ExtractCorrelationID() = %q, want %q", got, tc.want)
+			}
+{{- else }}
+			assert.Equal(t, tc.want, got)
+{{- end }}
+		})
+	}
+}
+
+func TestRequestID_SQS(t *testing.T) {
+	requestID, correlationID := runSQSRequestID(sqsEvent("corr-1"))
+	_, fallbackID := runSQSRequestID(sqsEvent(""))
+{{- if eq .TestingFramework "standard" }}
+	if requestID != "lambda-request-1" {
+		t.Errorf("request ID = %q, want %q", requestID, "lambda-request-1")
+	}
+	if correlationID != "corr-1" {
+		t.Errorf("correlation ID = %q, want %q", correlationID, "corr-1")
+	}
+	if fallbackID != "lambda-request-1" {
+		t.Errorf("fallback correlation ID = %q, want %q", fallbackID, "lambda-request-1")
+	}
+{{- else }}
+	assert.Equal(t, "lambda-request-1", requestID)
+	assert.Equal(t, "corr-1", correlationID)
+	assert.Equal(t, "lambda-request-1", fallbackID)
+{{- end }}
+}
+
+func TestRequestID_APIResponseHeader(t *testing.T) {
+	header, want := runAPIRequestID()
+{{- if eq .TestingFramework "standard" }}
+	if header != want {
+		t.Errorf("X-Request-ID = %q, want %q", header, want)
+	}
+{{- else }}
+	assert.Equal(t, want, header)
+{{- end }}
+}
+
+func TestLogging_Redaction(t *testing.T) {
+	out, err := runLogging()
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, "/login") || !strings.Contains(out, logger.Mask) {
+		t.Errorf("output = %s, want the request logged with secrets masked", out)
+	}
+	for _, secret := range loggedSecrets {
+		if strings.Contains(out, secret) {
+			t.Errorf("output leaks %q: %s", secret, out)
+		}
+	}
+{{- else }}
+	assert.NoError(t, err)
+	assert.Contains(t, out, "/login")
+	assert.Contains(t, out, logger.Mask)
+	for _, secret := range loggedSecrets {
+		assert.NotContains(t, out, secret)
+	}
+{{- end }}
+}
+
+func TestRecovery(t *testing.T) {
+	sqsErr := runPanickingSQS()
+	status, apiErr := runPanickingAPI()
+{{- if eq .TestingFramework "standard" }}
+	if sqsErr == nil {
+		t.Error("expected an error from a panicking SQS handler")
+	}
+	if apiErr != nil {
+		t.Errorf("unexpected error from a panicking API handler: %v", apiErr)
+	}
+	if status != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", status, http.StatusInternalServerError)
+	}
+{{- else }}
+	assert.ErrorContains(t, sqsErr, "boom")
+	assert.NoError(t, apiErr)
+	assert.Equal(t, http.StatusInternalServerError, status)
+{{- end }}
+}
+
+func TestChain(t *testing.T) {
+	order, err := runChain()
+{{- if eq .TestingFramework "standard" }}
+	if err == nil || err.Error() != "handler failed" {
+		t.Errorf("err = %v, want handler failed", err)
+	}
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+{{- else }}
+	assert.EqualError(t, err, "handler failed")
+	assert.Equal(t, []string{"first", "second", "handler"}, order)
+{{- end }}
+}
+{{- end }}
+`
+
+const IdempotencyTest = `package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+
+var idempotencyAPITestCases = []struct {
+	name         string
+	requests     []apievent.Request
+	statuses     []int
+	wantCalls    int
+	wantStatuses []int
+	wantReplayed []bool
+}{
+	{
+		name:         "replays the stored response for a repeated key",
+		requests:     []apievent.Request{keyedRequest("key-1", "a"), keyedRequest("key-1", "a")},
+		statuses:     []int{http.StatusCreated},
+		wantCalls:    1,
+		wantStatuses: []int{http.StatusCreated, http.StatusCreated},
+		wantReplayed: []bool{false, true},
+	},
+	{
+		name:         "rejects a key reused for a different request",
+		requests:     []apievent.Request{keyedRequest("key-1", "a"), keyedRequest("key-1", "b")},
+		statuses:     []int{http.StatusCreated},
+		wantCalls:    1,
+		wantStatuses: []int{http.StatusCreated, http.StatusUnprocessableEntity},
+		wantReplayed: []bool{false, false},
+	},
+	{
+		name:         "does not store server errors",
+		requests:     []apievent.Request{keyedRequest("key-1", "a"), keyedRequest("key-1", "a")},
+		statuses:     []int{http.StatusInternalServerError, http.StatusCreated},
+		wantCalls:    2,
+		wantStatuses: []int{http.StatusInternalServerError, http.StatusCreated},
+		wantReplayed: []bool{false, false},
+	},
+	{
+		name:         "keeps the keys of each user apart",
+		requests:     []apievent.Request{apievent.WithUserID(keyedRequest("key-1", "a"), "user-1"), apievent.WithUserID(keyedRequest("key-1", "b"), "user-2")},
+		statuses:     []int{http.StatusCreated},
+		wantCalls:    2,
+		wantStatuses: []int{http.StatusCreated, http.StatusCreated},
+		wantReplayed: []bool{false, false},
+	},
+	{
+		name:         "keeps the keys of each route apart",
+		requests:     []apievent.Request{keyedRequest("key-1", "a"), keyedRequestTo(http.MethodPut, "/users/1", "key-1", "a")},
+		statuses:     []int{http.StatusCreated, http.StatusOK},
+		wantCalls:    2,
+		wantStatuses: []int{http.StatusCreated, http.StatusOK},
+		wantReplayed: []bool{false, false},
+	},
+	{
+		name:         "passes requests without a key through",
+		requests:     []apievent.Request{keyedRequest("", "a"), keyedRequest("", "a")},
+		statuses:     []int{http.StatusCreated},
+		wantCalls:    2,
+		wantStatuses: []int{http.StatusCreated, http.StatusCreated},
+		wantReplayed: []bool{false, false},
+	},
+}
+
+var idempotencyEventTestCases = []struct {
+	name      string
+	payloads  []string
+	failFirst bool
+	gap       time.Duration
+	wantCalls int
+}{
+	{
+		name:      "processes an event key once",
+		payloads:  []string{orderEvent("order-1", 1), orderEvent("order-1", 2)},
+		wantCalls: 1,
+	},
+	{
+		name:      "processes different keys",
+		payloads:  []string{orderEvent("order-1", 1), orderEvent("order-2", 1)},
+		wantCalls: 2,
+	},
+	{
+		name:      "processes events without the key",
+		payloads:  []string{"{\"detail\":{}}", "{\"detail\":{}}"},
+		wantCalls: 2,
+	},
+	{
+		name:      "processes a key again once its record expired",
+		payloads:  []string{orderEvent("order-1", 1), orderEvent("order-1", 2)},
+		gap:       25 * time.Hour,
+		wantCalls: 2,
+	},
+	{
+		name:      "releases failed events for retry",
+		payloads:  []string{orderEvent("order-1", 1), orderEvent("order-1", 1)},
+		failFirst: true,
+		wantCalls: 2,
+	},
+}
+
+func keyedRequest(key, body string) apievent.Request {
+	return keyedRequestTo(http.MethodPost, "/users", key, body)
+}
+
+func keyedRequestTo(method, target, key, body string) apievent.Request {
+	request := apievent.NewRequest(method, target, body)
+	if key != "" {
+		request.Headers["idempotency-key"] = key
+	}
+	return request
+}
+
+func orderEvent(orderID string, attempt int) string {
+	return fmt.Sprintf("{\"detail\":{\"order_id\":%q,\"attempt\":%d}}", orderID, attempt)
+}
+
+// runAPIRequests sends requests through UserID and Idempotency to a
+// handler that answers with statuses in order, repeating the last one
+func runAPIRequests(requests []apievent.Request, statuses []int) (int, []int, []bool) {
+	calls := 0
+	handler := Chain(UserID(), Idempotency(IdempotencyConfig{Store: NewInMemoryIdempotencyStore()}))(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		status := statuses[len(statuses)-1]
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		return apievent.Response{StatusCode: status, Body: request.Body}, nil
+	})
+
+	var (
+		gotStatuses []int
+		replayed    []bool
+	)
+	for _, request := range requests {
+		response, err := handler(context.Background(), request)
+		if err != nil {
+			response.StatusCode = 0
+		}
+		gotStatuses = append(gotStatuses, response.StatusCode)
+		replayed = append(replayed, response.Headers[ReplayedHeader] == "true")
+	}
+	return calls, gotStatuses, replayed
+}
+
+// runOverlappingRequests repeats a request while the first one is running
+// and returns the status the duplicate got
+func runOverlappingRequests() int {
+	var (
+		handler   APIHandlerFunc
+		duplicate apievent.Response
+	)
+	handler = Idempotency(IdempotencyConfig{Store: NewInMemoryIdempotencyStore()})(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		if duplicate.StatusCode == 0 {
+			duplicate, _ = handler(ctx, request)
+		}
+		return apievent.Response{StatusCode: http.StatusCreated}, nil
+	})
+
+	_, _ = handler(context.Background(), keyedRequest("key-1", "a"))
+	return duplicate.StatusCode
+}
+
+// runEventPastDeadline repeats an event after the deadline of the first
+// one passed while it is still running, as when its invocation timed out,
+// and returns how often the handler was called
+func runEventPastDeadline() int {
+	now := time.Now()
+	calls := 0
+	var handler EventHandlerFunc
+	handler = IdempotentEvent(IdempotencyConfig{
+		Store:   NewInMemoryIdempotencyStore(),
+		KeyPath: "detail.order_id",
+		Now:     func() time.Time { return now },
+	}, func(ctx context.Context, payload json.RawMessage) (json.RawMessage, error) {
+		calls++
+		if calls == 1 {
+			now = now.Add(time.Minute)
+			_, _ = handler(context.Background(), payload)
+		}
+		return payload, nil
+	})
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(30*time.Second))
+	defer cancel()
+	_, _ = handler(ctx, json.RawMessage(orderEvent("order-1", 1)))
+	return calls
+}
+
+// inMemoryRecordsAfterExpiry stores a record, lets it expire and stores
+// another one a sweep interval later, and returns how many records the
+// store still holds
+func inMemoryRecordsAfterExpiry() int {
+	store := NewInMemoryIdempotencyStore()
+	now := time.Now()
+
+	_, _ = store.Begin(context.Background(), IdempotencyRecord{Key: "key-1", Status: StatusCompleted, ExpiresAt: now.Add(time.Second)}, now)
+	now = now.Add(inMemorySweepInterval)
+	_, _ = store.Begin(context.Background(), IdempotencyRecord{Key: "key-2", Status: StatusCompleted, ExpiresAt: now.Add(time.Second)}, now)
+	return store.Len()
+}
+
+// runEvents feeds payloads to an event handler keyed on detail.order_id,
+// advancing the clock by gap after each one
+func runEvents(payloads []string, failFirst bool, gap time.Duration) int {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	handler := IdempotentEvent(IdempotencyConfig{
+		Store:   NewInMemoryIdempotencyStore(),
+		KeyPath: "detail.order_id",
+		Now:     func() time.Time { return now },
+	}, func(ctx context.Context, payload json.RawMessage) (json.RawMessage, error) {
+		calls++
+		if failFirst && calls == 1 {
+			return nil, errors.New("downstream unavailable")
+		}
+		return payload, nil
+	})
+
+	for _, payload := range payloads {
+		_, _ = handler(context.Background(), json.RawMessage(payload))
+		now = now.Add(gap)
+	}
+	return calls
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Idempotency", func() {
+	for _, tc := range idempotencyAPITestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			calls, statuses, replayed := runAPIRequests(tc.requests, tc.statuses)
+
+			Expect(calls).To(Equal(tc.wantCalls))
+			Expect(statuses).To(Equal(tc.wantStatuses))
+			Expect(replayed).To(Equal(tc.wantReplayed))
+		})
+	}
+
+	It("rejects a duplicate while the first request is running", func() {
+		Expect(runOverlappingRequests()).To(Equal(http.StatusConflict))
+	})
+})
+
+var _ = Describe("IdempotentEvent", func() {
+	for _, tc := range idempotencyEventTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			Expect(runEvents(tc.payloads, tc.failFirst, tc.gap)).To(Equal(tc.wantCalls))
+		})
+	}
+
+	It("locks a running event only until its deadline", func() {
+		Expect(runEventPastDeadline()).To(Equal(2))
+	})
+})
+
+var _ = Describe("InMemoryIdempotencyStore", func() {
+	It("drops expired records", func() {
+		Expect(inMemoryRecordsAfterExpiry()).To(Equal(1))
+	})
+})
+{{- else }}
+
+func TestIdempotency(t *testing.T) {
+	for _, tc := range idempotencyAPITestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls, statuses, replayed := runAPIRequests(tc.requests, tc.statuses)
+{{- if eq .TestingFramework "standard" }}
+			if calls != tc.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tc.wantCalls)
+			}
+			if !reflect.DeepEqual(statuses, tc.wantStatuses) {
+				t.Errorf("statuses = %v, want %v", statuses, tc.wantStatuses)
+			}
+			if !reflect.DeepEqual(replayed, tc.wantReplayed) {
+				t.Errorf("replayed = %v, want %v", replayed, tc.wantReplayed)
+			}
+{{- else }}
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantStatuses, statuses)
+			assert.Equal(t, tc.wantReplayed, replayed)
+{{- end }}
+		})
+	}
+}
+
+func TestIdempotency_RejectsOverlappingDuplicate(t *testing.T) {
+	status := runOverlappingRequests()
+{{- if eq .TestingFramework "standard" }}
+	if status != http.StatusConflict {
+		t.Errorf("duplicate status = %d, want %d", status, http.StatusConflict)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusConflict, status)
+{{- end }}
+}
+
+func TestIdempotentEvent(t *testing.T) {
+	for _, tc := range idempotencyEventTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := runEvents(tc.payloads, tc.failFirst, tc.gap)
+{{- if eq .TestingFramework "standard" }}
+			if calls != tc.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tc.wantCalls)
+			}
+{{- else }}
+			assert.Equal(t, tc.wantCalls, calls)
+{{- end }}
+		})
+	}
+}
+
+func TestIdempotentEvent_LocksUntilDeadline(t *testing.T) {
+	calls := runEventPastDeadline()
+{{- if eq .TestingFramework "standard" }}
+	if calls != 2 {
+		t.Errorf("handler calls = %d, want 2: the duplicate after the deadline should run", calls)
+	}
+{{- else }}
+	assert.Equal(t, 2, calls, "the duplicate after the deadline should run")
+{{- end }}
+}
+
+func TestInMemoryIdempotencyStore_DropsExpiredRecords(t *testing.T) {
+	held := inMemoryRecordsAfterExpiry()
+{{- if eq .TestingFramework "standard" }}
+	if held != 1 {
+		t.Errorf("Len() = %d, want 1: the expired record should be dropped", held)
+	}
+{{- else }}
+	assert.Equal(t, 1, held, "the expired record should be dropped")
+{{- end }}
+}
+{{- end }}
+`
+
+const RateLimitTest = `package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+
+// rateLimitCaller is who sends a request: an API key, a user, or neither
+type rateLimitCaller struct {
+	apiKey string
+	userID string
+}
+
+var rateLimitTestCases = []struct {
+	name         string
+	callers      []rateLimitCaller
+	gap          time.Duration
+	wantStatuses []int
+}{
+	{
+		name:         "allows a burst, then throttles",
+		callers:      []rateLimitCaller{caller("key-1", ""), caller("key-1", ""), caller("key-1", "")},
+		wantStatuses: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+	},
+	{
+		name:         "refills the bucket over time",
+		callers:      []rateLimitCaller{caller("key-1", ""), caller("key-1", ""), caller("key-1", "")},
+		gap:          time.Second,
+		wantStatuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+	},
+	{
+		name:         "limits each API key on its own",
+		callers:      []rateLimitCaller{caller("key-1", ""), caller("key-1", ""), caller("key-2", "")},
+		wantStatuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+	},
+	{
+		name:         "limits a user whatever API key they use",
+		callers:      []rateLimitCaller{caller("key-1", "user-1"), caller("key-2", "user-1")},
+		wantStatuses: []int{http.StatusOK, http.StatusTooManyRequests},
+	},
+	{
+		name:         "takes no API key token for a throttled user",
+		callers:      []rateLimitCaller{caller("key-1", "user-1"), caller("key-1", "user-1"), caller("key-1", "")},
+		wantStatuses: []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+	},
+	{
+		name:         "passes anonymous requests through",
+		callers:      []rateLimitCaller{caller("", ""), caller("", ""), caller("", "")},
+		wantStatuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+	},
+}
+
+func caller(apiKey, userID string) rateLimitCaller {
+	return rateLimitCaller{apiKey: apiKey, userID: userID}
+}
+
+// failingRateLimitStore is a RateLimitStore that is always unavailable
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Peek(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error) {
+	return RateLimitDecision{}, errors.New("store unavailable")
+}
+
+func (failingRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitDecision, error) {
+	return RateLimitDecision{}, errors.New("store unavailable")
+}
+
+// runRateLimited sends a request per caller through UserID and RateLimit,
+// which allows 2 requests per API key and 1 per user at once and refills a
+// token per second, advancing the clock by gap after each one
+func runRateLimited(store RateLimitStore, callers []rateLimitCaller, gap time.Duration) []apievent.Response {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := Chain(UserID(), RateLimit(RateLimitConfig{
+		Store: store,
+		Rules: []RateLimitRule{
+			PerAPIKey(Limit{Rate: 1, Burst: 2}),
+			PerUser(Limit{Rate: 1, Burst: 1}),
+		},
+		Now: func() time.Time { return now },
+	}))(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		return apievent.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	var responses []apievent.Response
+	for _, from := range callers {
+		request := apievent.NewRequest(http.MethodGet, "/users", "")
+		if from.userID != "" {
+			request = apievent.WithUserID(request, from.userID)
+		}
+		if from.apiKey != "" {
+			request.Headers["x-api-key"] = from.apiKey
+		}
+
+		response, _ := handler(context.Background(), request)
+		responses = append(responses, response)
+		now = now.Add(gap)
+	}
+	return responses
+}
+
+func rateLimitStatuses(responses []apievent.Response) []int {
+	statuses := make([]int, len(responses))
+	for i, response := range responses {
+		statuses[i] = response.StatusCode
+	}
+	return statuses
+}
+
+// throttledCallers empties the bucket of an API key
+var throttledCallers = []rateLimitCaller{caller("key-1", ""), caller("key-1", ""), caller("key-1", "")}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("RateLimit", func() {
+	for _, tc := range rateLimitTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			responses := runRateLimited(NewInMemoryRateLimitStore(), tc.callers, tc.gap)
+
+			Expect(rateLimitStatuses(responses)).To(Equal(tc.wantStatuses))
+		})
+	}
+
+	It("tells throttled callers when to retry", func() {
+		responses := runRateLimited(NewInMemoryRateLimitStore(), throttledCallers, 0)
+
+		Expect(responses[1].Headers).To(HaveKeyWithValue(RateLimitRemainingHeader, "0"))
+		Expect(responses[2].Headers).To(HaveKeyWithValue(RetryAfterHeader, "1"))
+		Expect(responses[2].Body).To(ContainSubstring("TOO_MANY_REQUESTS"))
+	})
+
+	It("lets requests through when the store fails", func() {
+		responses := runRateLimited(failingRateLimitStore{}, throttledCallers, 0)
+
+		Expect(rateLimitStatuses(responses)).To(Equal([]int{http.StatusOK, http.StatusOK, http.StatusOK}))
+	})
+})
+{{- else }}
+
+func TestRateLimit(t *testing.T) {
+	for _, tc := range rateLimitTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			statuses := rateLimitStatuses(runRateLimited(NewInMemoryRateLimitStore(), tc.callers, tc.gap))
+{{- if eq .TestingFramework "standard" }}
+			if !reflect.DeepEqual(statuses, tc.wantStatuses) {
+				t.Errorf("statuses = %v, want %v", statuses, tc.wantStatuses)
+			}
+{{- else }}
+			assert.Equal(t, tc.wantStatuses, statuses)
+{{- end }}
+		})
+	}
+}
+
+func TestRateLimit_TellsWhenToRetry(t *testing.T) {
+	responses := runRateLimited(NewInMemoryRateLimitStore(), throttledCallers, 0)
+{{- if eq .TestingFramework "standard" }}
+	if got := responses[1].Headers[RateLimitRemainingHeader]; got != "0" {
+		t.Errorf("%s = %q, want %q", RateLimitRemainingHeader, got, "0")
+	}
+	if got := responses[2].Headers[RetryAfterHeader]; got != "1" {
+		t.Errorf("%s = %q, want %q", RetryAfterHeader, got, "1")
+	}
+{{- else }}
+	assert.Equal(t, "0", responses[1].Headers[RateLimitRemainingHeader])
+	assert.Equal(t, "1", responses[2].Headers[RetryAfterHeader])
+	assert.Contains(t, responses[2].Body, "TOO_MANY_REQUESTS")
+{{- end }}
+}
+
+func TestRateLimit_FailsOpen(t *testing.T) {
+	statuses := rateLimitStatuses(runRateLimited(failingRateLimitStore{}, throttledCallers, 0))
+	want := []int{http.StatusOK, http.StatusOK, http.StatusOK}
+{{- if eq .TestingFramework "standard" }}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+{{- else }}
+	assert.Equal(t, want, statuses)
+{{- end }}
+}
+{{- end }}
+`
+
+const TimeoutTest = `package middleware
+
+import (
+	"context"
+	"net/http"
+{{- if eq .TestingFramework "standard" }}
+	"strings"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+	apperrors "{{.Module}}/pkg/errors"
+)
+
+// invocationTimeout is how long the invocations of these tests have left;
+// Timeout gives their handlers half of it
+const invocationTimeout = 100 * time.Millisecond
+
+// invokeBeforeDeadline runs handler behind Timeout in an invocation that
+// has invocationTimeout left, and reports how long it took
+func invokeBeforeDeadline[In, Out any](handler HandlerFunc[In, Out], in In) (Out, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), invocationTimeout)
+	defer cancel()
+
+	start := time.Now()
+	out, err := Timeout[In, Out](invocationTimeout/2)(handler)(ctx, in)
+	return out, time.Since(start), err
+}
+
+// blockingHandler waits until its context is cancelled, as a handler stuck
+// on a slow downstream call does
+func blockingHandler[In, Out any](ctx context.Context, in In) (Out, error) {
+	<-ctx.Done()
+	var zero Out
+	return zero, ctx.Err()
+}
+
+func okHandler(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	return apievent.Response{StatusCode: http.StatusOK}, nil
+}
+
+// unlimitedHandler answers 200 when its context has no deadline
+func unlimitedHandler(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+	if _, ok := ctx.Deadline(); ok {
+		return apievent.Response{StatusCode: http.StatusInternalServerError}, nil
+	}
+	return apievent.Response{StatusCode: http.StatusOK}, nil
+}
+
+// runPanickingBeforeDeadline returns what Recovery makes of an API handler
+// that panics behind Timeout
+func runPanickingBeforeDeadline() apievent.Response {
+	ctx, cancel := context.WithTimeout(context.Background(), invocationTimeout)
+	defer cancel()
+
+	handler := Chain(Recovery[apievent.Request, apievent.Response](), Timeout[apievent.Request, apievent.Response](invocationTimeout/2))(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		panic("boom")
+	})
+	response, _ := handler(ctx, apievent.NewRequest(http.MethodGet, "/users", ""))
+	return response
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Timeout", func() {
+	request := apievent.NewRequest(http.MethodGet, "/users", "")
+
+	It("answers API requests 504 before the invocation deadline", func() {
+		response, elapsed, err := invokeBeforeDeadline(blockingHandler[apievent.Request, apievent.Response], request)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusGatewayTimeout))
+		Expect(response.Body).To(ContainSubstring("TIMEOUT"))
+		Expect(elapsed).To(BeNumerically("<", invocationTimeout))
+	})
+
+	It("fails batches with a timeout error", func() {
+		_, _, err := invokeBeforeDeadline(blockingHandler[events.SQSEvent, events.SQSEventResponse], events.SQSEvent{})
+
+		Expect(apperrors.IsTimeoutError(err)).To(BeTrue())
+	})
+
+	It("returns what a handler answers in time", func() {
+		response, _, err := invokeBeforeDeadline(okHandler, request)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("does not limit invocations without a deadline", func() {
+		response, _ := Timeout[apievent.Request, apievent.Response](time.Second)(unlimitedHandler)(context.Background(), request)
+
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("leaves panics to Recovery", func() {
+		Expect(runPanickingBeforeDeadline().StatusCode).To(Equal(http.StatusInternalServerError))
+	})
+})
+{{- else }}
+
+func TestTimeout_AnswersAPIRequests(t *testing.T) {
+	request := apievent.NewRequest(http.MethodGet, "/users", "")
+	response, elapsed, err := invokeBeforeDeadline(blockingHandler[apievent.Request, apievent.Response], request)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusGatewayTimeout)
+	}
+	if !strings.Contains(response.Body, "TIMEOUT") {
+		t.Errorf("body = %s, want a TIMEOUT problem", response.Body)
+	}
+	if elapsed >= invocationTimeout {
+		t.Errorf("answered after %v, want before the deadline of %v", elapsed, invocationTimeout)
+	}
+{{- else }}
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, response.StatusCode)
+	assert.Contains(t, response.Body, "TIMEOUT")
+	assert.Less(t, elapsed, invocationTimeout)
+{{- end }}
+}
+
+func TestTimeout_FailsBatches(t *testing.T) {
+	_, _, err := invokeBeforeDeadline(blockingHandler[events.SQSEvent, events.SQSEventResponse], events.SQSEvent{})
+{{- if eq .TestingFramework "standard" }}
+	if !apperrors.IsTimeoutError(err) {
+		t.Errorf("err = %v, want a timeout error", err)
+	}
+{{- else }}
+	assert.True(t, apperrors.IsTimeoutError(err), "err = %v", err)
+{{- end }}
+}
+
+func TestTimeout_PassesThrough(t *testing.T) {
+	request := apievent.NewRequest(http.MethodGet, "/users", "")
+	inTime, _, err := invokeBeforeDeadline(okHandler, request)
+	unlimited, _ := Timeout[apievent.Request, apievent.Response](time.Second)(unlimitedHandler)(context.Background(), request)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil || inTime.StatusCode != http.StatusOK {
+		t.Errorf("handler answering in time: status = %d, err = %v", inTime.StatusCode, err)
+	}
+	if unlimited.StatusCode != http.StatusOK {
+		t.Errorf("handler without deadline: status = %d, want %d", unlimited.StatusCode, http.StatusOK)
+	}
+{{- else }}
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, inTime.StatusCode)
+	assert.Equal(t, http.StatusOK, unlimited.StatusCode, "handler without deadline")
+{{- end }}
+}
+
+func TestTimeout_LeavesPanicsToRecovery(t *testing.T) {
+	response := runPanickingBeforeDeadline()
+{{- if eq .TestingFramework "standard" }}
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusInternalServerError)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+{{- end }}
+}
+{{- end }}
+`
+
+const ResponseSizeTest = `package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+
+var responseSizeTestCases = []struct {
+	name           string
+	bodySize       int
+	acceptEncoding string
+	wantStatus     int
+	wantEncoding   string
+}{
+	{
+		name:       "returns small responses as they are",
+		bodySize:   10,
+		wantStatus: http.StatusOK,
+	},
+	{
+		name:           "compresses responses for clients that accept gzip",
+		bodySize:       1500,
+		acceptEncoding: "br, gzip",
+		wantStatus:     http.StatusOK,
+		wantEncoding:   "gzip",
+	},
+	{
+		name:       "does not compress for other clients",
+		bodySize:   1500,
+		wantStatus: http.StatusOK,
+	},
+	{
+		name:           "does not compress for clients that refuse gzip",
+		bodySize:       1500,
+		acceptEncoding: "gzip;q=0",
+		wantStatus:     http.StatusOK,
+	},
+	{
+		name:           "returns responses that compress small enough",
+		bodySize:       5000,
+		acceptEncoding: "gzip",
+		wantStatus:     http.StatusOK,
+		wantEncoding:   "gzip",
+	},
+	{
+		name:       "offloads responses too large to return",
+		bodySize:   5000,
+		wantStatus: http.StatusSeeOther,
+	},
+}
+
+// recordingOffloader is a ResponseOffloader that keeps the last body it
+// was given, or fails with err
+type recordingOffloader struct {
+	contentType string
+	body        []byte
+	err         error
+}
+
+func (o *recordingOffloader) Offload(ctx context.Context, key, contentType string, body []byte) (string, error) {
+	if o.err != nil {
+		return "", o.err
+	}
+	o.contentType, o.body = contentType, body
+	return "https://responses.example.com/" + key, nil
+}
+
+// runSized answers a request with a body of bodySize bytes through
+// ResponseSize, which compresses responses from 500 bytes and offloads
+// those above 2000
+func runSized(offloader ResponseOffloader, bodySize int, acceptEncoding string) apievent.Response {
+	handler := ResponseSize(ResponseSizeConfig{
+		GzipMinSize: 500,
+		OffloadSize: 2000,
+		Offloader:   offloader,
+	})(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		body := strings.Repeat("a", bodySize)
+		return apievent.NewResponse(http.StatusOK, map[string]string{
+			"Content-Type":                "text/plain",
+			"Access-Control-Allow-Origin": "*",
+		}, body), nil
+	})
+
+	request := apievent.NewRequest(http.MethodGet, "/users", "")
+	if acceptEncoding != "" {
+		request.Headers["accept-encoding"] = acceptEncoding
+	}
+	response, _ := handler(context.Background(), request)
+	return response
+}
+
+// gunzip returns the body of a compressed response
+func gunzip(response apievent.Response) string {
+	compressed, err := base64.StdEncoding.DecodeString(response.Body)
+	if err != nil {
+		return ""
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return ""
+	}
+	body, _ := io.ReadAll(reader)
+	return string(body)
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("ResponseSize", func() {
+	for _, tc := range responseSizeTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			response := runSized(&recordingOffloader{}, tc.bodySize, tc.acceptEncoding)
+
+			Expect(response.StatusCode).To(Equal(tc.wantStatus))
+			Expect(response.Headers["Content-Encoding"]).To(Equal(tc.wantEncoding))
+		})
+	}
+
+	It("compresses without losing the body", func() {
+		response := runSized(&recordingOffloader{}, 1500, "gzip")
+
+		Expect(gunzip(response)).To(Equal(strings.Repeat("a", 1500)))
+	})
+
+	It("redirects to the offloaded body", func() {
+		offloader := &recordingOffloader{}
+		response := runSized(offloader, 5000, "")
+
+		Expect(response.Headers["Location"]).To(HavePrefix("https://responses.example.com/responses/"))
+		Expect(response.Headers["Access-Control-Allow-Origin"]).To(Equal("*"))
+		Expect(response.Body).To(BeEmpty())
+		Expect(offloader.contentType).To(Equal("text/plain"))
+		Expect(string(offloader.body)).To(Equal(strings.Repeat("a", 5000)))
+	})
+
+	It("answers 500 when a response cannot be offloaded", func() {
+		Expect(runSized(nil, 5000, "").StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(runSized(&recordingOffloader{err: errors.New("bucket unavailable")}, 5000, "").StatusCode).To(Equal(http.StatusInternalServerError))
+	})
+})
+{{- else }}
+
+func TestResponseSize(t *testing.T) {
+	for _, tc := range responseSizeTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := runSized(&recordingOffloader{}, tc.bodySize, tc.acceptEncoding)
+{{- if eq .TestingFramework "standard" }}
+			if response.StatusCode != tc.wantStatus {
+				t.Errorf("status = %d, want %d", response.StatusCode, tc.wantStatus)
+			}
+			if got := response.Headers["Content-Encoding"]; got != tc.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tc.wantEncoding)
+			}
+{{- else }}
+			assert.Equal(t, tc.wantStatus, response.StatusCode)
+			assert.Equal(t, tc.wantEncoding, response.Headers["Content-Encoding"])
+{{- end }}
+		})
+	}
+}
+
+func TestResponseSize_CompressesWithoutLoss(t *testing.T) {
+	response := runSized(&recordingOffloader{}, 1500, "gzip")
+	want := strings.Repeat("a", 1500)
+{{- if eq .TestingFramework "standard" }}
+	if got := gunzip(response); got != want {
+		t.Errorf("decompressed body has %d bytes, want %d", len(got), len(want))
+	}
+{{- else }}
+	assert.Equal(t, want, gunzip(response))
+{{- end }}
+}
+
+func TestResponseSize_RedirectsToOffloadedBody(t *testing.T) {
+	offloader := &recordingOffloader{}
+	response := runSized(offloader, 5000, "")
+{{- if eq .TestingFramework "standard" }}
+	if location := response.Headers["Location"]; !strings.HasPrefix(location, "https://responses.example.com/responses/") {
+		t.Errorf("Location = %q, want the offloaded body's URL", location)
+	}
+	if got := response.Headers["Access-Control-Allow-Origin"]; got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the response's %q", got, "*")
+	}
+	if response.Body != "" {
+		t.Errorf("body has %d bytes, want none", len(response.Body))
+	}
+	if offloader.contentType != "text/plain" {
+		t.Errorf("content type = %q, want %q", offloader.contentType, "text/plain")
+	}
+	if string(offloader.body) != strings.Repeat("a", 5000) {
+		t.Errorf("offloaded %d bytes, want the 5000 of the response", len(offloader.body))
+	}
+{{- else }}
+	assert.True(t, strings.HasPrefix(response.Headers["Location"], "https://responses.example.com/responses/"), "Location = %q", response.Headers["Location"])
+	assert.Equal(t, "*", response.Headers["Access-Control-Allow-Origin"])
+	assert.Empty(t, response.Body)
+	assert.Equal(t, "text/plain", offloader.contentType)
+	assert.Equal(t, strings.Repeat("a", 5000), string(offloader.body))
+{{- end }}
+}
+
+func TestResponseSize_FailsWithoutOffload(t *testing.T) {
+	withoutOffloader := runSized(nil, 5000, "")
+	failingOffloader := runSized(&recordingOffloader{err: errors.New("bucket unavailable")}, 5000, "")
+{{- if eq .TestingFramework "standard" }}
+	if withoutOffloader.StatusCode != http.StatusInternalServerError {
+		t.Errorf("without offloader: status = %d, want %d", withoutOffloader.StatusCode, http.StatusInternalServerError)
+	}
+	if failingOffloader.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failing offloader: status = %d, want %d", failingOffloader.StatusCode, http.StatusInternalServerError)
+	}
+{{- else }}
+	assert.Equal(t, http.StatusInternalServerError, withoutOffloader.StatusCode, "without offloader")
+	assert.Equal(t, http.StatusInternalServerError, failingOffloader.StatusCode, "failing offloader")
+{{- end }}
+}
+{{- end }}
+`
+
+const CleanRepositoryTest = `package database
+
+import (
+	"context"
+{{- if ne .TestingFramework "testify" }}
+	"errors"
+{{- end }}
+	"os"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/internal/domain/entities"
+	"{{.Module}}/internal/domain/repositories"
+	"{{.Module}}/internal/infrastructure/config"
+)
+
+// These tests run against DynamoDB Local (make local-up)
+// and are skipped when DYNAMODB_ENDPOINT is not set.
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		AWSRegion:           "us-east-1",
+		DynamoDBTablePrefix: "{{.Name}}_",
+		DynamoDBEndpoint:    os.Getenv("DYNAMODB_ENDPOINT"),
+	}
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("DynamoDB UserRepository", func() {
+	var repo repositories.UserRepository
+
+	BeforeEach(func() {
+		cfg := newTestConfig()
+		if cfg.DynamoDBEndpoint == "" {
+			Skip("DYNAMODB_ENDPOINT not set")
+		}
+
+		client, err := NewDynamoDBClient(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewDynamoDBUserRepository(client)
+	})
+
+	It("creates and reads back a user", func() {
+		ctx := context.Background()
+		user := entities.NewUser("jane@example.com", "Jane")
+
+		Expect(repo.Create(ctx, user)).To(Succeed())
+
+		found, err := repo.GetByID(ctx, user.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.Email).To(Equal(user.Email))
+		Expect(found.Version).To(Equal(user.Version))
+	})
+
+	It("returns ErrUserNotFound for unknown IDs", func() {
+		_, err := repo.GetByID(context.Background(), "does-not-exist")
+
+		Expect(err).To(MatchError(repositories.ErrUserNotFound))
+	})
+
+	It("rejects updates from a stale version", func() {
+		ctx := context.Background()
+		user := entities.NewUser("stale@example.com", "Stale")
+		Expect(repo.Create(ctx, user)).To(Succeed())
+
+		stale := *user
+		Expect(repo.Update(ctx, user)).To(Succeed())
+
+		var conflict *repositories.ConflictError
+		Expect(errors.As(repo.Update(ctx, &stale), &conflict)).To(BeTrue())
+	})
+})
+{{- else }}
+
+func newTestRepository(t *testing.T) repositories.UserRepository {
+	t.Helper()
+
+	cfg := newTestConfig()
+	if cfg.DynamoDBEndpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT not set")
+	}
+
+	client, err := NewDynamoDBClient(cfg)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("NewDynamoDBClient() error = %v", err)
+	}
+{{- else }}
+	require.NoError(t, err)
+{{- end }}
+
+	return NewDynamoDBUserRepository(client)
+}
+
+func TestUserRepository_CreateAndGetByID(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	user := entities.NewUser("jane@example.com", "Jane")
+{{- if eq .TestingFramework "standard" }}
+
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	found, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if found.Email != user.Email {
+		t.Errorf("Email = %q, want %q", found.Email, user.Email)
+	}
+	if found.Version != user.Version {
+		t.Errorf("Version = %d, want %d", found.Version, user.Version)
+	}
+{{- else }}
+
+	require.NoError(t, repo.Create(ctx, user))
+
+	found, err := repo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.Email, found.Email)
+	assert.Equal(t, user.Version, found.Version)
+{{- end }}
+}
+
+func TestUserRepository_GetByIDNotFound(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.GetByID(context.Background(), "does-not-exist")
+{{- if eq .TestingFramework "standard" }}
+	if err != repositories.ErrUserNotFound {
+		t.Errorf("GetByID() error = %v, want %v", err, repositories.ErrUserNotFound)
+	}
+{{- else }}
+	assert.ErrorIs(t, err, repositories.ErrUserNotFound)
+{{- end }}
+}
+
+func TestUserRepository_UpdateStaleVersion(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	user := entities.NewUser("stale@example.com", "Stale")
+{{- if eq .TestingFramework "standard" }}
+
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	stale := *user
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	var conflict *repositories.ConflictError
+	if err := repo.Update(ctx, &stale); !errors.As(err, &conflict) {
+		t.Errorf("Update() with stale version error = %v, want *repositories.ConflictError", err)
+	}
+{{- else }}
+
+	require.NoError(t, repo.Create(ctx, user))
+
+	stale := *user
+	require.NoError(t, repo.Update(ctx, user))
+
+	var conflict *repositories.ConflictError
+	assert.ErrorAs(t, repo.Update(ctx, &stale), &conflict)
+{{- end }}
+}
+{{- end }}
+`
+
+// Simple structure tests
+
+const SimpleHandlerTest = `package handlers
+
+import (
+	"context"
+	"net/http"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/pkg/apievent"
+)
+
+// These cases are rejected before the service touches DynamoDB.
+var handlerTestCases = []struct {
+	name           string
+	request        apievent.Request
+	expectedStatus int
+}{
+	{
+		name:           "rejects an invalid body",
+		request:        apievent.NewRequest(http.MethodPost, "/users", "invalid json"),
+		expectedStatus: http.StatusBadRequest,
+	},
+	{
+		name:           "rejects a user without an email",
+		request:        apievent.NewRequest(http.MethodPost, "/users", ` + "`" + `{"name":"Jane"}` + "`" + `),
+		expectedStatus: http.StatusBadRequest,
+	},
+	{
+		name:           "routes a trailing slash like the collection",
+		request:        apievent.NewRequest(http.MethodPost, "/users/", "invalid json"),
+		expectedStatus: http.StatusBadRequest,
+	},
+	{
+		name:           "does not route paths below a user",
+		request:        apievent.NewRequest(http.MethodGet, "/users/123/orders", ""),
+		expectedStatus: http.StatusNotFound,
+	},
+	{
+		name:           "returns 404 for unknown routes",
+		request:        apievent.NewRequest(http.MethodGet, "/unknown", ""),
+		expectedStatus: http.StatusNotFound,
+	},
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Handler", func() {
+	for _, tc := range handlerTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			response, err := Handler(context.Background(), tc.request)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(tc.expectedStatus))
+		})
+	}
+})
+{{- else }}
+
+func TestMainHandler(t *testing.T) {
+	for _, tc := range handlerTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := Handler(context.Background(), tc.request)
+{{- if eq .TestingFramework "standard" }}
+			if err != nil {
+				t.Fatalf("Handler() error = %v", err)
+			}
+			if response.StatusCode != tc.expectedStatus {
+				t.Errorf("StatusCode = %d, want %d", response.StatusCode, tc.expectedStatus)
+			}
+{{- else }}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, response.StatusCode)
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
+
+const SimpleSQSHandlerTest = `package handlers
+
+import (
+	"context"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/config"
+	"{{.Module}}/services"
+)
+
+const (
+	validUserCreatedBody   = ` + "`" + `{"type":"user.created","payload":{"user_id":"user-1"}}` + "`" + `
+	invalidUserCreatedBody = ` + "`" + `{"type":"user.created","payload":"not an object"}` + "`" + `
+)
+
+// The service only decodes payloads here, so no SQS client is needed.
+var sqsHandlerTestCases = []struct {
+	name         string
+	records      []events.SQSMessage
+	wantFailures []string
+}{
+	{
+		name: "processes the whole batch",
+		records: []events.SQSMessage{
+			{MessageId: "message-1", Body: validUserCreatedBody},
+			{MessageId: "message-2", Body: validUserCreatedBody},
+		},
+	},
+	{
+		name: "reports only the failed messages in a mixed batch",
+		records: []events.SQSMessage{
+			{MessageId: "message-1", Body: validUserCreatedBody},
+			{MessageId: "message-2", Body: invalidUserCreatedBody},
+			{MessageId: "message-3", Body: validUserCreatedBody},
+		},
+		wantFailures: []string{"message-2"},
+	},
+	{
+		name: "drops malformed messages",
+		records: []events.SQSMessage{
+			{MessageId: "message-1", Body: "not json"},
+		},
+	},
+}
+
+func runSQSBatch(records []events.SQSMessage) []string {
+	svc := services.NewSQSServiceWithClient(&config.Config{}, nil)
+	response := processBatch(context.Background(), svc, events.SQSEvent{Records: records})
+
+	var ids []string
+	for _, failure := range response.BatchItemFailures {
+		ids = append(ids, failure.ItemIdentifier)
+	}
+	return ids
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("SQSHandler", func() {
+	for _, tc := range sqsHandlerTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			Expect(runSQSBatch(tc.records)).To(Equal(tc.wantFailures))
+		})
+	}
+})
+{{- else }}
+
+func TestSQSHandler_ProcessBatch(t *testing.T) {
+	for _, tc := range sqsHandlerTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := runSQSBatch(tc.records)
+{{- if eq .TestingFramework "standard" }}
+			if !reflect.DeepEqual(got, tc.wantFailures) {
+				t.Errorf("BatchItemFailures = %v, want %v", got, tc.wantFailures)
+			}
+{{- else }}
+			assert.Equal(t, tc.wantFailures, got)
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
+
+const SimpleServiceTest = `package services
+
+import (
+	"context"
+	"errors"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/config"
+	"{{.Module}}/models"
+	"{{.Module}}/test/fakes"
+)
+
+var errThrottled = errors.New("throttled")
+
+func newTestService() (*Service, *fakes.DynamoDB) {
+	db := fakes.NewDynamoDB()
+	cfg := &config.Config{DynamoDBTableName: "{{.Name}}-{{.DataModel.Table.Name}}"}
+
+	return NewServiceWithClient(cfg, db), db
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Service", func() {
+	var (
+		svc *Service
+		db  *fakes.DynamoDB
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		svc, db = newTestService()
+		ctx = context.Background()
+	})
+
+	It("creates and reads back a user", func() {
+		user, err := svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+		Expect(err).NotTo(HaveOccurred())
+
+		found, err := svc.GetUser(ctx, user.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.Email).To(Equal("jane@example.com"))
+	})
+
+	It("rejects a duplicate email", func() {
+		_, err := svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+		Expect(err).To(MatchError(models.ErrUserAlreadyExists))
+		Expect(db.CallCount("PutItem")).To(Equal(1))
+	})
+
+	It("returns ErrUserNotFound for unknown IDs", func() {
+		_, err := svc.GetUser(ctx, "does-not-exist")
+
+		Expect(err).To(MatchError(models.ErrUserNotFound))
+	})
+
+	It("propagates DynamoDB errors", func() {
+		db.FailNext("PutItem", errThrottled)
+
+		_, err := svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+		Expect(errors.Is(err, errThrottled)).To(BeTrue())
+	})
+
+	It("rejects an update that lost a race with a concurrent writer", func() {
+		user, err := svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Another request updates the user between our read and our write
+		db.BeforeNext("PutItem", func() {
+			name := "Janet"
+			_, err := svc.UpdateUser(ctx, user.ID, models.UpdateUserInput{Name: &name})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		name := "Jane Doe"
+		_, err = svc.UpdateUser(ctx, user.ID, models.UpdateUserInput{Name: &name})
+		Expect(err).To(MatchError(models.ErrVersionConflict))
+
+		found, err := svc.GetUser(ctx, user.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.Name).To(Equal("Janet"))
+		Expect(found.Version).To(Equal(2))
+	})
+
+	It("lists users in creation order", func() {
+		for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+			_, err := svc.CreateUser(ctx, models.CreateUserInput{Email: email, Name: "User"})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		first, err := svc.ListUsers(ctx, models.ListParams{Limit: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Users).To(HaveLen(2))
+		Expect(first.NextCursor).NotTo(BeEmpty())
+
+		second, err := svc.ListUsers(ctx, models.ListParams{Cursor: first.NextCursor, Limit: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Users).To(HaveLen(1))
+		Expect(second.Users[0].Email).To(Equal("c@example.com"))
+		Expect(second.NextCursor).To(BeEmpty())
+	})
+
+	It("rejects an invalid cursor", func() {
+		_, err := svc.ListUsers(ctx, models.ListParams{Cursor: "not-a-cursor", Limit: 2})
+
+		Expect(err).To(MatchError(models.ErrInvalidCursor))
+	})
+})
+{{- else }}
+
+func TestService_CreateAndGetUser(t *testing.T) {
+	svc, _ := newTestService()
+	ctx := context.Background()
+
+	user, err := svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	found, err := svc.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if found.Email != "jane@example.com" {
+		t.Errorf("Email = %q, want %q", found.Email, "jane@example.com")
+	}
+{{- else }}
+	require.NoError(t, err)
+
+	found, err := svc.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", found.Email)
+{{- end }}
+}
+
+func TestService_CreateUserDuplicateEmail(t *testing.T) {
+	svc, db := newTestService()
+	ctx := context.Background()
+	input := models.CreateUserInput{Email: "jane@example.com", Name: "Jane"}
+
+	_, err := svc.CreateUser(ctx, input)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	_, err = svc.CreateUser(ctx, input)
+	if !errors.Is(err, models.ErrUserAlreadyExists) {
+		t.Errorf("CreateUser() error = %v, want %v", err, models.ErrUserAlreadyExists)
+	}
+	if got := db.CallCount("PutItem"); got != 1 {
+		t.Errorf("PutItem calls = %d, want 1", got)
+	}
+{{- else }}
+	require.NoError(t, err)
+
+	_, err = svc.CreateUser(ctx, input)
+	assert.ErrorIs(t, err, models.ErrUserAlreadyExists)
+	assert.Equal(t, 1, db.CallCount("PutItem"))
+{{- end }}
+}
+
+func TestService_GetUserNotFound(t *testing.T) {
+	svc, _ := newTestService()
+
+	_, err := svc.GetUser(context.Background(), "does-not-exist")
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(err, models.ErrUserNotFound) {
+		t.Errorf("GetUser() error = %v, want %v", err, models.ErrUserNotFound)
+	}
+{{- else }}
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+{{- end }}
+}
+
+func TestService_CreateUserStoreError(t *testing.T) {
+	svc, db := newTestService()
+	db.FailNext("PutItem", errThrottled)
+
+	_, err := svc.CreateUser(context.Background(), models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(err, errThrottled) {
+		t.Errorf("CreateUser() error = %v, want %v", err, errThrottled)
+	}
+{{- else }}
+	assert.ErrorIs(t, err, errThrottled)
+{{- end }}
+}
+
+func TestService_UpdateUserConcurrentWrite(t *testing.T) {
+	svc, db := newTestService()
+	ctx := context.Background()
+
+	user, err := svc.CreateUser(ctx, models.CreateUserInput{Email: "jane@example.com", Name: "Jane"})
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+{{- else }}
+	require.NoError(t, err)
+{{- end }}
+
+	// Another request updates the user between our read and our write
+	db.BeforeNext("PutItem", func() {
+		name := "Janet"
+		if _, err := svc.UpdateUser(ctx, user.ID, models.UpdateUserInput{Name: &name}); err != nil {
+			t.Errorf("concurrent UpdateUser() error = %v", err)
+		}
+	})
+
+	name := "Jane Doe"
+	_, err = svc.UpdateUser(ctx, user.ID, models.UpdateUserInput{Name: &name})
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(err, models.ErrVersionConflict) {
+		t.Errorf("UpdateUser() error = %v, want %v", err, models.ErrVersionConflict)
+	}
+
+	found, err := svc.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if found.Name != "Janet" || found.Version != 2 {
+		t.Errorf("stored user = %q at version %d, want %q at version 2", found.Name, found.Version, "Janet")
+	}
+{{- else }}
+	assert.ErrorIs(t, err, models.ErrVersionConflict)
+
+	found, err := svc.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Janet", found.Name)
+	assert.Equal(t, 2, found.Version)
+{{- end }}
+}
+
+func TestService_ListUsers(t *testing.T) {
+	svc, _ := newTestService()
+	ctx := context.Background()
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		_, err := svc.CreateUser(ctx, models.CreateUserInput{Email: email, Name: "User"})
+{{- if eq .TestingFramework "standard" }}
+		if err != nil {
+			t.Fatalf("CreateUser() error = %v", err)
+		}
+{{- else }}
+		require.NoError(t, err)
+{{- end }}
+	}
+
+	first, err := svc.ListUsers(ctx, models.ListParams{Limit: 2})
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if len(first.Users) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page = %d users, cursor %q; want 2 users and a cursor", len(first.Users), first.NextCursor)
+	}
+{{- else }}
+	require.NoError(t, err)
+	require.Len(t, first.Users, 2)
+	require.NotEmpty(t, first.NextCursor)
+{{- end }}
+
+	second, err := svc.ListUsers(ctx, models.ListParams{Cursor: first.NextCursor, Limit: 2})
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if len(second.Users) != 1 || second.Users[0].Email != "c@example.com" {
+		t.Errorf("Users = %v, want only c@example.com", second.Users)
+	}
+	if second.NextCursor != "" {
+		t.Errorf("NextCursor = %q, want empty on the last page", second.NextCursor)
+	}
+{{- else }}
+	require.NoError(t, err)
+	require.Len(t, second.Users, 1)
+	assert.Equal(t, "c@example.com", second.Users[0].Email)
+	assert.Empty(t, second.NextCursor)
+{{- end }}
+}
+
+func TestService_ListUsersInvalidCursor(t *testing.T) {
+	svc, _ := newTestService()
+
+	_, err := svc.ListUsers(context.Background(), models.ListParams{Cursor: "not-a-cursor", Limit: 2})
+{{- if eq .TestingFramework "standard" }}
+	if !errors.Is(err, models.ErrInvalidCursor) {
+		t.Errorf("ListUsers() error = %v, want %v", err, models.ErrInvalidCursor)
+	}
+{{- else }}
+	assert.ErrorIs(t, err, models.ErrInvalidCursor)
+{{- end }}
+}
+{{- end }}
+`
+
+const SimpleFakeDynamoDBTest = `package fakes
+
+import (
+	"context"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+const testTable = "fakes-test"
+
+// newScanFixture stores the items a, b and c in testTable
+func newScanFixture() *DynamoDB {
+	db := NewDynamoDB()
+	for _, id := range []string{"a", "b", "c"} {
+		item := map[string]types.AttributeValue{db.key.partition: &types.AttributeValueMemberS{Value: id}}
+		if db.key.sort != "" {
+			item[db.key.sort] = &types.AttributeValueMemberS{Value: id}
+		}
+		db.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String(testTable), Item: item})
+	}
+	return db
+}
+
+// scanPage scans testTable for up to two items after startKey and returns
+// the partition keys of the items it got
+func scanPage(db *DynamoDB, startKey map[string]types.AttributeValue) ([]string, map[string]types.AttributeValue, error) {
+	out, err := db.Scan(context.Background(), &dynamodb.ScanInput{
+		TableName:         aws.String(testTable),
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(2),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(out.Items))
+	for _, item := range out.Items {
+		ids = append(ids, item[db.key.partition].(*types.AttributeValueMemberS).Value)
+	}
+	return ids, out.LastEvaluatedKey, nil
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("DynamoDB", func() {
+	It("pages a scan with Limit and ExclusiveStartKey", func() {
+		db := newScanFixture()
+
+		first, lastKey, err := scanPage(db, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first).To(Equal([]string{"a", "b"}))
+		Expect(lastKey).NotTo(BeEmpty())
+
+		second, lastKey, err := scanPage(db, lastKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(Equal([]string{"c"}))
+		Expect(lastKey).To(BeNil())
+	})
+})
+{{- else }}
+
+func TestDynamoDB_ScanPages(t *testing.T) {
+	db := newScanFixture()
+
+	first, lastKey, err := scanPage(db, nil)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(first) != 2 || first[0] != "a" || first[1] != "b" || len(lastKey) == 0 {
+		t.Fatalf("first page = %v with LastEvaluatedKey %v, want [a b] and a key", first, lastKey)
+	}
+
+	second, lastKey, err := scanPage(db, lastKey)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(second) != 1 || second[0] != "c" {
+		t.Errorf("second page = %v, want [c]", second)
+	}
+	if lastKey != nil {
+		t.Errorf("LastEvaluatedKey = %v, want nil on the last page", lastKey)
+	}
+{{- else }}
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, first)
+	require.NotEmpty(t, lastKey)
+
+	second, lastKey, err := scanPage(db, lastKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, second)
+	assert.Nil(t, lastKey)
+{{- end }}
+}
+{{- end }}
+`
+
+// DDD tests
+
+const DDDAggregateTest = `package aggregate
+
+import (
+{{- if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- else }}
+	"testing"
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- end }}
+{{- end }}
+)
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("User", func() {
+	It("records a creation event", func() {
+		user, err := NewUser("jane@example.com", "Jane")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(user.IsActive()).To(BeTrue())
+		Expect(user.Version).To(Equal(1))
+		Expect(user.GetUncommittedEvents()).To(HaveLen(1))
+	})
+
+	It("rejects an empty email", func() {
+		_, err := NewUser("", "Jane")
+
+		Expect(err).To(MatchError(ErrInvalidEmail))
+	})
+
+	It("cannot be deleted twice", func() {
+		user, err := NewUser("jane@example.com", "Jane")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(user.Delete()).To(Succeed())
+		Expect(user.Delete()).To(MatchError(ErrUserAlreadyDeleted))
+	})
+
+	It("clears events once committed", func() {
+		user, err := NewUser("jane@example.com", "Jane")
+		Expect(err).NotTo(HaveOccurred())
+
+		user.MarkEventsAsCommitted()
+
+		Expect(user.GetUncommittedEvents()).To(BeEmpty())
+	})
+
+	It("rebuilds its state from its events", func() {
+		user, err := NewUser("jane@example.com", "Jane")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(user.UpdateProfile("Janet")).To(Succeed())
+		Expect(user.ChangeStatus(UserStatusBlocked)).To(Succeed())
+
+		replayed := &User{}
+		Expect(replayed.LoadFromHistory(user.GetUncommittedEvents())).To(Succeed())
+
+		Expect(replayed.ID).To(Equal(user.ID))
+		Expect(replayed.Name).To(Equal("Janet"))
+		Expect(replayed.Status).To(Equal(UserStatusBlocked))
+		Expect(replayed.Version).To(Equal(3))
+		Expect(replayed.GetUncommittedEvents()).To(BeEmpty())
+	})
+})
+{{- else if eq .TestingFramework "standard" }}
+
+func TestNewUser(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	if err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+
+	if !user.IsActive() {
+		t.Error("IsActive() = false, want true")
+	}
+	if user.Version != 1 {
+		t.Errorf("Version = %d, want 1", user.Version)
+	}
+	if got := len(user.GetUncommittedEvents()); got != 1 {
+		t.Errorf("len(GetUncommittedEvents()) = %d, want 1", got)
+	}
+}
+
+func TestNewUserInvalidEmail(t *testing.T) {
+	if _, err := NewUser("", "Jane"); err != ErrInvalidEmail {
+		t.Errorf("NewUser() error = %v, want %v", err, ErrInvalidEmail)
+	}
+}
+
+func TestUserDeleteTwice(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	if err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+
+	if err := user.Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := user.Delete(); err != ErrUserAlreadyDeleted {
+		t.Errorf("second Delete() error = %v, want %v", err, ErrUserAlreadyDeleted)
+	}
+}
+
+func TestMarkEventsAsCommitted(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	if err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+
+	user.MarkEventsAsCommitted()
+
+	if got := len(user.GetUncommittedEvents()); got != 0 {
+		t.Errorf("len(GetUncommittedEvents()) = %d, want 0", got)
+	}
+}
+
+func TestLoadFromHistory(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	if err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+	if err := user.UpdateProfile("Janet"); err != nil {
+		t.Fatalf("UpdateProfile() error = %v", err)
+	}
+	if err := user.ChangeStatus(UserStatusBlocked); err != nil {
+		t.Fatalf("ChangeStatus() error = %v", err)
+	}
+
+	replayed := &User{}
+	if err := replayed.LoadFromHistory(user.GetUncommittedEvents()); err != nil {
+		t.Fatalf("LoadFromHistory() error = %v", err)
+	}
+
+	if replayed.ID != user.ID {
+		t.Errorf("ID = %q, want %q", replayed.ID, user.ID)
+	}
+	if replayed.Name != "Janet" {
+		t.Errorf("Name = %q, want %q", replayed.Name, "Janet")
+	}
+	if replayed.Status != UserStatusBlocked {
+		t.Errorf("Status = %q, want %q", replayed.Status, UserStatusBlocked)
+	}
+	if replayed.Version != 3 {
+		t.Errorf("Version = %d, want 3", replayed.Version)
+	}
+	if got := len(replayed.GetUncommittedEvents()); got != 0 {
+		t.Errorf("len(GetUncommittedEvents()) = %d, want 0", got)
+	}
+}
+{{- else }}
+
+func TestNewUser(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	require.NoError(t, err)
+
+	assert.True(t, user.IsActive())
+	assert.Equal(t, 1, user.Version)
+	assert.Len(t, user.GetUncommittedEvents(), 1)
+}
+
+func TestNewUserInvalidEmail(t *testing.T) {
+	_, err := NewUser("", "Jane")
+
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+}
+
+func TestUserDeleteTwice(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	require.NoError(t, err)
+
+	require.NoError(t, user.Delete())
+	assert.ErrorIs(t, user.Delete(), ErrUserAlreadyDeleted)
+}
+
+func TestMarkEventsAsCommitted(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	require.NoError(t, err)
+
+	user.MarkEventsAsCommitted()
+
+	assert.Empty(t, user.GetUncommittedEvents())
+}
+
+func TestLoadFromHistory(t *testing.T) {
+	user, err := NewUser("jane@example.com", "Jane")
+	require.NoError(t, err)
+	require.NoError(t, user.UpdateProfile("Janet"))
+	require.NoError(t, user.ChangeStatus(UserStatusBlocked))
+
+	replayed := &User{}
+	require.NoError(t, replayed.LoadFromHistory(user.GetUncommittedEvents()))
+
+	assert.Equal(t, user.ID, replayed.ID)
+	assert.Equal(t, "Janet", replayed.Name)
+	assert.Equal(t, UserStatusBlocked, replayed.Status)
+	assert.Equal(t, 3, replayed.Version)
+	assert.Empty(t, replayed.GetUncommittedEvents())
+}
+{{- end }}
+`
+
+const DDDCommandTest = `package command
+
+import (
+	"context"
+	"errors"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+{{- if eq .TestingFramework "testify" }}
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/test/fakes"
+)
+
+var errStoreUnavailable = errors.New("store unavailable")
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("CreateUserHandler", func() {
+	var (
+		repo    *fakes.UserRepository
+		handler *CreateUserHandler
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		repo = fakes.NewUserRepository()
+		handler = NewCreateUserHandler(repo)
+		ctx = context.Background()
+	})
+
+	It("saves the user and writes its events to the outbox", func() {
+		err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Count(ctx)).To(BeEquivalentTo(1))
+		Expect(repo.Outbox()).To(HaveLen(1))
+	})
+
+	It("rejects a duplicate email", func() {
+		Expect(handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane"))).To(Succeed())
+
+		err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane Again"))
+
+		Expect(err).To(HaveOccurred())
+		Expect(repo.CallCount("Save")).To(Equal(1))
+	})
+
+	It("does not write events when saving fails", func() {
+		repo.FailNext("Save", errStoreUnavailable)
+
+		err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane"))
+
+		Expect(err).To(MatchError(errStoreUnavailable))
+		Expect(repo.Outbox()).To(BeEmpty())
+	})
+})
+{{- else if eq .TestingFramework "standard" }}
+
+func TestCreateUserHandler(t *testing.T) {
+	repo := fakes.NewUserRepository()
+	handler := NewCreateUserHandler(repo)
+	ctx := context.Background()
+
+	if err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane")); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if count, _ := repo.Count(ctx); count != 1 {
+		t.Errorf("saved users = %d, want 1", count)
+	}
+	if got := len(repo.Outbox()); got != 1 {
+		t.Errorf("outbox events = %d, want 1", got)
+	}
+}
+
+func TestCreateUserHandlerDuplicateEmail(t *testing.T) {
+	repo := fakes.NewUserRepository()
+	handler := NewCreateUserHandler(repo)
+	ctx := context.Background()
+
+	if err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane")); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane Again")); err == nil {
+		t.Error("Handle() with duplicate email succeeded, want error")
+	}
+	if got := repo.CallCount("Save"); got != 1 {
+		t.Errorf("Save calls = %d, want 1", got)
+	}
+}
+
+func TestCreateUserHandlerSaveError(t *testing.T) {
+	repo := fakes.NewUserRepository()
+	handler := NewCreateUserHandler(repo)
+	repo.FailNext("Save", errStoreUnavailable)
+
+	err := handler.Handle(context.Background(), NewCreateUserCommand("jane@example.com", "Jane"))
+
+	if !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("Handle() error = %v, want %v", err, errStoreUnavailable)
+	}
+	if got := len(repo.Outbox()); got != 0 {
+		t.Errorf("outbox events = %d, want 0", got)
+	}
+}
+{{- else }}
+
+func TestCreateUserHandler(t *testing.T) {
+	repo := fakes.NewUserRepository()
+	handler := NewCreateUserHandler(repo)
+	ctx := context.Background()
+
+	err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane"))
+
+	require.NoError(t, err)
+	count, err := repo.Count(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	assert.Len(t, repo.Outbox(), 1)
+}
+
+func TestCreateUserHandlerDuplicateEmail(t *testing.T) {
+	repo := fakes.NewUserRepository()
+	handler := NewCreateUserHandler(repo)
+	ctx := context.Background()
+
+	require.NoError(t, handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane")))
+
+	err := handler.Handle(ctx, NewCreateUserCommand("jane@example.com", "Jane Again"))
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, repo.CallCount("Save"))
+}
+
+func TestCreateUserHandlerSaveError(t *testing.T) {
+	repo := fakes.NewUserRepository()
+	handler := NewCreateUserHandler(repo)
+	repo.FailNext("Save", errStoreUnavailable)
+
+	err := handler.Handle(context.Background(), NewCreateUserCommand("jane@example.com", "Jane"))
+
+	assert.ErrorIs(t, err, errStoreUnavailable)
+	assert.Empty(t, repo.Outbox())
+}
+{{- end }}
+`
+
+const DDDPersistenceTest = `package persistence
+
+import (
+	"context"
+{{- if ne .TestingFramework "testify" }}
+	"errors"
+{{- end }}
+	"os"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/domain/aggregate"
+	"{{.Module}}/domain/repository"
+)
+
+// These tests run against DynamoDB Local (make local-up)
+// and are skipped when DYNAMODB_ENDPOINT is not set.
+
+func newTestClient(ctx context.Context, endpoint string) (*dynamodb.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	}), nil
+}
+
+// saveConcurrently stores a user, then saves two copies loaded at the same
+// version, and returns the errors of both saves
+func saveConcurrently(ctx context.Context, repo *DynamoDBUserRepository) (first, second, err error) {
+	user, err := aggregate.NewUser("concurrent@example.com", "Jane")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := repo.Save(ctx, user); err != nil {
+		return nil, nil, err
+	}
+
+	a, err := repo.FindByID(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := repo.FindByID(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := a.UpdateProfile("Jane A"); err != nil {
+		return nil, nil, err
+	}
+	if err := b.UpdateProfile("Jane B"); err != nil {
+		return nil, nil, err
+	}
+
+	return repo.Save(ctx, a), repo.Save(ctx, b), nil
+}
+
+func testTableName() string {
+	if name := os.Getenv("DYNAMODB_TABLE_NAME"); name != "" {
+		return name
+	}
+	return "{{.Name}}-{{.DataModel.Table.Name}}"
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("DynamoDBUserRepository", func() {
+	var repo *DynamoDBUserRepository
+
+	BeforeEach(func() {
+		endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+		if endpoint == "" {
+			Skip("DYNAMODB_ENDPOINT not set")
+		}
+
+		client, err := newTestClient(context.Background(), endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		repo = NewDynamoDBUserRepository(client, testTableName())
+	})
+
+	It("saves and reads back a user", func() {
+		ctx := context.Background()
+		user, err := aggregate.NewUser("jane@example.com", "Jane")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(repo.Save(ctx, user)).To(Succeed())
+
+		found, err := repo.FindByID(ctx, user.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.Email).To(Equal(user.Email))
+	})
+
+	It("rejects saving a stale copy", func() {
+		ctx := context.Background()
+		first, second, err := saveConcurrently(ctx, repo)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first).To(Succeed())
+		Expect(errors.Is(second, repository.ErrVersionConflict)).To(BeTrue(), "second save error = %v", second)
+	})
+})
+{{- else }}
+
+func newTestRepository(t *testing.T) *DynamoDBUserRepository {
+	t.Helper()
+
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT not set")
+	}
+
+	client, err := newTestClient(context.Background(), endpoint)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("newTestClient() error = %v", err)
+	}
+{{- else }}
+	require.NoError(t, err)
+{{- end }}
+
+	return NewDynamoDBUserRepository(client, testTableName())
+}
+
+func TestDynamoDBUserRepository_SaveAndFindByID(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+{{- if eq .TestingFramework "standard" }}
+
+	user, err := aggregate.NewUser("jane@example.com", "Jane")
+	if err != nil {
+		t.Fatalf("NewUser() error = %v", err)
+	}
+
+	if err := repo.Save(ctx, user); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	found, err := repo.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Email != user.Email {
+		t.Errorf("Email = %q, want %q", found.Email, user.Email)
+	}
+{{- else }}
+
+	user, err := aggregate.NewUser("jane@example.com", "Jane")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Save(ctx, user))
+
+	found, err := repo.FindByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.Email, found.Email)
+{{- end }}
+}
+
+func TestDynamoDBUserRepository_SaveStaleCopy(t *testing.T) {
+	repo := newTestRepository(t)
+
+	first, second, err := saveConcurrently(context.Background(), repo)
+{{- if eq .TestingFramework "standard" }}
+	if err != nil {
+		t.Fatalf("setup error = %v", err)
+	}
+	if first != nil {
+		t.Fatalf("first Save() error = %v", first)
+	}
+	if !errors.Is(second, repository.ErrVersionConflict) {
+		t.Errorf("second Save() error = %v, want %v", second, repository.ErrVersionConflict)
+	}
+{{- else }}
+	require.NoError(t, err)
+	require.NoError(t, first)
+	assert.ErrorIs(t, second, repository.ErrVersionConflict)
+{{- end }}
+}
+{{- end }}
+`
+
+const DDDOutboxRelayTest = `package outbox
+
+import (
+	"context"
+	"errors"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+)
+
+var errTargetUnavailable = errors.New("target unavailable")
+
+// stubPublisher keeps published messages and fails while err is set
+type stubPublisher struct {
+	published []Message
+	calls     int
+	err       error
+}
+
+func (p *stubPublisher) Publish(ctx context.Context, msg Message) error {
+	p.calls++
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, msg)
+	return nil
+}
+
+// outboxRecord builds the stream record of an inserted outbox item
+func outboxRecord(sequence, eventID string) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventName: string(events.DynamoDBOperationTypeInsert),
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber: sequence,
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"type":         events.NewStringAttribute(ItemType),
+				"event_id":     events.NewStringAttribute(eventID),
+				"event_type":   events.NewStringAttribute("user.created"),
+				"aggregate_id": events.NewStringAttribute("user-1"),
+				"occurred_at":  events.NewStringAttribute(time.Now().UTC().Format(time.RFC3339Nano)),
+				"payload":      events.NewStringAttribute(` + "`" + `{"user_id":"user-1"}` + "`" + `),
+			},
+		},
+	}
+}
+
+// userRecord builds the stream record of a change to a user item
+func userRecord(sequence, operation string) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventName: operation,
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber: sequence,
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"type": events.NewStringAttribute("User"),
+				"id":   events.NewStringAttribute("user-1"),
+			},
+		},
+	}
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Relay", func() {
+	var (
+		publisher *stubPublisher
+		relay     *Relay
+		ctx       context.Context
+	)
+
+	BeforeEach(func() {
+		publisher = &stubPublisher{}
+		relay = NewRelay(publisher)
+		ctx = context.Background()
+	})
+
+	It("publishes inserted outbox items in order", func() {
+		response, err := relay.HandleStream(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+			userRecord("1", "INSERT"),
+			outboxRecord("2", "event-1"),
+			userRecord("3", "MODIFY"),
+			outboxRecord("4", "event-2"),
+		}})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.BatchItemFailures).To(BeEmpty())
+		Expect(publisher.published).To(HaveLen(2))
+		Expect(publisher.published[0].EventID).To(Equal("event-1"))
+		Expect(publisher.published[1].EventID).To(Equal("event-2"))
+	})
+
+	It("stops at the first failure and reports it for retry", func() {
+		publisher.err = errTargetUnavailable
+
+		response, err := relay.HandleStream(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+			outboxRecord("1", "event-1"),
+			outboxRecord("2", "event-2"),
+		}})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.BatchItemFailures).To(HaveLen(1))
+		Expect(response.BatchItemFailures[0].ItemIdentifier).To(Equal("1"))
+		Expect(publisher.calls).To(Equal(1))
+	})
+})
+{{- else if eq .TestingFramework "standard" }}
+
+func TestRelay_PublishesOutboxItems(t *testing.T) {
+	publisher := &stubPublisher{}
+	relay := NewRelay(publisher)
+
+	response, err := relay.HandleStream(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		userRecord("1", "INSERT"),
+		outboxRecord("2", "event-1"),
+		userRecord("3", "MODIFY"),
+		outboxRecord("4", "event-2"),
+	}})
+	if err != nil {
+		t.Fatalf("HandleStream() error = %v", err)
+	}
+
+	if len(response.BatchItemFailures) != 0 {
+		t.Errorf("BatchItemFailures = %v, want none", response.BatchItemFailures)
+	}
+	if len(publisher.published) != 2 {
+		t.Fatalf("published = %d messages, want 2", len(publisher.published))
+	}
+	if publisher.published[0].EventID != "event-1" || publisher.published[1].EventID != "event-2" {
+		t.Errorf("published %q, %q, want event-1, event-2", publisher.published[0].EventID, publisher.published[1].EventID)
+	}
+}
+
+func TestRelay_ReportsFirstFailure(t *testing.T) {
+	publisher := &stubPublisher{err: errTargetUnavailable}
+	relay := NewRelay(publisher)
+
+	response, err := relay.HandleStream(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		outboxRecord("1", "event-1"),
+		outboxRecord("2", "event-2"),
+	}})
+	if err != nil {
+		t.Fatalf("HandleStream() error = %v", err)
+	}
+
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "1" {
+		t.Errorf("BatchItemFailures = %v, want record 1", response.BatchItemFailures)
+	}
+	if publisher.calls != 1 {
+		t.Errorf("Publish calls = %d, want 1", publisher.calls)
+	}
+}
+{{- else }}
+
+func TestRelay_PublishesOutboxItems(t *testing.T) {
+	publisher := &stubPublisher{}
+	relay := NewRelay(publisher)
+
+	response, err := relay.HandleStream(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		userRecord("1", "INSERT"),
+		outboxRecord("2", "event-1"),
+		userRecord("3", "MODIFY"),
+		outboxRecord("4", "event-2"),
+	}})
+
+	require.NoError(t, err)
+	assert.Empty(t, response.BatchItemFailures)
+	require.Len(t, publisher.published, 2)
+	assert.Equal(t, "event-1", publisher.published[0].EventID)
+	assert.Equal(t, "event-2", publisher.published[1].EventID)
+}
+
+func TestRelay_ReportsFirstFailure(t *testing.T) {
+	publisher := &stubPublisher{err: errTargetUnavailable}
+	relay := NewRelay(publisher)
+
+	response, err := relay.HandleStream(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		outboxRecord("1", "event-1"),
+		outboxRecord("2", "event-2"),
+	}})
+
+	require.NoError(t, err)
+	require.Len(t, response.BatchItemFailures, 1)
+	assert.Equal(t, "1", response.BatchItemFailures[0].ItemIdentifier)
+	assert.Equal(t, 1, publisher.calls)
+}
+{{- end }}
+`
+
+const DDDSQSHandlerTest = `package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+{{- if eq .TestingFramework "standard" }}
+	"reflect"
+{{- end }}
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/events"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+
+	"{{.Module}}/application/handler"
+)
+
+var sqsHandlerTestCases = []struct {
+	name         string
+	records      []events.SQSMessage
+	failFor      map[string]bool
+	wantCalls    int
+	wantFailures []string
+}{
+	{
+		name:      "dispatches messages by type",
+		records:   userCreatedBatch("message-1", "message-2"),
+		wantCalls: 2,
+	},
+	{
+		name:         "reports only the failed messages in a mixed batch",
+		records:      userCreatedBatch("message-1", "message-2", "message-3"),
+		failFor:      map[string]bool{"message-2": true},
+		wantCalls:    3,
+		wantFailures: []string{"message-2"},
+	},
+	{
+		name:         "reports every message when the whole batch fails",
+		records:      userCreatedBatch("message-1", "message-2"),
+		failFor:      map[string]bool{"message-1": true, "message-2": true},
+		wantCalls:    2,
+		wantFailures: []string{"message-1", "message-2"},
+	},
+	{
+		name: "drops malformed messages",
+		records: []events.SQSMessage{
+			{MessageId: "message-1", Body: "not json"},
+		},
+	},
+}
+
+// userCreatedBatch builds user.created records whose payload carries the
+// message ID, so the test handler can decide which ones fail.
+func userCreatedBatch(ids ...string) []events.SQSMessage {
+	records := make([]events.SQSMessage, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, events.SQSMessage{
+			MessageId: id,
+			Body:      fmt.Sprintf("{\"type\":\"user.created\",\"payload\":{\"message_id\":%q}}", id),
+		})
+	}
+	return records
+}
+
+func runSQSHandler(records []events.SQSMessage, failFor map[string]bool) (int, []string, error) {
+	calls := 0
+	messageHandler := handler.NewMessageHandler(nil)
+	messageHandler.RegisterHandler("user.created", func(ctx context.Context, payload json.RawMessage) error {
+		calls++
+		var body map[string]string
+		if err := json.Unmarshal(payload, &body); err != nil {
+			return err
+		}
+		if failFor[body["message_id"]] {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	})
+
+	response, err := NewSQSHandler(messageHandler).HandleRequest(context.Background(), events.SQSEvent{Records: records})
+
+	var failures []string
+	for _, failure := range response.BatchItemFailures {
+		failures = append(failures, failure.ItemIdentifier)
+	}
+	return calls, failures, err
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("SQSHandler", func() {
+	for _, tc := range sqsHandlerTestCases {
+		tc := tc
+
+		It(tc.name, func() {
+			calls, failures, err := runSQSHandler(tc.records, tc.failFor)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(tc.wantCalls))
+			Expect(failures).To(Equal(tc.wantFailures))
+		})
+	}
+})
+{{- else }}
+
+func TestSQSHandler_HandleRequest(t *testing.T) {
+	for _, tc := range sqsHandlerTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls, failures, err := runSQSHandler(tc.records, tc.failFor)
+{{- if eq .TestingFramework "standard" }}
+			if err != nil {
+				t.Fatalf("HandleRequest() error = %v", err)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tc.wantCalls)
+			}
+			if !reflect.DeepEqual(failures, tc.wantFailures) {
+				t.Errorf("BatchItemFailures = %v, want %v", failures, tc.wantFailures)
+			}
+{{- else }}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantFailures, failures)
+{{- end }}
+		})
+	}
+}
+{{- end }}
+`
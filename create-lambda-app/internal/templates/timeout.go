@@ -0,0 +1,116 @@
+package templates
+
+// Invocation timeout templates for the middleware package
+
+const Timeout = `package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"{{.Module}}/pkg/apievent"
+	apperrors "{{.Module}}/pkg/errors"
+	"{{.Module}}/pkg/metrics"
+	"{{.Module}}/pkg/problem"
+)
+
+// DefaultTimeoutMargin is how long before the invocation deadline
+// DefaultChain gives up on a handler: time enough to answer, and for the
+// outer middleware to log, end the span and flush metrics
+const DefaultTimeoutMargin = 500 * time.Millisecond
+
+// invocationResult is what a handler run by Timeout returned, or the
+// value it panicked with
+type invocationResult[Out any] struct {
+	out      Out
+	err      error
+	panicked bool
+	panic    interface{}
+}
+
+// Timeout gives the handler a context that is cancelled margin before the
+// Lambda invocation deadline. A handler still running then is abandoned:
+// API requests are answered 504 and other handlers return the timeout
+// error of pkg/errors, so the trigger retries the event. Either way the
+// invocation returns before Lambda kills it, so its logs, span and metrics
+// are flushed. A handler that fails after the deadline, usually because a
+// call it passed the context to was cancelled, is reported as timed out
+// too. Invocations without a deadline, such as those of cmd/local-api, are
+// not limited.
+//
+// Handlers should pass the context to every call that blocks, so the work
+// of an abandoned invocation stops.
+func Timeout[In, Out any](margin time.Duration) Middleware[In, Out] {
+	return func(next HandlerFunc[In, Out]) HandlerFunc[In, Out] {
+		return func(ctx context.Context, in In) (Out, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				return next(ctx, in)
+			}
+
+			ctx, cancel := context.WithDeadline(ctx, deadline.Add(-margin))
+			defer cancel()
+
+			done := make(chan invocationResult[Out], 1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						done <- invocationResult[Out]{panicked: true, panic: r}
+					}
+				}()
+
+				out, err := next(ctx, in)
+				done <- invocationResult[Out]{out: out, err: err}
+			}()
+
+			select {
+			case result := <-done:
+				// Recovery runs outside this goroutine, so the panic is
+				// raised again where it can recover it
+				if result.panicked {
+					panic(result.panic)
+				}
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) && failed(result.out, result.err) {
+					return timedOut[Out](ctx, in, deadline)
+				}
+				return result.out, result.err
+			case <-ctx.Done():
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					var zero Out
+					return zero, ctx.Err()
+				}
+				return timedOut[Out](ctx, in, deadline)
+			}
+		}
+	}
+}
+
+// failed reports whether a handler returned an error or answered 5xx
+func failed(out any, err error) bool {
+	if err != nil {
+		return true
+	}
+	response, ok := out.(apievent.Response)
+	return ok && response.StatusCode >= http.StatusInternalServerError
+}
+
+// timedOut answers an invocation whose handler ran out of time
+func timedOut[Out any](ctx context.Context, in any, deadline time.Time) (Out, error) {
+	contextLogger(ctx).Error().
+		Time("deadline", deadline).
+		Dur("remaining_ms", time.Until(deadline)).
+		Msg("Handler timed out")
+	metrics.Add(ctx, "Timeouts", metrics.Count, 1)
+
+	var out Out
+	err := apperrors.NewTimeoutError("invocation")
+	if response, ok := any(&out).(*apievent.Response); ok {
+		request, _ := in.(apievent.Request)
+		*response = problem.Response(ctx, request, problem.FromError(err))
+		return out, nil
+	}
+	return out, err
+}
+`
@@ -0,0 +1,902 @@
+package templates
+
+// Tracing templates. pkg/tracing is generated for every architecture: it
+// sets up the OpenTelemetry tracer provider, records a span per invocation,
+// carries trace context through SQS message attributes and HTTP headers, and
+// instruments AWS SDK v2 clients.
+
+const Tracing = `// Package tracing records OpenTelemetry spans for Lambda invocations, for
+// the messages and requests they send on, and for the AWS calls they make.
+//
+// Init selects where spans go: "xray" sends them to the ADOT collector
+// extension, which forwards them to AWS X-Ray; "otlp" sends them to any OTLP
+// endpoint; "stdout" prints them; "memory" keeps them for tests; and "none"
+// records nothing but still passes incoming trace context on.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters Init accepts
+const (
+	ExporterXRay   = "xray"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterMemory = "memory"
+	ExporterNone   = "none"
+)
+
+const instrumentationName = "{{.Module}}/pkg/tracing"
+
+// Options configures Init
+type Options struct {
+	// ServiceName is reported as service.name
+	ServiceName string
+
+	// Exporter is one of the Exporter constants
+	Exporter string
+
+	// Endpoint is the host:port of the OTLP/HTTP collector the xray and otlp
+	// exporters send to. On Lambda that is the ADOT extension on
+	// localhost:4318.
+	Endpoint string
+}
+
+var (
+	provider *sdktrace.TracerProvider
+	memory   *tracetest.InMemoryExporter
+
+	// lambdaParent makes the segment Lambda starts for an invocation the
+	// parent of invocations whose event carries no trace context. Only X-Ray
+	// understands that segment.
+	lambdaParent bool
+)
+
+// Init installs the global tracer provider and propagators. Call it once
+// at startup; the returned function flushes and stops the provider.
+func Init(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	propagators := []propagation.TextMapPropagator{propagation.TraceContext{}, propagation.Baggage{}}
+	providerOptions := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(newResource(opts.ServiceName)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	}
+
+	switch opts.Exporter {
+	case ExporterXRay, ExporterOTLP:
+		exporter, err := otlptracehttp.New(ctx,
+			otlptracehttp.WithEndpoint(opts.Endpoint),
+			otlptracehttp.WithInsecure(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		providerOptions = append(providerOptions, sdktrace.WithBatcher(exporter))
+		if opts.Exporter == ExporterXRay {
+			providerOptions = append(providerOptions, sdktrace.WithIDGenerator(xray.NewIDGenerator()))
+			propagators = append(propagators, xray.Propagator{})
+		}
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		providerOptions = append(providerOptions, sdktrace.WithSyncer(exporter))
+	case ExporterMemory:
+		memory = tracetest.NewInMemoryExporter()
+		providerOptions = append(providerOptions, sdktrace.WithSyncer(memory))
+	case ExporterNone, "":
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagators...))
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", opts.Exporter)
+	}
+
+	provider = sdktrace.NewTracerProvider(providerOptions...)
+	lambdaParent = opts.Exporter == ExporterXRay
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagators...))
+
+	return provider.Shutdown, nil
+}
+
+// newResource describes the function the spans come from
+func newResource(serviceName string) *resource.Resource {
+	return resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.CloudProviderAWS,
+		semconv.CloudPlatformAWSLambda,
+		semconv.CloudRegion(os.Getenv("AWS_REGION")),
+		semconv.FaaSName(os.Getenv("AWS_LAMBDA_FUNCTION_NAME")),
+		semconv.FaaSVersion(os.Getenv("AWS_LAMBDA_FUNCTION_VERSION")),
+	)
+}
+
+// Tracer returns the tracer application code starts its own spans with
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Flush exports the spans recorded so far. Lambda freezes the process
+// between invocations, so Wrap flushes before each invocation returns.
+func Flush(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.ForceFlush(ctx); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to export spans")
+	}
+}
+
+// Spans returns the spans the memory exporter recorded
+func Spans() tracetest.SpanStubs {
+	if memory == nil {
+		return nil
+	}
+	return memory.GetSpans()
+}
+
+// ResetSpans discards the spans the memory exporter recorded
+func ResetSpans() {
+	if memory != nil {
+		memory.Reset()
+	}
+}
+`
+
+const TracingInvocation = `package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"{{.Module}}/pkg/apievent"
+)
+
+// lambdaTraceHeaderKey is the context key aws-lambda-go stores the
+// invocation's X-Amzn-Trace-Id under
+const lambdaTraceHeaderKey = "x-amzn-trace-id"
+
+var warm atomic.Bool
+
+// Wrap runs handler in a span per invocation. The span continues the trace
+// the event carries: the trace headers of an API request or, with the xray
+// exporter, the segment Lambda started. A span for an SQS batch links to
+// the trace each message was sent in; StartSQSMessage continues it per
+// message.
+func Wrap[In, Out any](handler func(ctx context.Context, in In) (Out, error)) func(ctx context.Context, in In) (Out, error) {
+	return func(ctx context.Context, in In) (out Out, err error) {
+		ctx, span := StartInvocation(ctx, in)
+		defer func() {
+			EndInvocation(span, out, err)
+			Flush(ctx)
+		}()
+
+		return handler(ctx, in)
+	}
+}
+
+// StartInvocation starts the span of an invocation with event. Wrap calls
+// it; use it directly for handlers Wrap cannot wrap.
+func StartInvocation(ctx context.Context, event any) (context.Context, trace.Span) {
+	name, kind, attributes, links := describe(event)
+	attributes = append(attributes, semconv.FaaSColdstart(!warm.Swap(true)))
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		attributes = append(attributes, semconv.FaaSInvocationID(lc.AwsRequestID))
+	}
+
+	return Tracer().Start(parentContext(ctx, event), name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(attributes...),
+		trace.WithLinks(links...),
+	)
+}
+
+// EndInvocation records the outcome of an invocation on its span and ends
+// it. API responses with a 5xx status and SQS batches with failed messages
+// mark the span as failed.
+func EndInvocation(span trace.Span, out any, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	switch response := out.(type) {
+	case apievent.Response:
+		span.SetAttributes(semconv.HTTPStatusCode(response.StatusCode))
+		if err == nil && response.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+		}
+	case events.SQSEventResponse:
+		if failures := len(response.BatchItemFailures); failures > 0 {
+			span.SetAttributes(attribute.Int("messaging.batch.failure_count", failures))
+			if err == nil {
+				span.SetStatus(codes.Error, fmt.Sprintf("%d messages failed", failures))
+			}
+		}
+	}
+
+	span.End()
+}
+
+// StartSQSMessage starts the span of processing one message of a batch.
+// It continues the trace the message was sent in, so the producer and the
+// consumer show up in one trace, and links to the invocation span.
+func StartSQSMessage(ctx context.Context, message events.SQSMessage) (context.Context, trace.Span) {
+	queue := queueName(message.EventSourceARN)
+	options := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("aws_sqs"),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingDestinationName(queue),
+			semconv.MessagingMessageID(message.MessageId),
+		),
+	}
+
+	parent := ctx
+	if producer := ExtractSQS(context.Background(), message); trace.SpanContextFromContext(producer).IsValid() {
+		options = append(options, trace.WithLinks(trace.LinkFromContext(ctx)))
+		parent = trace.ContextWithRemoteSpanContext(ctx, trace.SpanContextFromContext(producer))
+	}
+
+	return Tracer().Start(parent, queue+" process", options...)
+}
+
+// ProcessSQSMessage runs process for one message of a batch in the span
+// StartSQSMessage starts and records the error it returns
+func ProcessSQSMessage(ctx context.Context, message events.SQSMessage, process func(ctx context.Context) error) error {
+	ctx, span := StartSQSMessage(ctx, message)
+	defer span.End()
+
+	if err := process(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// parentContext returns ctx with the trace context the event carries
+func parentContext(ctx context.Context, event any) context.Context {
+	if request, ok := event.(apievent.Request); ok {
+		if parent := ExtractHTTP(ctx, request.Headers); trace.SpanContextFromContext(parent).IsValid() {
+			return parent
+		}
+	}
+
+	if lambdaParent {
+		if header, ok := ctx.Value(lambdaTraceHeaderKey).(string); ok && header != "" {
+			return xray.Propagator{}.Extract(ctx, propagation.MapCarrier{"X-Amzn-Trace-Id": header})
+		}
+	}
+	return ctx
+}
+
+// describe names the span of an invocation with event and lists its
+// attributes and links
+func describe(event any) (string, trace.SpanKind, []attribute.KeyValue, []trace.Link) {
+	switch event := event.(type) {
+	case apievent.Request:
+		method := apievent.Method(event)
+		return method, trace.SpanKindServer, []attribute.KeyValue{
+			semconv.FaaSTriggerHTTP,
+			semconv.HTTPMethod(method),
+			semconv.URLPath(apievent.Path(event)),
+		}, nil
+	case events.SQSEvent:
+		queue := "sqs"
+		var links []trace.Link
+		for _, record := range event.Records {
+			queue = queueName(record.EventSourceARN)
+			if producer := trace.SpanContextFromContext(ExtractSQS(context.Background(), record)); producer.IsValid() {
+				links = append(links, trace.Link{SpanContext: producer})
+			}
+		}
+		return queue + " process", trace.SpanKindConsumer, []attribute.KeyValue{
+			semconv.FaaSTriggerPubsub,
+			semconv.MessagingSystemKey.String("aws_sqs"),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingDestinationName(queue),
+			semconv.MessagingBatchMessageCount(len(event.Records)),
+		}, links
+	case events.SNSEvent:
+		topic := "sns"
+		if len(event.Records) > 0 {
+			topic = resourceName(event.Records[0].SNS.TopicArn)
+		}
+		return topic + " process", trace.SpanKindConsumer, []attribute.KeyValue{
+			semconv.FaaSTriggerPubsub,
+			semconv.MessagingSystemKey.String("aws_sns"),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingDestinationName(topic),
+		}, nil
+	case events.CloudWatchEvent:
+		if event.DetailType == "Scheduled Event" {
+			return "schedule", trace.SpanKindInternal, []attribute.KeyValue{semconv.FaaSTriggerTimer}, nil
+		}
+		return event.DetailType + " process", trace.SpanKindConsumer, []attribute.KeyValue{
+			semconv.FaaSTriggerPubsub,
+			semconv.MessagingSystemKey.String("aws_eventbridge"),
+			semconv.MessagingOperationProcess,
+			attribute.String("aws.eventbridge.source", event.Source),
+		}, nil
+	case events.DynamoDBEvent:
+		table := "dynamodb"
+		if len(event.Records) > 0 {
+			table = tableName(event.Records[0].EventSourceArn)
+		}
+		return table + " stream process", trace.SpanKindConsumer, []attribute.KeyValue{
+			semconv.FaaSTriggerDatasource,
+			semconv.FaaSDocumentCollection(table),
+		}, nil
+	case events.S3Event:
+		bucket := "s3"
+		if len(event.Records) > 0 {
+			bucket = event.Records[0].S3.Bucket.Name
+		}
+		return bucket + " process", trace.SpanKindConsumer, []attribute.KeyValue{
+			semconv.FaaSTriggerDatasource,
+			semconv.FaaSDocumentCollection(bucket),
+		}, nil
+	default:
+		name := lambdacontext.FunctionName
+		if name == "" {
+			name = "invocation"
+		}
+		return name, trace.SpanKindServer, []attribute.KeyValue{semconv.FaaSTriggerOther}, nil
+	}
+}
+
+// queueName returns the queue name of an SQS queue ARN
+func queueName(arn string) string {
+	if arn == "" {
+		return "sqs"
+	}
+	return resourceName(arn)
+}
+
+// resourceName returns the last ":" separated part of an ARN
+func resourceName(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
+
+// tableName returns the table name of a DynamoDB stream ARN
+// (arn:aws:dynamodb:region:account:table/name/stream/label)
+func tableName(arn string) string {
+	parts := strings.Split(resourceName(arn), "/")
+	if len(parts) < 2 {
+		return "dynamodb"
+	}
+	return parts[1]
+}
+`
+
+const TracingPropagation = `package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// awsTraceHeaderAttribute is the system attribute SQS sets on messages sent
+// by a service X-Ray traces
+const awsTraceHeaderAttribute = "AWSTraceHeader"
+
+// InjectHTTP adds the trace context of ctx to the headers of an outgoing
+// request
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHTTP returns ctx with the trace context of the headers of an
+// incoming request. Header names are matched case-insensitively.
+func ExtractHTTP(ctx context.Context, headers map[string]string) context.Context {
+	header := make(http.Header, len(headers))
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectSQS adds the trace context of ctx to the attributes of an outgoing
+// SQS message and returns them, allocating the map when it is nil. The
+// propagators use up to three of the ten attributes SQS allows.
+func InjectSQS(ctx context.Context, attributes map[string]sqstypes.MessageAttributeValue) map[string]sqstypes.MessageAttributeValue {
+	if attributes == nil {
+		attributes = make(map[string]sqstypes.MessageAttributeValue)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, sqsAttributeCarrier(attributes))
+	return attributes
+}
+
+// ExtractSQS returns ctx with the trace context an SQS message was sent
+// with: the attributes InjectSQS wrote or, failing that, the AWSTraceHeader
+// SQS adds to messages from services X-Ray traces
+func ExtractSQS(ctx context.Context, message events.SQSMessage) context.Context {
+	carrier := sqsMessageCarrier(message.MessageAttributes)
+	if extracted := otel.GetTextMapPropagator().Extract(ctx, carrier); trace.SpanContextFromContext(extracted).IsRemote() {
+		return extracted
+	}
+
+	if header := message.Attributes[awsTraceHeaderAttribute]; header != "" {
+		return xray.Propagator{}.Extract(ctx, propagation.MapCarrier{"X-Amzn-Trace-Id": header})
+	}
+	return ctx
+}
+
+// sqsAttributeCarrier writes trace context to outgoing message attributes
+type sqsAttributeCarrier map[string]sqstypes.MessageAttributeValue
+
+func (c sqsAttributeCarrier) Get(key string) string {
+	if value, ok := c[key]; ok && value.StringValue != nil {
+		return *value.StringValue
+	}
+	return ""
+}
+
+func (c sqsAttributeCarrier) Set(key, value string) {
+	c[key] = sqstypes.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+func (c sqsAttributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// sqsMessageCarrier reads trace context from received message attributes
+type sqsMessageCarrier map[string]events.SQSMessageAttribute
+
+func (c sqsMessageCarrier) Get(key string) string {
+	if value, ok := c[key]; ok && value.StringValue != nil {
+		return *value.StringValue
+	}
+	return ""
+}
+
+func (c sqsMessageCarrier) Set(key, value string) {
+	c[key] = events.SQSMessageAttribute{
+		DataType:    "String",
+		StringValue: aws.String(value),
+	}
+}
+
+func (c sqsMessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+`
+
+const TracingAWS = `package tracing
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentAWS makes the clients created from cfg record a span per AWS
+// call, named after the service and operation (DynamoDB.PutItem), and send
+// the trace context with the request. With the xray exporter that makes
+// SQS stamp sent messages with the AWSTraceHeader ExtractSQS reads.
+func InstrumentAWS(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		// After, so the service and operation names are in the context
+		if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TracingSpan", startAWSSpan), middleware.After); err != nil {
+			return err
+		}
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("TracingPropagation", injectAWSHeaders), middleware.After)
+	})
+}
+
+func startAWSSpan(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	service := awsmiddleware.GetServiceID(ctx)
+	operation := awsmiddleware.GetOperationName(ctx)
+
+	ctx, span := Tracer().Start(ctx, service+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("aws-api"),
+			semconv.RPCService(service),
+			semconv.RPCMethod(operation),
+			semconv.CloudRegion(awsmiddleware.GetRegion(ctx)),
+		),
+	)
+	defer span.End()
+
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+		span.SetAttributes(semconv.AWSRequestID(requestID))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return out, metadata, err
+}
+
+func injectAWSHeaders(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+	if request, ok := in.Request.(*smithyhttp.Request); ok {
+		InjectHTTP(ctx, request.Header)
+	}
+	return next.HandleBuild(ctx, in)
+}
+`
+
+const TracingTest = `package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+{{- if eq .TestingFramework "standard" }}
+	"strings"
+{{- end }}
+	"sync"
+{{- if ne .TestingFramework "ginkgo" }}
+	"testing"
+{{- end }}
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+{{- if eq .TestingFramework "testify" }}
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+{{- else if eq .TestingFramework "ginkgo" }}
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+{{- end }}
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"{{.Module}}/pkg/apievent"
+)
+
+const (
+	remoteTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	remoteSpanID  = "00f067aa0ba902b7"
+
+	// xrayTraceHeader carries the same context in X-Ray's format
+	xrayTraceHeader = "Root=1-4bf92f35-77b34da6a3ce929d0e0e4736;Parent=00f067aa0ba902b7;Sampled=1"
+)
+
+var initOnce sync.Once
+
+// recordSpans sends spans to the memory exporter and clears it
+func recordSpans() {
+	initOnce.Do(func() {
+		if _, err := Init(context.Background(), Options{ServiceName: "test", Exporter: ExporterMemory}); err != nil {
+			panic(err)
+		}
+	})
+	ResetSpans()
+}
+
+// invokeAPI runs a wrapped API handler for a request that carries trace
+// context and returns the recorded spans
+func invokeAPI(statusCode int, handlerErr error) tracetest.SpanStubs {
+	recordSpans()
+	handler := Wrap(func(ctx context.Context, request apievent.Request) (apievent.Response, error) {
+		return apievent.NewResponse(statusCode, nil, ""), handlerErr
+	})
+
+	request := apievent.NewRequest(http.MethodPost, "/users", "")
+	request.Headers["traceparent"] = "00-" + remoteTraceID + "-" + remoteSpanID + "-01"
+	_, _ = handler(context.Background(), request)
+	return Spans()
+}
+
+// sendAndReceive injects the context of a producer span into SQS message
+// attributes and processes the received message. It returns the producer's
+// span context and the recorded spans.
+func sendAndReceive() (trace.SpanContext, tracetest.SpanStubs) {
+	recordSpans()
+	ctx, producer := Tracer().Start(context.Background(), "send")
+	attributes := InjectSQS(ctx, nil)
+	producer.End()
+
+	message := events.SQSMessage{
+		MessageId:         "msg-1",
+		EventSourceARN:    "arn:aws:sqs:us-east-1:123456789012:orders",
+		MessageAttributes: make(map[string]events.SQSMessageAttribute),
+	}
+	for key, value := range attributes {
+		message.MessageAttributes[key] = events.SQSMessageAttribute{DataType: *value.DataType, StringValue: value.StringValue}
+	}
+
+	_, consumer := StartSQSMessage(context.Background(), message)
+	consumer.End()
+	return producer.SpanContext(), Spans()
+}
+
+// extractAWSTraceHeader reads the trace context SQS adds for X-Ray
+func extractAWSTraceHeader() trace.SpanContext {
+	message := events.SQSMessage{Attributes: map[string]string{"AWSTraceHeader": xrayTraceHeader}}
+	return trace.SpanContextFromContext(ExtractSQS(context.Background(), message))
+}
+
+// httpClientFunc is an AWS HTTP client backed by a function
+type httpClientFunc func(*http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// callSQS sends a message with an instrumented client whose connection
+// fails. It returns the recorded spans and the traceparent header the
+// request carried.
+func callSQS() (tracetest.SpanStubs, string) {
+	recordSpans()
+	var traceparent string
+	cfg := aws.Config{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+		HTTPClient: httpClientFunc(func(r *http.Request) (*http.Response, error) {
+			traceparent = r.Header.Get("traceparent")
+			return nil, errors.New("connection refused")
+		}),
+	}
+	InstrumentAWS(&cfg)
+
+	_, _ = sqs.NewFromConfig(cfg).SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl:    aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/orders"),
+		MessageBody: aws.String("{}"),
+	})
+	return Spans(), traceparent
+}
+
+// attributeValue returns the value of an attribute of a span
+func attributeValue(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+{{- if eq .TestingFramework "ginkgo" }}
+
+var _ = Describe("Wrap", func() {
+	It("continues the trace of an API request", func() {
+		spans := invokeAPI(http.StatusOK, nil)
+
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal(http.MethodPost))
+		Expect(spans[0].SpanKind).To(Equal(trace.SpanKindServer))
+		Expect(spans[0].Parent.TraceID().String()).To(Equal(remoteTraceID))
+		Expect(spans[0].Parent.SpanID().String()).To(Equal(remoteSpanID))
+		Expect(attributeValue(spans[0], "http.status_code").AsInt64()).To(Equal(int64(http.StatusOK)))
+		Expect(spans[0].Status.Code).To(Equal(codes.Unset))
+	})
+
+	It("marks 5xx responses as errors", func() {
+		spans := invokeAPI(http.StatusBadGateway, nil)
+
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status.Code).To(Equal(codes.Error))
+	})
+
+	It("records handler errors", func() {
+		spans := invokeAPI(http.StatusOK, errors.New("boom"))
+
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status.Code).To(Equal(codes.Error))
+		Expect(spans[0].Status.Description).To(Equal("boom"))
+		Expect(spans[0].Events).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("SQS propagation", func() {
+	It("continues the producer's trace in the consumer", func() {
+		producer, spans := sendAndReceive()
+
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[1].Name).To(Equal("orders process"))
+		Expect(spans[1].Parent.TraceID()).To(Equal(producer.TraceID()))
+		Expect(spans[1].Parent.SpanID()).To(Equal(producer.SpanID()))
+	})
+
+	It("falls back to the AWSTraceHeader attribute", func() {
+		spanContext := extractAWSTraceHeader()
+
+		Expect(spanContext.TraceID().String()).To(Equal(remoteTraceID))
+		Expect(spanContext.SpanID().String()).To(Equal(remoteSpanID))
+	})
+})
+
+var _ = Describe("InstrumentAWS", func() {
+	It("records a span per call and propagates its context", func() {
+		spans, traceparent := callSQS()
+
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("SQS.SendMessage"))
+		Expect(spans[0].SpanKind).To(Equal(trace.SpanKindClient))
+		Expect(spans[0].Status.Code).To(Equal(codes.Error))
+		Expect(traceparent).To(ContainSubstring(spans[0].SpanContext.TraceID().String()))
+	})
+})
+{{- else }}
+
+func TestWrapContinuesAPITrace(t *testing.T) {
+	spans := invokeAPI(http.StatusOK, nil)
+{{- if eq .TestingFramework "standard" }}
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != http.MethodPost {
+		t.Errorf("Name = %q, want %q", span.Name, http.MethodPost)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("SpanKind = %v, want %v", span.SpanKind, trace.SpanKindServer)
+	}
+	if got := span.Parent.TraceID().String(); got != remoteTraceID {
+		t.Errorf("parent trace ID = %s, want %s", got, remoteTraceID)
+	}
+	if got := span.Parent.SpanID().String(); got != remoteSpanID {
+		t.Errorf("parent span ID = %s, want %s", got, remoteSpanID)
+	}
+	if got := attributeValue(span, "http.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("http.status_code = %d, want %d", got, http.StatusOK)
+	}
+	if span.Status.Code != codes.Unset {
+		t.Errorf("Status = %v, want %v", span.Status.Code, codes.Unset)
+	}
+{{- else }}
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, http.MethodPost, span.Name)
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+	assert.Equal(t, remoteTraceID, span.Parent.TraceID().String())
+	assert.Equal(t, remoteSpanID, span.Parent.SpanID().String())
+	assert.Equal(t, int64(http.StatusOK), attributeValue(span, "http.status_code").AsInt64())
+	assert.Equal(t, codes.Unset, span.Status.Code)
+{{- end }}
+}
+
+func TestWrapMarksFailures(t *testing.T) {
+	serverError := invokeAPI(http.StatusBadGateway, nil)
+	handlerError := invokeAPI(http.StatusOK, errors.New("boom"))
+{{- if eq .TestingFramework "standard" }}
+	if len(serverError) != 1 || serverError[0].Status.Code != codes.Error {
+		t.Errorf("5xx response spans = %+v, want one failed span", serverError)
+	}
+	if len(handlerError) != 1 {
+		t.Fatalf("recorded %d spans for a handler error, want 1", len(handlerError))
+	}
+	if got := handlerError[0].Status; got.Code != codes.Error || got.Description != "boom" {
+		t.Errorf("Status = %+v, want error %q", got, "boom")
+	}
+	if len(handlerError[0].Events) != 1 {
+		t.Errorf("recorded %d events, want the error", len(handlerError[0].Events))
+	}
+{{- else }}
+	require.Len(t, serverError, 1)
+	assert.Equal(t, codes.Error, serverError[0].Status.Code)
+	require.Len(t, handlerError, 1)
+	assert.Equal(t, codes.Error, handlerError[0].Status.Code)
+	assert.Equal(t, "boom", handlerError[0].Status.Description)
+	assert.Len(t, handlerError[0].Events, 1)
+{{- end }}
+}
+
+func TestSQSPropagation(t *testing.T) {
+	producer, spans := sendAndReceive()
+{{- if eq .TestingFramework "standard" }}
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	consumer := spans[1]
+	if consumer.Name != "orders process" {
+		t.Errorf("Name = %q, want %q", consumer.Name, "orders process")
+	}
+	if consumer.Parent.TraceID() != producer.TraceID() || consumer.Parent.SpanID() != producer.SpanID() {
+		t.Errorf("consumer parent = %s/%s, want %s/%s", consumer.Parent.TraceID(), consumer.Parent.SpanID(), producer.TraceID(), producer.SpanID())
+	}
+{{- else }}
+	require.Len(t, spans, 2)
+	consumer := spans[1]
+	assert.Equal(t, "orders process", consumer.Name)
+	assert.Equal(t, producer.TraceID(), consumer.Parent.TraceID())
+	assert.Equal(t, producer.SpanID(), consumer.Parent.SpanID())
+{{- end }}
+}
+
+func TestExtractSQSAWSTraceHeader(t *testing.T) {
+	spanContext := extractAWSTraceHeader()
+{{- if eq .TestingFramework "standard" }}
+	if got := spanContext.TraceID().String(); got != remoteTraceID {
+		t.Errorf("trace ID = %s, want %s", got, remoteTraceID)
+	}
+	if got := spanContext.SpanID().String(); got != remoteSpanID {
+		t.Errorf("span ID = %s, want %s", got, remoteSpanID)
+	}
+{{- else }}
+	assert.Equal(t, remoteTraceID, spanContext.TraceID().String())
+	assert.Equal(t, remoteSpanID, spanContext.SpanID().String())
+{{- end }}
+}
+
+func TestInstrumentAWS(t *testing.T) {
+	spans, traceparent := callSQS()
+{{- if eq .TestingFramework "standard" }}
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "SQS.SendMessage" {
+		t.Errorf("Name = %q, want %q", span.Name, "SQS.SendMessage")
+	}
+	if span.SpanKind != trace.SpanKindClient {
+		t.Errorf("SpanKind = %v, want %v", span.SpanKind, trace.SpanKindClient)
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("Status = %v, want %v", span.Status.Code, codes.Error)
+	}
+	if !strings.Contains(traceparent, span.SpanContext.TraceID().String()) {
+		t.Errorf("traceparent = %q, want trace ID %s", traceparent, span.SpanContext.TraceID())
+	}
+{{- else }}
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "SQS.SendMessage", span.Name)
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Contains(t, traceparent, span.SpanContext.TraceID().String())
+{{- end }}
+}
+{{- end }}
+`
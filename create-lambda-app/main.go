@@ -37,6 +37,7 @@ func main() {
 			"  • SAM/CDK deployment configurations\n" +
 			"  • Handler generators for common patterns",
 		Version: version,
+		Args:    cobra.MaximumNArgs(1),
 		RunE:    run,
 	}
 
@@ -46,6 +47,44 @@ func main() {
 	rootCmd.Flags().BoolP("skip-install", "", false, "Skip dependency installation")
 	rootCmd.Flags().StringP("deployment", "", "", "Deployment tool (sam/cdk/serverless)")
 	rootCmd.Flags().StringSliceP("features", "f", []string{}, "Features to include (api,dynamodb,sqs,sns,s3,cognito)")
+	rootCmd.Flags().StringP("api-type", "", "rest", "API event source (rest/http/function-url/alb)")
+	rootCmd.Flags().StringP("router", "", "", "API router (gin/chi/stdlib/none), defaults to none for clean and gin otherwise")
+	rootCmd.Flags().StringP("access-patterns", "", "", "DynamoDB single-table design (YAML) to generate the table code and IaC from")
+
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate code into an existing project",
+	}
+	generateAPICmd := &cobra.Command{
+		Use:   "api",
+		Short: "Generate types, a router and handler stubs from an OpenAPI spec",
+		Long: "Generate request/response types, a router and one handler stub per operation from an\n" +
+			"OpenAPI 3 spec, in the project's architecture style, and update the API routes of its\n" +
+			"IaC template. The spec is copied to docs/openapi.yaml, which requests are validated against.\n\n" +
+			"Run it again after changing the spec: the types and router are regenerated and stubs are\n" +
+			"added for new operations, while handlers that already exist are left untouched.",
+		Args: cobra.NoArgs,
+		RunE: runGenerateAPI,
+	}
+	generateAPICmd.Flags().StringP("spec", "s", "", "OpenAPI spec to generate from (default docs/openapi.yaml)")
+	generateAPICmd.Flags().StringP("dir", "C", ".", "Project directory")
+	generateAPICmd.Flags().StringP("out", "o", "", "Package directory, relative to the project (default depends on the architecture)")
+	generateCmd.AddCommand(generateAPICmd)
+	generateClientCmd := &cobra.Command{
+		Use:   "client",
+		Short: "Generate typed Go and TypeScript clients from the project's OpenAPI spec",
+		Long: "Generate a Go client package (pkg/client) and a TypeScript client (clients/typescript)\n" +
+			"from the project's OpenAPI spec. Both send bearer tokens or custom auth headers, retry\n" +
+			"throttled and unavailable requests, and return the API's error types as typed errors.\n\n" +
+			"Run it again after changing the spec: the clients are rewritten.",
+		Args: cobra.NoArgs,
+		RunE: runGenerateClient,
+	}
+	generateClientCmd.Flags().StringP("spec", "s", "", "OpenAPI spec to generate from (default docs/openapi.yaml)")
+	generateClientCmd.Flags().StringP("dir", "C", ".", "Project directory")
+	generateClientCmd.Flags().StringSliceP("lang", "l", []string{generator.ClientGo, generator.ClientTypeScript}, "Client languages (go,typescript)")
+	generateCmd.AddCommand(generateClientCmd)
+	rootCmd.AddCommand(generateCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(red("Error:"), err)
@@ -123,6 +162,80 @@ func run(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runGenerateAPI(cmd *cobra.Command, args []string) error {
+	opts := generator.APIGenOptions{}
+	opts.ProjectPath, _ = cmd.Flags().GetString("dir")
+	opts.SpecPath, _ = cmd.Flags().GetString("spec")
+	opts.OutputDir, _ = cmd.Flags().GetString("out")
+
+	result, err := generator.GenerateAPI(opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("%s %d operations from %s\n", bold("🔧 Generated"), len(result.Spec.Operations), generator.SpecFile)
+	for _, path := range result.Written {
+		fmt.Println(green("  ✓ ") + path)
+	}
+	for _, path := range result.Stubs {
+		fmt.Println(green("  + ") + path + cyan("  # new"))
+	}
+	if result.RoutesFile != "" {
+		fmt.Println(green("  ✓ ") + result.RoutesFile + cyan("  # API routes"))
+	}
+	for _, op := range result.Removed {
+		fmt.Printf(yellow("  ! ")+"%s is no longer in the spec; delete Handlers.%s from %s\n", op.Name, op.Name, op.File)
+	}
+	for _, note := range result.Notes {
+		fmt.Println(yellow("  ! ") + note)
+	}
+
+	pkg := filepath.Base(result.OutputDir)
+	fmt.Println()
+	fmt.Println(bold("Serve the operations") + " by handing the router to the API middleware chain:")
+	fmt.Printf("  router := %s.NewRouter(%s.NewHandlers())\n", pkg, pkg)
+	fmt.Println("  handler := chain(router.HandleRequest)")
+	fmt.Println()
+
+	return nil
+}
+
+func runGenerateClient(cmd *cobra.Command, args []string) error {
+	opts := generator.ClientGenOptions{}
+	opts.ProjectPath, _ = cmd.Flags().GetString("dir")
+	opts.SpecPath, _ = cmd.Flags().GetString("spec")
+	opts.Languages, _ = cmd.Flags().GetStringSlice("lang")
+
+	result, err := generator.GenerateClient(opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("%s for %d operations\n", bold("📦 Generated clients"), len(result.Spec.Operations))
+	for _, path := range result.Written {
+		fmt.Println(green("  ✓ ") + path)
+	}
+	for _, path := range result.Created {
+		fmt.Println(green("  + ") + path + cyan("  # new"))
+	}
+
+	fmt.Println()
+	fmt.Println(bold("Call the API:"))
+	for _, language := range opts.Languages {
+		switch language {
+		case generator.ClientGo:
+			fmt.Printf("  api := client.New(baseURL, client.WithBearerToken(token))  %s\n", cyan("// "+result.Config.Module+"/"+generator.GoClientDir))
+		case generator.ClientTypeScript:
+			fmt.Printf("  const api = new %s({ baseURL, token });  %s\n", result.Config.ClientName(), cyan("// "+generator.TypeScriptClientDir))
+		}
+	}
+	fmt.Println()
+
+	return nil
+}
+
 func getProjectConfig(cmd *cobra.Command, args []string) (*generator.Config, error) {
 	config := &generator.Config{
 		Features: make(map[string]bool),
@@ -215,6 +328,9 @@ func getProjectConfig(cmd *cobra.Command, args []string) (*generator.Config, err
 	}
 
 	// Additional options
+	config.APIType, _ = cmd.Flags().GetString("api-type")
+	config.Router, _ = cmd.Flags().GetString("router")
+	config.AccessPatternsFile, _ = cmd.Flags().GetString("access-patterns")
 	config.SkipGit, _ = cmd.Flags().GetBool("skip-git")
 	config.SkipInstall, _ = cmd.Flags().GetBool("skip-install")
 